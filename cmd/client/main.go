@@ -3,10 +3,10 @@ package main
 
 import (
 	"context"
-	"crypto/tls"
-	"crypto/x509"
+	"errors"
 	"flag"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -14,13 +14,27 @@ import (
 	"time"
 
 	"github.com/fsnotify/fsnotify"
+	"github.com/sahmadiut/half-tunnel/internal/admin"
+	"github.com/sahmadiut/half-tunnel/internal/circuitbreaker"
 	"github.com/sahmadiut/half-tunnel/internal/client"
 	"github.com/sahmadiut/half-tunnel/internal/config"
+	"github.com/sahmadiut/half-tunnel/internal/control"
+	"github.com/sahmadiut/half-tunnel/internal/geoip"
+	"github.com/sahmadiut/half-tunnel/internal/health"
 	"github.com/sahmadiut/half-tunnel/internal/metrics"
+	"github.com/sahmadiut/half-tunnel/internal/notify"
+	"github.com/sahmadiut/half-tunnel/internal/profiling"
 	"github.com/sahmadiut/half-tunnel/internal/retry"
+	"github.com/sahmadiut/half-tunnel/internal/routing"
+	"github.com/sahmadiut/half-tunnel/internal/service"
+	"github.com/sahmadiut/half-tunnel/internal/sockopt"
 	"github.com/sahmadiut/half-tunnel/pkg/logger"
 )
 
+// exitReconnectGaveUp is the exit status after giving up reconnecting
+// (EX_TEMPFAIL), so a service manager can restart the client.
+const exitReconnectGaveUp = 75
+
 var (
 	version   = "dev"
 	commit    = "none"
@@ -53,11 +67,19 @@ func main() {
 	}
 
 	// Initialize logger
-	log, err := logger.New(logger.Config{
-		Level:  cfg.Logging.Level,
-		Format: cfg.Logging.Format,
-		Output: cfg.Logging.Output,
-	})
+	logConfig, err := cfg.Logging.LoggerConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to initialize logger: %v\n", err)
+		os.Exit(1)
+	}
+	// A Windows service or launchd daemon has no console; log to the
+	// system log instead
+	output := serviceLogOutput()
+	if output != "" && (logConfig.Output == "" || logConfig.Output == logger.OutputStdout || logConfig.Output == logger.OutputStderr) {
+		logConfig.Output = output
+		logConfig.Tag = service.ServiceName(service.ClientService)
+	}
+	log, err := logger.New(logConfig)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to initialize logger: %v\n", err)
 		os.Exit(1)
@@ -73,6 +95,9 @@ func main() {
 	// Set up context for graceful shutdown
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
+	if isWindowsService() {
+		defer runWindowsService(cancel)()
+	}
 
 	// Handle shutdown and reload signals
 	sigCh := make(chan os.Signal, 1)
@@ -109,7 +134,7 @@ func main() {
 	}()
 
 	// Build SOCKS5 address from configuration
-	socks5Addr := fmt.Sprintf("%s:%d", cfg.SOCKS5.ListenHost, cfg.SOCKS5.ListenPort)
+	socks5Addr := cfg.SOCKS5.Addr()
 
 	// Parse port forwards from configuration
 	portForwards, err := cfg.GetPortForwards()
@@ -119,15 +144,12 @@ func main() {
 	}
 
 	// Convert config port forwards to client port forwards
-	clientPortForwards := make([]client.PortForward, len(portForwards))
-	for i, pf := range portForwards {
-		clientPortForwards[i] = client.PortForward{
-			Name:       pf.Name,
-			ListenHost: pf.ListenHost,
-			ListenPort: pf.ListenPort,
-			RemoteHost: pf.RemoteHost,
-			RemotePort: pf.RemotePort,
-		}
+	clientPortForwards := toClientPortForwards(portForwards)
+
+	router, err := toRouter(cfg.Routing)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to set up routing")
+		os.Exit(1)
 	}
 
 	readTimeout := time.Duration(0)
@@ -141,6 +163,10 @@ func main() {
 		DownstreamURL:    cfg.Client.Downstream.URL,
 		SOCKS5Addr:       socks5Addr,
 		SOCKS5Enabled:    cfg.SOCKS5.Enabled,
+		SOCKS5Family:     sockopt.Family(cfg.SOCKS5.IPFamily),
+		SOCKS5AllowFrom:  client.NewAllowList(cfg.SOCKS5.AllowFrom),
+		SOCKS5Listeners:  toClientSOCKS5Listeners(cfg.SOCKS5Listeners),
+		Router:           router,
 		PortForwards:     clientPortForwards,
 		ExitOnPortInUse:  cfg.Client.ExitOnPortInUse,
 		ListenOnConnect:  cfg.Client.ListenOnConnect,
@@ -151,37 +177,219 @@ func main() {
 			Multiplier:   cfg.Tunnel.Reconnect.Multiplier,
 			Jitter:       cfg.Tunnel.Reconnect.Jitter,
 		},
-		PingInterval:     cfg.Tunnel.Connection.KeepaliveInterval,
-		WriteTimeout:     cfg.Tunnel.Connection.DialTimeout,
-		ReadTimeout:      readTimeout,
-		DialTimeout:      cfg.Tunnel.Connection.DialTimeout,
-		HandshakeTimeout: cfg.Tunnel.Connection.DialTimeout,
-		ReadBufferSize:   cfg.Tunnel.Connection.ReadBufferSize,
-		WriteBufferSize:  cfg.Tunnel.Connection.WriteBufferSize,
+		PingInterval:        cfg.Tunnel.Connection.KeepaliveInterval,
+		RTTWarnThreshold:    cfg.Tunnel.Connection.RTTWarnThreshold,
+		CompactHeader:       cfg.Tunnel.Connection.CompactHeader,
+		MaxPayloadSize:      cfg.Tunnel.Connection.MaxPayloadSize,
+		Checksum:            cfg.Tunnel.Connection.Checksum,
+		ResetCorruptStreams: cfg.Tunnel.Connection.CorruptPacketPolicy == config.CorruptPacketReset,
+		SendQueueSize:       cfg.Tunnel.Connection.SendQueueSize,
+		MaxPadding:          cfg.Tunnel.Obfuscation.MaxPadding,
+		EchoProbe:           cfg.Observability.Health.EchoProbe,
+		WriteTimeout:        cfg.Tunnel.Connection.DialTimeout,
+		ReadTimeout:         readTimeout,
+		DialTimeout:         cfg.Tunnel.Connection.DialTimeout,
+		HandshakeTimeout:    cfg.Tunnel.Connection.DialTimeout,
+		StreamOpenTimeout:   cfg.Tunnel.Connection.StreamOpenTimeout,
+		ReadBufferSize:      cfg.Tunnel.Connection.ReadBufferSize,
+		WriteBufferSize:     cfg.Tunnel.Connection.WriteBufferSize,
+		GuestToken:          cfg.Client.GuestToken,
+		AuthToken:           cfg.Client.AuthToken,
+		PathSecret:          cfg.Client.PathToken.Secret,
+		PathWindow:          cfg.Client.PathToken.Window,
+		UpgradeSecret:       cfg.Client.UpgradeCookie.Secret,
+		UpgradeWindow:       cfg.Client.UpgradeCookie.Window,
+		UpgradeCookie:       cfg.Client.UpgradeCookie.Name,
 	}
 
-	// Set SOCKS5 authentication if enabled
-	if cfg.SOCKS5.Auth.Enabled {
-		clientConfig.SOCKS5Username = cfg.SOCKS5.Auth.Username
-		clientConfig.SOCKS5Password = cfg.SOCKS5.Auth.Password
+	clientConfig.TCP = cfg.Tunnel.Connection.TCP.SocketOptions()
+
+	if ct := cfg.Tunnel.Obfuscation.CoverTraffic; ct.Enabled {
+		clientConfig.CoverTraffic = &client.CoverTrafficConfig{
+			Interval: ct.Interval,
+			Jitter:   ct.Jitter,
+			MinSize:  ct.MinSize,
+			MaxSize:  ct.MaxSize,
+		}
+	}
+
+	if d := cfg.Tunnel.Degradation; d.Enabled {
+		clientConfig.Degradation = &health.DegradationConfig{
+			QueueSize:       d.QueueSize,
+			QueueTimeout:    d.QueueTimeout,
+			RecoveryTimeout: d.RecoveryTimeout,
+		}
+	}
+
+	clientConfig.DataFlowMonitor = &client.DataFlowMonitorConfig{
+		CheckInterval:  cfg.Tunnel.DataFlow.CheckInterval,
+		StallThreshold: cfg.Tunnel.DataFlow.StallThreshold,
+		StallAction:    stallAction(cfg.Tunnel.DataFlow.Action),
+		MinBytes:       cfg.Tunnel.DataFlow.MinBytes,
+	}
+
+	if r := cfg.Tunnel.Reconnect; r.MaxAttempts > 0 || r.MaxElapsedTime > 0 {
+		clientConfig.ReconnectLimit = &client.ReconnectLimit{
+			MaxAttempts:    r.MaxAttempts,
+			MaxElapsedTime: r.MaxElapsedTime,
+			Script:         r.GiveUpScript,
+		}
+	}
+
+	if h := cfg.Hooks; h.Enabled() {
+		clientConfig.HookCommands = &client.HookCommands{
+			OnConnect:    h.OnConnect,
+			OnDisconnect: h.OnDisconnect,
+			OnReconnect:  h.OnReconnect,
+			OnStall:      h.OnStall,
+			Timeout:      h.Timeout,
+		}
+	}
+
+	// Notifications are delivered until the client has stopped, including
+	// when it stops by itself
+	notifyCtx, stopNotify := context.WithCancel(context.Background())
+	defer stopNotify()
+	if n := cfg.Notifications; n.Enabled {
+		clientConfig.Notifier = notify.New(n.NotifierConfig(cfg.Client.Name), log.Component("notify"))
+		clientConfig.NotifyAfterFailures = n.ReconnectFailures
+		clientConfig.Notifier.Start(notifyCtx)
+	}
+
+	if cb := cfg.Tunnel.Reconnect.CircuitBreaker; cb.Enabled {
+		clientConfig.ReconnectBreaker = &circuitbreaker.Config{
+			MaxFailures:         cb.MaxFailures,
+			Timeout:             cb.Timeout,
+			MaxHalfOpenRequests: cb.HalfOpenRequests,
+		}
+	}
+
+	// Persist usage counters across restarts
+	if cfg.Observability.Usage.Enabled {
+		clientConfig.UsageStateFile = cfg.Observability.Usage.StateFile
+		clientConfig.UsageFlushInterval = cfg.Observability.Usage.FlushInterval
 	}
 
-	upstreamTLS, err := loadTLSConfig(cfg.Client.Upstream.TLS.Enabled, cfg.Client.Upstream.TLS.SkipVerify, cfg.Client.Upstream.TLS.CAFile)
+	// Set SOCKS5 authentication if enabled
+	clientConfig.SOCKS5Username, clientConfig.SOCKS5Password = socks5Credentials(cfg)
+
+	upstreamTLS, err := cfg.Client.Upstream.TLS.Load()
 	if err != nil {
 		log.Error().Err(err).Msg("Failed to load upstream TLS configuration")
 		os.Exit(1)
 	}
-	downstreamTLS, err := loadTLSConfig(cfg.Client.Downstream.TLS.Enabled, cfg.Client.Downstream.TLS.SkipVerify, cfg.Client.Downstream.TLS.CAFile)
+	downstreamTLS, err := cfg.Client.Downstream.TLS.Load()
 	if err != nil {
 		log.Error().Err(err).Msg("Failed to load downstream TLS configuration")
 		os.Exit(1)
 	}
 	clientConfig.UpstreamTLS = upstreamTLS
 	clientConfig.DownstreamTLS = downstreamTLS
+	clientConfig.UpstreamHeader = cfg.Client.Upstream.DialHeader()
+	clientConfig.DownstreamHeader = cfg.Client.Downstream.DialHeader()
+	clientConfig.UpstreamFingerprint = cfg.Client.Upstream.TLS.Fingerprint
+	clientConfig.DownstreamFingerprint = cfg.Client.Downstream.TLS.Fingerprint
+	if clientConfig.UpstreamProxy, err = cfg.Client.Upstream.Proxy(); err != nil {
+		log.Error().Err(err).Msg("Invalid upstream proxy URL")
+		os.Exit(1)
+	}
+	if clientConfig.DownstreamProxy, err = cfg.Client.Downstream.Proxy(); err != nil {
+		log.Error().Err(err).Msg("Invalid downstream proxy URL")
+		os.Exit(1)
+	}
+
+	// Start metrics server if enabled
+	var metricsServer *metrics.Server
+	if cfg.Observability.Metrics.Enabled {
+		addr := fmt.Sprintf(":%d", cfg.Observability.Metrics.Port)
+		metricsServer = metrics.NewServer(&metrics.ServerConfig{
+			Addr:          addr,
+			Path:          cfg.Observability.Metrics.Path,
+			MaxDestHosts:  cfg.Observability.Metrics.StreamLabels.MaxDestHosts,
+			HashDestHosts: cfg.Observability.Metrics.StreamLabels.HashDestHosts,
+		})
+		go func() {
+			if err := metricsServer.Start(); err != nil && err != http.ErrServerClosed {
+				log.Error().Err(err).Msg("Metrics server error")
+			}
+		}()
+		log.Info().Str("addr", addr).Str("path", cfg.Observability.Metrics.Path).Msg("Metrics server started")
+		clientConfig.Metrics = metricsServer.Collector()
+	}
 
 	// Create and start the client
 	c := client.New(clientConfig, log)
 	currentClient = c
+
+	var healthServer *health.Server
+	if cfg.Observability.Health.Enabled {
+		addr := fmt.Sprintf(":%d", cfg.Observability.Health.Port)
+		readyzPath := "/readyz"
+		if cfg.Observability.Health.Path == "/readyz" {
+			readyzPath = "/healthz"
+		}
+		healthServer = health.NewServer(&health.ServerConfig{
+			Addr:        addr,
+			HealthzPath: cfg.Observability.Health.Path,
+			ReadyzPath:  readyzPath,
+		})
+		healthServer.RegisterChecker(c)
+		go func() {
+			if err := healthServer.Start(); err != nil && err != http.ErrServerClosed {
+				log.Error().Err(err).Msg("Health server error")
+			}
+		}()
+		log.Info().Str("addr", addr).Str("path", cfg.Observability.Health.Path).Msg("Health server started")
+	}
+
+	var adminServer *admin.Server
+	if cfg.Observability.Admin.Enabled {
+		adminServer = admin.NewServer(&admin.ServerConfig{
+			Addr:   cfg.Observability.Admin.Addr(),
+			Prefix: cfg.Observability.Admin.Path,
+			Token:  cfg.Observability.Admin.Token,
+		}, c)
+		go func() {
+			if err := adminServer.Start(); err != nil && err != http.ErrServerClosed {
+				log.Error().Err(err).Msg("Admin server error")
+			}
+		}()
+		log.Info().Str("addr", adminServer.Addr()).Str("path", cfg.Observability.Admin.Path).Msg("Admin API started")
+	}
+
+	var debugServer *profiling.Server
+	if cfg.Observability.Debug.Enabled {
+		debugServer = profiling.NewServer(&profiling.ServerConfig{
+			Addr:    cfg.Observability.Debug.Addr(),
+			DumpDir: cfg.Observability.Debug.DumpDir,
+		})
+		go func() {
+			if err := debugServer.Start(); err != nil && err != http.ErrServerClosed {
+				log.Error().Err(err).Msg("Debug server error")
+			}
+		}()
+		log.Warn().Str("addr", debugServer.Addr()).Msg("Debug server started; it exposes pprof without authentication")
+	}
+
+	if cfg.Control.Enabled {
+		ctl := control.NewServer(cfg.Control.Socket, log.Component("control"))
+		ctl.RegisterTunnelCommands(c)
+		ctl.RegisterForwardCommands(c)
+		ctl.Handle("reload", func(args []string) (interface{}, error) {
+			if *configPath == "" {
+				return nil, fmt.Errorf("no config file to reload")
+			}
+			if err := reloadConfig(*configPath, c, log); err != nil {
+				return nil, err
+			}
+			return "configuration reloaded", nil
+		})
+		if err := ctl.Start(ctx); err != nil {
+			log.Warn().Err(err).Str("socket", cfg.Control.Socket).Msg("Control socket disabled")
+		} else {
+			log.Info().Str("socket", cfg.Control.Socket).Msg("Control socket started")
+		}
+	}
 	if err := c.Start(ctx); err != nil {
 		log.Error().Err(err).Msg("Failed to start client")
 		os.Exit(1)
@@ -194,6 +402,11 @@ func main() {
 			log.Warn().Err(err).Msg("Failed to create config watcher, hot reload disabled")
 		} else {
 			defer watcher.Close()
+			for _, path := range cfg.Routing.Files() {
+				if err := watcher.Add(path); err != nil {
+					log.Warn().Err(err).Str("path", path).Msg("Failed to watch routing file")
+				}
+			}
 			if err := watcher.Add(*configPath); err != nil {
 				log.Warn().Err(err).Str("path", *configPath).Msg("Failed to watch config file")
 			} else {
@@ -206,9 +419,8 @@ func main() {
 								return
 							}
 							if event.Op&fsnotify.Write == fsnotify.Write || event.Op&fsnotify.Create == fsnotify.Create {
-								log.Info().Str("path", event.Name).Msg("Config file changed, triggering reload...")
-								// Send SIGHUP to self to trigger reload
-								_ = syscall.Kill(syscall.Getpid(), syscall.SIGHUP)
+								log.Info().Str("path", event.Name).Msg("Config file changed, reloading...")
+								_ = reloadConfig(*configPath, c, log)
 							}
 						case err, ok := <-watcher.Errors:
 							if !ok {
@@ -224,22 +436,6 @@ func main() {
 		}
 	}
 
-	// Start metrics server if enabled
-	var metricsServer *metrics.Server
-	if cfg.Observability.Metrics.Enabled {
-		addr := fmt.Sprintf(":%d", cfg.Observability.Metrics.Port)
-		metricsServer = metrics.NewServer(&metrics.ServerConfig{
-			Addr: addr,
-			Path: cfg.Observability.Metrics.Path,
-		})
-		go func() {
-			if err := metricsServer.Start(); err != nil && err != http.ErrServerClosed {
-				log.Error().Err(err).Msg("Metrics server error")
-			}
-		}()
-		log.Info().Str("addr", addr).Str("path", cfg.Observability.Metrics.Path).Msg("Metrics server started")
-	}
-
 	// Log startup info
 	if cfg.SOCKS5.Enabled {
 		log.Info().
@@ -254,8 +450,11 @@ func main() {
 			Msg("Client is ready")
 	}
 
-	// Wait for shutdown
-	<-ctx.Done()
+	// Wait for shutdown, or for the client to stop by itself
+	select {
+	case <-ctx.Done():
+	case <-c.Done():
+	}
 	log.Info().Msg("Shutting down client")
 
 	if metricsServer != nil {
@@ -266,35 +465,176 @@ func main() {
 		shutdownCancel()
 	}
 
+	if healthServer != nil {
+		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if err := healthServer.Shutdown(shutdownCtx); err != nil {
+			log.Error().Err(err).Msg("Health server shutdown error")
+		}
+		shutdownCancel()
+	}
+
+	if adminServer != nil {
+		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if err := adminServer.Shutdown(shutdownCtx); err != nil {
+			log.Error().Err(err).Msg("Admin server shutdown error")
+		}
+		shutdownCancel()
+	}
+
+	if debugServer != nil {
+		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if err := debugServer.Shutdown(shutdownCtx); err != nil {
+			log.Error().Err(err).Msg("Debug server shutdown error")
+		}
+		shutdownCancel()
+	}
+
 	// Stop the client
 	if err := c.Stop(); err != nil {
 		log.Error().Err(err).Msg("Error stopping client")
 	}
+	stopNotify()
+	clientConfig.Notifier.Wait()
+	if errors.Is(c.Err(), client.ErrReconnectGaveUp) {
+		os.Exit(exitReconnectGaveUp)
+	}
+}
+
+// toClientPortForwards converts parsed config port forwards to client port forwards.
+func toClientPortForwards(portForwards []config.PortForward) []client.PortForward {
+	clientPortForwards := make([]client.PortForward, len(portForwards))
+	for i, pf := range portForwards {
+		clientPortForwards[i] = client.PortForward{
+			Name:       pf.Name,
+			ListenHost: pf.ListenHost,
+			ListenPort: pf.ListenPort,
+			RemoteHost: pf.RemoteHost,
+			RemotePort: pf.RemotePort,
+			Protocol:   pf.Protocol,
+			Family:     sockopt.Family(pf.IPFamily),
+			AllowFrom:  client.NewAllowList(pf.AllowFrom),
+		}
+	}
+	return clientPortForwards
 }
 
-// loadTLSConfig creates a TLS configuration based on the provided parameters.
-// If enabled is false, it returns nil. Otherwise, it creates a *tls.Config
-// with the specified InsecureSkipVerify setting and optionally loads a custom CA.
-func loadTLSConfig(enabled bool, skipVerify bool, caFile string) (*tls.Config, error) {
-	if !enabled {
-		return nil, nil
+// toClientSOCKS5Listeners converts the additional SOCKS5 listeners from the
+// config to client listeners.
+func toClientSOCKS5Listeners(listeners []config.SOCKS5Listener) []client.SOCKS5Listener {
+	clientListeners := make([]client.SOCKS5Listener, len(listeners))
+	for i, l := range listeners {
+		clientListeners[i] = client.SOCKS5Listener{
+			Name:      l.Label(),
+			Addr:      l.Addr(),
+			Family:    sockopt.Family(l.IPFamily),
+			AllowFrom: client.NewAllowList(l.AllowFrom),
+		}
+		if l.Auth.Enabled {
+			clientListeners[i].Username = l.Auth.Username
+			clientListeners[i].Password = l.Auth.Password
+		}
 	}
+	return clientListeners
+}
 
-	tlsConfig := &tls.Config{
-		InsecureSkipVerify: skipVerify,
+// toRouter builds the client's routing engine from the validated routing
+// config, reading its GeoIP database and list files.
+func toRouter(cfg config.RoutingConfig) (*routing.Router, error) {
+	rules := make([]routing.Rule, 0, len(cfg.Rules))
+	for _, r := range cfg.Rules {
+		rule := routing.Rule{Name: r.Name, Domains: r.Domains, Countries: r.Countries, Action: routing.Action(r.Action)}
+		for _, network := range r.Networks {
+			// Validated when the config was loaded
+			_, ipNet, _ := net.ParseCIDR(network)
+			rule.Networks = append(rule.Networks, ipNet)
+		}
+		for _, list := range r.Lists {
+			domains, networks, err := routing.LoadList(list)
+			if err != nil {
+				return nil, fmt.Errorf("failed to load routing list: %w", err)
+			}
+			rule.Domains = append(rule.Domains, domains...)
+			rule.Networks = append(rule.Networks, networks...)
+		}
+		for _, port := range r.Ports {
+			rule.Ports = append(rule.Ports, uint16(port))
+		}
+		rules = append(rules, rule)
 	}
 
-	if caFile != "" {
-		caCert, err := os.ReadFile(caFile)
+	router := routing.New(rules, routing.Action(cfg.Default))
+	router.SetResolve(cfg.Resolve)
+	if cfg.GeoIP != "" {
+		db, err := geoip.Open(cfg.GeoIP)
 		if err != nil {
-			return nil, fmt.Errorf("failed to read CA file: %w", err)
-		}
-		caCertPool := x509.NewCertPool()
-		if !caCertPool.AppendCertsFromPEM(caCert) {
-			return nil, fmt.Errorf("failed to parse CA certificate")
+			return nil, fmt.Errorf("failed to open GeoIP database: %w", err)
 		}
-		tlsConfig.RootCAs = caCertPool
+		router.SetGeoIP(db)
+	}
+	return router, nil
+}
+
+// stallAction returns the data flow monitor action for a configured
+// tunnel.dataflow.action.
+func stallAction(action string) client.StallAction {
+	switch action {
+	case config.StallActionRestart:
+		return client.StallActionRestart
+	case config.StallActionShutdown:
+		return client.StallActionShutdown
+	default:
+		return client.StallActionLog
+	}
+}
+
+// socks5Credentials returns the SOCKS5 username and password, or empty
+// strings when authentication is disabled.
+func socks5Credentials(cfg *config.ClientConfig) (string, string) {
+	if !cfg.SOCKS5.Auth.Enabled {
+		return "", ""
+	}
+	return cfg.SOCKS5.Auth.Username, cfg.SOCKS5.Auth.Password
+}
+
+// reloadConfig re-reads the configuration file and applies the settings that
+// can change at runtime: port forwards, SOCKS5 authentication and routing
+// rules, including their GeoIP database and list files. The tunnel and its
+// active streams are left untouched; other changes need a restart. Errors are
+// logged and returned for callers that report them.
+func reloadConfig(path string, c *client.Client, log *logger.Logger) error {
+	cfg, err := config.LoadClientConfigFromFile(path)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to reload configuration, keeping current settings")
+		return fmt.Errorf("failed to reload configuration: %w", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		log.Error().Err(err).Msg("Reloaded configuration is invalid, keeping current settings")
+		return fmt.Errorf("reloaded configuration is invalid: %w", err)
 	}
 
-	return tlsConfig, nil
+	portForwards, err := cfg.GetPortForwards()
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to parse reloaded port forwards, keeping current settings")
+		return fmt.Errorf("failed to parse port forwards: %w", err)
+	}
+
+	router, err := toRouter(cfg.Routing)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to load reloaded routing rules, keeping current settings")
+		return err
+	}
+
+	forwardErr := c.UpdatePortForwards(toClientPortForwards(portForwards))
+	if forwardErr != nil {
+		log.Error().Err(forwardErr).Msg("Some port forwards could not be started")
+	}
+	c.UpdateSOCKS5Auth(socks5Credentials(cfg))
+	c.SetRouter(router)
+
+	log.Info().
+		Int("port_forwards", len(portForwards)).
+		Int("routing_rules", len(cfg.Routing.Rules)).
+		Bool("socks5_auth", cfg.SOCKS5.Auth.Enabled).
+		Msg("Configuration reloaded (tunnel, TLS and logging changes require a restart)")
+	return forwardErr
 }
@@ -0,0 +1,22 @@
+//go:build darwin
+
+package main
+
+import (
+	"os"
+
+	"github.com/sahmadiut/half-tunnel/internal/service"
+	"github.com/sahmadiut/half-tunnel/pkg/logger"
+)
+
+// serviceLogOutput returns the log output to use instead of the console
+// when the client runs as a service without one. launchd sets
+// XPC_SERVICE_NAME to the label of the daemon it started; its console
+// output only goes to a file, so the client logs to the unified log
+// through syslog instead.
+func serviceLogOutput() string {
+	if os.Getenv("XPC_SERVICE_NAME") == service.ServiceName(service.ClientService) {
+		return logger.OutputSyslog
+	}
+	return ""
+}
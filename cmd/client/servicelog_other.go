@@ -0,0 +1,10 @@
+//go:build !windows && !darwin
+
+package main
+
+// serviceLogOutput returns the log output to use instead of the console
+// when the client runs as a service without one. systemd, OpenRC and runit
+// collect the console output, so it is always empty here.
+func serviceLogOutput() string {
+	return ""
+}
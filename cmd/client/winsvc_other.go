@@ -0,0 +1,14 @@
+//go:build !windows
+
+package main
+
+// isWindowsService reports whether the service control manager started the
+// client, which is never the case outside of Windows.
+func isWindowsService() bool {
+	return false
+}
+
+// runWindowsService does nothing outside of Windows.
+func runWindowsService(stop func()) func() {
+	return func() {}
+}
@@ -0,0 +1,72 @@
+//go:build windows
+
+package main
+
+import (
+	"golang.org/x/sys/windows/svc"
+
+	"github.com/sahmadiut/half-tunnel/pkg/logger"
+)
+
+// isWindowsService reports whether the service control manager started the
+// client.
+func isWindowsService() bool {
+	ok, err := svc.IsWindowsService()
+	return err == nil && ok
+}
+
+// serviceLogOutput returns the log output to use instead of the console
+// when the client runs as a service without one: the event log.
+func serviceLogOutput() string {
+	if isWindowsService() {
+		return logger.OutputEventLog
+	}
+	return ""
+}
+
+// runWindowsService reports the client to the service control manager as
+// running and calls stop when the service is stopped or Windows shuts down.
+// The returned function reports the service as stopped; call it once the
+// client has shut down.
+func runWindowsService(stop func()) func() {
+	h := &serviceHandler{stop: stop, done: make(chan struct{})}
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		// The name is ignored for services in their own process
+		_ = svc.Run("", h)
+	}()
+	return func() {
+		close(h.done)
+		<-exited
+	}
+}
+
+// serviceHandler handles service control requests.
+type serviceHandler struct {
+	stop func()
+	done chan struct{}
+}
+
+func (h *serviceHandler) Execute(args []string, requests <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	status <- svc.Status{State: svc.StartPending}
+	status <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+	for {
+		select {
+		case req := <-requests:
+			switch req.Cmd {
+			case svc.Interrogate:
+				status <- req.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				status <- svc.Status{State: svc.StopPending}
+				h.stop()
+				<-h.done
+				return false, 0
+			}
+		case <-h.done:
+			// Exiting on its own, e.g. for a reload, counts as a failure so
+			// the recovery actions restart the client
+			return true, 1
+		}
+	}
+}
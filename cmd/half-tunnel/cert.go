@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/sahmadiut/half-tunnel/internal/certgen"
+	"github.com/sahmadiut/half-tunnel/internal/config"
+	"github.com/spf13/pflag"
+)
+
+func runCertCommand(args []string) {
+	if len(args) == 0 {
+		printCertUsage()
+		os.Exit(0)
+	}
+
+	switch args[0] {
+	case "selfsign":
+		runCertSelfSign(args[1:])
+	case "fingerprint":
+		runCertFingerprint(args[1:])
+	case "help", "--help", "-h":
+		printCertUsage()
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown cert subcommand: %s\n", args[0])
+		printCertUsage()
+		os.Exit(1)
+	}
+}
+
+func printCertUsage() {
+	fmt.Println(`Manage TLS certificates
+
+Usage:
+  half-tunnel cert <subcommand> [options]
+
+Subcommands:
+  selfsign       Generate a self-signed certificate for the server
+  fingerprint    Print the public key fingerprint of a certificate
+
+Use "half-tunnel cert <subcommand> --help" for more information.`)
+}
+
+func runCertSelfSign(args []string) {
+	fs := pflag.NewFlagSet("selfsign", pflag.ExitOnError)
+
+	hosts := fs.StringArray("host", nil, "DNS name or IP address the certificate is valid for (can be specified multiple times)")
+	dir := fs.String("dir", certgen.DefaultDir, "Directory to write server.crt and server.key to")
+	days := fs.Int("days", 365, "Days the certificate is valid")
+	configPath := fs.String("config", "", "Server configuration file to enable TLS in")
+	force := fs.Bool("force", false, "Overwrite an existing certificate and key")
+
+	fs.Usage = func() {
+		fmt.Println(`Generate a self-signed certificate for the server
+
+Usage:
+  half-tunnel cert selfsign --host <domain> [options]
+
+Writes server.crt and server.key to --dir, optionally enables TLS with them
+in a server configuration, and prints the fingerprint the client pins with
+tls.pin_sha256.
+
+Options:`)
+		fs.PrintDefaults()
+		fmt.Println(`
+Examples:
+  # Certificate for a domain and the server's IP, enabling TLS in the config
+  half-tunnel cert selfsign --host tunnel.example.com --host 203.0.113.10 \
+    --config /etc/half-tunnel/server.yml`)
+	}
+
+	if err := fs.Parse(args); err != nil {
+		os.Exit(1)
+	}
+	if len(*hosts) == 0 {
+		fmt.Fprintln(os.Stderr, "Error: at least one --host is required")
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	certFile := filepath.Join(*dir, "server.crt")
+	keyFile := filepath.Join(*dir, "server.key")
+	fingerprint, err := createSelfSigned(*hosts, certFile, keyFile, time.Duration(*days)*24*time.Hour, *force)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Fprintf(os.Stderr, "✅ Certificate saved to %s, key to %s\n", certFile, keyFile)
+
+	if *configPath != "" {
+		if err := config.EnableServerTLS(*configPath, certFile, keyFile); err != nil {
+			fmt.Fprintf(os.Stderr, "❌ Failed to update configuration: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Fprintf(os.Stderr, "✅ TLS enabled for both listeners in %s\n", *configPath)
+	} else {
+		fmt.Fprintln(os.Stderr, "Use them for both listeners in the server configuration:")
+		fmt.Fprintf(os.Stderr, "  server:\n    upstream:\n      tls:\n        enabled: true\n        cert_file: %q\n        key_file: %q\n", certFile, keyFile)
+		fmt.Fprintln(os.Stderr, "    downstream: (the same tls block)")
+	}
+
+	printClientTrust(certFile, fingerprint)
+	fmt.Println(fingerprint)
+}
+
+func runCertFingerprint(args []string) {
+	fs := pflag.NewFlagSet("fingerprint", pflag.ExitOnError)
+
+	certFile := fs.String("cert", filepath.Join(certgen.DefaultDir, "server.crt"), "Certificate file")
+
+	fs.Usage = func() {
+		fmt.Println(`Print the public key fingerprint of a certificate
+
+Usage:
+  half-tunnel cert fingerprint [options]
+
+Prints the base64-encoded SHA-256 hash of the certificate's public key, the
+value of tls.pin_sha256 in the client configuration.
+
+Options:`)
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		os.Exit(1)
+	}
+
+	certPEM, err := os.ReadFile(*certFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	fingerprint, err := certgen.Fingerprint(certPEM)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s: %v\n", *certFile, err)
+		os.Exit(1)
+	}
+	fmt.Println(fingerprint)
+}
+
+// createSelfSigned writes a new self-signed certificate and key and returns
+// the certificate's public key fingerprint.
+func createSelfSigned(hosts []string, certFile, keyFile string, validFor time.Duration, force bool) (string, error) {
+	certPEM, keyPEM, err := certgen.SelfSigned(hosts, validFor)
+	if err != nil {
+		return "", err
+	}
+	if err := certgen.WriteFiles(certFile, keyFile, certPEM, keyPEM, force); err != nil {
+		if os.IsExist(err) {
+			return "", fmt.Errorf("%w (use --force to overwrite)", err)
+		}
+		return "", err
+	}
+	return certgen.Fingerprint(certPEM)
+}
+
+// printClientTrust prints how a client trusts a self-signed certificate.
+func printClientTrust(certFile, fingerprint string) {
+	fmt.Fprintf(os.Stderr, "Public key fingerprint (SHA-256): %s\n", fingerprint)
+	fmt.Fprintln(os.Stderr, "Pin it on both paths in the client configuration:")
+	fmt.Fprintf(os.Stderr, "  client:\n    upstream:\n      tls:\n        pin_sha256: [%q]\n", fingerprint)
+	fmt.Fprintln(os.Stderr, "    downstream: (the same tls block)")
+	fmt.Fprintf(os.Stderr, "or copy %s to the client and set it as ca_file instead.\n", certFile)
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/sahmadiut/half-tunnel/internal/config"
+	"github.com/spf13/pflag"
+)
+
+func runConfigGenerate(args []string) {
+	fs := pflag.NewFlagSet("generate", pflag.ExitOnError)
+
+	configType := fs.String("type", "", "Configuration type: 'client' or 'server' (required)")
+	output := fs.String("output", "", "Output file path")
+	format := fs.String("format", "", "Output format: yaml, json or toml (default: from the output file extension, else yaml)")
+
+	// Server flags
+	upstreamPort := fs.Int("upstream-port", 0, "Upstream listener port (server)")
+	downstreamPort := fs.Int("downstream-port", 0, "Downstream listener port (server)")
+	listenHost := fs.String("listen-host", "", "Host both listeners bind to (server)")
+	tlsCert := fs.String("tls-cert", "", "TLS certificate path (server)")
+	tlsKey := fs.String("tls-key", "", "TLS key path (server)")
+	randomPaths := fs.Bool("random-paths", false, "Use random WebSocket paths (server)")
+	maxSessions := fs.Int("max-sessions", 0, "Maximum concurrent sessions (server)")
+	sessionTimeout := fs.Duration("session-timeout", 0, "Idle session timeout (server)")
+
+	// Shared flags
+	name := fs.String("name", "", "Name of the client or server")
+	pathSecret := fs.String("path-secret", "", "Shared secret for rotating WebSocket path tokens")
+	encryption := fs.Bool("encryption", true, "Encrypt tunnel payloads")
+	encryptionAlgorithm := fs.String("encryption-algorithm", "", "Encryption algorithm: aes-256-gcm or chacha20-poly1305")
+	keepaliveInterval := fs.Duration("keepalive-interval", 0, "Keepalive interval")
+	logLevel := fs.String("log-level", "", "Log level: debug, info, warn or error")
+	logFormat := fs.String("log-format", "", "Log format: json or console")
+	logOutput := fs.String("log-output", "", "Log file (default: stdout)")
+	metrics := fs.Bool("metrics", true, "Enable the Prometheus metrics endpoint")
+	metricsPort := fs.Int("metrics-port", 0, "Metrics port (enables metrics)")
+	healthPort := fs.Int("health-port", 0, "Health check port (enables health checks)")
+
+	// Client flags
+	upstreamURL := fs.String("upstream-url", "", "Upstream server URL (client)")
+	downstreamURL := fs.String("downstream-url", "", "Downstream server URL (client)")
+	portForwards := fs.StringArray("port-forward", nil, "Port forward specification (can be specified multiple times)")
+	socks5Port := fs.Int("socks5-port", 0, "SOCKS5 proxy port (client)")
+	socks5Host := fs.String("socks5-host", "", "SOCKS5 proxy listen host (client)")
+	socks5User := fs.String("socks5-user", "", "SOCKS5 username, enables authentication (client)")
+	socks5Password := fs.String("socks5-password", "", "SOCKS5 password, enables authentication (client)")
+	tls := fs.Bool("tls", true, "Use TLS to the server (client)")
+	tlsSkipVerify := fs.Bool("tls-skip-verify", false, "Skip server certificate verification, insecure (client)")
+	caFile := fs.String("ca-file", "", "CA certificate to verify the server with (client)")
+	dnsPort := fs.Int("dns-port", 0, "Local DNS listener port, enables DNS forwarding (client)")
+	dnsUpstreams := fs.StringArray("dns-upstream", nil, "DNS server to forward to, enables DNS forwarding (can be specified multiple times)")
+	reconnect := fs.Bool("reconnect", true, "Reconnect automatically (client)")
+	reconnectInitialDelay := fs.Duration("reconnect-initial-delay", 0, "Delay before the first reconnect attempt (client)")
+	reconnectMaxDelay := fs.Duration("reconnect-max-delay", 0, "Maximum delay between reconnect attempts (client)")
+	reconnectMultiplier := fs.Float64("reconnect-multiplier", 0, "Backoff multiplier between reconnect attempts (client)")
+	dialTimeout := fs.Duration("dial-timeout", 0, "Timeout for dialing the server (client)")
+
+	sets := fs.StringArray("set", nil, "Set any configuration key, e.g. tunnel.reconnect.jitter=0.2 (can be specified multiple times)")
+
+	fs.Usage = func() {
+		fmt.Println(`Generate a new configuration file
+
+Usage:
+  half-tunnel config generate --type <client|server> [options]
+
+Without options other than --type and --output the configuration is built
+interactively. Any option makes the command non-interactive; settings that
+have no option of their own can be given with --set using the key names of
+the configuration file.
+
+Options:`)
+		fs.PrintDefaults()
+		fmt.Println(`
+Examples:
+  # Generate server config interactively
+  half-tunnel config generate --type server --output server.yml
+
+  # Generate client config interactively
+  half-tunnel config generate --type client --output client.yml
+
+  # Generate client config with flags (non-interactive)
+  half-tunnel config generate --type client \
+    --upstream-url "wss://domain-a.example.com:8443/ws/upstream" \
+    --downstream-url "wss://domain-b.example.com:8444/ws/downstream" \
+    --port-forward "2083" \
+    --port-forward "8080:example.com:80" \
+    --socks5-port 1080 \
+    --output client.yml
+
+  # Generate server config with flags
+  half-tunnel config generate --type server \
+    --upstream-port 8443 \
+    --downstream-port 8444 \
+    --tls-cert /path/to/cert.pem \
+    --tls-key /path/to/key.pem \
+    --output server.yml
+
+  # Generate server config with random paths and rotating path tokens
+  half-tunnel config generate --type server \
+    --random-paths \
+    --path-secret "$(openssl rand -hex 32)" \
+    --output server.yml
+
+  # Generate a server config as TOML
+  half-tunnel config generate --type server --upstream-port 8443 --output server.toml
+
+  # Tune reconnects and set keys without a dedicated option
+  half-tunnel config generate --type client \
+    --reconnect-max-delay 2m \
+    --metrics-port 9191 \
+    --set tunnel.reconnect.jitter=0.2 \
+    --set routing.default=direct \
+    --output client.yml
+
+Port forward formats:
+  - "2083"                    Listen on 2083, forward to remote:2083
+  - "8080:80"                 Listen on 8080, forward to remote:80
+  - "8080:example.com:80"     Listen on 8080, forward to example.com:80`)
+	}
+
+	if err := fs.Parse(args); err != nil {
+		os.Exit(1)
+	}
+
+	if *configType == "" {
+		fmt.Fprintln(os.Stderr, "Error: --type is required")
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	outputFormat := config.FormatFromPath(*output)
+	if *format != "" {
+		var err error
+		if outputFormat, err = config.ParseFormat(*format); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	overrides, err := config.ParseOverrides(*sets)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Run interactively when nothing but the type and output is given
+	interactive := true
+	fs.Visit(func(f *pflag.Flag) {
+		if f.Name != "type" && f.Name != "output" && f.Name != "format" {
+			interactive = false
+		}
+	})
+
+	opts := config.GenerateOptions{
+		OutputPath:            *output,
+		UpstreamPort:          *upstreamPort,
+		DownstreamPort:        *downstreamPort,
+		TLSCert:               *tlsCert,
+		TLSKey:                *tlsKey,
+		ServerName:            *name,
+		RandomPaths:           *randomPaths,
+		ListenHost:            *listenHost,
+		MaxSessions:           *maxSessions,
+		SessionTimeout:        *sessionTimeout,
+		PathSecret:            *pathSecret,
+		Encryption:            changedBool(fs, "encryption", *encryption),
+		EncryptionAlgorithm:   *encryptionAlgorithm,
+		KeepaliveInterval:     *keepaliveInterval,
+		LogLevel:              *logLevel,
+		LogFormat:             *logFormat,
+		LogOutput:             *logOutput,
+		Metrics:               changedBool(fs, "metrics", *metrics),
+		MetricsPort:           *metricsPort,
+		HealthPort:            *healthPort,
+		UpstreamURL:           *upstreamURL,
+		DownstreamURL:         *downstreamURL,
+		PortForwards:          *portForwards,
+		SOCKS5Port:            *socks5Port,
+		ClientName:            *name,
+		EnableSOCKS5:          *socks5Port > 0,
+		SOCKS5Host:            *socks5Host,
+		SOCKS5Username:        *socks5User,
+		SOCKS5Password:        *socks5Password,
+		TLS:                   changedBool(fs, "tls", *tls),
+		TLSSkipVerify:         *tlsSkipVerify,
+		CAFile:                *caFile,
+		DNSPort:               *dnsPort,
+		DNSUpstreams:          *dnsUpstreams,
+		Reconnect:             changedBool(fs, "reconnect", *reconnect),
+		ReconnectInitialDelay: *reconnectInitialDelay,
+		ReconnectMaxDelay:     *reconnectMaxDelay,
+		ReconnectMultiplier:   *reconnectMultiplier,
+		DialTimeout:           *dialTimeout,
+		Overrides:             overrides,
+	}
+
+	var generator *config.ConfigGenerator
+	if interactive {
+		generator = config.NewInteractiveGenerator()
+	} else {
+		generator = config.NewNonInteractiveGenerator()
+	}
+
+	var content string
+	switch *configType {
+	case "client":
+		clientCfg, err := generator.GenerateClientConfig(opts)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error generating client config: %v\n", err)
+			os.Exit(1)
+		}
+		content, err = config.RenderClientConfig(clientCfg, outputFormat)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error rendering client config: %v\n", err)
+			os.Exit(1)
+		}
+
+	case "server":
+		serverCfg, err := generator.GenerateServerConfig(opts)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error generating server config: %v\n", err)
+			os.Exit(1)
+		}
+		content, err = config.RenderServerConfig(serverCfg, outputFormat)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error rendering server config: %v\n", err)
+			os.Exit(1)
+		}
+
+	default:
+		fmt.Fprintf(os.Stderr, "Error: unknown config type: %s (use 'client' or 'server')\n", *configType)
+		os.Exit(1)
+	}
+
+	if *output == "" {
+		fmt.Println(content)
+		return
+	}
+	if err := os.WriteFile(*output, []byte(content), 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing config: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("✅ Configuration saved to: %s\n", *output)
+}
+
+// changedBool returns the value of a boolean flag if it was given on the
+// command line and nil otherwise, leaving the default of the config.
+func changedBool(fs *pflag.FlagSet, name string, value bool) *bool {
+	if !fs.Changed(name) {
+		return nil
+	}
+	return &value
+}
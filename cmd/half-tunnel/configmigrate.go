@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/sahmadiut/half-tunnel/internal/config"
+	"github.com/spf13/pflag"
+)
+
+func runConfigMigrate(args []string) {
+	fs := pflag.NewFlagSet("migrate", pflag.ExitOnError)
+
+	configPath := fs.String("config", "", "Path to configuration file (required)")
+	configType := fs.String("type", "", "Configuration type: 'client' or 'server' (optional, auto-detected if not specified)")
+	output := fs.String("output", "", "Write the migrated file here instead of replacing the original")
+	check := fs.Bool("check", false, "Only report what would change; exit with status 1 if anything would")
+
+	fs.Usage = func() {
+		fmt.Println(`Upgrade a configuration file to the current format
+
+Usage:
+  half-tunnel config migrate --config <path> [--type <client|server>] [--output <path>] [--check]
+
+Renames deprecated keys, removes unknown keys and writes every setting,
+filling in defaults for settings the file does not have, in the order of the
+current schema. The file is replaced in place, keeping the original as
+<path>.bak, unless --output is given. Comments are not kept.
+
+With --check nothing is written; the changes are listed and the command exits
+with status 1 if the file is not already up to date, for use in CI.
+
+Options:`)
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		os.Exit(1)
+	}
+
+	if *configPath == "" {
+		fmt.Fprintln(os.Stderr, "Error: --config is required")
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	if *configType == "" {
+		if strings.Contains(*configPath, "client") {
+			*configType = "client"
+		} else if strings.Contains(*configPath, "server") {
+			*configType = "server"
+		} else {
+			fmt.Fprintln(os.Stderr, "Error: could not auto-detect config type, please specify --type")
+			os.Exit(1)
+		}
+	}
+
+	var result *config.MigrationResult
+	var err error
+	switch *configType {
+	case "client":
+		result, err = config.MigrateClientConfig(*configPath)
+	case "server":
+		result, err = config.MigrateServerConfig(*configPath)
+	default:
+		fmt.Fprintf(os.Stderr, "Error: unknown config type: %s (use 'client' or 'server')\n", *configType)
+		os.Exit(1)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Migration failed: %v\n", err)
+		os.Exit(1)
+	}
+
+	for _, change := range result.Changes {
+		fmt.Printf("  %s\n", change)
+	}
+
+	if *check {
+		if result.Changed {
+			fmt.Fprintf(os.Stderr, "❌ %s is not up to date; run 'half-tunnel config migrate --config %s'\n", *configPath, *configPath)
+			os.Exit(1)
+		}
+		fmt.Printf("✅ Configuration is up to date: %s\n", *configPath)
+		return
+	}
+
+	if !result.Changed {
+		fmt.Printf("✅ Configuration is up to date: %s\n", *configPath)
+		return
+	}
+
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(*configPath); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	target := *output
+	if target == "" {
+		target = *configPath
+		original, err := os.ReadFile(*configPath)
+		if err == nil {
+			err = os.WriteFile(*configPath+".bak", original, mode)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error backing up config: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if err := os.WriteFile(target, []byte(result.Content), mode); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing config: %v\n", err)
+		os.Exit(1)
+	}
+	if target == *configPath {
+		fmt.Printf("✅ Configuration migrated: %s (original saved as %s.bak)\n", target, *configPath)
+	} else {
+		fmt.Printf("✅ Migrated configuration saved to: %s\n", target)
+	}
+}
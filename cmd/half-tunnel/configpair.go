@@ -0,0 +1,193 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/sahmadiut/half-tunnel/internal/config"
+	"github.com/spf13/pflag"
+)
+
+func runConfigPair(args []string) {
+	fs := pflag.NewFlagSet("pair", pflag.ExitOnError)
+
+	defaults := config.DefaultPairOptions()
+	clientOutput := fs.String("client-output", "client.yml", "Client config output path")
+	serverOutput := fs.String("server-output", "server.yml", "Server config output path")
+	format := fs.String("format", "", "Output format: yaml, json or toml (default: from the output file extensions)")
+
+	upstreamHost := fs.String("upstream-host", "", "Public host the client connects to for upstream (domain A)")
+	downstreamHost := fs.String("downstream-host", "", "Public host the client connects to for downstream (domain B, default: upstream host)")
+	upstreamPort := fs.Int("upstream-port", defaults.UpstreamPort, "Upstream port")
+	downstreamPort := fs.Int("downstream-port", defaults.DownstreamPort, "Downstream port")
+	tls := fs.Bool("tls", defaults.TLS, "Use TLS")
+	tlsCert := fs.String("tls-cert", defaults.TLSCert, "TLS certificate path on the server")
+	tlsKey := fs.String("tls-key", defaults.TLSKey, "TLS key path on the server")
+	randomPaths := fs.Bool("random-paths", defaults.RandomPaths, "Use random WebSocket paths")
+	pathTokens := fs.Bool("path-tokens", defaults.PathTokens, "Rotate WebSocket path tokens with a generated secret")
+	clientAuth := fs.Bool("client-auth", defaults.ClientAuth, "Require a generated client token")
+	clientName := fs.String("client-name", "", "Name of the client")
+	serverName := fs.String("server-name", "", "Name of the server")
+	encryptionAlgorithm := fs.String("encryption-algorithm", "", "Encryption algorithm: aes-256-gcm or chacha20-poly1305")
+	portForwards := fs.StringArray("port-forward", nil, "Port forward specification (can be specified multiple times)")
+	socks5Port := fs.Int("socks5-port", defaults.SOCKS5Port, "SOCKS5 proxy port on the client (0 disables SOCKS5)")
+
+	fs.Usage = func() {
+		fmt.Println(`Generate a matching client and server configuration
+
+Usage:
+  half-tunnel config pair [options]
+
+Writes a client and a server configuration whose ports, paths, TLS settings,
+path token secret, client token and encryption settings match, and prints
+what to deploy where. Without options other than the output paths and the
+format the settings are asked for interactively.
+
+Options:`)
+		fs.PrintDefaults()
+		fmt.Println(`
+Examples:
+  # Ask for the settings
+  half-tunnel config pair
+
+  # Two domains in front of one server, with a port forward
+  half-tunnel config pair \
+    --upstream-host domain-a.example.com \
+    --downstream-host domain-b.example.com \
+    --port-forward 2083 \
+    --client-output client.yml --server-output server.yml`)
+	}
+
+	if err := fs.Parse(args); err != nil {
+		os.Exit(1)
+	}
+
+	// Run interactively when nothing but the outputs and format is given
+	interactive := true
+	fs.Visit(func(f *pflag.Flag) {
+		if f.Name != "client-output" && f.Name != "server-output" && f.Name != "format" {
+			interactive = false
+		}
+	})
+
+	clientFormat := config.FormatFromPath(*clientOutput)
+	serverFormat := config.FormatFromPath(*serverOutput)
+	if *format != "" {
+		f, err := config.ParseFormat(*format)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		clientFormat, serverFormat = f, f
+	}
+
+	opts := config.PairOptions{
+		UpstreamHost:        *upstreamHost,
+		DownstreamHost:      *downstreamHost,
+		UpstreamPort:        *upstreamPort,
+		DownstreamPort:      *downstreamPort,
+		TLS:                 *tls,
+		TLSCert:             *tlsCert,
+		TLSKey:              *tlsKey,
+		RandomPaths:         *randomPaths,
+		PathTokens:          *pathTokens,
+		ClientAuth:          *clientAuth,
+		ClientName:          *clientName,
+		ServerName:          *serverName,
+		EncryptionAlgorithm: *encryptionAlgorithm,
+		PortForwards:        *portForwards,
+		SOCKS5Port:          *socks5Port,
+	}
+
+	var generator *config.ConfigGenerator
+	if interactive {
+		generator = config.NewInteractiveGenerator()
+	} else {
+		generator = config.NewNonInteractiveGenerator()
+	}
+	pair, err := generator.GeneratePair(opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error generating configs: %v\n", err)
+		os.Exit(1)
+	}
+
+	clientContent, err := config.RenderClientConfig(pair.Client, clientFormat)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error rendering client config: %v\n", err)
+		os.Exit(1)
+	}
+	serverContent, err := config.RenderServerConfig(pair.Server, serverFormat)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error rendering server config: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Both files hold shared secrets
+	if err := os.WriteFile(*serverOutput, []byte(serverContent), 0600); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing server config: %v\n", err)
+		os.Exit(1)
+	}
+	if err := os.WriteFile(*clientOutput, []byte(clientContent), 0600); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing client config: %v\n", err)
+		os.Exit(1)
+	}
+
+	printPairSummary(pair, *clientOutput, *serverOutput)
+}
+
+// printPairSummary prints what to deploy where for a generated pair.
+func printPairSummary(pair *config.ConfigPair, clientPath, serverPath string) {
+	server := pair.Server.Server
+	client := pair.Client
+
+	fmt.Printf("\n✅ Server configuration saved to: %s\n", serverPath)
+	fmt.Printf("✅ Client configuration saved to: %s\n", clientPath)
+
+	fmt.Println("\n🖥️  Server")
+	fmt.Printf("  Copy %s to the server and run: ht-server -config %s\n", serverPath, serverPath)
+	fmt.Printf("  Upstream:   listens on %s, path %s\n", net.JoinHostPort(server.Upstream.Host, strconv.Itoa(server.Upstream.Port)), server.Upstream.Path)
+	fmt.Printf("  Downstream: listens on %s, path %s\n", net.JoinHostPort(server.Downstream.Host, strconv.Itoa(server.Downstream.Port)), server.Downstream.Path)
+	if server.Upstream.TLS.Enabled {
+		fmt.Printf("  TLS:        place the certificate at %s and the key at %s\n", server.Upstream.TLS.CertFile, server.Upstream.TLS.KeyFile)
+	}
+	fmt.Printf("  Firewall:   open TCP ports %s\n", pairPorts(server.Upstream.Port, server.Downstream.Port))
+
+	fmt.Println("\n💻 Client")
+	fmt.Printf("  Copy %s to the client and run: ht-client -config %s\n", clientPath, clientPath)
+	fmt.Printf("  Upstream:   %s\n", client.Client.Upstream.URL)
+	fmt.Printf("  Downstream: %s\n", client.Client.Downstream.URL)
+	fmt.Println("  DNS:        both hosts must resolve to the server, or to a CDN in front of it")
+	if client.SOCKS5.Enabled {
+		fmt.Printf("  SOCKS5:     %s\n", client.SOCKS5.Addr())
+	}
+	if forwards, err := client.GetPortForwards(); err == nil && len(forwards) > 0 {
+		var listens []string
+		for _, pf := range forwards {
+			listens = append(listens, strconv.Itoa(pf.ListenPort))
+		}
+		fmt.Printf("  Forwards:   %s\n", strings.Join(listens, ", "))
+	}
+
+	fmt.Println("\n🔑 Shared settings")
+	if server.PathToken.Secret != "" {
+		fmt.Println("  Path tokens: the same generated secret is in both files")
+	}
+	if pair.ClientToken != "" {
+		fmt.Printf("  Client auth: token for %q in the client file, its hash in the server file\n", client.Client.Name)
+	}
+	if pair.Server.Tunnel.Encryption.Enabled {
+		fmt.Printf("  Encryption:  %s on both sides\n", pair.Server.Tunnel.Encryption.Algorithm)
+	}
+	fmt.Println("  Both files hold secrets; keep them readable by the service user only.")
+}
+
+// pairPorts lists the distinct listener ports.
+func pairPorts(upstream, downstream int) string {
+	if upstream == downstream {
+		return strconv.Itoa(upstream)
+	}
+	return strconv.Itoa(upstream) + " and " + strconv.Itoa(downstream)
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/sahmadiut/half-tunnel/internal/client"
+	"github.com/sahmadiut/half-tunnel/internal/config"
+	"github.com/sahmadiut/half-tunnel/internal/diag"
+	"github.com/spf13/pflag"
+)
+
+func runConfigTest(args []string) {
+	fs := pflag.NewFlagSet("test", pflag.ExitOnError)
+
+	configPath := fs.String("config", "", "Path to client configuration file (required)")
+	target := fs.String("target", diag.EchoAddr, "Destination of the test stream (host:port)")
+	timeout := fs.Duration("timeout", 30*time.Second, "Time allowed for the whole test")
+
+	fs.Usage = func() {
+		fmt.Println(`Test a client configuration against its server
+
+Usage:
+  half-tunnel config test --config <path> [--target <host:port>]
+
+Dials the configured upstream and downstream endpoints, performs the session
+handshake and opens a test stream, reporting the step that fails: DNS, TCP,
+TLS, WebSocket upgrade, handshake or stream.
+
+The default target is the server's echo diagnostic target, which needs
+tunnel.diagnostics.enabled on the server; any other target passes if the
+server can connect to it.
+
+Options:`)
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		os.Exit(1)
+	}
+
+	if *configPath == "" {
+		fmt.Fprintln(os.Stderr, "Error: --config is required")
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	cfg, err := config.LoadClientConfig(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Failed to load configuration: %v\n", err)
+		os.Exit(1)
+	}
+	clientConfig, err := selfTestConfig(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		os.Exit(1)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	ctx, cancel := context.WithTimeout(ctx, *timeout)
+	defer cancel()
+
+	fmt.Printf("Testing %s\n\n", *configPath)
+	report := client.SelfTest(ctx, clientConfig, *target)
+	for _, result := range report.Results {
+		name := result.Stage
+		if result.Path != "" {
+			name = result.Path + " " + result.Stage
+		}
+		if result.Err != nil {
+			fmt.Printf("  ❌ %-22s %v\n", name, result.Err)
+			continue
+		}
+		fmt.Printf("  ✅ %-22s %s (%s)\n", name, result.Detail, result.Elapsed.Round(time.Millisecond))
+	}
+
+	if err := report.Err(); err != nil {
+		fmt.Fprintf(os.Stderr, "\n❌ Self-test failed\n")
+		os.Exit(1)
+	}
+	fmt.Printf("\n✅ The tunnel works: %s\n", *configPath)
+}
+
+// selfTestConfig returns the tunnel settings of a client configuration.
+func selfTestConfig(cfg *config.ClientConfig) (*client.Config, error) {
+	clientConfig := &client.Config{
+		UpstreamURL:      cfg.Client.Upstream.URL,
+		DownstreamURL:    cfg.Client.Downstream.URL,
+		WriteTimeout:     cfg.Tunnel.Connection.DialTimeout,
+		DialTimeout:      cfg.Tunnel.Connection.DialTimeout,
+		HandshakeTimeout: cfg.Tunnel.Connection.DialTimeout,
+		ReadBufferSize:   cfg.Tunnel.Connection.ReadBufferSize,
+		WriteBufferSize:  cfg.Tunnel.Connection.WriteBufferSize,
+		GuestToken:       cfg.Client.GuestToken,
+		AuthToken:        cfg.Client.AuthToken,
+		PathSecret:       cfg.Client.PathToken.Secret,
+		PathWindow:       cfg.Client.PathToken.Window,
+		UpgradeSecret:    cfg.Client.UpgradeCookie.Secret,
+		UpgradeWindow:    cfg.Client.UpgradeCookie.Window,
+		UpgradeCookie:    cfg.Client.UpgradeCookie.Name,
+		UpstreamHeader:   cfg.Client.Upstream.DialHeader(),
+		DownstreamHeader: cfg.Client.Downstream.DialHeader(),
+		TCP:              cfg.Tunnel.Connection.TCP.SocketOptions(),
+	}
+
+	var err error
+	if clientConfig.UpstreamTLS, err = cfg.Client.Upstream.TLS.Load(); err != nil {
+		return nil, fmt.Errorf("failed to load upstream TLS configuration: %w", err)
+	}
+	if clientConfig.DownstreamTLS, err = cfg.Client.Downstream.TLS.Load(); err != nil {
+		return nil, fmt.Errorf("failed to load downstream TLS configuration: %w", err)
+	}
+	if clientConfig.UpstreamProxy, err = cfg.Client.Upstream.Proxy(); err != nil {
+		return nil, fmt.Errorf("invalid upstream proxy URL: %w", err)
+	}
+	if clientConfig.DownstreamProxy, err = cfg.Client.Downstream.Proxy(); err != nil {
+		return nil, fmt.Errorf("invalid downstream proxy URL: %w", err)
+	}
+	return clientConfig, nil
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/sahmadiut/half-tunnel/internal/clientauth"
+	"github.com/sahmadiut/half-tunnel/pkg/crypto"
+	"github.com/spf13/pflag"
+)
+
+func runKeygen(args []string) {
+	fs := pflag.NewFlagSet("keygen", pflag.ExitOnError)
+
+	out := fs.String("out", "", "Write the key to this file (mode 0600) instead of stdout")
+	force := fs.Bool("force", false, "Overwrite existing files")
+	name := fs.String("name", "", "Client name the server identifies the token with (token)")
+	hosts := fs.StringArray("host", nil, "DNS name or IP address the certificate is valid for (cert, can be specified multiple times)")
+	days := fs.Int("days", 365, "Days the certificate is valid (cert)")
+	certOut := fs.String("cert-out", "server.crt", "Certificate output path (cert)")
+	keyOut := fs.String("key-out", "server.key", "Private key output path (cert)")
+
+	fs.Usage = func() {
+		fmt.Println(`Generate key material
+
+Usage:
+  half-tunnel keygen <aes|hmac|token|cert> [options]
+
+Types:
+  aes      Random 256-bit AES key, base64 encoded
+  hmac     Random 256-bit HMAC key, base64 encoded; usable as the shared
+           path_token.secret of client and server or as access.guest.secret
+  token    Pre-shared client token and the hash the server checks it against
+  cert     Self-signed TLS certificate and key, for lab setups
+
+Keys are printed to stdout, or written to --out with mode 0600. The matching
+configuration snippets are printed to stderr.
+
+Options:`)
+		fs.PrintDefaults()
+		fmt.Println(`
+Examples:
+  # Shared path token secret, referenced from both configurations
+  half-tunnel keygen hmac --out /etc/half-tunnel/path-secret
+
+  # Client token for the client "laptop"
+  half-tunnel keygen token --name laptop --out laptop.token
+
+  # Self-signed certificate for a lab server
+  half-tunnel keygen cert --host tunnel.lab --host 10.0.0.5 \
+    --cert-out /etc/half-tunnel/certs/server.crt \
+    --key-out /etc/half-tunnel/certs/server.key`)
+	}
+
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		fs.Usage()
+		if len(args) == 0 || args[0] == "-h" || args[0] == "--help" {
+			os.Exit(0)
+		}
+		os.Exit(1)
+	}
+	kind := args[0]
+	if err := fs.Parse(args[1:]); err != nil {
+		os.Exit(1)
+	}
+
+	switch kind {
+	case "aes":
+		key, err := crypto.GenerateAES256Key()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		emitKey(base64.StdEncoding.EncodeToString(key), *out, *force)
+		fmt.Fprintln(os.Stderr, "No configuration setting takes an AES key; keep it for tooling that needs one.")
+
+	case "hmac":
+		key, err := crypto.GenerateHMACKey()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		value := base64.StdEncoding.EncodeToString(key)
+		emitKey(value, *out, *force)
+		fmt.Fprintln(os.Stderr, "Use it as the path token secret, under client: in the client and under server:")
+		fmt.Fprintln(os.Stderr, "in the server configuration:")
+		fmt.Fprintf(os.Stderr, "  path_token:\n    %s\n", secretSetting("secret", value, *out))
+		fmt.Fprintln(os.Stderr, "or to sign guest tokens, in the server configuration:")
+		fmt.Fprintf(os.Stderr, "  access:\n    guest:\n      %s\n", secretSetting("secret", value, *out))
+
+	case "token":
+		if *name == "" {
+			fmt.Fprintln(os.Stderr, "Error: --name is required for token")
+			os.Exit(1)
+		}
+		token, err := clientauth.Generate()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		emitKey(token, *out, *force)
+		fmt.Fprintln(os.Stderr, "Add the client to the server configuration:")
+		fmt.Fprintf(os.Stderr, "  access:\n    client_auth:\n      clients:\n        - name: %q\n          token_hash: %q\n", *name, clientauth.Hash(token))
+		fmt.Fprintln(os.Stderr, "and set the token in the client configuration:")
+		fmt.Fprintf(os.Stderr, "  client:\n    %s\n", secretSetting("auth_token", token, *out))
+
+	case "cert":
+		if len(*hosts) == 0 {
+			fmt.Fprintln(os.Stderr, "Error: at least one --host is required for cert")
+			os.Exit(1)
+		}
+		fingerprint, err := createSelfSigned(*hosts, *certOut, *keyOut, time.Duration(*days)*24*time.Hour, *force)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Fprintf(os.Stderr, "✅ Certificate saved to %s, key to %s\n", *certOut, *keyOut)
+		fmt.Fprintln(os.Stderr, "Use them for both listeners in the server configuration:")
+		fmt.Fprintf(os.Stderr, "  server:\n    upstream:\n      tls:\n        enabled: true\n        cert_file: %q\n        key_file: %q\n", *certOut, *keyOut)
+		fmt.Fprintln(os.Stderr, "    downstream: (the same tls block)")
+		printClientTrust(*certOut, fingerprint)
+
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown key type: %s\n", kind)
+		fs.Usage()
+		os.Exit(1)
+	}
+}
+
+// emitKey prints a key to stdout, or writes it to path.
+func emitKey(key, path string, force bool) {
+	if path == "" {
+		fmt.Println(key)
+		return
+	}
+	if err := writeKeyFile(path, []byte(key+"\n"), 0600, force); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Fprintf(os.Stderr, "✅ Key saved to: %s\n", path)
+}
+
+// writeKeyFile writes data to a new file, or replaces an existing one if
+// force is set.
+func writeKeyFile(path string, data []byte, perm os.FileMode, force bool) error {
+	flags := os.O_WRONLY | os.O_CREATE | os.O_EXCL
+	if force {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	}
+	f, err := os.OpenFile(path, flags, perm)
+	if err != nil {
+		if os.IsExist(err) {
+			return fmt.Errorf("%s already exists (use --force to overwrite)", path)
+		}
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Chmod(perm); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// secretSetting returns the config line for a secret: a reference to the
+// key file if there is one, the value otherwise.
+func secretSetting(key, value, path string) string {
+	if path != "" {
+		return fmt.Sprintf("%s_file: %q", key, path)
+	}
+	return fmt.Sprintf("%s: %q", key, value)
+}
@@ -5,8 +5,11 @@ import (
 	"fmt"
 	"os"
 	"strings"
+	"time"
 
+	"github.com/sahmadiut/half-tunnel/internal/clientauth"
 	"github.com/sahmadiut/half-tunnel/internal/config"
+	"github.com/sahmadiut/half-tunnel/internal/guest"
 	"github.com/spf13/pflag"
 )
 
@@ -33,6 +36,14 @@ func main() {
 	switch os.Args[1] {
 	case "config":
 		runConfigCommand(os.Args[2:])
+	case "guest":
+		runGuestCommand(os.Args[2:])
+	case "token":
+		runTokenCommand(os.Args[2:])
+	case "keygen":
+		runKeygen(os.Args[2:])
+	case "cert":
+		runCertCommand(os.Args[2:])
 	case "help", "--help", "-h":
 		printUsage()
 	default:
@@ -49,7 +60,11 @@ Usage:
   half-tunnel <command> [options]
 
 Commands:
-  config    Manage configuration files (generate, validate, sample)
+  config    Manage configuration files (generate, pair, validate, migrate, sample, test)
+  guest     Issue time-limited guest tokens
+  token     Generate client authentication tokens
+  keygen    Generate keys, tokens and self-signed certificates
+  cert      Create self-signed server certificates and print fingerprints
   help      Show this help message
 
 Flags:
@@ -70,8 +85,14 @@ func runConfigCommand(args []string) {
 		runConfigGenerate(args[1:])
 	case "validate":
 		runConfigValidate(args[1:])
+	case "migrate":
+		runConfigMigrate(args[1:])
+	case "pair":
+		runConfigPair(args[1:])
 	case "sample":
 		runConfigSample(args[1:])
+	case "test":
+		runConfigTest(args[1:])
 	case "help", "--help", "-h":
 		printConfigUsage()
 	default:
@@ -89,150 +110,15 @@ Usage:
 
 Subcommands:
   generate    Generate a new configuration file
+  pair        Generate a matching client and server configuration
   validate    Validate an existing configuration file
+  migrate     Upgrade a configuration file to the current format
   sample      Print a sample configuration
+  test        Test a client configuration against its server
 
 Use "half-tunnel config <subcommand> --help" for more information.`)
 }
 
-func runConfigGenerate(args []string) {
-	fs := pflag.NewFlagSet("generate", pflag.ExitOnError)
-	
-	configType := fs.String("type", "", "Configuration type: 'client' or 'server' (required)")
-	output := fs.String("output", "", "Output file path")
-	
-	// Server flags
-	upstreamPort := fs.Int("upstream-port", 0, "Upstream listener port (server)")
-	downstreamPort := fs.Int("downstream-port", 0, "Downstream listener port (server)")
-	tlsCert := fs.String("tls-cert", "", "TLS certificate path")
-	tlsKey := fs.String("tls-key", "", "TLS key path")
-	
-	// Client flags
-	upstreamURL := fs.String("upstream-url", "", "Upstream server URL (client)")
-	downstreamURL := fs.String("downstream-url", "", "Downstream server URL (client)")
-	portForwards := fs.StringArray("port-forward", nil, "Port forward specification (can be specified multiple times)")
-	socks5Port := fs.Int("socks5-port", 0, "SOCKS5 proxy port (client)")
-	
-	fs.Usage = func() {
-		fmt.Println(`Generate a new configuration file
-
-Usage:
-  half-tunnel config generate --type <client|server> [options]
-
-Options:`)
-		fs.PrintDefaults()
-		fmt.Println(`
-Examples:
-  # Generate server config interactively
-  half-tunnel config generate --type server --output server.yml
-
-  # Generate client config interactively
-  half-tunnel config generate --type client --output client.yml
-
-  # Generate client config with flags (non-interactive)
-  half-tunnel config generate --type client \
-    --upstream-url "wss://domain-a.example.com:8443/ws/upstream" \
-    --downstream-url "wss://domain-b.example.com:8444/ws/downstream" \
-    --port-forward "2083" \
-    --port-forward "8080:example.com:80" \
-    --socks5-port 1080 \
-    --output client.yml
-
-  # Generate server config with flags
-  half-tunnel config generate --type server \
-    --upstream-port 8443 \
-    --downstream-port 8444 \
-    --tls-cert /path/to/cert.pem \
-    --tls-key /path/to/key.pem \
-    --output server.yml
-
-Port forward formats:
-  - "2083"                    Listen on 2083, forward to remote:2083
-  - "8080:80"                 Listen on 8080, forward to remote:80
-  - "8080:example.com:80"     Listen on 8080, forward to example.com:80`)
-	}
-	
-	if err := fs.Parse(args); err != nil {
-		os.Exit(1)
-	}
-	
-	if *configType == "" {
-		fmt.Fprintln(os.Stderr, "Error: --type is required")
-		fs.Usage()
-		os.Exit(1)
-	}
-	
-	// Determine if we should run interactively (when no CLI options are provided)
-	hasNonInteractiveOptions := *upstreamPort > 0 ||
-		*downstreamPort > 0 ||
-		*upstreamURL != "" ||
-		*downstreamURL != "" ||
-		len(*portForwards) > 0 ||
-		*socks5Port > 0
-	
-	opts := config.GenerateOptions{
-		OutputPath:     *output,
-		UpstreamPort:   *upstreamPort,
-		DownstreamPort: *downstreamPort,
-		TLSCert:        *tlsCert,
-		TLSKey:         *tlsKey,
-		UpstreamURL:    *upstreamURL,
-		DownstreamURL:  *downstreamURL,
-		PortForwards:   *portForwards,
-		SOCKS5Port:     *socks5Port,
-		EnableSOCKS5:   *socks5Port > 0,
-	}
-	
-	var generator *config.ConfigGenerator
-	if hasNonInteractiveOptions {
-		generator = config.NewNonInteractiveGenerator()
-	} else {
-		generator = config.NewInteractiveGenerator()
-	}
-	
-	switch *configType {
-	case "client":
-		clientCfg, err := generator.GenerateClientConfig(opts)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error generating client config: %v\n", err)
-			os.Exit(1)
-		}
-		
-		if *output != "" {
-			if err := config.WriteClientConfigToFile(clientCfg, *output); err != nil {
-				fmt.Fprintf(os.Stderr, "Error writing config: %v\n", err)
-				os.Exit(1)
-			}
-			fmt.Printf("✅ Configuration saved to: %s\n", *output)
-		} else {
-			content, _ := config.RenderClientConfigYAML(clientCfg)
-			fmt.Println(content)
-		}
-		
-	case "server":
-		serverCfg, err := generator.GenerateServerConfig(opts)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error generating server config: %v\n", err)
-			os.Exit(1)
-		}
-		
-		if *output != "" {
-			if err := config.WriteServerConfigToFile(serverCfg, *output); err != nil {
-				fmt.Fprintf(os.Stderr, "Error writing config: %v\n", err)
-				os.Exit(1)
-			}
-			fmt.Printf("✅ Configuration saved to: %s\n", *output)
-		} else {
-			content, _ := config.RenderServerConfigYAML(serverCfg)
-			fmt.Println(content)
-		}
-		
-	default:
-		fmt.Fprintf(os.Stderr, "Error: unknown config type: %s (use 'client' or 'server')\n", *configType)
-		os.Exit(1)
-	}
-}
-
 func runConfigValidate(args []string) {
 	fs := pflag.NewFlagSet("validate", pflag.ExitOnError)
 	
@@ -315,3 +201,158 @@ Options:`)
 		os.Exit(1)
 	}
 }
+
+func runGuestCommand(args []string) {
+	if len(args) == 0 {
+		printGuestUsage()
+		os.Exit(0)
+	}
+	
+	switch args[0] {
+	case "issue":
+		runGuestIssue(args[1:])
+	case "help", "--help", "-h":
+		printGuestUsage()
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown guest subcommand: %s\n", args[0])
+		printGuestUsage()
+		os.Exit(1)
+	}
+}
+
+func printGuestUsage() {
+	fmt.Println(`Manage guest access tokens
+
+Usage:
+  half-tunnel guest <subcommand> [options]
+
+Subcommands:
+  issue    Issue a new guest token
+
+Use "half-tunnel guest <subcommand> --help" for more information.`)
+}
+
+func runGuestIssue(args []string) {
+	fs := pflag.NewFlagSet("issue", pflag.ExitOnError)
+	
+	configPath := fs.String("config", "", "Path to server configuration file (reads access.guest.secret)")
+	secret := fs.String("secret", "", "Guest secret (overrides the config file)")
+	ttl := fs.Duration("ttl", 24*time.Hour, "How long the token and its sessions stay valid")
+	trafficCap := fs.String("cap", "0", "Traffic cap for the token, e.g. 500MB or 2GB (0 = unlimited)")
+	
+	fs.Usage = func() {
+		fmt.Println(`Issue a new guest token
+
+Usage:
+  half-tunnel guest issue --config <server.yml> [--ttl 24h] [--cap 1GB]
+
+Options:`)
+		fs.PrintDefaults()
+	}
+	
+	if err := fs.Parse(args); err != nil {
+		os.Exit(1)
+	}
+	
+	if *secret == "" && *configPath != "" {
+		cfg, err := config.LoadServerConfigFromFile(*configPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		*secret = cfg.Access.Guest.Secret
+	}
+	if *secret == "" {
+		fmt.Fprintln(os.Stderr, "Error: a guest secret is required (--secret or access.guest.secret in --config)")
+		fs.Usage()
+		os.Exit(1)
+	}
+	
+	capBytes, err := config.ParseByteSize(*trafficCap)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	
+	token, info, err := guest.Issue(*secret, *ttl, capBytes)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	
+	capLabel := "unlimited"
+	if capBytes > 0 {
+		capLabel = config.FormatByteSize(capBytes)
+	}
+	
+	fmt.Fprintf(os.Stderr, "Guest token %s expires %s, traffic cap %s\n", info.ID, info.ExpiresAt.Format(time.RFC3339), capLabel)
+	fmt.Fprintln(os.Stderr, "Set it as client.guest_token in the client configuration:")
+	fmt.Println(token)
+}
+
+func runTokenCommand(args []string) {
+	if len(args) == 0 {
+		printTokenUsage()
+		os.Exit(0)
+	}
+	
+	switch args[0] {
+	case "generate":
+		runTokenGenerate(args[1:])
+	case "help", "--help", "-h":
+		printTokenUsage()
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown token subcommand: %s\n", args[0])
+		printTokenUsage()
+		os.Exit(1)
+	}
+}
+
+func printTokenUsage() {
+	fmt.Println(`Manage client authentication tokens
+
+Usage:
+  half-tunnel token <subcommand> [options]
+
+Subcommands:
+  generate    Generate a new client token
+
+Use "half-tunnel token <subcommand> --help" for more information.`)
+}
+
+func runTokenGenerate(args []string) {
+	fs := pflag.NewFlagSet("generate", pflag.ExitOnError)
+	
+	name := fs.String("name", "", "Client name the server identifies the token with (required)")
+	
+	fs.Usage = func() {
+		fmt.Println(`Generate a new client token
+
+Usage:
+  half-tunnel token generate --name <client>
+
+Options:`)
+		fs.PrintDefaults()
+	}
+	
+	if err := fs.Parse(args); err != nil {
+		os.Exit(1)
+	}
+	
+	if *name == "" {
+		fmt.Fprintln(os.Stderr, "Error: --name is required")
+		fs.Usage()
+		os.Exit(1)
+	}
+	
+	token, err := clientauth.Generate()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	
+	fmt.Fprintln(os.Stderr, "Add the client to access.client_auth.clients in the server configuration:")
+	fmt.Fprintf(os.Stderr, "  - name: %q\n    token_hash: %q\n", *name, clientauth.Hash(token))
+	fmt.Fprintln(os.Stderr, "Set the token as client.auth_token in the client configuration:")
+	fmt.Println(token)
+}
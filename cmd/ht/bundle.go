@@ -0,0 +1,257 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"time"
+
+	"github.com/sahmadiut/half-tunnel/internal/admin"
+	"github.com/sahmadiut/half-tunnel/internal/config"
+	"github.com/sahmadiut/half-tunnel/internal/doctor"
+	"github.com/sahmadiut/half-tunnel/internal/service"
+	"github.com/spf13/pflag"
+)
+
+// runSupportBundle collects diagnostics of the given services into a
+// tarball for bug reports. With no service given it collects every service
+// that is installed or has a config in the default location.
+func runSupportBundle(svcTypes []service.ServiceType, args []string) {
+	fs := pflag.NewFlagSet("support-bundle", pflag.ExitOnError)
+
+	now := time.Now()
+	output := fs.StringP("output", "o", fmt.Sprintf("half-tunnel-support-%s.tar.gz", now.Format("20060102-150405")), "Path of the tarball to write")
+	configPath := fs.StringP("config", "c", "", "Path to the config file (default: the installed service's, else the default path)")
+	lines := fs.IntP("lines", "n", 1000, "Number of log lines to collect")
+
+	fs.Usage = func() {
+		fmt.Printf(`Collect diagnostics into a tarball for bug reports
+
+Usage:
+  ht support-bundle [options]
+  ht <service> support-bundle [options]
+
+Collects version information, the service status, recent logs, the config
+with secrets redacted, doctor findings, and from the running process a
+metrics snapshot, the admin API status and a goroutine dump. Metrics need
+observability.metrics and the status and goroutine dump
+observability.admin to be enabled; missing parts are listed in errors.txt.
+
+Options:
+`)
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		os.Exit(1)
+	}
+
+	if len(svcTypes) == 0 {
+		svcTypes = detectServices("support-bundle")
+	}
+
+	file, err := os.OpenFile(*output, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Failed to create support bundle: %v\n", err)
+		os.Exit(1)
+	}
+	defer file.Close()
+
+	gz := gzip.NewWriter(file)
+	b := &supportBundle{
+		tw:      tar.NewWriter(gz),
+		dir:     fmt.Sprintf("half-tunnel-support-%s", now.Format("20060102-150405")),
+		modTime: now,
+	}
+
+	b.add("version.txt", []byte(fmt.Sprintf("ht %s (commit: %s, built: %s)\nGo %s %s/%s\nInit system: %s\nCollected: %s\n",
+		version, commit, buildDate, runtime.Version(), runtime.GOOS, runtime.GOARCH,
+		service.DetectInitSystem().Name(), now.Format(time.RFC3339))))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	for _, t := range svcTypes {
+		fmt.Printf("Collecting %s diagnostics...\n", t)
+		b.collect(ctx, t, *configPath, *lines)
+	}
+
+	if b.errors.Len() > 0 {
+		b.add("errors.txt", b.errors.Bytes())
+	}
+	if err := b.close(gz); err != nil {
+		fmt.Fprintf(os.Stderr, "❌ Failed to write support bundle: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("✅ Support bundle written to %s\n", *output)
+	fmt.Println("   Secrets in the config are redacted, but logs may contain addresses and")
+	fmt.Println("   host names; review the bundle before sharing it.")
+}
+
+// supportBundle writes the files of a support bundle into a tarball.
+type supportBundle struct {
+	tw      *tar.Writer
+	dir     string
+	modTime time.Time
+	errors  bytes.Buffer
+	err     error
+}
+
+// add writes a file into the bundle.
+func (b *supportBundle) add(name string, data []byte) {
+	if b.err != nil {
+		return
+	}
+	header := &tar.Header{
+		Name:    b.dir + "/" + name,
+		Mode:    0600,
+		Size:    int64(len(data)),
+		ModTime: b.modTime,
+	}
+	if b.err = b.tw.WriteHeader(header); b.err != nil {
+		return
+	}
+	_, b.err = b.tw.Write(data)
+}
+
+// fail records a part that could not be collected.
+func (b *supportBundle) fail(part string, err error) {
+	fmt.Fprintf(os.Stderr, "  ⚠️  %s: %v\n", part, err)
+	fmt.Fprintf(&b.errors, "%s: %v\n", part, err)
+}
+
+// close finishes the tarball.
+func (b *supportBundle) close(gz *gzip.Writer) error {
+	if b.err != nil {
+		return b.err
+	}
+	if err := b.tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// collect adds the diagnostics of one service to the bundle.
+func (b *supportBundle) collect(ctx context.Context, t service.ServiceType, configPath string, lines int) {
+	binaryPath := service.GetDefaultBinaryPath(t)
+	installedConfig := service.GetDefaultConfigPath(t)
+	if service.IsInstalled(t) {
+		if binary, cfgPath, err := service.InstalledPaths(t); err == nil {
+			binaryPath, installedConfig = binary, cfgPath
+		}
+	}
+	if configPath == "" {
+		configPath = installedConfig
+	}
+	dir := string(t) + "/"
+
+	if out, err := exec.CommandContext(ctx, binaryPath, "-version").CombinedOutput(); err != nil {
+		b.fail(dir+"version.txt", err)
+	} else {
+		b.add(dir+"version.txt", out)
+	}
+
+	if service.IsInstalled(t) {
+		// Status returns an error for a stopped service
+		status, _ := service.Status(t)
+		b.add(dir+"status.txt", []byte(status))
+		if logs, err := service.LogsOutput(t, lines); err != nil && logs == "" {
+			b.fail(dir+"logs.txt", err)
+		} else {
+			b.add(dir+"logs.txt", []byte(logs))
+		}
+	} else {
+		b.add(dir+"status.txt", []byte(fmt.Sprintf("Service %s is not installed.\n", service.ServiceName(t))))
+	}
+
+	if redacted, err := config.RedactConfigFile(configPath); err != nil {
+		b.fail(dir+"config", err)
+	} else {
+		b.add(dir+"config"+filepath.Ext(configPath), redacted)
+	}
+
+	var findings bytes.Buffer
+	for _, f := range doctor.Run(ctx, doctor.Options{Service: t, ConfigPath: configPath, BinaryPath: binaryPath}) {
+		fmt.Fprintf(&findings, "%-5s %-12s %s\n", f.Status, f.Check, f.Message)
+		if f.Fix != "" && f.Status != doctor.StatusOK {
+			fmt.Fprintf(&findings, "      %-12s → %s\n", "", f.Fix)
+		}
+	}
+	b.add(dir+"doctor.txt", findings.Bytes())
+
+	if url, err := metricsEndpoint(t, configPath); err != nil {
+		b.fail(dir+"metrics.txt", err)
+	} else if metrics, err := httpGet(ctx, url); err != nil {
+		b.fail(dir+"metrics.txt", err)
+	} else {
+		b.add(dir+"metrics.txt", metrics)
+	}
+
+	baseURL, token, err := adminEndpoint(t, configPath)
+	if err != nil {
+		b.fail(dir+"admin-status.json", err)
+		b.fail(dir+"goroutines.txt", err)
+		return
+	}
+	if status, err := admin.FetchStatus(ctx, baseURL, token); err != nil {
+		b.fail(dir+"admin-status.json", err)
+	} else if data, err := json.MarshalIndent(status, "", "  "); err == nil {
+		b.add(dir+"admin-status.json", append(data, '\n'))
+	}
+	if dump, err := admin.FetchGoroutines(ctx, baseURL, token); err != nil {
+		b.fail(dir+"goroutines.txt", err)
+	} else {
+		b.add(dir+"goroutines.txt", dump)
+	}
+}
+
+// metricsEndpoint returns the Prometheus metrics URL from the service config.
+func metricsEndpoint(svcType service.ServiceType, configPath string) (string, error) {
+	var cfg config.MetricsConfig
+	if svcType == service.ClientService {
+		c, err := config.LoadClientConfig(configPath)
+		if err != nil {
+			return "", fmt.Errorf("failed to load configuration: %w", err)
+		}
+		cfg = c.Observability.Metrics
+	} else {
+		c, err := config.LoadServerConfig(configPath)
+		if err != nil {
+			return "", fmt.Errorf("failed to load configuration: %w", err)
+		}
+		cfg = c.Observability.Metrics
+	}
+
+	if !cfg.Enabled {
+		return "", fmt.Errorf("metrics are disabled; set observability.metrics.enabled in %s", configPath)
+	}
+	return "http://127.0.0.1:" + strconv.Itoa(cfg.Port) + cfg.Path, nil
+}
+
+// httpGet returns the body of a successful GET request.
+func httpGet(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/sahmadiut/half-tunnel/internal/doctor"
+	"github.com/sahmadiut/half-tunnel/internal/service"
+	"github.com/spf13/pflag"
+)
+
+// runDoctor checks the environment of the given services. With no service
+// given it checks every service that is installed or has a config in the
+// default location.
+func runDoctor(svcTypes []service.ServiceType, args []string) {
+	fs := pflag.NewFlagSet("doctor", pflag.ExitOnError)
+
+	configPath := fs.StringP("config", "c", "", "Path to the config file (default: the installed service's, else the default path)")
+	binaryPath := fs.StringP("binary", "b", "", "Path to the binary (default: the installed service's, else the default path)")
+
+	fs.Usage = func() {
+		fmt.Printf(`Check the environment for problems
+
+Usage:
+  ht doctor
+  ht <service> doctor [options]
+
+Checks the init system, the service binary and config, that the configured
+listener ports can be bound, certificate expiry and the system clock, and
+suggests a fix for each problem found.
+
+Options:
+`)
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		os.Exit(1)
+	}
+
+	if len(svcTypes) == 0 {
+		svcTypes = detectServices("doctor")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	failed := false
+	for i, t := range svcTypes {
+		if i > 0 {
+			fmt.Println()
+		}
+		opts := doctor.Options{
+			Service:    t,
+			ConfigPath: service.GetDefaultConfigPath(t),
+			BinaryPath: service.GetDefaultBinaryPath(t),
+		}
+		if *configPath != "" {
+			opts.ConfigPath = *configPath
+		}
+		if *binaryPath != "" {
+			opts.BinaryPath = *binaryPath
+		}
+
+		fmt.Printf("Half-Tunnel %s\n", t)
+		findings := doctor.Run(ctx, opts)
+		for _, f := range findings {
+			fmt.Printf("  %s %-12s %s\n", doctorIcon(f.Status), f.Check, f.Message)
+			if f.Fix != "" && f.Status != doctor.StatusOK {
+				fmt.Printf("     %-12s → %s\n", "", f.Fix)
+			}
+		}
+		failed = failed || doctor.Failed(findings)
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// detectServices returns the services that are installed or have a config
+// in the default location, exiting if there are none.
+func detectServices(command string) []service.ServiceType {
+	var svcTypes []service.ServiceType
+	for _, t := range []service.ServiceType{service.ClientService, service.ServerService} {
+		if _, err := os.Stat(service.GetDefaultConfigPath(t)); err == nil || service.IsInstalled(t) {
+			svcTypes = append(svcTypes, t)
+		}
+	}
+	if len(svcTypes) == 0 {
+		fmt.Fprintf(os.Stderr, "❌ No service is installed and no config was found in /etc/half-tunnel\n")
+		fmt.Fprintf(os.Stderr, "   Run 'ht client %s --config <path>' or 'ht server %s --config <path>'\n", command, command)
+		os.Exit(1)
+	}
+	return svcTypes
+}
+
+// doctorIcon returns the marker printed before a finding.
+func doctorIcon(s doctor.Status) string {
+	switch s {
+	case doctor.StatusOK:
+		return "✅"
+	case doctor.StatusWarn:
+		return "⚠️ "
+	case doctor.StatusFail:
+		return "❌"
+	default:
+		return "➖"
+	}
+}
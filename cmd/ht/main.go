@@ -2,12 +2,28 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
+	"net"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
 	"strconv"
-
+	"strings"
+	"time"
+
+	"github.com/sahmadiut/half-tunnel/internal/admin"
+	"github.com/sahmadiut/half-tunnel/internal/certgen"
+	"github.com/sahmadiut/half-tunnel/internal/config"
+	"github.com/sahmadiut/half-tunnel/internal/control"
+	"github.com/sahmadiut/half-tunnel/internal/diag"
 	"github.com/sahmadiut/half-tunnel/internal/service"
+	"github.com/sahmadiut/half-tunnel/internal/usage"
 	"github.com/spf13/pflag"
+	"golang.org/x/net/proxy"
 )
 
 var (
@@ -35,6 +51,14 @@ func main() {
 		runClientCommand(os.Args[2:])
 	case "server", "s":
 		runServerCommand(os.Args[2:])
+	case "bench":
+		runBench(service.ClientService, os.Args[2:])
+	case "top":
+		runTop(service.ClientService, os.Args[2:])
+	case "doctor":
+		runDoctor(nil, os.Args[2:])
+	case "support-bundle":
+		runSupportBundle(nil, os.Args[2:])
 	case "help", "--help", "-h":
 		printUsage()
 	default:
@@ -53,17 +77,28 @@ Usage:
 Services:
   client, c    Manage the client service
   server, s    Manage the server service
+  bench        Shortcut for "ht client bench"
+  top          Shortcut for "ht client top"
+  doctor       Check the environment of installed services
+  support-bundle  Collect diagnostics of installed services for a bug report
 
 Commands:
-  install      Install the systemd service
-  uninstall    Remove the systemd service
+  install      Install the service (systemd, OpenRC, runit, plain, launchd or Windows)
+  uninstall    Remove the service
   start        Start the service
   stop         Stop the service
   restart      Restart the service
   enable       Enable service autostart on boot
   disable      Disable service autostart
-  status       Show service status
+  status       Show service status (--all for live tunnel state)
   logs         View service logs (default: follow mode)
+  usage        Show daily traffic totals (client only)
+  ctl          Send a runtime command to the running service
+  forward      Add, list or remove port forwards at runtime (client only)
+  bench        Measure tunnel latency and throughput (client only)
+  top          Show the busiest streams, refreshed live
+  doctor       Check the environment for problems
+  support-bundle  Collect logs, redacted config and diagnostics into a tarball
 
 Flags:
   -v, --version    Show version information
@@ -75,6 +110,14 @@ Examples:
   ht client logs
   ht server logs -n 50
   ht c restart
+  ht client usage --since 7d
+  ht c status --all
+  ht s ctl set-log-level debug
+  ht c forward add 8443:example.com:443
+  ht bench --duration 10s
+  ht s top
+  ht doctor
+  ht support-bundle
 
 Use "ht <service> <command> --help" for more information.`)
 }
@@ -109,9 +152,23 @@ func runServiceCommand(svcType service.ServiceType, args []string) {
 	case "disable":
 		runDisable(svcType)
 	case "status":
-		runStatus(svcType)
+		runStatus(svcType, args[1:])
 	case "logs", "log", "l":
 		runLogs(svcType, args[1:])
+	case "usage":
+		runUsage(svcType, args[1:])
+	case "ctl":
+		runCtl(svcType, args[1:])
+	case "forward", "fwd":
+		runForward(svcType, args[1:])
+	case "bench":
+		runBench(svcType, args[1:])
+	case "top":
+		runTop(svcType, args[1:])
+	case "doctor":
+		runDoctor([]service.ServiceType{svcType}, args[1:])
+	case "support-bundle":
+		runSupportBundle([]service.ServiceType{svcType}, args[1:])
 	case "help", "--help", "-h":
 		printServiceUsage(svcType)
 	default:
@@ -128,20 +185,30 @@ Usage:
   ht %s <command> [options]
 
 Commands:
-  install      Install the systemd service
-  uninstall    Remove the systemd service
+  install      Install the service (systemd, OpenRC, runit, plain, launchd or Windows)
+  uninstall    Remove the service
   start        Start the service
   stop         Stop the service
   restart      Restart the service
   enable       Enable service autostart on boot
   disable      Disable service autostart
-  status       Show service status
+  status       Show service status (--all for live tunnel state)
   logs, log, l View service logs
+  usage        Show daily traffic totals (client only)
+  ctl          Send a runtime command to the running service
+  forward      Add, list or remove port forwards at runtime (client only)
+  bench        Measure tunnel latency and throughput (client only)
+  top          Show the busiest streams, refreshed live
+  doctor       Check the environment for problems
+  support-bundle  Collect logs, redacted config and diagnostics into a tarball
 
 Install Options:
   --binary, -b   Path to the binary (default: %s)
   --config, -c   Path to the config file (default: %s)
   --user, -u     User to run the service as (default: root)
+  --init         Init system: systemd, openrc, runit, plain, launchd or windows (default: detected)
+  --self-signed-tls <domain>
+                 Generate a self-signed certificate and enable TLS (server only)
 
 Logs Options:
   -f, --follow   Follow log output (default: true)
@@ -165,13 +232,22 @@ func runInstall(svcType service.ServiceType, args []string) {
 	binaryPath := fs.StringP("binary", "b", service.GetDefaultBinaryPath(svcType), "Path to the binary")
 	configPath := fs.StringP("config", "c", service.GetDefaultConfigPath(svcType), "Path to the config file")
 	user := fs.StringP("user", "u", "root", "User to run the service as")
+	initSystem := fs.String("init", "", "Init system: "+strings.Join(service.InitSystemNames(), ", ")+" (default: detected)")
+	var selfSignedTLS []string
+	if svcType == service.ServerService {
+		fs.StringArrayVar(&selfSignedTLS, "self-signed-tls", nil, "Generate a self-signed certificate for this domain or IP and enable TLS in the config (can be specified multiple times)")
+	}
 
 	fs.Usage = func() {
-		fmt.Printf(`Install the %s systemd service
+		fmt.Printf(`Install the %s service
 
 Usage:
   ht %s install [options]
 
+The init system is detected: systemd, OpenRC or runit, falling back to a
+plain background process with a pidfile (no restarts or boot start). On
+macOS launchd and on Windows the service control manager is used.
+
 Options:
 `, svcType, svcType)
 		fs.PrintDefaults()
@@ -186,11 +262,21 @@ Options:
 		fmt.Fprintf(os.Stderr, "Warning: Could not create config directory: %v\n", err)
 	}
 
+	var fingerprint string
+	if len(selfSignedTLS) > 0 {
+		var err error
+		if fingerprint, err = installSelfSignedTLS(selfSignedTLS, *configPath); err != nil {
+			fmt.Fprintf(os.Stderr, "❌ Failed to set up TLS: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
 	cfg := &service.ServiceConfig{
 		Type:       svcType,
 		BinaryPath: *binaryPath,
 		ConfigPath: *configPath,
 		User:       *user,
+		InitSystem: *initSystem,
 	}
 
 	if err := service.Install(cfg); err != nil {
@@ -200,6 +286,46 @@ Options:
 
 	fmt.Printf("✅ Service %s installed successfully!\n", service.ServiceName(svcType))
 	service.PrintServiceInfo(svcType)
+
+	if fingerprint != "" {
+		certFile := filepath.Join(certgen.DefaultDir, "server.crt")
+		fmt.Printf("\n🔒 TLS enabled with a self-signed certificate for %s\n", strings.Join(selfSignedTLS, ", "))
+		fmt.Printf("  Public key fingerprint (SHA-256): %s\n", fingerprint)
+		fmt.Printf("  Pin it on both paths of the client with tls.pin_sha256: [%q],\n", fingerprint)
+		fmt.Printf("  or copy %s to the client and set it as tls.ca_file.\n", certFile)
+	}
+}
+
+// installSelfSignedTLS creates a self-signed certificate for hosts in the
+// default certificate directory, unless one exists, and enables TLS with it
+// in the server config. It returns the certificate's public key fingerprint.
+func installSelfSignedTLS(hosts []string, configPath string) (string, error) {
+	certFile := filepath.Join(certgen.DefaultDir, "server.crt")
+	keyFile := filepath.Join(certgen.DefaultDir, "server.key")
+
+	// Keep an existing certificate so reinstalling does not break clients
+	certPEM, err := os.ReadFile(certFile)
+	if os.IsNotExist(err) {
+		var keyPEM []byte
+		certPEM, keyPEM, err = certgen.SelfSigned(hosts, 365*24*time.Hour)
+		if err != nil {
+			return "", err
+		}
+		if err := certgen.WriteFiles(certFile, keyFile, certPEM, keyPEM, false); err != nil {
+			return "", err
+		}
+		fmt.Printf("✅ Certificate saved to %s, key to %s\n", certFile, keyFile)
+	} else if err != nil {
+		return "", err
+	} else {
+		fmt.Printf("Using the existing certificate %s\n", certFile)
+	}
+
+	if err := config.EnableServerTLS(configPath, certFile, keyFile); err != nil {
+		return "", err
+	}
+	fmt.Printf("✅ TLS enabled for both listeners in %s\n", configPath)
+	return certgen.Fingerprint(certPEM)
 }
 
 func runUninstall(svcType service.ServiceType, args []string) {
@@ -291,14 +417,182 @@ func runDisable(svcType service.ServiceType) {
 	fmt.Printf("✅ Service %s disabled from autostart!\n", service.ServiceName(svcType))
 }
 
-func runStatus(svcType service.ServiceType) {
-	if !service.IsInstalled(svcType) {
+func runStatus(svcType service.ServiceType, args []string) {
+	fs := pflag.NewFlagSet("status", pflag.ExitOnError)
+
+	all := fs.BoolP("all", "a", false, "Also show live tunnel state from the admin API")
+	configPath := fs.StringP("config", "c", service.GetDefaultConfigPath(svcType), "Path to the config file")
+	adminURL := fs.String("admin-url", "", "Admin API base URL (default: from config)")
+	token := fs.String("token", "", "Admin API token (default: from config)")
+	interval := fs.Duration("interval", time.Second, "Sampling interval for throughput")
+
+	fs.Usage = func() {
+		fmt.Printf(`Show status of the %s service
+
+Usage:
+  ht %s status [options]
+
+Options:
+`, svcType, svcType)
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		os.Exit(1)
+	}
+
+	if service.IsInstalled(svcType) {
+		status, _ := service.Status(svcType)
+		fmt.Println(status)
+	} else {
 		fmt.Printf("Service %s is not installed.\n", service.ServiceName(svcType))
+	}
+
+	if !*all {
+		return
+	}
+
+	baseURL, adminToken, err := adminEndpoint(svcType, *configPath)
+	if *adminURL != "" {
+		baseURL, err = *adminURL, nil
+	}
+	if *token != "" {
+		adminToken = *token
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		os.Exit(1)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *interval+10*time.Second)
+	defer cancel()
+
+	first, err := admin.FetchStatus(ctx, baseURL, adminToken)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		os.Exit(1)
+	}
+	time.Sleep(*interval)
+	second, err := admin.FetchStatus(ctx, baseURL, adminToken)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		os.Exit(1)
+	}
+
+	printLiveStatus(baseURL, first, second, *interval)
+}
+
+// adminEndpoint returns the admin API base URL and token from the service config.
+func adminEndpoint(svcType service.ServiceType, configPath string) (string, string, error) {
+	var cfg config.AdminConfig
+	if svcType == service.ClientService {
+		c, err := config.LoadClientConfig(configPath)
+		if err != nil {
+			return "", "", fmt.Errorf("failed to load configuration: %w", err)
+		}
+		cfg = c.Observability.Admin
+	} else {
+		c, err := config.LoadServerConfig(configPath)
+		if err != nil {
+			return "", "", fmt.Errorf("failed to load configuration: %w", err)
+		}
+		cfg = c.Observability.Admin
+	}
+
+	if !cfg.Enabled {
+		return "", "", fmt.Errorf("admin API is disabled; set observability.admin.enabled in %s", configPath)
+	}
+	// A wildcard listen address is reachable on loopback
+	if cfg.Listen == "" || cfg.Listen == "0.0.0.0" || cfg.Listen == "::" {
+		cfg.Listen = "127.0.0.1"
+	}
+	return "http://" + cfg.Addr() + cfg.Path, cfg.Token, nil
+}
+
+// printLiveStatus prints tunnel state, computing throughput from two
+// snapshots taken interval apart.
+func printLiveStatus(baseURL string, first, second *admin.Status, interval time.Duration) {
+	state := "disconnected"
+	if second.Connected {
+		state = "connected"
+	}
+	if second.Role == "server" {
+		state = "running"
+	}
+
+	seconds := interval.Seconds()
+	up := float64(second.Traffic.BytesSent-first.Traffic.BytesSent) / seconds
+	down := float64(second.Traffic.BytesReceived-first.Traffic.BytesReceived) / seconds
+
+	fmt.Printf("\nLive state (%s):\n", baseURL)
+	fmt.Printf("  %-16s %s\n", "State:", state)
+	fmt.Printf("  %-16s %s\n", "Uptime:", time.Since(second.StartedAt).Round(time.Second))
+	if second.Role == "client" && len(second.Sessions) > 0 {
+		fmt.Printf("  %-16s %s\n", "Session:", second.Sessions[0].ID)
+	} else {
+		fmt.Printf("  %-16s %d\n", "Sessions:", len(second.Sessions))
+	}
+	fmt.Printf("  %-16s %d\n", "Active streams:", len(second.Streams))
+	fmt.Printf("  %-16s %s/s sent, %s/s received\n", "Throughput:",
+		config.FormatByteSize(int64(up)), config.FormatByteSize(int64(down)))
+	fmt.Printf("  %-16s %s sent, %s received\n", "Traffic:",
+		config.FormatByteSize(second.Traffic.BytesSent), config.FormatByteSize(second.Traffic.BytesReceived))
+
+	if second.Role == "client" {
+		fmt.Printf("  %-16s %s\n", "Last reconnect:", describeLastReconnect(second.Reconnects))
+		fmt.Printf("  %-16s %s\n", "Path RTT:", describePathRTT(second.Paths))
+	}
+
+	if len(second.Streams) == 0 {
 		return
 	}
 
-	status, _ := service.Status(svcType)
-	fmt.Println(status)
+	streams := second.Streams
+	sort.Slice(streams, func(i, j int) bool {
+		return streams[i].BytesUp+streams[i].BytesDown > streams[j].BytesUp+streams[j].BytesDown
+	})
+	if len(streams) > 10 {
+		streams = streams[:10]
+	}
+
+	fmt.Printf("\n  %-8s %-12s %-32s %10s %10s\n", "STREAM", "FORWARD", "DESTINATION", "UP", "DOWN")
+	for _, st := range streams {
+		fmt.Printf("  %-8d %-12s %-32s %10s %10s\n", st.StreamID, st.Forward, st.Dest,
+			config.FormatByteSize(st.BytesUp), config.FormatByteSize(st.BytesDown))
+	}
+}
+
+// describeLastReconnect summarizes the most recent reconnect cycle.
+func describeLastReconnect(history []admin.Reconnect) string {
+	if len(history) == 0 {
+		return "never"
+	}
+
+	last := history[len(history)-1]
+	result := "succeeded"
+	if !last.Success {
+		result = "failed"
+		if last.Error != "" {
+			result += ": " + last.Error
+		}
+	}
+	return fmt.Sprintf("%s ago (%s, %d attempts, %s)",
+		time.Since(last.Time).Round(time.Second), last.Source, last.Attempts, result)
+}
+
+// describePathRTT summarizes the smoothed round-trip time of each path.
+func describePathRTT(paths []admin.Path) string {
+	var parts []string
+	for _, p := range paths {
+		if p.Samples == 0 {
+			continue
+		}
+		parts = append(parts, fmt.Sprintf("%s %s", p.Path, p.RTT.Round(time.Millisecond)))
+	}
+	if len(parts) == 0 {
+		return "not measured yet"
+	}
+	return strings.Join(parts, ", ")
 }
 
 func runLogs(svcType service.ServiceType, args []string) {
@@ -348,3 +642,371 @@ Press Ctrl+C to stop following logs.`)
 		}
 	}
 }
+
+func runUsage(svcType service.ServiceType, args []string) {
+	if svcType != service.ClientService {
+		fmt.Fprintf(os.Stderr, "❌ Usage reports are only available for the client\n")
+		os.Exit(1)
+	}
+
+	fs := pflag.NewFlagSet("usage", pflag.ExitOnError)
+
+	configPath := fs.StringP("config", "c", service.GetDefaultConfigPath(svcType), "Path to the config file")
+	stateFile := fs.String("state-file", "", "Path to the usage state file (default: from config)")
+	since := fs.StringP("since", "s", "7d", "Report period, in days (e.g. 7d) or as a duration (e.g. 48h)")
+	top := fs.IntP("top", "t", 5, "Number of top forwards to show (0 for all)")
+
+	fs.Usage = func() {
+		fmt.Printf(`Show daily traffic totals for the %s
+
+Usage:
+  ht %s usage [options]
+
+Options:
+`, svcType, svcType)
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		os.Exit(1)
+	}
+
+	period, err := parseSince(*since)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		os.Exit(1)
+	}
+
+	path := *stateFile
+	if path == "" {
+		cfg, err := config.LoadClientConfig(*configPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "❌ Failed to load configuration: %v\n", err)
+			os.Exit(1)
+		}
+		path = cfg.Observability.Usage.StateFile
+	}
+
+	state, err := usage.Load(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		os.Exit(1)
+	}
+
+	// Periods count whole calendar days, including today
+	now := time.Now()
+	start := now.Add(-period)
+	if strings.HasSuffix(*since, "d") {
+		start = now.AddDate(0, 0, -int(period/(24*time.Hour))+1)
+	}
+
+	days := state.DaysSince(start)
+	if len(days) == 0 {
+		fmt.Printf("No usage recorded since %s (%s)\n", start.Format(usage.DateFormat), path)
+		return
+	}
+
+	var total usage.Counters
+	fmt.Printf("%-12s %12s %12s %12s\n", "DATE", "UPLOAD", "DOWNLOAD", "TOTAL")
+	for _, day := range days {
+		fmt.Printf("%-12s %12s %12s %12s\n", day.Date,
+			config.FormatByteSize(day.Upload),
+			config.FormatByteSize(day.Download),
+			config.FormatByteSize(day.Total()))
+		total.Upload += day.Upload
+		total.Download += day.Download
+	}
+	fmt.Printf("%-12s %12s %12s %12s\n", "total",
+		config.FormatByteSize(total.Upload),
+		config.FormatByteSize(total.Download),
+		config.FormatByteSize(total.Total()))
+
+	forwards := state.TopForwards(start, *top)
+	if len(forwards) > 0 {
+		fmt.Printf("\n%-24s %12s %12s %12s\n", "FORWARD", "UPLOAD", "DOWNLOAD", "TOTAL")
+		for _, fwd := range forwards {
+			fmt.Printf("%-24s %12s %12s %12s\n", fwd.Name,
+				config.FormatByteSize(fwd.Upload),
+				config.FormatByteSize(fwd.Download),
+				config.FormatByteSize(fwd.Total()))
+		}
+	}
+}
+
+func runCtl(svcType service.ServiceType, args []string) {
+	fs := pflag.NewFlagSet("ctl", pflag.ExitOnError)
+	fs.SetInterspersed(false)
+
+	configPath := fs.StringP("config", "c", service.GetDefaultConfigPath(svcType), "Path to the config file")
+	socket := fs.StringP("socket", "s", "", "Control socket path (default: from config)")
+
+	fs.Usage = func() {
+		fmt.Printf(`Send a runtime command to the running %s
+
+Usage:
+  ht %s ctl [options] <command> [args]
+
+Commands:
+  reload                          Reload the configuration
+  dump-state                      Print sessions, streams and traffic as JSON
+  close-session <session-id>      Close a session and its streams
+  close-stream [session-id] <id>  Close one stream
+  set-log-level <level>           Change the log level (debug, info, warn, error)
+  set-log-level <comp>=<level>    Change one component's log level (or "default")
+
+Options:
+`, svcType, svcType)
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		os.Exit(1)
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	printResult(callControl(svcType, *configPath, *socket, fs.Arg(0), fs.Args()[1:]...))
+}
+
+func runForward(svcType service.ServiceType, args []string) {
+	if svcType != service.ClientService {
+		fmt.Fprintf(os.Stderr, "❌ Port forwards are only available for the client\n")
+		os.Exit(1)
+	}
+
+	fs := pflag.NewFlagSet("forward", pflag.ExitOnError)
+	fs.SetInterspersed(false)
+
+	configPath := fs.StringP("config", "c", service.GetDefaultConfigPath(svcType), "Path to the config file")
+	socket := fs.StringP("socket", "s", "", "Control socket path (default: from config)")
+
+	fs.Usage = func() {
+		fmt.Printf(`Manage port forwards of the running %s
+
+Usage:
+  ht %s forward [options] <command> [args]
+
+Commands:
+  list                                     List active port forwards
+  add <[[lhost:]listen:]host:port> [name]  Add a port forward (e.g. 127.0.0.1:8443:example.com:443)
+  remove <listen-port>                     Remove a port forward
+
+Changes apply immediately but are not written to the config file; a reload
+or restart restores the forwards from the config.
+
+Options:
+`, svcType, svcType)
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		os.Exit(1)
+	}
+
+	switch fs.Arg(0) {
+	case "list", "ls":
+		result := callControl(svcType, *configPath, *socket, "forward-list")
+		var forwards []admin.Forward
+		if err := json.Unmarshal(result, &forwards); err != nil {
+			fmt.Fprintf(os.Stderr, "❌ Unexpected response: %v\n", err)
+			os.Exit(1)
+		}
+		if len(forwards) == 0 {
+			fmt.Println("No port forwards")
+			return
+		}
+		fmt.Printf("%-20s %-22s %s\n", "NAME", "LISTEN", "REMOTE")
+		for _, f := range forwards {
+			fmt.Printf("%-20s %-22s %s\n", f.Name,
+				net.JoinHostPort(f.ListenHost, strconv.Itoa(f.ListenPort)),
+				net.JoinHostPort(f.RemoteHost, strconv.Itoa(f.RemotePort)))
+		}
+	case "add":
+		printResult(callControl(svcType, *configPath, *socket, "forward-add", fs.Args()[1:]...))
+	case "remove", "rm":
+		printResult(callControl(svcType, *configPath, *socket, "forward-remove", fs.Args()[1:]...))
+	default:
+		fs.Usage()
+		os.Exit(1)
+	}
+}
+
+func runBench(svcType service.ServiceType, args []string) {
+	if svcType != service.ClientService {
+		fmt.Fprintf(os.Stderr, "❌ Benchmarks run through the client\n")
+		os.Exit(1)
+	}
+
+	defaults := diag.DefaultBenchOptions()
+	fs := pflag.NewFlagSet("bench", pflag.ExitOnError)
+
+	configPath := fs.StringP("config", "c", service.GetDefaultConfigPath(svcType), "Path to the config file")
+	socksAddr := fs.String("socks", "", "SOCKS5 proxy of the running client (default: from config)")
+	duration := fs.DurationP("duration", "d", defaults.Duration, "Length of each throughput test")
+	pings := fs.IntP("pings", "n", defaults.Pings, "Number of echo round trips timed for latency")
+
+	fs.Usage = func() {
+		fmt.Printf(`Measure latency and throughput through the running %s
+
+Usage:
+  ht %s bench [options]
+
+Opens streams through the client's SOCKS5 proxy to the server's diagnostic
+targets (echo, discard and chargen), which must be enabled on the server with
+tunnel.diagnostics.enabled.
+
+Options:
+`, svcType, svcType)
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		os.Exit(1)
+	}
+	if *duration <= 0 || *pings <= 0 {
+		fmt.Fprintf(os.Stderr, "❌ --duration and --pings must be positive\n")
+		os.Exit(1)
+	}
+
+	addr := *socksAddr
+	var auth *proxy.Auth
+	if addr == "" {
+		cfg, err := config.LoadClientConfig(*configPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "❌ Failed to load configuration: %v\n", err)
+			os.Exit(1)
+		}
+		if !cfg.SOCKS5.Enabled {
+			fmt.Fprintf(os.Stderr, "❌ SOCKS5 proxy is disabled; set socks5.enabled in %s or pass --socks\n", *configPath)
+			os.Exit(1)
+		}
+		host := cfg.SOCKS5.ListenHost
+		// A wildcard listen address is reachable on loopback
+		if host == "" || host == "0.0.0.0" || host == "::" {
+			host = "127.0.0.1"
+		}
+		addr = net.JoinHostPort(host, strconv.Itoa(cfg.SOCKS5.ListenPort))
+		if cfg.SOCKS5.Auth.Enabled {
+			auth = &proxy.Auth{User: cfg.SOCKS5.Auth.Username, Password: cfg.SOCKS5.Auth.Password}
+		}
+	}
+
+	dialer, err := proxy.SOCKS5("tcp", addr, auth, proxy.Direct)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		os.Exit(1)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	opts := defaults
+	opts.Duration = *duration
+	opts.Pings = *pings
+
+	fmt.Printf("Benchmarking the tunnel through %s (%s per direction)...\n", addr, opts.Duration)
+	report, err := diag.Bench(ctx, dialer.(proxy.ContextDialer).DialContext, opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		fmt.Fprintf(os.Stderr, "   Is the client running and tunnel.diagnostics.enabled set on the server?\n")
+		os.Exit(1)
+	}
+
+	lat := report.Latency
+	fmt.Printf("\n  %-10s min %s, avg %s, max %s (%d round trips of %dB)\n", "Latency:",
+		lat.Min.Round(100*time.Microsecond), lat.Avg.Round(100*time.Microsecond), lat.Max.Round(100*time.Microsecond),
+		lat.Samples, opts.PingSize)
+	fmt.Printf("  %-10s %s\n", "Upload:", describeThroughput(report.Upload))
+	fmt.Printf("  %-10s %s\n", "Download:", describeThroughput(report.Download))
+}
+
+// describeThroughput formats a throughput test result.
+func describeThroughput(t diag.Throughput) string {
+	rate := t.BytesPerSecond()
+	return fmt.Sprintf("%s/s (%.1f Mbit/s), %s in %s",
+		config.FormatByteSize(int64(rate)), rate*8/1e6,
+		config.FormatByteSize(t.Bytes), t.Duration.Round(time.Millisecond))
+}
+
+// callControl sends a command to the service's control socket, exiting on
+// failure.
+func callControl(svcType service.ServiceType, configPath, socket, command string, args ...string) json.RawMessage {
+	path := socket
+	if path == "" {
+		var err error
+		path, err = controlSocket(svcType, configPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	result, err := control.Call(path, command, args...)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		os.Exit(1)
+	}
+	return result
+}
+
+// printResult prints a control result: strings as-is, anything else as
+// indented JSON.
+func printResult(result json.RawMessage) {
+	if len(result) == 0 {
+		return
+	}
+
+	var text string
+	if err := json.Unmarshal(result, &text); err == nil {
+		fmt.Println(text)
+		return
+	}
+	var pretty bytes.Buffer
+	if err := json.Indent(&pretty, result, "", "  "); err != nil {
+		fmt.Println(string(result))
+		return
+	}
+	fmt.Println(pretty.String())
+}
+
+// controlSocket returns the control socket path from the service config.
+func controlSocket(svcType service.ServiceType, configPath string) (string, error) {
+	var cfg config.ControlConfig
+	if svcType == service.ClientService {
+		c, err := config.LoadClientConfig(configPath)
+		if err != nil {
+			return "", fmt.Errorf("failed to load configuration: %w", err)
+		}
+		cfg = c.Control
+	} else {
+		c, err := config.LoadServerConfig(configPath)
+		if err != nil {
+			return "", fmt.Errorf("failed to load configuration: %w", err)
+		}
+		cfg = c.Control
+	}
+
+	if !cfg.Enabled {
+		return "", fmt.Errorf("control socket is disabled; set control.enabled in %s", configPath)
+	}
+	return cfg.Socket, nil
+}
+
+// parseSince parses a report period such as "7d" or "48h".
+func parseSince(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n <= 0 {
+			return 0, fmt.Errorf("invalid period: %s", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid period: %s", s)
+	}
+	return d, nil
+}
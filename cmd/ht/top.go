@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sahmadiut/half-tunnel/internal/admin"
+	"github.com/sahmadiut/half-tunnel/internal/config"
+	"github.com/sahmadiut/half-tunnel/internal/service"
+	"github.com/spf13/pflag"
+)
+
+// clearScreen moves the cursor home and clears the terminal.
+const clearScreen = "\033[H\033[2J"
+
+// streamKey identifies a stream across admin status snapshots.
+type streamKey struct {
+	session uuid.UUID
+	stream  uint32
+}
+
+// topRow is a stream with its throughput since the previous snapshot.
+type topRow struct {
+	admin.Stream
+	upRate   float64
+	downRate float64
+}
+
+func runTop(svcType service.ServiceType, args []string) {
+	fs := pflag.NewFlagSet("top", pflag.ExitOnError)
+
+	configPath := fs.StringP("config", "c", service.GetDefaultConfigPath(svcType), "Path to the config file")
+	adminURL := fs.String("admin-url", "", "Admin API base URL (default: from config)")
+	token := fs.String("token", "", "Admin API token (default: from config)")
+	interval := fs.DurationP("interval", "i", 2*time.Second, "Refresh interval")
+	limit := fs.IntP("lines", "n", 20, "Number of streams to show (0 for all)")
+	once := fs.Bool("once", false, "Print one refresh and exit, without clearing the screen")
+
+	fs.Usage = func() {
+		fmt.Printf(`Show the busiest streams of the running %s, refreshed live
+
+Usage:
+  ht %s top [options]
+
+Streams are sorted by throughput since the previous refresh. Press Ctrl+C
+to exit.
+
+Options:
+`, svcType, svcType)
+		fs.PrintDefaults()
+	}
+
+	if err := fs.Parse(args); err != nil {
+		os.Exit(1)
+	}
+	if *interval <= 0 {
+		fmt.Fprintf(os.Stderr, "❌ --interval must be positive\n")
+		os.Exit(1)
+	}
+
+	baseURL, adminToken, err := adminEndpoint(svcType, *configPath)
+	if *adminURL != "" {
+		baseURL, err = *adminURL, nil
+	}
+	if *token != "" {
+		adminToken = *token
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		os.Exit(1)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	fetch := func() (*admin.Status, error) {
+		fetchCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		defer cancel()
+		return admin.FetchStatus(fetchCtx, baseURL, adminToken)
+	}
+
+	prev, err := fetch()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+		os.Exit(1)
+	}
+	prevAt := time.Now()
+
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		cur, err := fetch()
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+			os.Exit(1)
+		}
+		now := time.Now()
+
+		if !*once {
+			fmt.Print(clearScreen)
+		}
+		printTop(os.Stdout, baseURL, prev, cur, now.Sub(prevAt), *limit)
+		if *once {
+			return
+		}
+		prev, prevAt = cur, now
+	}
+}
+
+// printTop prints the streams of cur, busiest first, with their throughput
+// since prev, elapsed earlier.
+func printTop(w io.Writer, baseURL string, prev, cur *admin.Status, elapsed time.Duration, limit int) {
+	seconds := elapsed.Seconds()
+	before := make(map[streamKey]admin.Stream, len(prev.Streams))
+	for _, st := range prev.Streams {
+		before[streamKey{st.SessionID, st.StreamID}] = st
+	}
+
+	rows := make([]topRow, 0, len(cur.Streams))
+	for _, st := range cur.Streams {
+		// Streams opened since the previous refresh count from zero
+		old := before[streamKey{st.SessionID, st.StreamID}]
+		rows = append(rows, topRow{
+			Stream:   st,
+			upRate:   float64(st.BytesUp-old.BytesUp) / seconds,
+			downRate: float64(st.BytesDown-old.BytesDown) / seconds,
+		})
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		ri, rj := rows[i].upRate+rows[i].downRate, rows[j].upRate+rows[j].downRate
+		if ri != rj {
+			return ri > rj
+		}
+		return rows[i].BytesUp+rows[i].BytesDown > rows[j].BytesUp+rows[j].BytesDown
+	})
+
+	up := float64(cur.Traffic.BytesSent-prev.Traffic.BytesSent) / seconds
+	down := float64(cur.Traffic.BytesReceived-prev.Traffic.BytesReceived) / seconds
+	fmt.Fprintf(w, "ht top - %s (%s) - %s\n", baseURL, cur.Role, time.Now().Format("15:04:05"))
+	fmt.Fprintf(w, "Sessions: %d   Streams: %d   Throughput: %s/s sent, %s/s received\n\n",
+		len(cur.Sessions), len(cur.Streams), config.FormatByteSize(int64(up)), config.FormatByteSize(int64(down)))
+
+	shown := rows
+	if limit > 0 && len(shown) > limit {
+		shown = shown[:limit]
+	}
+	fmt.Fprintf(w, "%-8s %-12s %-28s %10s %10s %10s %10s %13s %8s %8s\n",
+		"STREAM", "FORWARD", "DESTINATION", "UP/S", "DOWN/S", "UP", "DOWN", "PACKETS", "AGE", "IDLE")
+	now := time.Now()
+	for _, r := range shown {
+		fmt.Fprintf(w, "%-8d %-12s %-28s %10s %10s %10s %10s %13s %8s %8s\n",
+			r.StreamID, truncate(r.Forward, 12), truncate(r.Dest, 28),
+			config.FormatByteSize(int64(r.upRate)), config.FormatByteSize(int64(r.downRate)),
+			config.FormatByteSize(r.BytesUp), config.FormatByteSize(r.BytesDown),
+			fmt.Sprintf("%d/%d", r.PacketsUp, r.PacketsDown),
+			now.Sub(r.CreatedAt).Round(time.Second), now.Sub(r.LastActivity).Round(time.Second))
+	}
+	if len(shown) < len(rows) {
+		fmt.Fprintf(w, "... and %d more\n", len(rows)-len(shown))
+	}
+}
+
+// truncate shortens s to at most n characters, marking the cut with "~".
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n-1] + "~"
+}
@@ -5,6 +5,7 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -12,10 +13,22 @@ import (
 	"time"
 
 	"github.com/fsnotify/fsnotify"
+	"github.com/sahmadiut/half-tunnel/internal/admin"
+	"github.com/sahmadiut/half-tunnel/internal/audit"
+	"github.com/sahmadiut/half-tunnel/internal/banlist"
+	"github.com/sahmadiut/half-tunnel/internal/circuitbreaker"
+	"github.com/sahmadiut/half-tunnel/internal/clientauth"
 	"github.com/sahmadiut/half-tunnel/internal/config"
+	"github.com/sahmadiut/half-tunnel/internal/control"
 	"github.com/sahmadiut/half-tunnel/internal/health"
 	"github.com/sahmadiut/half-tunnel/internal/metrics"
+	"github.com/sahmadiut/half-tunnel/internal/notify"
+	"github.com/sahmadiut/half-tunnel/internal/profiling"
+	"github.com/sahmadiut/half-tunnel/internal/quota"
+	"github.com/sahmadiut/half-tunnel/internal/resolver"
 	"github.com/sahmadiut/half-tunnel/internal/server"
+	"github.com/sahmadiut/half-tunnel/internal/session"
+	"github.com/sahmadiut/half-tunnel/internal/sockopt"
 	"github.com/sahmadiut/half-tunnel/pkg/logger"
 )
 
@@ -51,19 +64,20 @@ func main() {
 	}
 
 	// Initialize logger
-	log, err := logger.New(logger.Config{
-		Level:  cfg.Logging.Level,
-		Format: cfg.Logging.Format,
-		Output: cfg.Logging.Output,
-	})
+	logConfig, err := cfg.Logging.LoggerConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to initialize logger: %v\n", err)
+		os.Exit(1)
+	}
+	log, err := logger.New(logConfig)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Failed to initialize logger: %v\n", err)
 		os.Exit(1)
 	}
 
 	// Construct addresses from host:port
-	upstreamAddr := fmt.Sprintf("%s:%d", cfg.Server.Upstream.Host, cfg.Server.Upstream.Port)
-	downstreamAddr := fmt.Sprintf("%s:%d", cfg.Server.Downstream.Host, cfg.Server.Downstream.Port)
+	upstreamAddr := cfg.Server.Upstream.Addr()
+	downstreamAddr := cfg.Server.Downstream.Addr()
 
 	log.Info().
 		Str("version", version).
@@ -101,21 +115,292 @@ func main() {
 		}
 	}()
 
+	var metricsServer *metrics.Server
+	if cfg.Observability.Metrics.Enabled {
+		addr := fmt.Sprintf(":%d", cfg.Observability.Metrics.Port)
+		metricsServer = metrics.NewServer(&metrics.ServerConfig{
+			Addr: addr,
+			Path: cfg.Observability.Metrics.Path,
+		})
+		go func() {
+			if err := metricsServer.Start(); err != nil && err != http.ErrServerClosed {
+				log.Error().Err(err).Msg("Metrics server error")
+			}
+		}()
+		log.Info().Str("addr", addr).Str("path", cfg.Observability.Metrics.Path).Msg("Metrics server started")
+	}
+
 	// Create server configuration
 	serverConfig := &server.Config{
-		UpstreamAddr:    upstreamAddr,
-		UpstreamPath:    cfg.Server.Upstream.Path,
-		UpstreamTLS:     server.TLSConfig{Enabled: cfg.Server.Upstream.TLS.Enabled, CertFile: cfg.Server.Upstream.TLS.CertFile, KeyFile: cfg.Server.Upstream.TLS.KeyFile},
-		DownstreamAddr:  downstreamAddr,
-		DownstreamPath:  cfg.Server.Downstream.Path,
-		DownstreamTLS:   server.TLSConfig{Enabled: cfg.Server.Downstream.TLS.Enabled, CertFile: cfg.Server.Downstream.TLS.CertFile, KeyFile: cfg.Server.Downstream.TLS.KeyFile},
-		ExitOnPortInUse: cfg.Server.ExitOnPortInUse,
-		SessionTimeout:  cfg.Tunnel.Session.Timeout,
-		MaxSessions:     cfg.Tunnel.Session.MaxSessions,
-		ReadBufferSize:  cfg.Tunnel.Connection.ReadBufferSize,
-		WriteBufferSize: cfg.Tunnel.Connection.WriteBufferSize,
-		MaxMessageSize:  cfg.Tunnel.Connection.MaxMessageSize,
-		DialTimeout:     cfg.Tunnel.Connection.KeepaliveInterval,
+		UpstreamAddr:   upstreamAddr,
+		UpstreamFamily: sockopt.Family(cfg.Server.Upstream.IPFamily),
+		UpstreamPath:   cfg.Server.Upstream.Path,
+		UpstreamTLS: server.TLSConfig{
+			Enabled:           cfg.Server.Upstream.TLS.Enabled,
+			CertFile:          cfg.Server.Upstream.TLS.CertFile,
+			KeyFile:           cfg.Server.Upstream.TLS.KeyFile,
+			ClientCAFile:      cfg.Server.Upstream.TLS.ClientCAFile,
+			RequireClientCert: cfg.Server.Upstream.TLS.RequireClientCert,
+		},
+		DownstreamAddr:   downstreamAddr,
+		DownstreamFamily: sockopt.Family(cfg.Server.Downstream.IPFamily),
+		DownstreamPath:   cfg.Server.Downstream.Path,
+		DownstreamTLS: server.TLSConfig{
+			Enabled:           cfg.Server.Downstream.TLS.Enabled,
+			CertFile:          cfg.Server.Downstream.TLS.CertFile,
+			KeyFile:           cfg.Server.Downstream.TLS.KeyFile,
+			ClientCAFile:      cfg.Server.Downstream.TLS.ClientCAFile,
+			RequireClientCert: cfg.Server.Downstream.TLS.RequireClientCert,
+		},
+		Decoy: server.DecoyConfig{
+			Dir:      cfg.Server.Decoy.Dir,
+			ProxyURL: cfg.Server.Decoy.ProxyURL,
+		},
+		PathSecret:          cfg.Server.PathToken.Secret,
+		PathWindow:          cfg.Server.PathToken.Window,
+		UpgradeSecret:       cfg.Server.UpgradeCookie.Secret,
+		UpgradeWindow:       cfg.Server.UpgradeCookie.Window,
+		UpgradeCookie:       cfg.Server.UpgradeCookie.Name,
+		ExitOnPortInUse:     cfg.Server.ExitOnPortInUse,
+		SessionTimeout:      cfg.Tunnel.Session.Timeout,
+		MaxSessions:         cfg.Tunnel.Session.MaxSessions,
+		SessionEviction:     session.EvictionPolicy(cfg.Tunnel.Session.Eviction),
+		EvictIdleAfter:      cfg.Tunnel.Session.EvictIdleAfter,
+		ReadBufferSize:      cfg.Tunnel.Connection.ReadBufferSize,
+		WriteBufferSize:     cfg.Tunnel.Connection.WriteBufferSize,
+		MaxMessageSize:      cfg.Tunnel.Connection.MaxMessageSize,
+		MaxPayloadSize:      cfg.Tunnel.Connection.MaxPayloadSize,
+		ResetCorruptStreams: cfg.Tunnel.Connection.CorruptPacketPolicy == config.CorruptPacketReset,
+		DialTimeout:         cfg.Tunnel.Connection.KeepaliveInterval,
+		SlowDialThreshold:   cfg.Tunnel.Connection.SlowDialThreshold,
+		MaxConcurrentDials:  cfg.Tunnel.Connection.MaxConcurrentDials,
+		AcceptQueueSize:     cfg.Tunnel.Connection.AcceptQueueSize,
+		MaxConnectionsPerIP: cfg.Access.MaxConnectionsPerIP,
+		SendQueueSize:       cfg.Tunnel.Connection.SendQueueSize,
+		Diagnostics:         cfg.Tunnel.Diagnostics.Enabled,
+		Guest: server.GuestConfig{
+			Enabled:          cfg.Access.Guest.Enabled,
+			Secret:           cfg.Access.Guest.Secret,
+			Required:         cfg.Access.Guest.Required,
+			WarnBefore:       cfg.Access.Guest.WarnBefore,
+			WarnTrafficRatio: cfg.Access.Guest.WarnTrafficRatio,
+		},
+	}
+
+	if cfg.Tunnel.CircuitBreaker.Enabled {
+		serverConfig.DestinationBreaker = &circuitbreaker.Config{
+			MaxFailures:         cfg.Tunnel.CircuitBreaker.MaxFailures,
+			Timeout:             cfg.Tunnel.CircuitBreaker.Timeout,
+			MaxHalfOpenRequests: cfg.Tunnel.CircuitBreaker.HalfOpenRequests,
+		}
+	}
+
+	if d := cfg.Tunnel.DataFlow; d.Enabled {
+		serverConfig.Stall = &server.StallConfig{
+			CheckInterval:  d.CheckInterval,
+			StallThreshold: d.StallThreshold,
+		}
+	}
+
+	if l := cfg.Tunnel.Liveness; l.Enabled {
+		serverConfig.Liveness = &server.LivenessConfig{
+			IdleTimeout:   l.IdleTimeout,
+			ProbeInterval: l.ProbeInterval,
+			ProbeCount:    l.ProbeCount,
+		}
+	}
+
+	if g := cfg.Tunnel.ResourceGuard; g.Enabled {
+		serverConfig.Guard = &server.GuardConfig{
+			CheckInterval: g.CheckInterval,
+			MaxOpenFiles:  g.MaxOpenFiles,
+			MaxNatEntries: g.MaxNatEntries,
+			MaxGoroutines: g.MaxGoroutines,
+			ShedAt:        g.ShedAt,
+		}
+	}
+
+	serverConfig.TCP = cfg.Tunnel.Connection.TCP.SocketOptions()
+
+	serverConfig.BindAddress = net.ParseIP(cfg.Egress.BindAddress)
+	serverConfig.Interface = cfg.Egress.Interface
+	serverConfig.Mark = uint32(cfg.Egress.Mark)
+	serverConfig.DNS = &resolver.Config{
+		Servers:   cfg.Egress.DNS.Servers,
+		DoHURL:    cfg.Egress.DNS.DoHURL,
+		Timeout:   cfg.Egress.DNS.Timeout,
+		Prefer:    resolver.Preference(cfg.Egress.DNS.Prefer),
+		CacheTTL:  cfg.Egress.DNS.CacheTTL,
+		CacheSize: cfg.Egress.DNS.CacheSize,
+	}
+
+	serverConfig.DialRetry = server.DialRetryPolicy{
+		Attempts:   cfg.Access.DialRetry.Attempts,
+		Backoff:    cfg.Access.DialRetry.Backoff,
+		MaxBackoff: cfg.Access.DialRetry.MaxBackoff,
+	}
+	for _, r := range cfg.Access.Rules {
+		rule := server.AccessRule{
+			Name:     r.Name,
+			Networks: toNetworks(r.Networks),
+			Domains:  r.Domains,
+			Ports:    toPorts(r.Ports),
+		}
+		if r.DialRetry != nil {
+			rule.DialRetry = &server.DialRetryPolicy{
+				Attempts:   r.DialRetry.Attempts,
+				Backoff:    r.DialRetry.Backoff,
+				MaxBackoff: r.DialRetry.MaxBackoff,
+			}
+		}
+		serverConfig.AccessRules = append(serverConfig.AccessRules, rule)
+	}
+
+	policy := &server.Policy{
+		BlockPrivate:    cfg.Access.Policy.BlockPrivate,
+		AllowedNetworks: toNetworks(cfg.Access.AllowedNetworks),
+		BlockedNetworks: toNetworks(cfg.Access.BlockedNetworks),
+		BlockedDomains:  cfg.Access.Policy.BlockedDomains,
+		BlockedPorts:    toPorts(cfg.Access.Policy.BlockedPorts),
+	}
+	for _, c := range cfg.Access.Policy.Clients {
+		client := server.ClientPolicy{Identity: c.Identity}
+		for _, r := range c.Allow {
+			client.Allow = append(client.Allow, server.AccessRule{
+				Networks: toNetworks(r.Networks),
+				Domains:  r.Domains,
+				Ports:    toPorts(r.Ports),
+			})
+		}
+		policy.Clients = append(policy.Clients, client)
+	}
+	serverConfig.Policy = policy
+
+	serverConfig.ClientAuth.Required = cfg.Access.ClientAuth.Required
+	if len(cfg.Access.ClientAuth.Clients) > 0 {
+		var clients []clientauth.Client
+		for _, c := range cfg.Access.ClientAuth.Clients {
+			clients = append(clients, clientauth.Client{Name: c.Name, TokenHash: c.Hash()})
+		}
+		verifier, err := clientauth.NewVerifier(clients)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to set up client tokens")
+			os.Exit(1)
+		}
+		serverConfig.ClientAuth.Clients = verifier
+		log.Info().Int("clients", verifier.Len()).Msg("Client token authentication enabled")
+	}
+
+	var quotas *quota.Tracker
+	if cfg.Access.Quotas.Enabled {
+		defaults, _ := cfg.Access.Quotas.Default.Limits()
+		limits := make(map[string]quota.Limits)
+		for _, c := range cfg.Access.Quotas.Clients {
+			limits[c.Name], _ = c.Limits()
+		}
+		var err error
+		quotas, err = quota.New(cfg.Access.Quotas.StateFile, limits, defaults)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to open quota state")
+			os.Exit(1)
+		}
+		quotas.Start(cfg.Access.Quotas.FlushInterval, func(err error) {
+			log.Warn().Err(err).Msg("Failed to save quota state")
+		})
+		serverConfig.Quotas = quotas
+		log.Info().
+			Str("path", cfg.Access.Quotas.StateFile).
+			Int("clients", len(limits)).
+			Msg("Client traffic quotas enabled")
+	}
+
+	// Proxies listed as exempt hold many connections
+	serverConfig.ConnectionLimitExempt = toNetworks(cfg.Access.ConnectionLimits.ExemptNetworks)
+
+	var bans *banlist.List
+	if limits := cfg.Access.ConnectionLimits; limits.Enabled {
+		var err error
+		bans, err = banlist.New(limits.StateFile, banlist.Config{
+			AttemptsPerMinute: limits.AttemptsPerMinute,
+			Burst:             limits.Burst,
+			MaxFailures:       limits.MaxFailures,
+			FailureWindow:     limits.FailureWindow,
+			BanDuration:       limits.BanDuration,
+			Exempt:            toNetworks(limits.ExemptNetworks),
+		})
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to open ban list")
+			os.Exit(1)
+		}
+		bans.Start(limits.FlushInterval, func(err error) {
+			log.Warn().Err(err).Msg("Failed to save ban list")
+		})
+		serverConfig.Bans = bans
+		log.Info().
+			Int("attempts_per_minute", limits.AttemptsPerMinute).
+			Int("max_failures", limits.MaxFailures).
+			Dur("ban_duration", limits.BanDuration).
+			Int("bans", len(bans.Bans())).
+			Msg("Connection limits enabled")
+	}
+
+	if cfg.Tunnel.Session.Store.Backend == "redis" {
+		backend, err := session.NewRedisBackend(session.RedisConfig{
+			Addr:        cfg.Tunnel.Session.Store.Redis.Addr,
+			Password:    cfg.Tunnel.Session.Store.Redis.Password,
+			DB:          cfg.Tunnel.Session.Store.Redis.DB,
+			Prefix:      cfg.Tunnel.Session.Store.Redis.Prefix,
+			MaxSessions: cfg.Tunnel.Session.MaxSessions,
+			TTL:         cfg.Tunnel.Session.Timeout,
+		})
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to set up session store")
+			os.Exit(1)
+		}
+		defer backend.Close()
+		serverConfig.Name = cfg.Server.Name
+		serverConfig.SessionBackend = backend
+		log.Info().Str("addr", cfg.Tunnel.Session.Store.Redis.Addr).Msg("Sharing session state through redis")
+	}
+
+	if len(cfg.Cluster.Peers) > 0 {
+		serverConfig.Cluster = server.ClusterConfig{
+			Node:               cfg.Server.Name,
+			Secret:             cfg.Cluster.Secret,
+			InsecureSkipVerify: cfg.Cluster.InsecureSkipVerify,
+		}
+		for _, peer := range cfg.Cluster.Peers {
+			serverConfig.Cluster.Peers = append(serverConfig.Cluster.Peers, server.ClusterPeer{
+				Name:          peer.Name,
+				UpstreamURL:   peer.UpstreamURL,
+				DownstreamURL: peer.DownstreamURL,
+			})
+		}
+		log.Info().Str("node", cfg.Server.Name).Int("peers", len(cfg.Cluster.Peers)).Msg("Cluster session routing enabled")
+	}
+
+	if metricsServer != nil {
+		serverConfig.Metrics = metricsServer.Collector()
+	}
+
+	if cfg.Observability.Audit.Enabled {
+		auditLog, err := audit.Open(cfg.Observability.Audit.Output)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to open audit log")
+			os.Exit(1)
+		}
+		defer auditLog.Close()
+		serverConfig.Audit = auditLog
+		log.Info().Str("output", cfg.Observability.Audit.Output).Msg("Audit log enabled")
+	}
+
+	// Notifications are delivered until the server has stopped
+	notifyCtx, stopNotify := context.WithCancel(context.Background())
+	defer stopNotify()
+	if n := cfg.Notifications; n.Enabled {
+		serverConfig.Notifier = notify.New(n.NotifierConfig(cfg.Server.Name), log.Component("notify"))
+		serverConfig.CertExpiryWarning = n.CertExpiryWarning
+		serverConfig.Notifier.Start(notifyCtx)
 	}
 
 	// Create and start the server
@@ -164,21 +449,6 @@ func main() {
 		}
 	}
 
-	var metricsServer *metrics.Server
-	if cfg.Observability.Metrics.Enabled {
-		addr := fmt.Sprintf(":%d", cfg.Observability.Metrics.Port)
-		metricsServer = metrics.NewServer(&metrics.ServerConfig{
-			Addr: addr,
-			Path: cfg.Observability.Metrics.Path,
-		})
-		go func() {
-			if err := metricsServer.Start(); err != nil && err != http.ErrServerClosed {
-				log.Error().Err(err).Msg("Metrics server error")
-			}
-		}()
-		log.Info().Str("addr", addr).Str("path", cfg.Observability.Metrics.Path).Msg("Metrics server started")
-	}
-
 	var healthServer *health.Server
 	if cfg.Observability.Health.Enabled {
 		addr := fmt.Sprintf(":%d", cfg.Observability.Health.Port)
@@ -191,6 +461,7 @@ func main() {
 			HealthzPath: cfg.Observability.Health.Path,
 			ReadyzPath:  readyzPath,
 		})
+		healthServer.RegisterChecker(s)
 		go func() {
 			if err := healthServer.Start(); err != nil && err != http.ErrServerClosed {
 				log.Error().Err(err).Msg("Health server error")
@@ -199,6 +470,52 @@ func main() {
 		log.Info().Str("addr", addr).Str("path", cfg.Observability.Health.Path).Msg("Health server started")
 	}
 
+	var adminServer *admin.Server
+	if cfg.Observability.Admin.Enabled {
+		adminServer = admin.NewServer(&admin.ServerConfig{
+			Addr:   cfg.Observability.Admin.Addr(),
+			Prefix: cfg.Observability.Admin.Path,
+			Token:  cfg.Observability.Admin.Token,
+		}, s)
+		go func() {
+			if err := adminServer.Start(); err != nil && err != http.ErrServerClosed {
+				log.Error().Err(err).Msg("Admin server error")
+			}
+		}()
+		log.Info().Str("addr", adminServer.Addr()).Str("path", cfg.Observability.Admin.Path).Msg("Admin API started")
+	}
+
+	var debugServer *profiling.Server
+	if cfg.Observability.Debug.Enabled {
+		debugServer = profiling.NewServer(&profiling.ServerConfig{
+			Addr:    cfg.Observability.Debug.Addr(),
+			DumpDir: cfg.Observability.Debug.DumpDir,
+		})
+		go func() {
+			if err := debugServer.Start(); err != nil && err != http.ErrServerClosed {
+				log.Error().Err(err).Msg("Debug server error")
+			}
+		}()
+		log.Warn().Str("addr", debugServer.Addr()).Msg("Debug server started; it exposes pprof without authentication")
+	}
+
+	if cfg.Control.Enabled {
+		ctl := control.NewServer(cfg.Control.Socket, log.Component("control"))
+		ctl.RegisterTunnelCommands(s)
+		ctl.Handle("reload", func(args []string) (interface{}, error) {
+			// Same as SIGHUP: the service manager restarts the server with the new config
+			if err := syscall.Kill(syscall.Getpid(), syscall.SIGHUP); err != nil {
+				return nil, err
+			}
+			return "restart requested", nil
+		})
+		if err := ctl.Start(ctx); err != nil {
+			log.Warn().Err(err).Str("socket", cfg.Control.Socket).Msg("Control socket disabled")
+		} else {
+			log.Info().Str("socket", cfg.Control.Socket).Msg("Control socket started")
+		}
+	}
+
 	// Periodic stats logging
 	go func() {
 		ticker := time.NewTicker(30 * time.Second)
@@ -236,6 +553,22 @@ func main() {
 		shutdownCancel()
 	}
 
+	if adminServer != nil {
+		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if err := adminServer.Shutdown(shutdownCtx); err != nil {
+			log.Error().Err(err).Msg("Admin server shutdown error")
+		}
+		shutdownCancel()
+	}
+
+	if debugServer != nil {
+		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if err := debugServer.Shutdown(shutdownCtx); err != nil {
+			log.Error().Err(err).Msg("Debug server shutdown error")
+		}
+		shutdownCancel()
+	}
+
 	// Stop the server with a timeout
 	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer shutdownCancel()
@@ -243,4 +576,35 @@ func main() {
 	if err := s.Stop(shutdownCtx); err != nil {
 		log.Error().Err(err).Msg("Error stopping server")
 	}
+	if quotas != nil {
+		if err := quotas.Stop(); err != nil {
+			log.Error().Err(err).Msg("Failed to save quota state")
+		}
+	}
+	if bans != nil {
+		if err := bans.Stop(); err != nil {
+			log.Error().Err(err).Msg("Failed to save ban list")
+		}
+	}
+	stopNotify()
+	serverConfig.Notifier.Wait()
+}
+
+// toNetworks parses CIDRs validated when the config was loaded.
+func toNetworks(cidrs []string) []*net.IPNet {
+	var networks []*net.IPNet
+	for _, cidr := range cidrs {
+		_, network, _ := net.ParseCIDR(cidr)
+		networks = append(networks, network)
+	}
+	return networks
+}
+
+// toPorts converts ports validated when the config was loaded.
+func toPorts(ports []int) []uint16 {
+	var converted []uint16
+	for _, port := range ports {
+		converted = append(converted, uint16(port))
+	}
+	return converted
 }
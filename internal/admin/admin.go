@@ -0,0 +1,279 @@
+// Package admin provides the JSON admin/status API for Half-Tunnel clients
+// and servers.
+//
+// The API exposes live tunnel state (sessions, streams, NAT entries and
+// reconnect history) and a small set of actions for operators and tooling:
+//
+//	GET  <prefix>/status                                   full snapshot
+//	GET  <prefix>/sessions                                 sessions only
+//	GET  <prefix>/streams                                  streams only
+//	GET  <prefix>/streams/closed                           recently closed streams and why
+//	GET  <prefix>/nat                                      NAT table only
+//	GET  <prefix>/reconnects                               reconnect history
+//	GET  <prefix>/paths                                    tunnel path round-trip times
+//	GET  <prefix>/goroutines                               stack traces of all goroutines (text)
+//	POST <prefix>/sessions/{session}/drain                 close a session's streams
+//	POST <prefix>/sessions/{session}/close                 close a session
+//	POST <prefix>/sessions/{session}/streams/{stream}/close close one stream
+//
+// Providers that also implement ForwardManager (the client) get endpoints for
+// managing port forwards at runtime:
+//
+//	GET    <prefix>/forwards                               list forwards
+//	POST   <prefix>/forwards                               add a forward (JSON Forward body)
+//	DELETE <prefix>/forwards/{port}                        remove the forward on a listen port
+//
+// Providers that also implement BreakerManager (the client) get endpoints for
+// the circuit breaker around connection attempts:
+//
+//	GET  <prefix>/breaker                                  breaker state
+//	POST <prefix>/breaker/reset                            close the breaker and reconnect now
+//
+// Providers that also implement QuotaManager (the server) get endpoints for
+// client traffic quotas:
+//
+//	GET  <prefix>/quotas                                   client quotas and usage
+//	POST <prefix>/quotas/{client}/reset                    clear a client's usage
+//
+// Providers that also implement BanManager (the server) get endpoints for
+// source IPs banned by connection limits:
+//
+//	GET    <prefix>/bans                                   banned sources
+//	DELETE <prefix>/bans                                   lift every ban
+//	DELETE <prefix>/bans/{ip}                              lift the ban on one source
+package admin
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Errors returned by providers for unknown sessions or streams.
+var (
+	// ErrSessionNotFound indicates the session does not exist.
+	ErrSessionNotFound = errors.New("session not found")
+	// ErrStreamNotFound indicates the stream does not exist.
+	ErrStreamNotFound = errors.New("stream not found")
+	// ErrForwardNotFound indicates no forward listens on the given port.
+	ErrForwardNotFound = errors.New("port forward not found")
+	// ErrForwardExists indicates a forward already listens on the given port.
+	ErrForwardExists = errors.New("port forward already exists")
+	// ErrClientNotFound indicates the client has no quota or usage.
+	ErrClientNotFound = errors.New("client not found")
+	// ErrBanNotFound indicates the source is not banned.
+	ErrBanNotFound = errors.New("ban not found")
+)
+
+// Status is a snapshot of a client's or server's tunnel state.
+type Status struct {
+	Role       string      `json:"role"`
+	Connected  bool        `json:"connected"`
+	StartedAt  time.Time   `json:"started_at"`
+	Traffic    Traffic     `json:"traffic"`
+	Sessions   []Session   `json:"sessions"`
+	Streams    []Stream    `json:"streams"`
+	NAT        []NATEntry  `json:"nat"`
+	Reconnects []Reconnect `json:"reconnects"`
+	// ClosedStreams holds the most recently closed streams, oldest first
+	ClosedStreams []ClosedStream `json:"closed_streams"`
+	// Paths holds the round-trip time of each tunnel path (client only)
+	Paths []Path `json:"paths,omitempty"`
+	// Breaker holds the state of the circuit breaker around connection
+	// attempts (client only, if enabled)
+	Breaker *Breaker `json:"breaker,omitempty"`
+}
+
+// Traffic holds aggregate tunnel traffic counters.
+type Traffic struct {
+	BytesSent       int64 `json:"bytes_sent"`
+	BytesReceived   int64 `json:"bytes_received"`
+	PacketsSent     int64 `json:"packets_sent"`
+	PacketsReceived int64 `json:"packets_received"`
+}
+
+// Session describes a tunnel session. Protocol is the packet version
+// negotiated with the peer and Capabilities the optional features both
+// sides support; Protocol is 0 if the peer did not negotiate.
+type Session struct {
+	ID           uuid.UUID `json:"id"`
+	Client       string    `json:"client,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+	LastActivity time.Time `json:"last_activity"`
+	Streams      int       `json:"streams"`
+	Protocol     int       `json:"protocol"`
+	Capabilities []string  `json:"capabilities,omitempty"`
+	// DownstreamFailures counts the writes to the session's downstream that
+	// failed since DownstreamFailingSince; both are unset while writes
+	// succeed
+	DownstreamFailures     int64      `json:"downstream_failures,omitempty"`
+	DownstreamFailingSince *time.Time `json:"downstream_failing_since,omitempty"`
+}
+
+// Stream describes an active stream and its traffic. PacketsUp and
+// PacketsDown count the stream's data packets through the tunnel and
+// LastActivity is when the last one was sent or received.
+type Stream struct {
+	SessionID    uuid.UUID `json:"session_id"`
+	StreamID     uint32    `json:"stream_id"`
+	Forward      string    `json:"forward,omitempty"`
+	Dest         string    `json:"dest"`
+	BytesUp      int64     `json:"bytes_up"`
+	BytesDown    int64     `json:"bytes_down"`
+	PacketsUp    int64     `json:"packets_up"`
+	PacketsDown  int64     `json:"packets_down"`
+	CreatedAt    time.Time `json:"created_at"`
+	LastActivity time.Time `json:"last_activity"`
+}
+
+// Sides of a stream that can close it, as reported in ClosedStream.ClosedBy.
+const (
+	// ClosedByLocal means this client or server closed the stream.
+	ClosedByLocal = "local"
+	// ClosedByPeer means the other side closed the stream with a FIN.
+	ClosedByPeer = "peer"
+)
+
+// ClosedStream describes a closed stream, its traffic and why it was closed.
+// Reason is the close reason sent in or received with the stream's FIN and
+// Message its optional detail, such as an error.
+type ClosedStream struct {
+	SessionID uuid.UUID `json:"session_id"`
+	StreamID  uint32    `json:"stream_id"`
+	Forward   string    `json:"forward,omitempty"`
+	Dest      string    `json:"dest"`
+	BytesUp   int64     `json:"bytes_up"`
+	BytesDown int64     `json:"bytes_down"`
+	CreatedAt time.Time `json:"created_at"`
+	ClosedAt  time.Time `json:"closed_at"`
+	ClosedBy  string    `json:"closed_by"`
+	Reason    string    `json:"reason"`
+	Message   string    `json:"message,omitempty"`
+}
+
+// NATEntry describes a server-side mapping from a stream to the destination
+// connection dialed for it.
+type NATEntry struct {
+	SessionID  uuid.UUID `json:"session_id"`
+	StreamID   uint32    `json:"stream_id"`
+	LocalAddr  string    `json:"local_addr"`
+	RemoteAddr string    `json:"remote_addr"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// Reconnect records one reconnect cycle of a client.
+type Reconnect struct {
+	Time     time.Time     `json:"time"`
+	Source   string        `json:"source"`
+	Attempts int           `json:"attempts"`
+	Success  bool          `json:"success"`
+	Duration time.Duration `json:"duration"`
+	Error    string        `json:"error,omitempty"`
+}
+
+// Path describes the round-trip time of a tunnel path, measured with
+// keepalive probes. RTT is smoothed over recent probes; it is zero until the
+// first probe returns.
+type Path struct {
+	Path      string        `json:"path"`
+	RTT       time.Duration `json:"rtt"`
+	LastRTT   time.Duration `json:"last_rtt"`
+	MinRTT    time.Duration `json:"min_rtt"`
+	Samples   int64         `json:"samples"`
+	UpdatedAt time.Time     `json:"updated_at"`
+}
+
+// Breaker describes the circuit breaker around a client's connection
+// attempts. State is "closed", "open" or "half-open"; while it is open,
+// reconnects pause until RetryAt.
+type Breaker struct {
+	State       string    `json:"state"`
+	Failures    int       `json:"failures"`
+	LastFailure time.Time `json:"last_failure"`
+	OpenedAt    time.Time `json:"opened_at"`
+	RetryAt     time.Time `json:"retry_at"`
+}
+
+// BreakerManager is implemented by providers whose connection attempts go
+// through a circuit breaker.
+type BreakerManager interface {
+	// Breaker returns the breaker state, or nil if the breaker is disabled.
+	Breaker() *Breaker
+	// ResetBreaker closes the breaker so the next attempt is made at once.
+	ResetBreaker() error
+}
+
+// Forward describes a port forward rule.
+type Forward struct {
+	Name       string `json:"name,omitempty"`
+	ListenHost string `json:"listen_host"`
+	ListenPort int    `json:"listen_port"`
+	RemoteHost string `json:"remote_host"`
+	RemotePort int    `json:"remote_port"`
+}
+
+// ForwardManager is implemented by providers whose port forwards can be
+// changed at runtime. Changes are not written back to the config file.
+type ForwardManager interface {
+	// Forwards returns the active port forward rules.
+	Forwards() []Forward
+	// AddForward starts a new port forward.
+	AddForward(f Forward) error
+	// RemoveForward stops the port forward listening on listenPort.
+	RemoveForward(listenPort int) error
+}
+
+// Quota describes a client's traffic quotas and its usage in the current day
+// and month. Limits of 0 are unlimited.
+type Quota struct {
+	Client       string `json:"client"`
+	DailyLimit   int64  `json:"daily_limit"`
+	MonthlyLimit int64  `json:"monthly_limit"`
+	RateLimit    int64  `json:"rate_limit"`
+	Day          string `json:"day"`
+	DayBytes     int64  `json:"day_bytes"`
+	Month        string `json:"month"`
+	MonthBytes   int64  `json:"month_bytes"`
+	Exceeded     string `json:"exceeded,omitempty"`
+}
+
+// QuotaManager is implemented by providers that enforce client traffic
+// quotas.
+type QuotaManager interface {
+	// Quotas returns every client's quotas and usage.
+	Quotas() []Quota
+	// ResetQuota clears a client's usage for the current day and month.
+	ResetQuota(client string) error
+}
+
+// Ban describes a source IP banned by connection limits until Until.
+type Ban struct {
+	IP     string    `json:"ip"`
+	Reason string    `json:"reason"`
+	Since  time.Time `json:"since"`
+	Until  time.Time `json:"until"`
+}
+
+// BanManager is implemented by providers that ban sources of abusive
+// connection attempts.
+type BanManager interface {
+	// Bans returns the bans in effect.
+	Bans() []Ban
+	// Unban lifts the ban on a source IP.
+	Unban(ip string) error
+	// ClearBans lifts every ban.
+	ClearBans() error
+}
+
+// Provider is implemented by the client and server to serve the admin API.
+type Provider interface {
+	// AdminStatus returns a snapshot of the current tunnel state.
+	AdminStatus() *Status
+	// CloseStream closes a single stream of a session.
+	CloseStream(sessionID uuid.UUID, streamID uint32) error
+	// DrainSession closes every stream of a session.
+	DrainSession(sessionID uuid.UUID) error
+	// CloseSession closes a session and all of its streams.
+	CloseSession(sessionID uuid.UUID) error
+}
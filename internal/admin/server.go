@@ -0,0 +1,235 @@
+package admin
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"runtime/pprof"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ServerConfig holds admin server configuration.
+type ServerConfig struct {
+	// Addr is the address to listen on
+	Addr string
+	// Prefix is the path prefix for all endpoints
+	Prefix string
+	// Token, if set, must be sent as "Authorization: Bearer <token>"
+	Token string
+}
+
+// DefaultServerConfig returns default admin server configuration.
+func DefaultServerConfig() *ServerConfig {
+	return &ServerConfig{
+		Addr:   "127.0.0.1:7070",
+		Prefix: "/api",
+	}
+}
+
+// Server serves the admin API for a Provider.
+type Server struct {
+	provider Provider
+	token    string
+	server   *http.Server
+}
+
+// NewServer creates an admin server for provider.
+func NewServer(config *ServerConfig, provider Provider) *Server {
+	if config == nil {
+		config = DefaultServerConfig()
+	}
+
+	s := &Server{
+		provider: provider,
+		token:    config.Token,
+	}
+	s.server = &http.Server{
+		Addr:         config.Addr,
+		Handler:      s.Handler(config.Prefix),
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 10 * time.Second,
+	}
+	return s
+}
+
+// Handler returns the admin API handler with endpoints under prefix.
+func (s *Server) Handler(prefix string) http.Handler {
+	prefix = strings.TrimSuffix(prefix, "/")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET "+prefix+"/status", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, s.provider.AdminStatus())
+	})
+	mux.HandleFunc("GET "+prefix+"/sessions", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, s.provider.AdminStatus().Sessions)
+	})
+	mux.HandleFunc("GET "+prefix+"/streams", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, s.provider.AdminStatus().Streams)
+	})
+	mux.HandleFunc("GET "+prefix+"/streams/closed", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, s.provider.AdminStatus().ClosedStreams)
+	})
+	mux.HandleFunc("GET "+prefix+"/nat", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, s.provider.AdminStatus().NAT)
+	})
+	mux.HandleFunc("GET "+prefix+"/reconnects", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, s.provider.AdminStatus().Reconnects)
+	})
+	mux.HandleFunc("GET "+prefix+"/paths", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, s.provider.AdminStatus().Paths)
+	})
+	mux.HandleFunc("GET "+prefix+"/goroutines", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_ = pprof.Lookup("goroutine").WriteTo(w, 2)
+	})
+	mux.HandleFunc("POST "+prefix+"/sessions/{session}/drain", s.handleDrainSession)
+	mux.HandleFunc("POST "+prefix+"/sessions/{session}/close", s.handleCloseSession)
+	mux.HandleFunc("POST "+prefix+"/sessions/{session}/streams/{stream}/close", s.handleCloseStream)
+
+	if forwards, ok := s.provider.(ForwardManager); ok {
+		mux.HandleFunc("GET "+prefix+"/forwards", func(w http.ResponseWriter, r *http.Request) {
+			writeJSON(w, http.StatusOK, forwards.Forwards())
+		})
+		mux.HandleFunc("POST "+prefix+"/forwards", func(w http.ResponseWriter, r *http.Request) {
+			var f Forward
+			if err := json.NewDecoder(r.Body).Decode(&f); err != nil {
+				writeError(w, http.StatusBadRequest, errors.New("invalid forward"))
+				return
+			}
+			s.writeResult(w, forwards.AddForward(f))
+		})
+		mux.HandleFunc("DELETE "+prefix+"/forwards/{port}", func(w http.ResponseWriter, r *http.Request) {
+			port, err := strconv.Atoi(r.PathValue("port"))
+			if err != nil {
+				writeError(w, http.StatusBadRequest, errors.New("invalid port"))
+				return
+			}
+			s.writeResult(w, forwards.RemoveForward(port))
+		})
+	}
+
+	if breaker, ok := s.provider.(BreakerManager); ok {
+		mux.HandleFunc("GET "+prefix+"/breaker", func(w http.ResponseWriter, r *http.Request) {
+			writeJSON(w, http.StatusOK, breaker.Breaker())
+		})
+		mux.HandleFunc("POST "+prefix+"/breaker/reset", func(w http.ResponseWriter, r *http.Request) {
+			s.writeResult(w, breaker.ResetBreaker())
+		})
+	}
+
+	if quotas, ok := s.provider.(QuotaManager); ok {
+		mux.HandleFunc("GET "+prefix+"/quotas", func(w http.ResponseWriter, r *http.Request) {
+			writeJSON(w, http.StatusOK, quotas.Quotas())
+		})
+		mux.HandleFunc("POST "+prefix+"/quotas/{client}/reset", func(w http.ResponseWriter, r *http.Request) {
+			s.writeResult(w, quotas.ResetQuota(r.PathValue("client")))
+		})
+	}
+
+	if bans, ok := s.provider.(BanManager); ok {
+		mux.HandleFunc("GET "+prefix+"/bans", func(w http.ResponseWriter, r *http.Request) {
+			writeJSON(w, http.StatusOK, bans.Bans())
+		})
+		mux.HandleFunc("DELETE "+prefix+"/bans", func(w http.ResponseWriter, r *http.Request) {
+			s.writeResult(w, bans.ClearBans())
+		})
+		mux.HandleFunc("DELETE "+prefix+"/bans/{ip}", func(w http.ResponseWriter, r *http.Request) {
+			s.writeResult(w, bans.Unban(r.PathValue("ip")))
+		})
+	}
+
+	return s.authorize(mux)
+}
+
+// authorize rejects requests without the configured bearer token.
+func (s *Server) authorize(next http.Handler) http.Handler {
+	if s.token == "" {
+		return next
+	}
+	expected := []byte("Bearer " + s.token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+			writeError(w, http.StatusUnauthorized, errors.New("unauthorized"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) handleDrainSession(w http.ResponseWriter, r *http.Request) {
+	sessionID, err := uuid.Parse(r.PathValue("session"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errors.New("invalid session ID"))
+		return
+	}
+	s.writeResult(w, s.provider.DrainSession(sessionID))
+}
+
+func (s *Server) handleCloseSession(w http.ResponseWriter, r *http.Request) {
+	sessionID, err := uuid.Parse(r.PathValue("session"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errors.New("invalid session ID"))
+		return
+	}
+	s.writeResult(w, s.provider.CloseSession(sessionID))
+}
+
+func (s *Server) handleCloseStream(w http.ResponseWriter, r *http.Request) {
+	sessionID, err := uuid.Parse(r.PathValue("session"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errors.New("invalid session ID"))
+		return
+	}
+	streamID, err := strconv.ParseUint(r.PathValue("stream"), 10, 32)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errors.New("invalid stream ID"))
+		return
+	}
+	s.writeResult(w, s.provider.CloseStream(sessionID, uint32(streamID)))
+}
+
+// writeResult reports the outcome of an action.
+func (s *Server) writeResult(w http.ResponseWriter, err error) {
+	switch {
+	case err == nil:
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	case errors.Is(err, ErrSessionNotFound), errors.Is(err, ErrStreamNotFound), errors.Is(err, ErrForwardNotFound),
+		errors.Is(err, ErrClientNotFound), errors.Is(err, ErrBanNotFound):
+		writeError(w, http.StatusNotFound, err)
+	case errors.Is(err, ErrForwardExists):
+		writeError(w, http.StatusConflict, err)
+	default:
+		writeError(w, http.StatusInternalServerError, err)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
+
+// Start starts the admin server.
+func (s *Server) Start() error {
+	return s.server.ListenAndServe()
+}
+
+// Shutdown gracefully shuts down the admin server.
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.server.Shutdown(ctx)
+}
+
+// Addr returns the server address.
+func (s *Server) Addr() string {
+	return s.server.Addr
+}
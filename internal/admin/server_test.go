@@ -0,0 +1,417 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+type fakeProvider struct {
+	status         *Status
+	closed         []uint32
+	drained        []uuid.UUID
+	closedSessions []uuid.UUID
+}
+
+func (f *fakeProvider) AdminStatus() *Status { return f.status }
+
+func (f *fakeProvider) CloseStream(sessionID uuid.UUID, streamID uint32) error {
+	if sessionID != f.status.Sessions[0].ID {
+		return ErrSessionNotFound
+	}
+	f.closed = append(f.closed, streamID)
+	return nil
+}
+
+func (f *fakeProvider) DrainSession(sessionID uuid.UUID) error {
+	if sessionID != f.status.Sessions[0].ID {
+		return ErrSessionNotFound
+	}
+	f.drained = append(f.drained, sessionID)
+	return nil
+}
+
+func (f *fakeProvider) CloseSession(sessionID uuid.UUID) error {
+	if sessionID != f.status.Sessions[0].ID {
+		return ErrSessionNotFound
+	}
+	f.closedSessions = append(f.closedSessions, sessionID)
+	return nil
+}
+
+func newFakeProvider() *fakeProvider {
+	id := uuid.New()
+	return &fakeProvider{status: &Status{
+		Role:     "server",
+		Sessions: []Session{{ID: id, Streams: 1}},
+		Streams:  []Stream{{SessionID: id, StreamID: 3, Dest: "example.com:443", BytesUp: 10}},
+		ClosedStreams: []ClosedStream{
+			{SessionID: id, StreamID: 1, Dest: "example.com:80", ClosedBy: ClosedByPeer, Reason: "eof"},
+		},
+	}}
+}
+
+func TestStatusEndpoints(t *testing.T) {
+	provider := newFakeProvider()
+	handler := NewServer(nil, provider).Handler("/api")
+
+	req := httptest.NewRequest(http.MethodGet, "/api/status", nil)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", rec.Code)
+	}
+	var status Status
+	if err := json.NewDecoder(rec.Body).Decode(&status); err != nil {
+		t.Fatalf("Failed to decode status: %v", err)
+	}
+	if status.Role != "server" || len(status.Streams) != 1 || status.Streams[0].Dest != "example.com:443" {
+		t.Errorf("Unexpected status: %+v", status)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/streams", nil)
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	var streams []Stream
+	if err := json.NewDecoder(rec.Body).Decode(&streams); err != nil {
+		t.Fatalf("Failed to decode streams: %v", err)
+	}
+	if len(streams) != 1 || streams[0].BytesUp != 10 {
+		t.Errorf("Unexpected streams: %+v", streams)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/streams/closed", nil)
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	var closed []ClosedStream
+	if err := json.NewDecoder(rec.Body).Decode(&closed); err != nil {
+		t.Fatalf("Failed to decode closed streams: %v", err)
+	}
+	if len(closed) != 1 || closed[0].ClosedBy != ClosedByPeer || closed[0].Reason != "eof" {
+		t.Errorf("Unexpected closed streams: %+v", closed)
+	}
+}
+
+func TestActionEndpoints(t *testing.T) {
+	provider := newFakeProvider()
+	handler := NewServer(nil, provider).Handler("/api/")
+	id := provider.status.Sessions[0].ID
+
+	tests := []struct {
+		name string
+		path string
+		want int
+	}{
+		{"close stream", "/api/sessions/" + id.String() + "/streams/3/close", http.StatusOK},
+		{"drain session", "/api/sessions/" + id.String() + "/drain", http.StatusOK},
+		{"close session", "/api/sessions/" + id.String() + "/close", http.StatusOK},
+		{"unknown session", "/api/sessions/" + uuid.New().String() + "/drain", http.StatusNotFound},
+		{"invalid session", "/api/sessions/nope/drain", http.StatusBadRequest},
+		{"invalid stream", "/api/sessions/" + id.String() + "/streams/x/close", http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tt.path, nil)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("Expected %d, got %d", tt.want, rec.Code)
+			}
+		})
+	}
+
+	if len(provider.closed) != 1 || provider.closed[0] != 3 {
+		t.Errorf("Expected stream 3 to be closed, got %v", provider.closed)
+	}
+	if len(provider.drained) != 1 {
+		t.Errorf("Expected one drained session, got %d", len(provider.drained))
+	}
+	if len(provider.closedSessions) != 1 {
+		t.Errorf("Expected one closed session, got %d", len(provider.closedSessions))
+	}
+
+	// Actions require POST
+	req := httptest.NewRequest(http.MethodGet, "/api/sessions/"+id.String()+"/drain", nil)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected 405, got %d", rec.Code)
+	}
+}
+
+func TestTokenAuth(t *testing.T) {
+	handler := NewServer(&ServerConfig{Token: "s3cret"}, newFakeProvider()).Handler("/api")
+
+	req := httptest.NewRequest(http.MethodGet, "/api/status", nil)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without token, got %d", rec.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/status", nil)
+	req.Header.Set("Authorization", "Bearer s3cret")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected 200 with token, got %d", rec.Code)
+	}
+}
+
+func TestFetchStatus(t *testing.T) {
+	provider := newFakeProvider()
+	ts := httptest.NewServer(NewServer(&ServerConfig{Token: "s3cret"}, provider).Handler("/api"))
+	defer ts.Close()
+
+	status, err := FetchStatus(context.Background(), ts.URL+"/api/", "s3cret")
+	if err != nil {
+		t.Fatalf("Failed to fetch status: %v", err)
+	}
+	if status.Role != "server" || len(status.Streams) != 1 {
+		t.Errorf("Unexpected status: %+v", status)
+	}
+
+	if _, err := FetchStatus(context.Background(), ts.URL+"/api", "wrong"); err == nil {
+		t.Error("Expected error with wrong token")
+	}
+}
+
+func TestFetchGoroutines(t *testing.T) {
+	ts := httptest.NewServer(NewServer(&ServerConfig{Token: "s3cret"}, newFakeProvider()).Handler("/api"))
+	defer ts.Close()
+
+	dump, err := FetchGoroutines(context.Background(), ts.URL+"/api", "s3cret")
+	if err != nil {
+		t.Fatalf("Failed to fetch goroutines: %v", err)
+	}
+	if !strings.Contains(string(dump), "goroutine ") {
+		t.Errorf("Expected a goroutine dump, got %q", dump)
+	}
+
+	if _, err := FetchGoroutines(context.Background(), ts.URL+"/api", "wrong"); err == nil {
+		t.Error("Expected error with wrong token")
+	}
+}
+
+type fakeForwardProvider struct {
+	*fakeProvider
+	forwards []Forward
+}
+
+func (f *fakeForwardProvider) Forwards() []Forward { return f.forwards }
+
+func (f *fakeForwardProvider) AddForward(fwd Forward) error {
+	for _, existing := range f.forwards {
+		if existing.ListenPort == fwd.ListenPort {
+			return ErrForwardExists
+		}
+	}
+	f.forwards = append(f.forwards, fwd)
+	return nil
+}
+
+func (f *fakeForwardProvider) RemoveForward(listenPort int) error {
+	for i, fwd := range f.forwards {
+		if fwd.ListenPort == listenPort {
+			f.forwards = append(f.forwards[:i], f.forwards[i+1:]...)
+			return nil
+		}
+	}
+	return ErrForwardNotFound
+}
+
+func TestForwardEndpoints(t *testing.T) {
+	provider := &fakeForwardProvider{fakeProvider: newFakeProvider()}
+	handler := NewServer(nil, provider).Handler("/api")
+
+	body := `{"listen_host":"127.0.0.1","listen_port":8443,"remote_host":"example.com","remote_port":443}`
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		want   int
+	}{
+		{"add", http.MethodPost, "/api/forwards", body, http.StatusOK},
+		{"add duplicate", http.MethodPost, "/api/forwards", body, http.StatusConflict},
+		{"add invalid", http.MethodPost, "/api/forwards", "{", http.StatusBadRequest},
+		{"list", http.MethodGet, "/api/forwards", "", http.StatusOK},
+		{"remove", http.MethodDelete, "/api/forwards/8443", "", http.StatusOK},
+		{"remove missing", http.MethodDelete, "/api/forwards/8443", "", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("%s: expected %d, got %d", tt.name, tt.want, rec.Code)
+		}
+	}
+
+	// Providers without forward support do not expose the endpoints
+	handler = NewServer(nil, newFakeProvider()).Handler("/api")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/forwards", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 without forward support, got %d", rec.Code)
+	}
+}
+
+type fakeQuotaProvider struct {
+	*fakeProvider
+	quotas []Quota
+}
+
+func (f *fakeQuotaProvider) Quotas() []Quota { return f.quotas }
+
+func (f *fakeQuotaProvider) ResetQuota(client string) error {
+	for i := range f.quotas {
+		if f.quotas[i].Client == client {
+			f.quotas[i].DayBytes = 0
+			f.quotas[i].MonthBytes = 0
+			return nil
+		}
+	}
+	return ErrClientNotFound
+}
+
+func TestQuotaEndpoints(t *testing.T) {
+	provider := &fakeQuotaProvider{
+		fakeProvider: newFakeProvider(),
+		quotas:       []Quota{{Client: "laptop", DailyLimit: 100, DayBytes: 100, MonthBytes: 100, Exceeded: "daily"}},
+	}
+	handler := NewServer(nil, provider).Handler("/api")
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/quotas", nil))
+	var quotas []Quota
+	if err := json.NewDecoder(rec.Body).Decode(&quotas); err != nil {
+		t.Fatalf("Failed to decode quotas: %v", err)
+	}
+	if len(quotas) != 1 || quotas[0].Client != "laptop" || quotas[0].Exceeded != "daily" {
+		t.Errorf("Expected laptop over its daily quota, got %+v", quotas)
+	}
+
+	tests := []struct {
+		path string
+		want int
+	}{
+		{"/api/quotas/laptop/reset", http.StatusOK},
+		{"/api/quotas/phone/reset", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, tt.path, nil))
+		if rec.Code != tt.want {
+			t.Errorf("%s: expected %d, got %d", tt.path, tt.want, rec.Code)
+		}
+	}
+	if provider.quotas[0].DayBytes != 0 {
+		t.Errorf("Expected laptop usage reset, got %d", provider.quotas[0].DayBytes)
+	}
+}
+
+type fakeBanProvider struct {
+	*fakeProvider
+	bans []Ban
+}
+
+func (f *fakeBanProvider) Bans() []Ban { return f.bans }
+
+func (f *fakeBanProvider) Unban(ip string) error {
+	for i := range f.bans {
+		if f.bans[i].IP == ip {
+			f.bans = append(f.bans[:i], f.bans[i+1:]...)
+			return nil
+		}
+	}
+	return ErrBanNotFound
+}
+
+func (f *fakeBanProvider) ClearBans() error {
+	f.bans = nil
+	return nil
+}
+
+func TestBanEndpoints(t *testing.T) {
+	provider := &fakeBanProvider{
+		fakeProvider: newFakeProvider(),
+		bans:         []Ban{{IP: "192.0.2.1", Reason: "rate_limit"}, {IP: "2001:db8::1", Reason: "handshake_failures"}},
+	}
+	handler := NewServer(nil, provider).Handler("/api")
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/bans", nil))
+	var bans []Ban
+	if err := json.NewDecoder(rec.Body).Decode(&bans); err != nil {
+		t.Fatalf("Failed to decode bans: %v", err)
+	}
+	if len(bans) != 2 || bans[0].IP != "192.0.2.1" || bans[0].Reason != "rate_limit" {
+		t.Errorf("Expected two bans, got %+v", bans)
+	}
+
+	tests := []struct {
+		path string
+		want int
+	}{
+		{"/api/bans/192.0.2.1", http.StatusOK},
+		{"/api/bans/192.0.2.1", http.StatusNotFound},
+		{"/api/bans", http.StatusOK},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, tt.path, nil))
+		if rec.Code != tt.want {
+			t.Errorf("%s: expected %d, got %d", tt.path, tt.want, rec.Code)
+		}
+	}
+	if len(provider.bans) != 0 {
+		t.Errorf("Expected every ban lifted, got %+v", provider.bans)
+	}
+}
+
+type fakeBreakerProvider struct {
+	*fakeProvider
+	breaker Breaker
+}
+
+func (f *fakeBreakerProvider) Breaker() *Breaker { return &f.breaker }
+
+func (f *fakeBreakerProvider) ResetBreaker() error {
+	f.breaker = Breaker{State: "closed"}
+	return nil
+}
+
+func TestBreakerEndpoints(t *testing.T) {
+	provider := &fakeBreakerProvider{
+		fakeProvider: newFakeProvider(),
+		breaker:      Breaker{State: "open", Failures: 5},
+	}
+	handler := NewServer(nil, provider).Handler("/api")
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/breaker", nil))
+	var breaker Breaker
+	if err := json.NewDecoder(rec.Body).Decode(&breaker); err != nil {
+		t.Fatalf("Failed to decode breaker: %v", err)
+	}
+	if breaker.State != "open" || breaker.Failures != 5 {
+		t.Errorf("Expected an open breaker, got %+v", breaker)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/breaker/reset", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected status %d, got %d", http.StatusOK, rec.Code)
+	}
+	if provider.breaker.State != "closed" {
+		t.Errorf("Expected the breaker to be reset, got %+v", provider.breaker)
+	}
+}
@@ -0,0 +1,102 @@
+// Package audit writes a structured record of the streams the Half-Tunnel
+// server carries.
+//
+// Each event is one JSON object per line, written to a file or to syslog.
+// Events are written regardless of the log level, so operators can keep a
+// connection record without enabling debug logging.
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// OutputSyslog selects the local syslog daemon as the audit log output.
+const OutputSyslog = "syslog"
+
+// Event types.
+const (
+	EventStreamOpen  = "stream_open"
+	EventStreamClose = "stream_close"
+)
+
+// Event is one audit record. Bytes, duration and reason are set for
+// stream_close events.
+type Event struct {
+	Time       time.Time `json:"time"`
+	Event      string    `json:"event"`
+	SessionID  string    `json:"session_id"`
+	StreamID   uint32    `json:"stream_id"`
+	Client     string    `json:"client,omitempty"`
+	Dest       string    `json:"dest"`
+	BytesUp    int64     `json:"bytes_up"`
+	BytesDown  int64     `json:"bytes_down"`
+	DurationMS int64     `json:"duration_ms"`
+	Reason     string    `json:"reason,omitempty"`
+}
+
+// Logger writes audit events. A nil Logger discards them.
+type Logger struct {
+	w      io.Writer
+	closer io.Closer
+	mu     sync.Mutex
+}
+
+// New creates a logger writing to w.
+func New(w io.Writer) *Logger {
+	return &Logger{w: w}
+}
+
+// Open creates a logger for output: OutputSyslog, or the path of a file
+// that events are appended to.
+func Open(output string) (*Logger, error) {
+	if output == OutputSyslog {
+		w, err := openSyslog()
+		if err != nil {
+			return nil, fmt.Errorf("failed to open syslog: %w", err)
+		}
+		return &Logger{w: w, closer: w}, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(output), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create audit log directory: %w", err)
+	}
+	file, err := os.OpenFile(output, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+	return &Logger{w: file, closer: file}, nil
+}
+
+// Log writes an event, stamping it with the current time if it has none.
+func (l *Logger) Log(e Event) error {
+	if l == nil {
+		return nil
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, err = l.w.Write(data)
+	return err
+}
+
+// Close closes the underlying file or syslog connection.
+func (l *Logger) Close() error {
+	if l == nil || l.closer == nil {
+		return nil
+	}
+	return l.closer.Close()
+}
@@ -0,0 +1,69 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLogWritesJSONLines(t *testing.T) {
+	var buf bytes.Buffer
+	l := New(&buf)
+
+	at := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+	if err := l.Log(Event{Time: at, Event: EventStreamOpen, SessionID: "s1", StreamID: 1, Dest: "example.com:443"}); err != nil {
+		t.Fatalf("Log failed: %v", err)
+	}
+	if err := l.Log(Event{Event: EventStreamClose, SessionID: "s1", StreamID: 1, Client: "laptop", BytesUp: 10, Reason: "client_fin"}); err != nil {
+		t.Fatalf("Log failed: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 lines, got %d", len(lines))
+	}
+	var open, closed Event
+	if err := json.Unmarshal([]byte(lines[0]), &open); err != nil {
+		t.Fatalf("Invalid event: %v", err)
+	}
+	if err := json.Unmarshal([]byte(lines[1]), &closed); err != nil {
+		t.Fatalf("Invalid event: %v", err)
+	}
+	if !open.Time.Equal(at) || open.Dest != "example.com:443" || open.Client != "" {
+		t.Errorf("Unexpected open event: %+v", open)
+	}
+	if closed.Time.IsZero() || closed.Client != "laptop" || closed.BytesUp != 10 || closed.Reason != "client_fin" {
+		t.Errorf("Unexpected close event: %+v", closed)
+	}
+
+	var none *Logger
+	if err := none.Log(Event{}); err != nil {
+		t.Errorf("Expected a nil logger to discard events, got %v", err)
+	}
+}
+
+func TestOpenFileAppends(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "log", "audit.log")
+	for i := 0; i < 2; i++ {
+		l, err := Open(path)
+		if err != nil {
+			t.Fatalf("Open failed: %v", err)
+		}
+		_ = l.Log(Event{Event: EventStreamOpen})
+		if err := l.Close(); err != nil {
+			t.Fatalf("Close failed: %v", err)
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile failed: %v", err)
+	}
+	if n := strings.Count(string(data), "\n"); n != 2 {
+		t.Errorf("Expected events appended across opens, got %d lines", n)
+	}
+}
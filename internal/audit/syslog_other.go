@@ -0,0 +1,13 @@
+//go:build windows || plan9
+
+package audit
+
+import (
+	"errors"
+	"io"
+)
+
+// openSyslog fails: syslog is not available on this platform.
+func openSyslog() (io.WriteCloser, error) {
+	return nil, errors.New("syslog is not supported on this platform")
+}
@@ -0,0 +1,371 @@
+// Package banlist rate limits tunnel connection attempts per source IP on the
+// Half-Tunnel server and temporarily bans sources that exceed the limits.
+//
+// Each source gets a token bucket for WebSocket upgrade attempts and a count
+// of failed handshakes. A source that runs out of attempts, or fails too many
+// handshakes within the failure window, is banned for the ban duration. Bans
+// can be kept in a JSON state file so they survive restarts.
+package banlist
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sahmadiut/half-tunnel/internal/statefile"
+)
+
+// Reasons a source is banned.
+const (
+	ReasonRateLimit = "rate_limit"
+	ReasonFailures  = "handshake_failures"
+)
+
+// ErrNotBanned is returned by Unban for a source that is not banned.
+var ErrNotBanned = errors.New("source not banned")
+
+// Config holds the limits. Zero rates and counts disable the corresponding
+// limit.
+type Config struct {
+	// AttemptsPerMinute is the sustained rate of upgrade attempts allowed per
+	// source
+	AttemptsPerMinute int
+	// Burst is the number of attempts a source may make at once
+	Burst int
+	// MaxFailures is the number of failed handshakes within FailureWindow
+	// that bans a source
+	MaxFailures   int
+	FailureWindow time.Duration
+	// BanDuration is how long a source stays banned
+	BanDuration time.Duration
+	// Exempt holds networks that are never limited, such as reverse proxies
+	Exempt []*net.IPNet
+}
+
+// Verdict is the outcome of an upgrade attempt.
+type Verdict int
+
+const (
+	// Allowed means the attempt may proceed.
+	Allowed Verdict = iota
+	// RateLimited means the attempt exceeded the rate limit and banned the
+	// source.
+	RateLimited
+	// Banned means the source was already banned.
+	Banned
+)
+
+// Ban describes a banned source.
+type Ban struct {
+	IP     string    `json:"ip"`
+	Reason string    `json:"reason"`
+	Since  time.Time `json:"since"`
+	Until  time.Time `json:"until"`
+}
+
+// State is the persisted ban list.
+type State struct {
+	Version int             `json:"version"`
+	Bans    map[string]*Ban `json:"bans"`
+}
+
+// Load reads a state file. A missing file yields an empty state.
+func Load(path string) (*State, error) {
+	state := &State{Version: 1, Bans: make(map[string]*Ban)}
+
+	if err := statefile.Load(path, "ban list", state); err != nil {
+		return nil, err
+	}
+	if state.Bans == nil {
+		state.Bans = make(map[string]*Ban)
+	}
+	return state, nil
+}
+
+// source tracks the attempts and failures of one source.
+type source struct {
+	tokens       float64
+	last         time.Time
+	failures     int
+	failureStart time.Time
+}
+
+// List tracks connection attempts per source and the sources banned for
+// them.
+type List struct {
+	path   string
+	config Config
+	now    func() time.Time
+
+	state   *State
+	sources map[string]*source
+	dirty   bool
+	mu      sync.Mutex
+
+	shutdown chan struct{}
+	wg       sync.WaitGroup
+}
+
+// New creates a ban list with the given limits. With a non-empty path, bans
+// are loaded from and written to that state file; otherwise they are only
+// kept in memory.
+func New(path string, config Config) (*List, error) {
+	state := &State{Version: 1, Bans: make(map[string]*Ban)}
+	if path != "" {
+		var err error
+		if state, err = Load(path); err != nil {
+			return nil, err
+		}
+	}
+
+	return &List{
+		path:     path,
+		config:   config,
+		now:      time.Now,
+		state:    state,
+		sources:  make(map[string]*source),
+		shutdown: make(chan struct{}),
+	}, nil
+}
+
+// exempt reports whether ip is in an exempt network.
+func (l *List) exempt(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, network := range l.config.Exempt {
+		if network.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// banned returns ip's ban if it is in effect, dropping an expired one. Must
+// be called with the lock held.
+func (l *List) banned(ip string, now time.Time) *Ban {
+	ban, ok := l.state.Bans[ip]
+	if !ok {
+		return nil
+	}
+	if !now.Before(ban.Until) {
+		delete(l.state.Bans, ip)
+		l.dirty = true
+		return nil
+	}
+	return ban
+}
+
+// ban bans ip for the ban duration. Must be called with the lock held.
+func (l *List) ban(ip, reason string, now time.Time) {
+	l.state.Bans[ip] = &Ban{IP: ip, Reason: reason, Since: now, Until: now.Add(l.config.BanDuration)}
+	delete(l.sources, ip)
+	l.dirty = true
+}
+
+// source returns the tracking state of ip, creating it if needed. Must be
+// called with the lock held.
+func (l *List) source(ip string, now time.Time) *source {
+	src, ok := l.sources[ip]
+	if !ok {
+		src = &source{tokens: float64(l.config.Burst), last: now}
+		l.sources[ip] = src
+	}
+	return src
+}
+
+// Banned reports whether ip is currently banned.
+func (l *List) Banned(ip string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.banned(ip, l.now()) != nil
+}
+
+// Attempt records an upgrade attempt from ip.
+func (l *List) Attempt(ip string) Verdict {
+	if l.exempt(ip) {
+		return Allowed
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	if l.banned(ip, now) != nil {
+		return Banned
+	}
+	if l.config.AttemptsPerMinute <= 0 {
+		return Allowed
+	}
+
+	src := l.source(ip, now)
+	rate := float64(l.config.AttemptsPerMinute) / 60
+	src.tokens += now.Sub(src.last).Seconds() * rate
+	if src.tokens > float64(l.config.Burst) {
+		src.tokens = float64(l.config.Burst)
+	}
+	src.last = now
+	if src.tokens < 1 {
+		l.ban(ip, ReasonRateLimit, now)
+		return RateLimited
+	}
+	src.tokens--
+	return Allowed
+}
+
+// Fail records a failed handshake from ip. It reports whether the failure
+// banned the source.
+func (l *List) Fail(ip string) bool {
+	if l.config.MaxFailures <= 0 || l.exempt(ip) {
+		return false
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	if l.banned(ip, now) != nil {
+		return false
+	}
+	src := l.source(ip, now)
+	if src.failures == 0 || now.Sub(src.failureStart) > l.config.FailureWindow {
+		src.failures = 0
+		src.failureStart = now
+	}
+	src.failures++
+	if src.failures < l.config.MaxFailures {
+		return false
+	}
+	l.ban(ip, ReasonFailures, now)
+	return true
+}
+
+// Bans returns the bans in effect, sorted by IP.
+func (l *List) Bans() []Ban {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	bans := make([]Ban, 0, len(l.state.Bans))
+	for ip := range l.state.Bans {
+		if ban := l.banned(ip, now); ban != nil {
+			bans = append(bans, *ban)
+		}
+	}
+	sort.Slice(bans, func(i, j int) bool { return bans[i].IP < bans[j].IP })
+	return bans
+}
+
+// Unban lifts the ban on ip.
+func (l *List) Unban(ip string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.banned(ip, l.now()) == nil {
+		return ErrNotBanned
+	}
+	delete(l.state.Bans, ip)
+	l.dirty = true
+	return nil
+}
+
+// Clear lifts every ban and returns how many were lifted.
+func (l *List) Clear() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	n := len(l.state.Bans)
+	l.state.Bans = make(map[string]*Ban)
+	l.dirty = true
+	return n
+}
+
+// prune forgets sources whose attempts and failures no longer matter and
+// drops expired bans.
+func (l *List) prune() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	for ip, src := range l.sources {
+		refilled := l.config.AttemptsPerMinute <= 0 ||
+			now.Sub(src.last) >= time.Duration(float64(l.config.Burst)/float64(l.config.AttemptsPerMinute)*float64(time.Minute))
+		failuresStale := src.failures == 0 || now.Sub(src.failureStart) > l.config.FailureWindow
+		if refilled && failuresStale {
+			delete(l.sources, ip)
+		}
+	}
+	for ip := range l.state.Bans {
+		l.banned(ip, now)
+	}
+}
+
+// Flush writes the state file if bans changed since the last flush. It does
+// nothing for a ban list kept in memory. A failed write leaves the bans
+// pending for the next flush.
+func (l *List) Flush() error {
+	if l.path == "" {
+		return nil
+	}
+
+	l.mu.Lock()
+	if !l.dirty {
+		l.mu.Unlock()
+		return nil
+	}
+	data, err := json.MarshalIndent(l.state, "", "  ")
+	if err != nil {
+		l.mu.Unlock()
+		return fmt.Errorf("failed to encode ban list: %w", err)
+	}
+	l.dirty = false
+	l.mu.Unlock()
+
+	if err := statefile.Save(l.path, "ban list", data); err != nil {
+		l.mu.Lock()
+		l.dirty = true
+		l.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// Start prunes idle sources and flushes the state file every interval until
+// Stop is called. Flush errors are passed to onError if it is non-nil.
+func (l *List) Start(interval time.Duration, onError func(error)) {
+	l.wg.Add(1)
+	go func() {
+		defer l.wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-l.shutdown:
+				return
+			case <-ticker.C:
+				l.prune()
+				if err := l.Flush(); err != nil && onError != nil {
+					onError(err)
+				}
+			}
+		}
+	}()
+}
+
+// Stop stops the background work and writes any pending bans.
+func (l *List) Stop() error {
+	select {
+	case <-l.shutdown:
+	default:
+		close(l.shutdown)
+	}
+	l.wg.Wait()
+	return l.Flush()
+}
@@ -0,0 +1,162 @@
+package banlist
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAttemptRateLimit(t *testing.T) {
+	l, err := New("", Config{AttemptsPerMinute: 60, Burst: 3, BanDuration: time.Hour})
+	if err != nil {
+		t.Fatalf("Failed to create ban list: %v", err)
+	}
+	now := time.Date(2024, 3, 29, 12, 0, 0, 0, time.UTC)
+	l.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		if got := l.Attempt("192.0.2.1"); got != Allowed {
+			t.Fatalf("Attempt %d: expected Allowed within the burst, got %v", i, got)
+		}
+	}
+	if got := l.Attempt("192.0.2.1"); got != RateLimited {
+		t.Fatalf("Expected RateLimited after the burst, got %v", got)
+	}
+	if got := l.Attempt("192.0.2.1"); got != Banned {
+		t.Errorf("Expected Banned after the rate limit, got %v", got)
+	}
+	if got := l.Attempt("192.0.2.2"); got != Allowed {
+		t.Errorf("Expected another source to be allowed, got %v", got)
+	}
+
+	// Bans expire after the ban duration
+	now = now.Add(time.Hour)
+	if l.Banned("192.0.2.1") {
+		t.Error("Expected the ban to expire")
+	}
+	if got := l.Attempt("192.0.2.1"); got != Allowed {
+		t.Errorf("Expected Allowed after the ban expired, got %v", got)
+	}
+}
+
+func TestAttemptRefills(t *testing.T) {
+	l, _ := New("", Config{AttemptsPerMinute: 60, Burst: 1, BanDuration: time.Hour})
+	now := time.Now()
+	l.now = func() time.Time { return now }
+
+	for i := 0; i < 5; i++ {
+		if got := l.Attempt("192.0.2.1"); got != Allowed {
+			t.Fatalf("Attempt %d: expected Allowed at the sustained rate, got %v", i, got)
+		}
+		now = now.Add(time.Second)
+	}
+}
+
+func TestFailuresBan(t *testing.T) {
+	l, _ := New("", Config{MaxFailures: 3, FailureWindow: time.Minute, BanDuration: time.Hour})
+	now := time.Now()
+	l.now = func() time.Time { return now }
+
+	// Failures outside the window do not add up
+	l.Fail("192.0.2.1")
+	l.Fail("192.0.2.1")
+	now = now.Add(2 * time.Minute)
+	if l.Fail("192.0.2.1") {
+		t.Fatal("Expected failures from an old window to be forgotten")
+	}
+	l.Fail("192.0.2.1")
+	if !l.Fail("192.0.2.1") {
+		t.Fatal("Expected the third failure in the window to ban the source")
+	}
+
+	bans := l.Bans()
+	if len(bans) != 1 || bans[0].IP != "192.0.2.1" || bans[0].Reason != ReasonFailures || !bans[0].Until.Equal(now.Add(time.Hour)) {
+		t.Errorf("Unexpected bans: %+v", bans)
+	}
+}
+
+func TestExemptNetworks(t *testing.T) {
+	_, exempt, _ := net.ParseCIDR("10.0.0.0/8")
+	l, _ := New("", Config{AttemptsPerMinute: 1, Burst: 1, MaxFailures: 1, FailureWindow: time.Minute, BanDuration: time.Hour, Exempt: []*net.IPNet{exempt}})
+
+	for i := 0; i < 5; i++ {
+		if got := l.Attempt("10.0.0.2"); got != Allowed {
+			t.Fatalf("Expected an exempt source to be allowed, got %v", got)
+		}
+	}
+	if l.Fail("10.0.0.2") {
+		t.Error("Expected an exempt source never to be banned")
+	}
+}
+
+func TestBansPersistAndClear(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "bans.json")
+	config := Config{MaxFailures: 1, FailureWindow: time.Minute, BanDuration: time.Hour}
+
+	l, err := New(path, config)
+	if err != nil {
+		t.Fatalf("Failed to create ban list: %v", err)
+	}
+	l.Fail("192.0.2.1")
+	l.Fail("2001:db8::1")
+	if err := l.Stop(); err != nil {
+		t.Fatalf("Failed to stop ban list: %v", err)
+	}
+
+	l, err = New(path, config)
+	if err != nil {
+		t.Fatalf("Failed to reload ban list: %v", err)
+	}
+	if !l.Banned("192.0.2.1") || !l.Banned("2001:db8::1") {
+		t.Fatalf("Expected bans to survive a restart, got %+v", l.Bans())
+	}
+
+	if err := l.Unban("192.0.2.1"); err != nil {
+		t.Errorf("Unban failed: %v", err)
+	}
+	if err := l.Unban("192.0.2.1"); err != ErrNotBanned {
+		t.Errorf("Expected ErrNotBanned, got %v", err)
+	}
+	if n := l.Clear(); n != 1 || len(l.Bans()) != 0 {
+		t.Errorf("Expected Clear to lift one ban, lifted %d, left %+v", n, l.Bans())
+	}
+}
+
+func TestFlushRetriesFailedWrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bans.json")
+	l, err := New(path, Config{AttemptsPerMinute: 60, Burst: 1, BanDuration: time.Hour})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	l.Attempt("192.0.2.1")
+	if got := l.Attempt("192.0.2.1"); got != RateLimited {
+		t.Fatalf("Expected the source banned, got %v", got)
+	}
+
+	// A directory in the way makes the write fail
+	if err := os.Mkdir(path, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(path, "keep"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Flush(); err == nil {
+		t.Fatal("Expected flush to fail")
+	}
+
+	if err := os.RemoveAll(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	state, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if state.Bans["192.0.2.1"] == nil {
+		t.Error("Expected the ban of the failed flush written")
+	}
+}
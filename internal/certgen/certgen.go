@@ -0,0 +1,131 @@
+// Package certgen creates self-signed TLS certificates for lab setups and
+// single-server deployments.
+package certgen
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// DefaultDir is where the server looks for its certificate and key by
+// default.
+const DefaultDir = "/etc/half-tunnel/certs"
+
+// SelfSigned creates an ECDSA P-256 key and a certificate for hosts, DNS
+// names or IP addresses, signed by that key and valid for validFor. The
+// first host is the certificate's common name. Both are returned PEM
+// encoded.
+func SelfSigned(hosts []string, validFor time.Duration) (certPEM, keyPEM []byte, err error) {
+	if len(hosts) == 0 {
+		return nil, nil, errors.New("at least one host is required")
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate key: %w", err)
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate serial number: %w", err)
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: hosts[0], Organization: []string{"Half-Tunnel"}},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(validFor),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create certificate: %w", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to encode key: %w", err)
+	}
+
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	return certPEM, keyPEM, nil
+}
+
+// WriteFiles writes a certificate and its key, creating their directories.
+// The key is readable by the owner only. Existing files are replaced only
+// if force is set.
+func WriteFiles(certPath, keyPath string, certPEM, keyPEM []byte, force bool) error {
+	if !force {
+		for _, path := range []string{certPath, keyPath} {
+			if _, err := os.Stat(path); err == nil {
+				return &os.PathError{Op: "write", Path: path, Err: os.ErrExist}
+			}
+		}
+	}
+	for _, file := range []struct {
+		path string
+		data []byte
+		perm os.FileMode
+	}{
+		{keyPath, keyPEM, 0600},
+		{certPath, certPEM, 0644},
+	} {
+		if err := os.MkdirAll(filepath.Dir(file.path), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(file.path, file.data, file.perm); err != nil {
+			return err
+		}
+		// WriteFile keeps the mode of an existing file
+		if err := os.Chmod(file.path, file.perm); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Fingerprint returns the base64-encoded SHA-256 hash of the public key
+// (SubjectPublicKeyInfo) of the first certificate in certPEM. It stays the
+// same when a certificate is renewed with the same key.
+func Fingerprint(certPEM []byte) (string, error) {
+	block, _ := pem.Decode(certPEM)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return "", errors.New("no PEM certificate found")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse certificate: %w", err)
+	}
+	return PublicKeyHash(cert), nil
+}
+
+// PublicKeyHash returns the base64-encoded SHA-256 hash of the certificate's
+// SubjectPublicKeyInfo.
+func PublicKeyHash(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,90 @@
+package certgen
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSelfSigned(t *testing.T) {
+	certPEM, keyPEM, err := SelfSigned([]string{"tunnel.example.com", "203.0.113.7"}, 30*24*time.Hour)
+	if err != nil {
+		t.Fatalf("SelfSigned() error = %v", err)
+	}
+
+	pair, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatalf("Expected a usable key pair: %v", err)
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cert.Subject.CommonName != "tunnel.example.com" {
+		t.Errorf("Expected common name tunnel.example.com, got %s", cert.Subject.CommonName)
+	}
+	for _, host := range []string{"tunnel.example.com", "203.0.113.7"} {
+		if err := cert.VerifyHostname(host); err != nil {
+			t.Errorf("Expected the certificate to be valid for %s: %v", host, err)
+		}
+	}
+	if d := time.Until(cert.NotAfter); d < 29*24*time.Hour || d > 31*24*time.Hour {
+		t.Errorf("Expected the certificate to expire in 30 days, got %v", d)
+	}
+
+	// The certificate verifies against itself, as a client CA file
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	if _, err := cert.Verify(x509.VerifyOptions{DNSName: "tunnel.example.com", Roots: pool}); err != nil {
+		t.Errorf("Expected the certificate to verify as its own CA: %v", err)
+	}
+
+	if _, _, err := SelfSigned(nil, time.Hour); err == nil {
+		t.Error("Expected error without hosts")
+	}
+}
+
+func TestWriteFilesAndFingerprint(t *testing.T) {
+	certPEM, keyPEM, err := SelfSigned([]string{"localhost"}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := filepath.Join(t.TempDir(), "certs")
+	certPath, keyPath := filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key")
+
+	if err := WriteFiles(certPath, keyPath, certPEM, keyPEM, false); err != nil {
+		t.Fatalf("WriteFiles() error = %v", err)
+	}
+	info, err := os.Stat(keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("Expected key mode 0600, got %v", info.Mode().Perm())
+	}
+	if err := WriteFiles(certPath, keyPath, certPEM, keyPEM, false); err == nil {
+		t.Error("Expected error for existing files")
+	}
+	if err := WriteFiles(certPath, keyPath, certPEM, keyPEM, true); err != nil {
+		t.Errorf("Expected force to replace the files: %v", err)
+	}
+
+	fingerprint, err := Fingerprint(certPEM)
+	if err != nil {
+		t.Fatalf("Fingerprint() error = %v", err)
+	}
+	pair, _ := tls.X509KeyPair(certPEM, keyPEM)
+	cert, _ := x509.ParseCertificate(pair.Certificate[0])
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	if want := base64.StdEncoding.EncodeToString(sum[:]); fingerprint != want {
+		t.Errorf("Expected fingerprint %s, got %s", want, fingerprint)
+	}
+	if _, err := Fingerprint(keyPEM); err == nil {
+		t.Error("Expected error for a key")
+	}
+}
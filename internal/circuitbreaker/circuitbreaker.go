@@ -273,6 +273,8 @@ type DestinationBreaker struct {
 	breakers map[string]*CircuitBreaker
 	config   *Config
 	mu       sync.RWMutex
+
+	onStateChange func(dest string, from, to State)
 }
 
 // NewDestinationBreaker creates a new DestinationBreaker with the given configuration.
@@ -310,10 +312,43 @@ func (db *DestinationBreaker) Get(dest string) *CircuitBreaker {
 
 	// Create new circuit breaker for this destination
 	cb = New(db.config)
+	if db.onStateChange != nil {
+		fn := db.onStateChange
+		cb.onStateChange = func(from, to State) { fn(dest, from, to) }
+	}
 	db.breakers[dest] = cb
 	return cb
 }
 
+// SetOnStateChange sets a callback for state transitions of any destination's
+// circuit breaker. It applies to breakers created after the call.
+func (db *DestinationBreaker) SetOnStateChange(fn func(dest string, from, to State)) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.onStateChange = fn
+}
+
+// Prune removes breakers that carry no state worth keeping: closed breakers
+// with no failures, or whose last failure is older than the open timeout.
+// It returns the number of breakers removed.
+func (db *DestinationBreaker) Prune() int {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	removed := 0
+	for dest, cb := range db.breakers {
+		stats := cb.Stats()
+		if stats.State != StateClosed {
+			continue
+		}
+		if stats.Failures == 0 || time.Since(stats.LastFailureTime) >= db.config.Timeout {
+			delete(db.breakers, dest)
+			removed++
+		}
+	}
+	return removed
+}
+
 // Remove removes the circuit breaker for the specified destination.
 func (db *DestinationBreaker) Remove(dest string) {
 	db.mu.Lock()
@@ -486,3 +486,55 @@ func TestDestinationBreaker_AllStats(t *testing.T) {
 		}
 	}
 }
+
+func TestDestinationBreaker_OnStateChange(t *testing.T) {
+	db := NewDestinationBreaker(&Config{
+		MaxFailures:         1,
+		Timeout:             time.Minute,
+		MaxHalfOpenRequests: 1,
+	})
+
+	var dests []string
+	db.SetOnStateChange(func(dest string, from, to State) {
+		if from == StateClosed && to == StateOpen {
+			dests = append(dests, dest)
+		}
+	})
+
+	db.RecordFailure("example.com:80")
+	db.RecordFailure("other.com:443")
+
+	if len(dests) != 2 || dests[0] != "example.com:80" || dests[1] != "other.com:443" {
+		t.Errorf("expected trips for both destinations, got %v", dests)
+	}
+}
+
+func TestDestinationBreaker_Prune(t *testing.T) {
+	db := NewDestinationBreaker(&Config{
+		MaxFailures:         2,
+		Timeout:             50 * time.Millisecond,
+		MaxHalfOpenRequests: 1,
+	})
+
+	db.RecordSuccess("healthy.com:80")
+	db.RecordFailure("flaky.com:80")
+	db.RecordFailure("down.com:80")
+	db.RecordFailure("down.com:80")
+
+	if removed := db.Prune(); removed != 1 {
+		t.Errorf("expected 1 breaker pruned, got %d", removed)
+	}
+	if db.Count() != 2 {
+		t.Errorf("expected 2 destinations, got %d", db.Count())
+	}
+
+	// Once the timeout passes the old failure is forgotten, but the open
+	// breaker (now half-open) is kept
+	time.Sleep(60 * time.Millisecond)
+	if removed := db.Prune(); removed != 1 {
+		t.Errorf("expected 1 breaker pruned, got %d", removed)
+	}
+	if db.Get("down.com:80").State() != StateHalfOpen {
+		t.Error("expected tripped destination to be kept")
+	}
+}
@@ -0,0 +1,288 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sahmadiut/half-tunnel/internal/admin"
+	"github.com/sahmadiut/half-tunnel/internal/protocol"
+)
+
+// maxReconnectHistory is the number of reconnect cycles kept for the admin API.
+const maxReconnectHistory = 20
+
+// maxClosedStreamHistory is the number of closed streams kept for the admin
+// API.
+const maxClosedStreamHistory = 50
+
+// AdminStatus returns a snapshot of the client's session, streams and
+// reconnect history for the admin API.
+func (c *Client) AdminStatus() *admin.Status {
+	c.metricsMu.RLock()
+	traffic := admin.Traffic{
+		BytesSent:       c.metrics.BytesSent,
+		BytesReceived:   c.metrics.BytesReceived,
+		PacketsSent:     c.metrics.PacketsSent,
+		PacketsReceived: c.metrics.PacketsReceived,
+	}
+	c.metricsMu.RUnlock()
+
+	status := &admin.Status{
+		Role:      "client",
+		Connected: c.IsConnected(),
+		StartedAt: c.createdAt,
+		Traffic:   traffic,
+		Sessions:  []admin.Session{},
+		Streams:   []admin.Stream{},
+		NAT:       []admin.NATEntry{},
+	}
+
+	status.Paths = c.pathRTTs()
+	status.Breaker = c.Breaker()
+	status.ClosedStreams = c.closedStreamHistory()
+
+	sess := c.session
+	if sess == nil {
+		status.Reconnects = c.reconnectHistory()
+		return status
+	}
+
+	c.streamConnsMu.RLock()
+	for _, sc := range c.streamConns {
+		stream := admin.Stream{
+			SessionID:    sess.ID,
+			StreamID:     sc.streamID,
+			Forward:      sc.forward,
+			Dest:         net.JoinHostPort(sc.destHost, strconv.Itoa(int(sc.destPort))),
+			BytesUp:      atomic.LoadInt64(&sc.bytesUp),
+			BytesDown:    atomic.LoadInt64(&sc.bytesDown),
+			CreatedAt:    sc.created,
+			LastActivity: sc.created,
+		}
+		if stats, ok := c.mux.GetStreamStats(sc.streamID); ok {
+			stream.PacketsUp = stats.PacketsSent
+			stream.PacketsDown = stats.PacketsRecv
+			stream.LastActivity = stats.LastActivity
+		}
+		status.Streams = append(status.Streams, stream)
+	}
+	c.streamConnsMu.RUnlock()
+
+	version, caps := sess.Protocol()
+	status.Sessions = append(status.Sessions, admin.Session{
+		ID:           sess.ID,
+		CreatedAt:    sess.CreatedAt,
+		LastActivity: sess.LastActivity(),
+		Streams:      len(status.Streams),
+		Protocol:     int(version),
+		Capabilities: protocol.Capability(caps).Names(),
+	})
+	status.Reconnects = c.reconnectHistory()
+	return status
+}
+
+// CloseStream closes one stream, telling the server with a FIN.
+func (c *Client) CloseStream(sessionID uuid.UUID, streamID uint32) error {
+	if sessionID != c.GetSessionID() {
+		return admin.ErrSessionNotFound
+	}
+
+	c.streamConnsMu.RLock()
+	_, exists := c.streamConns[streamID]
+	c.streamConnsMu.RUnlock()
+	if !exists {
+		return admin.ErrStreamNotFound
+	}
+
+	c.resetStream(streamID, protocol.Fin{Reason: protocol.CloseAdmin})
+	return nil
+}
+
+// DrainSession closes every stream of the current session. The tunnel stays
+// connected and new streams can still be opened.
+func (c *Client) DrainSession(sessionID uuid.UUID) error {
+	if sessionID != c.GetSessionID() {
+		return admin.ErrSessionNotFound
+	}
+
+	c.streamConnsMu.RLock()
+	streams := make([]uint32, 0, len(c.streamConns))
+	for streamID := range c.streamConns {
+		streams = append(streams, streamID)
+	}
+	c.streamConnsMu.RUnlock()
+
+	for _, streamID := range streams {
+		c.resetStream(streamID, protocol.Fin{Reason: protocol.CloseAdmin})
+	}
+
+	c.log.Info().
+		Str("session_id", sessionID.String()).
+		Int("streams", len(streams)).
+		Msg("Session drained")
+	return nil
+}
+
+// CloseSession drops the current session and reconnects with a new one.
+// It fails if automatic reconnection is disabled, since the client would
+// otherwise be left without a tunnel.
+func (c *Client) CloseSession(sessionID uuid.UUID) error {
+	if sessionID != c.GetSessionID() {
+		return admin.ErrSessionNotFound
+	}
+	if !c.shouldReconnect() {
+		return errors.New("reconnect is disabled; closing the session would stop the tunnel")
+	}
+
+	if err := c.DrainSession(sessionID); err != nil {
+		return err
+	}
+	c.triggerReconnect("control")
+	return nil
+}
+
+// recordReconnect appends a reconnect cycle to the history, dropping the
+// oldest entry once maxReconnectHistory is reached.
+func (c *Client) recordReconnect(r admin.Reconnect) {
+	c.reconnectsMu.Lock()
+	defer c.reconnectsMu.Unlock()
+
+	if len(c.reconnects) >= maxReconnectHistory {
+		c.reconnects = c.reconnects[1:]
+	}
+	c.reconnects = append(c.reconnects, r)
+}
+
+// reconnectHistory returns a copy of the reconnect history.
+func (c *Client) reconnectHistory() []admin.Reconnect {
+	c.reconnectsMu.Lock()
+	defer c.reconnectsMu.Unlock()
+
+	history := make([]admin.Reconnect, len(c.reconnects))
+	copy(history, c.reconnects)
+	return history
+}
+
+// recordClosedStream appends a closed stream to the history, dropping the
+// oldest entry once maxClosedStreamHistory is reached.
+func (c *Client) recordClosedStream(sc *streamConn, closedBy string, fin protocol.Fin) {
+	closed := admin.ClosedStream{
+		SessionID: c.GetSessionID(),
+		StreamID:  sc.streamID,
+		Forward:   sc.forward,
+		Dest:      net.JoinHostPort(sc.destHost, strconv.Itoa(int(sc.destPort))),
+		BytesUp:   atomic.LoadInt64(&sc.bytesUp),
+		BytesDown: atomic.LoadInt64(&sc.bytesDown),
+		CreatedAt: sc.created,
+		ClosedAt:  time.Now(),
+		ClosedBy:  closedBy,
+		Reason:    fin.Reason.String(),
+		Message:   fin.Message,
+	}
+
+	c.closedStreamsMu.Lock()
+	defer c.closedStreamsMu.Unlock()
+
+	if len(c.closedStreams) >= maxClosedStreamHistory {
+		c.closedStreams = c.closedStreams[1:]
+	}
+	c.closedStreams = append(c.closedStreams, closed)
+}
+
+// closedStreamHistory returns a copy of the closed stream history.
+func (c *Client) closedStreamHistory() []admin.ClosedStream {
+	c.closedStreamsMu.Lock()
+	defer c.closedStreamsMu.Unlock()
+
+	history := make([]admin.ClosedStream, len(c.closedStreams))
+	copy(history, c.closedStreams)
+	return history
+}
+
+// Forwards returns the active port forward rules.
+func (c *Client) Forwards() []admin.Forward {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	forwards := make([]admin.Forward, 0, len(c.config.PortForwards))
+	for _, pf := range c.config.PortForwards {
+		forwards = append(forwards, admin.Forward{
+			Name:       pf.Name,
+			ListenHost: pf.ListenHost,
+			ListenPort: pf.ListenPort,
+			RemoteHost: pf.RemoteHost,
+			RemotePort: pf.RemotePort,
+		})
+	}
+	return forwards
+}
+
+// AddForward starts a new port forward. If its listener cannot be started,
+// the rule is dropped again and the error returned.
+func (c *Client) AddForward(f admin.Forward) error {
+	if f.ListenPort <= 0 || f.ListenPort > 65535 || f.RemotePort <= 0 || f.RemotePort > 65535 {
+		return fmt.Errorf("invalid port forward %d -> %d", f.ListenPort, f.RemotePort)
+	}
+	if f.RemoteHost == "" {
+		return errors.New("remote host is required")
+	}
+	pf := PortForward{
+		Name:       f.Name,
+		ListenHost: f.ListenHost,
+		ListenPort: f.ListenPort,
+		RemoteHost: f.RemoteHost,
+		RemotePort: f.RemotePort,
+	}
+
+	c.forwardsMu.Lock()
+	defer c.forwardsMu.Unlock()
+
+	c.mu.RLock()
+	current := append([]PortForward(nil), c.config.PortForwards...)
+	c.mu.RUnlock()
+	for _, existing := range current {
+		if existing.ListenPort == pf.ListenPort {
+			return fmt.Errorf("%w on port %d", admin.ErrForwardExists, pf.ListenPort)
+		}
+	}
+
+	if err := c.UpdatePortForwards(append(current, pf)); err != nil {
+		_ = c.UpdatePortForwards(current)
+		return err
+	}
+
+	c.log.Info().
+		Str("name", pf.Name).
+		Int("listen_port", pf.ListenPort).
+		Str("remote", net.JoinHostPort(pf.RemoteHost, strconv.Itoa(pf.RemotePort))).
+		Msg("Port forward added")
+	return nil
+}
+
+// RemoveForward stops the port forward listening on listenPort. Streams
+// already opened through it are not affected.
+func (c *Client) RemoveForward(listenPort int) error {
+	c.forwardsMu.Lock()
+	defer c.forwardsMu.Unlock()
+
+	c.mu.RLock()
+	current := append([]PortForward(nil), c.config.PortForwards...)
+	c.mu.RUnlock()
+
+	remaining := make([]PortForward, 0, len(current))
+	for _, pf := range current {
+		if pf.ListenPort != listenPort {
+			remaining = append(remaining, pf)
+		}
+	}
+	if len(remaining) == len(current) {
+		return fmt.Errorf("%w on port %d", admin.ErrForwardNotFound, listenPort)
+	}
+
+	return c.UpdatePortForwards(remaining)
+}
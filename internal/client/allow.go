@@ -0,0 +1,107 @@
+package client
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// AllowList is a comma-separated list of CIDRs and IP addresses allowed to
+// connect to a local listener; empty allows everyone. It is a string so
+// PortForward stays comparable.
+type AllowList string
+
+// NewAllowList joins entries into an AllowList.
+func NewAllowList(entries []string) AllowList {
+	return AllowList(strings.Join(entries, ","))
+}
+
+// Networks parses the list. Bare IP addresses match only themselves.
+func (a AllowList) Networks() ([]*net.IPNet, error) {
+	if a == "" {
+		return nil, nil
+	}
+	var networks []*net.IPNet
+	for _, entry := range strings.Split(string(a), ",") {
+		entry = strings.TrimSpace(entry)
+		if _, network, err := net.ParseCIDR(entry); err == nil {
+			networks = append(networks, network)
+			continue
+		}
+		ip := net.ParseIP(entry)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid allow_from entry: %q", entry)
+		}
+		bits := 8 * net.IPv6len
+		if ip4 := ip.To4(); ip4 != nil {
+			ip, bits = ip4, 8*net.IPv4len
+		}
+		networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+	}
+	return networks, nil
+}
+
+// allowListener closes accepted connections from addresses outside allowed
+// and reports them to onReject.
+type allowListener struct {
+	net.Listener
+	allowed  []*net.IPNet
+	onReject func(addr net.Addr)
+}
+
+// Accept returns the next allowed connection.
+func (l *allowListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		if addrAllowed(l.allowed, conn.RemoteAddr()) {
+			return conn, nil
+		}
+		// Report before closing, so the rejection is counted by the time
+		// the peer sees the connection closed
+		l.onReject(conn.RemoteAddr())
+		conn.Close()
+	}
+}
+
+func addrAllowed(allowed []*net.IPNet, addr net.Addr) bool {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	for _, network := range allowed {
+		if network.Contains(tcpAddr.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// restrictListener limits listener to the addresses in allow, counting
+// rejected connections under name. An empty list returns listener as is; an
+// invalid one closes it.
+func (c *Client) restrictListener(listener net.Listener, allow AllowList, name string) (net.Listener, error) {
+	allowed, err := allow.Networks()
+	if err != nil {
+		listener.Close()
+		return nil, err
+	}
+	if len(allowed) == 0 {
+		return listener, nil
+	}
+	return &allowListener{
+		Listener: listener,
+		allowed:  allowed,
+		onReject: func(addr net.Addr) {
+			c.log.Debug().
+				Str("listener", name).
+				Str("remote_addr", addr.String()).
+				Msg("Rejected connection not in allow_from")
+			if c.config.Metrics != nil {
+				c.config.Metrics.RecordListenerRejected(name)
+			}
+		},
+	}, nil
+}
@@ -0,0 +1,119 @@
+package client
+
+import (
+	"context"
+	"time"
+
+	"github.com/sahmadiut/half-tunnel/internal/admin"
+	"github.com/sahmadiut/half-tunnel/internal/circuitbreaker"
+)
+
+// breakerMetricName is the circuit breaker metric name of the breaker around
+// connection attempts.
+const breakerMetricName = "reconnect"
+
+// newReconnectBreaker creates the circuit breaker around connection
+// attempts, exporting state changes when metrics are enabled. It returns nil
+// when the breaker is disabled.
+func (c *Client) newReconnectBreaker() *circuitbreaker.CircuitBreaker {
+	if c.config.ReconnectBreaker == nil {
+		return nil
+	}
+	breaker := circuitbreaker.New(c.config.ReconnectBreaker)
+	breaker.SetOnStateChange(func(from, to circuitbreaker.State) {
+		c.log.Debug().
+			Str("from", from.String()).
+			Str("to", to.String()).
+			Msg("Reconnect circuit breaker state changed")
+		if to == circuitbreaker.StateOpen {
+			c.log.Warn().
+				Dur("cooldown", c.config.ReconnectBreaker.Timeout).
+				Msg("Server unreachable repeatedly, pausing reconnects")
+		}
+
+		if c.config.Metrics == nil {
+			return
+		}
+		c.config.Metrics.SetCircuitBreakerState(breakerMetricName, int(to))
+		if to == circuitbreaker.StateOpen {
+			c.config.Metrics.RecordCircuitBreakerTrip(breakerMetricName)
+		}
+	})
+	return breaker
+}
+
+// waitForBreaker waits while the reconnect circuit breaker is open. It
+// returns an error if the client stops first.
+func (c *Client) waitForBreaker(ctx context.Context) error {
+	if c.breaker == nil {
+		return nil
+	}
+	for !c.breaker.Allow() {
+		wait := time.Until(c.breaker.Stats().OpenedAt.Add(c.config.ReconnectBreaker.Timeout))
+		if wait <= 0 {
+			// Another attempt holds the half-open trial
+			wait = time.Second
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-c.shutdown:
+			timer.Stop()
+			return context.Canceled
+		case <-c.breakerReset:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+	return nil
+}
+
+// recordConnectResult feeds the outcome of a connection attempt to the
+// reconnect circuit breaker.
+func (c *Client) recordConnectResult(err error) {
+	if c.breaker == nil {
+		return
+	}
+	if err != nil {
+		c.breaker.RecordFailure()
+	} else {
+		c.breaker.RecordSuccess()
+	}
+}
+
+// Breaker returns the state of the reconnect circuit breaker for the admin
+// API, or nil if it is disabled.
+func (c *Client) Breaker() *admin.Breaker {
+	if c.breaker == nil {
+		return nil
+	}
+	stats := c.breaker.Stats()
+	b := &admin.Breaker{
+		State:       stats.State.String(),
+		Failures:    stats.Failures,
+		LastFailure: stats.LastFailureTime,
+		OpenedAt:    stats.OpenedAt,
+	}
+	if stats.State == circuitbreaker.StateOpen {
+		b.RetryAt = stats.OpenedAt.Add(c.config.ReconnectBreaker.Timeout)
+	}
+	return b
+}
+
+// ResetBreaker closes the reconnect circuit breaker, ending a pause in
+// reconnects.
+func (c *Client) ResetBreaker() error {
+	if c.breaker == nil {
+		return nil
+	}
+	c.breaker.Reset()
+	select {
+	case c.breakerReset <- struct{}{}:
+	default:
+	}
+	c.log.Info().Msg("Reconnect circuit breaker reset")
+	return nil
+}
@@ -0,0 +1,22 @@
+package client
+
+import (
+	"github.com/sahmadiut/half-tunnel/internal/protocol"
+)
+
+// handleCorruptPacket drops a packet with a checksum mismatch and, if
+// ResetCorruptStreams is set, resets its stream, since the stream's data
+// has a gap.
+func (c *Client) handleCorruptPacket(pkt *protocol.Packet) {
+	c.log.Warn().
+		Uint32("stream_id", pkt.StreamID).
+		Uint32("seq", pkt.SeqNum).
+		Msg("Dropped packet with checksum mismatch")
+	if c.config.Metrics != nil {
+		c.config.Metrics.RecordPacketCorrupted("downstream")
+	}
+
+	if c.config.ResetCorruptStreams && pkt.StreamID != 0 {
+		c.resetStream(pkt.StreamID, protocol.Fin{Reason: protocol.CloseProtocolError, Message: "corrupted packet"})
+	}
+}
@@ -13,19 +13,31 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"net/http"
+	"net/url"
+	"strconv"
 	"sync"
 	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/sahmadiut/half-tunnel/internal/admin"
+	"github.com/sahmadiut/half-tunnel/internal/circuitbreaker"
 	"github.com/sahmadiut/half-tunnel/internal/constants"
+	"github.com/sahmadiut/half-tunnel/internal/health"
+	"github.com/sahmadiut/half-tunnel/internal/metrics"
 	"github.com/sahmadiut/half-tunnel/internal/mux"
+	"github.com/sahmadiut/half-tunnel/internal/notify"
+	"github.com/sahmadiut/half-tunnel/internal/pathtoken"
 	"github.com/sahmadiut/half-tunnel/internal/protocol"
 	"github.com/sahmadiut/half-tunnel/internal/retry"
+	"github.com/sahmadiut/half-tunnel/internal/routing"
 	"github.com/sahmadiut/half-tunnel/internal/session"
+	"github.com/sahmadiut/half-tunnel/internal/sockopt"
 	"github.com/sahmadiut/half-tunnel/internal/socks5"
 	"github.com/sahmadiut/half-tunnel/internal/transport"
+	"github.com/sahmadiut/half-tunnel/internal/usage"
 	"github.com/sahmadiut/half-tunnel/pkg/logger"
 )
 
@@ -36,6 +48,40 @@ type PortForward struct {
 	ListenPort int
 	RemoteHost string
 	RemotePort int
+	// AllowFrom restricts which client addresses may connect
+	AllowFrom AllowList
+	// Protocol is "tcp" (or empty) or "udp"; UDP forwards are not
+	// supported by the tunnel yet and fail to start
+	Protocol string
+	// Family restricts the listener to IPv4 or IPv6 (empty listens
+	// dual-stack)
+	Family sockopt.Family
+}
+
+// usageLabel returns the name under which the forward's traffic is recorded.
+func (pf PortForward) usageLabel() string {
+	if pf.Name != "" {
+		return pf.Name
+	}
+	return fmt.Sprintf("port-%d", pf.ListenPort)
+}
+
+// SOCKS5Listener is an additional SOCKS5 proxy listener, for example one on
+// the LAN with its own credentials next to the main one on localhost.
+type SOCKS5Listener struct {
+	// Name labels the streams opened through the listener in logs and
+	// metrics
+	Name string
+	// Addr is the local address to listen on
+	Addr string
+	// Family restricts the listener to IPv4 or IPv6 (empty listens
+	// dual-stack)
+	Family sockopt.Family
+	// AllowFrom restricts which client addresses may connect
+	AllowFrom AllowList
+	// Username and Password enable authentication when both are set
+	Username string
+	Password string
 }
 
 // Config holds client configuration.
@@ -48,6 +94,12 @@ type Config struct {
 	SOCKS5Addr string
 	// SOCKS5Enabled controls whether SOCKS5 proxy is started
 	SOCKS5Enabled bool
+	// SOCKS5Family restricts the SOCKS5 listener to IPv4 or IPv6 (empty
+	// listens dual-stack)
+	SOCKS5Family sockopt.Family
+	// SOCKS5AllowFrom restricts which client addresses may connect to the
+	// SOCKS5 proxy
+	SOCKS5AllowFrom AllowList
 	// ExitOnPortInUse controls whether to stop when local listener ports are already in use
 	ExitOnPortInUse bool
 	// ListenOnConnect controls whether local listeners start only after connection
@@ -55,45 +107,146 @@ type Config struct {
 	// SOCKS5Username and SOCKS5Password for optional authentication
 	SOCKS5Username string
 	SOCKS5Password string
+	// SOCKS5Listeners are SOCKS5 proxies started in addition to the one on
+	// SOCKS5Addr
+	SOCKS5Listeners []SOCKS5Listener
+	// Router decides which SOCKS5 and port-forward connections bypass the
+	// tunnel or are blocked (nil tunnels everything)
+	Router *routing.Router
 	// PortForwards is the list of port forwarding rules
 	PortForwards []PortForward
 	// Reconnection settings
 	ReconnectEnabled bool
 	ReconnectConfig  *retry.Config
+	// ReconnectBreaker pauses connection attempts for its timeout after
+	// repeated failures (nil retries with backoff only)
+	ReconnectBreaker *circuitbreaker.Config
+	// ReconnectLimit gives up reconnecting after too many failed attempts
+	// or too long (nil reconnects forever)
+	ReconnectLimit *ReconnectLimit
 	// Connection settings
 	PingInterval     time.Duration
 	WriteTimeout     time.Duration
 	ReadTimeout      time.Duration
 	DialTimeout      time.Duration
-	HandshakeTimeout time.Duration
+	HandshakeTimeout time.Duration // also the wait for the server's hello
 	UpstreamTLS      *tls.Config
 	DownstreamTLS    *tls.Config
 	ReadBufferSize   int
 	WriteBufferSize  int
+	// SendQueueSize is the number of packets queued per tunnel connection
+	// for its single writer; writes wait while it is full, at most
+	// WriteTimeout (0 writes directly)
+	SendQueueSize int
+	// StreamOpenTimeout is how long a new stream waits for a server that
+	// confirms stream opens to connect its destination; SOCKS5 clients get
+	// their reply once it did (0 replies at once, without waiting)
+	StreamOpenTimeout time.Duration
+	// Faults injects faults into the messages sent to the server, for tests
+	// (nil sends them faithfully)
+	Faults *transport.FaultConfig
+	// Extra handshake headers per direction (Host override, User-Agent, ...)
+	UpstreamHeader   http.Header
+	DownstreamHeader http.Header
+	// Outbound proxies for the WebSocket dials (nil dials directly)
+	UpstreamProxy   *url.URL
+	DownstreamProxy *url.URL
+	// Browser TLS fingerprints for the WebSocket dials (empty uses Go's
+	// ClientHello; see transport.Fingerprints)
+	UpstreamFingerprint   string
+	DownstreamFingerprint string
+	// TCP sets socket options on the tunnel, SOCKS5 and port-forward
+	// connections (nil keeps the OS defaults)
+	TCP *sockopt.TCPConfig
 	// Data flow monitoring settings
 	DataFlowMonitor *DataFlowMonitorConfig
+	// GuestToken is an optional server-issued guest token sent with the handshake
+	GuestToken string
+	// AuthToken is an optional client token identifying this client to the
+	// server, sent with the handshake instead of a guest token
+	AuthToken string
+	// PathSecret enables rotating HMAC tokens appended to the WebSocket paths
+	PathSecret string
+	// PathWindow is how often path tokens rotate
+	PathWindow time.Duration
+	// UpgradeSecret sends a cookie with a token derived from this secret on
+	// every WebSocket dial; servers with the same secret require it
+	UpgradeSecret string
+	// UpgradeWindow is how often upgrade cookie tokens rotate
+	UpgradeWindow time.Duration
+	// UpgradeCookie is the name of the upgrade cookie
+	UpgradeCookie string
+	// UsageStateFile persists cumulative traffic counters across restarts (empty disables)
+	UsageStateFile string
+	// UsageFlushInterval is how often usage counters are written to the state file
+	UsageFlushInterval time.Duration
+	// EchoProbe adds a readiness check that sends data through the tunnel
+	// to the server's echo target (requires diagnostics on the server)
+	EchoProbe bool
+	// RTTWarnThreshold logs a warning when a path's smoothed round-trip time
+	// rises above it (0 disables the warning)
+	RTTWarnThreshold time.Duration
+	// CompactHeader offers the server compact packet headers, which replace
+	// the session UUID with a 4-byte index
+	CompactHeader bool
+	// MaxPayloadSize is the largest packet payload offered to the server (0
+	// offers protocol.MaxPayloadSize). Larger values, up to
+	// protocol.MaxJumboPayloadSize, need compact headers
+	MaxPayloadSize int
+	// Checksum offers the server a checksum trailer on every packet, which
+	// detects packets corrupted on the wire
+	Checksum bool
+	// ResetCorruptStreams resets the stream of a packet with a checksum
+	// mismatch instead of only dropping the packet
+	ResetCorruptStreams bool
+	// MaxPadding pads each data packet sent upstream with up to this many
+	// random bytes if the server supports padding (0 disables padding)
+	MaxPadding int
+	// CoverTraffic sends dummy packets upstream at jittered intervals (nil
+	// disables cover traffic)
+	CoverTraffic *CoverTrafficConfig
+	// Degradation keeps the session and its streams across reconnects,
+	// queueing their data until the tunnel is back (nil closes the streams
+	// when the connection drops)
+	Degradation *health.DegradationConfig
+	// Metrics receives Prometheus metrics (optional)
+	Metrics *metrics.Collector
+	// Hooks are called on connection and stream events (optional)
+	Hooks *Hooks
+	// HookCommands are shell commands run on tunnel events (optional)
+	HookCommands *HookCommands
+	// Notifier sends notifications of critical events (optional)
+	Notifier *notify.Notifier
+	// NotifyAfterFailures is the number of failed reconnect attempts after
+	// which the Notifier is told that reconnecting keeps failing (0 never)
+	NotifyAfterFailures int
 }
 
 // DefaultConfig returns default client configuration.
 func DefaultConfig() *Config {
 	return &Config{
-		UpstreamURL:      "ws://localhost:8080/upstream",
-		DownstreamURL:    "ws://localhost:8081/downstream",
-		SOCKS5Addr:       "127.0.0.1:1080",
-		SOCKS5Enabled:    true,
-		ExitOnPortInUse:  false,
-		ListenOnConnect:  false,
-		PortForwards:     []PortForward{},
-		ReconnectEnabled: true,
-		ReconnectConfig:  retry.DefaultConfig(),
-		PingInterval:     30 * time.Second,
-		WriteTimeout:     10 * time.Second,
-		ReadTimeout:      60 * time.Second,
-		DialTimeout:      10 * time.Second,
-		HandshakeTimeout: 10 * time.Second,
-		ReadBufferSize:   constants.DefaultBufferSize,
-		WriteBufferSize:  constants.DefaultBufferSize,
-		DataFlowMonitor:  DefaultDataFlowMonitorConfig(),
+		UpstreamURL:         "ws://localhost:8080/upstream",
+		DownstreamURL:       "ws://localhost:8081/downstream",
+		SOCKS5Addr:          "127.0.0.1:1080",
+		SOCKS5Enabled:       true,
+		ExitOnPortInUse:     false,
+		ListenOnConnect:     false,
+		PortForwards:        []PortForward{},
+		ReconnectEnabled:    true,
+		ReconnectConfig:     retry.DefaultConfig(),
+		PingInterval:        30 * time.Second,
+		WriteTimeout:        10 * time.Second,
+		ReadTimeout:         60 * time.Second,
+		DialTimeout:         10 * time.Second,
+		HandshakeTimeout:    10 * time.Second,
+		StreamOpenTimeout:   30 * time.Second,
+		ReadBufferSize:      constants.DefaultBufferSize,
+		WriteBufferSize:     constants.DefaultBufferSize,
+		SendQueueSize:       256,
+		DataFlowMonitor:     DefaultDataFlowMonitorConfig(),
+		UsageFlushInterval:  time.Minute,
+		CompactHeader:       true,
+		ResetCorruptStreams: true,
 	}
 }
 
@@ -107,12 +260,48 @@ type Client struct {
 	downstream *transport.Connection
 	socks5     *socks5.Server
 
+	// Additional SOCKS5 servers, one per SOCKS5Listeners entry
+	socks5Listeners []*socks5.Server
+
 	// Data flow monitoring
 	dataFlowMonitor *DataFlowMonitor
 
-	// Port forward listeners
-	portForwardListeners []net.Listener
+	// Session handshake on the current connections, guarded by mu:
+	// handshakeDone once the tunnel was marked up or the session rejected,
+	// helloTimer to mark it up if the server sends no hello, helloWait
+	// counting the timers started
+	handshakeDone bool
+	helloTimer    *time.Timer
+	helloWait     uint64
+
+	// Persistent usage counters (nil when disabled)
+	usage *usage.Recorder
+
+	// Graceful degradation: stream data queued while reconnecting (nil when
+	// disabled). queueMu orders queueing against the flush after a
+	// reconnect
+	degradation      *health.GracefulDegradation
+	queueMu          sync.Mutex
+	droppedStreams   map[uint32]struct{} // streams that lost queued packets
+	droppedStreamsMu sync.Mutex
+
+	// Circuit breaker around connection attempts (nil when disabled);
+	// breakerReset wakes a reconnect paused by it
+	breaker      *circuitbreaker.CircuitBreaker
+	breakerReset chan struct{}
+
+	// Recent reconnect cycles, oldest first, for the admin API
+	reconnects   []admin.Reconnect
+	reconnectsMu sync.Mutex
+
+	// Recently closed streams, oldest first, for the admin API
+	closedStreams   []admin.ClosedStream
+	closedStreamsMu sync.Mutex
+
+	// Port forward listeners, keyed by the rule they serve
+	portForwardListeners map[PortForward]net.Listener
 	listenersStarted     bool
+	forwardsMu           sync.Mutex // serializes runtime forward changes
 
 	// Stream management
 	streamConns   map[uint32]*streamConn
@@ -127,23 +316,62 @@ type Client struct {
 	unknownStreamLastLog  int64 // Unix timestamp
 
 	// State
+	createdAt        time.Time
 	running          int32
 	reconnecting     int32
+	sessionExpired   int32 // set when the server closed the session
 	lastKeepAliveAck int64
+	sessionIndex     uint32 // compact header index from the server's hello
+	compactHeader    int32  // set while packets are sent with compact headers
+	upstreamRTT      rttEstimator
+	downstreamRTT    rttEstimator
 	ctx              context.Context
 	cancel           context.CancelFunc
 	shutdown         chan struct{}
+	stopped          context.Context // cancelled with shutdown
+	stopStopped      context.CancelFunc
 	wg               sync.WaitGroup
 	mu               sync.RWMutex
+
+	// hookCommands queues the HookCommands to run (nil without them)
+	hookCommands chan hookCommand
+
+	// err is why the client stopped by itself (see Err)
+	errMu sync.Mutex
+	err   error
 }
 
 var dialTransport = transport.Dial
 
+// errUDPForward is returned for UDP port forwards, which the tunnel cannot
+// carry yet.
+var errUDPForward = errors.New("UDP port forwards are not supported")
+
 // streamConn holds the connection associated with a stream.
 type streamConn struct {
-	conn     net.Conn
-	streamID uint32
-	done     chan struct{}
+	conn      net.Conn
+	streamID  uint32
+	forward   string // usage label: the SOCKS5 listener or port forward name
+	destHost  string // destination host, for per-destination metrics
+	destPort  uint16
+	created   time.Time
+	bytesUp   int64 // updated atomically
+	bytesDown int64 // updated atomically
+	done      chan struct{}
+
+	// open, if set, is called once with the outcome of opening the stream,
+	// nil if the destination is connected, before any of the stream's data
+	// is written to conn and before conn is closed (see finishOpen)
+	open     func(err error) error
+	openOnce sync.Once
+	opened   chan struct{} // closed once the open is finished
+	openErr  error         // set before opened is closed
+
+	// finSeq and fin are the sequence number and reason of a FIN from the
+	// server that overtook data packets (see deferFin), set if finDeferred
+	finDeferred bool
+	finSeq      uint32
+	fin         protocol.Fin
 }
 
 // ConnectionMetrics holds metrics for monitoring data transfer.
@@ -174,13 +402,25 @@ func New(config *Config, log *logger.Logger) *Client {
 	if config.DataFlowMonitor == nil {
 		config.DataFlowMonitor = DefaultDataFlowMonitorConfig()
 	}
+	if config.UsageFlushInterval <= 0 {
+		config.UsageFlushInterval = time.Minute
+	}
 
 	client := &Client{
-		config:          config,
-		log:             log,
-		streamConns:     make(map[uint32]*streamConn),
-		shutdown:        make(chan struct{}),
-		dataFlowMonitor: NewDataFlowMonitor(config.DataFlowMonitor, log.WithStr("component", "dataflow")),
+		config:               config,
+		log:                  log,
+		portForwardListeners: make(map[PortForward]net.Listener),
+		streamConns:          make(map[uint32]*streamConn),
+		createdAt:            time.Now(),
+		shutdown:             make(chan struct{}),
+		dataFlowMonitor:      NewDataFlowMonitor(config.DataFlowMonitor, log.Component("dataflow")),
+	}
+	client.stopped, client.stopStopped = context.WithCancel(context.Background())
+	client.degradation = client.newDegradation()
+	client.breaker = client.newReconnectBreaker()
+	client.breakerReset = make(chan struct{}, 1)
+	if config.HookCommands != nil {
+		client.hookCommands = make(chan hookCommand, hookCommandQueueSize)
 	}
 
 	return client
@@ -196,6 +436,11 @@ func (c *Client) Start(ctx context.Context) error {
 	c.ctx = ctx
 	c.cancel = cancel
 
+	if c.hookCommands != nil {
+		c.wg.Add(1)
+		go c.hookCommandLoop(ctx)
+	}
+
 	// Create a new session
 	c.session = session.New()
 	c.mux = mux.NewMultiplexer(c.session)
@@ -208,7 +453,9 @@ func (c *Client) Start(ctx context.Context) error {
 	c.mux.SetPacketHandler(c.sendPacket)
 
 	connected := false
-	if err := c.connect(ctx); err != nil {
+	err := c.connect(ctx)
+	c.recordConnectResult(err)
+	if err != nil {
 		if c.shouldReconnect() && ctx.Err() == nil {
 			c.log.Warn().Err(err).Msg("Initial connection failed, starting reconnect loop")
 			c.triggerReconnect("startup")
@@ -219,6 +466,7 @@ func (c *Client) Start(ctx context.Context) error {
 		}
 	} else {
 		connected = true
+		c.hookConnected()
 		// Start downstream reader goroutine
 		c.wg.Add(1)
 		go c.readDownstream(ctx)
@@ -229,6 +477,11 @@ func (c *Client) Start(ctx context.Context) error {
 		go c.keepaliveLoop(ctx)
 	}
 
+	if c.config.CoverTraffic != nil && c.config.CoverTraffic.Interval > 0 {
+		c.wg.Add(1)
+		go c.coverTrafficLoop(ctx)
+	}
+
 	if !c.config.ListenOnConnect || connected {
 		if err := c.startLocalListeners(ctx); err != nil {
 			cancel()
@@ -243,8 +496,12 @@ func (c *Client) Start(ctx context.Context) error {
 	c.log.Info().
 		Dur("check_interval", c.config.DataFlowMonitor.CheckInterval).
 		Dur("stall_threshold", c.config.DataFlowMonitor.StallThreshold).
+		Str("action", c.config.DataFlowMonitor.StallAction.String()).
 		Msg("Data flow monitor started")
 
+	// Start persistent usage accounting
+	c.startUsageRecorder()
+
 	// Start periodic metrics logging
 	c.wg.Add(1)
 	go c.logMetricsPeriodically(ctx)
@@ -252,6 +509,51 @@ func (c *Client) Start(ctx context.Context) error {
 	return nil
 }
 
+// startUsageRecorder opens the usage state file and starts periodic flushing.
+// Failures are logged and leave usage accounting disabled.
+func (c *Client) startUsageRecorder() {
+	if c.config.UsageStateFile == "" {
+		return
+	}
+
+	recorder, err := usage.NewRecorder(c.config.UsageStateFile)
+	if err != nil {
+		c.log.Warn().Err(err).
+			Str("path", c.config.UsageStateFile).
+			Msg("Failed to open usage state, usage accounting disabled")
+		return
+	}
+	recorder.Start(c.config.UsageFlushInterval, func(err error) {
+		c.log.Warn().Err(err).Msg("Failed to save usage state")
+	})
+
+	c.mu.Lock()
+	c.usage = recorder
+	c.mu.Unlock()
+
+	c.log.Info().
+		Str("path", c.config.UsageStateFile).
+		Dur("flush_interval", c.config.UsageFlushInterval).
+		Msg("Usage accounting started")
+}
+
+// recordUsage adds traffic for a stream to the persistent usage counters and
+// the per-destination metrics.
+func (c *Client) recordUsage(sc *streamConn, upload, download int64) {
+	c.mu.RLock()
+	recorder := c.usage
+	c.mu.RUnlock()
+
+	atomic.AddInt64(&sc.bytesUp, upload)
+	atomic.AddInt64(&sc.bytesDown, download)
+	if recorder != nil {
+		recorder.Add(sc.forward, upload, download)
+	}
+	if c.config.Metrics != nil {
+		c.config.Metrics.RecordStreamBytes(sc.destHost, sc.forward, int(upload+download))
+	}
+}
+
 // Stop stops the client gracefully.
 func (c *Client) Stop() error {
 	if !atomic.CompareAndSwapInt32(&c.running, 1, 0) {
@@ -262,6 +564,7 @@ func (c *Client) Stop() error {
 		c.cancel()
 	}
 	close(c.shutdown)
+	c.stopStopped()
 	c.cleanup()
 
 	c.log.Info().Msg("Client stopped")
@@ -281,18 +584,30 @@ func (c *Client) cleanup() {
 		c.dataFlowMonitor.Stop()
 	}
 
-	// Close SOCKS5 server
+	// Save usage counters
+	if c.usage != nil {
+		if err := c.usage.Stop(); err != nil {
+			c.log.Warn().Err(err).Msg("Failed to save usage state")
+		}
+		c.usage = nil
+	}
+
+	// Close SOCKS5 servers
 	if c.socks5 != nil {
 		c.socks5.Close()
 		c.socks5 = nil
 	}
+	for _, server := range c.socks5Listeners {
+		server.Close()
+	}
+	c.socks5Listeners = nil
 	c.listenersStarted = false
 
 	// Close port forward listeners
 	for _, listener := range c.portForwardListeners {
 		listener.Close()
 	}
-	c.portForwardListeners = nil
+	c.portForwardListeners = make(map[PortForward]net.Listener)
 
 	// Close all stream connections
 	c.streamConnsMu.Lock()
@@ -314,6 +629,7 @@ func (c *Client) cleanup() {
 
 // handleDataFlowStall is called when data flow stalls.
 func (c *Client) handleDataFlowStall(action StallAction) {
+	c.hookStalled(action)
 	switch action {
 	case StallActionLog:
 		// Already logged by the monitor
@@ -331,7 +647,12 @@ func (c *Client) handleDataFlowStall(action StallAction) {
 
 // sendHandshake sends the initial handshake packet to both upstream and downstream.
 func (c *Client) sendHandshake() error {
-	pkt, err := protocol.NewPacket(c.session.ID, 0, protocol.FlagHandshake, nil)
+	// Until the server's hello confirms the session index again, packets
+	// carry the session UUID
+	atomic.StoreInt32(&c.compactHeader, 0)
+	c.session.SetMaxPayload(0)
+
+	pkt, err := protocol.NewPacket(c.session.ID, 0, protocol.FlagHandshake, c.handshakePayload())
 	if err != nil {
 		return err
 	}
@@ -351,11 +672,52 @@ func (c *Client) sendHandshake() error {
 		return fmt.Errorf("failed to send handshake to downstream: %w", err)
 	}
 
+	// Offer our version and capabilities; the server answers on downstream.
+	// Servers that predate the exchange ignore it.
+	hello, err := protocol.NewHelloPacket(c.session.ID, c.localHello())
+	if err != nil {
+		return err
+	}
+	data, err = hello.Marshal()
+	if err != nil {
+		return err
+	}
+	if err := c.downstream.Write(data); err != nil {
+		return fmt.Errorf("failed to send hello to downstream: %w", err)
+	}
+
 	return nil
 }
 
-// sendPacket sends a packet through the upstream connection.
+// handshakePayload returns the token sent with the session handshake, if any.
+func (c *Client) handshakePayload() []byte {
+	if c.config.AuthToken != "" {
+		return []byte(c.config.AuthToken)
+	}
+	if c.config.GuestToken != "" {
+		return []byte(c.config.GuestToken)
+	}
+	return nil
+}
+
+// sendPacket sends a packet through the upstream connection. While the
+// client reconnects, stream packets are queued instead if graceful
+// degradation is enabled.
 func (c *Client) sendPacket(pkt *protocol.Packet) error {
+	if c.queuePacket(pkt) {
+		return nil
+	}
+	err := c.writeUpstream(pkt)
+	if err != nil && c.queuePacket(pkt) {
+		// The failed write started a reconnect; the packet is sent after it
+		return nil
+	}
+	return err
+}
+
+// writeUpstream writes a packet to the upstream connection, reconnecting if
+// the connection is gone.
+func (c *Client) writeUpstream(pkt *protocol.Packet) error {
 	c.mu.RLock()
 	upstream := c.upstream
 	c.mu.RUnlock()
@@ -365,7 +727,9 @@ func (c *Client) sendPacket(pkt *protocol.Packet) error {
 		}
 		return transport.ErrConnectionClosed
 	}
-	data, err := pkt.Marshal()
+	// Padding added by marshalPacket does not count as data
+	payloadLen := len(pkt.Payload)
+	data, err := c.marshalPacket(pkt)
 	if err != nil {
 		return err
 	}
@@ -374,14 +738,15 @@ func (c *Client) sendPacket(pkt *protocol.Packet) error {
 	c.recordPacketSent(int64(len(data)))
 
 	if err := upstream.Write(data); err != nil {
+		c.recordError("upstream_write")
 		if c.shouldReconnect() {
 			c.triggerReconnect("upstream")
 		}
 		return err
 	}
 	// Record data flow for monitoring (only count data packets, not control packets)
-	if pkt.IsData() && len(pkt.Payload) > 0 {
-		c.dataFlowMonitor.RecordSend(int64(len(pkt.Payload)))
+	if pkt.IsData() && payloadLen > 0 {
+		c.dataFlowMonitor.RecordSend(int64(payloadLen))
 	}
 	return nil
 }
@@ -414,6 +779,7 @@ func (c *Client) readDownstream(ctx context.Context) {
 		if err != nil {
 			if !downstream.IsClosed() {
 				c.log.Error().Err(err).Msg("Error reading from downstream")
+				c.recordError("downstream_read")
 			}
 			if c.shouldReconnect() {
 				c.triggerReconnect("downstream")
@@ -424,9 +790,14 @@ func (c *Client) readDownstream(ctx context.Context) {
 		// Record received packet metrics
 		c.recordPacketReceived(int64(len(data)))
 
-		pkt, err := protocol.Unmarshal(data)
+		pkt, err := c.unmarshalPacket(data)
 		if err != nil {
 			c.log.Error().Err(err).Msg("Error unmarshaling packet")
+			c.recordError("protocol")
+			continue
+		}
+		if !pkt.ChecksumValid() {
+			c.handleCorruptPacket(pkt)
 			continue
 		}
 
@@ -448,19 +819,45 @@ func (c *Client) handleDownstreamPacket(pkt *protocol.Packet) {
 
 	if pkt.IsKeepAlive() && pkt.IsAck() {
 		c.recordKeepAliveAck()
+		c.recordRTT(pkt.Payload)
 		return
 	}
 
 	if pkt.IsKeepAlive() {
-		if err := c.sendKeepAliveAck(); err != nil {
+		if err := c.sendKeepAliveAck(pkt.Payload); err != nil {
 			c.log.Debug().Err(err).Msg("Failed to send keepalive ack")
 		}
 		return
 	}
 
+	if pkt.IsControlMessage() {
+		c.handleControlPacket(pkt)
+		return
+	}
+
+	if pkt.IsHandshake() && pkt.IsAck() && pkt.StreamID != 0 {
+		c.handleOpenResult(pkt)
+		return
+	}
+
+	// The server forgot the stream, so its ID may be reused
+	if pkt.IsFin() && pkt.IsAck() {
+		c.mux.ReleaseStream(pkt.StreamID)
+		return
+	}
+
 	// Handle FIN packets
 	if pkt.IsFin() {
-		c.closeStream(pkt.StreamID)
+		fin := protocol.ParseFin(pkt.Payload)
+		c.log.Debug().
+			Uint32("stream_id", pkt.StreamID).
+			Str("reason", fin.Reason.String()).
+			Str("message", fin.Message).
+			Msg("Stream closed by server")
+		if seqAfter(pkt.SeqNum, c.mux.NextSeq(pkt.StreamID)) && c.deferFin(pkt.StreamID, pkt.SeqNum, fin) {
+			return
+		}
+		c.closeStream(pkt.StreamID, admin.ClosedByPeer, fin)
 		return
 	}
 
@@ -496,7 +893,7 @@ func (c *Client) handleDownstreamPacket(pkt *protocol.Packet) {
 			c.log.Error().Err(err).
 				Uint32("stream_id", pkt.StreamID).
 				Msg("Error handling packet in multiplexer")
-			c.closeStream(pkt.StreamID)
+			c.resetStream(pkt.StreamID, protocol.Fin{Reason: protocol.CloseProtocolError, Message: err.Error()})
 			return
 		}
 
@@ -506,7 +903,7 @@ func (c *Client) handleDownstreamPacket(pkt *protocol.Packet) {
 			c.log.Error().Err(err).
 				Uint32("stream_id", pkt.StreamID).
 				Msg("Error reading from stream buffer")
-			c.closeStream(pkt.StreamID)
+			c.resetStream(pkt.StreamID, protocol.Fin{Reason: protocol.CloseProtocolError, Message: err.Error()})
 			return
 		}
 
@@ -516,12 +913,141 @@ func (c *Client) handleDownstreamPacket(pkt *protocol.Packet) {
 				c.log.Error().Err(err).
 					Uint32("stream_id", pkt.StreamID).
 					Msg("Error writing to client")
-				c.closeStream(pkt.StreamID)
+				c.resetStream(pkt.StreamID, protocol.Fin{Reason: protocol.CloseWriteError, Message: err.Error()})
+				return
 			}
+			c.recordUsage(sc, 0, int64(len(data)))
+		}
+		if sc.finDeferred && !seqAfter(sc.finSeq, c.mux.NextSeq(pkt.StreamID)) {
+			c.closeStream(pkt.StreamID, admin.ClosedByPeer, sc.fin)
 		}
 	}
 }
 
+// handleControlPacket handles session-level control messages from the server.
+func (c *Client) handleControlPacket(pkt *protocol.Packet) {
+	ctrl, body, err := protocol.ParseControl(pkt)
+	if err != nil {
+		c.log.Debug().Err(err).Msg("Ignoring malformed control packet")
+		return
+	}
+
+	switch ctrl {
+	case protocol.ControlSessionWarning:
+		limit, err := protocol.ParseSessionLimit(body)
+		if err != nil {
+			c.log.Debug().Err(err).Msg("Ignoring malformed session warning")
+			return
+		}
+		event := c.log.Warn().Str("reason", limit.Reason.String())
+		if limit.Reason == protocol.LimitTTL {
+			event = event.Dur("remaining", time.Duration(limit.Remaining)*time.Second)
+		} else {
+			event = event.Uint64("remaining_bytes", limit.Remaining)
+		}
+		event.Msg("Session limit approaching")
+	case protocol.ControlSessionExpired:
+		limit, err := protocol.ParseSessionLimit(body)
+		if err != nil {
+			c.log.Debug().Err(err).Msg("Ignoring malformed session expiry")
+			return
+		}
+		if limit.Reason == protocol.LimitSessions || limit.Reason == protocol.LimitIdle {
+			// Evicted or expired by the server; the reconnect that follows
+			// the closed connection starts a new session
+			atomic.StoreInt32(&c.sessionExpired, 1)
+			c.log.Warn().
+				Str("reason", limit.Reason.String()).
+				Msg("Session closed by server, reconnecting")
+			return
+		}
+		c.log.Error().
+			Str("reason", limit.Reason.String()).
+			Msg("Session closed by server: guest limit reached, stopping client")
+		// Reconnecting with the same token would be rejected
+		go func() {
+			_ = c.Stop()
+		}()
+	case protocol.ControlSessionRejected:
+		limit, err := protocol.ParseSessionLimit(body)
+		if err != nil {
+			c.log.Debug().Err(err).Msg("Ignoring malformed session rejection")
+			return
+		}
+		c.log.Error().
+			Str("reason", limit.Reason.String()).
+			Msg("Session rejected by server: limit reached, retrying with backoff")
+		c.rejectHandshake()
+	case protocol.ControlHello:
+		hello, err := protocol.ParseHello(body)
+		if err != nil {
+			c.log.Debug().Err(err).Msg("Ignoring malformed hello")
+			return
+		}
+		c.session.SetProtocol(hello.Version, uint32(hello.Capabilities))
+		c.useSessionIndex(hello)
+		c.usePayloadSize(hello)
+		c.log.Info().
+			Uint8("version", hello.Version).
+			Str("capabilities", hello.Capabilities.String()).
+			Int("max_payload", c.session.MaxPayload()).
+			Msg("Protocol negotiated with server")
+		// The server's hello completes the handshake, unless the hello
+		// timeout did already
+		c.completeHandshake(0)
+	case protocol.ControlStreamError:
+		streamErr, err := protocol.ParseStreamError(body)
+		if err != nil {
+			c.log.Debug().Err(err).Msg("Ignoring malformed stream error")
+			return
+		}
+		c.handleStreamError(streamErr)
+	default:
+		c.log.Debug().Uint8("type", uint8(ctrl)).Msg("Ignoring unknown control message")
+	}
+}
+
+// handleStreamError closes a stream the server could not serve.
+func (c *Client) handleStreamError(streamErr protocol.StreamError) {
+	switch streamErr.Code {
+	case protocol.StreamErrorBlocked:
+		c.log.Warn().
+			Uint32("stream_id", streamErr.StreamID).
+			Msg("Destination blocked by server policy")
+	case protocol.StreamErrorQuotaExceeded:
+		c.log.Warn().
+			Uint32("stream_id", streamErr.StreamID).
+			Msg("Stream refused by server: traffic quota used up")
+	case protocol.StreamErrorOverloaded:
+		c.log.Warn().
+			Uint32("stream_id", streamErr.StreamID).
+			Msg("Stream refused by server: server overloaded")
+	default:
+		c.log.Warn().
+			Uint32("stream_id", streamErr.StreamID).
+			Str("code", streamErr.Code.String()).
+			Uint16("attempts", streamErr.Attempts).
+			Msg("Server could not connect to destination")
+	}
+	c.finishOpen(streamErr.StreamID, &openError{result: protocol.OpenResult{Code: streamErr.Code, Attempts: streamErr.Attempts}})
+	c.closeStream(streamErr.StreamID, admin.ClosedByPeer, streamErrorFin(streamErr))
+}
+
+// streamErrorFin returns the close reason of a stream the server could not
+// serve.
+func streamErrorFin(e protocol.StreamError) protocol.Fin {
+	switch e.Code {
+	case protocol.StreamErrorBlocked:
+		return protocol.Fin{Reason: protocol.ClosePolicy}
+	case protocol.StreamErrorQuotaExceeded:
+		return protocol.Fin{Reason: protocol.CloseQuota}
+	case protocol.StreamErrorOverloaded:
+		return protocol.Fin{Reason: protocol.CloseOverloaded}
+	default:
+		return protocol.Fin{Reason: protocol.CloseDialFailed, Message: e.Code.String()}
+	}
+}
+
 // logUnknownStreamRateLimited logs unknown stream messages with rate limiting.
 // Only logs once per second, with a count of suppressed messages.
 func (c *Client) logUnknownStreamRateLimited(streamID uint32) {
@@ -545,55 +1071,73 @@ func (c *Client) logUnknownStreamRateLimited(streamID uint32) {
 	}
 }
 
-// handleConnect handles a SOCKS5 CONNECT request.
-func (c *Client) handleConnect(ctx context.Context, req *socks5.ConnectRequest) error {
+// handleConnect handles a SOCKS5 CONNECT request received by server, the
+// SOCKS5 listener called name.
+func (c *Client) handleConnect(ctx context.Context, server *socks5.Server, name string, req *socks5.ConnectRequest) error {
+	switch c.route(ctx, name, req.DestHost, req.DestPort) {
+	case routing.ActionBlock:
+		_ = server.SendFailureReply(req.ClientConn, socks5.ReplyNotAllowed)
+		return errBlocked
+	case routing.ActionDirect:
+		return c.connectDirect(ctx, server, req)
+	}
+
 	if atomic.LoadInt32(&c.reconnecting) == 1 {
-		_ = c.socks5.SendFailureReply(req.ClientConn, socks5.ReplyGeneralFailure)
+		_ = server.SendFailureReply(req.ClientConn, socks5.ReplyGeneralFailure)
 		return fmt.Errorf("client reconnecting")
 	}
 
 	// Open a new stream
 	streamID, err := c.mux.OpenStream()
 	if err != nil {
-		_ = c.socks5.SendFailureReply(req.ClientConn, socks5.ReplyGeneralFailure)
+		_ = server.SendFailureReply(req.ClientConn, socks5.ReplyGeneralFailure)
 		return err
 	}
 
 	c.log.Debug().
 		Uint32("stream_id", streamID).
+		Str("listener", name).
+		Str("user", req.Username).
 		Str("dest", socks5.FormatDestination(req.DestHost, req.DestPort)).
 		Msg("Opening stream for CONNECT request")
 
+	// Register the stream connection before the server can answer. The
+	// SOCKS5 reply tells whether the server connected the destination, if
+	// it confirms stream opens
+	sc := &streamConn{
+		conn:     req.ClientConn,
+		streamID: streamID,
+		forward:  name,
+		destHost: req.DestHost,
+		destPort: req.DestPort,
+		done:     make(chan struct{}),
+		open: func(err error) error {
+			if err != nil {
+				return server.SendFailureReply(req.ClientConn, openFailureReply(err))
+			}
+			return server.SendSuccessReply(req.ClientConn, "0.0.0.0", 0)
+		},
+	}
+
+	c.registerStream(sc)
+
 	// Send connect packet to server
 	connectPayload := formatConnectPayload(req.DestHost, req.DestPort)
 	if err := c.mux.SendPacket(streamID, protocol.FlagData|protocol.FlagHandshake, connectPayload); err != nil {
-		_ = c.mux.CloseStream(streamID)
-		_ = c.socks5.SendFailureReply(req.ClientConn, socks5.ReplyGeneralFailure)
+		c.closeStream(streamID, admin.ClosedByLocal, protocol.Fin{Reason: protocol.CloseTunnelError, Message: err.Error()})
+		return err
+	}
+
+	if err := c.awaitOpen(ctx, sc); err != nil {
 		return err
 	}
 
 	c.log.Debug().
 		Uint32("stream_id", streamID).
+		Str("listener", name).
 		Str("dest_addr", socks5.FormatDestination(req.DestHost, req.DestPort)).
 		Msg("Stream opened")
 
-	// Register the stream connection
-	sc := &streamConn{
-		conn:     req.ClientConn,
-		streamID: streamID,
-		done:     make(chan struct{}),
-	}
-
-	c.streamConnsMu.Lock()
-	c.streamConns[streamID] = sc
-	c.streamConnsMu.Unlock()
-
-	// Send success reply to SOCKS5 client
-	if err := c.socks5.SendSuccessReply(req.ClientConn, "0.0.0.0", 0); err != nil {
-		c.closeStream(streamID)
-		return err
-	}
-
 	// Start reading from client and forwarding to upstream
 	go c.forwardClientToUpstream(ctx, sc)
 
@@ -603,33 +1147,42 @@ func (c *Client) handleConnect(ctx context.Context, req *socks5.ConnectRequest)
 	return nil
 }
 
-// forwardClientToUpstream forwards data from the client to upstream.
+// forwardClientToUpstream forwards data from the client to upstream. Reads
+// block until data arrives; a stream is cancelled by closing its connection,
+// which closeStream does, and ctx and the client's shutdown do here. Stop
+// closes the streams it knows of, but not one registered while it runs.
 func (c *Client) forwardClientToUpstream(ctx context.Context, sc *streamConn) {
-	buf := make([]byte, constants.DefaultBufferSize)
+	buf := make([]byte, mux.ReadSize(c.mux.MaxPayload()))
+
+	cancel := func() {
+		c.closeStream(sc.streamID, admin.ClosedByLocal, protocol.Fin{Reason: protocol.CloseShutdown})
+	}
+	stop := context.AfterFunc(ctx, cancel)
+	defer stop()
+	stopShutdown := context.AfterFunc(c.stopped, cancel)
+	defer stopShutdown()
 
 	for {
-		select {
-		case <-ctx.Done():
-			c.closeStream(sc.streamID)
-			return
-		case <-c.shutdown:
-			c.closeStream(sc.streamID)
+		n, err := sc.conn.Read(buf)
+		if err != nil && streamDone(sc) {
+			// Closed locally, and already reported
 			return
-		case <-sc.done:
+		}
+		if err == io.EOF {
+			// The application finished sending but may still read the
+			// response: tell the server, which half-closes the destination
+			// connection, and keep the stream until its FIN arrives
+			c.log.Debug().
+				Uint32("stream_id", sc.streamID).
+				Msg("Stream half-closed by client")
+			_ = c.mux.SendPacket(sc.streamID, protocol.FlagFin, protocol.Fin{Reason: protocol.CloseEOF}.Marshal())
 			return
-		default:
 		}
-
-		n, err := sc.conn.Read(buf)
 		if err != nil {
-			if err != io.EOF {
-				c.log.Debug().Err(err).
-					Uint32("stream_id", sc.streamID).
-					Msg("Error reading from client")
-			}
-			// Send FIN packet
-			_ = c.mux.SendPacket(sc.streamID, protocol.FlagFin, nil)
-			c.closeStream(sc.streamID)
+			c.log.Debug().Err(err).
+				Uint32("stream_id", sc.streamID).
+				Msg("Error reading from client")
+			c.resetStream(sc.streamID, protocol.Fin{Reason: protocol.CloseReadError, Message: err.Error()})
 			return
 		}
 
@@ -645,15 +1198,97 @@ func (c *Client) forwardClientToUpstream(ctx context.Context, sc *streamConn) {
 				c.log.Error().Err(err).
 					Uint32("stream_id", sc.streamID).
 					Msg("Error sending packet")
-				c.closeStream(sc.streamID)
+				c.closeStream(sc.streamID, admin.ClosedByLocal, protocol.Fin{Reason: protocol.CloseTunnelError, Message: err.Error()})
 				return
 			}
+			c.recordUsage(sc, int64(n), 0)
 		}
 	}
 }
 
-// closeStream closes a stream and its associated connection.
-func (c *Client) closeStream(streamID uint32) {
+// streamDone reports whether a stream has been closed.
+func streamDone(sc *streamConn) bool {
+	select {
+	case <-sc.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// registerStream adds a stream connection to the stream table.
+func (c *Client) registerStream(sc *streamConn) {
+	sc.created = time.Now()
+	sc.opened = make(chan struct{})
+
+	c.streamConnsMu.Lock()
+	c.streamConns[sc.streamID] = sc
+	c.streamConnsMu.Unlock()
+
+	if c.config.Metrics != nil {
+		c.config.Metrics.RecordStreamCreated()
+	}
+	c.hookStreamOpened(sc)
+}
+
+// recordStreamClosed reports a closed stream to the hooks and exports its
+// close reason, lifetime and traffic.
+func (c *Client) recordStreamClosed(sc *streamConn, closedBy string, fin protocol.Fin) {
+	c.hookStreamClosed(sc, closedBy, fin.Reason.String())
+	if c.config.Metrics == nil {
+		return
+	}
+	c.config.Metrics.RecordStreamClosed()
+	c.config.Metrics.RecordStreamCloseReason(closedBy, fin.Reason.String())
+	c.config.Metrics.RecordStreamLifetime(time.Since(sc.created), atomic.LoadInt64(&sc.bytesUp), atomic.LoadInt64(&sc.bytesDown))
+}
+
+// resetStream tells the server why a stream is closed with a FIN and closes
+// it.
+func (c *Client) resetStream(streamID uint32, fin protocol.Fin) {
+	_ = c.mux.SendPacket(streamID, protocol.FlagFin, fin.Marshal())
+	c.closeStream(streamID, admin.ClosedByLocal, fin)
+}
+
+// finDrainTimeout is how long a FIN that overtook data packets waits for
+// them before the stream is closed anyway.
+const finDrainTimeout = 5 * time.Second
+
+// seqAfter reports whether sequence number a comes after b. Sequence numbers
+// wrap around, so they are compared in serial number arithmetic: a is after
+// b if it is less than 2^31 ahead.
+func seqAfter(a, b uint32) bool {
+	return int32(a-b) > 0
+}
+
+// deferFin delays closing a stream for a FIN from the server that overtook
+// some of the stream's data packets, seq being the number the server sent,
+// until they arrive or finDrainTimeout passed. It returns false if the
+// stream is unknown.
+func (c *Client) deferFin(streamID, seq uint32, fin protocol.Fin) bool {
+	c.streamConnsMu.RLock()
+	sc, exists := c.streamConns[streamID]
+	c.streamConnsMu.RUnlock()
+	if !exists {
+		return false
+	}
+
+	sc.finDeferred, sc.finSeq, sc.fin = true, seq, fin
+	time.AfterFunc(finDrainTimeout, func() {
+		c.streamConnsMu.RLock()
+		current := c.streamConns[streamID]
+		c.streamConnsMu.RUnlock()
+		if current == sc {
+			c.closeStream(streamID, admin.ClosedByPeer, fin)
+		}
+	})
+	return true
+}
+
+// closeStream closes a stream and its associated connection. closedBy tells
+// which side closed it and fin why, for the logs, metrics and the closed
+// stream history.
+func (c *Client) closeStream(streamID uint32, closedBy string, fin protocol.Fin) {
 	c.streamConnsMu.Lock()
 	sc, exists := c.streamConns[streamID]
 	if exists {
@@ -664,7 +1299,12 @@ func (c *Client) closeStream(streamID uint32) {
 	if exists {
 		c.log.Debug().
 			Uint32("stream_id", streamID).
+			Str("closed_by", closedBy).
+			Str("reason", fin.Reason.String()).
 			Msg("Stream closed")
+		c.recordStreamClosed(sc, closedBy, fin)
+		c.recordClosedStream(sc, closedBy, fin)
+		sc.finishOpen(errOpenClosed)
 		select {
 		case <-sc.done:
 			// Already closed
@@ -685,28 +1325,115 @@ func (c *Client) closeAllStreams() {
 		default:
 			close(sc.done)
 		}
+		sc.finishOpen(errOpenClosed)
 		sc.conn.Close()
+		fin := protocol.Fin{Reason: protocol.CloseSessionClosed}
+		c.recordStreamClosed(sc, admin.ClosedByLocal, fin)
+		c.recordClosedStream(sc, admin.ClosedByLocal, fin)
 	}
 	c.streamConns = make(map[uint32]*streamConn)
 	c.streamConnsMu.Unlock()
 }
 
+// tunnelURL returns the URL to dial for an endpoint, appending the current
+// path token when path tokens are enabled.
+func (c *Client) tunnelURL(rawURL string) (string, error) {
+	if c.config.PathSecret == "" {
+		return rawURL, nil
+	}
+
+	pathTokens, err := pathtoken.New(c.config.PathSecret, c.config.PathWindow)
+	if err != nil {
+		return "", err
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid endpoint URL: %w", err)
+	}
+	u.Path = pathTokens.Path(u.Path, time.Now())
+	return u.String(), nil
+}
+
+// upgradeHeader returns header with the current upgrade cookie for the
+// endpoint URL added when upgrade cookies are enabled. The token is bound to
+// the configured endpoint path, without the path token.
+func (c *Client) upgradeHeader(header http.Header, rawURL string) (http.Header, error) {
+	if c.config.UpgradeSecret == "" {
+		return header, nil
+	}
+
+	upgradeTokens, err := pathtoken.NewCookie(c.config.UpgradeSecret, c.config.UpgradeWindow)
+	if err != nil {
+		return nil, err
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid endpoint URL: %w", err)
+	}
+	name := c.config.UpgradeCookie
+	if name == "" {
+		name = pathtoken.DefaultCookie
+	}
+
+	header = header.Clone()
+	if header == nil {
+		header = make(http.Header)
+	}
+	cookie := &http.Cookie{Name: name, Value: upgradeTokens.Token(u.Path, time.Now())}
+	header.Add("Cookie", cookie.String())
+	return header, nil
+}
+
+// transportConfig builds the dial configuration of one tunnel path.
+func (c *Client) transportConfig(path string) (*transport.Config, error) {
+	rawURL, tlsConfig := c.config.UpstreamURL, c.config.UpstreamTLS
+	header, proxyURL := c.config.UpstreamHeader, c.config.UpstreamProxy
+	fingerprint := c.config.UpstreamFingerprint
+	if path == pathDownstream {
+		rawURL, tlsConfig = c.config.DownstreamURL, c.config.DownstreamTLS
+		header, proxyURL = c.config.DownstreamHeader, c.config.DownstreamProxy
+		fingerprint = c.config.DownstreamFingerprint
+	}
+
+	tunnelURL, err := c.tunnelURL(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build %s URL: %w", path, err)
+	}
+	if header, err = c.upgradeHeader(header, rawURL); err != nil {
+		return nil, fmt.Errorf("failed to build %s upgrade cookie: %w", path, err)
+	}
+
+	config := transport.DefaultConfig(tunnelURL)
+	config.HandshakeTimeout = c.config.HandshakeTimeout
+	config.WriteTimeout = c.config.WriteTimeout
+	config.ReadTimeout = c.config.ReadTimeout
+	config.TLSConfig = tlsConfig
+	config.ReadBufferSize = c.config.ReadBufferSize
+	config.WriteBufferSize = c.config.WriteBufferSize
+	config.Header = header
+	config.ProxyURL = proxyURL
+	config.Fingerprint = fingerprint
+	config.TCP = c.config.TCP
+	config.SendQueueSize = c.config.SendQueueSize
+	config.Faults = c.config.Faults
+	if c.config.Metrics != nil {
+		config.QueueObserver = c.config.Metrics.SendQueueObserver(path)
+	}
+	if size := int64(c.config.MaxPayloadSize + protocol.PacketOverhead); size > config.MaxMessageSize {
+		config.MaxMessageSize = size
+	}
+	return config, nil
+}
+
 func (c *Client) connect(ctx context.Context) error {
-	upstreamConfig := transport.DefaultConfig(c.config.UpstreamURL)
-	upstreamConfig.HandshakeTimeout = c.config.HandshakeTimeout
-	upstreamConfig.WriteTimeout = c.config.WriteTimeout
-	upstreamConfig.ReadTimeout = c.config.ReadTimeout
-	upstreamConfig.TLSConfig = c.config.UpstreamTLS
-	upstreamConfig.ReadBufferSize = c.config.ReadBufferSize
-	upstreamConfig.WriteBufferSize = c.config.WriteBufferSize
-
-	downstreamConfig := transport.DefaultConfig(c.config.DownstreamURL)
-	downstreamConfig.HandshakeTimeout = c.config.HandshakeTimeout
-	downstreamConfig.ReadTimeout = c.config.ReadTimeout
-	downstreamConfig.WriteTimeout = c.config.WriteTimeout
-	downstreamConfig.TLSConfig = c.config.DownstreamTLS
-	downstreamConfig.ReadBufferSize = c.config.ReadBufferSize
-	downstreamConfig.WriteBufferSize = c.config.WriteBufferSize
+	upstreamConfig, err := c.transportConfig(pathUpstream)
+	if err != nil {
+		return err
+	}
+	downstreamConfig, err := c.transportConfig(pathDownstream)
+	if err != nil {
+		return err
+	}
 
 	upstreamCtx, upstreamCancel := c.dialContext(ctx)
 	defer upstreamCancel()
@@ -736,6 +1463,7 @@ func (c *Client) connect(ctx context.Context) error {
 	c.upstream = upstream
 	c.downstream = downstream
 	c.mu.Unlock()
+	c.setConnectionStatus(true)
 
 	c.log.Info().
 		Str("url", c.config.UpstreamURL).
@@ -752,6 +1480,7 @@ func (c *Client) connect(ctx context.Context) error {
 		c.cleanupConnections()
 		return fmt.Errorf("failed to send handshake: %w", err)
 	}
+	c.awaitHello()
 
 	c.recordKeepAliveAck()
 	return nil
@@ -789,13 +1518,19 @@ func (c *Client) keepaliveLoop(ctx context.Context) {
 				if c.shouldReconnect() {
 					c.triggerReconnect("keepalive")
 				}
+				continue
+			}
+			if err := c.sendDownstreamKeepAlive(); err != nil {
+				c.log.Debug().Err(err).Msg("Failed to send downstream keepalive")
 			}
 		}
 	}
 }
 
+// sendKeepAlive sends a keepalive probe upstream, which the server answers
+// downstream.
 func (c *Client) sendKeepAlive() error {
-	pkt, err := protocol.NewKeepAlivePacket(c.session.ID)
+	pkt, err := protocol.NewPacket(c.session.ID, 0, protocol.FlagKeepAlive, rttProbe(probeUpstream, time.Now()))
 	if err != nil {
 		return err
 	}
@@ -803,7 +1538,18 @@ func (c *Client) sendKeepAlive() error {
 	return c.sendPacket(pkt)
 }
 
-func (c *Client) sendKeepAliveAck() error {
+// sendKeepAliveAck answers a keepalive from the server, echoing its payload.
+func (c *Client) sendKeepAliveAck(payload []byte) error {
+	pkt, err := protocol.NewPacket(c.session.ID, 0, protocol.FlagKeepAlive|protocol.FlagAck, payload)
+	if err != nil {
+		return err
+	}
+	return c.writeDownstream(pkt)
+}
+
+// writeDownstream writes a packet to the downstream connection, which
+// otherwise only carries packets from the server.
+func (c *Client) writeDownstream(pkt *protocol.Packet) error {
 	c.mu.RLock()
 	downstream := c.downstream
 	c.mu.RUnlock()
@@ -811,12 +1557,7 @@ func (c *Client) sendKeepAliveAck() error {
 		return transport.ErrConnectionClosed
 	}
 
-	pkt, err := protocol.NewKeepAliveAckPacket(c.session.ID)
-	if err != nil {
-		return err
-	}
-
-	data, err := pkt.Marshal()
+	data, err := c.marshalPacket(pkt)
 	if err != nil {
 		return err
 	}
@@ -856,6 +1597,84 @@ func (c *Client) cleanupConnectionsLocked() {
 		c.downstream.Close()
 		c.downstream = nil
 	}
+	c.stopHelloTimerLocked()
+	c.handshakeDone = false
+	c.setConnectionStatus(false)
+	c.setTunnelUp(pathUpstream, false)
+	c.setTunnelUp(pathDownstream, false)
+}
+
+// awaitHello waits up to HandshakeTimeout for the server's hello before
+// marking the tunnel up anyway: servers older than the hello exchange
+// never send one, and the session works without it.
+func (c *Client) awaitHello() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.helloWait++
+	wait := c.helloWait
+	c.helloTimer = time.AfterFunc(c.config.HandshakeTimeout, func() {
+		c.completeHandshake(wait)
+	})
+}
+
+// completeHandshake marks both tunnel legs up and records the handshake,
+// once per connection and not after the session was rejected. wait, if
+// non-zero, is the hello wait that timed out; it is ignored unless still
+// current.
+func (c *Client) completeHandshake(wait uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.handshakeDone || (wait != 0 && (wait != c.helloWait || c.helloTimer == nil)) {
+		return
+	}
+	c.stopHelloTimerLocked()
+
+	if wait != 0 {
+		c.log.Debug().
+			Dur("timeout", c.config.HandshakeTimeout).
+			Msg("No hello from server, assuming it predates the hello exchange")
+	}
+	c.setTunnelUp(pathUpstream, true)
+	c.setTunnelUp(pathDownstream, true)
+	if c.config.Metrics != nil {
+		c.config.Metrics.RecordHandshake()
+	}
+}
+
+// rejectHandshake keeps the tunnel down after the server rejected the
+// session, until the next connection.
+func (c *Client) rejectHandshake() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stopHelloTimerLocked()
+	c.setTunnelUp(pathUpstream, false)
+	c.setTunnelUp(pathDownstream, false)
+}
+
+// stopHelloTimerLocked ends the wait for the server's hello. Callers must
+// hold mu.
+func (c *Client) stopHelloTimerLocked() {
+	if c.helloTimer != nil {
+		c.helloTimer.Stop()
+		c.helloTimer = nil
+	}
+	c.handshakeDone = true
+}
+
+// setConnectionStatus exports whether both tunnel legs are connected.
+func (c *Client) setConnectionStatus(connected bool) {
+	if c.config.Metrics != nil {
+		c.config.Metrics.SetConnectionStatus("upstream", connected)
+		c.config.Metrics.SetConnectionStatus("downstream", connected)
+	}
+}
+
+// setTunnelUp exports whether a tunnel leg is up: connected, with the
+// session handshake completed and not found broken since.
+func (c *Client) setTunnelUp(leg string, up bool) {
+	if c.config.Metrics != nil {
+		c.config.Metrics.SetTunnelUp(leg, up)
+	}
 }
 
 func (c *Client) shouldReconnect() bool {
@@ -863,9 +1682,19 @@ func (c *Client) shouldReconnect() bool {
 }
 
 func (c *Client) triggerReconnect(source string) {
+	// A broken leg is down right away, even if reconnecting is disabled
+	switch source {
+	case pathUpstream, pathDownstream:
+		c.setTunnelUp(source, false)
+	}
 	if !atomic.CompareAndSwapInt32(&c.reconnecting, 0, 1) {
 		return
 	}
+	// Queue stream data from now on, including the packet whose write
+	// failed
+	if c.resumable() {
+		c.degradation.EnterDegradedMode()
+	}
 
 	ctx := c.ctx
 	if ctx == nil {
@@ -886,25 +1715,76 @@ func (c *Client) handleReconnect(ctx context.Context, source string) {
 	}
 
 	c.log.Warn().Str("source", source).Msg("Connection lost, attempting reconnect")
+	c.setTunnelUp(pathUpstream, false)
+	c.setTunnelUp(pathDownstream, false)
+	c.hookDisconnected(source)
 	if c.config.ListenOnConnect {
 		c.stopLocalListeners()
 	}
-	c.cleanupConnections()
-	c.closeAllStreams()
-	c.mux.Close()
-	c.session = session.New()
-	c.mux = mux.NewMultiplexer(c.session)
-	c.mux.SetPacketHandler(c.sendPacket)
+	// With graceful degradation the session and its streams survive the
+	// reconnect while the server still knows the session
+	resume := c.resumable() && c.degradation.Mode() == health.ModeDegraded
+	if resume {
+		c.cleanupConnections()
+		c.degradation.BeginRecovery()
+	} else {
+		if c.queueing() {
+			c.abandonResume()
+		}
+		c.newSession()
+	}
 
 	retryer := retry.New(c.config.ReconnectConfig)
+	record := admin.Reconnect{Time: time.Now(), Source: source}
+	// The failed attempts since the reconnect started or the give-up
+	// script last ran
+	roundStart, roundAttempts := record.Time, 0
+	defer func() {
+		record.Duration = time.Since(record.Time)
+		c.recordReconnect(record)
+	}()
 	for {
 		if ctx.Err() != nil || atomic.LoadInt32(&c.running) == 0 {
 			return
 		}
+		if err := c.waitForBreaker(ctx); err != nil {
+			return
+		}
 
+		if resume && c.degradation.IsRecoveryTimedOut() {
+			c.log.Warn().
+				Dur("recovery_timeout", c.config.Degradation.RecoveryTimeout).
+				Msg("Could not reconnect in time, closing streams and starting a new session")
+			c.abandonResume()
+			c.newSession()
+			resume = false
+		}
+
+		if c.config.Metrics != nil {
+			c.config.Metrics.RecordReconnectAttempt(source)
+		}
+		record.Attempts++
 		err := c.connect(ctx)
+		if err == nil && resume {
+			if err = c.flushQueue(); err != nil {
+				c.cleanupConnections()
+				err = fmt.Errorf("failed to send queued packets: %w", err)
+			}
+		}
+		c.recordConnectResult(err)
 		if err == nil {
+			if c.degradation != nil {
+				// Leaves the failed mode after a new session
+				c.degradation.RecoveryComplete()
+			}
+			record.Success = true
+			record.Error = ""
 			c.log.Info().Str("session_id", c.session.ID.String()).Msg("Reconnected to server")
+			c.hookConnected()
+			c.hookReconnected(source)
+			if c.config.Metrics != nil {
+				c.config.Metrics.RecordReconnectSuccess(source)
+			}
 			c.wg.Add(1)
 			go c.readDownstream(ctx)
 			if c.config.ListenOnConnect {
@@ -920,13 +1800,52 @@ func (c *Client) handleReconnect(ctx context.Context, source string) {
 		}
 
 		c.log.Warn().Err(err).Msg("Reconnect attempt failed")
+		record.Error = err.Error()
+		if c.config.Metrics != nil {
+			c.config.Metrics.RecordReconnectFailure(source)
+		}
+		if record.Attempts == c.config.NotifyAfterFailures {
+			c.config.Notifier.Notify(notify.EventReconnectFailing, "",
+				fmt.Sprintf("Reconnecting failed %d times", record.Attempts),
+				map[string]string{
+					"server": c.config.UpstreamURL,
+					"since":  record.Time.Format(time.RFC3339),
+					"error":  record.Error,
+				})
+		}
+		roundAttempts++
+		if c.config.ReconnectLimit.exhausted(roundAttempts, time.Since(roundStart)) {
+			if !c.giveUpReconnect(ctx, roundAttempts, time.Since(roundStart), err) {
+				if resume {
+					c.abandonResume()
+				}
+				return
+			}
+			retryer.Reset()
+			roundStart, roundAttempts = time.Now(), 0
+			continue
+		}
 		if waitErr := retryer.Wait(ctx); waitErr != nil {
 			c.log.Error().Err(waitErr).Msg("Reconnect stopped")
+			if resume {
+				c.abandonResume()
+			}
 			return
 		}
 	}
 }
 
+// newSession closes the streams and replaces the session with a new one.
+func (c *Client) newSession() {
+	c.cleanupConnections()
+	c.closeAllStreams()
+	c.mux.Close()
+	c.session = session.New()
+	c.mux = mux.NewMultiplexer(c.session)
+	c.mux.SetPacketHandler(c.sendPacket)
+	atomic.StoreInt32(&c.sessionExpired, 0)
+}
+
 // formatConnectPayload creates the payload for a connect request.
 // Format: [1 byte address type][address][2 bytes port]
 // Address type: 1 = IPv4, 3 = domain, 4 = IPv6
@@ -985,7 +1904,33 @@ func (c *Client) startLocalListeners(ctx context.Context) error {
 		}
 	}
 
-	for _, pf := range c.config.PortForwards {
+	for _, l := range c.config.SOCKS5Listeners {
+		server, err := c.serveSOCKS5(ctx, l)
+		if err != nil {
+			if c.shouldExitOnListenError(err) {
+				c.stopLocalListeners()
+				return err
+			}
+			c.log.Error().Err(err).
+				Str("listener", l.Name).
+				Msg("SOCKS5 server error")
+			continue
+		}
+		c.mu.Lock()
+		c.socks5Listeners = append(c.socks5Listeners, server)
+		c.mu.Unlock()
+		c.log.Info().
+			Str("listener", l.Name).
+			Str("addr", l.Addr).
+			Bool("auth", l.Username != "" && l.Password != "").
+			Msg("SOCKS5 proxy started")
+	}
+
+	c.mu.RLock()
+	portForwards := append([]PortForward(nil), c.config.PortForwards...)
+	c.mu.RUnlock()
+
+	for _, pf := range portForwards {
 		if err := c.startPortForward(ctx, pf); err != nil {
 			if c.shouldExitOnListenError(err) {
 				c.stopLocalListeners()
@@ -1001,32 +1946,63 @@ func (c *Client) startLocalListeners(ctx context.Context) error {
 	return nil
 }
 
+// startSOCKS5 starts the main SOCKS5 proxy on SOCKS5Addr.
 func (c *Client) startSOCKS5(ctx context.Context) error {
-	listener, err := net.Listen("tcp", c.config.SOCKS5Addr)
-	if err != nil {
-		return err
+	c.mu.RLock()
+	l := SOCKS5Listener{
+		Name:      "socks5",
+		Addr:      c.config.SOCKS5Addr,
+		Family:    c.config.SOCKS5Family,
+		AllowFrom: c.config.SOCKS5AllowFrom,
+		Username:  c.config.SOCKS5Username,
+		Password:  c.config.SOCKS5Password,
 	}
+	c.mu.RUnlock()
 
-	socks5Config := &socks5.Config{
-		ListenAddr: c.config.SOCKS5Addr,
-		Username:   c.config.SOCKS5Username,
-		Password:   c.config.SOCKS5Password,
+	server, err := c.serveSOCKS5(ctx, l)
+	if err != nil {
+		return err
 	}
-	server := socks5.NewServer(socks5Config, c.handleConnect)
 
 	c.mu.Lock()
 	c.socks5 = server
 	c.mu.Unlock()
+	return nil
+}
+
+// serveSOCKS5 starts a SOCKS5 proxy for l. Its streams are labeled with
+// l.Name.
+func (c *Client) serveSOCKS5(ctx context.Context, l SOCKS5Listener) (*socks5.Server, error) {
+	listener, err := sockopt.Listen(l.Family, l.Addr)
+	if err != nil {
+		return nil, err
+	}
+	listener = sockopt.Listener(listener, c.config.TCP)
+	if listener, err = c.restrictListener(listener, l.AllowFrom, l.Name); err != nil {
+		return nil, err
+	}
+
+	socks5Config := &socks5.Config{
+		ListenAddr: l.Addr,
+		Username:   l.Username,
+		Password:   l.Password,
+	}
+	var server *socks5.Server
+	server = socks5.NewServer(socks5Config, func(ctx context.Context, req *socks5.ConnectRequest) error {
+		return c.handleConnect(ctx, server, l.Name, req)
+	})
 
 	c.wg.Add(1)
 	go func() {
 		defer c.wg.Done()
 		if err := server.Serve(ctx, listener); err != nil {
-			c.log.Error().Err(err).Msg("SOCKS5 server error")
+			c.log.Error().Err(err).
+				Str("listener", l.Name).
+				Msg("SOCKS5 server error")
 		}
 	}()
 
-	return nil
+	return server, nil
 }
 
 func (c *Client) stopLocalListeners() {
@@ -1036,15 +2012,20 @@ func (c *Client) stopLocalListeners() {
 		return
 	}
 	socksServer := c.socks5
+	socksListeners := c.socks5Listeners
 	listeners := c.portForwardListeners
 	c.socks5 = nil
-	c.portForwardListeners = nil
+	c.socks5Listeners = nil
+	c.portForwardListeners = make(map[PortForward]net.Listener)
 	c.listenersStarted = false
 	c.mu.Unlock()
 
 	if socksServer != nil {
 		socksServer.Close()
 	}
+	for _, server := range socksListeners {
+		server.Close()
+	}
 	for _, listener := range listeners {
 		listener.Close()
 	}
@@ -1060,15 +2041,22 @@ func isAddrInUse(err error) bool {
 
 // startPortForward starts a listener for a port forwarding rule.
 func (c *Client) startPortForward(ctx context.Context, pf PortForward) error {
-	listenAddr := fmt.Sprintf("%s:%d", pf.ListenHost, pf.ListenPort)
+	if pf.Protocol == "udp" {
+		return errUDPForward
+	}
+	listenAddr := net.JoinHostPort(pf.ListenHost, strconv.Itoa(pf.ListenPort))
 
-	listener, err := net.Listen("tcp", listenAddr)
+	listener, err := sockopt.Listen(pf.Family, listenAddr)
 	if err != nil {
 		return fmt.Errorf("failed to listen on %s: %w", listenAddr, err)
 	}
+	listener = sockopt.Listener(listener, c.config.TCP)
+	if listener, err = c.restrictListener(listener, pf.AllowFrom, pf.usageLabel()); err != nil {
+		return err
+	}
 
 	c.mu.Lock()
-	c.portForwardListeners = append(c.portForwardListeners, listener)
+	c.portForwardListeners[pf] = listener
 	c.mu.Unlock()
 
 	name := pf.Name
@@ -1096,24 +2084,17 @@ func (c *Client) startPortForward(ctx context.Context, pf PortForward) error {
 func (c *Client) runPortForwardListener(ctx context.Context, listener net.Listener, pf PortForward) {
 	defer listener.Close()
 
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		case <-c.shutdown:
-			return
-		default:
-		}
-
-		// Set a deadline so we can check for shutdown periodically
-		if tcpListener, ok := listener.(*net.TCPListener); ok {
-			_ = tcpListener.SetDeadline(time.Now().Add(1 * time.Second))
-		}
+	// Accept blocks until a connection arrives; Stop and reloads close the
+	// listener, and so does ctx here
+	stop := context.AfterFunc(ctx, func() { listener.Close() })
+	defer stop()
 
+	for {
 		conn, err := listener.Accept()
 		if err != nil {
-			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
-				continue
+			// The listener was closed by a reload, listener shutdown or ctx
+			if errors.Is(err, net.ErrClosed) {
+				return
 			}
 			// Check if we're shutting down
 			select {
@@ -1137,46 +2118,162 @@ func (c *Client) runPortForwardListener(ctx context.Context, listener net.Listen
 func (c *Client) handlePortForwardConnection(ctx context.Context, conn net.Conn, pf PortForward) {
 	defer conn.Close()
 
-	// Open a new stream
-	streamID, err := c.mux.OpenStream()
+	switch c.route(ctx, pf.usageLabel(), pf.RemoteHost, uint16(pf.RemotePort)) {
+	case routing.ActionBlock:
+		return
+	case routing.ActionDirect:
+		dest, err := c.dialDirect(ctx, pf.RemoteHost, uint16(pf.RemotePort))
+		if err != nil {
+			c.log.Debug().Err(err).
+				Str("remote_host", pf.RemoteHost).
+				Int("remote_port", pf.RemotePort).
+				Msg("Direct connection failed")
+			return
+		}
+		defer dest.Close()
+		relayDirect(conn, dest)
+		return
+	}
+
+	sc, err := c.openStream(conn, pf.usageLabel(), pf.RemoteHost, uint16(pf.RemotePort))
+	if err == nil {
+		err = c.awaitOpen(ctx, sc)
+	}
 	if err != nil {
-		c.log.Error().Err(err).Msg("Failed to open stream for port forward")
+		c.log.Error().Err(err).
+			Str("remote_host", pf.RemoteHost).
+			Int("remote_port", pf.RemotePort).
+			Msg("Failed to open stream for port forward")
 		return
 	}
 
-	remoteHost := pf.RemoteHost
-	remotePort := uint16(pf.RemotePort)
+	// Start reading from client and forwarding to upstream
+	go c.forwardClientToUpstream(ctx, sc)
 
-	c.log.Debug().
-		Uint32("stream_id", streamID).
-		Str("remote_host", remoteHost).
-		Int("remote_port", int(remotePort)).
-		Msg("Opening stream for port forward")
+	// Wait for the stream to complete
+	<-sc.done
+}
 
-	// Send connect packet to server
-	connectPayload := formatConnectPayload(remoteHost, remotePort)
-	if err := c.mux.SendPacket(streamID, protocol.FlagData|protocol.FlagHandshake, connectPayload); err != nil {
-		_ = c.mux.CloseStream(streamID)
-		c.log.Error().Err(err).Msg("Failed to send connect packet for port forward")
-		return
+// openStream opens a stream to host:port relaying conn, labelled forward in
+// usage accounting, and registers it. The caller waits for the open with
+// awaitOpen, then starts forwarding.
+func (c *Client) openStream(conn net.Conn, forward, host string, port uint16) (*streamConn, error) {
+	streamID, err := c.mux.OpenStream()
+	if err != nil {
+		return nil, err
 	}
 
-	// Register the stream connection
+	c.log.Debug().
+		Uint32("stream_id", streamID).
+		Str("forward", forward).
+		Str("remote_host", host).
+		Int("remote_port", int(port)).
+		Msg("Opening stream")
+
+	// Register the stream before the server can answer
 	sc := &streamConn{
 		conn:     conn,
 		streamID: streamID,
+		forward:  forward,
+		destHost: host,
+		destPort: port,
 		done:     make(chan struct{}),
 	}
+	c.registerStream(sc)
 
-	c.streamConnsMu.Lock()
-	c.streamConns[streamID] = sc
-	c.streamConnsMu.Unlock()
+	// Send connect packet to server
+	connectPayload := formatConnectPayload(host, port)
+	if err := c.mux.SendPacket(streamID, protocol.FlagData|protocol.FlagHandshake, connectPayload); err != nil {
+		c.closeStream(streamID, admin.ClosedByLocal, protocol.Fin{Reason: protocol.CloseTunnelError, Message: err.Error()})
+		return nil, fmt.Errorf("failed to send connect packet: %w", err)
+	}
+	return sc, nil
+}
 
-	// Start reading from client and forwarding to upstream
+// dialTunnel opens a stream to host:port through the tunnel for the client's
+// own use and returns the local end of it once the server connected the
+// destination. dialCtx bounds the wait and ctx the stream's forwarding.
+func (c *Client) dialTunnel(ctx, dialCtx context.Context, forward, host string, port uint16) (net.Conn, error) {
+	if !c.IsConnected() || atomic.LoadInt32(&c.reconnecting) == 1 {
+		return nil, fmt.Errorf("tunnel not connected")
+	}
+
+	local, remote := net.Pipe()
+	sc, err := c.openStream(remote, forward, host, port)
+	if err == nil {
+		err = c.awaitOpen(dialCtx, sc)
+	}
+	if err != nil {
+		local.Close()
+		remote.Close()
+		return nil, err
+	}
 	go c.forwardClientToUpstream(ctx, sc)
+	return local, nil
+}
 
-	// Wait for the stream to complete
-	<-sc.done
+// UpdatePortForwards reconciles the running port forward listeners with the
+// given rules. Listeners whose rule is no longer present are closed and new
+// rules get a listener; connections already accepted keep their streams.
+// If local listeners are not running yet, only the configuration is updated.
+func (c *Client) UpdatePortForwards(forwards []PortForward) error {
+	wanted := make(map[PortForward]bool, len(forwards))
+	for _, pf := range forwards {
+		wanted[pf] = true
+	}
+
+	c.mu.Lock()
+	c.config.PortForwards = append([]PortForward(nil), forwards...)
+	started := c.listenersStarted
+	ctx := c.ctx
+	var stale []net.Listener
+	for pf, listener := range c.portForwardListeners {
+		if !wanted[pf] {
+			stale = append(stale, listener)
+			delete(c.portForwardListeners, pf)
+			c.log.Info().
+				Str("name", pf.Name).
+				Int("listen_port", pf.ListenPort).
+				Msg("Port forward removed")
+		}
+	}
+	var added []PortForward
+	if started {
+		for pf := range wanted {
+			if _, exists := c.portForwardListeners[pf]; !exists {
+				added = append(added, pf)
+			}
+		}
+	}
+	c.mu.Unlock()
+
+	// Close stale listeners first so a rule that changed its remote can
+	// rebind the same local port.
+	for _, listener := range stale {
+		listener.Close()
+	}
+
+	var errs []error
+	for _, pf := range added {
+		if err := c.startPortForward(ctx, pf); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// UpdateSOCKS5Auth replaces the SOCKS5 credentials. Empty values disable
+// authentication. Already negotiated connections are not affected.
+func (c *Client) UpdateSOCKS5Auth(username, password string) {
+	c.mu.Lock()
+	c.config.SOCKS5Username = username
+	c.config.SOCKS5Password = password
+	server := c.socks5
+	c.mu.Unlock()
+
+	if server != nil {
+		server.SetCredentials(username, password)
+	}
 }
 
 // GetSessionID returns the current session ID.
@@ -1194,6 +2291,37 @@ func (c *Client) IsConnected() bool {
 	return c.upstream != nil && c.downstream != nil
 }
 
+// HealthChecks returns the client's readiness checks. The client is ready
+// only while both tunnel legs are connected and, with EchoProbe set, data
+// sent through the tunnel comes back.
+func (c *Client) HealthChecks() map[string]health.Check {
+	checks := map[string]health.Check{
+		"upstream": func(ctx context.Context) error {
+			c.mu.RLock()
+			defer c.mu.RUnlock()
+			if c.upstream == nil || c.upstream.IsClosed() {
+				return fmt.Errorf("upstream not connected")
+			}
+			return nil
+		},
+		"downstream": func(ctx context.Context) error {
+			c.mu.RLock()
+			defer c.mu.RUnlock()
+			if c.downstream == nil || c.downstream.IsClosed() {
+				return fmt.Errorf("downstream not connected")
+			}
+			return nil
+		},
+	}
+	if c.config.EchoProbe {
+		checks["echo"] = func(ctx context.Context) error {
+			_, err := c.probeEcho(ctx)
+			return err
+		}
+	}
+	return checks
+}
+
 // logMetricsPeriodically logs connection metrics every 30 seconds.
 func (c *Client) logMetricsPeriodically(ctx context.Context) {
 	defer c.wg.Done()
@@ -1241,6 +2369,10 @@ func (c *Client) recordPacketReceived(bytes int64) {
 	c.metrics.PacketsReceived++
 	c.metrics.BytesReceived += bytes
 	c.metricsMu.Unlock()
+
+	if c.config.Metrics != nil {
+		c.config.Metrics.RecordPacketReceived("downstream", int(bytes))
+	}
 }
 
 // recordPacketSent increments the packets sent counter.
@@ -1249,4 +2381,15 @@ func (c *Client) recordPacketSent(bytes int64) {
 	c.metrics.PacketsSent++
 	c.metrics.BytesSent += bytes
 	c.metricsMu.Unlock()
+
+	if c.config.Metrics != nil {
+		c.config.Metrics.RecordPacketSent("upstream", int(bytes))
+	}
+}
+
+// recordError counts an error of the given type in the Prometheus metrics.
+func (c *Client) recordError(errorType string) {
+	if c.config.Metrics != nil {
+		c.config.Metrics.RecordError(errorType)
+	}
 }
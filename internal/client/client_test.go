@@ -3,13 +3,33 @@ package client
 import (
 	"bytes"
 	"context"
+	"errors"
+	"io"
 	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sahmadiut/half-tunnel/internal/admin"
+	"github.com/sahmadiut/half-tunnel/internal/circuitbreaker"
+	"github.com/sahmadiut/half-tunnel/internal/constants"
+	"github.com/sahmadiut/half-tunnel/internal/health"
+	"github.com/sahmadiut/half-tunnel/internal/metrics"
 	"github.com/sahmadiut/half-tunnel/internal/mux"
+	"github.com/sahmadiut/half-tunnel/internal/pathtoken"
 	"github.com/sahmadiut/half-tunnel/internal/protocol"
+	"github.com/sahmadiut/half-tunnel/internal/retry"
+	"github.com/sahmadiut/half-tunnel/internal/routing"
 	"github.com/sahmadiut/half-tunnel/internal/session"
 	"github.com/sahmadiut/half-tunnel/internal/socks5"
 	"github.com/sahmadiut/half-tunnel/internal/transport"
@@ -137,6 +157,70 @@ func TestClientNotRunning(t *testing.T) {
 	}
 }
 
+func TestTunnelURLPathToken(t *testing.T) {
+	config := DefaultConfig()
+	config.UpstreamURL = "wss://example.com:8443/ws/upstream?x=1"
+	config.PathSecret = "path-secret"
+	config.PathWindow = time.Minute
+	client := New(config, nil)
+
+	rawURL, err := client.tunnelURL(config.UpstreamURL)
+	if err != nil {
+		t.Fatalf("tunnelURL returned error: %v", err)
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatalf("Invalid URL %s: %v", rawURL, err)
+	}
+	if u.Host != "example.com:8443" || u.RawQuery != "x=1" {
+		t.Errorf("Expected host and query to be kept, got %s", rawURL)
+	}
+
+	token := strings.TrimPrefix(u.Path, "/ws/upstream/")
+	pathTokens, _ := pathtoken.New("path-secret", time.Minute)
+	if !pathTokens.Valid("/ws/upstream", token, time.Now()) {
+		t.Errorf("Expected valid path token in %s", rawURL)
+	}
+
+	// Without a secret the URL is used as-is
+	client.config.PathSecret = ""
+	if rawURL, _ := client.tunnelURL(config.UpstreamURL); rawURL != config.UpstreamURL {
+		t.Errorf("Expected unchanged URL, got %s", rawURL)
+	}
+}
+
+func TestUpgradeHeaderCookie(t *testing.T) {
+	config := DefaultConfig()
+	config.UpgradeSecret = "cookie-secret"
+	config.UpgradeWindow = time.Minute
+	client := New(config, nil)
+
+	extra := http.Header{"X-Forwarded-Host": {"cdn.example.com"}}
+	header, err := client.upgradeHeader(extra, "wss://example.com/ws/upstream")
+	if err != nil {
+		t.Fatalf("upgradeHeader returned error: %v", err)
+	}
+	if header.Get("X-Forwarded-Host") != "cdn.example.com" || extra.Get("Cookie") != "" {
+		t.Errorf("Expected configured headers to be kept and not modified, got %v", header)
+	}
+
+	r := &http.Request{Header: header}
+	cookie, err := r.Cookie(pathtoken.DefaultCookie)
+	if err != nil {
+		t.Fatalf("Expected %s cookie, got %v", pathtoken.DefaultCookie, header)
+	}
+	upgradeTokens, _ := pathtoken.NewCookie("cookie-secret", time.Minute)
+	if !upgradeTokens.Valid("/ws/upstream", cookie.Value, time.Now()) {
+		t.Errorf("Expected valid upgrade token, got %q", cookie.Value)
+	}
+
+	// Without a secret the headers are used as-is
+	client.config.UpgradeSecret = ""
+	if header, _ := client.upgradeHeader(nil, "wss://example.com/ws/upstream"); header != nil {
+		t.Errorf("Expected no headers, got %v", header)
+	}
+}
+
 func TestStartLocalListenersExitOnPortInUse(t *testing.T) {
 	listener, err := net.Listen("tcp", "127.0.0.1:0")
 	if err != nil {
@@ -167,6 +251,228 @@ func TestStartLocalListenersExitOnPortInUse(t *testing.T) {
 	}
 }
 
+func TestUpdatePortForwards(t *testing.T) {
+	config := DefaultConfig()
+	config.SOCKS5Enabled = false
+	config.PortForwards = []PortForward{
+		{ListenHost: "127.0.0.1", ListenPort: 0, RemoteHost: "127.0.0.1", RemotePort: 80},
+	}
+
+	client := New(config, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	client.ctx = ctx
+
+	if err := client.startLocalListeners(ctx); err != nil {
+		t.Fatalf("Failed to start listeners: %v", err)
+	}
+	t.Cleanup(client.stopLocalListeners)
+
+	replacement := PortForward{ListenHost: "127.0.0.1", ListenPort: 0, RemoteHost: "127.0.0.1", RemotePort: 443}
+	if err := client.UpdatePortForwards([]PortForward{replacement}); err != nil {
+		t.Fatalf("UpdatePortForwards returned error: %v", err)
+	}
+
+	client.mu.RLock()
+	count := len(client.portForwardListeners)
+	_, hasReplacement := client.portForwardListeners[replacement]
+	client.mu.RUnlock()
+
+	if count != 1 {
+		t.Errorf("Expected 1 listener after update, got %d", count)
+	}
+	if !hasReplacement {
+		t.Error("Expected listener for the new port forward")
+	}
+	if len(client.config.PortForwards) != 1 || client.config.PortForwards[0] != replacement {
+		t.Errorf("Expected config to hold the new port forward, got %v", client.config.PortForwards)
+	}
+
+	if err := client.UpdatePortForwards(nil); err != nil {
+		t.Fatalf("UpdatePortForwards returned error: %v", err)
+	}
+
+	client.mu.RLock()
+	count = len(client.portForwardListeners)
+	client.mu.RUnlock()
+	if count != 0 {
+		t.Errorf("Expected no listeners after removing all forwards, got %d", count)
+	}
+}
+
+func TestStartSOCKS5Listeners(t *testing.T) {
+	config := DefaultConfig()
+	config.SOCKS5Addr = "127.0.0.1:0"
+	config.SOCKS5Listeners = []SOCKS5Listener{
+		{Name: "lan", Addr: "127.0.0.1:0", Username: "alice", Password: "secret"},
+	}
+
+	client := New(config, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	if err := client.startLocalListeners(ctx); err != nil {
+		t.Fatalf("Failed to start listeners: %v", err)
+	}
+
+	client.mu.RLock()
+	main, extra := client.socks5, client.socks5Listeners
+	client.mu.RUnlock()
+	if main == nil || len(extra) != 1 {
+		t.Fatalf("Expected main and one extra SOCKS5 server, got %v and %d", main, len(extra))
+	}
+	// Serve records the listener asynchronously
+	for i := 0; i < 100 && (main.Addr() == nil || extra[0].Addr() == nil); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if extra[0].Addr() == nil || main.Addr() == nil || extra[0].Addr().String() == main.Addr().String() {
+		t.Errorf("Expected the extra server on its own address, got %v", extra[0].Addr())
+	}
+
+	client.stopLocalListeners()
+	if _, err := net.Dial("tcp", extra[0].Addr().String()); err == nil {
+		t.Error("Expected the extra listener to be closed")
+	}
+}
+
+func TestAllowListNetworks(t *testing.T) {
+	networks, err := NewAllowList([]string{"10.0.0.0/8", "192.0.2.7", "2001:db8::/32"}).Networks()
+	if err != nil {
+		t.Fatalf("Networks returned error: %v", err)
+	}
+	if len(networks) != 3 || networks[1].String() != "192.0.2.7/32" {
+		t.Errorf("Expected a /32 for the bare address, got %v", networks)
+	}
+	if _, err := NewAllowList([]string{"10.0.0.0/33"}).Networks(); err == nil {
+		t.Error("Expected error for an invalid CIDR")
+	}
+	if networks, err := AllowList("").Networks(); err != nil || networks != nil {
+		t.Errorf("Expected no networks for an empty list, got %v, %v", networks, err)
+	}
+}
+
+func TestRestrictListener(t *testing.T) {
+	config := DefaultConfig()
+	config.Metrics = metrics.NewCollector()
+	client := New(config, nil)
+
+	dialAndRead := func(allow AllowList) error {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("Failed to listen: %v", err)
+		}
+		listener, err := client.restrictListener(ln, allow, "db")
+		if err != nil {
+			t.Fatalf("restrictListener returned error: %v", err)
+		}
+		defer listener.Close()
+		go func() {
+			if conn, err := listener.Accept(); err == nil {
+				_, _ = conn.Write([]byte("ok"))
+				conn.Close()
+			}
+		}()
+
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatalf("Failed to dial: %v", err)
+		}
+		defer conn.Close()
+		_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+		buf := make([]byte, 2)
+		_, err = conn.Read(buf)
+		return err
+	}
+
+	if err := dialAndRead("127.0.0.0/8"); err != nil {
+		t.Errorf("Expected allowed connection to be served, got %v", err)
+	}
+	if err := dialAndRead("10.0.0.0/8"); err == nil {
+		t.Error("Expected connection from outside allow_from to be closed")
+	}
+	if got := testutil.ToFloat64(config.Metrics.ListenerRejections.WithLabelValues("db")); got != 1 {
+		t.Errorf("Expected 1 rejection, got %v", got)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	if _, err := client.restrictListener(ln, "bogus", "db"); err == nil {
+		t.Error("Expected error for an invalid allow list")
+	}
+}
+
+func TestPortForwardRouting(t *testing.T) {
+	backend, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { _ = backend.Close() })
+	go func() {
+		for {
+			conn, err := backend.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				buf := make([]byte, 64)
+				n, _ := conn.Read(buf)
+				_, _ = conn.Write(buf[:n])
+			}()
+		}
+	}()
+	backendPort := backend.Addr().(*net.TCPAddr).Port
+
+	config := DefaultConfig()
+	config.SOCKS5Enabled = false
+	config.Metrics = metrics.NewCollector()
+	client := New(config, nil)
+	client.SetRouter(routing.New([]routing.Rule{
+		{Name: "blocked", Ports: []uint16{9}, Action: routing.ActionBlock},
+		{Name: "local", Domains: []string{"localhost"}, Action: routing.ActionDirect},
+	}, routing.ActionTunnel))
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	exchange := func(pf PortForward) (string, error) {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("Failed to listen: %v", err)
+		}
+		defer ln.Close()
+		go func() {
+			if conn, err := ln.Accept(); err == nil {
+				client.handlePortForwardConnection(ctx, conn, pf)
+			}
+		}()
+
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatalf("Failed to dial: %v", err)
+		}
+		defer conn.Close()
+		_ = conn.SetDeadline(time.Now().Add(time.Second))
+		if _, err := conn.Write([]byte("ping")); err != nil {
+			return "", err
+		}
+		buf := make([]byte, 4)
+		n, err := conn.Read(buf)
+		return string(buf[:n]), err
+	}
+
+	if got, err := exchange(PortForward{RemoteHost: "localhost", RemotePort: backendPort}); err != nil || got != "ping" {
+		t.Errorf("Expected the direct route to reach the backend, got %q, %v", got, err)
+	}
+	if got, err := exchange(PortForward{RemoteHost: "localhost", RemotePort: 9}); err == nil {
+		t.Errorf("Expected the blocked connection to be closed, got %q", got)
+	}
+	if got := testutil.ToFloat64(config.Metrics.RoutingHits.WithLabelValues("local", "direct")); got != 1 {
+		t.Errorf("Expected 1 hit for the local rule, got %v", got)
+	}
+}
+
 func TestStartTriggersReconnectOnFailure(t *testing.T) {
 	originalDial := dialTransport
 	defer func() { dialTransport = originalDial }()
@@ -196,6 +502,216 @@ func TestStartTriggersReconnectOnFailure(t *testing.T) {
 	_ = client.Stop()
 }
 
+func TestClientExportsMetrics(t *testing.T) {
+	config := DefaultConfig()
+	config.Metrics = metrics.NewCollector()
+	client := New(config, nil)
+	client.session = session.New()
+	client.mux = mux.NewMultiplexer(client.session)
+	m := config.Metrics
+
+	client.recordPacketSent(120)
+	client.recordPacketReceived(80)
+	if got := testutil.ToFloat64(m.BytesSent.WithLabelValues("upstream")); got != 120 {
+		t.Errorf("Expected 120 upstream bytes sent, got %v", got)
+	}
+	if got := testutil.ToFloat64(m.BytesReceived.WithLabelValues("downstream")); got != 80 {
+		t.Errorf("Expected 80 downstream bytes received, got %v", got)
+	}
+
+	client.registerStream(&streamConn{conn: &mockConn{}, streamID: 1, done: make(chan struct{})})
+	client.registerStream(&streamConn{conn: &mockConn{}, streamID: 2, done: make(chan struct{})})
+	if got := testutil.ToFloat64(m.ActiveStreams); got != 2 {
+		t.Errorf("Expected 2 active streams, got %v", got)
+	}
+
+	client.closeStream(1, admin.ClosedByPeer, protocol.Fin{Reason: protocol.CloseEOF})
+	client.closeStream(1, admin.ClosedByPeer, protocol.Fin{Reason: protocol.CloseEOF})
+	client.closeAllStreams()
+	if got := testutil.ToFloat64(m.ActiveStreams); got != 0 {
+		t.Errorf("Expected 0 active streams, got %v", got)
+	}
+	if got := testutil.ToFloat64(m.TotalStreams); got != 2 {
+		t.Errorf("Expected 2 total streams, got %v", got)
+	}
+	if got := testutil.ToFloat64(m.StreamsClosed.WithLabelValues(admin.ClosedByPeer, "eof")); got != 1 {
+		t.Errorf("Expected 1 stream closed by the server with eof, got %v", got)
+	}
+	if got := testutil.ToFloat64(m.StreamsClosed.WithLabelValues(admin.ClosedByLocal, "session_closed")); got != 1 {
+		t.Errorf("Expected 1 stream closed with its session, got %v", got)
+	}
+	closed := client.AdminStatus().ClosedStreams
+	if len(closed) != 2 || closed[0].StreamID != 1 || closed[0].ClosedBy != admin.ClosedByPeer || closed[0].Reason != "eof" {
+		t.Errorf("Unexpected closed stream history: %+v", closed)
+	}
+
+	sc := &streamConn{forward: "web", destHost: "example.com"}
+	client.recordUsage(sc, 10, 0)
+	client.recordUsage(sc, 0, 30)
+	if got := testutil.ToFloat64(m.StreamBytes.WithLabelValues("example.com", "web")); got != 40 {
+		t.Errorf("Expected 40 stream bytes for example.com, got %v", got)
+	}
+}
+
+func TestHealthChecksRequireBothLegs(t *testing.T) {
+	client := New(DefaultConfig(), nil)
+	checks := client.HealthChecks()
+
+	for name, check := range checks {
+		if err := check(context.Background()); err == nil {
+			t.Errorf("Expected %s check to fail while disconnected", name)
+		}
+	}
+}
+
+func TestAdminStatusAndActions(t *testing.T) {
+	client := New(DefaultConfig(), nil)
+	client.session = session.New()
+	client.mux = mux.NewMultiplexer(client.session)
+
+	sc := &streamConn{conn: &mockConn{}, streamID: 1, forward: "web", destHost: "example.com", destPort: 443, done: make(chan struct{})}
+	client.registerStream(sc)
+	client.registerStream(&streamConn{conn: &mockConn{}, streamID: 2, done: make(chan struct{})})
+	client.recordUsage(sc, 10, 20)
+	client.recordReconnect(admin.Reconnect{Source: "keepalive", Attempts: 2, Success: true})
+
+	status := client.AdminStatus()
+	if status.Role != "client" || len(status.Sessions) != 1 || status.Sessions[0].Streams != 2 {
+		t.Fatalf("Unexpected status: %+v", status)
+	}
+	for _, stream := range status.Streams {
+		if stream.StreamID == 1 && (stream.Dest != "example.com:443" || stream.BytesUp != 10 || stream.BytesDown != 20) {
+			t.Errorf("Unexpected stream: %+v", stream)
+		}
+	}
+	if len(status.Reconnects) != 1 || status.Reconnects[0].Attempts != 2 {
+		t.Errorf("Expected reconnect history, got %+v", status.Reconnects)
+	}
+
+	if err := client.CloseStream(uuid.New(), 1); err != admin.ErrSessionNotFound {
+		t.Errorf("Expected ErrSessionNotFound, got %v", err)
+	}
+	if err := client.CloseStream(client.session.ID, 9); err != admin.ErrStreamNotFound {
+		t.Errorf("Expected ErrStreamNotFound, got %v", err)
+	}
+	if err := client.CloseStream(client.session.ID, 1); err != nil {
+		t.Errorf("Expected stream to close, got %v", err)
+	}
+	if err := client.DrainSession(client.session.ID); err != nil {
+		t.Errorf("Expected session to drain, got %v", err)
+	}
+	if n := len(client.AdminStatus().Streams); n != 0 {
+		t.Errorf("Expected no streams after drain, got %d", n)
+	}
+}
+
+func TestRuntimeForwards(t *testing.T) {
+	config := DefaultConfig()
+	config.PortForwards = []PortForward{{Name: "ssh", ListenHost: "127.0.0.1", ListenPort: 2222, RemoteHost: "10.0.0.1", RemotePort: 22}}
+	client := New(config, nil)
+
+	err := client.AddForward(admin.Forward{Name: "web", ListenHost: "127.0.0.1", ListenPort: 8443, RemoteHost: "example.com", RemotePort: 443})
+	if err != nil {
+		t.Fatalf("Failed to add forward: %v", err)
+	}
+	if n := len(client.Forwards()); n != 2 {
+		t.Fatalf("Expected 2 forwards, got %d", n)
+	}
+
+	err = client.AddForward(admin.Forward{ListenPort: 8443, RemoteHost: "example.org", RemotePort: 443})
+	if !errors.Is(err, admin.ErrForwardExists) {
+		t.Errorf("Expected ErrForwardExists, got %v", err)
+	}
+	if err := client.AddForward(admin.Forward{ListenPort: 9000, RemotePort: 80}); err == nil {
+		t.Error("Expected error for missing remote host")
+	}
+
+	if err := client.RemoveForward(2222); err != nil {
+		t.Fatalf("Failed to remove forward: %v", err)
+	}
+	forwards := client.Forwards()
+	if len(forwards) != 1 || forwards[0].Name != "web" {
+		t.Errorf("Expected only the web forward, got %+v", forwards)
+	}
+	if err := client.RemoveForward(2222); !errors.Is(err, admin.ErrForwardNotFound) {
+		t.Errorf("Expected ErrForwardNotFound, got %v", err)
+	}
+}
+
+func TestReconnectHistoryBounded(t *testing.T) {
+	client := New(DefaultConfig(), nil)
+	for i := 0; i < maxReconnectHistory+5; i++ {
+		client.recordReconnect(admin.Reconnect{Attempts: i})
+	}
+
+	history := client.reconnectHistory()
+	if len(history) != maxReconnectHistory {
+		t.Fatalf("Expected %d entries, got %d", maxReconnectHistory, len(history))
+	}
+	if history[0].Attempts != 5 {
+		t.Errorf("Expected oldest entries to be dropped, got first attempts %d", history[0].Attempts)
+	}
+}
+
+func TestPathRTT(t *testing.T) {
+	config := DefaultConfig()
+	config.Metrics = metrics.NewCollector()
+	config.RTTWarnThreshold = 100 * time.Millisecond
+	client := New(config, nil)
+	client.session = session.New()
+
+	// Acks echo the probe, so the round trip is the time since it was sent
+	ack, _ := protocol.NewPacket(client.session.ID, 0, protocol.FlagKeepAlive|protocol.FlagAck,
+		rttProbe(probeDownstream, time.Now().Add(-200*time.Millisecond)))
+	client.handleDownstreamPacket(ack)
+
+	// Acks from servers that do not echo probes are ignored
+	ack, _ = protocol.NewPacket(client.session.ID, 0, protocol.FlagKeepAlive|protocol.FlagAck, nil)
+	client.handleDownstreamPacket(ack)
+
+	paths := client.AdminStatus().Paths
+	if len(paths) != 2 || paths[0].Path != pathUpstream || paths[1].Path != pathDownstream {
+		t.Fatalf("Expected upstream and downstream paths, got %+v", paths)
+	}
+	if paths[0].Samples != 0 {
+		t.Errorf("Expected no upstream samples, got %d", paths[0].Samples)
+	}
+	if paths[1].Samples != 1 || paths[1].RTT < 200*time.Millisecond {
+		t.Errorf("Expected one downstream sample of at least 200ms, got %+v", paths[1])
+	}
+	if got := testutil.ToFloat64(config.Metrics.PathRTT.WithLabelValues(pathDownstream)); got < 0.2 {
+		t.Errorf("Expected downstream RTT metric of at least 0.2s, got %v", got)
+	}
+	if !client.downstreamRTT.slow {
+		t.Error("Expected downstream path marked slow above the threshold")
+	}
+}
+
+func TestRTTEstimatorSmoothing(t *testing.T) {
+	var e rttEstimator
+	now := time.Now()
+
+	if got := e.update(80*time.Millisecond, now); got != 80*time.Millisecond {
+		t.Errorf("Expected the first sample to seed the estimate, got %v", got)
+	}
+	if got := e.update(160*time.Millisecond, now); got != 90*time.Millisecond {
+		t.Errorf("Expected samples weighted 1/8, got %v", got)
+	}
+	e.update(40*time.Millisecond, now)
+
+	p := e.snapshot(pathUpstream)
+	if p.LastRTT != 40*time.Millisecond || p.MinRTT != 40*time.Millisecond || p.Samples != 3 {
+		t.Errorf("Unexpected snapshot %+v", p)
+	}
+
+	if slow, changed := e.checkThreshold(50 * time.Millisecond); !slow || !changed {
+		t.Errorf("Expected the path to turn slow, got slow=%v changed=%v", slow, changed)
+	}
+	if _, changed := e.checkThreshold(50 * time.Millisecond); changed {
+		t.Error("Expected no change while the path stays slow")
+	}
+}
+
 // mockConn is a mock net.Conn that captures written data.
 type mockConn struct {
 	writeBuf bytes.Buffer
@@ -346,3 +862,1099 @@ func TestHandleDownstreamPacketInOrder(t *testing.T) {
 		t.Errorf("Expected 'FirstSecondThird' after packet 2, got '%s'", string(data))
 	}
 }
+
+// TestStreamHalfClose verifies that an application that finishes sending
+// still receives the response until the server's FIN.
+func TestStreamHalfClose(t *testing.T) {
+	config := DefaultConfig()
+	config.SOCKS5Enabled = false
+	config.ReconnectEnabled = false
+
+	client := New(config, nil)
+	client.session = session.New()
+	client.mux = mux.NewMultiplexer(client.session)
+	client.dataFlowMonitor = NewDataFlowMonitor(config.DataFlowMonitor, client.log)
+
+	sent := make(chan *protocol.Packet, 10)
+	client.mux.SetPacketHandler(func(pkt *protocol.Packet) error {
+		sent <- pkt
+		return nil
+	})
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	defer ln.Close()
+	app, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer app.Close()
+	local, err := ln.Accept()
+	if err != nil {
+		t.Fatalf("Accept failed: %v", err)
+	}
+
+	streamID, _ := client.mux.OpenStream()
+	sc := &streamConn{conn: local, streamID: streamID, done: make(chan struct{})}
+	client.registerStream(sc)
+	go client.forwardClientToUpstream(context.Background(), sc)
+
+	_, _ = app.Write([]byte("request"))
+	_ = app.(*net.TCPConn).CloseWrite()
+
+	for _, want := range []string{"DATA", "FIN"} {
+		select {
+		case pkt := <-sent:
+			if pkt.PacketType() != want {
+				t.Fatalf("Expected %s packet, got %s", want, pkt.PacketType())
+			}
+			if pkt.IsFin() && protocol.ParseFin(pkt.Payload).Reason != protocol.CloseEOF {
+				t.Errorf("Expected FIN with reason eof, got %+v", protocol.ParseFin(pkt.Payload))
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Expected %s packet", want)
+		}
+	}
+
+	// The response still reaches the application
+	resp, _ := protocol.NewPacket(client.session.ID, streamID, protocol.FlagData, []byte("response"))
+	client.handleDownstreamPacket(resp)
+	fin, _ := protocol.NewFinReasonPacket(client.session.ID, streamID, protocol.Fin{Reason: protocol.CloseEOF})
+	client.handleDownstreamPacket(fin)
+
+	_ = app.SetReadDeadline(time.Now().Add(2 * time.Second))
+	got, err := io.ReadAll(app)
+	if err != nil {
+		t.Fatalf("Expected response then EOF, got %v", err)
+	}
+	if string(got) != "response" {
+		t.Errorf("Expected response, got %q", got)
+	}
+}
+
+func TestHelloFromServer(t *testing.T) {
+	client := New(DefaultConfig(), nil)
+	client.session = session.New()
+
+	hello, _ := protocol.NewHelloPacket(client.session.ID, protocol.Hello{Version: 1, Capabilities: protocol.CapCloseReasons})
+	client.handleControlPacket(hello)
+
+	if version, caps := client.session.Protocol(); version != 1 || protocol.Capability(caps) != protocol.CapCloseReasons {
+		t.Errorf("Expected version 1 with close_reasons, got version %d, capabilities %s", version, protocol.Capability(caps))
+	}
+	sessions := client.AdminStatus().Sessions
+	if len(sessions) != 1 || sessions[0].Protocol != 1 || len(sessions[0].Capabilities) != 1 {
+		t.Errorf("Unexpected admin sessions: %+v", sessions)
+	}
+}
+
+func TestCompactHeaderFromServer(t *testing.T) {
+	client := New(DefaultConfig(), nil)
+	client.session = session.New()
+
+	pkt, _ := protocol.NewPacket(client.session.ID, 1, protocol.FlagData, []byte("x"))
+	data, _ := client.marshalPacket(pkt)
+	if decoded, _ := protocol.Unmarshal(data); decoded.IsCompact() {
+		t.Fatal("Expected a full header before the server granted compact headers")
+	}
+
+	hello, _ := protocol.NewHelloPacket(client.session.ID, protocol.Hello{
+		Version:      protocol.Version,
+		Capabilities: protocol.CapCompactHeader,
+		SessionIndex: 5,
+	})
+	client.handleControlPacket(hello)
+
+	pkt, _ = protocol.NewPacket(client.session.ID, 1, protocol.FlagData, []byte("x"))
+	data, _ = client.marshalPacket(pkt)
+	if len(data) != protocol.CompactHeaderMinSize+1 {
+		t.Errorf("Expected a compact packet of %d bytes, got %d", protocol.CompactHeaderMinSize+1, len(data))
+	}
+
+	decoded, err := client.unmarshalPacket(data)
+	if err != nil {
+		t.Fatalf("unmarshalPacket failed: %v", err)
+	}
+	if decoded.SessionID != client.session.ID {
+		t.Errorf("Expected session %s, got %s", client.session.ID, decoded.SessionID)
+	}
+	pkt.SessionIndex = 6
+	data, _ = pkt.Marshal()
+	if _, err := client.unmarshalPacket(data); err == nil {
+		t.Error("Expected an error for another session's index")
+	}
+}
+
+func TestCompactHeaderDisabled(t *testing.T) {
+	config := DefaultConfig()
+	config.CompactHeader = false
+	client := New(config, nil)
+
+	if client.localHello().Capabilities.Has(protocol.CapCompactHeader) {
+		t.Error("Expected compact headers not to be offered")
+	}
+}
+
+func TestJumboFramesFromServer(t *testing.T) {
+	config := DefaultConfig()
+	config.MaxPayloadSize = 1 << 20
+	client := New(config, nil)
+	client.session = session.New()
+	client.mux = mux.NewMultiplexer(client.session)
+
+	if got := client.localHello().MaxPayload; got != 1<<20 {
+		t.Errorf("Expected to offer %d, got %d", 1<<20, got)
+	}
+	if size := mux.ReadSize(client.mux.MaxPayload()); size != constants.DefaultBufferSize {
+		t.Errorf("Expected read size %d before negotiation, got %d", constants.DefaultBufferSize, size)
+	}
+
+	hello, _ := protocol.NewHelloPacket(client.session.ID, protocol.Hello{
+		Version:      protocol.Version,
+		Capabilities: protocol.CapCompactHeader,
+		SessionIndex: 3,
+		MaxPayload:   256 << 10,
+	})
+	client.handleControlPacket(hello)
+	if n := client.mux.MaxPayload(); n != 256<<10 {
+		t.Errorf("Expected max payload %d, got %d", 256<<10, n)
+	}
+
+	// Without the compact header payloads stay within the full header's limit
+	hello, _ = protocol.NewHelloPacket(client.session.ID, protocol.Hello{
+		Version:    protocol.Version,
+		MaxPayload: 256 << 10,
+	})
+	client.handleControlPacket(hello)
+	if n := client.mux.MaxPayload(); n != protocol.MaxPayloadSize {
+		t.Errorf("Expected max payload %d, got %d", protocol.MaxPayloadSize, n)
+	}
+}
+
+func TestChecksumOffered(t *testing.T) {
+	config := DefaultConfig()
+	if New(config, nil).localHello().Capabilities.Has(protocol.CapChecksum) {
+		t.Error("Expected checksums not to be offered by default")
+	}
+
+	config.Checksum = true
+	client := New(config, nil)
+	client.session = session.New()
+	if !client.localHello().Capabilities.Has(protocol.CapChecksum) {
+		t.Error("Expected checksums to be offered")
+	}
+
+	hello, _ := protocol.NewHelloPacket(client.session.ID, protocol.Hello{
+		Version:      protocol.Version,
+		Capabilities: protocol.CapChecksum,
+	})
+	client.handleControlPacket(hello)
+
+	pkt, _ := protocol.NewPacket(client.session.ID, 1, protocol.FlagData, []byte("x"))
+	data, _ := client.marshalPacket(pkt)
+	decoded, err := client.unmarshalPacket(data)
+	if err != nil {
+		t.Fatalf("unmarshalPacket failed: %v", err)
+	}
+	if !decoded.HasChecksum || !decoded.ChecksumValid() {
+		t.Errorf("Expected a valid checksum, got %+v", decoded)
+	}
+}
+
+func TestCorruptPacketPolicy(t *testing.T) {
+	tests := []struct {
+		name       string
+		reset      bool
+		wantStream bool
+	}{
+		{"reset", true, false},
+		{"drop", false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := DefaultConfig()
+			config.Metrics = metrics.NewCollector()
+			config.ResetCorruptStreams = tt.reset
+			client := New(config, nil)
+			client.session = session.New()
+			client.mux = mux.NewMultiplexer(client.session)
+			client.registerStream(&streamConn{conn: &mockConn{}, streamID: 1, done: make(chan struct{})})
+
+			pkt, _ := protocol.NewPacket(client.session.ID, 1, protocol.FlagData, []byte("hello"))
+			pkt.SetChecksum()
+			pkt.Payload[0] ^= 0x01
+			client.handleCorruptPacket(pkt)
+
+			if got := testutil.ToFloat64(config.Metrics.PacketsCorrupted.WithLabelValues("downstream")); got != 1 {
+				t.Errorf("Expected 1 corrupted packet, got %v", got)
+			}
+			client.streamConnsMu.RLock()
+			_, exists := client.streamConns[1]
+			client.streamConnsMu.RUnlock()
+			if exists != tt.wantStream {
+				t.Errorf("Expected stream open: %v, got %v", tt.wantStream, exists)
+			}
+		})
+	}
+}
+
+func TestPaddingFromServer(t *testing.T) {
+	config := DefaultConfig()
+	if New(config, nil).localHello().Capabilities.Has(protocol.CapPadding) {
+		t.Error("Expected padding not to be offered by default")
+	}
+
+	config.MaxPadding = 256
+	client := New(config, nil)
+	client.session = session.New()
+	client.mux = mux.NewMultiplexer(client.session)
+	if !client.localHello().Capabilities.Has(protocol.CapPadding) {
+		t.Error("Expected padding to be offered")
+	}
+
+	hello, _ := protocol.NewHelloPacket(client.session.ID, protocol.Hello{
+		Version:      protocol.Version,
+		Capabilities: protocol.CapPadding,
+	})
+	client.handleControlPacket(hello)
+	if n := client.mux.MaxPayload(); n != protocol.MaxPayloadSize-protocol.PaddingTrailerSize {
+		t.Errorf("Expected max payload %d, got %d", protocol.MaxPayloadSize-protocol.PaddingTrailerSize, n)
+	}
+
+	for i := 0; i < 20; i++ {
+		pkt, _ := protocol.NewPacket(client.session.ID, 1, protocol.FlagData, []byte("hello"))
+		data, err := client.marshalPacket(pkt)
+		if err != nil {
+			t.Fatalf("marshalPacket failed: %v", err)
+		}
+		decoded, _ := client.unmarshalPacket(data)
+		if extra := len(decoded.Payload) - len("hello") - protocol.PaddingTrailerSize; extra < 0 || extra > 256 {
+			t.Fatalf("Expected up to 256 bytes of padding, got %d", extra)
+		}
+		if err := decoded.Unpad(); err != nil || string(decoded.Payload) != "hello" {
+			t.Fatalf("Unpad: got %q, %v", decoded.Payload, err)
+		}
+	}
+
+	// A full payload still fits after padding
+	pkt, _ := protocol.NewPacket(client.session.ID, 1, protocol.FlagData, make([]byte, client.mux.MaxPayload()))
+	if _, err := client.marshalPacket(pkt); err != nil {
+		t.Errorf("marshalPacket of a full payload failed: %v", err)
+	}
+
+	// Only data packets are padded
+	ping, _ := protocol.NewPacket(client.session.ID, 0, protocol.FlagKeepAlive, []byte("ping"))
+	data, _ := client.marshalPacket(ping)
+	if decoded, _ := client.unmarshalPacket(data); string(decoded.Payload) != "ping" {
+		t.Errorf("Expected an unpadded keepalive, got %q", decoded.Payload)
+	}
+}
+
+func TestCoverTrafficInterval(t *testing.T) {
+	cfg := &CoverTrafficConfig{Interval: time.Second, Jitter: 0.5, MinSize: 10, MaxSize: 20}
+	for i := 0; i < 100; i++ {
+		if d := cfg.nextInterval(); d < 500*time.Millisecond || d > 1500*time.Millisecond {
+			t.Fatalf("Interval %v outside the jitter range", d)
+		}
+		if n := cfg.nextSize(); n < 10 || n > 20 {
+			t.Fatalf("Size %d outside 10-20", n)
+		}
+	}
+}
+
+// readPacket reads the next packet from a server-side connection.
+func readPacket(t *testing.T, conn *transport.Connection) *protocol.Packet {
+	t.Helper()
+	data, err := conn.Read()
+	if err != nil {
+		t.Fatalf("Failed to read packet: %v", err)
+	}
+	pkt, err := protocol.Unmarshal(data)
+	if err != nil {
+		t.Fatalf("Failed to unmarshal packet: %v", err)
+	}
+	return pkt
+}
+
+func TestDegradationQueuesAcrossReconnect(t *testing.T) {
+	upstreams := transport.NewServerHandler(nil, nil)
+	upstreamServer := httptest.NewServer(upstreams)
+	defer upstreamServer.Close()
+	downstreams := transport.NewServerHandler(nil, nil)
+	downstreamServer := httptest.NewServer(downstreams)
+	defer downstreamServer.Close()
+
+	// Dials fail while the tunnel is "down"
+	var down int32
+	originalDial := dialTransport
+	defer func() { dialTransport = originalDial }()
+	dialTransport = func(ctx context.Context, config *transport.Config) (*transport.Connection, error) {
+		if atomic.LoadInt32(&down) == 1 {
+			return nil, errors.New("tunnel down")
+		}
+		return transport.Dial(ctx, config)
+	}
+
+	config := DefaultConfig()
+	config.UpstreamURL = "ws" + strings.TrimPrefix(upstreamServer.URL, "http")
+	config.DownstreamURL = "ws" + strings.TrimPrefix(downstreamServer.URL, "http")
+	config.SOCKS5Enabled = false
+	config.PingInterval = 0
+	config.ReconnectConfig = &retry.Config{InitialDelay: 10 * time.Millisecond, MaxDelay: 10 * time.Millisecond, Multiplier: 1}
+	config.Degradation = health.DefaultDegradationConfig()
+	config.Metrics = metrics.NewCollector()
+
+	client := New(config, nil)
+	if err := client.Start(context.Background()); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer client.Stop()
+	<-upstreams.Accept()
+	<-downstreams.Accept()
+	sessionID := client.GetSessionID()
+
+	streamID, _ := client.mux.OpenStream()
+	client.registerStream(&streamConn{conn: &mockConn{}, streamID: streamID, done: make(chan struct{})})
+
+	atomic.StoreInt32(&down, 1)
+	client.triggerReconnect("upstream")
+	for _, data := range []string{"hello", "world"} {
+		if err := client.mux.SendPacket(streamID, protocol.FlagData, []byte(data)); err != nil {
+			t.Fatalf("Expected data to be queued while reconnecting, got %v", err)
+		}
+	}
+	if n := client.degradation.QueuedCount(); n != 2 {
+		t.Fatalf("Expected 2 queued packets, got %d", n)
+	}
+
+	atomic.StoreInt32(&down, 0)
+	upstream := <-upstreams.Accept()
+	if pkt := readPacket(t, upstream); !pkt.IsHandshake() || pkt.SessionID != sessionID {
+		t.Fatalf("Expected a handshake resuming session %s, got %+v", sessionID, pkt)
+	}
+	for i, want := range []string{"hello", "world"} {
+		pkt := readPacket(t, upstream)
+		if pkt.StreamID != streamID || pkt.SeqNum != uint32(i) || string(pkt.Payload) != want {
+			t.Errorf("Expected queued packet %q (seq %d), got %q (seq %d)", want, i, pkt.Payload, pkt.SeqNum)
+		}
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for client.degradation.Mode() != health.ModeNormal && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if mode := client.degradation.Mode(); mode != health.ModeNormal {
+		t.Errorf("Expected normal mode after the flush, got %s", mode)
+	}
+	if got := testutil.ToFloat64(config.Metrics.DegradationTransitions.WithLabelValues("recovering")); got != 1 {
+		t.Errorf("Expected 1 transition to recovering, got %v", got)
+	}
+	client.streamConnsMu.RLock()
+	_, exists := client.streamConns[streamID]
+	client.streamConnsMu.RUnlock()
+	if !exists {
+		t.Error("Expected the stream to survive the reconnect")
+	}
+}
+
+func TestDegradationQueueFullResetsStream(t *testing.T) {
+	config := DefaultConfig()
+	config.Degradation = &health.DegradationConfig{QueueSize: 2, QueueTimeout: time.Minute, RecoveryTimeout: time.Minute}
+	config.Metrics = metrics.NewCollector()
+	client := New(config, nil)
+	client.session = session.New()
+	client.mux = mux.NewMultiplexer(client.session)
+	client.mux.SetPacketHandler(client.sendPacket)
+	client.degradation.EnterDegradedMode()
+
+	full, _ := client.mux.OpenStream()
+	client.registerStream(&streamConn{conn: &mockConn{}, streamID: full, done: make(chan struct{})})
+	other, _ := client.mux.OpenStream()
+	client.registerStream(&streamConn{conn: &mockConn{}, streamID: other, done: make(chan struct{})})
+
+	_ = client.mux.SendPacket(other, protocol.FlagData, []byte("kept"))
+	for i := 0; i < 3; i++ {
+		_ = client.mux.SendPacket(full, protocol.FlagData, []byte("bulk"))
+	}
+
+	client.streamConnsMu.RLock()
+	_, fullOpen := client.streamConns[full]
+	_, otherOpen := client.streamConns[other]
+	client.streamConnsMu.RUnlock()
+	if fullOpen {
+		t.Error("Expected the stream that lost queued data to be reset")
+	}
+	if otherOpen {
+		t.Error("Expected the stream whose packet was pushed out of the queue to be reset")
+	}
+	if got := testutil.ToFloat64(config.Metrics.DegradationDropped.WithLabelValues("queue_full")); got == 0 {
+		t.Error("Expected dropped packets to be counted")
+	}
+}
+
+func TestReconnectBreakerOpensAndResets(t *testing.T) {
+	config := DefaultConfig()
+	config.ReconnectBreaker = &circuitbreaker.Config{MaxFailures: 2, Timeout: time.Hour, MaxHalfOpenRequests: 1}
+	config.Metrics = metrics.NewCollector()
+	client := New(config, nil)
+
+	connectErr := errors.New("connection refused")
+	client.recordConnectResult(connectErr)
+	if got := client.Breaker().State; got != "closed" {
+		t.Fatalf("Expected breaker to stay closed below max failures, got %s", got)
+	}
+	client.recordConnectResult(connectErr)
+
+	b := client.Breaker()
+	if b.State != "open" || b.Failures != 2 || !b.RetryAt.Equal(b.OpenedAt.Add(time.Hour)) {
+		t.Fatalf("Unexpected breaker state: %+v", b)
+	}
+	if got := testutil.ToFloat64(config.Metrics.CircuitBreakerState.WithLabelValues(breakerMetricName)); got != 1 {
+		t.Errorf("Expected breaker state metric 1 (open), got %v", got)
+	}
+
+	// A reset ends the wait for the cooldown
+	done := make(chan error, 1)
+	go func() { done <- client.waitForBreaker(context.Background()) }()
+	time.Sleep(10 * time.Millisecond)
+	if err := client.ResetBreaker(); err != nil {
+		t.Fatalf("ResetBreaker failed: %v", err)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Expected wait to end without error, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected reset to end the wait")
+	}
+	if got := client.Breaker().State; got != "closed" {
+		t.Errorf("Expected breaker to be closed after reset, got %s", got)
+	}
+}
+
+func TestReconnectBreakerDisabled(t *testing.T) {
+	client := New(DefaultConfig(), nil)
+	client.recordConnectResult(errors.New("connection refused"))
+	if client.Breaker() != nil {
+		t.Error("Expected no breaker state when the breaker is disabled")
+	}
+	if err := client.waitForBreaker(context.Background()); err != nil {
+		t.Errorf("Expected no wait when the breaker is disabled, got %v", err)
+	}
+}
+
+func TestDataFlowMonitorMinBytes(t *testing.T) {
+	m := NewDataFlowMonitor(&DataFlowMonitorConfig{
+		CheckInterval:  time.Second,
+		StallThreshold: time.Minute,
+		StallAction:    StallActionRestart,
+		MinBytes:       1000,
+	}, nil)
+	var stalls []StallAction
+	m.SetStallCallback(func(action StallAction) { stalls = append(stalls, action) })
+
+	m.RecordSend(5000)
+	m.checkDataFlow()
+
+	// A trickle below MinBytes does not count as data flow
+	m.lastFlowTime = m.lastFlowTime.Add(-2 * time.Minute)
+	m.RecordSend(10)
+	m.checkDataFlow()
+	if len(stalls) != 1 || stalls[0] != StallActionRestart {
+		t.Fatalf("Expected one restart on a trickle below min_bytes, got %v", stalls)
+	}
+
+	m.RecordReceive(2000)
+	m.checkDataFlow()
+	if len(stalls) != 1 {
+		t.Errorf("Expected no stall once enough data flows, got %v", stalls)
+	}
+}
+
+// newForwardingClient returns a client that can forward streams without a
+// tunnel.
+func newForwardingClient() *Client {
+	config := DefaultConfig()
+	config.SOCKS5Enabled = false
+	config.ReconnectEnabled = false
+
+	client := New(config, nil)
+	client.session = session.New()
+	client.mux = mux.NewMultiplexer(client.session)
+	client.mux.SetPacketHandler(func(pkt *protocol.Packet) error { return nil })
+	return client
+}
+
+// TestForwardCancelledByContext verifies that cancelling the context closes
+// a stream blocked reading from an idle application.
+func TestForwardCancelledByContext(t *testing.T) {
+	client := newForwardingClient()
+	local, app := net.Pipe()
+	defer app.Close()
+
+	sc := &streamConn{conn: local, streamID: 1, done: make(chan struct{})}
+	client.registerStream(sc)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	returned := make(chan struct{})
+	go func() {
+		client.forwardClientToUpstream(ctx, sc)
+		close(returned)
+	}()
+	cancel()
+
+	select {
+	case <-returned:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the forwarder to return once cancelled")
+	}
+	if !streamDone(sc) {
+		t.Error("Expected the stream closed")
+	}
+	client.streamConnsMu.Lock()
+	open := len(client.streamConns)
+	client.streamConnsMu.Unlock()
+	if open != 0 {
+		t.Errorf("Expected no streams, got %d", open)
+	}
+}
+
+// TestForwardCancelledByShutdown verifies that a stream registered while the
+// client stops does not keep its forwarder blocked reading.
+func TestForwardCancelledByShutdown(t *testing.T) {
+	client := newForwardingClient()
+	atomic.StoreInt32(&client.running, 1)
+	_ = client.Stop()
+
+	local, app := net.Pipe()
+	defer app.Close()
+	sc := &streamConn{conn: local, streamID: 1, done: make(chan struct{})}
+	client.registerStream(sc)
+
+	returned := make(chan struct{})
+	go func() {
+		client.forwardClientToUpstream(context.Background(), sc)
+		close(returned)
+	}()
+
+	select {
+	case <-returned:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the forwarder to return once the client stopped")
+	}
+	if !streamDone(sc) {
+		t.Error("Expected the stream closed")
+	}
+}
+
+// TestPortForwardListenerCancelledByContext verifies that cancelling the
+// context stops a port forward listener waiting for connections.
+func TestPortForwardListenerCancelledByContext(t *testing.T) {
+	client := newForwardingClient()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	returned := make(chan struct{})
+	go func() {
+		client.runPortForwardListener(ctx, ln, PortForward{ListenPort: 1})
+		close(returned)
+	}()
+	cancel()
+
+	select {
+	case <-returned:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the listener to stop once cancelled")
+	}
+}
+
+// BenchmarkIdleStreams measures setting up and cancelling 10k idle streams,
+// each with a forwarder blocked reading from its application.
+func BenchmarkIdleStreams(b *testing.B) {
+	const streams = 10000
+	client := newForwardingClient()
+
+	for i := 0; i < b.N; i++ {
+		ctx, cancel := context.WithCancel(context.Background())
+		var wg sync.WaitGroup
+		apps := make([]net.Conn, 0, streams)
+		for id := uint32(1); id <= streams; id++ {
+			local, app := net.Pipe()
+			apps = append(apps, app)
+			sc := &streamConn{conn: local, streamID: id, done: make(chan struct{})}
+			client.registerStream(sc)
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				client.forwardClientToUpstream(ctx, sc)
+			}()
+		}
+		cancel()
+		wg.Wait()
+		for _, app := range apps {
+			app.Close()
+		}
+	}
+}
+
+func TestDialContextErrors(t *testing.T) {
+	client := New(DefaultConfig(), nil)
+
+	tests := []struct {
+		name    string
+		network string
+		address string
+	}{
+		{"UDP", "udp", "example.com:53"},
+		{"NoPort", "tcp", "example.com"},
+		{"BadPort", "tcp", "example.com:http"},
+		{"PortZero", "tcp", "example.com:0"},
+		{"NoHost", "tcp", ":80"},
+		{"NotStarted", "tcp", "example.com:80"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, err := client.DialContext(context.Background(), tt.network, tt.address)
+			if err == nil {
+				conn.Close()
+				t.Fatal("Expected an error")
+			}
+			var opErr *net.OpError
+			if !errors.As(err, &opErr) || opErr.Op != "dial" {
+				t.Errorf("Expected a dial *net.OpError, got %v", err)
+			}
+		})
+	}
+}
+
+func TestAwaitOpen(t *testing.T) {
+	newClient := func(timeout time.Duration) *Client {
+		config := DefaultConfig()
+		config.SOCKS5Enabled = false
+		config.ReconnectEnabled = false
+		config.StreamOpenTimeout = timeout
+		c := New(config, nil)
+		c.session = session.New()
+		c.session.SetProtocol(protocol.Version, uint32(protocol.CapOpenAck))
+		c.mux = mux.NewMultiplexer(c.session)
+		c.dataFlowMonitor = NewDataFlowMonitor(config.DataFlowMonitor, c.log)
+		return c
+	}
+	// open registers a stream whose open outcome is sent to outcome
+	open := func(c *Client, outcome chan<- error) *streamConn {
+		streamID, err := c.mux.OpenStream()
+		if err != nil {
+			t.Fatalf("OpenStream failed: %v", err)
+		}
+		sc := &streamConn{
+			conn:     &mockConn{},
+			streamID: streamID,
+			done:     make(chan struct{}),
+			open: func(err error) error {
+				outcome <- err
+				return nil
+			},
+		}
+		c.registerStream(sc)
+		return sc
+	}
+	ack := func(c *Client, sc *streamConn, result protocol.OpenResult) {
+		pkt, _ := protocol.NewPacket(c.session.ID, sc.streamID, protocol.FlagHandshake|protocol.FlagAck, result.Marshal())
+		c.handleDownstreamPacket(pkt)
+	}
+
+	t.Run("connected", func(t *testing.T) {
+		c := newClient(time.Second)
+		outcome := make(chan error, 1)
+		sc := open(c, outcome)
+		go ack(c, sc, protocol.OpenResult{})
+
+		if err := c.awaitOpen(context.Background(), sc); err != nil {
+			t.Fatalf("Expected the stream to open, got %v", err)
+		}
+		if err := <-outcome; err != nil {
+			t.Errorf("Expected a success reply, got %v", err)
+		}
+	})
+
+	t.Run("refused", func(t *testing.T) {
+		c := newClient(time.Second)
+		outcome := make(chan error, 1)
+		sc := open(c, outcome)
+		go ack(c, sc, protocol.OpenResult{Code: protocol.StreamErrorRefused, Attempts: 1})
+
+		err := c.awaitOpen(context.Background(), sc)
+		if reply := openFailureReply(err); reply != socks5.ReplyConnectionRefused {
+			t.Errorf("Expected a connection refused reply, got %#x for %v", reply, err)
+		}
+		if <-outcome == nil {
+			t.Error("Expected a failure reply")
+		}
+		select {
+		case <-sc.done:
+		default:
+			t.Error("Expected the refused stream to be closed")
+		}
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		c := newClient(10 * time.Millisecond)
+		outcome := make(chan error, 1)
+		sc := open(c, outcome)
+
+		err := c.awaitOpen(context.Background(), sc)
+		if reply := openFailureReply(err); reply != socks5.ReplyTTLExpired {
+			t.Errorf("Expected a TTL expired reply, got %#x for %v", reply, err)
+		}
+		if <-outcome == nil {
+			t.Error("Expected a failure reply")
+		}
+		c.streamConnsMu.RLock()
+		_, exists := c.streamConns[sc.streamID]
+		c.streamConnsMu.RUnlock()
+		if exists {
+			t.Error("Expected the stream to be closed after the timeout")
+		}
+	})
+
+	t.Run("not confirmed", func(t *testing.T) {
+		c := newClient(time.Second)
+		c.session.SetProtocol(protocol.Version, 0)
+		outcome := make(chan error, 1)
+		sc := open(c, outcome)
+
+		if err := c.awaitOpen(context.Background(), sc); err != nil {
+			t.Fatalf("Expected the stream to open at once, got %v", err)
+		}
+		if err := <-outcome; err != nil {
+			t.Errorf("Expected a success reply, got %v", err)
+		}
+	})
+}
+
+func TestFinWaitsForOvertakenData(t *testing.T) {
+	config := DefaultConfig()
+	config.SOCKS5Enabled = false
+	config.ReconnectEnabled = false
+	c := New(config, nil)
+	c.session = session.New()
+	c.mux = mux.NewMultiplexer(c.session)
+	c.dataFlowMonitor = NewDataFlowMonitor(config.DataFlowMonitor, c.log)
+
+	streamID, _ := c.mux.OpenStream()
+	conn := &mockConn{}
+	sc := &streamConn{conn: conn, streamID: streamID, done: make(chan struct{})}
+	c.registerStream(sc)
+
+	data := func(seq uint32, payload string) *protocol.Packet {
+		pkt, _ := protocol.NewPacket(c.session.ID, streamID, protocol.FlagData, []byte(payload))
+		pkt.SeqNum = seq
+		return pkt
+	}
+	fin, _ := protocol.NewFinReasonPacket(c.session.ID, streamID, protocol.Fin{Reason: protocol.CloseEOF})
+	fin.SeqNum = 2
+
+	// The FIN overtook the second data packet
+	c.handleDownstreamPacket(data(0, "first "))
+	c.handleDownstreamPacket(fin)
+	select {
+	case <-sc.done:
+		t.Fatal("Expected the stream to stay open until its data arrived")
+	default:
+	}
+
+	c.handleDownstreamPacket(data(1, "second"))
+	select {
+	case <-sc.done:
+	default:
+		t.Fatal("Expected the stream to be closed once its data arrived")
+	}
+	if got := string(conn.getWrittenData()); got != "first second" {
+		t.Errorf("Expected all data before the close, got %q", got)
+	}
+}
+
+func TestSeqAfter(t *testing.T) {
+	for _, tt := range []struct {
+		a, b uint32
+		want bool
+	}{
+		{2, 1, true},
+		{1, 2, false},
+		{1, 1, false},
+		// Across the wrap: the FIN numbered 1 overtook 0xFFFFFFFF and 0
+		{1, 0xFFFFFFFF, true},
+		{0, 0xFFFFFFFF, true},
+		{0xFFFFFFFF, 1, false},
+		{0xFFFFFFFF, 0, false},
+	} {
+		if got := seqAfter(tt.a, tt.b); got != tt.want {
+			t.Errorf("seqAfter(%#x, %#x) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestReconnectGivesUp(t *testing.T) {
+	originalDial := dialTransport
+	defer func() { dialTransport = originalDial }()
+	var dials int32
+	dialTransport = func(ctx context.Context, config *transport.Config) (*transport.Connection, error) {
+		atomic.AddInt32(&dials, 1)
+		return nil, errors.New("server unreachable")
+	}
+
+	newClient := func(limit *ReconnectLimit) *Client {
+		config := DefaultConfig()
+		config.SOCKS5Enabled = false
+		config.PingInterval = 0
+		config.ReconnectConfig = &retry.Config{InitialDelay: time.Millisecond, MaxDelay: time.Millisecond, Multiplier: 1}
+		config.ReconnectBreaker = nil
+		config.ReconnectLimit = limit
+		return New(config, nil)
+	}
+
+	t.Run("stop", func(t *testing.T) {
+		atomic.StoreInt32(&dials, 0)
+		client := newClient(&ReconnectLimit{MaxAttempts: 3})
+		if err := client.Start(context.Background()); err != nil {
+			t.Fatalf("Start failed: %v", err)
+		}
+		defer client.Stop()
+
+		select {
+		case <-client.Done():
+		case <-time.After(5 * time.Second):
+			t.Fatal("Expected the client to stop after giving up")
+		}
+		if err := client.Err(); !errors.Is(err, ErrReconnectGaveUp) {
+			t.Errorf("Expected ErrReconnectGaveUp, got %v", err)
+		}
+		// The initial connection and three reconnects
+		if n := atomic.LoadInt32(&dials); n != 4 {
+			t.Errorf("Expected 4 dials, got %d", n)
+		}
+	})
+
+	t.Run("script", func(t *testing.T) {
+		if runtime.GOOS == "windows" {
+			t.Skip("shell script")
+		}
+		dir := t.TempDir()
+		out := filepath.Join(dir, "attempts")
+		script := filepath.Join(dir, "give-up.sh")
+		if err := os.WriteFile(script, []byte("#!/bin/sh\necho \"$HT_RECONNECT_ATTEMPTS $1 $HT_RECONNECT_ERROR\" >> "+out+"\n"), 0o755); err != nil {
+			t.Fatalf("WriteFile failed: %v", err)
+		}
+
+		// The script is a shell command, so it can take arguments
+		client := newClient(&ReconnectLimit{MaxAttempts: 2, Script: script + " fallback"})
+		if err := client.Start(context.Background()); err != nil {
+			t.Fatalf("Start failed: %v", err)
+		}
+		defer client.Stop()
+
+		// The client keeps reconnecting after each run of the script
+		deadline := time.Now().Add(5 * time.Second)
+		var lines []string
+		for len(lines) < 2 {
+			if time.Now().After(deadline) {
+				t.Fatalf("Expected the script to run twice, got %q", lines)
+			}
+			time.Sleep(10 * time.Millisecond)
+			data, _ := os.ReadFile(out)
+			lines = strings.Split(strings.TrimSpace(string(data)), "\n")
+			if lines[0] == "" {
+				lines = nil
+			}
+		}
+		if !strings.HasPrefix(lines[0], "2 fallback ") || !strings.Contains(lines[0], "server unreachable") {
+			t.Errorf("Expected the argument, attempts and last error, got %q", lines[0])
+		}
+		if client.Err() != nil {
+			t.Errorf("Expected the client to keep running, got %v", client.Err())
+		}
+	})
+}
+
+func TestHookCommands(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("sh command")
+	}
+	upstreams := transport.NewServerHandler(nil, nil)
+	upstreamServer := httptest.NewServer(upstreams)
+	defer upstreamServer.Close()
+	downstreams := transport.NewServerHandler(nil, nil)
+	downstreamServer := httptest.NewServer(downstreams)
+	defer downstreamServer.Close()
+
+	out := filepath.Join(t.TempDir(), "events")
+	command := `echo "$HT_EVENT $HT_REASON $HT_SESSION_ID" >> ` + out
+
+	config := DefaultConfig()
+	config.UpstreamURL = "ws" + strings.TrimPrefix(upstreamServer.URL, "http")
+	config.DownstreamURL = "ws" + strings.TrimPrefix(downstreamServer.URL, "http")
+	config.SOCKS5Enabled = false
+	config.PingInterval = 0
+	config.ReconnectConfig = &retry.Config{InitialDelay: time.Millisecond, MaxDelay: time.Millisecond, Multiplier: 1}
+	config.HookCommands = &HookCommands{
+		OnConnect:    command,
+		OnDisconnect: command,
+		OnReconnect:  command,
+		Timeout:      5 * time.Second,
+	}
+
+	client := New(config, nil)
+	if err := client.Start(context.Background()); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer client.Stop()
+	<-upstreams.Accept()
+	<-downstreams.Accept()
+	first := client.GetSessionID().String()
+
+	client.triggerReconnect("upstream")
+	<-upstreams.Accept()
+	<-downstreams.Accept()
+
+	var lines []string
+	deadline := time.Now().Add(5 * time.Second)
+	for len(lines) < 4 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		data, _ := os.ReadFile(out)
+		lines = strings.Split(strings.TrimSpace(string(data)), "\n")
+	}
+	second := client.GetSessionID().String()
+	want := []string{
+		"connect  " + first,
+		"disconnect upstream " + first,
+		"connect  " + second,
+		"reconnect upstream " + second,
+	}
+	if strings.Join(lines, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Expected hook commands\n%s\ngot\n%s", strings.Join(want, "\n"), strings.Join(lines, "\n"))
+	}
+}
+
+func TestTunnelUpMetric(t *testing.T) {
+	upstreams := transport.NewServerHandler(nil, nil)
+	upstreamServer := httptest.NewServer(upstreams)
+	defer upstreamServer.Close()
+	downstreams := transport.NewServerHandler(nil, nil)
+	downstreamServer := httptest.NewServer(downstreams)
+	defer downstreamServer.Close()
+
+	config := DefaultConfig()
+	config.UpstreamURL = "ws" + strings.TrimPrefix(upstreamServer.URL, "http")
+	config.DownstreamURL = "ws" + strings.TrimPrefix(downstreamServer.URL, "http")
+	config.SOCKS5Enabled = false
+	config.PingInterval = 0
+	config.ReconnectEnabled = false
+	config.Metrics = metrics.NewCollector()
+	up := func(leg string) float64 {
+		return testutil.ToFloat64(config.Metrics.TunnelUp.WithLabelValues(leg))
+	}
+
+	client := New(config, nil)
+	if err := client.Start(context.Background()); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer client.Stop()
+	<-upstreams.Accept()
+	<-downstreams.Accept()
+
+	// Connected, but the handshake completes with the server's hello
+	if up("upstream") != 0 || up("downstream") != 0 {
+		t.Errorf("Expected both legs down before the hello, got upstream %v, downstream %v", up("upstream"), up("downstream"))
+	}
+	hello, _ := protocol.NewHelloPacket(client.session.ID, protocol.Hello{Version: 1})
+	client.handleControlPacket(hello)
+	if up("upstream") != 1 || up("downstream") != 1 {
+		t.Errorf("Expected both legs up, got upstream %v, downstream %v", up("upstream"), up("downstream"))
+	}
+	if got := testutil.ToFloat64(config.Metrics.LastHandshake); got == 0 {
+		t.Error("Expected the handshake time to be recorded")
+	}
+
+	client.triggerReconnect("upstream")
+	if up("upstream") != 0 || up("downstream") != 1 {
+		t.Errorf("Expected only upstream down, got upstream %v, downstream %v", up("upstream"), up("downstream"))
+	}
+
+	_ = client.Stop()
+	if up("downstream") != 0 {
+		t.Error("Expected downstream down after stopping")
+	}
+}
+
+func TestTunnelUpMetricSessionRejected(t *testing.T) {
+	config := DefaultConfig()
+	config.Metrics = metrics.NewCollector()
+	config.HandshakeTimeout = 20 * time.Millisecond
+	client := New(config, nil)
+	client.session = session.New()
+
+	// The rejection also cancels the wait for a hello
+	client.awaitHello()
+	rejected, _ := protocol.NewSessionLimitPacket(client.session.ID, protocol.ControlSessionRejected, protocol.SessionLimit{Reason: protocol.LimitSessions})
+	client.handleControlPacket(rejected)
+	time.Sleep(3 * config.HandshakeTimeout)
+
+	for _, leg := range []string{"upstream", "downstream"} {
+		if got := testutil.ToFloat64(config.Metrics.TunnelUp.WithLabelValues(leg)); got != 0 {
+			t.Errorf("Expected %s down after the session was rejected, got %v", leg, got)
+		}
+	}
+	if got := testutil.ToFloat64(config.Metrics.LastHandshake); got != 0 {
+		t.Errorf("Expected no handshake time after the session was rejected, got %v", got)
+	}
+}
+
+func TestTunnelUpMetricWithoutHello(t *testing.T) {
+	upstreams := transport.NewServerHandler(nil, nil)
+	upstreamServer := httptest.NewServer(upstreams)
+	defer upstreamServer.Close()
+	downstreams := transport.NewServerHandler(nil, nil)
+	downstreamServer := httptest.NewServer(downstreams)
+	defer downstreamServer.Close()
+
+	config := DefaultConfig()
+	config.UpstreamURL = "ws" + strings.TrimPrefix(upstreamServer.URL, "http")
+	config.DownstreamURL = "ws" + strings.TrimPrefix(downstreamServer.URL, "http")
+	config.SOCKS5Enabled = false
+	config.PingInterval = 0
+	config.ReconnectEnabled = false
+	config.HandshakeTimeout = 50 * time.Millisecond
+	config.Metrics = metrics.NewCollector()
+
+	client := New(config, nil)
+	if err := client.Start(context.Background()); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer client.Stop()
+	<-upstreams.Accept()
+	<-downstreams.Accept()
+
+	// A server that predates the hello exchange never answers the
+	// handshake; the tunnel is up once the client stops waiting
+	deadline := time.Now().Add(2 * time.Second)
+	for testutil.ToFloat64(config.Metrics.TunnelUp.WithLabelValues("upstream")) != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the tunnel up without a hello from the server")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := testutil.ToFloat64(config.Metrics.TunnelUp.WithLabelValues("downstream")); got != 1 {
+		t.Errorf("Expected downstream up, got %v", got)
+	}
+	if got := testutil.ToFloat64(config.Metrics.LastHandshake); got == 0 {
+		t.Error("Expected the handshake time to be recorded")
+	}
+}
@@ -0,0 +1,87 @@
+package client
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/sahmadiut/half-tunnel/internal/protocol"
+)
+
+// localHello returns the hello offered to the server.
+func (c *Client) localHello() protocol.Hello {
+	hello := protocol.LocalHello()
+	if !c.config.CompactHeader {
+		hello.Capabilities &^= protocol.CapCompactHeader
+	}
+	if !c.config.Checksum {
+		hello.Capabilities &^= protocol.CapChecksum
+	}
+	if c.config.MaxPadding <= 0 {
+		hello.Capabilities &^= protocol.CapPadding
+	}
+	if c.config.MaxPayloadSize > 0 {
+		hello.MaxPayload = uint32(c.config.MaxPayloadSize)
+	}
+	return hello
+}
+
+// useSessionIndex switches to compact headers if the server's hello
+// granted them.
+func (c *Client) useSessionIndex(hello protocol.Hello) {
+	if !hello.Capabilities.Has(protocol.CapCompactHeader) || hello.SessionIndex == 0 {
+		atomic.StoreInt32(&c.compactHeader, 0)
+		return
+	}
+	atomic.StoreUint32(&c.sessionIndex, hello.SessionIndex)
+	atomic.StoreInt32(&c.compactHeader, 1)
+}
+
+// usePayloadSize limits packet payloads to the size the server's hello
+// allowed. Jumbo frames need the compact header, and padded payloads leave
+// room for the padding trailer.
+func (c *Client) usePayloadSize(hello protocol.Hello) {
+	n := int(hello.MaxPayload)
+	if atomic.LoadInt32(&c.compactHeader) == 0 {
+		n = min(n, protocol.MaxPayloadSize)
+	}
+	if hello.Capabilities.Has(protocol.CapPadding) {
+		if n == 0 {
+			n = protocol.MaxPayloadSize
+		}
+		n -= protocol.PaddingTrailerSize
+	}
+	c.session.SetMaxPayload(n)
+}
+
+// marshalPacket encodes a packet for the server, with the compact header,
+// padding and checksum once the session negotiated them.
+func (c *Client) marshalPacket(pkt *protocol.Packet) ([]byte, error) {
+	if atomic.LoadInt32(&c.compactHeader) == 1 && pkt.SessionID == c.session.ID {
+		pkt.SessionIndex = atomic.LoadUint32(&c.sessionIndex)
+	}
+	if pkt.IsData() && c.paddingEnabled() {
+		if err := c.padPacket(pkt); err != nil {
+			return nil, err
+		}
+	}
+	if _, caps := c.session.Protocol(); protocol.Capability(caps).Has(protocol.CapChecksum) {
+		pkt.SetChecksum()
+	}
+	return pkt.Marshal()
+}
+
+// unmarshalPacket decodes a packet from the server, resolving the session
+// of compact headers.
+func (c *Client) unmarshalPacket(data []byte) (*protocol.Packet, error) {
+	pkt, err := protocol.Unmarshal(data)
+	if err != nil {
+		return nil, err
+	}
+	if pkt.IsCompact() {
+		if pkt.SessionIndex != atomic.LoadUint32(&c.sessionIndex) {
+			return nil, fmt.Errorf("unknown session index %d", pkt.SessionIndex)
+		}
+		pkt.SessionID = c.session.ID
+	}
+	return pkt, nil
+}
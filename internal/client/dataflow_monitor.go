@@ -18,6 +18,10 @@ type DataFlowMonitorConfig struct {
 	StallThreshold time.Duration
 	// StallAction specifies what to do when data flow stalls
 	StallAction StallAction
+	// MinBytes is the number of bytes a check interval must move to count
+	// as activity, so a trickle of small packets does not hide a stall (0
+	// counts any packet)
+	MinBytes int64
 }
 
 // StallAction specifies what action to take when data flow stalls.
@@ -32,6 +36,20 @@ const (
 	StallActionShutdown
 )
 
+// String returns the name of the stall action.
+func (a StallAction) String() string {
+	switch a {
+	case StallActionLog:
+		return "log"
+	case StallActionRestart:
+		return "restart"
+	case StallActionShutdown:
+		return "shutdown"
+	default:
+		return "unknown"
+	}
+}
+
 // DefaultDataFlowMonitorConfig returns default monitor configuration.
 func DefaultDataFlowMonitorConfig() *DataFlowMonitorConfig {
 	return &DataFlowMonitorConfig{
@@ -61,6 +79,9 @@ type DataFlowMonitor struct {
 	lastCheckBytesRecv int64
 	lastCheckTime      time.Time
 
+	// Last check that moved at least MinBytes
+	lastFlowTime time.Time
+
 	// State
 	running  int32
 	shutdown chan struct{}
@@ -220,33 +241,25 @@ func (m *DataFlowMonitor) checkDataFlow() {
 	if lastRecvTime.After(lastActivity) {
 		lastActivity = lastRecvTime
 	}
+	if m.config.MinBytes > 0 {
+		if deltaBytesSent+deltaBytesRecv >= m.config.MinBytes {
+			m.lastFlowTime = now
+		}
+		lastActivity = m.lastFlowTime
+	}
 
 	// Check if stalled (no data flow and enough time has passed)
 	timeSinceActivity := now.Sub(lastActivity)
 	isStalled := !lastActivity.IsZero() && timeSinceActivity > m.config.StallThreshold
 
-	// Log periodic stats
-	if deltaBytesSent > 0 || deltaBytesRecv > 0 {
-		sendRate := float64(deltaBytesSent) / elapsed.Seconds()
-		recvRate := float64(deltaBytesRecv) / elapsed.Seconds()
-
-		m.log.Info().
-			Int64("bytes_sent", deltaBytesSent).
-			Int64("bytes_recv", deltaBytesRecv).
-			Float64("send_rate_bps", sendRate).
-			Float64("recv_rate_bps", recvRate).
-			Int64("total_sent", currentBytesSent).
-			Int64("total_recv", currentBytesRecv).
-			Msg("Data flow stats")
-	} else if lastActivity.IsZero() {
-		// No data has ever been transferred
-		m.log.Debug().Msg("Data flow monitor: No data transferred yet")
-	} else if isStalled {
+	if isStalled {
 		// Data was flowing but has stopped
 		m.log.Warn().
 			Dur("time_since_activity", timeSinceActivity).
 			Time("last_send", lastSendTime).
 			Time("last_recv", lastRecvTime).
+			Int64("bytes_sent", deltaBytesSent).
+			Int64("bytes_recv", deltaBytesRecv).
 			Msg("Data flow stalled - no data transferred")
 
 		// Take action based on configuration
@@ -262,6 +275,22 @@ func (m *DataFlowMonitor) checkDataFlow() {
 		case StallActionShutdown:
 			m.log.Error().Msg("Triggering shutdown due to stalled data flow")
 		}
+	} else if deltaBytesSent > 0 || deltaBytesRecv > 0 {
+		// Log periodic stats
+		sendRate := float64(deltaBytesSent) / elapsed.Seconds()
+		recvRate := float64(deltaBytesRecv) / elapsed.Seconds()
+
+		m.log.Info().
+			Int64("bytes_sent", deltaBytesSent).
+			Int64("bytes_recv", deltaBytesRecv).
+			Float64("send_rate_bps", sendRate).
+			Float64("recv_rate_bps", recvRate).
+			Int64("total_sent", currentBytesSent).
+			Int64("total_recv", currentBytesRecv).
+			Msg("Data flow stats")
+	} else if lastActivity.IsZero() {
+		// No data has ever been transferred
+		m.log.Debug().Msg("Data flow monitor: No data transferred yet")
 	} else {
 		// Data is flowing normally, log at debug level
 		m.log.Debug().
@@ -286,4 +315,5 @@ func (m *DataFlowMonitor) Reset() {
 	m.lastCheckBytesSent = 0
 	m.lastCheckBytesRecv = 0
 	m.lastCheckTime = time.Now()
+	m.lastFlowTime = time.Time{}
 }
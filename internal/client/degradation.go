@@ -0,0 +1,209 @@
+package client
+
+import (
+	"encoding/binary"
+	"errors"
+	"strings"
+	"sync/atomic"
+
+	"github.com/google/uuid"
+	"github.com/sahmadiut/half-tunnel/internal/health"
+	"github.com/sahmadiut/half-tunnel/internal/protocol"
+)
+
+// dropRecoveryTimeout is the metrics reason for packets dropped because the
+// session could not be resumed. The queue itself drops packets with
+// "queue_full" and "timeout".
+const dropRecoveryTimeout = "recovery_timeout"
+
+// queuedHeaderSize is the size of the flags and sequence number stored with
+// each queued packet.
+const queuedHeaderSize = 5
+
+var errQueuedPacket = errors.New("malformed queued packet")
+
+// newDegradation creates the graceful degradation handler that queues
+// stream data while the client reconnects, or nil if it is disabled.
+func (c *Client) newDegradation() *health.GracefulDegradation {
+	if c.config.Degradation == nil {
+		return nil
+	}
+	gd := health.NewGracefulDegradation(c.config.Degradation)
+	gd.SetOnModeChange(c.degradationModeChanged)
+	gd.SetOnPacketDrop(func(packet health.QueuedPacket, reason string) {
+		c.queuedPacketDropped(packet, strings.ReplaceAll(reason, " ", "_"))
+	})
+	return gd
+}
+
+// degradationModeChanged logs and exports a degradation mode change.
+func (c *Client) degradationModeChanged(old, mode health.DegradationMode) {
+	event := c.log.Info()
+	if mode == health.ModeDegraded || mode == health.ModeFailed {
+		event = c.log.Warn()
+	}
+	event.
+		Str("from", old.String()).
+		Str("to", mode.String()).
+		Int("queued_packets", c.degradation.QueuedCount()).
+		Msg("Degradation mode changed")
+	if c.config.Metrics != nil {
+		c.config.Metrics.SetDegradationMode(int(mode), mode.String())
+	}
+}
+
+// queuedPacketDropped records a queued packet that will never be sent. Its
+// stream has lost data, so it is reset once the queue is no longer locked
+// (see resetDroppedStreams).
+func (c *Client) queuedPacketDropped(packet health.QueuedPacket, reason string) {
+	if c.config.Metrics != nil {
+		c.config.Metrics.RecordDegradationDrop(reason)
+	}
+	// A lost FIN needs no reset: the stream is closed already, and
+	// resetting it would queue another FIN
+	if len(packet.Data) > 0 && protocol.Flag(packet.Data[0])&protocol.FlagFin != 0 {
+		return
+	}
+	c.droppedStreamsMu.Lock()
+	if c.droppedStreams == nil {
+		c.droppedStreams = make(map[uint32]struct{})
+	}
+	c.droppedStreams[packet.StreamID] = struct{}{}
+	c.droppedStreamsMu.Unlock()
+}
+
+// resetDroppedStreams resets the streams that lost queued packets.
+func (c *Client) resetDroppedStreams() {
+	c.droppedStreamsMu.Lock()
+	dropped := c.droppedStreams
+	c.droppedStreams = nil
+	c.droppedStreamsMu.Unlock()
+
+	for streamID := range dropped {
+		c.log.Debug().
+			Uint32("stream_id", streamID).
+			Msg("Resetting stream that lost queued data")
+		c.resetStream(streamID, protocol.Fin{Reason: protocol.CloseTunnelError, Message: "queued data dropped"})
+	}
+}
+
+// droppedStream reports whether streamID lost queued packets.
+func (c *Client) droppedStream(streamID uint32) bool {
+	c.droppedStreamsMu.Lock()
+	defer c.droppedStreamsMu.Unlock()
+	_, dropped := c.droppedStreams[streamID]
+	return dropped
+}
+
+// resumable reports whether a reconnect should keep the session and its
+// streams, queueing their data until the tunnel is back.
+func (c *Client) resumable() bool {
+	return c.degradation != nil && atomic.LoadInt32(&c.sessionExpired) == 0
+}
+
+// queueing reports whether stream packets are queued instead of sent.
+func (c *Client) queueing() bool {
+	if c.degradation == nil {
+		return false
+	}
+	mode := c.degradation.Mode()
+	return mode == health.ModeDegraded || mode == health.ModeRecovering
+}
+
+// queuePacket queues a stream packet while the client reconnects. It
+// reports false if the packet must be sent now: it belongs to no stream or
+// the client is not reconnecting.
+func (c *Client) queuePacket(pkt *protocol.Packet) bool {
+	if pkt.StreamID == 0 || !c.queueing() {
+		return false
+	}
+
+	c.queueMu.Lock()
+	// Recovery may have completed while we waited for the flush
+	if !c.queueing() {
+		c.queueMu.Unlock()
+		return false
+	}
+	c.degradation.QueuePacket(pkt.StreamID, encodeQueued(pkt))
+	c.queueMu.Unlock()
+
+	c.resetDroppedStreams()
+	return true
+}
+
+// flushQueue sends the packets queued while reconnecting and returns to
+// normal mode. Senders wait for the flush, so packets keep their order. If
+// a write fails, the unsent packets are queued again.
+func (c *Client) flushQueue() error {
+	c.queueMu.Lock()
+	packets := c.degradation.DrainQueue()
+	var err error
+	for i, queued := range packets {
+		// The rest of a stream that lost data would arrive with a gap
+		if c.droppedStream(queued.StreamID) {
+			continue
+		}
+		pkt, decodeErr := decodeQueued(c.session.ID, queued)
+		if decodeErr != nil {
+			continue
+		}
+		if err = c.writeUpstream(pkt); err != nil {
+			for _, p := range packets[i:] {
+				c.degradation.QueuePacket(p.StreamID, p.Data)
+			}
+			break
+		}
+	}
+	if err == nil {
+		c.degradation.RecoveryComplete()
+	}
+	c.queueMu.Unlock()
+
+	c.resetDroppedStreams()
+	if err == nil && len(packets) > 0 {
+		c.log.Info().Int("packets", len(packets)).Msg("Sent packets queued while reconnecting")
+	}
+	return err
+}
+
+// abandonResume gives up resuming the session: queued packets are dropped
+// and the streams waiting for them closed.
+func (c *Client) abandonResume() {
+	c.degradation.MarkFailed()
+
+	c.queueMu.Lock()
+	for _, queued := range c.degradation.DrainQueue() {
+		c.queuedPacketDropped(queued, dropRecoveryTimeout)
+	}
+	c.queueMu.Unlock()
+
+	c.droppedStreamsMu.Lock()
+	c.droppedStreams = nil
+	c.droppedStreamsMu.Unlock()
+	c.closeAllStreams()
+}
+
+// encodeQueued encodes a stream packet for the degradation queue. The
+// packet is marshaled when it is sent, so it gets the header the new
+// connection negotiated.
+func encodeQueued(pkt *protocol.Packet) []byte {
+	data := make([]byte, queuedHeaderSize+len(pkt.Payload))
+	data[0] = byte(pkt.Flags)
+	binary.BigEndian.PutUint32(data[1:], pkt.SeqNum)
+	copy(data[queuedHeaderSize:], pkt.Payload)
+	return data
+}
+
+// decodeQueued restores a packet of session sessionID from the
+// degradation queue.
+func decodeQueued(sessionID uuid.UUID, queued health.QueuedPacket) (*protocol.Packet, error) {
+	if len(queued.Data) < queuedHeaderSize {
+		return nil, errQueuedPacket
+	}
+	pkt, err := protocol.NewJumboPacket(sessionID, queued.StreamID, protocol.Flag(queued.Data[0]), queued.Data[queuedHeaderSize:])
+	if err != nil {
+		return nil, err
+	}
+	pkt.SeqNum = binary.BigEndian.Uint32(queued.Data[1:])
+	return pkt, nil
+}
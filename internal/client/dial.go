@@ -0,0 +1,58 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+)
+
+// dialForward is the usage label of streams opened with DialContext.
+const dialForward = "dial"
+
+// DialContext opens a connection to address through the tunnel, like
+// net.Dialer.DialContext, for Go programs that embed the client. Only the
+// "tcp", "tcp4" and "tcp6" networks are supported. Routing rules do not
+// apply: the connection always goes through the tunnel.
+//
+// ctx bounds the dial only; the connection lasts until it is closed or the
+// client stops. DialContext returns once the server connected the
+// destination, or failed to, for at most StreamOpenTimeout. Servers that do
+// not confirm stream opens dial the destination after the connection is
+// returned, so a destination that cannot be reached shows up as a closed
+// connection rather than a dial error. Reads should not stall for long:
+// data for the connection is delivered on the tunnel's downstream reader.
+func (c *Client) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+	default:
+		return nil, &net.OpError{Op: "dial", Net: network, Err: net.UnknownNetworkError(network)}
+	}
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, &net.OpError{Op: "dial", Net: network, Err: err}
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil || port == 0 {
+		return nil, &net.OpError{Op: "dial", Net: network, Err: fmt.Errorf("invalid port %q", portStr)}
+	}
+	if host == "" {
+		return nil, &net.OpError{Op: "dial", Net: network, Err: fmt.Errorf("missing host in address %q", address)}
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, &net.OpError{Op: "dial", Net: network, Err: err}
+	}
+
+	c.mu.RLock()
+	clientCtx := c.ctx
+	c.mu.RUnlock()
+	if clientCtx == nil {
+		return nil, &net.OpError{Op: "dial", Net: network, Err: fmt.Errorf("client not started")}
+	}
+
+	conn, err := c.dialTunnel(clientCtx, ctx, dialForward, host, uint16(port))
+	if err != nil {
+		return nil, &net.OpError{Op: "dial", Net: network, Err: err}
+	}
+	return conn, nil
+}
@@ -0,0 +1,105 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrReconnectGaveUp is returned by Err when the client stopped because
+// reconnecting failed for longer than its ReconnectLimit allows.
+var ErrReconnectGaveUp = errors.New("gave up reconnecting to the server")
+
+// giveUpScriptTimeout bounds how long a give-up script may run.
+const giveUpScriptTimeout = time.Minute
+
+// ReconnectLimit bounds how long the client keeps reconnecting after the
+// connection is lost. Once either limit is reached, the client runs Script
+// and starts over, or stops with ErrReconnectGaveUp if there is no script.
+type ReconnectLimit struct {
+	// MaxAttempts is the number of failed reconnect attempts before giving
+	// up (0 means no limit)
+	MaxAttempts int
+	// MaxElapsedTime is how long reconnecting may fail before giving up
+	// (0 means no limit)
+	MaxElapsedTime time.Duration
+	// Script is a shell command run instead of stopping the client
+	// (optional). It gets the attempts, elapsed seconds and last error in
+	// HT_RECONNECT_ATTEMPTS, HT_RECONNECT_ELAPSED and HT_RECONNECT_ERROR.
+	Script string
+}
+
+// exhausted reports whether attempts failed reconnect attempts over elapsed
+// reach the limit.
+func (l *ReconnectLimit) exhausted(attempts int, elapsed time.Duration) bool {
+	if l == nil {
+		return false
+	}
+	return (l.MaxAttempts > 0 && attempts >= l.MaxAttempts) ||
+		(l.MaxElapsedTime > 0 && elapsed >= l.MaxElapsedTime)
+}
+
+// Done returns a channel that is closed when the client stops, including
+// when it stops by itself.
+func (c *Client) Done() <-chan struct{} {
+	return c.shutdown
+}
+
+// Err returns why the client stopped by itself, or nil.
+func (c *Client) Err() error {
+	c.errMu.Lock()
+	defer c.errMu.Unlock()
+	return c.err
+}
+
+// giveUpReconnect is called when reconnecting failed attempts times over
+// elapsed. It runs the give-up script and returns true to start over, or
+// stops the client and returns false if there is no script.
+func (c *Client) giveUpReconnect(ctx context.Context, attempts int, elapsed time.Duration, lastErr error) bool {
+	limit := c.config.ReconnectLimit
+	if limit.Script != "" {
+		c.log.Error().
+			Int("attempts", attempts).
+			Dur("elapsed", elapsed).
+			Str("script", limit.Script).
+			Msg("Reconnecting keeps failing, running give-up script")
+		c.runGiveUpScript(ctx, attempts, elapsed, lastErr)
+		return ctx.Err() == nil
+	}
+
+	c.log.Error().
+		Int("attempts", attempts).
+		Dur("elapsed", elapsed).
+		Msg("Giving up reconnecting, stopping client")
+	c.errMu.Lock()
+	c.err = ErrReconnectGaveUp
+	c.errMu.Unlock()
+	go func() {
+		_ = c.Stop()
+	}()
+	return false
+}
+
+// runGiveUpScript runs the give-up script and logs its outcome.
+func (c *Client) runGiveUpScript(ctx context.Context, attempts int, elapsed time.Duration, lastErr error) {
+	ctx, cancel := context.WithTimeout(ctx, giveUpScriptTimeout)
+	defer cancel()
+
+	cmd := shellCommand(ctx, c.config.ReconnectLimit.Script)
+	cmd.Env = append(os.Environ(),
+		"HT_RECONNECT_ATTEMPTS="+strconv.Itoa(attempts),
+		"HT_RECONNECT_ELAPSED="+strconv.Itoa(int(elapsed.Seconds())),
+	)
+	if lastErr != nil {
+		cmd.Env = append(cmd.Env, "HT_RECONNECT_ERROR="+lastErr.Error())
+	}
+	out, err := cmd.CombinedOutput()
+	event := c.log.Info()
+	if err != nil {
+		event = c.log.Warn().Err(err)
+	}
+	event.Str("output", strings.TrimSpace(string(out))).Msg("Give-up script finished")
+}
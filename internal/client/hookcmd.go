@@ -0,0 +1,103 @@
+package client
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// hookCommandQueueSize is how many hook commands may wait to run; events
+// beyond it are dropped.
+const hookCommandQueueSize = 16
+
+// HookCommands are shell commands run on tunnel events, e.g. to flush DNS
+// caches, update routes or send notifications. They run one at a time in
+// the order of the events, in the background, with HT_EVENT, HT_SESSION_ID
+// and HT_REASON set. Empty commands are skipped.
+type HookCommands struct {
+	// OnConnect runs whenever the tunnel connects, including after a
+	// reconnect
+	OnConnect string
+	// OnDisconnect runs when the tunnel connection is lost; HT_REASON is
+	// what noticed it
+	OnDisconnect string
+	// OnReconnect runs when the tunnel connects again after it was lost;
+	// HT_REASON is what noticed the loss
+	OnReconnect string
+	// OnStall runs when data stops flowing; HT_REASON is the configured
+	// stall action
+	OnStall string
+	// Timeout is how long a command may run before it is killed
+	Timeout time.Duration
+}
+
+// hookCommand is a hook command waiting to run.
+type hookCommand struct {
+	event     string
+	command   string
+	sessionID string
+	reason    string
+}
+
+// queueHookCommand queues command for the event, if it is set.
+func (c *Client) queueHookCommand(event, command, reason string) {
+	if command == "" || c.hookCommands == nil {
+		return
+	}
+	cmd := hookCommand{event: event, command: command, reason: reason}
+	if c.session != nil {
+		cmd.sessionID = c.session.ID.String()
+	}
+	select {
+	case c.hookCommands <- cmd:
+	default:
+		c.log.Warn().Str("event", event).Msg("Too many hook commands waiting, skipping")
+	}
+}
+
+// hookCommandLoop runs queued hook commands until the client stops.
+func (c *Client) hookCommandLoop(ctx context.Context) {
+	defer c.wg.Done()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case cmd := <-c.hookCommands:
+			c.runHookCommand(cmd)
+		}
+	}
+}
+
+// runHookCommand runs a hook command and logs its outcome.
+func (c *Client) runHookCommand(hc hookCommand) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.config.HookCommands.Timeout)
+	defer cancel()
+
+	cmd := shellCommand(ctx, hc.command)
+	cmd.Env = append(os.Environ(),
+		"HT_EVENT="+hc.event,
+		"HT_SESSION_ID="+hc.sessionID,
+		"HT_REASON="+hc.reason,
+	)
+	start := time.Now()
+	out, err := cmd.CombinedOutput()
+	event := c.log.Debug()
+	if err != nil {
+		event = c.log.Warn().Err(err)
+	}
+	event.Str("event", hc.event).
+		Dur("duration", time.Since(start)).
+		Str("output", strings.TrimSpace(string(out))).
+		Msg("Hook command finished")
+}
+
+// shellCommand returns a command running command with the platform's shell.
+func shellCommand(ctx context.Context, command string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.CommandContext(ctx, "cmd", "/C", command)
+	}
+	return exec.CommandContext(ctx, "sh", "-c", command)
+}
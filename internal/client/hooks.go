@@ -0,0 +1,66 @@
+package client
+
+import (
+	"net"
+	"strconv"
+
+	"github.com/google/uuid"
+)
+
+// Hooks are called on client lifecycle events, e.g. by a program embedding
+// the client. They run on the goroutine of the event and must not block.
+// Any of them may be nil.
+type Hooks struct {
+	// Connected is called when the tunnel is connected or reconnected
+	Connected func(sessionID uuid.UUID)
+	// Disconnected is called when the tunnel connection is lost; source
+	// tells what noticed it
+	Disconnected func(source string)
+	// StreamOpened is called when a stream to destAddr is opened
+	StreamOpened func(streamID uint32, destAddr string)
+	// StreamClosed is called when a stream is closed; closedBy is "local"
+	// or "peer"
+	StreamClosed func(streamID uint32, closedBy, reason string)
+}
+
+func (c *Client) hookConnected() {
+	if h := c.config.Hooks; h != nil && h.Connected != nil {
+		h.Connected(c.session.ID)
+	}
+	if hc := c.config.HookCommands; hc != nil {
+		c.queueHookCommand("connect", hc.OnConnect, "")
+	}
+}
+
+func (c *Client) hookDisconnected(source string) {
+	if h := c.config.Hooks; h != nil && h.Disconnected != nil {
+		h.Disconnected(source)
+	}
+	if hc := c.config.HookCommands; hc != nil {
+		c.queueHookCommand("disconnect", hc.OnDisconnect, source)
+	}
+}
+
+func (c *Client) hookReconnected(source string) {
+	if hc := c.config.HookCommands; hc != nil {
+		c.queueHookCommand("reconnect", hc.OnReconnect, source)
+	}
+}
+
+func (c *Client) hookStalled(action StallAction) {
+	if hc := c.config.HookCommands; hc != nil {
+		c.queueHookCommand("stall", hc.OnStall, action.String())
+	}
+}
+
+func (c *Client) hookStreamOpened(sc *streamConn) {
+	if h := c.config.Hooks; h != nil && h.StreamOpened != nil {
+		h.StreamOpened(sc.streamID, net.JoinHostPort(sc.destHost, strconv.Itoa(int(sc.destPort))))
+	}
+}
+
+func (c *Client) hookStreamClosed(sc *streamConn, closedBy, reason string) {
+	if h := c.config.Hooks; h != nil && h.StreamClosed != nil {
+		h.StreamClosed(sc.streamID, closedBy, reason)
+	}
+}
@@ -0,0 +1,149 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/sahmadiut/half-tunnel/internal/protocol"
+	"github.com/sahmadiut/half-tunnel/internal/socks5"
+)
+
+// errOpenTimeout is returned when the server did not report in time whether
+// it connected a stream's destination.
+var errOpenTimeout = errors.New("timed out waiting for the server to connect the destination")
+
+// errOpenClosed is returned when a stream is closed before the server
+// reported whether it connected its destination.
+var errOpenClosed = errors.New("stream closed before the destination was connected")
+
+// openError is the reason the server could not connect a stream's
+// destination.
+type openError struct {
+	result protocol.OpenResult
+}
+
+func (e *openError) Error() string {
+	return fmt.Sprintf("server could not connect to destination: %s", e.result.Code)
+}
+
+// openAckEnabled reports whether the server confirms stream opens.
+func (c *Client) openAckEnabled() bool {
+	_, caps := c.session.Protocol()
+	return protocol.Capability(caps).Has(protocol.CapOpenAck)
+}
+
+// handleOpenResult handles the HANDSHAKE_ACK of a stream, which tells
+// whether the server connected its destination.
+func (c *Client) handleOpenResult(pkt *protocol.Packet) {
+	result, err := protocol.ParseOpenResult(pkt.Payload)
+	if err != nil {
+		c.log.Debug().Err(err).
+			Uint32("stream_id", pkt.StreamID).
+			Msg("Ignoring malformed stream open result")
+		return
+	}
+	if !result.OK() {
+		c.handleStreamError(result.StreamError(pkt.StreamID))
+		return
+	}
+	c.finishOpen(pkt.StreamID, nil)
+}
+
+// finishOpen ends the open of a registered stream with err.
+func (c *Client) finishOpen(streamID uint32, err error) {
+	c.streamConnsMu.RLock()
+	sc, exists := c.streamConns[streamID]
+	c.streamConnsMu.RUnlock()
+	if exists {
+		sc.finishOpen(err)
+	}
+}
+
+// finishOpen ends the open of the stream with err, nil if its destination
+// is connected, and calls sc.open. Only the first call has an effect.
+func (sc *streamConn) finishOpen(err error) {
+	sc.openOnce.Do(func() {
+		if sc.open != nil {
+			if replyErr := sc.open(err); err == nil {
+				err = replyErr
+			}
+		}
+		sc.openErr = err
+		if sc.opened != nil {
+			close(sc.opened)
+		}
+	})
+}
+
+// awaitOpen waits until the server connected the destination of a stream
+// that was just opened, for at most StreamOpenTimeout. It finishes the open
+// at once if the server does not confirm stream opens or StreamOpenTimeout
+// is 0. If the destination is not connected, the stream is closed.
+func (c *Client) awaitOpen(ctx context.Context, sc *streamConn) error {
+	if c.config.StreamOpenTimeout <= 0 || !c.openAckEnabled() {
+		sc.finishOpen(nil)
+		if sc.openErr != nil {
+			c.resetStream(sc.streamID, protocol.Fin{Reason: protocol.CloseWriteError, Message: sc.openErr.Error()})
+		}
+		return sc.openErr
+	}
+
+	timer := time.NewTimer(c.config.StreamOpenTimeout)
+	defer timer.Stop()
+
+	select {
+	case <-sc.opened:
+	case <-timer.C:
+		sc.finishOpen(errOpenTimeout)
+	case <-ctx.Done():
+		sc.finishOpen(ctx.Err())
+	}
+	<-sc.opened
+
+	err := sc.openErr
+	var openErr *openError
+	switch {
+	case err == nil:
+		if c.config.Metrics != nil {
+			c.config.Metrics.RecordStreamLatency("connect", time.Since(sc.created))
+		}
+	case errors.Is(err, errOpenClosed), errors.As(err, &openErr):
+		// The stream is already closed
+	case errors.Is(err, errOpenTimeout):
+		c.resetStream(sc.streamID, protocol.Fin{Reason: protocol.CloseDialFailed, Message: err.Error()})
+	case ctx.Err() != nil:
+		c.resetStream(sc.streamID, protocol.Fin{Reason: protocol.CloseShutdown})
+	default:
+		// Replying to the local client failed
+		c.resetStream(sc.streamID, protocol.Fin{Reason: protocol.CloseWriteError, Message: err.Error()})
+	}
+	return err
+}
+
+// openFailureReply returns the SOCKS5 reply for a stream that awaitOpen
+// failed with err.
+func openFailureReply(err error) byte {
+	if errors.Is(err, errOpenTimeout) {
+		return socks5.ReplyTTLExpired
+	}
+	var openErr *openError
+	if !errors.As(err, &openErr) {
+		return socks5.ReplyGeneralFailure
+	}
+	switch openErr.result.Code {
+	case protocol.StreamErrorRefused:
+		return socks5.ReplyConnectionRefused
+	case protocol.StreamErrorTimeout:
+		return socks5.ReplyTTLExpired
+	case protocol.StreamErrorUnreachable:
+		return socks5.ReplyNetworkUnreachable
+	case protocol.StreamErrorHostNotFound:
+		return socks5.ReplyHostUnreachable
+	case protocol.StreamErrorBlocked:
+		return socks5.ReplyNotAllowed
+	default:
+		return socks5.ReplyGeneralFailure
+	}
+}
@@ -0,0 +1,91 @@
+package client
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	"github.com/sahmadiut/half-tunnel/internal/protocol"
+)
+
+// CoverTrafficConfig configures dummy packets sent upstream so that idle
+// periods and keepalive timing do not stand out.
+type CoverTrafficConfig struct {
+	// Interval is the average time between dummy packets
+	Interval time.Duration
+	// Jitter randomizes each interval by up to this fraction (0.0 to 1.0)
+	Jitter float64
+	// MinSize and MaxSize bound the random size of a dummy packet's body
+	MinSize int
+	MaxSize int
+}
+
+// nextInterval returns the time until the next dummy packet.
+func (cfg *CoverTrafficConfig) nextInterval() time.Duration {
+	d := float64(cfg.Interval)
+	if cfg.Jitter > 0 {
+		d += d * cfg.Jitter * (rand.Float64()*2 - 1)
+	}
+	return time.Duration(d)
+}
+
+// nextSize returns the body size of the next dummy packet.
+func (cfg *CoverTrafficConfig) nextSize() int {
+	return randomBetween(cfg.MinSize, cfg.MaxSize)
+}
+
+// randomBetween returns a random number from lo to hi inclusive.
+func randomBetween(lo, hi int) int {
+	if hi <= lo {
+		return lo
+	}
+	return lo + rand.Intn(hi-lo+1)
+}
+
+// paddingEnabled reports whether the session negotiated padded data
+// packets.
+func (c *Client) paddingEnabled() bool {
+	_, caps := c.session.Protocol()
+	return protocol.Capability(caps).Has(protocol.CapPadding)
+}
+
+// padPacket pads a data packet with up to MaxPadding random bytes. The
+// padded payload stays within the negotiated payload size, which
+// usePayloadSize lowered by the padding trailer.
+func (c *Client) padPacket(pkt *protocol.Packet) error {
+	room := c.mux.MaxPayload() - len(pkt.Payload)
+	return pkt.Pad(randomBetween(0, min(c.config.MaxPadding, room)))
+}
+
+// coverTrafficLoop sends dummy packets upstream while the client runs.
+func (c *Client) coverTrafficLoop(ctx context.Context) {
+	defer c.wg.Done()
+
+	cfg := c.config.CoverTraffic
+	for {
+		timer := time.NewTimer(cfg.nextInterval())
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-c.shutdown:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		if !c.IsConnected() {
+			continue
+		}
+		// The body and control type must fit a packet
+		size := min(cfg.nextSize(), c.mux.MaxPayload()-1)
+		pkt, err := protocol.NewPaddingPacket(c.session.ID, size)
+		if err != nil {
+			c.log.Debug().Err(err).Msg("Failed to create cover traffic packet")
+			continue
+		}
+		if err := c.sendPacket(pkt); err != nil {
+			c.log.Debug().Err(err).Msg("Failed to send cover traffic packet")
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/sahmadiut/half-tunnel/internal/diag"
+)
+
+// echoProbeTimeout bounds an echo probe when the caller's context has no
+// deadline.
+const echoProbeTimeout = 5 * time.Second
+
+// echoProbeSize is the size of the message sent by an echo probe.
+const echoProbeSize = 32
+
+// probeEcho opens a stream to the server's echo target and checks that a
+// random message comes back, proving that streams can be opened and carry
+// data both ways. The server must have diagnostics enabled.
+func (c *Client) probeEcho(ctx context.Context) (time.Duration, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, echoProbeTimeout)
+		defer cancel()
+	}
+
+	start := time.Now()
+	conn, err := c.dialTunnel(ctx, ctx, "diagnostics", diag.EchoHost, diag.EchoPort)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	defer context.AfterFunc(ctx, func() { _ = conn.SetDeadline(time.Now()) })()
+
+	msg := make([]byte, echoProbeSize)
+	_, _ = rand.Read(msg)
+	go func() { _, _ = conn.Write(msg) }()
+
+	reply := make([]byte, len(msg))
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		if ctx.Err() != nil {
+			return 0, fmt.Errorf("no echo from %s: %w", diag.EchoAddr, ctx.Err())
+		}
+		return 0, fmt.Errorf("no echo from %s: %w", diag.EchoAddr, err)
+	}
+	if !bytes.Equal(reply, msg) {
+		return 0, fmt.Errorf("corrupted echo from %s", diag.EchoAddr)
+	}
+	return time.Since(start), nil
+}
@@ -0,0 +1,97 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+
+	"github.com/sahmadiut/half-tunnel/internal/routing"
+	"github.com/sahmadiut/half-tunnel/internal/socks5"
+)
+
+// errBlocked is returned for connections refused by a routing rule.
+var errBlocked = errors.New("blocked by routing rule")
+
+// route returns the routing action for a connection to host:port accepted by
+// the listener called name. Connections that leave the tunnel are logged and
+// every decision is counted per rule.
+func (c *Client) route(ctx context.Context, name, host string, port uint16) routing.Action {
+	c.mu.RLock()
+	router := c.config.Router
+	c.mu.RUnlock()
+
+	action, rule := router.Route(ctx, host, port)
+	if router != nil && c.config.Metrics != nil {
+		label := rule
+		if label == "" {
+			label = "default"
+		}
+		c.config.Metrics.RecordRoutingHit(label, string(action))
+	}
+	if action != routing.ActionTunnel {
+		c.log.Debug().
+			Str("listener", name).
+			Str("dest_addr", socks5.FormatDestination(host, port)).
+			Str("action", string(action)).
+			Str("rule", rule).
+			Msg("Routing connection outside the tunnel")
+	}
+	return action
+}
+
+// SetRouter replaces the routing rules for new connections; nil tunnels
+// everything.
+func (c *Client) SetRouter(router *routing.Router) {
+	c.mu.Lock()
+	c.config.Router = router
+	c.mu.Unlock()
+}
+
+// dialDirect connects to host:port from the client, bypassing the tunnel.
+func (c *Client) dialDirect(ctx context.Context, host string, port uint16) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: c.config.DialTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", socks5.FormatDestination(host, port))
+	if err != nil {
+		return nil, err
+	}
+	if err := c.config.TCP.Apply(conn); err != nil {
+		c.log.Debug().Err(err).Str("dest_addr", conn.RemoteAddr().String()).Msg("Failed to set direct socket options")
+	}
+	return conn, nil
+}
+
+// connectDirect serves a SOCKS5 CONNECT request routed directly.
+func (c *Client) connectDirect(ctx context.Context, server *socks5.Server, req *socks5.ConnectRequest) error {
+	dest, err := c.dialDirect(ctx, req.DestHost, req.DestPort)
+	if err != nil {
+		c.log.Debug().Err(err).
+			Str("dest_addr", socks5.FormatDestination(req.DestHost, req.DestPort)).
+			Msg("Direct connection failed")
+		_ = server.SendFailureReply(req.ClientConn, socks5.ReplyGeneralFailure)
+		return err
+	}
+	defer dest.Close()
+
+	if err := server.SendSuccessReply(req.ClientConn, "0.0.0.0", 0); err != nil {
+		return err
+	}
+	relayDirect(req.ClientConn, dest)
+	return nil
+}
+
+// relayDirect copies data between a local connection and a directly dialed
+// destination. When either side finishes both are closed.
+func relayDirect(local, dest net.Conn) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, _ = io.Copy(dest, local)
+		dest.Close()
+		local.Close()
+	}()
+	_, _ = io.Copy(local, dest)
+	dest.Close()
+	local.Close()
+	<-done
+}
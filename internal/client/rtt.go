@@ -0,0 +1,178 @@
+package client
+
+import (
+	"encoding/binary"
+	"sync"
+	"time"
+
+	"github.com/sahmadiut/half-tunnel/internal/admin"
+	"github.com/sahmadiut/half-tunnel/internal/protocol"
+)
+
+// Tunnel paths whose round-trip time is measured.
+const (
+	pathUpstream   = "upstream"
+	pathDownstream = "downstream"
+)
+
+// rttProbeSize is the length of a keepalive probe payload: one byte naming
+// the path it was sent on and its send time in Unix nanoseconds. The server
+// echoes the payload in its ack.
+const rttProbeSize = 9
+
+// Probe path identifiers.
+const (
+	probeUpstream   byte = 1
+	probeDownstream byte = 2
+)
+
+// rttProbe returns the payload of a keepalive probe sent on path at t.
+func rttProbe(path byte, t time.Time) []byte {
+	payload := make([]byte, rttProbeSize)
+	payload[0] = path
+	binary.BigEndian.PutUint64(payload[1:], uint64(t.UnixNano()))
+	return payload
+}
+
+// parseRTTProbe decodes an echoed probe payload. Acks from servers that do
+// not echo probes have no payload.
+func parseRTTProbe(payload []byte) (string, time.Time, bool) {
+	if len(payload) != rttProbeSize {
+		return "", time.Time{}, false
+	}
+	sent := time.Unix(0, int64(binary.BigEndian.Uint64(payload[1:])))
+	switch payload[0] {
+	case probeUpstream:
+		return pathUpstream, sent, true
+	case probeDownstream:
+		return pathDownstream, sent, true
+	default:
+		return "", time.Time{}, false
+	}
+}
+
+// rttEstimator tracks the round-trip time of one path, smoothed the way TCP
+// smooths its RTT (RFC 6298, alpha 1/8).
+type rttEstimator struct {
+	mu       sync.Mutex
+	last     time.Duration
+	smoothed time.Duration
+	min      time.Duration
+	samples  int64
+	updated  time.Time
+	// slow is set while the smoothed RTT is above the warning threshold
+	slow bool
+}
+
+// update adds a sample and returns the new smoothed RTT.
+func (e *rttEstimator) update(sample time.Duration, now time.Time) time.Duration {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.samples == 0 {
+		e.smoothed = sample
+		e.min = sample
+	} else {
+		e.smoothed += (sample - e.smoothed) / 8
+		if sample < e.min {
+			e.min = sample
+		}
+	}
+	e.last = sample
+	e.samples++
+	e.updated = now
+	return e.smoothed
+}
+
+// checkThreshold records whether the smoothed RTT is above threshold and
+// reports whether that changed.
+func (e *rttEstimator) checkThreshold(threshold time.Duration) (slow, changed bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	slow = e.smoothed > threshold
+	changed = slow != e.slow
+	e.slow = slow
+	return slow, changed
+}
+
+// snapshot describes the path for the admin API.
+func (e *rttEstimator) snapshot(path string) admin.Path {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return admin.Path{
+		Path:      path,
+		RTT:       e.smoothed,
+		LastRTT:   e.last,
+		MinRTT:    e.min,
+		Samples:   e.samples,
+		UpdatedAt: e.updated,
+	}
+}
+
+// pathRTT returns the estimator for path.
+func (c *Client) pathRTT(path string) *rttEstimator {
+	if path == pathDownstream {
+		return &c.downstreamRTT
+	}
+	return &c.upstreamRTT
+}
+
+// recordRTT takes a sample from an echoed keepalive probe, updating the
+// path's metric and warning when its RTT crosses the configured threshold.
+func (c *Client) recordRTT(payload []byte) {
+	path, sent, ok := parseRTTProbe(payload)
+	if !ok {
+		return
+	}
+	now := time.Now()
+	sample := now.Sub(sent)
+	if sample < 0 {
+		return
+	}
+
+	est := c.pathRTT(path)
+	smoothed := est.update(sample, now)
+	if c.config.Metrics != nil {
+		c.config.Metrics.SetPathRTT(path, smoothed)
+	}
+
+	if c.config.RTTWarnThreshold <= 0 {
+		return
+	}
+	slow, changed := est.checkThreshold(c.config.RTTWarnThreshold)
+	if !changed {
+		return
+	}
+	if slow {
+		c.log.Warn().
+			Str("path", path).
+			Dur("rtt", smoothed).
+			Dur("threshold", c.config.RTTWarnThreshold).
+			Msg("Path round-trip time above threshold")
+	} else {
+		c.log.Info().
+			Str("path", path).
+			Dur("rtt", smoothed).
+			Msg("Path round-trip time back below threshold")
+	}
+}
+
+// sendDownstreamKeepAlive sends a keepalive probe on the downstream
+// connection, which the server answers on the same connection.
+func (c *Client) sendDownstreamKeepAlive() error {
+	pkt, err := protocol.NewPacket(c.session.ID, 0, protocol.FlagKeepAlive, rttProbe(probeDownstream, time.Now()))
+	if err != nil {
+		return err
+	}
+	return c.writeDownstream(pkt)
+}
+
+// pathRTTs returns the round-trip time of both tunnel paths.
+func (c *Client) pathRTTs() []admin.Path {
+	return []admin.Path{
+		c.upstreamRTT.snapshot(pathUpstream),
+		c.downstreamRTT.snapshot(pathDownstream),
+	}
+}
@@ -0,0 +1,452 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sahmadiut/half-tunnel/internal/diag"
+	"github.com/sahmadiut/half-tunnel/internal/protocol"
+	"github.com/sahmadiut/half-tunnel/internal/transport"
+)
+
+// Self-test stages, in the order they run. Each tunnel path is checked from
+// config to websocket; handshake and stream cover the session.
+const (
+	StageConfig    = "config"
+	StageDNS       = "dns"
+	StageTCP       = "tcp"
+	StageTLS       = "tls"
+	StageWebSocket = "websocket"
+	StageHandshake = "handshake"
+	StageStream    = "stream"
+)
+
+// selfTestStreamID is the stream opened by the self-test.
+const selfTestStreamID = 1
+
+// selfTestSettle is how long a stream to a target other than the echo target
+// has to stay up without an error to pass.
+const selfTestSettle = 3 * time.Second
+
+// SelfTestResult is the outcome of one self-test stage.
+type SelfTestResult struct {
+	// Path is the tunnel path checked, empty for session stages
+	Path    string
+	Stage   string
+	Detail  string
+	Elapsed time.Duration
+	Err     error
+}
+
+// SelfTestReport holds the stages run by a self-test, which stops at the
+// first failure.
+type SelfTestReport struct {
+	Results []SelfTestResult
+}
+
+// Err returns the failed stage's error, or nil if every stage passed.
+func (r *SelfTestReport) Err() error {
+	for _, result := range r.Results {
+		if result.Err != nil {
+			return result.Err
+		}
+	}
+	return nil
+}
+
+// run times fn as stage and records its result. It reports whether the stage
+// passed.
+func (r *SelfTestReport) run(path, stage string, fn func() (string, error)) bool {
+	start := time.Now()
+	detail, err := fn()
+	r.Results = append(r.Results, SelfTestResult{
+		Path:    path,
+		Stage:   stage,
+		Detail:  detail,
+		Elapsed: time.Since(start),
+		Err:     err,
+	})
+	return err == nil
+}
+
+// SelfTest checks that a client with config can use the tunnel, one stage at
+// a time so a failure is pinned to the step that caused it: each path's URL
+// is resolved, dialed over TCP and TLS and upgraded to a WebSocket, then a
+// session handshake is made and a stream opened to target. Against
+// diag.EchoAddr the stream must echo data back; other targets pass if the
+// server does not report an error within a few seconds.
+func SelfTest(ctx context.Context, config *Config, target string) *SelfTestReport {
+	c := New(config, nil)
+	report := &SelfTestReport{}
+
+	upstream := c.selfTestPath(ctx, report, pathUpstream)
+	if upstream == nil {
+		return report
+	}
+	defer upstream.Close()
+
+	downstream := c.selfTestPath(ctx, report, pathDownstream)
+	if downstream == nil {
+		return report
+	}
+	defer downstream.Close()
+
+	c.selfTestSession(ctx, report, upstream, downstream, target)
+	return report
+}
+
+// selfTestPath checks the connection of one tunnel path, returning it if
+// every stage passed.
+func (c *Client) selfTestPath(ctx context.Context, report *SelfTestReport, path string) *transport.Connection {
+	var config *transport.Config
+	var u *url.URL
+	if !report.run(path, StageConfig, func() (string, error) {
+		var err error
+		if config, err = c.transportConfig(path); err != nil {
+			return "", err
+		}
+		if u, err = url.Parse(config.URL); err != nil {
+			return "", fmt.Errorf("invalid %s URL: %w", path, err)
+		}
+		if u.Scheme != "ws" && u.Scheme != "wss" {
+			return "", fmt.Errorf("%s URL must use ws:// or wss://, got %q", path, u.Scheme)
+		}
+		return config.URL, nil
+	}) {
+		return nil
+	}
+
+	// Through a proxy the name is resolved and dialed by the proxy, so only
+	// the WebSocket dial can be checked
+	if config.ProxyURL == nil {
+		if !c.selfTestDial(ctx, report, path, config, u) {
+			return nil
+		}
+	}
+
+	var conn *transport.Connection
+	report.run(path, StageWebSocket, func() (string, error) {
+		dialCtx, cancel := c.dialContext(ctx)
+		defer cancel()
+
+		var err error
+		if conn, err = dialTransport(dialCtx, config); err != nil {
+			return "", fmt.Errorf("%s WebSocket upgrade failed: %w", path, err)
+		}
+		if config.ProxyURL != nil {
+			return "via proxy " + config.ProxyURL.Host, nil
+		}
+		return "upgraded " + u.Path, nil
+	})
+	return conn
+}
+
+// selfTestDial runs the DNS, TCP and TLS stages of a path.
+func (c *Client) selfTestDial(ctx context.Context, report *SelfTestReport, path string, config *transport.Config, u *url.URL) bool {
+	host := u.Hostname()
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "wss" {
+			port = "443"
+		}
+	}
+
+	if net.ParseIP(host) == nil && !report.run(path, StageDNS, func() (string, error) {
+		addrs, err := net.DefaultResolver.LookupHost(ctx, host)
+		if err != nil {
+			return "", fmt.Errorf("cannot resolve %s: %w", host, err)
+		}
+		return strings.Join(addrs, ", "), nil
+	}) {
+		return false
+	}
+
+	var conn net.Conn
+	if !report.run(path, StageTCP, func() (string, error) {
+		dialCtx, cancel := c.dialContext(ctx)
+		defer cancel()
+
+		dial := (&net.Dialer{}).DialContext
+		if config.TCP != nil {
+			dial = config.TCP.Dialer(&net.Dialer{})
+		}
+		var err error
+		addr := net.JoinHostPort(host, port)
+		if conn, err = dial(dialCtx, "tcp", addr); err != nil {
+			return "", fmt.Errorf("cannot connect to %s: %w", addr, err)
+		}
+		return "connected to " + conn.RemoteAddr().String(), nil
+	}) {
+		return false
+	}
+	defer conn.Close()
+
+	if u.Scheme != "wss" {
+		return true
+	}
+	return report.run(path, StageTLS, func() (string, error) {
+		dialCtx, cancel := c.dialContext(ctx)
+		defer cancel()
+
+		tlsConfig := &tls.Config{}
+		if config.TLSConfig != nil {
+			tlsConfig = config.TLSConfig.Clone()
+		}
+		if tlsConfig.ServerName == "" {
+			tlsConfig.ServerName = host
+		}
+		tlsConn := tls.Client(conn, tlsConfig)
+		if err := tlsConn.HandshakeContext(dialCtx); err != nil {
+			return "", fmt.Errorf("TLS handshake with %s failed: %w", tlsConfig.ServerName, err)
+		}
+
+		state := tlsConn.ConnectionState()
+		detail := tls.VersionName(state.Version)
+		if len(state.PeerCertificates) > 0 {
+			cert := state.PeerCertificates[0]
+			detail += fmt.Sprintf(", certificate %q valid until %s", cert.Subject.CommonName, cert.NotAfter.Format(time.DateOnly))
+		}
+		return detail, nil
+	})
+}
+
+// selfTestSession makes a session handshake over the connected paths and
+// opens a test stream to target.
+func (c *Client) selfTestSession(ctx context.Context, report *SelfTestReport, upstream, downstream *transport.Connection, target string) {
+	sessionID := uuid.New()
+	packets := make(chan *protocol.Packet, 16)
+	readErr := make(chan error, 1)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			data, err := downstream.Read()
+			if err != nil {
+				readErr <- err
+				return
+			}
+			pkt, err := protocol.Unmarshal(data)
+			if err != nil {
+				continue
+			}
+			select {
+			case packets <- pkt:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	// next returns the next downstream packet
+	next := func(timeout time.Duration) (*protocol.Packet, error) {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		select {
+		case pkt := <-packets:
+			return pkt, nil
+		case err := <-readErr:
+			return nil, fmt.Errorf("server closed the downstream connection: %w", err)
+		case <-timer.C:
+			return nil, errSelfTestTimeout
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	send := func(conn *transport.Connection, streamID uint32, flags protocol.Flag, payload []byte) error {
+		pkt, err := protocol.NewPacket(sessionID, streamID, flags, payload)
+		if err != nil {
+			return err
+		}
+		data, err := pkt.Marshal()
+		if err != nil {
+			return err
+		}
+		return conn.Write(data)
+	}
+
+	timeout := c.config.HandshakeTimeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+
+	if !report.run("", StageHandshake, func() (string, error) {
+		if err := send(upstream, 0, protocol.FlagHandshake, c.handshakePayload()); err != nil {
+			return "", fmt.Errorf("failed to send handshake to upstream: %w", err)
+		}
+		if err := send(downstream, 0, protocol.FlagHandshake, c.handshakePayload()); err != nil {
+			return "", fmt.Errorf("failed to send handshake to downstream: %w", err)
+		}
+
+		// The server answers a probe on each path once it has accepted the
+		// session on both
+		rtts := make(map[string]time.Duration)
+		for _, probe := range []struct {
+			conn *transport.Connection
+			path byte
+		}{{downstream, probeDownstream}, {upstream, probeUpstream}} {
+			if err := send(probe.conn, 0, protocol.FlagKeepAlive, rttProbe(probe.path, time.Now())); err != nil {
+				return "", fmt.Errorf("failed to send keepalive: %w", err)
+			}
+			for {
+				pkt, err := next(timeout)
+				if errors.Is(err, errSelfTestTimeout) {
+					return "", fmt.Errorf("no keepalive reply within %s; the server may have refused the session", timeout)
+				}
+				if err != nil {
+					return "", err
+				}
+				if err := sessionRefusal(pkt); err != nil {
+					return "", err
+				}
+				if !pkt.IsKeepAlive() || !pkt.IsAck() {
+					continue
+				}
+				if path, sent, ok := parseRTTProbe(pkt.Payload); ok {
+					rtts[path] = time.Since(sent)
+					break
+				}
+			}
+		}
+		return fmt.Sprintf("session %s, upstream RTT %s, downstream RTT %s", sessionID,
+			rtts[pathUpstream].Round(time.Millisecond), rtts[pathDownstream].Round(time.Millisecond)), nil
+	}) {
+		return
+	}
+
+	report.run("", StageStream, func() (string, error) {
+		host, port := splitTarget(target)
+		if port == 0 {
+			return "", fmt.Errorf("invalid target %q, expected host:port", target)
+		}
+
+		if err := send(upstream, selfTestStreamID, protocol.FlagData|protocol.FlagHandshake, formatConnectPayload(host, port)); err != nil {
+			return "", fmt.Errorf("failed to open stream: %w", err)
+		}
+		defer func() {
+			_ = send(upstream, selfTestStreamID, protocol.FlagFin, protocol.Fin{Reason: protocol.CloseEOF}.Marshal())
+		}()
+
+		echo := target == diag.EchoAddr
+		msg := make([]byte, 32)
+		if echo {
+			_, _ = rand.Read(msg)
+			if err := send(upstream, selfTestStreamID, protocol.FlagData, msg); err != nil {
+				return "", fmt.Errorf("failed to send stream data: %w", err)
+			}
+		}
+
+		wait := selfTestSettle
+		if echo {
+			wait = timeout
+		}
+		deadline := time.Now().Add(wait)
+		var received []byte
+		for {
+			pkt, err := next(time.Until(deadline))
+			if errors.Is(err, errSelfTestTimeout) {
+				if echo {
+					return "", fmt.Errorf("no echo from %s within %s", target, wait)
+				}
+				return fmt.Sprintf("connected to %s, no error after %s", target, wait), nil
+			}
+			if err != nil {
+				return "", err
+			}
+			if err := sessionRefusal(pkt); err != nil {
+				return "", err
+			}
+			if pkt.IsControlMessage() {
+				if streamErr, ok := parseStreamError(pkt); ok && streamErr.StreamID == selfTestStreamID {
+					return "", streamFailure(target, streamErr)
+				}
+				continue
+			}
+			if pkt.StreamID != selfTestStreamID {
+				continue
+			}
+			if pkt.IsFin() {
+				return "", fmt.Errorf("server closed the stream to %s", target)
+			}
+			if !pkt.IsData() {
+				continue
+			}
+			if !echo {
+				return fmt.Sprintf("connected to %s, data received", target), nil
+			}
+			received = append(received, pkt.Payload...)
+			if len(received) >= len(msg) {
+				if !bytes.Equal(received[:len(msg)], msg) {
+					return "", fmt.Errorf("corrupted echo from %s", target)
+				}
+				return fmt.Sprintf("echoed %d bytes through %s", len(msg), target), nil
+			}
+		}
+	})
+}
+
+// errSelfTestTimeout is returned while waiting for a downstream packet that
+// does not arrive.
+var errSelfTestTimeout = errors.New("timed out")
+
+// sessionRefusal returns an error if pkt reports that the server refused or
+// ended the session.
+func sessionRefusal(pkt *protocol.Packet) error {
+	if !pkt.IsControlMessage() {
+		return nil
+	}
+	ctrl, body, err := protocol.ParseControl(pkt)
+	if err != nil {
+		return nil
+	}
+	limit, err := protocol.ParseSessionLimit(body)
+	if err != nil {
+		return nil
+	}
+	switch ctrl {
+	case protocol.ControlSessionRejected:
+		return fmt.Errorf("session rejected by server: %s limit reached", limit.Reason)
+	case protocol.ControlSessionExpired:
+		return fmt.Errorf("session closed by server: %s limit reached", limit.Reason)
+	}
+	return nil
+}
+
+// parseStreamError decodes a stream error control packet.
+func parseStreamError(pkt *protocol.Packet) (protocol.StreamError, bool) {
+	ctrl, body, err := protocol.ParseControl(pkt)
+	if err != nil || ctrl != protocol.ControlStreamError {
+		return protocol.StreamError{}, false
+	}
+	streamErr, err := protocol.ParseStreamError(body)
+	return streamErr, err == nil
+}
+
+// streamFailure describes a stream error reported by the server.
+func streamFailure(target string, streamErr protocol.StreamError) error {
+	err := fmt.Errorf("server could not connect to %s: %s", target, streamErr.Code)
+	if diag.Lookup(splitTarget(target)) != "" && streamErr.Code == protocol.StreamErrorHostNotFound {
+		return fmt.Errorf("%w (diagnostic targets need tunnel.diagnostics.enabled on the server)", err)
+	}
+	return err
+}
+
+// splitTarget splits a host:port target, returning port 0 if it is invalid.
+func splitTarget(target string) (string, uint16) {
+	host, portStr, err := net.SplitHostPort(target)
+	if err != nil {
+		return "", 0
+	}
+	port, _ := strconv.ParseUint(portStr, 10, 16)
+	return host, uint16(port)
+}
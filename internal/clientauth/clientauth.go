@@ -0,0 +1,108 @@
+// Package clientauth provides client authentication tokens for the Half-Tunnel
+// server.
+//
+// Each client is given a random token that it sends with its session
+// handshake. The server only stores the SHA-256 hash of each token, so a
+// leaked server configuration does not expose the tokens themselves.
+package clientauth
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/sahmadiut/half-tunnel/pkg/crypto"
+)
+
+// TokenPrefix identifies client tokens.
+const TokenPrefix = "htc_"
+
+// tokenSize is the number of random bytes in a token.
+const tokenSize = 32
+
+// Errors
+var (
+	ErrMalformedToken = errors.New("malformed client token")
+	ErrUnknownToken   = errors.New("unknown client token")
+)
+
+// Client is a client identity and the hash of its token.
+type Client struct {
+	// Name identifies the client in logs, metrics and policies.
+	Name string
+	// TokenHash is the hex-encoded SHA-256 hash of the client's token.
+	TokenHash string
+}
+
+// Generate creates a new random client token.
+func Generate() (string, error) {
+	key, err := crypto.GenerateKey(tokenSize)
+	if err != nil {
+		return "", err
+	}
+	return TokenPrefix + base64.RawURLEncoding.EncodeToString(key), nil
+}
+
+// Hash returns the hex-encoded SHA-256 hash of token.
+func Hash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// IsToken reports whether s looks like a client token.
+func IsToken(s string) bool {
+	return strings.HasPrefix(s, TokenPrefix)
+}
+
+// Verifier identifies clients by their tokens.
+type Verifier struct {
+	clients map[[sha256.Size]byte]string
+}
+
+// NewVerifier creates a verifier for clients. Names and token hashes must be
+// unique.
+func NewVerifier(clients []Client) (*Verifier, error) {
+	v := &Verifier{clients: make(map[[sha256.Size]byte]string, len(clients))}
+	names := make(map[string]bool, len(clients))
+	for _, client := range clients {
+		if client.Name == "" {
+			return nil, errors.New("client name is required")
+		}
+		if names[client.Name] {
+			return nil, fmt.Errorf("duplicate client name: %s", client.Name)
+		}
+		names[client.Name] = true
+
+		raw, err := hex.DecodeString(client.TokenHash)
+		if err != nil || len(raw) != sha256.Size {
+			return nil, fmt.Errorf("client %s: invalid token hash", client.Name)
+		}
+		var key [sha256.Size]byte
+		copy(key[:], raw)
+		if _, exists := v.clients[key]; exists {
+			return nil, fmt.Errorf("client %s: token already used by another client", client.Name)
+		}
+		v.clients[key] = client.Name
+	}
+	return v, nil
+}
+
+// Identify returns the name of the client token belongs to.
+func (v *Verifier) Identify(token string) (string, error) {
+	if !IsToken(token) {
+		return "", ErrMalformedToken
+	}
+	name, ok := v.clients[sha256.Sum256([]byte(token))]
+	if !ok {
+		return "", ErrUnknownToken
+	}
+	return name, nil
+}
+
+// Len returns the number of known clients.
+func (v *Verifier) Len() int {
+	return len(v.clients)
+}
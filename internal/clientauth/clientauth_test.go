@@ -0,0 +1,66 @@
+package clientauth
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGenerateAndIdentify(t *testing.T) {
+	token, err := Generate()
+	if err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if !IsToken(token) {
+		t.Errorf("Expected token with prefix %s, got %s", TokenPrefix, token)
+	}
+	other, _ := Generate()
+	if other == token {
+		t.Error("Expected distinct tokens")
+	}
+
+	v, err := NewVerifier([]Client{
+		{Name: "laptop", TokenHash: Hash(token)},
+		{Name: "phone", TokenHash: strings.ToUpper(Hash(other))},
+	})
+	if err != nil {
+		t.Fatalf("NewVerifier failed: %v", err)
+	}
+	if v.Len() != 2 {
+		t.Errorf("Expected 2 clients, got %d", v.Len())
+	}
+
+	tests := []struct {
+		token    string
+		wantName string
+		wantErr  error
+	}{
+		{token, "laptop", nil},
+		{other, "phone", nil},
+		{TokenPrefix + "unknown", "", ErrUnknownToken},
+		{"not-a-token", "", ErrMalformedToken},
+	}
+	for _, tt := range tests {
+		name, err := v.Identify(tt.token)
+		if name != tt.wantName || err != tt.wantErr {
+			t.Errorf("Identify(%q) = %q, %v; want %q, %v", tt.token, name, err, tt.wantName, tt.wantErr)
+		}
+	}
+}
+
+func TestNewVerifierErrors(t *testing.T) {
+	hash := Hash("htc_test")
+	tests := []struct {
+		name    string
+		clients []Client
+	}{
+		{"missing name", []Client{{TokenHash: hash}}},
+		{"duplicate name", []Client{{Name: "a", TokenHash: hash}, {Name: "a", TokenHash: Hash("htc_other")}}},
+		{"duplicate token", []Client{{Name: "a", TokenHash: hash}, {Name: "b", TokenHash: hash}}},
+		{"invalid hash", []Client{{Name: "a", TokenHash: "abc"}}},
+	}
+	for _, tt := range tests {
+		if _, err := NewVerifier(tt.clients); err == nil {
+			t.Errorf("%s: expected an error", tt.name)
+		}
+	}
+}
@@ -2,109 +2,663 @@
 package config
 
 import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
 	"fmt"
+	"net"
+	"net/http"
+	"net/url"
 	"os"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/sahmadiut/half-tunnel/internal/certgen"
+	"github.com/sahmadiut/half-tunnel/internal/clientauth"
+	"github.com/sahmadiut/half-tunnel/internal/guest"
+	"github.com/sahmadiut/half-tunnel/internal/notify"
+	"github.com/sahmadiut/half-tunnel/internal/pathtoken"
+	"github.com/sahmadiut/half-tunnel/internal/protocol"
+	"github.com/sahmadiut/half-tunnel/internal/routing"
+	"github.com/sahmadiut/half-tunnel/internal/sockopt"
+	"github.com/sahmadiut/half-tunnel/internal/transport"
 	"github.com/spf13/viper"
 )
 
 // ClientConfig represents the complete client configuration.
 type ClientConfig struct {
-	Client        ClientSettings     `mapstructure:"client"`
-	PortForwards  []interface{}      `mapstructure:"port_forwards"`
-	SOCKS5        SOCKS5Config       `mapstructure:"socks5"`
-	Tunnel        ClientTunnelConfig `mapstructure:"tunnel"`
-	DNS           DNSConfig          `mapstructure:"dns"`
-	Logging       LoggingConfig      `mapstructure:"logging"`
-	Observability ClientObservConfig `mapstructure:"observability"`
+	Client          ClientSettings     `mapstructure:"client" yaml:"client"`
+	PortForwards    []interface{}      `mapstructure:"port_forwards" yaml:"port_forwards"`
+	SOCKS5          SOCKS5Config       `mapstructure:"socks5" yaml:"socks5"`
+	SOCKS5Listeners []SOCKS5Listener   `mapstructure:"socks5_listeners" yaml:"socks5_listeners"`
+	Routing         RoutingConfig      `mapstructure:"routing" yaml:"routing"`
+	Tunnel          ClientTunnelConfig `mapstructure:"tunnel" yaml:"tunnel"`
+	DNS             DNSConfig          `mapstructure:"dns" yaml:"dns"`
+	Logging         LoggingConfig      `mapstructure:"logging" yaml:"logging"`
+	Observability   ClientObservConfig `mapstructure:"observability" yaml:"observability"`
+	Control         ControlConfig      `mapstructure:"control" yaml:"control"`
+	Hooks           ClientHooksConfig  `mapstructure:"hooks" yaml:"hooks"`
+	Notifications   ClientNotifyConfig `mapstructure:"notifications" yaml:"notifications"`
+}
+
+// ClientNotifyConfig holds the client's notification settings.
+type ClientNotifyConfig struct {
+	NotifyConfig `mapstructure:",squash" yaml:",inline"`
+	// ReconnectFailures is the number of failed reconnect attempts after
+	// which to notify that reconnecting keeps failing
+	ReconnectFailures int `mapstructure:"reconnect_failures" yaml:"reconnect_failures"`
+}
+
+// validate checks the client's notification settings.
+func (n ClientNotifyConfig) validate() error {
+	if err := n.NotifyConfig.validate([]notify.Event{notify.EventReconnectFailing}); err != nil {
+		return err
+	}
+	if n.Enabled && n.ReconnectFailures < 1 {
+		return fmt.Errorf("notifications reconnect_failures must be at least 1")
+	}
+	return nil
+}
+
+// ClientHooksConfig holds shell commands run on tunnel events, e.g. to flush
+// DNS caches, update routes or send notifications. Empty commands are
+// skipped.
+type ClientHooksConfig struct {
+	OnConnect    string `mapstructure:"on_connect" yaml:"on_connect"`
+	OnDisconnect string `mapstructure:"on_disconnect" yaml:"on_disconnect"`
+	OnReconnect  string `mapstructure:"on_reconnect" yaml:"on_reconnect"`
+	OnStall      string `mapstructure:"on_stall" yaml:"on_stall"`
+	// Timeout is how long a command may run before it is killed
+	Timeout time.Duration `mapstructure:"timeout" yaml:"timeout"`
+}
+
+// Enabled reports whether any hook command is set.
+func (h ClientHooksConfig) Enabled() bool {
+	return h.OnConnect != "" || h.OnDisconnect != "" || h.OnReconnect != "" || h.OnStall != ""
 }
 
 // ClientSettings holds client-specific settings.
 type ClientSettings struct {
-	Name            string         `mapstructure:"name"`
-	ExitOnPortInUse bool           `mapstructure:"exit_on_port_in_use"`
-	ListenOnConnect bool           `mapstructure:"listen_on_connect"`
-	Upstream        ClientEndpoint `mapstructure:"upstream"`
-	Downstream      ClientEndpoint `mapstructure:"downstream"`
+	Name            string              `mapstructure:"name" yaml:"name"`
+	ExitOnPortInUse bool                `mapstructure:"exit_on_port_in_use" yaml:"exit_on_port_in_use"`
+	ListenOnConnect bool                `mapstructure:"listen_on_connect" yaml:"listen_on_connect"`
+	GuestToken      string              `mapstructure:"guest_token" yaml:"guest_token"`
+	AuthToken       string              `mapstructure:"auth_token" yaml:"auth_token"`
+	PathToken       PathTokenConfig     `mapstructure:"path_token" yaml:"path_token"`
+	UpgradeCookie   UpgradeCookieConfig `mapstructure:"upgrade_cookie" yaml:"upgrade_cookie"`
+	Upstream        ClientEndpoint      `mapstructure:"upstream" yaml:"upstream"`
+	Downstream      ClientEndpoint      `mapstructure:"downstream" yaml:"downstream"`
 }
 
 // ClientEndpoint defines a client connection endpoint.
 type ClientEndpoint struct {
-	URL string          `mapstructure:"url"`
-	TLS ClientTLSConfig `mapstructure:"tls"`
+	URL string          `mapstructure:"url" yaml:"url"`
+	TLS ClientTLSConfig `mapstructure:"tls" yaml:"tls"`
+	// Host overrides the Host header sent with the handshake (domain fronting)
+	Host string `mapstructure:"host" yaml:"host"`
+	// UserAgent overrides the User-Agent header sent with the handshake
+	UserAgent string `mapstructure:"user_agent" yaml:"user_agent"`
+	// Headers are extra headers sent with the handshake
+	Headers map[string]string `mapstructure:"headers" yaml:"headers"`
+	// ProxyURL is an http:// or socks5:// proxy the WebSocket dial goes through
+	ProxyURL string `mapstructure:"proxy_url" yaml:"proxy_url"`
+}
+
+// validate checks the endpoint's TLS and proxy settings.
+func (e ClientEndpoint) validate() error {
+	if err := e.TLS.validate(); err != nil {
+		return fmt.Errorf("tls: %w", err)
+	}
+	if e.ProxyURL != "" {
+		u, err := url.Parse(e.ProxyURL)
+		if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "socks5") {
+			return fmt.Errorf("proxy_url: %q is not an http:// or socks5:// proxy URL", e.ProxyURL)
+		}
+		if e.TLS.Fingerprint != "" {
+			return fmt.Errorf("proxy_url cannot be combined with a TLS fingerprint")
+		}
+	}
+	return nil
 }
 
 // ClientTLSConfig holds TLS configuration for client connections.
 type ClientTLSConfig struct {
-	Enabled    bool   `mapstructure:"enabled"`
-	SkipVerify bool   `mapstructure:"skip_verify"`
-	CAFile     string `mapstructure:"ca_file"`
+	Enabled    bool   `mapstructure:"enabled" yaml:"enabled"`
+	SkipVerify bool   `mapstructure:"skip_verify" yaml:"skip_verify"`
+	CAFile     string `mapstructure:"ca_file" yaml:"ca_file"`
+	CertFile   string `mapstructure:"cert_file" yaml:"cert_file"`
+	KeyFile    string `mapstructure:"key_file" yaml:"key_file"`
+	// ServerName overrides the SNI sent in the TLS handshake
+	ServerName string `mapstructure:"server_name" yaml:"server_name"`
+	// PinSHA256 lists the accepted base64 SHA-256 hashes of the server
+	// certificate's public key (SPKI). When set they replace CA validation.
+	PinSHA256 []string `mapstructure:"pin_sha256" yaml:"pin_sha256"`
+	// Fingerprint sends the TLS ClientHello of a browser (chrome, firefox
+	// or safari) instead of Go's, which DPI can single out
+	Fingerprint string `mapstructure:"fingerprint" yaml:"fingerprint"`
+}
+
+// validate checks that a client certificate is configured as a complete pair
+// and that TLS-only settings are not set with TLS disabled.
+func (t ClientTLSConfig) validate() error {
+	if (t.CertFile == "") != (t.KeyFile == "") {
+		return fmt.Errorf("cert_file and key_file must be set together")
+	}
+	if t.ServerName != "" && !t.Enabled {
+		return fmt.Errorf("server_name requires TLS to be enabled")
+	}
+	if len(t.PinSHA256) > 0 {
+		if !t.Enabled {
+			return fmt.Errorf("pin_sha256 requires TLS to be enabled")
+		}
+		if t.SkipVerify || t.CAFile != "" {
+			return fmt.Errorf("pin_sha256 replaces CA validation and cannot be combined with skip_verify or ca_file")
+		}
+		for _, pin := range t.PinSHA256 {
+			if hash, err := base64.StdEncoding.DecodeString(pin); err != nil || len(hash) != sha256.Size {
+				return fmt.Errorf("pin_sha256: %q is not a base64-encoded SHA-256 hash", pin)
+			}
+		}
+	}
+	if t.Fingerprint != "" {
+		if !t.Enabled {
+			return fmt.Errorf("fingerprint requires TLS to be enabled")
+		}
+		if err := transport.ValidateFingerprint(t.Fingerprint); err != nil {
+			return fmt.Errorf("fingerprint: %w", err)
+		}
+	}
+	return nil
+}
+
+// DialHeader builds the extra WebSocket handshake headers for the endpoint.
+// Returns nil if none are configured.
+func (e ClientEndpoint) DialHeader() http.Header {
+	header := http.Header{}
+	for name, value := range e.Headers {
+		header.Set(name, value)
+	}
+	if e.UserAgent != "" {
+		header.Set("User-Agent", e.UserAgent)
+	}
+	if e.Host != "" {
+		header.Set("Host", e.Host)
+	}
+	if len(header) == 0 {
+		return nil
+	}
+	return header
 }
 
-// PortForward defines a port forwarding rule with smart defaults.
+// Proxy parses the endpoint's outbound proxy. Returns nil if no proxy is
+// configured.
+func (e ClientEndpoint) Proxy() (*url.URL, error) {
+	if e.ProxyURL == "" {
+		return nil, nil
+	}
+	return url.Parse(e.ProxyURL)
+}
+
+// Load creates the TLS configuration for the endpoint. Returns nil if TLS is
+// disabled. A CA file replaces the system roots used to verify the server,
+// pins replace chain verification with a check of the server certificate's
+// public key, and a certificate/key pair is presented to servers that
+// require client certificates.
+func (t ClientTLSConfig) Load() (*tls.Config, error) {
+	if !t.Enabled {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: t.SkipVerify,
+		ServerName:         t.ServerName,
+	}
+
+	if t.CAFile != "" {
+		caCert, err := os.ReadFile(t.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %w", err)
+		}
+		caCertPool := x509.NewCertPool()
+		if !caCertPool.AppendCertsFromPEM(caCert) {
+			return nil, fmt.Errorf("failed to parse CA certificate")
+		}
+		tlsConfig.RootCAs = caCertPool
+	}
+
+	if len(t.PinSHA256) > 0 {
+		// The pin authenticates the server, so the chain and host name are
+		// not checked
+		tlsConfig.InsecureSkipVerify = true
+		tlsConfig.VerifyPeerCertificate = verifyPins(t.PinSHA256)
+	}
+
+	if t.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+// verifyPins returns a VerifyPeerCertificate callback that accepts the
+// server's leaf certificate only if its public key hash is one of pins.
+func verifyPins(pins []string) func([][]byte, [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return fmt.Errorf("server sent no certificate")
+		}
+		cert, err := x509.ParseCertificate(rawCerts[0])
+		if err != nil {
+			return fmt.Errorf("failed to parse server certificate: %w", err)
+		}
+		hash := certgen.PublicKeyHash(cert)
+		for _, pin := range pins {
+			if hash == pin {
+				return nil
+			}
+		}
+		return fmt.Errorf("server certificate public key %s does not match pin_sha256", hash)
+	}
+}
+
+// PortForward defines a port forwarding rule with smart defaults. IPFamily
+// restricts the listener to "ipv4" or "ipv6" (empty or "dual" listens on
+// both). AllowFrom lists the CIDRs or addresses allowed to connect (empty
+// allows everyone).
 type PortForward struct {
-	Name       string `mapstructure:"name,omitempty" yaml:"name,omitempty"`
-	ListenHost string `mapstructure:"listen_host,omitempty" yaml:"listen_host,omitempty"`
-	ListenPort int    `mapstructure:"listen_port,omitempty" yaml:"listen_port,omitempty"`
-	Port       int    `mapstructure:"port,omitempty" yaml:"port,omitempty"`
-	RemoteHost string `mapstructure:"remote_host,omitempty" yaml:"remote_host,omitempty"`
-	RemotePort int    `mapstructure:"remote_port,omitempty" yaml:"remote_port,omitempty"`
-	Protocol   string `mapstructure:"protocol,omitempty" yaml:"protocol,omitempty"`
+	Name       string   `mapstructure:"name,omitempty" yaml:"name,omitempty"`
+	ListenHost string   `mapstructure:"listen_host,omitempty" yaml:"listen_host,omitempty"`
+	ListenPort int      `mapstructure:"listen_port,omitempty" yaml:"listen_port,omitempty"`
+	Port       int      `mapstructure:"port,omitempty" yaml:"port,omitempty"`
+	RemoteHost string   `mapstructure:"remote_host,omitempty" yaml:"remote_host,omitempty"`
+	RemotePort int      `mapstructure:"remote_port,omitempty" yaml:"remote_port,omitempty"`
+	Protocol   string   `mapstructure:"protocol,omitempty" yaml:"protocol,omitempty"`
+	IPFamily   string   `mapstructure:"ip_family,omitempty" yaml:"ip_family,omitempty"`
+	AllowFrom  []string `mapstructure:"allow_from,omitempty" yaml:"allow_from,omitempty"`
 }
 
-// SOCKS5Config holds SOCKS5 proxy configuration.
+// SOCKS5Config holds SOCKS5 proxy configuration. IPFamily is "dual", "ipv4"
+// or "ipv6". AllowFrom lists the CIDRs or addresses allowed to connect
+// (empty allows everyone).
 type SOCKS5Config struct {
-	Enabled    bool       `mapstructure:"enabled"`
-	ListenHost string     `mapstructure:"listen_host"`
-	ListenPort int        `mapstructure:"listen_port"`
-	Auth       SOCKS5Auth `mapstructure:"auth"`
+	Enabled    bool       `mapstructure:"enabled" yaml:"enabled"`
+	ListenHost string     `mapstructure:"listen_host" yaml:"listen_host"`
+	ListenPort int        `mapstructure:"listen_port" yaml:"listen_port"`
+	IPFamily   string     `mapstructure:"ip_family" yaml:"ip_family"`
+	AllowFrom  []string   `mapstructure:"allow_from" yaml:"allow_from"`
+	Auth       SOCKS5Auth `mapstructure:"auth" yaml:"auth"`
+}
+
+// Addr returns the SOCKS5 listen address.
+func (s SOCKS5Config) Addr() string {
+	return net.JoinHostPort(s.ListenHost, strconv.Itoa(s.ListenPort))
+}
+
+// SOCKS5Listener is an additional SOCKS5 proxy listener, for example one on
+// the LAN with its own credentials next to the main one on localhost. Name
+// labels the streams opened through it in logs and metrics (default
+// "socks5-<port>"); ListenHost defaults to 127.0.0.1.
+type SOCKS5Listener struct {
+	Name       string     `mapstructure:"name" yaml:"name"`
+	ListenHost string     `mapstructure:"listen_host" yaml:"listen_host"`
+	ListenPort int        `mapstructure:"listen_port" yaml:"listen_port"`
+	IPFamily   string     `mapstructure:"ip_family" yaml:"ip_family"`
+	AllowFrom  []string   `mapstructure:"allow_from" yaml:"allow_from"`
+	Auth       SOCKS5Auth `mapstructure:"auth" yaml:"auth"`
+}
+
+// Addr returns the listen address.
+func (s SOCKS5Listener) Addr() string {
+	host := s.ListenHost
+	if host == "" {
+		host = "127.0.0.1"
+	}
+	return net.JoinHostPort(host, strconv.Itoa(s.ListenPort))
+}
+
+// Label returns the listener's name, or "socks5-<port>" when unset.
+func (s SOCKS5Listener) Label() string {
+	if s.Name != "" {
+		return s.Name
+	}
+	return fmt.Sprintf("socks5-%d", s.ListenPort)
+}
+
+// validate checks the listener's port, family, allowlist and credentials.
+func (s SOCKS5Listener) validate() error {
+	if s.ListenPort <= 0 || s.ListenPort > 65535 {
+		return fmt.Errorf("invalid port: %d", s.ListenPort)
+	}
+	if _, err := sockopt.ParseFamily(s.IPFamily); err != nil {
+		return err
+	}
+	if err := validateAllowFrom(s.AllowFrom); err != nil {
+		return err
+	}
+	if s.Auth.Enabled && (s.Auth.Username == "" || s.Auth.Password == "") {
+		return fmt.Errorf("auth requires a username and password")
+	}
+	return nil
+}
+
+// RoutingConfig decides per destination whether SOCKS5 and port-forward
+// connections go through the tunnel ("tunnel"), are dialed directly by the
+// client ("direct") or are refused ("block"). Rules are checked in order and
+// the first match wins; Default applies when none matches. GeoIP is the path
+// of a MaxMind DB (.mmdb) country database for rules with countries. With
+// Resolve, destination names are resolved on the client so that networks and
+// countries match them too.
+type RoutingConfig struct {
+	Default string              `mapstructure:"default" yaml:"default"`
+	GeoIP   string              `mapstructure:"geoip" yaml:"geoip"`
+	Resolve bool                `mapstructure:"resolve" yaml:"resolve"`
+	Rules   []RoutingRuleConfig `mapstructure:"rules" yaml:"rules"`
+}
+
+// RoutingRuleConfig matches destinations like AccessRuleConfig on the
+// server: hosts in Networks (CIDRs) or Domains (including subdomains), in
+// Countries (ISO codes, looked up in the GeoIP database) or listed in one of
+// the Lists files, or any host when all are empty, on Ports, or any port when
+// empty. Networks and countries only match IP destinations unless Resolve is
+// set.
+type RoutingRuleConfig struct {
+	Name      string   `mapstructure:"name" yaml:"name"`
+	Networks  []string `mapstructure:"networks" yaml:"networks"`
+	Domains   []string `mapstructure:"domains" yaml:"domains"`
+	Countries []string `mapstructure:"countries" yaml:"countries"`
+	Lists     []string `mapstructure:"lists" yaml:"lists"`
+	Ports     []int    `mapstructure:"ports" yaml:"ports"`
+	Action    string   `mapstructure:"action" yaml:"action"`
+}
+
+// Files returns the GeoIP database and list files the rules read.
+func (r RoutingConfig) Files() []string {
+	var files []string
+	if r.GeoIP != "" {
+		files = append(files, r.GeoIP)
+	}
+	for _, rule := range r.Rules {
+		files = append(files, rule.Lists...)
+	}
+	return files
+}
+
+// validate checks the default action and every rule.
+func (r RoutingConfig) validate() error {
+	if _, err := routing.ParseAction(r.Default); err != nil {
+		return fmt.Errorf("routing default: %w", err)
+	}
+	for i, rule := range r.Rules {
+		name := rule.Name
+		if name == "" {
+			name = strconv.Itoa(i)
+		}
+		if _, err := routing.ParseAction(rule.Action); err != nil {
+			return fmt.Errorf("routing rule %s: %w", name, err)
+		}
+		for _, network := range rule.Networks {
+			if _, _, err := net.ParseCIDR(network); err != nil {
+				return fmt.Errorf("routing rule %s: invalid network %q: %w", name, network, err)
+			}
+		}
+		for _, port := range rule.Ports {
+			if port <= 0 || port > 65535 {
+				return fmt.Errorf("routing rule %s: invalid port: %d", name, port)
+			}
+		}
+		for _, country := range rule.Countries {
+			if len(country) != 2 {
+				return fmt.Errorf("routing rule %s: invalid country %q (use ISO 3166-1 alpha-2 codes)", name, country)
+			}
+		}
+		if len(rule.Countries) > 0 && r.GeoIP == "" {
+			return fmt.Errorf("routing rule %s: countries require routing.geoip", name)
+		}
+	}
+	return nil
 }
 
 // SOCKS5Auth holds SOCKS5 authentication settings.
 type SOCKS5Auth struct {
-	Enabled  bool   `mapstructure:"enabled"`
-	Username string `mapstructure:"username"`
-	Password string `mapstructure:"password"`
+	Enabled  bool   `mapstructure:"enabled" yaml:"enabled"`
+	Username string `mapstructure:"username" yaml:"username"`
+	Password string `mapstructure:"password" yaml:"password"`
 }
 
 // ClientTunnelConfig holds tunnel settings for the client.
 type ClientTunnelConfig struct {
-	Reconnect  ReconnectConfig        `mapstructure:"reconnect"`
-	Connection ClientConnectionConfig `mapstructure:"connection"`
-	Encryption EncryptionConfig       `mapstructure:"encryption"`
+	Reconnect   ReconnectConfig        `mapstructure:"reconnect" yaml:"reconnect"`
+	Connection  ClientConnectionConfig `mapstructure:"connection" yaml:"connection"`
+	Encryption  EncryptionConfig       `mapstructure:"encryption" yaml:"encryption"`
+	Obfuscation ObfuscationConfig      `mapstructure:"obfuscation" yaml:"obfuscation"`
+	Degradation DegradationConfig      `mapstructure:"degradation" yaml:"degradation"`
+	DataFlow    DataFlowConfig         `mapstructure:"dataflow" yaml:"dataflow"`
+}
+
+// DataFlowConfig holds settings for detecting a tunnel that is connected but
+// no longer passes data.
+type DataFlowConfig struct {
+	// CheckInterval is how often data flow is checked
+	CheckInterval time.Duration `mapstructure:"check_interval" yaml:"check_interval"`
+	// StallThreshold is how long without data before the tunnel counts as
+	// stalled
+	StallThreshold time.Duration `mapstructure:"stall_threshold" yaml:"stall_threshold"`
+	// Action is taken on a stall: log, restart (reconnect) or shutdown (exit
+	// so the service manager restarts the client)
+	Action string `mapstructure:"action" yaml:"action"`
+	// MinBytes is the number of bytes a check interval must move to count
+	// as data flow (0 counts any packet)
+	MinBytes int64 `mapstructure:"min_bytes" yaml:"min_bytes"`
+}
+
+// Stall actions
+const (
+	StallActionLog      = "log"
+	StallActionRestart  = "restart"
+	StallActionShutdown = "shutdown"
+)
+
+// validate checks the data flow settings.
+func (d DataFlowConfig) validate() error {
+	if d.CheckInterval <= 0 {
+		return fmt.Errorf("dataflow check_interval must be positive")
+	}
+	if d.StallThreshold < d.CheckInterval {
+		return fmt.Errorf("dataflow stall_threshold must be at least check_interval")
+	}
+	switch d.Action {
+	case StallActionLog, StallActionRestart, StallActionShutdown:
+	default:
+		return fmt.Errorf("invalid dataflow action: %s (use log, restart or shutdown)", d.Action)
+	}
+	if d.MinBytes < 0 {
+		return fmt.Errorf("invalid dataflow min_bytes: %d", d.MinBytes)
+	}
+	return nil
+}
+
+// DegradationConfig holds settings for keeping streams open across
+// reconnects. While the client reconnects, stream data is queued and sent
+// once the session is resumed.
+type DegradationConfig struct {
+	Enabled bool `mapstructure:"enabled" yaml:"enabled"`
+	// QueueSize is the number of packets queued while reconnecting; when it
+	// is full the oldest packet is dropped and its stream reset
+	QueueSize int `mapstructure:"queue_size" yaml:"queue_size"`
+	// QueueTimeout drops queued packets older than this when they are sent
+	QueueTimeout time.Duration `mapstructure:"queue_timeout" yaml:"queue_timeout"`
+	// RecoveryTimeout is how long to try resuming the session before its
+	// streams are closed and a new session is started
+	RecoveryTimeout time.Duration `mapstructure:"recovery_timeout" yaml:"recovery_timeout"`
+}
+
+// validate checks the degradation settings.
+func (d DegradationConfig) validate() error {
+	if !d.Enabled {
+		return nil
+	}
+	if d.QueueSize <= 0 {
+		return fmt.Errorf("invalid degradation queue_size: %d", d.QueueSize)
+	}
+	if d.QueueTimeout <= 0 {
+		return fmt.Errorf("invalid degradation queue_timeout: %v", d.QueueTimeout)
+	}
+	if d.RecoveryTimeout <= 0 {
+		return fmt.Errorf("invalid degradation recovery_timeout: %v", d.RecoveryTimeout)
+	}
+	return nil
+}
+
+// ObfuscationConfig shapes upstream traffic to blunt traffic analysis of
+// packet sizes and timing.
+type ObfuscationConfig struct {
+	// MaxPadding pads each data packet with up to this many random bytes
+	// if the server supports padding (0 disables padding)
+	MaxPadding   int                `mapstructure:"max_padding" yaml:"max_padding"`
+	CoverTraffic CoverTrafficConfig `mapstructure:"cover_traffic" yaml:"cover_traffic"`
+}
+
+// CoverTrafficConfig holds settings for dummy packets sent upstream at
+// random intervals, which the server discards.
+type CoverTrafficConfig struct {
+	Enabled bool `mapstructure:"enabled" yaml:"enabled"`
+	// Interval is the average time between dummy packets
+	Interval time.Duration `mapstructure:"interval" yaml:"interval"`
+	// Jitter randomizes each interval by up to this fraction (0.0 to 1.0)
+	Jitter float64 `mapstructure:"jitter" yaml:"jitter"`
+	// MinSize and MaxSize bound the random size of a dummy packet
+	MinSize int `mapstructure:"min_size" yaml:"min_size"`
+	MaxSize int `mapstructure:"max_size" yaml:"max_size"`
+}
+
+// validate checks the obfuscation settings.
+func (o ObfuscationConfig) validate() error {
+	if o.MaxPadding < 0 || o.MaxPadding > protocol.MaxPadding {
+		return fmt.Errorf("invalid obfuscation max_padding: %d (must be 0 to %d)", o.MaxPadding, protocol.MaxPadding)
+	}
+	ct := o.CoverTraffic
+	if !ct.Enabled {
+		return nil
+	}
+	if ct.Interval <= 0 {
+		return fmt.Errorf("invalid cover_traffic interval: %v", ct.Interval)
+	}
+	if ct.Jitter < 0 || ct.Jitter > 1 {
+		return fmt.Errorf("invalid cover_traffic jitter: %v (must be 0.0 to 1.0)", ct.Jitter)
+	}
+	// The body of a dummy packet follows a 1-byte control type
+	if ct.MinSize < 0 || ct.MaxSize < ct.MinSize || ct.MaxSize > protocol.MaxPayloadSize-1 {
+		return fmt.Errorf("invalid cover_traffic sizes: %d to %d (must be 0 to %d, min_size <= max_size)", ct.MinSize, ct.MaxSize, protocol.MaxPayloadSize-1)
+	}
+	return nil
 }
 
 // ReconnectConfig holds reconnection strategy settings.
 type ReconnectConfig struct {
-	Enabled      bool          `mapstructure:"enabled"`
-	InitialDelay time.Duration `mapstructure:"initial_delay"`
-	MaxDelay     time.Duration `mapstructure:"max_delay"`
-	Multiplier   float64       `mapstructure:"multiplier"`
-	Jitter       float64       `mapstructure:"jitter"`
+	Enabled      bool          `mapstructure:"enabled" yaml:"enabled"`
+	InitialDelay time.Duration `mapstructure:"initial_delay" yaml:"initial_delay"`
+	MaxDelay     time.Duration `mapstructure:"max_delay" yaml:"max_delay"`
+	Multiplier   float64       `mapstructure:"multiplier" yaml:"multiplier"`
+	Jitter       float64       `mapstructure:"jitter" yaml:"jitter"`
+	// CircuitBreaker pauses reconnects for its timeout after max_failures
+	// consecutive failed attempts
+	CircuitBreaker CircuitBreakerConfig `mapstructure:"circuit_breaker" yaml:"circuit_breaker"`
+	// MaxAttempts and MaxElapsedTime give up reconnecting after that many
+	// failed attempts or that long (0 means no limit); the client then runs
+	// GiveUpScript, or exits with status 75 without one
+	MaxAttempts    int           `mapstructure:"max_attempts" yaml:"max_attempts"`
+	MaxElapsedTime time.Duration `mapstructure:"max_elapsed_time" yaml:"max_elapsed_time"`
+	GiveUpScript   string        `mapstructure:"give_up_script" yaml:"give_up_script"`
+}
+
+// validate checks the reconnect limits.
+func (r ReconnectConfig) validate() error {
+	if r.MaxAttempts < 0 || r.MaxElapsedTime < 0 {
+		return fmt.Errorf("reconnect max_attempts and max_elapsed_time must not be negative")
+	}
+	if r.GiveUpScript != "" && r.MaxAttempts == 0 && r.MaxElapsedTime == 0 {
+		return fmt.Errorf("reconnect give_up_script requires max_attempts or max_elapsed_time")
+	}
+	return nil
 }
 
 // ClientConnectionConfig holds connection settings for client.
 type ClientConnectionConfig struct {
-	ReadBufferSize    int           `mapstructure:"read_buffer_size"`
-	WriteBufferSize   int           `mapstructure:"write_buffer_size"`
-	KeepaliveInterval time.Duration `mapstructure:"keepalive_interval"`
-	DialTimeout       time.Duration `mapstructure:"dial_timeout"`
+	ReadBufferSize    int           `mapstructure:"read_buffer_size" yaml:"read_buffer_size"`
+	WriteBufferSize   int           `mapstructure:"write_buffer_size" yaml:"write_buffer_size"`
+	KeepaliveInterval time.Duration `mapstructure:"keepalive_interval" yaml:"keepalive_interval"`
+	DialTimeout       time.Duration `mapstructure:"dial_timeout" yaml:"dial_timeout"`
+	TCP               TCPConfig     `mapstructure:"tcp" yaml:"tcp"`
+	// StreamOpenTimeout is how long a new stream waits for the server to
+	// connect its destination, if the server confirms stream opens; SOCKS5
+	// clients get their reply once it did (0 replies at once)
+	StreamOpenTimeout time.Duration `mapstructure:"stream_open_timeout" yaml:"stream_open_timeout"`
+	// RTTWarnThreshold logs a warning when the round-trip time of either
+	// path, measured with keepalives, rises above it (0 disables it)
+	RTTWarnThreshold time.Duration `mapstructure:"rtt_warn_threshold" yaml:"rtt_warn_threshold"`
+	// CompactHeader offers the server compact packet headers, which carry a
+	// 4-byte session index instead of the 16-byte session UUID
+	CompactHeader bool `mapstructure:"compact_header" yaml:"compact_header"`
+	// MaxPayloadSize is the largest packet payload offered to the server; 0
+	// offers 65535 bytes. Up to 1 MiB (jumbo frames) with compact headers
+	MaxPayloadSize int `mapstructure:"max_payload_size" yaml:"max_payload_size"`
+	// Checksum offers the server a checksum trailer on every packet, which
+	// detects packets corrupted on the wire
+	Checksum bool `mapstructure:"checksum" yaml:"checksum"`
+	// CorruptPacketPolicy decides what happens to the stream of a packet
+	// with a checksum mismatch: "reset" closes it, "drop" only drops the
+	// packet
+	CorruptPacketPolicy string `mapstructure:"corrupt_packet_policy" yaml:"corrupt_packet_policy"`
+	// SendQueueSize is the number of packets queued per tunnel connection
+	// for its single writer, which writes bursts back to back; senders wait
+	// while it is full (0 writes directly)
+	SendQueueSize int `mapstructure:"send_queue_size" yaml:"send_queue_size"`
+}
+
+// Corrupt packet policies
+const (
+	CorruptPacketReset = "reset"
+	CorruptPacketDrop  = "drop"
+)
+
+// validateCorruptPacketPolicy checks a corrupt_packet_policy value.
+func validateCorruptPacketPolicy(policy string) error {
+	switch policy {
+	case CorruptPacketReset, CorruptPacketDrop:
+		return nil
+	default:
+		return fmt.Errorf("invalid corrupt_packet_policy: %s (use reset or drop)", policy)
+	}
 }
 
 // DNSConfig holds DNS settings for VPN mode.
 type DNSConfig struct {
-	Enabled         bool     `mapstructure:"enabled"`
-	ListenHost      string   `mapstructure:"listen_host"`
-	ListenPort      int      `mapstructure:"listen_port"`
-	UpstreamServers []string `mapstructure:"upstream_servers"`
+	Enabled         bool     `mapstructure:"enabled" yaml:"enabled"`
+	ListenHost      string   `mapstructure:"listen_host" yaml:"listen_host"`
+	ListenPort      int      `mapstructure:"listen_port" yaml:"listen_port"`
+	UpstreamServers []string `mapstructure:"upstream_servers" yaml:"upstream_servers"`
 }
 
 // ClientObservConfig holds client observability configuration.
 type ClientObservConfig struct {
-	Metrics MetricsConfig `mapstructure:"metrics"`
+	Metrics MetricsConfig `mapstructure:"metrics" yaml:"metrics"`
+	Health  HealthConfig  `mapstructure:"health" yaml:"health"`
+	Admin   AdminConfig   `mapstructure:"admin" yaml:"admin"`
+	Debug   DebugConfig   `mapstructure:"debug" yaml:"debug"`
+	Usage   UsageConfig   `mapstructure:"usage" yaml:"usage"`
+}
+
+// UsageConfig controls persistent traffic accounting on the client.
+type UsageConfig struct {
+	Enabled       bool          `mapstructure:"enabled" yaml:"enabled"`
+	StateFile     string        `mapstructure:"state_file" yaml:"state_file"`
+	FlushInterval time.Duration `mapstructure:"flush_interval" yaml:"flush_interval"`
 }
 
 // DefaultClientConfig returns a ClientConfig with sensible defaults.
@@ -114,6 +668,13 @@ func DefaultClientConfig() *ClientConfig {
 			Name:            "entry-client-01",
 			ExitOnPortInUse: false,
 			ListenOnConnect: false,
+			PathToken: PathTokenConfig{
+				Window: 5 * time.Minute,
+			},
+			UpgradeCookie: UpgradeCookieConfig{
+				Window: 5 * time.Minute,
+				Name:   pathtoken.DefaultCookie,
+			},
 			Upstream: ClientEndpoint{
 				URL: "wss://domain-a.example.com:8443/ws/upstream",
 				TLS: ClientTLSConfig{
@@ -136,12 +697,16 @@ func DefaultClientConfig() *ClientConfig {
 			Enabled:    true,
 			ListenHost: "127.0.0.1",
 			ListenPort: 1080,
+			IPFamily:   "dual",
 			Auth: SOCKS5Auth{
 				Enabled:  false,
 				Username: "",
 				Password: "",
 			},
 		},
+		Routing: RoutingConfig{
+			Default: "tunnel",
+		},
 		Tunnel: ClientTunnelConfig{
 			Reconnect: ReconnectConfig{
 				Enabled:      true,
@@ -149,17 +714,49 @@ func DefaultClientConfig() *ClientConfig {
 				MaxDelay:     60 * time.Second,
 				Multiplier:   2.0,
 				Jitter:       0.1,
+				CircuitBreaker: CircuitBreakerConfig{
+					Enabled:          true,
+					MaxFailures:      10,
+					Timeout:          5 * time.Minute,
+					HalfOpenRequests: 1,
+				},
 			},
 			Connection: ClientConnectionConfig{
-				ReadBufferSize:    32768,
-				WriteBufferSize:   32768,
-				KeepaliveInterval: 30 * time.Second,
-				DialTimeout:       10 * time.Second,
+				ReadBufferSize:      32768,
+				WriteBufferSize:     32768,
+				KeepaliveInterval:   30 * time.Second,
+				DialTimeout:         10 * time.Second,
+				TCP:                 DefaultTCPConfig(),
+				StreamOpenTimeout:   30 * time.Second,
+				CompactHeader:       true,
+				CorruptPacketPolicy: CorruptPacketReset,
+				SendQueueSize:       256,
 			},
 			Encryption: EncryptionConfig{
 				Enabled:   true,
 				Algorithm: "aes-256-gcm",
 			},
+			Obfuscation: ObfuscationConfig{
+				CoverTraffic: CoverTrafficConfig{
+					Enabled:  false,
+					Interval: 15 * time.Second,
+					Jitter:   0.5,
+					MinSize:  32,
+					MaxSize:  512,
+				},
+			},
+			Degradation: DegradationConfig{
+				Enabled:         false,
+				QueueSize:       1000,
+				QueueTimeout:    30 * time.Second,
+				RecoveryTimeout: 5 * time.Minute,
+			},
+			DataFlow: DataFlowConfig{
+				CheckInterval:  30 * time.Second,
+				StallThreshold: 2 * time.Minute,
+				Action:         StallActionLog,
+				MinBytes:       0,
+			},
 		},
 		DNS: DNSConfig{
 			Enabled:         false,
@@ -177,7 +774,42 @@ func DefaultClientConfig() *ClientConfig {
 				Enabled: true,
 				Port:    9091,
 				Path:    "/metrics",
+				StreamLabels: StreamLabelsConfig{
+					MaxDestHosts: 100,
+				},
+			},
+			Health: HealthConfig{
+				Enabled: false,
+				Port:    8082,
+				Path:    "/healthz",
 			},
+			Admin: AdminConfig{
+				Enabled: false,
+				Listen:  "127.0.0.1",
+				Port:    7071,
+				Path:    "/api",
+			},
+			Debug: DebugConfig{
+				Enabled: false,
+				Listen:  "127.0.0.1",
+				Port:    6061,
+			},
+			Usage: UsageConfig{
+				Enabled:       false,
+				StateFile:     "/var/lib/half-tunnel/client-usage.json",
+				FlushInterval: time.Minute,
+			},
+		},
+		Control: ControlConfig{
+			Enabled: true,
+			Socket:  "/run/half-tunnel/client.sock",
+		},
+		Hooks: ClientHooksConfig{
+			Timeout: 30 * time.Second,
+		},
+		Notifications: ClientNotifyConfig{
+			NotifyConfig:      DefaultNotifyConfig(),
+			ReconnectFailures: 5,
 		},
 	}
 }
@@ -192,9 +824,10 @@ func LoadClientConfig(configPath string) (*ClientConfig, error) {
 	// Set config file
 	if configPath != "" {
 		v.SetConfigFile(configPath)
+		v.SetConfigType(FormatFromPath(configPath))
 	} else {
+		// Finds client.json, client.toml, client.yaml or client.yml
 		v.SetConfigName("client")
-		v.SetConfigType("yaml")
 		v.AddConfigPath(".")
 		v.AddConfigPath("./configs")
 		v.AddConfigPath("/etc/half-tunnel/")
@@ -215,8 +848,8 @@ func LoadClientConfig(configPath string) (*ClientConfig, error) {
 	}
 
 	var cfg ClientConfig
-	if err := v.Unmarshal(&cfg); err != nil {
-		return nil, fmt.Errorf("error unmarshaling config: %w", err)
+	if err := unmarshalResolved(v, &cfg); err != nil {
+		return nil, err
 	}
 
 	return &cfg, nil
@@ -237,6 +870,9 @@ func setClientDefaults(v *viper.Viper) {
 	v.SetDefault("client.name", defaults.Client.Name)
 	v.SetDefault("client.exit_on_port_in_use", defaults.Client.ExitOnPortInUse)
 	v.SetDefault("client.listen_on_connect", defaults.Client.ListenOnConnect)
+	v.SetDefault("client.path_token.window", defaults.Client.PathToken.Window)
+	v.SetDefault("client.upgrade_cookie.window", defaults.Client.UpgradeCookie.Window)
+	v.SetDefault("client.upgrade_cookie.name", defaults.Client.UpgradeCookie.Name)
 	v.SetDefault("client.upstream.url", defaults.Client.Upstream.URL)
 	v.SetDefault("client.upstream.tls.enabled", defaults.Client.Upstream.TLS.Enabled)
 	v.SetDefault("client.upstream.tls.skip_verify", defaults.Client.Upstream.TLS.SkipVerify)
@@ -247,19 +883,52 @@ func setClientDefaults(v *viper.Viper) {
 	v.SetDefault("socks5.enabled", defaults.SOCKS5.Enabled)
 	v.SetDefault("socks5.listen_host", defaults.SOCKS5.ListenHost)
 	v.SetDefault("socks5.listen_port", defaults.SOCKS5.ListenPort)
+	v.SetDefault("socks5.ip_family", defaults.SOCKS5.IPFamily)
+	v.SetDefault("socks5.allow_from", defaults.SOCKS5.AllowFrom)
 	v.SetDefault("socks5.auth.enabled", defaults.SOCKS5.Auth.Enabled)
 
+	v.SetDefault("routing.default", defaults.Routing.Default)
+
 	v.SetDefault("tunnel.reconnect.enabled", defaults.Tunnel.Reconnect.Enabled)
 	v.SetDefault("tunnel.reconnect.initial_delay", defaults.Tunnel.Reconnect.InitialDelay)
 	v.SetDefault("tunnel.reconnect.max_delay", defaults.Tunnel.Reconnect.MaxDelay)
 	v.SetDefault("tunnel.reconnect.multiplier", defaults.Tunnel.Reconnect.Multiplier)
 	v.SetDefault("tunnel.reconnect.jitter", defaults.Tunnel.Reconnect.Jitter)
+	v.SetDefault("tunnel.reconnect.circuit_breaker.enabled", defaults.Tunnel.Reconnect.CircuitBreaker.Enabled)
+	v.SetDefault("tunnel.reconnect.circuit_breaker.max_failures", defaults.Tunnel.Reconnect.CircuitBreaker.MaxFailures)
+	v.SetDefault("tunnel.reconnect.circuit_breaker.timeout", defaults.Tunnel.Reconnect.CircuitBreaker.Timeout)
+	v.SetDefault("tunnel.reconnect.circuit_breaker.half_open_requests", defaults.Tunnel.Reconnect.CircuitBreaker.HalfOpenRequests)
+	v.SetDefault("tunnel.reconnect.max_attempts", defaults.Tunnel.Reconnect.MaxAttempts)
+	v.SetDefault("tunnel.reconnect.max_elapsed_time", defaults.Tunnel.Reconnect.MaxElapsedTime)
+	v.SetDefault("tunnel.reconnect.give_up_script", defaults.Tunnel.Reconnect.GiveUpScript)
 	v.SetDefault("tunnel.connection.read_buffer_size", defaults.Tunnel.Connection.ReadBufferSize)
 	v.SetDefault("tunnel.connection.write_buffer_size", defaults.Tunnel.Connection.WriteBufferSize)
 	v.SetDefault("tunnel.connection.keepalive_interval", defaults.Tunnel.Connection.KeepaliveInterval)
+	v.SetDefault("tunnel.connection.rtt_warn_threshold", defaults.Tunnel.Connection.RTTWarnThreshold)
 	v.SetDefault("tunnel.connection.dial_timeout", defaults.Tunnel.Connection.DialTimeout)
+	v.SetDefault("tunnel.connection.stream_open_timeout", defaults.Tunnel.Connection.StreamOpenTimeout)
+	v.SetDefault("tunnel.connection.compact_header", defaults.Tunnel.Connection.CompactHeader)
+	v.SetDefault("tunnel.connection.max_payload_size", defaults.Tunnel.Connection.MaxPayloadSize)
+	v.SetDefault("tunnel.connection.checksum", defaults.Tunnel.Connection.Checksum)
+	v.SetDefault("tunnel.connection.corrupt_packet_policy", defaults.Tunnel.Connection.CorruptPacketPolicy)
+	v.SetDefault("tunnel.connection.send_queue_size", defaults.Tunnel.Connection.SendQueueSize)
+	setTCPDefaults(v, "tunnel.connection.tcp")
 	v.SetDefault("tunnel.encryption.enabled", defaults.Tunnel.Encryption.Enabled)
 	v.SetDefault("tunnel.encryption.algorithm", defaults.Tunnel.Encryption.Algorithm)
+	v.SetDefault("tunnel.obfuscation.max_padding", defaults.Tunnel.Obfuscation.MaxPadding)
+	v.SetDefault("tunnel.obfuscation.cover_traffic.enabled", defaults.Tunnel.Obfuscation.CoverTraffic.Enabled)
+	v.SetDefault("tunnel.obfuscation.cover_traffic.interval", defaults.Tunnel.Obfuscation.CoverTraffic.Interval)
+	v.SetDefault("tunnel.obfuscation.cover_traffic.jitter", defaults.Tunnel.Obfuscation.CoverTraffic.Jitter)
+	v.SetDefault("tunnel.obfuscation.cover_traffic.min_size", defaults.Tunnel.Obfuscation.CoverTraffic.MinSize)
+	v.SetDefault("tunnel.obfuscation.cover_traffic.max_size", defaults.Tunnel.Obfuscation.CoverTraffic.MaxSize)
+	v.SetDefault("tunnel.degradation.enabled", defaults.Tunnel.Degradation.Enabled)
+	v.SetDefault("tunnel.degradation.queue_size", defaults.Tunnel.Degradation.QueueSize)
+	v.SetDefault("tunnel.degradation.queue_timeout", defaults.Tunnel.Degradation.QueueTimeout)
+	v.SetDefault("tunnel.degradation.recovery_timeout", defaults.Tunnel.Degradation.RecoveryTimeout)
+	v.SetDefault("tunnel.dataflow.check_interval", defaults.Tunnel.DataFlow.CheckInterval)
+	v.SetDefault("tunnel.dataflow.stall_threshold", defaults.Tunnel.DataFlow.StallThreshold)
+	v.SetDefault("tunnel.dataflow.action", defaults.Tunnel.DataFlow.Action)
+	v.SetDefault("tunnel.dataflow.min_bytes", defaults.Tunnel.DataFlow.MinBytes)
 
 	v.SetDefault("dns.enabled", defaults.DNS.Enabled)
 	v.SetDefault("dns.listen_host", defaults.DNS.ListenHost)
@@ -273,6 +942,31 @@ func setClientDefaults(v *viper.Viper) {
 	v.SetDefault("observability.metrics.enabled", defaults.Observability.Metrics.Enabled)
 	v.SetDefault("observability.metrics.port", defaults.Observability.Metrics.Port)
 	v.SetDefault("observability.metrics.path", defaults.Observability.Metrics.Path)
+	v.SetDefault("observability.metrics.stream_labels.max_dest_hosts", defaults.Observability.Metrics.StreamLabels.MaxDestHosts)
+	v.SetDefault("observability.metrics.stream_labels.hash_dest_hosts", defaults.Observability.Metrics.StreamLabels.HashDestHosts)
+	v.SetDefault("observability.health.enabled", defaults.Observability.Health.Enabled)
+	v.SetDefault("observability.health.port", defaults.Observability.Health.Port)
+	v.SetDefault("observability.health.path", defaults.Observability.Health.Path)
+	v.SetDefault("observability.health.echo_probe", defaults.Observability.Health.EchoProbe)
+	v.SetDefault("observability.admin.enabled", defaults.Observability.Admin.Enabled)
+	v.SetDefault("observability.admin.listen", defaults.Observability.Admin.Listen)
+	v.SetDefault("observability.admin.port", defaults.Observability.Admin.Port)
+	v.SetDefault("observability.admin.path", defaults.Observability.Admin.Path)
+	v.SetDefault("observability.debug.enabled", defaults.Observability.Debug.Enabled)
+	v.SetDefault("observability.debug.listen", defaults.Observability.Debug.Listen)
+	v.SetDefault("observability.debug.port", defaults.Observability.Debug.Port)
+	v.SetDefault("control.enabled", defaults.Control.Enabled)
+	v.SetDefault("control.socket", defaults.Control.Socket)
+	v.SetDefault("hooks.on_connect", defaults.Hooks.OnConnect)
+	v.SetDefault("hooks.on_disconnect", defaults.Hooks.OnDisconnect)
+	v.SetDefault("hooks.on_reconnect", defaults.Hooks.OnReconnect)
+	v.SetDefault("hooks.on_stall", defaults.Hooks.OnStall)
+	v.SetDefault("hooks.timeout", defaults.Hooks.Timeout)
+	setNotifyDefaults(v, "notifications")
+	v.SetDefault("notifications.reconnect_failures", defaults.Notifications.ReconnectFailures)
+	v.SetDefault("observability.usage.enabled", defaults.Observability.Usage.Enabled)
+	v.SetDefault("observability.usage.state_file", defaults.Observability.Usage.StateFile)
+	v.SetDefault("observability.usage.flush_interval", defaults.Observability.Usage.FlushInterval)
 }
 
 // GetPortForwards parses the flexible port_forwards configuration and returns normalized PortForward entries.
@@ -283,7 +977,7 @@ func (c *ClientConfig) GetPortForwards() ([]PortForward, error) {
 // ParsePortForwards handles flexible YAML input formats for port forwarding.
 // Supports:
 // - int: just port number (e.g., 2083)
-// - string: port specification (e.g., "8080", "8080:80", "8080:example.com:80", "1000-1200")
+// - string: port specification (e.g., "8080", "8080:80", "127.0.0.1:8080:example.com:80", "5353/udp", "1000-1200")
 // - map: full object with optional fields
 func ParsePortForwards(raw []interface{}) ([]PortForward, error) {
 	var result []PortForward
@@ -310,18 +1004,10 @@ func ParsePortForwards(raw []interface{}) ([]PortForward, error) {
 // parsePortForwardEntry parses a single port forward entry.
 func parsePortForwardEntry(entry interface{}) (*PortForward, error) {
 	switch v := entry.(type) {
-	case int:
-		// Simple format: just port number
-		return &PortForward{
-			ListenHost: "0.0.0.0",
-			ListenPort: v,
-			RemoteHost: "127.0.0.1",
-			RemotePort: v,
-			Protocol:   "tcp",
-		}, nil
-	case float64:
-		// YAML sometimes parses numbers as float64
-		port := int(v)
+	case int, int64, float64:
+		// Simple format: just port number. YAML and JSON sometimes parse
+		// numbers as float64, TOML as int64.
+		port, _ := toInt(v)
 		return &PortForward{
 			ListenHost: "0.0.0.0",
 			ListenPort: port,
@@ -375,33 +1061,89 @@ func parsePortRange(spec string) (startPort, endPort int, err error) {
 	return startPort, endPort, nil
 }
 
+// splitPortForwardSpec splits a port forward string on colons outside
+// brackets, so IPv6 literals can be written as "[2001:db8::1]". Brackets
+// are removed from the returned parts.
+func splitPortForwardSpec(spec string) ([]string, error) {
+	var parts []string
+	start := 0
+	depth := 0
+	for i, r := range spec {
+		switch r {
+		case '[':
+			depth++
+		case ']':
+			depth--
+			if depth < 0 {
+				return nil, fmt.Errorf("unbalanced brackets in %s", spec)
+			}
+		case ':':
+			if depth == 0 {
+				parts = append(parts, spec[start:i])
+				start = i + 1
+			}
+		}
+	}
+	if depth != 0 {
+		return nil, fmt.Errorf("unbalanced brackets in %s", spec)
+	}
+	parts = append(parts, spec[start:])
+
+	for i, part := range parts {
+		if strings.HasPrefix(part, "[") && strings.HasSuffix(part, "]") {
+			parts[i] = part[1 : len(part)-1]
+		} else if strings.ContainsAny(part, "[]") {
+			return nil, fmt.Errorf("invalid brackets in %s", spec)
+		}
+	}
+	return parts, nil
+}
+
+// splitProtocolSuffix removes a "/tcp" or "/udp" suffix from a port forward
+// string and returns the protocol ("tcp" without a suffix).
+func splitProtocolSuffix(spec string) (string, string, error) {
+	i := strings.LastIndex(spec, "/")
+	if i < 0 {
+		return spec, "tcp", nil
+	}
+	protocol := strings.ToLower(spec[i+1:])
+	if protocol != "tcp" && protocol != "udp" {
+		return "", "", fmt.Errorf("invalid protocol: %s (use tcp or udp)", spec[i+1:])
+	}
+	return spec[:i], protocol, nil
+}
+
 // ParsePortForwardString parses flexible port forward string formats:
 // - "2083" → listen:2083, remote:2083
 // - "8080:80" → listen:8080, remote:80
 // - "8080:example.com:80" → listen:8080, remote:example.com:80
+// - "8080:[2001:db8::1]:80" → listen:8080, remote:[2001:db8::1]:80
+// - "127.0.0.1:8080:example.com:80" → listen:127.0.0.1:8080, remote:example.com:80
 // - "1000-1200" → port range (returns first port, use ParsePortForwardStringRange for all)
+// Any format may end in "/tcp" (the default) or "/udp", e.g. "5353/udp".
 func ParsePortForwardString(spec string) (*PortForward, error) {
 	// Check for port range format (e.g., "1000-1200")
 	if isPortRange(spec) {
-		startPort, _, err := parsePortRange(spec)
+		forwards, err := ParsePortForwardStringRange(spec)
 		if err != nil {
 			return nil, err
 		}
 		// Return the first port of the range (use ParsePortForwardStringRange for all ports)
-		return &PortForward{
-			ListenHost: "0.0.0.0",
-			ListenPort: startPort,
-			RemoteHost: "127.0.0.1",
-			RemotePort: startPort,
-			Protocol:   "tcp",
-		}, nil
+		return &forwards[0], nil
 	}
 
-	parts := strings.Split(spec, ":")
+	rest, protocol, err := splitProtocolSuffix(spec)
+	if err != nil {
+		return nil, err
+	}
+	parts, err := splitPortForwardSpec(rest)
+	if err != nil {
+		return nil, err
+	}
 	pf := &PortForward{
 		ListenHost: "0.0.0.0",
 		RemoteHost: "127.0.0.1",
-		Protocol:   "tcp",
+		Protocol:   protocol,
 	}
 
 	switch len(parts) {
@@ -438,6 +1180,23 @@ func ParsePortForwardString(spec string) (*PortForward, error) {
 		pf.ListenPort = listenPort
 		pf.RemoteHost = parts[1]
 		pf.RemotePort = remotePort
+	case 4:
+		// listen_host:listen:host:remote
+		listenPort, err := strconv.Atoi(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid listen port: %s", parts[1])
+		}
+		remotePort, err := strconv.Atoi(parts[3])
+		if err != nil {
+			return nil, fmt.Errorf("invalid remote port: %s", parts[3])
+		}
+		if parts[0] == "" || parts[2] == "" {
+			return nil, fmt.Errorf("invalid port forward format: %s", spec)
+		}
+		pf.ListenHost = parts[0]
+		pf.ListenPort = listenPort
+		pf.RemoteHost = parts[2]
+		pf.RemotePort = remotePort
 	default:
 		return nil, fmt.Errorf("invalid port forward format: %s", spec)
 	}
@@ -446,10 +1205,15 @@ func ParsePortForwardString(spec string) (*PortForward, error) {
 }
 
 // ParsePortForwardStringRange parses a port range string and returns all port forwards.
-// Format: "start-end" (e.g., "1000-1200")
+// Format: "start-end" (e.g., "1000-1200"), optionally with a "/tcp" or "/udp"
+// suffix.
 // Returns a slice of PortForward for each port in the range.
 func ParsePortForwardStringRange(spec string) ([]PortForward, error) {
-	startPort, endPort, err := parsePortRange(spec)
+	rest, protocol, err := splitProtocolSuffix(spec)
+	if err != nil {
+		return nil, err
+	}
+	startPort, endPort, err := parsePortRange(rest)
 	if err != nil {
 		return nil, err
 	}
@@ -461,7 +1225,7 @@ func ParsePortForwardStringRange(spec string) ([]PortForward, error) {
 			ListenPort: port,
 			RemoteHost: "127.0.0.1",
 			RemotePort: port,
-			Protocol:   "tcp",
+			Protocol:   protocol,
 		})
 	}
 
@@ -470,6 +1234,10 @@ func ParsePortForwardStringRange(spec string) ([]PortForward, error) {
 
 // isPortRange checks if a string is a port range format.
 func isPortRange(spec string) bool {
+	spec, _, err := splitProtocolSuffix(spec)
+	if err != nil {
+		return false
+	}
 	if !strings.Contains(spec, "-") || strings.Contains(spec, ":") {
 		return false
 	}
@@ -542,6 +1310,20 @@ func parsePortForwardMap(m map[string]interface{}) (*PortForward, error) {
 		pf.Protocol = v
 	}
 
+	// Parse ip_family
+	if v, ok := m["ip_family"].(string); ok {
+		pf.IPFamily = v
+	}
+
+	// Parse allow_from (a list or a comma-separated string)
+	if v, ok := m["allow_from"]; ok {
+		allowFrom, err := toStringSlice(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid allow_from: %w", err)
+		}
+		pf.AllowFrom = allowFrom
+	}
+
 	// Validate that we have a port
 	if pf.ListenPort == 0 {
 		return nil, fmt.Errorf("port or listen_port is required")
@@ -566,6 +1348,48 @@ func toInt(v interface{}) (int, error) {
 	}
 }
 
+// toStringSlice converts a YAML list of strings or a comma-separated string
+// to a slice.
+func toStringSlice(v interface{}) ([]string, error) {
+	switch val := v.(type) {
+	case string:
+		var out []string
+		for _, item := range strings.Split(val, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				out = append(out, item)
+			}
+		}
+		return out, nil
+	case []string:
+		return val, nil
+	case []interface{}:
+		out := make([]string, 0, len(val))
+		for _, item := range val {
+			str, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("expected string, got %T", item)
+			}
+			out = append(out, str)
+		}
+		return out, nil
+	default:
+		return nil, fmt.Errorf("cannot convert %T to a list", v)
+	}
+}
+
+// validateAllowFrom checks that every entry is a CIDR or an IP address.
+func validateAllowFrom(entries []string) error {
+	for _, entry := range entries {
+		if _, _, err := net.ParseCIDR(entry); err == nil {
+			continue
+		}
+		if net.ParseIP(entry) == nil {
+			return fmt.Errorf("invalid allow_from entry %q: expected a CIDR or IP address", entry)
+		}
+	}
+	return nil
+}
+
 // Validate validates the client configuration.
 func (c *ClientConfig) Validate() error {
 	if c.Client.Upstream.URL == "" {
@@ -575,11 +1399,49 @@ func (c *ClientConfig) Validate() error {
 		return fmt.Errorf("downstream URL is required")
 	}
 
+	// Validate path tokens
+	if c.Client.PathToken.Secret != "" && c.Client.PathToken.Window <= 0 {
+		return fmt.Errorf("invalid path_token window: %v", c.Client.PathToken.Window)
+	}
+	if err := c.Client.UpgradeCookie.validate(); err != nil {
+		return err
+	}
+
+	// Validate TLS client certificates
+	if err := c.Client.Upstream.validate(); err != nil {
+		return fmt.Errorf("upstream %w", err)
+	}
+	if err := c.Client.Downstream.validate(); err != nil {
+		return fmt.Errorf("downstream %w", err)
+	}
+
 	// Validate SOCKS5 port
 	if c.SOCKS5.Enabled {
 		if c.SOCKS5.ListenPort <= 0 || c.SOCKS5.ListenPort > 65535 {
 			return fmt.Errorf("invalid SOCKS5 port: %d", c.SOCKS5.ListenPort)
 		}
+		if _, err := sockopt.ParseFamily(c.SOCKS5.IPFamily); err != nil {
+			return fmt.Errorf("SOCKS5 %w", err)
+		}
+		if err := validateAllowFrom(c.SOCKS5.AllowFrom); err != nil {
+			return fmt.Errorf("SOCKS5 %w", err)
+		}
+	}
+
+	// Validate additional SOCKS5 listeners
+	socks5Names := map[string]bool{"socks5": true}
+	for _, l := range c.SOCKS5Listeners {
+		if err := l.validate(); err != nil {
+			return fmt.Errorf("SOCKS5 listener %s: %w", l.Label(), err)
+		}
+		if socks5Names[l.Label()] {
+			return fmt.Errorf("duplicate SOCKS5 listener name: %s", l.Label())
+		}
+		socks5Names[l.Label()] = true
+	}
+
+	if err := c.Routing.validate(); err != nil {
+		return err
 	}
 
 	// Validate port forwards
@@ -594,6 +1456,66 @@ func (c *ClientConfig) Validate() error {
 		if pf.RemotePort <= 0 || pf.RemotePort > 65535 {
 			return fmt.Errorf("invalid remote port: %d", pf.RemotePort)
 		}
+		if pf.Protocol != "" && pf.Protocol != "tcp" && pf.Protocol != "udp" {
+			return fmt.Errorf("invalid port forward protocol: %s (use tcp or udp)", pf.Protocol)
+		}
+		if _, err := sockopt.ParseFamily(pf.IPFamily); err != nil {
+			return fmt.Errorf("port forward %d: %w", pf.ListenPort, err)
+		}
+		if err := validateAllowFrom(pf.AllowFrom); err != nil {
+			return fmt.Errorf("port forward %d: %w", pf.ListenPort, err)
+		}
+	}
+
+	if err := c.Tunnel.Connection.TCP.validate(); err != nil {
+		return err
+	}
+
+	// Validate guest token
+	if c.Client.GuestToken != "" && !guest.IsToken(c.Client.GuestToken) {
+		return fmt.Errorf("invalid guest token: expected %s prefix", guest.TokenPrefix)
+	}
+	if c.Client.AuthToken != "" {
+		if !clientauth.IsToken(c.Client.AuthToken) {
+			return fmt.Errorf("invalid auth token: expected %s prefix", clientauth.TokenPrefix)
+		}
+		if c.Client.GuestToken != "" {
+			return fmt.Errorf("auth_token and guest_token are mutually exclusive")
+		}
+	}
+
+	if c.Observability.Metrics.StreamLabels.MaxDestHosts < 0 {
+		return fmt.Errorf("invalid metrics stream_labels max_dest_hosts: %d", c.Observability.Metrics.StreamLabels.MaxDestHosts)
+	}
+
+	if c.Observability.Health.Enabled && (c.Observability.Health.Port <= 0 || c.Observability.Health.Port > 65535) {
+		return fmt.Errorf("invalid health port: %d", c.Observability.Health.Port)
+	}
+
+	if err := c.Observability.Admin.validate(); err != nil {
+		return err
+	}
+	if err := c.Observability.Debug.validate(); err != nil {
+		return err
+	}
+	if c.Control.Enabled && c.Control.Socket == "" {
+		return fmt.Errorf("control socket path is required when the control socket is enabled")
+	}
+	if c.Hooks.Enabled() && c.Hooks.Timeout <= 0 {
+		return fmt.Errorf("hooks timeout must be positive")
+	}
+	if err := c.Notifications.validate(); err != nil {
+		return err
+	}
+
+	// Validate usage accounting
+	if c.Observability.Usage.Enabled {
+		if c.Observability.Usage.StateFile == "" {
+			return fmt.Errorf("usage state_file is required when usage accounting is enabled")
+		}
+		if c.Observability.Usage.FlushInterval <= 0 {
+			return fmt.Errorf("usage flush_interval must be positive")
+		}
 	}
 
 	// Validate DNS
@@ -603,6 +1525,37 @@ func (c *ClientConfig) Validate() error {
 		}
 	}
 
+	if c.Tunnel.Connection.SendQueueSize < 0 {
+		return fmt.Errorf("invalid send_queue_size: %d", c.Tunnel.Connection.SendQueueSize)
+	}
+	if c.Tunnel.Connection.StreamOpenTimeout < 0 {
+		return fmt.Errorf("invalid stream_open_timeout: %v", c.Tunnel.Connection.StreamOpenTimeout)
+	}
+	if c.Tunnel.Connection.RTTWarnThreshold < 0 {
+		return fmt.Errorf("invalid rtt_warn_threshold: %v", c.Tunnel.Connection.RTTWarnThreshold)
+	}
+	if n := c.Tunnel.Connection.MaxPayloadSize; n < 0 || n > protocol.MaxJumboPayloadSize {
+		return fmt.Errorf("invalid max_payload_size: %d (must be 0 to %d)", n, protocol.MaxJumboPayloadSize)
+	}
+	if err := validateCorruptPacketPolicy(c.Tunnel.Connection.CorruptPacketPolicy); err != nil {
+		return err
+	}
+	if err := c.Tunnel.Obfuscation.validate(); err != nil {
+		return err
+	}
+	if err := c.Tunnel.Degradation.validate(); err != nil {
+		return err
+	}
+	if err := c.Tunnel.Reconnect.CircuitBreaker.validate(); err != nil {
+		return err
+	}
+	if err := c.Tunnel.Reconnect.validate(); err != nil {
+		return err
+	}
+	if err := c.Tunnel.DataFlow.validate(); err != nil {
+		return err
+	}
+
 	// Validate encryption algorithm
 	if c.Tunnel.Encryption.Enabled {
 		switch c.Tunnel.Encryption.Algorithm {
@@ -613,5 +1566,9 @@ func (c *ClientConfig) Validate() error {
 		}
 	}
 
+	if err := c.Logging.validate(); err != nil {
+		return err
+	}
+
 	return nil
 }
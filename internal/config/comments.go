@@ -0,0 +1,204 @@
+package config
+
+// Comments written above the settings of rendered YAML configs, keyed by
+// the dotted path of the setting. Keys that are not in the schema are
+// caught by TestConfigCommentsMatchSchema.
+
+// sharedComments explains settings that clients and servers have in common.
+var sharedComments = map[string]string{
+	"tunnel.connection.tcp":              "TCP options for raw sockets",
+	"tunnel.connection.tcp.user_timeout": "Drop connections with data unacknowledged this long (Linux, 0s = OS default)",
+
+	"logging":            "Logging",
+	"logging.level":      "debug, info, warn or error",
+	"logging.format":     "json or console",
+	"logging.output":     "File path, stdout, stderr, syslog, journald or eventlog (empty = stdout)",
+	"logging.tag":        "syslog/journald identifier and event log source",
+	"logging.components": "Per-component levels overriding level",
+	"logging.rotation":   "Built-in rotation of the output file (leave max_size and interval\nempty to use logrotate)",
+
+	"observability.metrics.stream_labels":                 "Per-destination traffic (halftunnel_stream_bytes_total)",
+	"observability.metrics.stream_labels.max_dest_hosts":  "Hosts beyond this many are reported as \"other\"",
+	"observability.metrics.stream_labels.hash_dest_hosts": "Report hosts as a short hash instead of the hostname",
+	"observability.admin":                                 "JSON admin/status API (sessions, streams, reconnects); keep it on localhost",
+	"observability.debug":                                 "pprof, expvar and goroutine/heap dumps for profiling; no authentication,\nkeep it on localhost",
+	"observability.debug.dump_dir":                        "Directory for dumps written by POST /debug/dump (empty = temp directory)",
+
+	"notifications":                  "Notifications of critical events, sent to every configured destination",
+	"notifications.cooldown":         "Don't repeat a notification of the same event sooner than this",
+	"notifications.telegram":         "Telegram bot (from @BotFather); used when bot_token is set",
+	"notifications.telegram.chat_id": "Chat, group or channel ID to post to",
+	"notifications.webhook":          "JSON POST of each notification; used when url is set",
+	"notifications.webhook.headers":  "Extra request headers, e.g. Authorization",
+	"notifications.email":            "SMTP server; used when host is set (STARTTLS when offered)",
+}
+
+var clientComments = withShared(map[string]string{
+	"client":                            "Any value may reference an environment variable as ${NAME} or\n${NAME:-default}, and any string setting may be read from a file by\nappending _file to its key (e.g. auth_token_file: /run/secrets/token)",
+	"client.name":                       "Client identification",
+	"client.exit_on_port_in_use":        "Exit when a local listener port is already in use",
+	"client.listen_on_connect":          "Only start SOCKS5/port forwards after the tunnel connection is established",
+	"client.guest_token":                "Guest token issued by the server operator",
+	"client.auth_token":                 "Client token identifying this client (half-tunnel token generate);\nmutually exclusive with guest_token",
+	"client.path_token":                 "Rotating WebSocket path tokens; must match the server's path_token",
+	"client.upgrade_cookie":             "Cookie with a rotating token sent on every WebSocket dial; must match the\nserver's upgrade_cookie",
+	"client.upstream":                   "Upstream connection (Domain A) - sends requests to server",
+	"client.downstream":                 "Downstream connection (Domain B) - receives responses from server",
+	"client.upstream.tls.cert_file":     "Client certificate for servers that require mutual TLS",
+	"client.downstream.tls.cert_file":   "Client certificate for servers that require mutual TLS",
+	"client.upstream.tls.server_name":   "SNI sent in the TLS handshake (empty = the URL host)",
+	"client.downstream.tls.server_name": "SNI sent in the TLS handshake (empty = the URL host)",
+	"client.upstream.tls.pin_sha256":    "Accepted server public key hashes (half-tunnel cert fingerprint); replace CA validation",
+	"client.downstream.tls.pin_sha256":  "Accepted server public key hashes (half-tunnel cert fingerprint); replace CA validation",
+	"client.upstream.tls.fingerprint":   "Mimic a browser's TLS ClientHello: chrome, firefox or safari (empty =\nGo's; needs a build with -tags utls, not with proxy_url)",
+	"client.downstream.tls.fingerprint": "Mimic a browser's TLS ClientHello: chrome, firefox or safari (empty =\nGo's; needs a build with -tags utls, not with proxy_url)",
+	"client.upstream.host":              "Handshake overrides for domain fronting or strict CDNs",
+	"client.downstream.host":            "Handshake overrides for domain fronting or strict CDNs",
+	"client.upstream.proxy_url":         "Outbound proxy for the WebSocket dial (http:// or socks5://)",
+	"client.downstream.proxy_url":       "Outbound proxy for the WebSocket dial (http:// or socks5://)",
+
+	"port_forwards": "Port forwarding rules: a port (2083), a string (\"8080:example.com:80\")\nor a map with listen_host, listen_port, remote_host, remote_port,\nprotocol, ip_family and allow_from",
+
+	"socks5":            "SOCKS5 proxy (for dynamic port forwarding - any destination)",
+	"socks5.ip_family":  "dual, ipv4 or ipv6",
+	"socks5.allow_from": "CIDRs or addresses allowed to connect (empty = everyone)",
+	"socks5_listeners":  "Additional SOCKS5 listeners, each with its own address, allowlist and\ncredentials",
+	"routing":           "Split tunneling: decide per destination whether connections go through\nthe tunnel, are dialed directly by the client, or are refused. Rules\nare checked in order; the first match wins.",
+	"routing.default":   "tunnel, direct or block",
+	"routing.geoip":     "MaxMind DB country database (.mmdb) for countries",
+	"routing.resolve":   "Resolve names on the client for networks/countries",
+
+	"tunnel":                                  "Tunnel settings",
+	"tunnel.reconnect":                        "Reconnection strategy",
+	"tunnel.reconnect.circuit_breaker":        "After max_failures consecutive failed reconnects, pause reconnecting\nfor timeout (reset early with POST /api/breaker/reset)",
+	"tunnel.reconnect.max_attempts":           "Give up after this many failed reconnects (0 = never)",
+	"tunnel.reconnect.max_elapsed_time":       "Give up after reconnecting failed for this long (0s = never)",
+	"tunnel.reconnect.give_up_script":         "Shell command to run on giving up, then keep reconnecting; without one\nthe client exits with status 75 for the service manager to restart it",
+	"tunnel.connection":                       "Connection settings",
+	"tunnel.connection.stream_open_timeout":   "Wait this long for the server to connect a new stream's destination\nbefore replying to SOCKS5 clients (0s = reply at once)",
+	"tunnel.connection.rtt_warn_threshold":    "Warn when a path's round-trip time rises above this (0s = off)",
+	"tunnel.connection.compact_header":        "Use compact packet headers if the server supports them",
+	"tunnel.connection.max_payload_size":      "Largest packet payload to offer (0 = 65535; up to 1048576 with\ncompact headers)",
+	"tunnel.connection.checksum":              "Add a checksum to every packet if the server supports it",
+	"tunnel.connection.corrupt_packet_policy": "On a checksum mismatch: reset (close the stream) or drop (the packet only)",
+	"tunnel.connection.send_queue_size":       "Packets queued per tunnel connection for its single writer (0 = write\ndirectly); senders wait while it is full",
+	"tunnel.encryption":                       "Encryption (must match server)",
+	"tunnel.obfuscation":                      "Traffic shaping against analysis of upstream packet sizes and timing",
+	"tunnel.obfuscation.max_padding":          "Pad data packets with up to this many random bytes if the server\nsupports it (0 = off)",
+	"tunnel.obfuscation.cover_traffic":        "Dummy packets every interval (+/- jitter as a fraction) of min_size to\nmax_size bytes, discarded by the server",
+	"tunnel.dataflow":                         "Detect a tunnel that is connected but passes no data: after\nstall_threshold with less than min_bytes per check_interval, log,\nrestart (reconnect) or shutdown (exit for the service manager to restart)",
+	"tunnel.degradation":                      "Keep streams open across reconnects: queue their data (up to\nqueue_size packets, each for at most queue_timeout) and send it once\nthe session is resumed; after recovery_timeout the streams are closed",
+
+	"dns": "DNS settings (for full VPN mode)",
+
+	"observability":                   "Local metrics, health checks and status",
+	"observability.health":            "/healthz and /readyz; ready only while both tunnel legs are connected",
+	"observability.health.echo_probe": "Also require an echo through the tunnel for readiness (needs\ntunnel.diagnostics.enabled on the server)",
+	"observability.usage":             "Persistent traffic counters, reported by \"ht client usage\"; the\nstate_file directory must be writable by the client",
+
+	"control": "Local control socket for \"ht c ctl\" (reload, dump-state, set-log-level, ...)",
+
+	"hooks":               "Shell commands run on tunnel events, with HT_EVENT, HT_SESSION_ID and\nHT_REASON set (empty = none); they run one at a time in the background",
+	"hooks.on_connect":    "Whenever the tunnel connects, including after a reconnect",
+	"hooks.on_disconnect": "When the tunnel connection is lost; HT_REASON is what noticed it",
+	"hooks.on_reconnect":  "When the tunnel connects again after it was lost",
+	"hooks.on_stall":      "When data stops flowing (see tunnel.dataflow); HT_REASON is the action",
+	"hooks.timeout":       "Kill a command that runs longer than this",
+
+	"notifications.events":             "Only these events (empty = all): reconnect_failing",
+	"notifications.reconnect_failures": "Notify reconnect_failing after this many failed reconnect attempts",
+})
+
+var serverComments = withShared(map[string]string{
+	"server":                               "Any value may reference an environment variable as ${NAME} or\n${NAME:-default}, and any string setting may be read from a file by\nappending _file to its key (e.g. secret_file: /run/secrets/secret)",
+	"server.name":                          "Server identification",
+	"server.exit_on_port_in_use":           "Exit when a listener port is already in use",
+	"server.path_token":                    "Rotating WebSocket path tokens: clients must connect to <path>/<token>,\nwhere the token is derived from this secret and the current time window",
+	"server.upgrade_cookie":                "Anti-probing: WebSocket upgrades must carry a cookie with a token derived\nfrom this secret; requests without it get the decoy response",
+	"server.decoy":                         "Decoy website for requests that are not tunnel connections: a directory\nof static files or a site to reverse proxy to (not both). Without a\ndecoy, such requests get a plain 404.",
+	"server.upstream":                      "Upstream listener (Domain A) - receives client requests",
+	"server.downstream":                    "Downstream listener (Domain B) - sends responses to client. Using the\nsame host and port as upstream (with a different path) serves both\ndirections on a single listener",
+	"server.upstream.ip_family":            "dual (IPv4 and IPv6), ipv4 or ipv6",
+	"server.downstream.ip_family":          "dual (IPv4 and IPv6), ipv4 or ipv6",
+	"server.upstream.tls.client_ca_file":   "Mutual TLS: verify client certificates against this CA",
+	"server.downstream.tls.client_ca_file": "Mutual TLS: verify client certificates against this CA",
+
+	"access":                                   "Access control (server doesn't define ports - client requests any destination)",
+	"access.allowed_networks":                  "Allowed destination networks (empty = allow all)",
+	"access.blocked_networks":                  "Blocked destinations (takes priority over allowed)",
+	"access.max_streams_per_session":           "Max connections per session",
+	"access.max_connections_per_ip":            "Max open tunnel connections per client IP (0 = unlimited)",
+	"access.guest":                             "Time-limited guest sessions (issue tokens with: half-tunnel guest issue)",
+	"access.guest.required":                    "Reject sessions without a valid guest token",
+	"access.dial_retry":                        "Destination dial retries (attempts = total dials; 1 disables retries)",
+	"access.rules":                             "Per-destination overrides; the first matching rule with dial_retry wins",
+	"access.client_auth":                       "Client identities (add clients with: half-tunnel token generate)",
+	"access.client_auth.required":              "Reject clients without a token or client certificate",
+	"access.policy":                            "Destination policy; blocked streams fail with \"blocked\" on the client",
+	"access.policy.block_private":              "Block private, loopback and link-local destinations",
+	"access.policy.clients":                    "Restrict clients (by client_auth name or certificate common name) to\ndestinations",
+	"access.quotas":                            "Traffic quotas and rate caps for identified clients; streams over quota\nfail with \"quota_exceeded\" on the client",
+	"access.quotas.default":                    "Clients without their own entry (empty = unlimited)",
+	"access.connection_limits":                 "Per-source-IP limits on WebSocket upgrade attempts and failed handshakes;\nsources over a limit are banned and get the decoy response",
+	"access.connection_limits.state_file":      "Keep bans across restarts (empty = in memory only)",
+	"access.connection_limits.exempt_networks": "Never limited, e.g. a CDN's addresses",
+
+	"tunnel":                                  "Tunnel settings",
+	"tunnel.session":                          "Session management",
+	"tunnel.session.max_sessions":             "Maximum concurrent sessions (0 = unlimited)",
+	"tunnel.session.eviction":                 "At the limit: reject new sessions, or evict \"lru\" / \"idle\" ones",
+	"tunnel.session.store":                    "Where session state lives: \"memory\" (this process) or \"redis\" (shared\nbetween instances; max_sessions becomes a global limit)",
+	"tunnel.connection":                       "Connection settings",
+	"tunnel.connection.slow_dial_threshold":   "Warn when the p95 destination dial time exceeds this (0s = off)",
+	"tunnel.connection.max_concurrent_dials":  "Destination dials in progress at once across all sessions (0 = no limit)",
+	"tunnel.connection.accept_queue_size":     "Upgraded tunnel connections waiting to be served; beyond this, upgrades\nare refused with 503 until there is room",
+	"tunnel.connection.max_payload_size":      "Largest packet payload to offer (0 = what fits max_message_size, up to\n65535); jumbo frames up to 1048576 need max_message_size 70 bytes larger",
+	"tunnel.connection.corrupt_packet_policy": "On a checksum mismatch: reset (close the stream) or drop (the packet only)",
+	"tunnel.connection.send_queue_size":       "Packets queued per tunnel connection for its single writer (0 = write\ndirectly); senders wait while it is full",
+	"tunnel.circuit_breaker":                  "Per-destination circuit breaker: after max_failures consecutive failed\ndials, streams to that destination fail immediately for timeout",
+	"tunnel.dataflow":                         "Close sessions whose downstream writes keep failing for stall_threshold\nwhile the client still sends data",
+	"tunnel.liveness":                         "Probe the destination of streams idle for idle_timeout with TCP keepalives\nand close the stream if probe_count probes go unanswered",
+	"tunnel.resource_guard":                   "Refuse new streams once open files, open streams or goroutines reach\nshed_at of their ceiling (max_open_files 0 = ulimit -n; other 0 = no ceiling)",
+	"tunnel.encryption":                       "Encryption",
+	"tunnel.encryption.algorithm":             "aes-256-gcm or chacha20-poly1305",
+	"tunnel.diagnostics":                      "Answer streams to echo.internal:7, discard.internal:9 and\nchargen.internal:19 in the server, for `ht c bench`",
+
+	"observability":       "Metrics & Health",
+	"observability.audit": "Record of every stream opened and closed, written regardless of log level",
+
+	"control": "Local control socket for \"ht s ctl\" (reload, dump-state, set-log-level, ...)",
+	"cluster": "Multi-server deployments: servers sharing one name route each session to\none owner node. List every other node in peers; all nodes must use the\nsame secret and path_token secret.",
+
+	"egress":               "Connections from the server to destinations",
+	"egress.bind_address":  "Source IP for destination connections (multi-homed hosts)",
+	"egress.interface":     "Bind destination connections to an interface, e.g. \"eth1\" (Linux)",
+	"egress.mark":          "SO_MARK/fwmark for destination connections (Linux, 0 = off)",
+	"egress.dns.servers":   "e.g. [\"1.1.1.1\", \"8.8.8.8:53\"]; empty = system resolver",
+	"egress.dns.doh_url":   "e.g. \"https://cloudflare-dns.com/dns-query\" (overrides servers)",
+	"egress.dns.prefer":    "auto, ipv4, ipv6, ipv4_only or ipv6_only",
+	"egress.dns.cache_ttl": "Longest time an answer is cached; \"0s\" disables the cache",
+
+	"notifications.events":              "Only these events (empty = all): cert_expiring, quota_exceeded,\nserver_started",
+	"notifications.cert_expiry_warning": "Notify cert_expiring when a TLS certificate expires within this",
+})
+
+// withShared adds the shared comments to comments.
+func withShared(comments map[string]string) map[string]string {
+	for key, comment := range sharedComments {
+		if _, ok := comments[key]; !ok {
+			comments[key] = comment
+		}
+	}
+	return comments
+}
+
+// configComments returns the header and setting comments of a rendered
+// config.
+func configComments(cfg interface{}) (string, map[string]string) {
+	switch cfg.(type) {
+	case *ServerConfig:
+		return "Half-Tunnel Server Configuration", serverComments
+	default:
+		return "Half-Tunnel Client Configuration", clientComments
+	}
+}
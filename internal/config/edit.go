@@ -0,0 +1,142 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+	"go.yaml.in/yaml/v3"
+)
+
+// UpdateConfigFile sets keys of a config file to new values and keeps
+// everything else. YAML files keep their comments and key order; JSON and
+// TOML files are rewritten with sorted keys. Values are typed the way they
+// would be when written in a YAML file.
+func UpdateConfigFile(path string, overrides []Override) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var updated []byte
+	switch format := FormatFromPath(path); format {
+	case FormatYAML:
+		updated, err = updateYAML(content, overrides)
+	default:
+		updated, err = updateEncoded(content, format, overrides)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update %s: %w", path, err)
+	}
+	return os.WriteFile(path, updated, info.Mode().Perm())
+}
+
+// updateYAML sets the overridden keys in a YAML document.
+func updateYAML(content []byte, overrides []Override) ([]byte, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(content, &doc); err != nil {
+		return nil, err
+	}
+	if len(doc.Content) == 0 {
+		doc = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode}}}
+	}
+
+	for _, o := range overrides {
+		node := doc.Content[0]
+		for _, part := range strings.Split(o.Key, ".") {
+			if node.Kind != yaml.MappingNode {
+				return nil, fmt.Errorf("cannot set %s: parent is not a mapping", o.Key)
+			}
+			node = mappingValue(node, part)
+		}
+		*node = yaml.Node{
+			Kind:        yaml.ScalarNode,
+			Value:       o.Value,
+			HeadComment: node.HeadComment,
+			LineComment: node.LineComment,
+		}
+	}
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(&doc); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// mappingValue returns the value node of key in a mapping node, adding an
+// empty mapping under key if it is missing.
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	value := &yaml.Node{Kind: yaml.MappingNode}
+	node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: key}, value)
+	return value
+}
+
+// updateEncoded sets the overridden keys in a JSON or TOML document.
+func updateEncoded(content []byte, format string, overrides []Override) ([]byte, error) {
+	settings := make(map[string]interface{})
+	var err error
+	if format == FormatJSON {
+		err = json.Unmarshal(content, &settings)
+	} else {
+		err = toml.Unmarshal(content, &settings)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, o := range overrides {
+		var value interface{}
+		if err := yaml.Unmarshal([]byte(o.Value), &value); err != nil || value == nil {
+			value = o.Value
+		}
+		setKey(settings, o.Key, value)
+	}
+
+	if format == FormatJSON {
+		data, err := json.MarshalIndent(settings, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		return append(data, '\n'), nil
+	}
+	var buf bytes.Buffer
+	enc := toml.NewEncoder(&buf)
+	enc.SetIndentTables(true)
+	if err := enc.Encode(settings); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// EnableServerTLS turns on TLS for both listeners of the server config at
+// path, with the given certificate and key.
+func EnableServerTLS(path, certFile, keyFile string) error {
+	var overrides []Override
+	for _, listener := range []string{"upstream", "downstream"} {
+		prefix := "server." + listener + ".tls."
+		overrides = append(overrides,
+			Override{Key: prefix + "enabled", Value: "true"},
+			Override{Key: prefix + "cert_file", Value: certFile},
+			Override{Key: prefix + "key_file", Value: keyFile},
+		)
+	}
+	return UpdateConfigFile(path, overrides)
+}
@@ -0,0 +1,60 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEnableServerTLS(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+	}{
+		{"yaml", "server.yml", "# My server\nserver:\n  name: \"exit-1\" # keep me\n  upstream:\n    port: 443\n"},
+		{"json", "server.json", `{"server": {"name": "exit-1", "upstream": {"port": 443}}}`},
+		{"toml", "server.toml", "[server]\nname = 'exit-1'\n\n[server.upstream]\nport = 443\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), tt.file)
+			if err := os.WriteFile(path, []byte(tt.content), 0640); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := EnableServerTLS(path, "/etc/half-tunnel/certs/server.crt", "/etc/half-tunnel/certs/server.key"); err != nil {
+				t.Fatalf("EnableServerTLS() error = %v", err)
+			}
+
+			cfg, err := LoadServerConfig(path)
+			if err != nil {
+				t.Fatalf("LoadServerConfig() error = %v", err)
+			}
+			for _, listener := range []ServerEndpoint{cfg.Server.Upstream, cfg.Server.Downstream} {
+				if !listener.TLS.Enabled || listener.TLS.CertFile != "/etc/half-tunnel/certs/server.crt" || listener.TLS.KeyFile != "/etc/half-tunnel/certs/server.key" {
+					t.Errorf("Expected TLS with the new certificate, got %+v", listener.TLS)
+				}
+			}
+			if cfg.Server.Name != "exit-1" || cfg.Server.Upstream.Port != 443 {
+				t.Errorf("Expected other settings to be kept, got name %s and port %d", cfg.Server.Name, cfg.Server.Upstream.Port)
+			}
+
+			info, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Mode().Perm() != 0640 {
+				t.Errorf("Expected the file mode to be kept, got %v", info.Mode().Perm())
+			}
+			if tt.name == "yaml" {
+				content, _ := os.ReadFile(path)
+				if !strings.Contains(string(content), "# My server") || !strings.Contains(string(content), "# keep me") {
+					t.Errorf("Expected comments to be kept, got:\n%s", content)
+				}
+			}
+		})
+	}
+}
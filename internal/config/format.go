@@ -0,0 +1,237 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+	"go.yaml.in/yaml/v3"
+)
+
+// Config file formats.
+const (
+	FormatYAML = "yaml"
+	FormatJSON = "json"
+	FormatTOML = "toml"
+)
+
+// ParseFormat returns the config file format named s.
+func ParseFormat(s string) (string, error) {
+	switch strings.ToLower(s) {
+	case "yaml", "yml":
+		return FormatYAML, nil
+	case FormatJSON:
+		return FormatJSON, nil
+	case FormatTOML:
+		return FormatTOML, nil
+	default:
+		return "", fmt.Errorf("unknown config format: %s (use yaml, json or toml)", s)
+	}
+}
+
+// FormatFromPath returns the format of a config file by its extension:
+// JSON for .json, TOML for .toml and YAML otherwise.
+func FormatFromPath(path string) string {
+	if format, err := ParseFormat(strings.TrimPrefix(filepath.Ext(path), ".")); err == nil {
+		return format
+	}
+	return FormatYAML
+}
+
+// RenderClientConfig renders a client config in the given format. Every
+// setting is written; YAML output explains the settings in comments.
+func RenderClientConfig(cfg *ClientConfig, format string) (string, error) {
+	return encodeConfig(cfg, nil, format)
+}
+
+// RenderServerConfig renders a server config in the given format. Every
+// setting is written; YAML output explains the settings in comments.
+func RenderServerConfig(cfg *ServerConfig, format string) (string, error) {
+	return encodeConfig(cfg, nil, format)
+}
+
+// encodeConfig marshals every setting of cfg, a pointer to a ClientConfig
+// or ServerConfig, in the given format. raw holds the settings as written
+// in a file and may be nil; environment variable and file references found
+// there are written in place of their values.
+func encodeConfig(cfg interface{}, raw map[string]interface{}, format string) (string, error) {
+	var node yaml.Node
+	if err := node.Encode(cfg); err != nil {
+		return "", fmt.Errorf("failed to render config: %w", err)
+	}
+	if raw != nil {
+		restoreReferences(&node, reflect.TypeOf(cfg), raw)
+	}
+	header, comments := configComments(cfg)
+
+	var buf bytes.Buffer
+	switch format {
+	case FormatYAML:
+		commentConfig(&node, comments, "")
+		var body bytes.Buffer
+		enc := yaml.NewEncoder(&body)
+		enc.SetIndent(2)
+		if err := enc.Encode(&node); err != nil {
+			return "", fmt.Errorf("failed to render config: %w", err)
+		}
+		if err := enc.Close(); err != nil {
+			return "", fmt.Errorf("failed to render config: %w", err)
+		}
+		buf.WriteString("# " + header + "\n")
+		buf.WriteString(separateSections(body.String()))
+	case FormatJSON:
+		// Written from the node to keep the order of the schema
+		var compact bytes.Buffer
+		if err := writeJSON(&compact, &node); err != nil {
+			return "", fmt.Errorf("failed to render config: %w", err)
+		}
+		if err := json.Indent(&buf, compact.Bytes(), "", "  "); err != nil {
+			return "", fmt.Errorf("failed to render config: %w", err)
+		}
+		buf.WriteString("\n")
+	case FormatTOML:
+		var settings map[string]interface{}
+		if err := node.Decode(&settings); err != nil {
+			return "", fmt.Errorf("failed to render config: %w", err)
+		}
+		buf.WriteString("# " + header + "\n")
+		enc := toml.NewEncoder(&buf)
+		enc.SetIndentTables(true)
+		if err := enc.Encode(settings); err != nil {
+			return "", fmt.Errorf("failed to render config: %w", err)
+		}
+	default:
+		return "", fmt.Errorf("unknown config format: %s", format)
+	}
+	return buf.String(), nil
+}
+
+// restoreReferences puts the environment variable and "<key>_file"
+// references of raw back into a marshaled config of type t.
+func restoreReferences(n *yaml.Node, t reflect.Type, raw interface{}) {
+	switch n.Kind {
+	case yaml.MappingNode:
+		rawMap, _ := raw.(map[string]interface{})
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			key, value := n.Content[i], n.Content[i+1]
+			if ref, ok := rawMap[key.Value+fileSuffix]; ok {
+				if _, isRef := fileReferenceBase(t, key.Value+fileSuffix); isRef {
+					key.Value += fileSuffix
+					*value = yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: fmt.Sprint(ref)}
+					continue
+				}
+			}
+			restoreReferences(value, fieldType(t, key.Value), rawMap[key.Value])
+		}
+	case yaml.SequenceNode:
+		rawList, _ := raw.([]interface{})
+		var elem reflect.Type
+		if t != nil && t.Kind() == reflect.Slice {
+			elem = t.Elem()
+		}
+		for i, item := range n.Content {
+			if i < len(rawList) {
+				restoreReferences(item, elem, rawList[i])
+			}
+		}
+	case yaml.ScalarNode:
+		if s, ok := raw.(string); ok && strings.Contains(s, "${") {
+			*n = yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: s}
+		}
+	}
+}
+
+// commentConfig attaches comments, keyed by the dotted path of a setting,
+// to the keys of a marshaled config.
+func commentConfig(n *yaml.Node, comments map[string]string, path string) {
+	if n.Kind != yaml.MappingNode {
+		return
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		key := joinKey(path, n.Content[i].Value)
+		if comment, ok := comments[key]; ok {
+			n.Content[i].HeadComment = comment
+		}
+		commentConfig(n.Content[i+1], comments, key)
+	}
+}
+
+// separateSections puts a blank line before every top-level section of a
+// YAML document after the first, above the section's comment.
+func separateSections(doc string) string {
+	lines := strings.SplitAfter(doc, "\n")
+	blankBefore := make(map[int]bool)
+	first := true
+	for i, line := range lines {
+		if line == "" || strings.ContainsAny(line[:1], " -#\n") {
+			continue
+		}
+		if !first {
+			j := i
+			for j > 0 && strings.HasPrefix(lines[j-1], "#") {
+				j--
+			}
+			blankBefore[j] = true
+		}
+		first = false
+	}
+
+	var out strings.Builder
+	for i, line := range lines {
+		if blankBefore[i] {
+			out.WriteString("\n")
+		}
+		out.WriteString(line)
+	}
+	return out.String()
+}
+
+// writeJSON writes a YAML node as compact JSON.
+func writeJSON(buf *bytes.Buffer, n *yaml.Node) error {
+	switch n.Kind {
+	case yaml.DocumentNode:
+		return writeJSON(buf, n.Content[0])
+	case yaml.AliasNode:
+		return writeJSON(buf, n.Alias)
+	case yaml.MappingNode:
+		buf.WriteByte('{')
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			key, _ := json.Marshal(n.Content[i].Value)
+			buf.Write(key)
+			buf.WriteByte(':')
+			if err := writeJSON(buf, n.Content[i+1]); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+	case yaml.SequenceNode:
+		buf.WriteByte('[')
+		for i, item := range n.Content {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := writeJSON(buf, item); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+	default:
+		var value interface{}
+		if err := n.Decode(&value); err != nil {
+			return err
+		}
+		data, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		buf.Write(data)
+	}
+	return nil
+}
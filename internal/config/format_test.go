@@ -0,0 +1,193 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"go.yaml.in/yaml/v3"
+)
+
+func TestParseFormat(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    string
+		wantErr bool
+	}{
+		{"yaml", FormatYAML, false},
+		{"yml", FormatYAML, false},
+		{"JSON", FormatJSON, false},
+		{"toml", FormatTOML, false},
+		{"ini", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := ParseFormat(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseFormat() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+
+	for path, want := range map[string]string{
+		"client.yml":          FormatYAML,
+		"/etc/ht/server.Json": FormatJSON,
+		"client.toml":         FormatTOML,
+		"client.conf":         FormatYAML,
+		"":                    FormatYAML,
+	} {
+		if got := FormatFromPath(path); got != want {
+			t.Errorf("FormatFromPath(%q): expected %s, got %s", path, want, got)
+		}
+	}
+}
+
+func TestRenderedFormatsLoad(t *testing.T) {
+	tests := []struct {
+		name   string
+		sample string
+		render func(interface{}, string) (string, error)
+		load   func(string) (interface{}, error)
+	}{
+		{
+			"client", "../../configs/client.yml",
+			func(cfg interface{}, format string) (string, error) {
+				return RenderClientConfig(cfg.(*ClientConfig), format)
+			},
+			func(p string) (interface{}, error) { return LoadClientConfig(p) },
+		},
+		{
+			"server", "../../configs/server.yml",
+			func(cfg interface{}, format string) (string, error) {
+				return RenderServerConfig(cfg.(*ServerConfig), format)
+			},
+			func(p string) (interface{}, error) { return LoadServerConfig(p) },
+		},
+	}
+
+	for _, tt := range tests {
+		for _, format := range []string{FormatYAML, FormatJSON, FormatTOML} {
+			t.Run(tt.name+"/"+format, func(t *testing.T) {
+				want, err := tt.load(tt.sample)
+				if err != nil {
+					t.Fatal(err)
+				}
+				content, err := tt.render(want, format)
+				if err != nil {
+					t.Fatalf("render error = %v", err)
+				}
+
+				path := filepath.Join(t.TempDir(), tt.name+"."+format)
+				if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+					t.Fatal(err)
+				}
+				got, err := tt.load(path)
+				if err != nil {
+					t.Fatalf("load error = %v\n%s", err, content)
+				}
+				if err := got.(interface{ Validate() error }).Validate(); err != nil {
+					t.Errorf("Expected the rendered config to be valid: %v", err)
+				}
+
+				gotYAML, _ := yaml.Marshal(got)
+				wantYAML, _ := yaml.Marshal(want)
+				if string(gotYAML) != string(wantYAML) {
+					t.Errorf("Expected the %s config to load the same settings\ngot:\n%s\nwant:\n%s", format, gotYAML, wantYAML)
+				}
+			})
+		}
+	}
+}
+
+func TestMigrateKeepsFormat(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "client.json")
+	legacy := `{"client": {"upstream_url": "wss://a.example.com/up", "downstream_url": "wss://b.example.com/down"}}`
+	if err := os.WriteFile(path, []byte(legacy), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := MigrateClientConfig(path)
+	if err != nil {
+		t.Fatalf("MigrateClientConfig() error = %v", err)
+	}
+	if !strings.HasPrefix(result.Content, "{") {
+		t.Errorf("Expected JSON output, got:\n%s", result.Content)
+	}
+	if err := os.WriteFile(path, []byte(result.Content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := LoadClientConfig(path)
+	if err != nil {
+		t.Fatalf("LoadClientConfig() error = %v", err)
+	}
+	if cfg.Client.Upstream.URL != "wss://a.example.com/up" {
+		t.Errorf("Expected upstream URL to be migrated, got %s", cfg.Client.Upstream.URL)
+	}
+}
+
+func TestConfigCommentsMatchSchema(t *testing.T) {
+	tests := []struct {
+		name     string
+		cfg      interface{}
+		comments map[string]string
+	}{
+		{"client", DefaultClientConfig(), clientComments},
+		{"server", DefaultServerConfig(), serverComments},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := yaml.Marshal(tt.cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var settings map[string]interface{}
+			if err := yaml.Unmarshal(data, &settings); err != nil {
+				t.Fatal(err)
+			}
+			for key := range tt.comments {
+				if _, ok := lookupKey(settings, key); !ok {
+					t.Errorf("Comment for unknown key %s", key)
+				}
+			}
+		})
+	}
+}
+
+func TestYAMLTagsMatchMapstructure(t *testing.T) {
+	seen := make(map[reflect.Type]bool)
+	var check func(reflect.Type)
+	check = func(typ reflect.Type) {
+		for typ.Kind() == reflect.Pointer || typ.Kind() == reflect.Slice || typ.Kind() == reflect.Map {
+			typ = typ.Elem()
+		}
+		if typ.Kind() != reflect.Struct || seen[typ] {
+			return
+		}
+		seen[typ] = true
+		for i := 0; i < typ.NumField(); i++ {
+			field := typ.Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			name, opts, _ := strings.Cut(field.Tag.Get("mapstructure"), ",")
+			yamlName, yamlOpts, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+			if strings.Contains(opts, "squash") {
+				name = ""
+				opts = "inline"
+			}
+			if name != yamlName || strings.Contains(opts, "omitempty") && !strings.Contains(yamlOpts, "omitempty") || strings.Contains(opts, "inline") != strings.Contains(yamlOpts, "inline") {
+				t.Errorf("%s.%s: yaml tag %q does not match mapstructure tag %q", typ.Name(), field.Name, field.Tag.Get("yaml"), field.Tag.Get("mapstructure"))
+			}
+			check(field.Type)
+		}
+	}
+	check(reflect.TypeOf(ClientConfig{}))
+	check(reflect.TypeOf(ServerConfig{}))
+}
@@ -3,13 +3,14 @@ package config
 
 import (
 	"bufio"
-	"bytes"
 	"fmt"
 	"io"
 	"os"
 	"strconv"
 	"strings"
-	"text/template"
+	"time"
+
+	"github.com/sahmadiut/half-tunnel/internal/pathtoken"
 )
 
 // ConfigGenerator handles interactive and flag-based config generation.
@@ -30,14 +31,47 @@ type GenerateOptions struct {
 	TLSCert        string
 	TLSKey         string
 	ServerName     string
+	RandomPaths    bool
+
+	ListenHost     string
+	MaxSessions    int
+	SessionTimeout time.Duration
+
+	// Shared options
+	PathSecret          string
+	Encryption          *bool
+	EncryptionAlgorithm string
+	KeepaliveInterval   time.Duration
+	LogLevel            string
+	LogFormat           string
+	LogOutput           string
+	Metrics             *bool
+	MetricsPort         int
+	HealthPort          int
 
 	// Client options
-	UpstreamURL   string
-	DownstreamURL string
-	PortForwards  []string
-	SOCKS5Port    int
-	ClientName    string
-	EnableSOCKS5  bool
+	UpstreamURL           string
+	DownstreamURL         string
+	PortForwards          []string
+	SOCKS5Port            int
+	ClientName            string
+	EnableSOCKS5          bool
+	SOCKS5Host            string
+	SOCKS5Username        string
+	SOCKS5Password        string
+	TLS                   *bool
+	TLSSkipVerify         bool
+	CAFile                string
+	DNSPort               int
+	DNSUpstreams          []string
+	Reconnect             *bool
+	ReconnectInitialDelay time.Duration
+	ReconnectMaxDelay     time.Duration
+	ReconnectMultiplier   float64
+	DialTimeout           time.Duration
+
+	// Overrides set any other key of the schema and are applied last
+	Overrides []Override
 }
 
 // NewConfigGenerator creates a new config generator.
@@ -184,16 +218,23 @@ func (g *ConfigGenerator) generateClientConfigFromOptions(cfg *ClientConfig, opt
 			return nil, fmt.Errorf("invalid port forward %q: %w", pf, err)
 		}
 		// Convert to simple format if possible (remote_host is default 127.0.0.1)
-		if parsed.RemoteHost == "127.0.0.1" && parsed.ListenPort == parsed.RemotePort {
+		if parsed.RemoteHost == "127.0.0.1" && parsed.ListenPort == parsed.RemotePort &&
+			parsed.ListenHost == "0.0.0.0" && parsed.Protocol == "tcp" {
 			portForwards = append(portForwards, parsed.ListenPort)
 		} else {
 			pfMap := map[string]interface{}{
 				"listen_port": parsed.ListenPort,
 				"remote_port": parsed.RemotePort,
 			}
+			if parsed.ListenHost != "0.0.0.0" {
+				pfMap["listen_host"] = parsed.ListenHost
+			}
 			if parsed.RemoteHost != "" && parsed.RemoteHost != "127.0.0.1" {
 				pfMap["remote_host"] = parsed.RemoteHost
 			}
+			if parsed.Protocol != "tcp" {
+				pfMap["protocol"] = parsed.Protocol
+			}
 			portForwards = append(portForwards, pfMap)
 		}
 	}
@@ -205,6 +246,64 @@ func (g *ConfigGenerator) generateClientConfigFromOptions(cfg *ClientConfig, opt
 		cfg.SOCKS5.Enabled = true
 	}
 
+	if opts.SOCKS5Host != "" {
+		cfg.SOCKS5.Enabled = true
+		cfg.SOCKS5.ListenHost = opts.SOCKS5Host
+	}
+	if opts.SOCKS5Username != "" || opts.SOCKS5Password != "" {
+		cfg.SOCKS5.Enabled = true
+		cfg.SOCKS5.Auth.Enabled = true
+		cfg.SOCKS5.Auth.Username = opts.SOCKS5Username
+		cfg.SOCKS5.Auth.Password = opts.SOCKS5Password
+	}
+
+	if opts.TLS != nil {
+		cfg.Client.Upstream.TLS.Enabled = *opts.TLS
+		cfg.Client.Downstream.TLS.Enabled = *opts.TLS
+	}
+	if opts.TLSSkipVerify {
+		cfg.Client.Upstream.TLS.SkipVerify = true
+		cfg.Client.Downstream.TLS.SkipVerify = true
+	}
+	if opts.CAFile != "" {
+		cfg.Client.Upstream.TLS.CAFile = opts.CAFile
+		cfg.Client.Downstream.TLS.CAFile = opts.CAFile
+	}
+
+	if opts.DNSPort > 0 {
+		cfg.DNS.Enabled = true
+		cfg.DNS.ListenPort = opts.DNSPort
+	}
+	if len(opts.DNSUpstreams) > 0 {
+		cfg.DNS.Enabled = true
+		cfg.DNS.UpstreamServers = opts.DNSUpstreams
+	}
+
+	if opts.Reconnect != nil {
+		cfg.Tunnel.Reconnect.Enabled = *opts.Reconnect
+	}
+	if opts.ReconnectInitialDelay > 0 {
+		cfg.Tunnel.Reconnect.InitialDelay = opts.ReconnectInitialDelay
+	}
+	if opts.ReconnectMaxDelay > 0 {
+		cfg.Tunnel.Reconnect.MaxDelay = opts.ReconnectMaxDelay
+	}
+	if opts.ReconnectMultiplier > 0 {
+		cfg.Tunnel.Reconnect.Multiplier = opts.ReconnectMultiplier
+	}
+	if opts.DialTimeout > 0 {
+		cfg.Tunnel.Connection.DialTimeout = opts.DialTimeout
+	}
+	if opts.KeepaliveInterval > 0 {
+		cfg.Tunnel.Connection.KeepaliveInterval = opts.KeepaliveInterval
+	}
+
+	applySharedOptions(&cfg.Tunnel.Encryption, &cfg.Logging, &cfg.Observability.Metrics, &cfg.Observability.Health, opts)
+	cfg.Client.PathToken.Secret = opts.PathSecret
+
+	if err := applyOverrides(cfg, opts.Overrides); err != nil {
+		return nil, err
+	}
 	return cfg, nil
 }
 
@@ -270,10 +369,81 @@ func (g *ConfigGenerator) generateServerConfigFromOptions(cfg *ServerConfig, opt
 		cfg.Server.Upstream.TLS.KeyFile = opts.TLSKey
 		cfg.Server.Downstream.TLS.KeyFile = opts.TLSKey
 	}
+	if opts.RandomPaths {
+		if err := randomizePaths(cfg); err != nil {
+			return nil, err
+		}
+	}
+	if opts.ListenHost != "" {
+		cfg.Server.Upstream.Host = opts.ListenHost
+		cfg.Server.Downstream.Host = opts.ListenHost
+	}
+	if opts.MaxSessions > 0 {
+		cfg.Tunnel.Session.MaxSessions = opts.MaxSessions
+	}
+	if opts.SessionTimeout > 0 {
+		cfg.Tunnel.Session.Timeout = opts.SessionTimeout
+	}
+	if opts.KeepaliveInterval > 0 {
+		cfg.Tunnel.Connection.KeepaliveInterval = opts.KeepaliveInterval
+	}
 
+	applySharedOptions(&cfg.Tunnel.Encryption, &cfg.Logging, &cfg.Observability.Metrics, &cfg.Observability.Health, opts)
+	cfg.Server.PathToken.Secret = opts.PathSecret
+
+	if err := applyOverrides(cfg, opts.Overrides); err != nil {
+		return nil, err
+	}
 	return cfg, nil
 }
 
+// applySharedOptions applies the options that client and server configs
+// have in common.
+func applySharedOptions(enc *EncryptionConfig, logging *LoggingConfig, metrics *MetricsConfig, health *HealthConfig, opts GenerateOptions) {
+	if opts.Encryption != nil {
+		enc.Enabled = *opts.Encryption
+	}
+	if opts.EncryptionAlgorithm != "" {
+		enc.Algorithm = opts.EncryptionAlgorithm
+	}
+	if opts.LogLevel != "" {
+		logging.Level = opts.LogLevel
+	}
+	if opts.LogFormat != "" {
+		logging.Format = opts.LogFormat
+	}
+	if opts.LogOutput != "" {
+		logging.Output = opts.LogOutput
+	}
+	if opts.Metrics != nil {
+		metrics.Enabled = *opts.Metrics
+	}
+	if opts.MetricsPort > 0 {
+		metrics.Enabled = true
+		metrics.Port = opts.MetricsPort
+	}
+	if opts.HealthPort > 0 {
+		health.Enabled = true
+		health.Port = opts.HealthPort
+	}
+}
+
+// randomizePaths replaces the default WebSocket paths with random ones so that
+// each deployment uses endpoint paths that cannot be guessed.
+func randomizePaths(cfg *ServerConfig) error {
+	upstreamPath, err := pathtoken.RandomPath("/ws")
+	if err != nil {
+		return fmt.Errorf("failed to generate upstream path: %w", err)
+	}
+	downstreamPath, err := pathtoken.RandomPath("/ws")
+	if err != nil {
+		return fmt.Errorf("failed to generate downstream path: %w", err)
+	}
+	cfg.Server.Upstream.Path = upstreamPath
+	cfg.Server.Downstream.Path = downstreamPath
+	return nil
+}
+
 // promptWithDefault prompts for input with a default value.
 func (g *ConfigGenerator) promptWithDefault(scanner *bufio.Scanner, prompt, defaultVal string) string {
 	if defaultVal != "" {
@@ -336,215 +506,12 @@ func WriteServerConfigToFile(cfg *ServerConfig, path string) error {
 
 // RenderClientConfigYAML renders client config as YAML.
 func RenderClientConfigYAML(cfg *ClientConfig) (string, error) {
-	tmpl := `# Half-Tunnel Client Configuration
-client:
-  name: "{{.Client.Name}}"
-  exit_on_port_in_use: {{.Client.ExitOnPortInUse}}
-  listen_on_connect: {{.Client.ListenOnConnect}}
-  upstream:
-    url: "{{.Client.Upstream.URL}}"
-    tls:
-      enabled: {{.Client.Upstream.TLS.Enabled}}
-      skip_verify: {{.Client.Upstream.TLS.SkipVerify}}
-{{- if .Client.Upstream.TLS.CAFile}}
-      ca_file: "{{.Client.Upstream.TLS.CAFile}}"
-{{- end}}
-  downstream:
-    url: "{{.Client.Downstream.URL}}"
-    tls:
-      enabled: {{.Client.Downstream.TLS.Enabled}}
-      skip_verify: {{.Client.Downstream.TLS.SkipVerify}}
-{{- if .Client.Downstream.TLS.CAFile}}
-      ca_file: "{{.Client.Downstream.TLS.CAFile}}"
-{{- end}}
-
-port_forwards:
-{{- range .PortForwardsRendered}}
-  - {{.}}
-{{- end}}
-{{- if not .PortForwardsRendered}}
-  # Add port forwards here
-  # Examples:
-  # - 2083                    # Forward port 2083
-  # - port: 443               # Forward port 443
-  # - listen_port: 8080
-  #   remote_host: "example.com"
-  #   remote_port: 80
-{{- end}}
-
-socks5:
-  enabled: {{.SOCKS5.Enabled}}
-  listen_host: "{{.SOCKS5.ListenHost}}"
-  listen_port: {{.SOCKS5.ListenPort}}
-  auth:
-    enabled: {{.SOCKS5.Auth.Enabled}}
-
-tunnel:
-  reconnect:
-    enabled: {{.Tunnel.Reconnect.Enabled}}
-    initial_delay: "{{.Tunnel.Reconnect.InitialDelay}}"
-    max_delay: "{{.Tunnel.Reconnect.MaxDelay}}"
-    multiplier: {{.Tunnel.Reconnect.Multiplier}}
-    jitter: {{.Tunnel.Reconnect.Jitter}}
-  connection:
-    read_buffer_size: {{.Tunnel.Connection.ReadBufferSize}}
-    write_buffer_size: {{.Tunnel.Connection.WriteBufferSize}}
-    keepalive_interval: "{{.Tunnel.Connection.KeepaliveInterval}}"
-    dial_timeout: "{{.Tunnel.Connection.DialTimeout}}"
-  encryption:
-    enabled: {{.Tunnel.Encryption.Enabled}}
-    algorithm: "{{.Tunnel.Encryption.Algorithm}}"
-
-dns:
-  enabled: {{.DNS.Enabled}}
-  listen_host: "{{.DNS.ListenHost}}"
-  listen_port: {{.DNS.ListenPort}}
-  upstream_servers:
-{{- range .DNS.UpstreamServers}}
-    - "{{.}}"
-{{- end}}
-
-logging:
-  level: "{{.Logging.Level}}"
-  format: "{{.Logging.Format}}"
-{{- if .Logging.Output}}
-  output: "{{.Logging.Output}}"
-{{- end}}
-
-observability:
-  metrics:
-    enabled: {{.Observability.Metrics.Enabled}}
-    port: {{.Observability.Metrics.Port}}
-    path: "{{.Observability.Metrics.Path}}"
-`
-
-	// Prepare port forwards for rendering
-	type renderData struct {
-		*ClientConfig
-		PortForwardsRendered []string
-	}
-	data := renderData{ClientConfig: cfg}
-
-	portForwards, err := cfg.GetPortForwards()
-	if err != nil {
-		return "", err
-	}
-
-	for _, pf := range portForwards {
-		if pf.RemoteHost == "127.0.0.1" && pf.ListenPort == pf.RemotePort && pf.ListenHost == "0.0.0.0" {
-			data.PortForwardsRendered = append(data.PortForwardsRendered, strconv.Itoa(pf.ListenPort))
-		} else {
-			var parts []string
-			if pf.Name != "" {
-				parts = append(parts, fmt.Sprintf("name: %q", pf.Name))
-			}
-			if pf.ListenHost != "0.0.0.0" {
-				parts = append(parts, fmt.Sprintf("listen_host: %q", pf.ListenHost))
-			}
-			parts = append(parts, fmt.Sprintf("listen_port: %d", pf.ListenPort))
-			if pf.RemoteHost != "" && pf.RemoteHost != "127.0.0.1" {
-				parts = append(parts, fmt.Sprintf("remote_host: %q", pf.RemoteHost))
-			}
-			if pf.RemotePort != pf.ListenPort {
-				parts = append(parts, fmt.Sprintf("remote_port: %d", pf.RemotePort))
-			}
-			data.PortForwardsRendered = append(data.PortForwardsRendered, "{"+strings.Join(parts, ", ")+"}")
-		}
-	}
-
-	t, err := template.New("client").Parse(tmpl)
-	if err != nil {
-		return "", err
-	}
-
-	var buf bytes.Buffer
-	if err := t.Execute(&buf, data); err != nil {
-		return "", err
-	}
-
-	return buf.String(), nil
+	return RenderClientConfig(cfg, FormatYAML)
 }
 
 // RenderServerConfigYAML renders server config as YAML.
 func RenderServerConfigYAML(cfg *ServerConfig) (string, error) {
-	tmpl := `# Half-Tunnel Server Configuration
-server:
-  name: "{{.Server.Name}}"
-  exit_on_port_in_use: {{.Server.ExitOnPortInUse}}
-  upstream:
-    host: "{{.Server.Upstream.Host}}"
-    port: {{.Server.Upstream.Port}}
-    path: "{{.Server.Upstream.Path}}"
-    tls:
-      enabled: {{.Server.Upstream.TLS.Enabled}}
-{{- if .Server.Upstream.TLS.CertFile}}
-      cert_file: "{{.Server.Upstream.TLS.CertFile}}"
-      key_file: "{{.Server.Upstream.TLS.KeyFile}}"
-{{- end}}
-  downstream:
-    host: "{{.Server.Downstream.Host}}"
-    port: {{.Server.Downstream.Port}}
-    path: "{{.Server.Downstream.Path}}"
-    tls:
-      enabled: {{.Server.Downstream.TLS.Enabled}}
-{{- if .Server.Downstream.TLS.CertFile}}
-      cert_file: "{{.Server.Downstream.TLS.CertFile}}"
-      key_file: "{{.Server.Downstream.TLS.KeyFile}}"
-{{- end}}
-
-access:
-  allowed_networks:
-{{- range .Access.AllowedNetworks}}
-    - "{{.}}"
-{{- end}}
-  blocked_networks:
-{{- range .Access.BlockedNetworks}}
-    - "{{.}}"
-{{- end}}
-  max_streams_per_session: {{.Access.MaxStreamsPerSession}}
-
-tunnel:
-  session:
-    timeout: "{{.Tunnel.Session.Timeout}}"
-    max_sessions: {{.Tunnel.Session.MaxSessions}}
-  connection:
-    read_buffer_size: {{.Tunnel.Connection.ReadBufferSize}}
-    write_buffer_size: {{.Tunnel.Connection.WriteBufferSize}}
-    keepalive_interval: "{{.Tunnel.Connection.KeepaliveInterval}}"
-    max_message_size: {{.Tunnel.Connection.MaxMessageSize}}
-  encryption:
-    enabled: {{.Tunnel.Encryption.Enabled}}
-    algorithm: "{{.Tunnel.Encryption.Algorithm}}"
-
-logging:
-  level: "{{.Logging.Level}}"
-  format: "{{.Logging.Format}}"
-{{- if .Logging.Output}}
-  output: "{{.Logging.Output}}"
-{{- end}}
-
-observability:
-  metrics:
-    enabled: {{.Observability.Metrics.Enabled}}
-    port: {{.Observability.Metrics.Port}}
-    path: "{{.Observability.Metrics.Path}}"
-  health:
-    enabled: {{.Observability.Health.Enabled}}
-    port: {{.Observability.Health.Port}}
-    path: "{{.Observability.Health.Path}}"
-`
-
-	t, err := template.New("server").Parse(tmpl)
-	if err != nil {
-		return "", err
-	}
-
-	var buf bytes.Buffer
-	if err := t.Execute(&buf, cfg); err != nil {
-		return "", err
-	}
-
-	return buf.String(), nil
+	return RenderServerConfig(cfg, FormatYAML)
 }
 
 // GetSampleClientConfig returns the sample client configuration as a string.
@@ -2,8 +2,10 @@ package config
 
 import (
 	"bytes"
+	"reflect"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestNewConfigGenerator(t *testing.T) {
@@ -60,6 +62,110 @@ func TestGenerateClientConfigFromOptions(t *testing.T) {
 	}
 }
 
+func TestGenerateClientConfigPortForwardRoundTrip(t *testing.T) {
+	gen := NewNonInteractiveGenerator()
+
+	cfg, err := gen.GenerateClientConfig(GenerateOptions{
+		UpstreamURL:   "wss://up.example.com/ws",
+		DownstreamURL: "wss://down.example.com/ws",
+		PortForwards:  []string{"2083", "127.0.0.1:8080:example.com:80", "5353/udp"},
+	})
+	if err != nil {
+		t.Fatalf("GenerateClientConfig() error = %v", err)
+	}
+	cfg.PortForwards = append(cfg.PortForwards, map[string]interface{}{
+		"listen_port": 5432,
+		"allow_from":  []interface{}{"10.0.0.0/8", "192.0.2.7"},
+	})
+	cfg.SOCKS5.AllowFrom = []string{"127.0.0.1"}
+
+	configPath := t.TempDir() + "/client.yml"
+	if err := WriteClientConfigToFile(cfg, configPath); err != nil {
+		t.Fatalf("WriteClientConfigToFile() error = %v", err)
+	}
+	loaded, err := LoadClientConfig(configPath)
+	if err != nil {
+		t.Fatalf("LoadClientConfig() error = %v", err)
+	}
+	forwards, err := loaded.GetPortForwards()
+	if err != nil {
+		t.Fatalf("GetPortForwards() error = %v", err)
+	}
+
+	want := []PortForward{
+		{ListenHost: "0.0.0.0", ListenPort: 2083, RemoteHost: "127.0.0.1", RemotePort: 2083, Protocol: "tcp"},
+		{ListenHost: "127.0.0.1", ListenPort: 8080, RemoteHost: "example.com", RemotePort: 80, Protocol: "tcp"},
+		{ListenHost: "0.0.0.0", ListenPort: 5353, RemoteHost: "127.0.0.1", RemotePort: 5353, Protocol: "udp"},
+		{ListenHost: "0.0.0.0", ListenPort: 5432, RemoteHost: "127.0.0.1", RemotePort: 5432, Protocol: "tcp",
+			AllowFrom: []string{"10.0.0.0/8", "192.0.2.7"}},
+	}
+	if len(forwards) != len(want) {
+		t.Fatalf("Expected %d port forwards, got %+v", len(want), forwards)
+	}
+	for i := range want {
+		if !reflect.DeepEqual(forwards[i], want[i]) {
+			t.Errorf("Port forward %d = %+v, want %+v", i, forwards[i], want[i])
+		}
+	}
+	if !reflect.DeepEqual(loaded.SOCKS5.AllowFrom, []string{"127.0.0.1"}) {
+		t.Errorf("Expected SOCKS5 allow_from to round-trip, got %v", loaded.SOCKS5.AllowFrom)
+	}
+}
+
+func TestGenerateClientConfigAllOptions(t *testing.T) {
+	gen := NewNonInteractiveGenerator()
+	disabled := false
+
+	cfg, err := gen.GenerateClientConfig(GenerateOptions{
+		SOCKS5Username:      "user",
+		SOCKS5Password:      "p\"ss",
+		TLSSkipVerify:       true,
+		DNSUpstreams:        []string{"9.9.9.9:53"},
+		Encryption:          &disabled,
+		ReconnectMaxDelay:   2 * time.Minute,
+		ReconnectMultiplier: 1.5,
+		MetricsPort:         9191,
+		HealthPort:          8181,
+		LogLevel:            "debug",
+		Overrides:           []Override{{Key: "tunnel.reconnect.jitter", Value: "0.3"}},
+	})
+	if err != nil {
+		t.Fatalf("GenerateClientConfig() error = %v", err)
+	}
+
+	// Every option survives a render/load round trip
+	configPath := t.TempDir() + "/client.yml"
+	if err := WriteClientConfigToFile(cfg, configPath); err != nil {
+		t.Fatalf("WriteClientConfigToFile() error = %v", err)
+	}
+	loaded, err := LoadClientConfig(configPath)
+	if err != nil {
+		t.Fatalf("LoadClientConfig() error = %v", err)
+	}
+
+	if !loaded.SOCKS5.Enabled || !loaded.SOCKS5.Auth.Enabled || loaded.SOCKS5.Auth.Password != "p\"ss" {
+		t.Errorf("Expected SOCKS5 with authentication, got %+v", loaded.SOCKS5)
+	}
+	if !loaded.Client.Upstream.TLS.SkipVerify || !loaded.Client.Downstream.TLS.SkipVerify {
+		t.Error("Expected skip_verify on both paths")
+	}
+	if !loaded.DNS.Enabled || !reflect.DeepEqual(loaded.DNS.UpstreamServers, []string{"9.9.9.9:53"}) {
+		t.Errorf("Expected DNS forwarding to 9.9.9.9:53, got %+v", loaded.DNS)
+	}
+	if loaded.Tunnel.Encryption.Enabled {
+		t.Error("Expected encryption to be disabled")
+	}
+	if loaded.Tunnel.Reconnect.MaxDelay != 2*time.Minute || loaded.Tunnel.Reconnect.Multiplier != 1.5 || loaded.Tunnel.Reconnect.Jitter != 0.3 {
+		t.Errorf("Expected reconnect tuning to round-trip, got %+v", loaded.Tunnel.Reconnect)
+	}
+	if loaded.Observability.Metrics.Port != 9191 || !loaded.Observability.Health.Enabled || loaded.Observability.Health.Port != 8181 {
+		t.Errorf("Expected metrics on 9191 and health on 8181, got %+v", loaded.Observability)
+	}
+	if loaded.Logging.Level != "debug" {
+		t.Errorf("Expected log level debug, got %s", loaded.Logging.Level)
+	}
+}
+
 func TestGenerateServerConfigFromOptions(t *testing.T) {
 	gen := NewNonInteractiveGenerator()
 
@@ -90,6 +196,39 @@ func TestGenerateServerConfigFromOptions(t *testing.T) {
 	}
 }
 
+func TestGenerateServerConfigRandomPaths(t *testing.T) {
+	gen := NewNonInteractiveGenerator()
+
+	cfg, err := gen.GenerateServerConfig(GenerateOptions{RandomPaths: true, PathSecret: "path-secret"})
+	if err != nil {
+		t.Fatalf("GenerateServerConfig() error = %v", err)
+	}
+
+	defaults := DefaultServerConfig()
+	if cfg.Server.Upstream.Path == defaults.Server.Upstream.Path || !strings.HasPrefix(cfg.Server.Upstream.Path, "/ws/") {
+		t.Errorf("Expected random upstream path, got %s", cfg.Server.Upstream.Path)
+	}
+	if cfg.Server.Upstream.Path == cfg.Server.Downstream.Path {
+		t.Error("Expected upstream and downstream paths to differ")
+	}
+
+	// Paths and path token survive a render/load round trip
+	configPath := t.TempDir() + "/server.yml"
+	if err := WriteServerConfigToFile(cfg, configPath); err != nil {
+		t.Fatalf("WriteServerConfigToFile() error = %v", err)
+	}
+	loaded, err := LoadServerConfig(configPath)
+	if err != nil {
+		t.Fatalf("LoadServerConfig() error = %v", err)
+	}
+	if loaded.Server.Upstream.Path != cfg.Server.Upstream.Path {
+		t.Errorf("Upstream path = %s, want %s", loaded.Server.Upstream.Path, cfg.Server.Upstream.Path)
+	}
+	if loaded.Server.PathToken.Secret != "path-secret" || loaded.Server.PathToken.Window != cfg.Server.PathToken.Window {
+		t.Errorf("Unexpected path token after load: %+v", loaded.Server.PathToken)
+	}
+}
+
 func TestRenderClientConfigYAML(t *testing.T) {
 	cfg := DefaultClientConfig()
 	cfg.PortForwards = []interface{}{2083, map[string]interface{}{"port": 443}}
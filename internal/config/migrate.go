@@ -0,0 +1,335 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-viper/mapstructure/v2"
+	"github.com/spf13/viper"
+)
+
+// Kinds of change made by a migration.
+const (
+	ChangeRenamed = "renamed"
+	ChangeRemoved = "removed"
+	ChangeAdded   = "added"
+)
+
+// MigrationChange is one change a migration makes to a config file.
+type MigrationChange struct {
+	Kind string
+	Key  string
+	// To lists the keys a renamed key was moved to
+	To []string
+	// Note explains a removal or holds the default of an added key
+	Note string
+}
+
+func (c MigrationChange) String() string {
+	switch c.Kind {
+	case ChangeRenamed:
+		s := fmt.Sprintf("renamed %s -> %s", c.Key, strings.Join(c.To, ", "))
+		if c.Note != "" {
+			s += " (" + c.Note + ")"
+		}
+		return s
+	case ChangeRemoved:
+		return fmt.Sprintf("removed %s (%s)", c.Key, c.Note)
+	default:
+		return fmt.Sprintf("added %s: %s", c.Key, c.Note)
+	}
+}
+
+// MigrationResult is the outcome of migrating a config file.
+type MigrationResult struct {
+	Changes []MigrationChange
+	// Content is the normalized config
+	Content string
+	// Changed reports whether Content differs from the file
+	Changed bool
+}
+
+// keyRename moves a deprecated key to its replacements.
+type keyRename struct {
+	from string
+	to   []string
+	// convert maps the old value to one value per new key; nil copies it
+	convert func(value interface{}) ([]interface{}, error)
+	note    string
+}
+
+// Keys of the combined legacy configuration (see Config) and where their
+// values live in the client and server configurations.
+var (
+	clientRenames = []keyRename{
+		{from: "client.upstream_url", to: []string{"client.upstream.url"}},
+		{from: "client.downstream_url", to: []string{"client.downstream.url"}},
+		{from: "client.listen_addr", to: []string{"socks5.listen_host", "socks5.listen_port"}, convert: splitAddr("127.0.0.1")},
+		{from: "client.tls.enabled", to: []string{"client.upstream.tls.enabled", "client.downstream.tls.enabled"}},
+		{from: "client.tls.insecure_skip_verify", to: []string{"client.upstream.tls.skip_verify", "client.downstream.tls.skip_verify"}},
+		{from: "client.tls.ca_file", to: []string{"client.upstream.tls.ca_file", "client.downstream.tls.ca_file"}},
+		{from: "client.tls.cert_file", to: []string{"client.upstream.tls.cert_file", "client.downstream.tls.cert_file"}},
+		{from: "client.tls.key_file", to: []string{"client.upstream.tls.key_file", "client.downstream.tls.key_file"}},
+		{from: "client.connection.ping_interval", to: []string{"tunnel.connection.keepalive_interval"}},
+		{from: "client.connection.write_timeout", to: []string{"tunnel.connection.dial_timeout"}, note: "now also the dial timeout"},
+		{from: "client.connection.pong_timeout", note: "keepalive timeouts are derived from the keepalive interval"},
+		{from: "client.connection.read_timeout", note: "idle paths are detected by keepalives"},
+		{from: "client.connection.reconnect.enabled", to: []string{"tunnel.reconnect.enabled"}},
+		{from: "client.connection.reconnect.initial_delay", to: []string{"tunnel.reconnect.initial_delay"}},
+		{from: "client.connection.reconnect.max_delay", to: []string{"tunnel.reconnect.max_delay"}},
+		{from: "client.connection.reconnect.max_attempts", note: "the client retries until stopped"},
+		{from: "log.level", to: []string{"logging.level"}},
+		{from: "log.format", to: []string{"logging.format"}},
+		{from: "log.output", to: []string{"logging.output"}},
+	}
+
+	serverRenames = []keyRename{
+		{from: "server.upstream_addr", to: []string{"server.upstream.host", "server.upstream.port"}, convert: splitAddr("0.0.0.0")},
+		{from: "server.downstream_addr", to: []string{"server.downstream.host", "server.downstream.port"}, convert: splitAddr("0.0.0.0")},
+		{from: "server.tls.enabled", to: []string{"server.upstream.tls.enabled", "server.downstream.tls.enabled"}},
+		{from: "server.tls.cert_file", to: []string{"server.upstream.tls.cert_file", "server.downstream.tls.cert_file"}},
+		{from: "server.tls.key_file", to: []string{"server.upstream.tls.key_file", "server.downstream.tls.key_file"}},
+		{from: "server.tls.ca_file", to: []string{"server.upstream.tls.client_ca_file", "server.downstream.tls.client_ca_file"}},
+		{from: "server.tls.insecure_skip_verify", note: "has no meaning on the server"},
+		{from: "server.session.idle_timeout", to: []string{"tunnel.session.timeout"}},
+		{from: "server.session.max_sessions", to: []string{"tunnel.session.max_sessions"}},
+		{from: "server.session.max_streams_per_session", to: []string{"access.max_streams_per_session"}},
+		{from: "log.level", to: []string{"logging.level"}},
+		{from: "log.format", to: []string{"logging.format"}},
+		{from: "log.output", to: []string{"logging.output"}},
+	}
+)
+
+// splitAddr converts a legacy "host:port" listen address into a host and a
+// port, using defaultHost for an address like ":8080".
+func splitAddr(defaultHost string) func(interface{}) ([]interface{}, error) {
+	return func(value interface{}) ([]interface{}, error) {
+		host, portStr, err := net.SplitHostPort(fmt.Sprint(value))
+		if err != nil {
+			return nil, err
+		}
+		port, err := strconv.Atoi(portStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid port: %s", portStr)
+		}
+		if host == "" {
+			host = defaultHost
+		}
+		return []interface{}{host, port}, nil
+	}
+}
+
+// MigrateClientConfig reads a client config file and returns it with
+// deprecated keys renamed, unknown keys removed and every setting written
+// out, defaults included, in the order of the schema. The result is in the
+// format of the file.
+func MigrateClientConfig(path string) (*MigrationResult, error) {
+	var cfg ClientConfig
+	return migrate(path, clientRenames, setClientDefaults, &cfg)
+}
+
+// MigrateServerConfig is MigrateClientConfig for server configs.
+func MigrateServerConfig(path string) (*MigrationResult, error) {
+	var cfg ServerConfig
+	return migrate(path, serverRenames, setServerDefaults, &cfg)
+}
+
+func migrate(path string, renames []keyRename, setDefaults func(*viper.Viper), cfg interface{}) (*MigrationResult, error) {
+	original, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	in := viper.New()
+	in.SetConfigFile(path)
+	in.SetConfigType(FormatFromPath(path))
+	if err := in.ReadInConfig(); err != nil {
+		return nil, fmt.Errorf("error reading config: %w", err)
+	}
+	settings := in.AllSettings()
+
+	result := &MigrationResult{}
+	provided := make(map[string]bool)
+	for _, key := range in.AllKeys() {
+		provided[key] = true
+	}
+
+	for _, r := range renames {
+		value, ok := lookupKey(settings, r.from)
+		if !ok {
+			continue
+		}
+		deleteKey(settings, r.from)
+		if len(r.to) == 0 {
+			result.Changes = append(result.Changes, MigrationChange{Kind: ChangeRemoved, Key: r.from, Note: r.note})
+			continue
+		}
+
+		values := []interface{}{value}
+		if r.convert != nil {
+			if values, err = r.convert(value); err != nil {
+				return nil, fmt.Errorf("cannot migrate %s: %w", r.from, err)
+			}
+		}
+		var moved []string
+		for i, to := range r.to {
+			v := values[0]
+			if i < len(values) {
+				v = values[i]
+			}
+			// A value already set under the new key wins
+			if _, exists := lookupKey(settings, to); exists {
+				continue
+			}
+			setKey(settings, to, v)
+			provided[to] = true
+			moved = append(moved, to)
+		}
+		note := r.note
+		if len(moved) == 0 {
+			note = "already set under the new key"
+		}
+		result.Changes = append(result.Changes, MigrationChange{Kind: ChangeRenamed, Key: r.from, To: r.to, Note: note})
+	}
+
+	// References to environment variables and files are written back as
+	// they are; only their values are needed to decode the file
+	raw := copySettings(settings)
+	(&resolver{keepReferences: true}).resolveMap(settings, reflect.TypeOf(cfg).Elem(), "")
+
+	v := viper.New()
+	setDefaults(v)
+	if err := v.MergeConfigMap(settings); err != nil {
+		return nil, err
+	}
+	var md mapstructure.Metadata
+	if err := v.Unmarshal(cfg, func(dc *mapstructure.DecoderConfig) {
+		dc.Metadata = &md
+	}); err != nil {
+		return nil, fmt.Errorf("error unmarshaling config: %w", err)
+	}
+	sort.Strings(md.Unused)
+	for _, key := range md.Unused {
+		result.Changes = append(result.Changes, MigrationChange{Kind: ChangeRemoved, Key: key, Note: "unknown key"})
+	}
+
+	format := FormatFromPath(path)
+	if result.Content, err = encodeConfig(cfg, raw, format); err != nil {
+		return nil, err
+	}
+	result.Changed = result.Content != string(original)
+
+	// Report the settings the file did not have that default to something
+	// other than an empty value
+	out := viper.New()
+	out.SetConfigType(format)
+	if err := out.ReadConfig(strings.NewReader(result.Content)); err != nil {
+		return nil, fmt.Errorf("failed to read migrated config: %w", err)
+	}
+	keys := out.AllKeys()
+	sort.Strings(keys)
+	for _, key := range keys {
+		if provided[key] || hasProvidedParent(provided, key) {
+			continue
+		}
+		value := fmt.Sprint(out.Get(key))
+		switch value {
+		case "", "false", "0", "0s", "[]", "map[]":
+			continue
+		}
+		result.Changes = append(result.Changes, MigrationChange{Kind: ChangeAdded, Key: key, Note: value})
+	}
+
+	return result, nil
+}
+
+// hasProvidedParent reports whether a parent of key was given as a whole,
+// for example a list of rules.
+func hasProvidedParent(provided map[string]bool, key string) bool {
+	for i := strings.LastIndex(key, "."); i > 0; i = strings.LastIndex(key[:i], ".") {
+		if provided[key[:i]] {
+			return true
+		}
+	}
+	return false
+}
+
+// lookupKey returns the value of a dotted key in nested settings.
+func lookupKey(settings map[string]interface{}, key string) (interface{}, bool) {
+	parts := strings.Split(key, ".")
+	m := settings
+	for _, part := range parts[:len(parts)-1] {
+		next, ok := m[part].(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		m = next
+	}
+	value, ok := m[parts[len(parts)-1]]
+	return value, ok
+}
+
+// setKey sets a dotted key in nested settings, adding maps as needed.
+func setKey(settings map[string]interface{}, key string, value interface{}) {
+	parts := strings.Split(key, ".")
+	m := settings
+	for _, part := range parts[:len(parts)-1] {
+		next, ok := m[part].(map[string]interface{})
+		if !ok {
+			next = make(map[string]interface{})
+			m[part] = next
+		}
+		m = next
+	}
+	m[parts[len(parts)-1]] = value
+}
+
+// deleteKey removes a dotted key from nested settings along with any map
+// left empty by the removal.
+func deleteKey(settings map[string]interface{}, key string) {
+	parts := strings.SplitN(key, ".", 2)
+	if len(parts) == 1 {
+		delete(settings, key)
+		return
+	}
+	next, ok := settings[parts[0]].(map[string]interface{})
+	if !ok {
+		return
+	}
+	deleteKey(next, parts[1])
+	if len(next) == 0 {
+		delete(settings, parts[0])
+	}
+}
+
+// copySettings returns a deep copy of nested settings.
+func copySettings(settings map[string]interface{}) map[string]interface{} {
+	c, _ := copyValue(settings).(map[string]interface{})
+	return c
+}
+
+func copyValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		c := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			c[key] = copyValue(item)
+		}
+		return c
+	case []interface{}:
+		c := make([]interface{}, len(v))
+		for i, item := range v {
+			c[i] = copyValue(item)
+		}
+		return c
+	case []string:
+		return append([]string(nil), v...)
+	default:
+		return value
+	}
+}
@@ -0,0 +1,140 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.yaml.in/yaml/v3"
+)
+
+func TestMigrateLegacyClientConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "client.yml")
+	legacy := `client:
+  upstream_url: "wss://a.example.com/up"
+  downstream_url: "wss://b.example.com/down"
+  listen_addr: "127.0.0.1:1081"
+  tls:
+    insecure_skip_verify: true
+  connection:
+    ping_interval: 15s
+    reconnect:
+      max_delay: 30s
+      max_attempts: 3
+  unknown_setting: 1
+log:
+  level: debug
+`
+	if err := os.WriteFile(path, []byte(legacy), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := MigrateClientConfig(path)
+	if err != nil {
+		t.Fatalf("MigrateClientConfig() error = %v", err)
+	}
+	if !result.Changed {
+		t.Error("Expected a legacy config to change")
+	}
+
+	changes := make(map[string]string)
+	for _, c := range result.Changes {
+		changes[c.Key] = c.Kind
+	}
+	for key, kind := range map[string]string{
+		"client.upstream_url":                      ChangeRenamed,
+		"client.listen_addr":                       ChangeRenamed,
+		"client.connection.reconnect.max_attempts": ChangeRemoved,
+		"client.unknown_setting":                   ChangeRemoved,
+		"tunnel.encryption.algorithm":              ChangeAdded,
+	} {
+		if changes[key] != kind {
+			t.Errorf("Expected %s to be %s, got %q", key, kind, changes[key])
+		}
+	}
+	if _, ok := changes["client.upstream.url"]; ok {
+		t.Error("Expected a renamed key not to be reported as added")
+	}
+
+	if err := os.WriteFile(path, []byte(result.Content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := LoadClientConfig(path)
+	if err != nil {
+		t.Fatalf("LoadClientConfig() error = %v", err)
+	}
+	if cfg.Client.Upstream.URL != "wss://a.example.com/up" || cfg.Client.Downstream.URL != "wss://b.example.com/down" {
+		t.Errorf("Expected URLs to be migrated, got %s and %s", cfg.Client.Upstream.URL, cfg.Client.Downstream.URL)
+	}
+	if cfg.SOCKS5.ListenHost != "127.0.0.1" || cfg.SOCKS5.ListenPort != 1081 {
+		t.Errorf("Expected SOCKS5 on 127.0.0.1:1081, got %s", cfg.SOCKS5.Addr())
+	}
+	if !cfg.Client.Upstream.TLS.SkipVerify || !cfg.Client.Downstream.TLS.SkipVerify {
+		t.Error("Expected skip_verify on both paths")
+	}
+	if cfg.Tunnel.Connection.KeepaliveInterval != 15*time.Second || cfg.Tunnel.Reconnect.MaxDelay != 30*time.Second {
+		t.Errorf("Expected keepalive 15s and max delay 30s, got %v and %v", cfg.Tunnel.Connection.KeepaliveInterval, cfg.Tunnel.Reconnect.MaxDelay)
+	}
+	if cfg.Logging.Level != "debug" {
+		t.Errorf("Expected log level debug, got %s", cfg.Logging.Level)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Expected migrated config to be valid: %v", err)
+	}
+
+	// Migrating again changes nothing
+	again, err := MigrateClientConfig(path)
+	if err != nil {
+		t.Fatalf("MigrateClientConfig() error = %v", err)
+	}
+	if again.Changed || len(again.Changes) != 0 {
+		t.Errorf("Expected a migrated config to be up to date, got %v", again.Changes)
+	}
+}
+
+func TestMigrateKeepsSettings(t *testing.T) {
+	tests := []struct {
+		name    string
+		path    string
+		migrate func(string) (*MigrationResult, error)
+		load    func(string) (interface{}, error)
+	}{
+		{"client", "../../configs/client.yml", MigrateClientConfig, func(p string) (interface{}, error) { return LoadClientConfig(p) }},
+		{"server", "../../configs/server.yml", MigrateServerConfig, func(p string) (interface{}, error) { return LoadServerConfig(p) }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := tt.migrate(tt.path)
+			if err != nil {
+				t.Fatalf("migrate error = %v", err)
+			}
+			for _, c := range result.Changes {
+				if c.Kind != ChangeAdded {
+					t.Errorf("Unexpected change to the sample config: %s", c)
+				}
+			}
+
+			migrated := filepath.Join(t.TempDir(), tt.name+".yml")
+			if err := os.WriteFile(migrated, []byte(result.Content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			want, err := tt.load(tt.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := tt.load(migrated)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Compare the encoded settings, which do not tell nil and empty
+			// lists apart
+			gotYAML, _ := yaml.Marshal(got)
+			wantYAML, _ := yaml.Marshal(want)
+			if string(gotYAML) != string(wantYAML) {
+				t.Errorf("Expected the migrated config to load the same settings\ngot:\n%s\nwant:\n%s", gotYAML, wantYAML)
+			}
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/go-viper/mapstructure/v2"
+	"github.com/spf13/viper"
+)
+
+// Override sets a single configuration key, given as "key=value" with a
+// dotted key such as "tunnel.reconnect.max_delay=2m". Values are converted
+// the same way as values read from a config file: durations like "30s",
+// booleans and numbers are parsed, and comma-separated values fill lists.
+type Override struct {
+	Key   string
+	Value string
+}
+
+// ParseOverrides parses "key=value" override specifications.
+func ParseOverrides(specs []string) ([]Override, error) {
+	overrides := make([]Override, 0, len(specs))
+	for _, spec := range specs {
+		key, value, ok := strings.Cut(spec, "=")
+		key = strings.ToLower(strings.TrimSpace(key))
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid override %q (use key=value)", spec)
+		}
+		for _, part := range strings.Split(key, ".") {
+			if part == "" {
+				return nil, fmt.Errorf("invalid override key %q", key)
+			}
+		}
+		overrides = append(overrides, Override{Key: key, Value: value})
+	}
+	return overrides, nil
+}
+
+// applyOverrides sets the overridden keys on cfg, a pointer to a
+// ClientConfig or ServerConfig. Keys that do not exist in the schema and
+// values that cannot be converted are reported as errors.
+func applyOverrides(cfg interface{}, overrides []Override) error {
+	for _, o := range overrides {
+		v := viper.New()
+		v.Set(o.Key, o.Value)
+		var md mapstructure.Metadata
+		if err := v.Unmarshal(cfg, func(dc *mapstructure.DecoderConfig) {
+			dc.Metadata = &md
+		}); err != nil {
+			return fmt.Errorf("invalid value %q for %s", o.Value, o.Key)
+		}
+		if len(md.Unused) > 0 {
+			return fmt.Errorf("unknown config key: %s", o.Key)
+		}
+	}
+	return nil
+}
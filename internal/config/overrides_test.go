@@ -0,0 +1,100 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseOverrides(t *testing.T) {
+	overrides, err := ParseOverrides([]string{"Tunnel.Reconnect.Jitter=0.2", "client.auth_token=a=b"})
+	if err != nil {
+		t.Fatalf("ParseOverrides() error = %v", err)
+	}
+	if overrides[0].Key != "tunnel.reconnect.jitter" || overrides[0].Value != "0.2" {
+		t.Errorf("Unexpected override: %+v", overrides[0])
+	}
+	if overrides[1].Value != "a=b" {
+		t.Errorf("Expected value a=b, got %s", overrides[1].Value)
+	}
+
+	for _, spec := range []string{"novalue", "=1", "tunnel..jitter=1"} {
+		if _, err := ParseOverrides([]string{spec}); err == nil {
+			t.Errorf("Expected error for %q", spec)
+		}
+	}
+}
+
+func TestApplyOverrides(t *testing.T) {
+	cfg := DefaultServerConfig()
+	err := applyOverrides(cfg, []Override{
+		{Key: "tunnel.session.timeout", Value: "10m"},
+		{Key: "access.policy.blocked_ports", Value: "25,465"},
+		{Key: "server.upstream.tls.enabled", Value: "true"},
+	})
+	if err != nil {
+		t.Fatalf("applyOverrides() error = %v", err)
+	}
+	if cfg.Tunnel.Session.Timeout != 10*time.Minute {
+		t.Errorf("Expected timeout 10m, got %v", cfg.Tunnel.Session.Timeout)
+	}
+	if len(cfg.Access.Policy.BlockedPorts) != 2 || cfg.Access.Policy.BlockedPorts[1] != 465 {
+		t.Errorf("Expected blocked ports [25 465], got %v", cfg.Access.Policy.BlockedPorts)
+	}
+	if !cfg.Server.Upstream.TLS.Enabled {
+		t.Error("Expected upstream TLS to be enabled")
+	}
+	// Untouched settings keep their defaults
+	if cfg.Server.Upstream.Port != DefaultServerConfig().Server.Upstream.Port {
+		t.Errorf("Expected default upstream port, got %d", cfg.Server.Upstream.Port)
+	}
+
+	if err := applyOverrides(cfg, []Override{{Key: "server.bogus", Value: "1"}}); err == nil || !strings.Contains(err.Error(), "unknown config key") {
+		t.Errorf("Expected unknown key error, got %v", err)
+	}
+	if err := applyOverrides(cfg, []Override{{Key: "tunnel.session.max_sessions", Value: "many"}}); err == nil {
+		t.Error("Expected error for a non-numeric value")
+	}
+}
+
+func TestOverridesAreRendered(t *testing.T) {
+	overrides := []Override{
+		{Key: "routing.default", Value: "direct"},
+		{Key: "dns.upstream_servers", Value: "9.9.9.9:53, 1.1.1.1:53"},
+		{Key: "tunnel.reconnect.max_delay", Value: "2m"},
+	}
+	cfg, err := NewNonInteractiveGenerator().GenerateClientConfig(GenerateOptions{Overrides: overrides})
+	if err != nil {
+		t.Fatalf("GenerateClientConfig() error = %v", err)
+	}
+	content, err := RenderClientConfigYAML(cfg)
+	if err != nil {
+		t.Fatalf("RenderClientConfigYAML() error = %v", err)
+	}
+	if !strings.HasPrefix(content, "# Half-Tunnel Client Configuration") {
+		t.Errorf("Expected the header comment, got:\n%s", content)
+	}
+
+	path := filepath.Join(t.TempDir(), "client.yml")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadClientConfig(path)
+	if err != nil {
+		t.Fatalf("LoadClientConfig() error = %v", err)
+	}
+	if loaded.Routing.Default != "direct" {
+		t.Errorf("Expected routing default direct, got %s", loaded.Routing.Default)
+	}
+	if len(loaded.DNS.UpstreamServers) != 2 || loaded.DNS.UpstreamServers[0] != "9.9.9.9:53" {
+		t.Errorf("Expected two DNS upstreams, got %v", loaded.DNS.UpstreamServers)
+	}
+	if loaded.Tunnel.Reconnect.MaxDelay != 2*time.Minute {
+		t.Errorf("Expected max delay 2m, got %v", loaded.Tunnel.Reconnect.MaxDelay)
+	}
+	if loaded.SOCKS5.ListenPort != cfg.SOCKS5.ListenPort {
+		t.Errorf("Expected SOCKS5 port %d to be kept, got %d", cfg.SOCKS5.ListenPort, loaded.SOCKS5.ListenPort)
+	}
+}
@@ -0,0 +1,198 @@
+package config
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/sahmadiut/half-tunnel/internal/clientauth"
+	"github.com/sahmadiut/half-tunnel/pkg/crypto"
+)
+
+// PairOptions holds options for generating a client config together with
+// the server config it connects to.
+type PairOptions struct {
+	// UpstreamHost and DownstreamHost are the public names the client
+	// dials (domain A and domain B). DownstreamHost defaults to
+	// UpstreamHost.
+	UpstreamHost   string
+	DownstreamHost string
+	UpstreamPort   int
+	DownstreamPort int
+
+	TLS     bool
+	TLSCert string
+	TLSKey  string
+
+	RandomPaths bool
+	// PathTokens generates a shared secret for rotating path tokens
+	PathTokens bool
+	// ClientAuth generates a client token and registers its hash with the
+	// server
+	ClientAuth bool
+
+	ClientName          string
+	ServerName          string
+	EncryptionAlgorithm string
+	PortForwards        []string
+	SOCKS5Port          int
+}
+
+// DefaultPairOptions returns the options of a pair that uses TLS, random
+// paths, path tokens and a client token.
+func DefaultPairOptions() PairOptions {
+	server := DefaultServerConfig()
+	return PairOptions{
+		UpstreamPort:   server.Server.Upstream.Port,
+		DownstreamPort: server.Server.Downstream.Port,
+		TLS:            true,
+		TLSCert:        "/etc/half-tunnel/certs/server.crt",
+		TLSKey:         "/etc/half-tunnel/certs/server.key",
+		RandomPaths:    true,
+		PathTokens:     true,
+		ClientAuth:     true,
+		SOCKS5Port:     DefaultClientConfig().SOCKS5.ListenPort,
+	}
+}
+
+// ConfigPair is a client config and the server config it connects to.
+type ConfigPair struct {
+	Client *ClientConfig
+	Server *ServerConfig
+	// ClientToken is the client's token, empty without client auth
+	ClientToken string
+}
+
+// GeneratePair generates a client and a server config with matching ports,
+// paths, TLS, secrets and encryption settings.
+func (g *ConfigGenerator) GeneratePair(opts PairOptions) (*ConfigPair, error) {
+	if g.isInteractive {
+		opts = g.promptPairOptions(opts)
+	}
+	return generatePair(opts)
+}
+
+// promptPairOptions asks for the pair settings, offering opts as defaults.
+func (g *ConfigGenerator) promptPairOptions(opts PairOptions) PairOptions {
+	scanner := bufio.NewScanner(g.reader)
+
+	g.printLine("\n🔧 Half-Tunnel Client and Server Configuration Generator\n")
+
+	g.printLine("\n🌐 Server Addresses\n")
+	opts.UpstreamHost = g.promptWithDefault(scanner, "Upstream host the client connects to (domain A)", opts.UpstreamHost)
+	downstreamDefault := opts.DownstreamHost
+	if downstreamDefault == "" {
+		downstreamDefault = opts.UpstreamHost
+	}
+	opts.DownstreamHost = g.promptWithDefault(scanner, "Downstream host the client connects to (domain B)", downstreamDefault)
+	if port, err := strconv.Atoi(g.promptWithDefault(scanner, "Upstream port", strconv.Itoa(opts.UpstreamPort))); err == nil {
+		opts.UpstreamPort = port
+	}
+	if port, err := strconv.Atoi(g.promptWithDefault(scanner, "Downstream port", strconv.Itoa(opts.DownstreamPort))); err == nil {
+		opts.DownstreamPort = port
+	}
+
+	g.printLine("\n🔒 Security\n")
+	opts.TLS = g.promptYesNo(scanner, "Enable TLS?", opts.TLS)
+	if opts.TLS {
+		opts.TLSCert = g.promptWithDefault(scanner, "TLS certificate path on the server", opts.TLSCert)
+		opts.TLSKey = g.promptWithDefault(scanner, "TLS key path on the server", opts.TLSKey)
+	}
+	opts.RandomPaths = g.promptYesNo(scanner, "Use random WebSocket paths?", opts.RandomPaths)
+	opts.PathTokens = g.promptYesNo(scanner, "Rotate WebSocket path tokens?", opts.PathTokens)
+	opts.ClientAuth = g.promptYesNo(scanner, "Require a client token?", opts.ClientAuth)
+
+	g.printLine("\n📡 Client\n")
+	forwards := g.promptWithDefault(scanner, "Port forwards, comma-separated (e.g. 2083,8080:example.com:80)", strings.Join(opts.PortForwards, ","))
+	opts.PortForwards = nil
+	for _, pf := range strings.Split(forwards, ",") {
+		if pf = strings.TrimSpace(pf); pf != "" {
+			opts.PortForwards = append(opts.PortForwards, pf)
+		}
+	}
+	if port, err := strconv.Atoi(g.promptWithDefault(scanner, "SOCKS5 port (0 to disable)", strconv.Itoa(opts.SOCKS5Port))); err == nil {
+		opts.SOCKS5Port = port
+	}
+
+	return opts
+}
+
+// generatePair builds the pair from complete options.
+func generatePair(opts PairOptions) (*ConfigPair, error) {
+	if opts.UpstreamHost == "" {
+		return nil, fmt.Errorf("upstream host is required")
+	}
+	if opts.DownstreamHost == "" {
+		opts.DownstreamHost = opts.UpstreamHost
+	}
+	if opts.TLS && (opts.TLSCert == "" || opts.TLSKey == "") {
+		return nil, fmt.Errorf("TLS needs a certificate and key path")
+	}
+
+	var pathSecret string
+	if opts.PathTokens {
+		key, err := crypto.GenerateKey(32)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate path secret: %w", err)
+		}
+		pathSecret = hex.EncodeToString(key)
+	}
+
+	g := NewNonInteractiveGenerator()
+	serverOpts := GenerateOptions{
+		ServerName:          opts.ServerName,
+		UpstreamPort:        opts.UpstreamPort,
+		DownstreamPort:      opts.DownstreamPort,
+		RandomPaths:         opts.RandomPaths,
+		PathSecret:          pathSecret,
+		EncryptionAlgorithm: opts.EncryptionAlgorithm,
+	}
+	if opts.TLS {
+		serverOpts.TLSCert = opts.TLSCert
+		serverOpts.TLSKey = opts.TLSKey
+	}
+	server, err := g.GenerateServerConfig(serverOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	scheme := "ws"
+	if opts.TLS {
+		scheme = "wss"
+	}
+	upstream := server.Server.Upstream
+	downstream := server.Server.Downstream
+	client, err := g.GenerateClientConfig(GenerateOptions{
+		ClientName:          opts.ClientName,
+		UpstreamURL:         scheme + "://" + net.JoinHostPort(opts.UpstreamHost, strconv.Itoa(upstream.Port)) + upstream.Path,
+		DownstreamURL:       scheme + "://" + net.JoinHostPort(opts.DownstreamHost, strconv.Itoa(downstream.Port)) + downstream.Path,
+		PortForwards:        opts.PortForwards,
+		EnableSOCKS5:        opts.SOCKS5Port > 0,
+		SOCKS5Port:          opts.SOCKS5Port,
+		TLS:                 &opts.TLS,
+		PathSecret:          pathSecret,
+		EncryptionAlgorithm: opts.EncryptionAlgorithm,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	pair := &ConfigPair{Client: client, Server: server}
+	if opts.ClientAuth {
+		token, err := clientauth.Generate()
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate client token: %w", err)
+		}
+		pair.ClientToken = token
+		client.Client.AuthToken = token
+		server.Access.ClientAuth.Required = true
+		server.Access.ClientAuth.Clients = append(server.Access.ClientAuth.Clients, ClientTokenConfig{
+			Name:      client.Client.Name,
+			TokenHash: clientauth.Hash(token),
+		})
+	}
+	return pair, nil
+}
@@ -0,0 +1,77 @@
+package config
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/sahmadiut/half-tunnel/internal/clientauth"
+)
+
+func TestGeneratePair(t *testing.T) {
+	opts := DefaultPairOptions()
+	opts.UpstreamHost = "a.example.com"
+	opts.DownstreamHost = "b.example.com"
+	opts.UpstreamPort = 443
+	opts.PortForwards = []string{"2083"}
+	opts.EncryptionAlgorithm = "chacha20-poly1305"
+
+	pair, err := NewNonInteractiveGenerator().GeneratePair(opts)
+	if err != nil {
+		t.Fatalf("GeneratePair() error = %v", err)
+	}
+	client, server := pair.Client, pair.Server
+
+	wantUp := "wss://a.example.com:443" + server.Server.Upstream.Path
+	if client.Client.Upstream.URL != wantUp {
+		t.Errorf("Expected upstream URL %s, got %s", wantUp, client.Client.Upstream.URL)
+	}
+	wantDown := "wss://b.example.com:8444" + server.Server.Downstream.Path
+	if client.Client.Downstream.URL != wantDown {
+		t.Errorf("Expected downstream URL %s, got %s", wantDown, client.Client.Downstream.URL)
+	}
+	if server.Server.Upstream.Path == DefaultServerConfig().Server.Upstream.Path {
+		t.Error("Expected random paths")
+	}
+	if !server.Server.Upstream.TLS.Enabled || !client.Client.Downstream.TLS.Enabled {
+		t.Error("Expected TLS on both sides")
+	}
+	if server.Server.PathToken.Secret == "" || server.Server.PathToken.Secret != client.Client.PathToken.Secret {
+		t.Errorf("Expected a shared path token secret, got %q and %q", server.Server.PathToken.Secret, client.Client.PathToken.Secret)
+	}
+	if client.Client.AuthToken != pair.ClientToken || !server.Access.ClientAuth.Required ||
+		len(server.Access.ClientAuth.Clients) != 1 || server.Access.ClientAuth.Clients[0].TokenHash != clientauth.Hash(pair.ClientToken) {
+		t.Errorf("Expected the client token to be registered with the server, got %+v", server.Access.ClientAuth)
+	}
+	if client.Tunnel.Encryption.Algorithm != "chacha20-poly1305" || server.Tunnel.Encryption.Algorithm != "chacha20-poly1305" {
+		t.Error("Expected the encryption algorithm on both sides")
+	}
+	if err := client.Validate(); err != nil {
+		t.Errorf("Expected a valid client config: %v", err)
+	}
+
+	if _, err := NewNonInteractiveGenerator().GeneratePair(DefaultPairOptions()); err == nil {
+		t.Error("Expected error without an upstream host")
+	}
+}
+
+func TestGeneratePairInteractive(t *testing.T) {
+	// Upstream host, downstream host (default), ports (default), TLS: no,
+	// random paths: no, path tokens: no, client token: no, forwards, SOCKS5
+	input := "tunnel.example.com\n\n\n\nn\nn\nn\nn\n2083, 8080:example.com:80\n0\n"
+	gen := NewConfigGenerator(strings.NewReader(input), &bytes.Buffer{}, true)
+
+	pair, err := gen.GeneratePair(DefaultPairOptions())
+	if err != nil {
+		t.Fatalf("GeneratePair() error = %v", err)
+	}
+	if pair.Client.Client.Downstream.URL != "ws://tunnel.example.com:8444/ws/downstream" {
+		t.Errorf("Unexpected downstream URL %s", pair.Client.Client.Downstream.URL)
+	}
+	if pair.Server.Server.Upstream.TLS.Enabled || pair.Server.Server.PathToken.Secret != "" || pair.ClientToken != "" {
+		t.Error("Expected no TLS, path tokens or client token")
+	}
+	if len(pair.Client.PortForwards) != 2 || pair.Client.SOCKS5.Enabled {
+		t.Errorf("Expected two port forwards and no SOCKS5, got %v and %v", pair.Client.PortForwards, pair.Client.SOCKS5.Enabled)
+	}
+}
@@ -0,0 +1,169 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+	"go.yaml.in/yaml/v3"
+)
+
+// Redacted replaces secret values in redacted config files.
+const Redacted = "REDACTED"
+
+// secretKeys are the config keys, or key suffixes after an underscore, whose
+// values are secrets.
+var secretKeys = []string{"secret", "password", "token", "token_hash"}
+
+// RedactConfigFile returns the content of a config file with secrets
+// replaced: tokens, token hashes, passwords, secrets and passwords in URLs.
+// YAML files keep their comments; JSON and TOML files are rewritten with
+// sorted keys.
+func RedactConfigFile(path string) ([]byte, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var redacted []byte
+	switch format := FormatFromPath(path); format {
+	case FormatYAML:
+		redacted, err = redactYAML(content)
+	default:
+		redacted, err = redactEncoded(content, format)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to redact %s: %w", path, err)
+	}
+	return redacted, nil
+}
+
+// isSecretKey reports whether a config key holds a secret.
+func isSecretKey(key string) bool {
+	for _, secret := range secretKeys {
+		if key == secret || strings.HasSuffix(key, "_"+secret) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactString replaces the password of a URL with "xxxxx".
+func redactString(s string) string {
+	if !strings.Contains(s, "://") {
+		return s
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return s
+	}
+	if _, ok := u.User.Password(); !ok {
+		return s
+	}
+	return u.Redacted()
+}
+
+// redactYAML redacts a YAML document.
+func redactYAML(content []byte) ([]byte, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(content, &doc); err != nil {
+		return nil, err
+	}
+	redactNode(&doc, false)
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(&doc); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// redactNode redacts the scalars under node; secret is set below a secret
+// key.
+func redactNode(node *yaml.Node, secret bool) {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		if secret {
+			node.Value = Redacted
+			node.Tag = "!!str"
+			node.Style = 0
+		} else if node.Tag == "!!str" || node.Tag == "" {
+			node.Value = redactString(node.Value)
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			redactNode(node.Content[i+1], isSecretKey(node.Content[i].Value))
+		}
+	default:
+		for _, child := range node.Content {
+			redactNode(child, secret)
+		}
+	}
+}
+
+// redactEncoded redacts a JSON or TOML document.
+func redactEncoded(content []byte, format string) ([]byte, error) {
+	settings := make(map[string]interface{})
+	var err error
+	if format == FormatJSON {
+		err = json.Unmarshal(content, &settings)
+	} else {
+		err = toml.Unmarshal(content, &settings)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	redacted := redactValue(settings, false)
+
+	if format == FormatJSON {
+		data, err := json.MarshalIndent(redacted, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		return append(data, '\n'), nil
+	}
+	var buf bytes.Buffer
+	enc := toml.NewEncoder(&buf)
+	enc.SetIndentTables(true)
+	if err := enc.Encode(redacted); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// redactValue returns a decoded value with its secrets replaced; secret is
+// set below a secret key.
+func redactValue(value interface{}, secret bool) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			v[key] = redactValue(child, isSecretKey(key))
+		}
+		return v
+	case []interface{}:
+		for i, child := range v {
+			v[i] = redactValue(child, secret)
+		}
+		return v
+	case string:
+		if secret {
+			return Redacted
+		}
+		return redactString(v)
+	default:
+		if secret {
+			return Redacted
+		}
+		return v
+	}
+}
@@ -0,0 +1,194 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// envReference matches ${NAME} and ${NAME:-default}; $${ is a literal ${.
+var envReference = regexp.MustCompile(`\$\$\{|\$\{([A-Za-z_][A-Za-z0-9_]*)(?::-([^}]*))?\}`)
+
+// fileSuffix marks a setting whose value is read from a file, such as
+// socks5.auth.password_file for socks5.auth.password.
+const fileSuffix = "_file"
+
+// unmarshalResolved decodes the settings of v into cfg, a pointer to a
+// ClientConfig or ServerConfig, after resolving environment variable and
+// file references.
+func unmarshalResolved(v *viper.Viper, cfg interface{}) error {
+	settings := v.AllSettings()
+	if err := resolveReferences(settings, reflect.TypeOf(cfg).Elem()); err != nil {
+		return err
+	}
+
+	resolved := viper.New()
+	if err := resolved.MergeConfigMap(settings); err != nil {
+		return fmt.Errorf("error unmarshaling config: %w", err)
+	}
+	if err := resolved.Unmarshal(cfg); err != nil {
+		return fmt.Errorf("error unmarshaling config: %w", err)
+	}
+	return nil
+}
+
+// resolveReferences expands ${NAME} references to environment variables in
+// every string setting, then replaces each "<key>_file" setting with the
+// contents of the file it names, for any string setting <key> of t. All
+// unset variables and unreadable files are reported together.
+func resolveReferences(settings map[string]interface{}, t reflect.Type) error {
+	r := &resolver{}
+	r.resolveMap(settings, t, "")
+	return errors.Join(r.errs...)
+}
+
+// resolver resolves the references in settings.
+type resolver struct {
+	// keepReferences drops file references instead of reading them and
+	// expands unset variables to nothing, so that a config can be decoded
+	// and rewritten without its secrets
+	keepReferences bool
+	errs           []error
+}
+
+func (r *resolver) resolveMap(m map[string]interface{}, t reflect.Type, path string) {
+	for key, value := range m {
+		m[key] = r.resolveValue(value, fieldType(t, key), joinKey(path, key))
+	}
+
+	for key, value := range m {
+		base, ok := fileReferenceBase(t, key)
+		if !ok {
+			continue
+		}
+		delete(m, key)
+		if r.keepReferences {
+			continue
+		}
+		if existing, _ := m[base].(string); existing != "" {
+			r.errs = append(r.errs, fmt.Errorf("%s: set either %s or %s, not both", joinKey(path, key), base, key))
+			continue
+		}
+		content, err := os.ReadFile(fmt.Sprint(value))
+		if err != nil {
+			r.errs = append(r.errs, fmt.Errorf("%s: %w", joinKey(path, key), err))
+			continue
+		}
+		m[base] = strings.TrimRight(string(content), "\r\n")
+	}
+}
+
+func (r *resolver) resolveValue(value interface{}, t reflect.Type, path string) interface{} {
+	switch v := value.(type) {
+	case string:
+		expanded, missing := expandEnv(v)
+		if !r.keepReferences {
+			for _, name := range missing {
+				r.errs = append(r.errs, fmt.Errorf("%s: environment variable %s is not set", path, name))
+			}
+		}
+		return expanded
+	case []string:
+		for i, s := range v {
+			v[i], _ = r.resolveValue(s, nil, fmt.Sprintf("%s[%d]", path, i)).(string)
+		}
+		return v
+	case []interface{}:
+		var elem reflect.Type
+		if t != nil && t.Kind() == reflect.Slice {
+			elem = t.Elem()
+		}
+		for i, item := range v {
+			v[i] = r.resolveValue(item, elem, fmt.Sprintf("%s[%d]", path, i))
+		}
+		return v
+	case map[string]interface{}:
+		r.resolveMap(v, t, path)
+		return v
+	default:
+		return value
+	}
+}
+
+// expandEnv replaces environment variable references in s and returns the
+// names of variables that are not set and have no default.
+func expandEnv(s string) (string, []string) {
+	if !strings.Contains(s, "${") {
+		return s, nil
+	}
+	var missing []string
+	expanded := envReference.ReplaceAllStringFunc(s, func(ref string) string {
+		if ref == "$${" {
+			return "${"
+		}
+		match := envReference.FindStringSubmatch(ref)
+		if value, ok := os.LookupEnv(match[1]); ok {
+			return value
+		}
+		if strings.Contains(ref, ":-") {
+			return match[2]
+		}
+		missing = append(missing, match[1])
+		return ""
+	})
+	return expanded, missing
+}
+
+// fileReferenceBase returns the setting a "<key>_file" key of struct type t
+// reads from a file. Keys of t that end in _file, such as
+// tls.cert_file, are settings of their own.
+func fileReferenceBase(t reflect.Type, key string) (string, bool) {
+	if !strings.HasSuffix(key, fileSuffix) || fieldType(t, key) != nil {
+		return "", false
+	}
+	base := strings.TrimSuffix(key, fileSuffix)
+	if ft := fieldType(t, base); ft == nil || ft.Kind() != reflect.String {
+		return "", false
+	}
+	return base, true
+}
+
+// fieldType returns the type of the value stored under key in a value of
+// type t, or nil if t has no such key.
+func fieldType(t reflect.Type, key string) reflect.Type {
+	for t != nil && t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == nil {
+		return nil
+	}
+	switch t.Kind() {
+	case reflect.Map:
+		return t.Elem()
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name, opts, _ := strings.Cut(field.Tag.Get("mapstructure"), ",")
+			if strings.Contains(opts, "squash") {
+				if ft := fieldType(field.Type, key); ft != nil {
+					return ft
+				}
+				continue
+			}
+			if name == "" {
+				name = strings.ToLower(field.Name)
+			}
+			if name == key {
+				return field.Type
+			}
+		}
+	}
+	return nil
+}
+
+func joinKey(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
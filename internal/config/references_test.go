@@ -0,0 +1,164 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExpandEnv(t *testing.T) {
+	t.Setenv("HT_TEST_HOST", "tunnel.example.com")
+
+	tests := []struct {
+		in      string
+		want    string
+		missing []string
+	}{
+		{"plain", "plain", nil},
+		{"wss://${HT_TEST_HOST}/up", "wss://tunnel.example.com/up", nil},
+		{"${HT_TEST_UNSET:-fallback}", "fallback", nil},
+		{"${HT_TEST_UNSET:-}", "", nil},
+		{"${HT_TEST_UNSET}", "", []string{"HT_TEST_UNSET"}},
+		{"pa$${HT_TEST_HOST}", "pa${HT_TEST_HOST}", nil},
+		{"pa$word", "pa$word", nil},
+	}
+
+	for _, tt := range tests {
+		got, missing := expandEnv(tt.in)
+		if got != tt.want {
+			t.Errorf("expandEnv(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+		if strings.Join(missing, ",") != strings.Join(tt.missing, ",") {
+			t.Errorf("expandEnv(%q) missing = %v, want %v", tt.in, missing, tt.missing)
+		}
+	}
+}
+
+func TestLoadClientConfigReferences(t *testing.T) {
+	dir := t.TempDir()
+	passwordFile := filepath.Join(dir, "socks5-password")
+	if err := os.WriteFile(passwordFile, []byte("s3cret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("HT_TEST_HOST", "tunnel.example.com")
+
+	configPath := filepath.Join(dir, "client.yml")
+	content := `client:
+  auth_token: "${HT_TEST_TOKEN:-default-token}"
+  upstream:
+    url: "wss://${HT_TEST_HOST}:8443/ws/upstream"
+socks5:
+  auth:
+    enabled: true
+    username: "user"
+    password_file: "` + passwordFile + `"
+port_forwards:
+  - "${HT_TEST_PORT:-2083}"
+`
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := LoadClientConfig(configPath)
+	if err != nil {
+		t.Fatalf("LoadClientConfig() error = %v", err)
+	}
+	if cfg.Client.Upstream.URL != "wss://tunnel.example.com:8443/ws/upstream" {
+		t.Errorf("Expected expanded URL, got %s", cfg.Client.Upstream.URL)
+	}
+	if cfg.Client.AuthToken != "default-token" {
+		t.Errorf("Expected default token, got %s", cfg.Client.AuthToken)
+	}
+	if cfg.SOCKS5.Auth.Password != "s3cret" {
+		t.Errorf("Expected password from file, got %q", cfg.SOCKS5.Auth.Password)
+	}
+	forwards, err := cfg.GetPortForwards()
+	if err != nil || len(forwards) != 1 || forwards[0].ListenPort != 2083 {
+		t.Errorf("Expected port forward 2083, got %+v (%v)", forwards, err)
+	}
+	// tls.cert_file and the like are settings, not references
+	if cfg.Client.Upstream.TLS.CertFile != "" {
+		t.Errorf("Expected no client certificate, got %s", cfg.Client.Upstream.TLS.CertFile)
+	}
+}
+
+func TestLoadServerConfigReferenceErrors(t *testing.T) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "server.yml")
+	content := `server:
+  path_token:
+    secret: "${HT_TEST_UNSET_SECRET}"
+access:
+  guest:
+    secret_file: "` + filepath.Join(dir, "missing") + `"
+  client_auth:
+    clients:
+      - name: laptop
+        token: "${HT_TEST_UNSET_TOKEN}"
+`
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := LoadServerConfig(configPath)
+	if err == nil {
+		t.Fatal("Expected an error for unresolved references")
+	}
+	for _, want := range []string{
+		"server.path_token.secret: environment variable HT_TEST_UNSET_SECRET is not set",
+		"access.client_auth.clients[0].token: environment variable HT_TEST_UNSET_TOKEN is not set",
+		"access.guest.secret_file:",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected error to contain %q, got: %v", want, err)
+		}
+	}
+
+	both := `server:
+  path_token:
+    secret: "inline"
+    secret_file: "/etc/hostname"
+`
+	if err := os.WriteFile(configPath, []byte(both), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadServerConfig(configPath); err == nil || !strings.Contains(err.Error(), "set either secret or secret_file") {
+		t.Errorf("Expected an error for a secret set twice, got %v", err)
+	}
+}
+
+func TestMigrateKeepsReferences(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "client.yml")
+	content := `client:
+  upstream:
+    url: "wss://${HT_TEST_UNSET_HOST}/up"
+socks5:
+  listen_port: "${HT_TEST_UNSET_PORT}"
+  auth:
+    enabled: true
+    password_file: /run/secrets/socks5
+`
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := MigrateClientConfig(configPath)
+	if err != nil {
+		t.Fatalf("MigrateClientConfig() error = %v", err)
+	}
+	for _, want := range []string{
+		"url: wss://${HT_TEST_UNSET_HOST}/up",
+		"listen_port: ${HT_TEST_UNSET_PORT}",
+		"password_file: /run/secrets/socks5",
+	} {
+		if !strings.Contains(result.Content, want) {
+			t.Errorf("Expected migrated config to contain %q:\n%s", want, result.Content)
+		}
+	}
+	for _, c := range result.Changes {
+		if c.Kind == ChangeRemoved {
+			t.Errorf("Unexpected removal: %s", c)
+		}
+	}
+}
@@ -2,105 +2,1172 @@
 package config
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"math"
+	"net"
+	"net/url"
 	"os"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/sahmadiut/half-tunnel/internal/clientauth"
+	"github.com/sahmadiut/half-tunnel/internal/notify"
+	"github.com/sahmadiut/half-tunnel/internal/pathtoken"
+	"github.com/sahmadiut/half-tunnel/internal/protocol"
+	"github.com/sahmadiut/half-tunnel/internal/quota"
+	"github.com/sahmadiut/half-tunnel/internal/sockopt"
+	"github.com/sahmadiut/half-tunnel/pkg/logger"
 	"github.com/spf13/viper"
 )
 
 // ServerConfig represents the complete server configuration.
 type ServerConfig struct {
-	Server        ServerSettings     `mapstructure:"server"`
-	Access        AccessConfig       `mapstructure:"access"`
-	Tunnel        ServerTunnelConfig `mapstructure:"tunnel"`
-	Logging       LoggingConfig      `mapstructure:"logging"`
-	Observability ObservConfig       `mapstructure:"observability"`
+	Server        ServerSettings     `mapstructure:"server" yaml:"server"`
+	Access        AccessConfig       `mapstructure:"access" yaml:"access"`
+	Tunnel        ServerTunnelConfig `mapstructure:"tunnel" yaml:"tunnel"`
+	Logging       LoggingConfig      `mapstructure:"logging" yaml:"logging"`
+	Observability ObservConfig       `mapstructure:"observability" yaml:"observability"`
+	Control       ControlConfig      `mapstructure:"control" yaml:"control"`
+	Cluster       ClusterConfig      `mapstructure:"cluster" yaml:"cluster"`
+	Egress        EgressConfig       `mapstructure:"egress" yaml:"egress"`
+	Notifications ServerNotifyConfig `mapstructure:"notifications" yaml:"notifications"`
+}
+
+// EgressConfig holds settings for connections from the server to
+// destinations. BindAddress sets their source IP and Interface binds them to
+// a network interface (Linux only); both are useful on multi-homed hosts and
+// with policy routing. Mark sets the SO_MARK packet mark (fwmark) of
+// destination connections so routing and nftables rules can tell tunnel
+// traffic apart (Linux only, 0 disables it).
+type EgressConfig struct {
+	BindAddress string         `mapstructure:"bind_address" yaml:"bind_address"`
+	Interface   string         `mapstructure:"interface" yaml:"interface"`
+	Mark        int64          `mapstructure:"mark" yaml:"mark"`
+	DNS         ResolverConfig `mapstructure:"dns" yaml:"dns"`
+}
+
+// validate checks the egress settings.
+func (e EgressConfig) validate() error {
+	if e.BindAddress != "" && net.ParseIP(e.BindAddress) == nil {
+		return fmt.Errorf("invalid egress bind_address: %q (use an IP address)", e.BindAddress)
+	}
+	if e.Mark < 0 || e.Mark > math.MaxUint32 {
+		return fmt.Errorf("egress mark must be between 0 and %d", uint32(math.MaxUint32))
+	}
+	return e.DNS.validate()
+}
+
+// ResolverConfig holds destination name resolution settings. Names are resolved
+// with the system resolver unless Servers or DoHURL is set. Prefer is one of
+// "auto", "ipv4", "ipv6", "ipv4_only" or "ipv6_only"; CacheTTL caps how long
+// answers are cached (0 disables the cache).
+type ResolverConfig struct {
+	Servers   []string      `mapstructure:"servers" yaml:"servers"`
+	DoHURL    string        `mapstructure:"doh_url" yaml:"doh_url"`
+	Timeout   time.Duration `mapstructure:"timeout" yaml:"timeout"`
+	Prefer    string        `mapstructure:"prefer" yaml:"prefer"`
+	CacheTTL  time.Duration `mapstructure:"cache_ttl" yaml:"cache_ttl"`
+	CacheSize int           `mapstructure:"cache_size" yaml:"cache_size"`
+}
+
+// validate checks the resolver settings.
+func (d ResolverConfig) validate() error {
+	for _, server := range d.Servers {
+		host := server
+		if h, _, err := net.SplitHostPort(server); err == nil {
+			host = h
+		}
+		if net.ParseIP(strings.Trim(host, "[]")) == nil {
+			return fmt.Errorf("invalid DNS server %q (use an IP address, optionally with a port)", server)
+		}
+	}
+	if d.DoHURL != "" {
+		u, err := url.Parse(d.DoHURL)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return fmt.Errorf("invalid DNS-over-HTTPS URL: %q", d.DoHURL)
+		}
+	}
+	if d.Timeout <= 0 {
+		return fmt.Errorf("DNS timeout must be positive")
+	}
+	switch d.Prefer {
+	case "", "auto", "ipv4", "ipv6", "ipv4_only", "ipv6_only":
+		// valid
+	default:
+		return fmt.Errorf("invalid DNS prefer: %s (use auto, ipv4, ipv6, ipv4_only or ipv6_only)", d.Prefer)
+	}
+	if d.CacheTTL < 0 || d.CacheSize < 0 {
+		return fmt.Errorf("DNS cache_ttl and cache_size must not be negative")
+	}
+	return nil
 }
 
 // ServerSettings holds server-specific settings.
 type ServerSettings struct {
-	Name            string         `mapstructure:"name"`
-	ExitOnPortInUse bool           `mapstructure:"exit_on_port_in_use"`
-	Upstream        ServerEndpoint `mapstructure:"upstream"`
-	Downstream      ServerEndpoint `mapstructure:"downstream"`
+	Name            string              `mapstructure:"name" yaml:"name"`
+	ExitOnPortInUse bool                `mapstructure:"exit_on_port_in_use" yaml:"exit_on_port_in_use"`
+	PathToken       PathTokenConfig     `mapstructure:"path_token" yaml:"path_token"`
+	UpgradeCookie   UpgradeCookieConfig `mapstructure:"upgrade_cookie" yaml:"upgrade_cookie"`
+	Decoy           DecoyConfig         `mapstructure:"decoy" yaml:"decoy"`
+	Upstream        ServerEndpoint      `mapstructure:"upstream" yaml:"upstream"`
+	Downstream      ServerEndpoint      `mapstructure:"downstream" yaml:"downstream"`
+}
+
+// SinglePort reports whether upstream and downstream listen on the same host
+// and port. Both directions are then served by one listener, distinguished by
+// path, using the upstream TLS settings.
+func (s ServerSettings) SinglePort() bool {
+	return s.Upstream.Host == s.Downstream.Host && s.Upstream.Port == s.Downstream.Port
+}
+
+// DecoyConfig selects a website served to requests that are not tunnel
+// connections, so the endpoints look like ordinary web servers. At most one
+// of Dir and ProxyURL may be set.
+type DecoyConfig struct {
+	Dir      string `mapstructure:"dir" yaml:"dir"`
+	ProxyURL string `mapstructure:"proxy_url" yaml:"proxy_url"`
 }
 
-// ServerEndpoint defines a server listener endpoint.
+// PathTokenConfig enables rotating HMAC tokens in the WebSocket paths.
+// Client and server must share the same secret.
+type PathTokenConfig struct {
+	Secret string        `mapstructure:"secret" yaml:"secret"`
+	Window time.Duration `mapstructure:"window" yaml:"window"`
+}
+
+// UpgradeCookieConfig requires a cookie with a rotating HMAC token on
+// WebSocket upgrades. Client and server must share the same secret.
+type UpgradeCookieConfig struct {
+	Secret string        `mapstructure:"secret" yaml:"secret"`
+	Window time.Duration `mapstructure:"window" yaml:"window"`
+	Name   string        `mapstructure:"name" yaml:"name"`
+}
+
+// validate checks the upgrade cookie settings.
+func (u UpgradeCookieConfig) validate() error {
+	if u.Secret == "" {
+		return nil
+	}
+	if u.Window <= 0 {
+		return fmt.Errorf("invalid upgrade_cookie window: %v", u.Window)
+	}
+	if u.Name == "" || strings.ContainsAny(u.Name, " \t;,=\"") {
+		return fmt.Errorf("invalid upgrade_cookie name: %q", u.Name)
+	}
+	return nil
+}
+
+// ServerEndpoint defines a server listener endpoint. IPFamily is "dual"
+// (IPv4 and IPv6 on wildcard hosts), "ipv4" or "ipv6".
 type ServerEndpoint struct {
-	Host string          `mapstructure:"host"`
-	Port int             `mapstructure:"port"`
-	Path string          `mapstructure:"path"`
-	TLS  ServerTLSConfig `mapstructure:"tls"`
+	Host     string          `mapstructure:"host" yaml:"host"`
+	Port     int             `mapstructure:"port" yaml:"port"`
+	IPFamily string          `mapstructure:"ip_family" yaml:"ip_family"`
+	Path     string          `mapstructure:"path" yaml:"path"`
+	TLS      ServerTLSConfig `mapstructure:"tls" yaml:"tls"`
+}
+
+// Addr returns the endpoint's listen address.
+func (e ServerEndpoint) Addr() string {
+	return net.JoinHostPort(e.Host, strconv.Itoa(e.Port))
 }
 
 // ServerTLSConfig holds TLS configuration for server endpoints.
 type ServerTLSConfig struct {
-	Enabled  bool   `mapstructure:"enabled"`
-	CertFile string `mapstructure:"cert_file"`
-	KeyFile  string `mapstructure:"key_file"`
+	Enabled           bool   `mapstructure:"enabled" yaml:"enabled"`
+	CertFile          string `mapstructure:"cert_file" yaml:"cert_file"`
+	KeyFile           string `mapstructure:"key_file" yaml:"key_file"`
+	ClientCAFile      string `mapstructure:"client_ca_file" yaml:"client_ca_file"`
+	RequireClientCert bool   `mapstructure:"require_client_cert" yaml:"require_client_cert"`
 }
 
 // AccessConfig defines server-side access control.
 type AccessConfig struct {
-	AllowedNetworks      []string `mapstructure:"allowed_networks"`
-	BlockedNetworks      []string `mapstructure:"blocked_networks"`
-	MaxStreamsPerSession int      `mapstructure:"max_streams_per_session"`
+	AllowedNetworks      []string           `mapstructure:"allowed_networks" yaml:"allowed_networks"`
+	BlockedNetworks      []string           `mapstructure:"blocked_networks" yaml:"blocked_networks"`
+	MaxStreamsPerSession int                `mapstructure:"max_streams_per_session" yaml:"max_streams_per_session"`
+	MaxConnectionsPerIP  int                `mapstructure:"max_connections_per_ip" yaml:"max_connections_per_ip"`
+	Guest                GuestConfig        `mapstructure:"guest" yaml:"guest"`
+	DialRetry            DialRetryConfig    `mapstructure:"dial_retry" yaml:"dial_retry"`
+	Rules                []AccessRuleConfig `mapstructure:"rules" yaml:"rules"`
+	Policy               PolicyConfig       `mapstructure:"policy" yaml:"policy"`
+	ClientAuth           ClientAuthConfig   `mapstructure:"client_auth" yaml:"client_auth"`
+	Quotas               QuotaConfig        `mapstructure:"quotas" yaml:"quotas"`
+	ConnectionLimits     ConnLimitsConfig   `mapstructure:"connection_limits" yaml:"connection_limits"`
+}
+
+// ConnLimitsConfig rate limits WebSocket upgrade attempts per source IP and
+// temporarily bans sources that exceed the rate or fail too many handshakes
+// within FailureWindow. Bans are kept in StateFile when it is set, and only
+// in memory otherwise. Sources in ExemptNetworks are never limited.
+type ConnLimitsConfig struct {
+	Enabled           bool          `mapstructure:"enabled" yaml:"enabled"`
+	AttemptsPerMinute int           `mapstructure:"attempts_per_minute" yaml:"attempts_per_minute"`
+	Burst             int           `mapstructure:"burst" yaml:"burst"`
+	MaxFailures       int           `mapstructure:"max_failures" yaml:"max_failures"`
+	FailureWindow     time.Duration `mapstructure:"failure_window" yaml:"failure_window"`
+	BanDuration       time.Duration `mapstructure:"ban_duration" yaml:"ban_duration"`
+	StateFile         string        `mapstructure:"state_file" yaml:"state_file"`
+	FlushInterval     time.Duration `mapstructure:"flush_interval" yaml:"flush_interval"`
+	ExemptNetworks    []string      `mapstructure:"exempt_networks" yaml:"exempt_networks"`
+}
+
+// validate checks the limits when connection limits are enabled.
+func (c ConnLimitsConfig) validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.AttemptsPerMinute < 0 || c.MaxFailures < 0 {
+		return fmt.Errorf("attempts_per_minute and max_failures must not be negative")
+	}
+	if c.AttemptsPerMinute > 0 && c.Burst < 1 {
+		return fmt.Errorf("burst must be at least 1")
+	}
+	if c.MaxFailures > 0 && c.FailureWindow <= 0 {
+		return fmt.Errorf("failure_window must be positive")
+	}
+	if c.BanDuration <= 0 {
+		return fmt.Errorf("ban_duration must be positive")
+	}
+	if c.FlushInterval <= 0 {
+		return fmt.Errorf("flush_interval must be positive")
+	}
+	return validateNetworks(c.ExemptNetworks)
+}
+
+// QuotaConfig limits the traffic of identified clients per calendar day and
+// month and caps their throughput. Clients without an entry get Default.
+// Usage is kept in StateFile so it survives restarts.
+type QuotaConfig struct {
+	Enabled       bool                `mapstructure:"enabled" yaml:"enabled"`
+	StateFile     string              `mapstructure:"state_file" yaml:"state_file"`
+	FlushInterval time.Duration       `mapstructure:"flush_interval" yaml:"flush_interval"`
+	Default       QuotaLimitsConfig   `mapstructure:"default" yaml:"default"`
+	Clients       []ClientQuotaConfig `mapstructure:"clients" yaml:"clients"`
+}
+
+// QuotaLimitsConfig holds human-readable sizes ("10GB", "512MB"); Rate is
+// per second. Empty or "0" is unlimited.
+type QuotaLimitsConfig struct {
+	Daily   string `mapstructure:"daily" yaml:"daily"`
+	Monthly string `mapstructure:"monthly" yaml:"monthly"`
+	Rate    string `mapstructure:"rate" yaml:"rate"`
+}
+
+// ClientQuotaConfig sets the limits of one client, named as in client_auth
+// or by its client certificate common name.
+type ClientQuotaConfig struct {
+	Name              string `mapstructure:"name" yaml:"name"`
+	QuotaLimitsConfig `mapstructure:",squash" yaml:",inline"`
+}
+
+// Limits parses the sizes into quota limits.
+func (l QuotaLimitsConfig) Limits() (quota.Limits, error) {
+	var limits quota.Limits
+	for _, field := range []struct {
+		name  string
+		value string
+		dst   *int64
+	}{
+		{"daily", l.Daily, &limits.Daily},
+		{"monthly", l.Monthly, &limits.Monthly},
+		{"rate", l.Rate, &limits.Rate},
+	} {
+		if field.value == "" {
+			continue
+		}
+		n, err := ParseByteSize(field.value)
+		if err != nil {
+			return quota.Limits{}, fmt.Errorf("%s: %w", field.name, err)
+		}
+		*field.dst = n
+	}
+	return limits, nil
+}
+
+// validate checks the state file, sizes and client names when quotas are
+// enabled.
+func (q QuotaConfig) validate() error {
+	if !q.Enabled {
+		return nil
+	}
+	if q.StateFile == "" {
+		return fmt.Errorf("state_file is required")
+	}
+	if q.FlushInterval <= 0 {
+		return fmt.Errorf("flush_interval must be positive")
+	}
+	if _, err := q.Default.Limits(); err != nil {
+		return fmt.Errorf("default: %w", err)
+	}
+	seen := make(map[string]bool)
+	for i, client := range q.Clients {
+		if client.Name == "" {
+			return fmt.Errorf("client %d: name is required", i+1)
+		}
+		if seen[client.Name] {
+			return fmt.Errorf("duplicate client name: %s", client.Name)
+		}
+		seen[client.Name] = true
+		if _, err := client.Limits(); err != nil {
+			return fmt.Errorf("client %s: %w", client.Name, err)
+		}
+	}
+	return nil
+}
+
+// ClientAuthConfig identifies clients by the token in their client
+// configuration (generate one with "half-tunnel token generate"). Required
+// rejects sessions identified by neither a token nor a client certificate.
+type ClientAuthConfig struct {
+	Required bool                `mapstructure:"required" yaml:"required"`
+	Clients  []ClientTokenConfig `mapstructure:"clients" yaml:"clients"`
+}
+
+// ClientTokenConfig names a client and its token. TokenHash is the token's
+// hex SHA-256 hash; it is preferred over keeping the Token itself in the
+// server configuration.
+type ClientTokenConfig struct {
+	Name      string `mapstructure:"name" yaml:"name"`
+	Token     string `mapstructure:"token" yaml:"token"`
+	TokenHash string `mapstructure:"token_hash" yaml:"token_hash"`
+}
+
+// Hash returns the token hash of the client.
+func (c ClientTokenConfig) Hash() string {
+	if c.TokenHash != "" {
+		return strings.ToLower(c.TokenHash)
+	}
+	return clientauth.Hash(c.Token)
+}
+
+// validate checks that each client has a unique name and exactly one valid
+// token setting, and that no two clients share a token.
+func (c ClientAuthConfig) validate() error {
+	names := make(map[string]bool)
+	hashes := make(map[string]bool)
+	for i, client := range c.Clients {
+		if client.Name == "" {
+			return fmt.Errorf("client %d: name is required", i+1)
+		}
+		if names[client.Name] {
+			return fmt.Errorf("duplicate client name: %s", client.Name)
+		}
+		names[client.Name] = true
+
+		if (client.Token == "") == (client.TokenHash == "") {
+			return fmt.Errorf("client %s: exactly one of token and token_hash is required", client.Name)
+		}
+		if client.Token != "" && !clientauth.IsToken(client.Token) {
+			return fmt.Errorf("client %s: invalid token: expected %s prefix", client.Name, clientauth.TokenPrefix)
+		}
+		hash := client.Hash()
+		if raw, err := hex.DecodeString(hash); err != nil || len(raw) != sha256.Size {
+			return fmt.Errorf("client %s: invalid token_hash: expected a hex SHA-256 hash", client.Name)
+		}
+		if hashes[hash] {
+			return fmt.Errorf("client %s: token already used by another client", client.Name)
+		}
+		hashes[hash] = true
+	}
+	return nil
+}
+
+// PolicyConfig decides which destinations clients may reach, on top of
+// allowed_networks and blocked_networks. BlockPrivate blocks private,
+// loopback and link-local addresses, including those a destination name
+// resolves to. Clients restrict identified clients (by client_auth name or
+// client certificate common name) to the destinations they list.
+type PolicyConfig struct {
+	BlockPrivate   bool                 `mapstructure:"block_private" yaml:"block_private"`
+	BlockedPorts   []int                `mapstructure:"blocked_ports" yaml:"blocked_ports"`
+	BlockedDomains []string             `mapstructure:"blocked_domains" yaml:"blocked_domains"`
+	Clients        []ClientPolicyConfig `mapstructure:"clients" yaml:"clients"`
+}
+
+// ClientPolicyConfig restricts one client identity to the destinations
+// matched by Allow. A rule matches hosts in Networks or Domains, or any host
+// when both are empty, on Ports, or any port when empty.
+type ClientPolicyConfig struct {
+	Identity string             `mapstructure:"identity" yaml:"identity"`
+	Allow    []PolicyRuleConfig `mapstructure:"allow" yaml:"allow"`
+}
+
+// PolicyRuleConfig matches destinations for a client policy.
+type PolicyRuleConfig struct {
+	Networks []string `mapstructure:"networks" yaml:"networks"`
+	Domains  []string `mapstructure:"domains" yaml:"domains"`
+	Ports    []int    `mapstructure:"ports" yaml:"ports"`
+}
+
+// validate checks the policy's networks, ports and client identities.
+func (p PolicyConfig) validate() error {
+	if err := validatePorts(p.BlockedPorts); err != nil {
+		return fmt.Errorf("blocked_ports: %w", err)
+	}
+	seen := make(map[string]bool)
+	for i, client := range p.Clients {
+		if client.Identity == "" {
+			return fmt.Errorf("client %d: identity is required", i+1)
+		}
+		if seen[client.Identity] {
+			return fmt.Errorf("duplicate client identity: %s", client.Identity)
+		}
+		seen[client.Identity] = true
+		for _, rule := range client.Allow {
+			if err := validateNetworks(rule.Networks); err != nil {
+				return fmt.Errorf("client %s: %w", client.Identity, err)
+			}
+			if err := validatePorts(rule.Ports); err != nil {
+				return fmt.Errorf("client %s: %w", client.Identity, err)
+			}
+		}
+	}
+	return nil
+}
+
+// validateNetworks checks that every entry is a CIDR.
+func validateNetworks(networks []string) error {
+	for _, network := range networks {
+		if _, _, err := net.ParseCIDR(network); err != nil {
+			return fmt.Errorf("invalid network %q: %w", network, err)
+		}
+	}
+	return nil
+}
+
+// validatePorts checks that every entry is a valid port.
+func validatePorts(ports []int) error {
+	for _, port := range ports {
+		if port <= 0 || port > 65535 {
+			return fmt.Errorf("invalid port: %d", port)
+		}
+	}
+	return nil
+}
+
+// DialRetryConfig holds destination dial retry settings. Attempts is the
+// total number of dials; 1 disables retries.
+type DialRetryConfig struct {
+	Attempts   int           `mapstructure:"attempts" yaml:"attempts"`
+	Backoff    time.Duration `mapstructure:"backoff" yaml:"backoff"`
+	MaxBackoff time.Duration `mapstructure:"max_backoff" yaml:"max_backoff"`
+}
+
+// AccessRuleConfig applies settings to matching destinations. A rule matches
+// hosts in Networks (CIDRs) or Domains (including subdomains), or any host
+// when both are empty, on Ports, or any port when empty. Unset DialRetry
+// fields inherit from access.dial_retry.
+type AccessRuleConfig struct {
+	Name      string           `mapstructure:"name" yaml:"name"`
+	Networks  []string         `mapstructure:"networks" yaml:"networks"`
+	Domains   []string         `mapstructure:"domains" yaml:"domains"`
+	Ports     []int            `mapstructure:"ports" yaml:"ports"`
+	DialRetry *DialRetryConfig `mapstructure:"dial_retry" yaml:"dial_retry,omitempty"`
+}
+
+// validate checks the top-level dial retry settings.
+func (d DialRetryConfig) validate(name string) error {
+	if d.Attempts < 1 {
+		return fmt.Errorf("invalid %s attempts: %d (must be at least 1)", name, d.Attempts)
+	}
+	if d.Backoff < 0 || d.MaxBackoff < 0 {
+		return fmt.Errorf("%s backoff must not be negative", name)
+	}
+	return nil
+}
+
+// validate checks the rule's matchers and overrides.
+func (r AccessRuleConfig) validate() error {
+	if err := validateNetworks(r.Networks); err != nil {
+		return err
+	}
+	if err := validatePorts(r.Ports); err != nil {
+		return err
+	}
+	if r.DialRetry != nil {
+		if r.DialRetry.Attempts < 0 {
+			return fmt.Errorf("invalid dial_retry attempts: %d", r.DialRetry.Attempts)
+		}
+		if r.DialRetry.Backoff < 0 || r.DialRetry.MaxBackoff < 0 {
+			return fmt.Errorf("dial_retry backoff must not be negative")
+		}
+	}
+	return nil
+}
+
+// GuestConfig holds settings for time-limited guest sessions.
+type GuestConfig struct {
+	Enabled          bool          `mapstructure:"enabled" yaml:"enabled"`
+	Secret           string        `mapstructure:"secret" yaml:"secret"`
+	Required         bool          `mapstructure:"required" yaml:"required"`
+	WarnBefore       time.Duration `mapstructure:"warn_before" yaml:"warn_before"`
+	WarnTrafficRatio float64       `mapstructure:"warn_traffic_ratio" yaml:"warn_traffic_ratio"`
 }
 
 // ServerTunnelConfig holds tunnel settings for the server.
 type ServerTunnelConfig struct {
-	Session    ServerSessionConfig    `mapstructure:"session"`
-	Connection ServerConnectionConfig `mapstructure:"connection"`
-	Encryption EncryptionConfig       `mapstructure:"encryption"`
+	Session        ServerSessionConfig    `mapstructure:"session" yaml:"session"`
+	Connection     ServerConnectionConfig `mapstructure:"connection" yaml:"connection"`
+	CircuitBreaker CircuitBreakerConfig   `mapstructure:"circuit_breaker" yaml:"circuit_breaker"`
+	DataFlow       ServerDataFlowConfig   `mapstructure:"dataflow" yaml:"dataflow"`
+	Liveness       ServerLivenessConfig   `mapstructure:"liveness" yaml:"liveness"`
+	ResourceGuard  ResourceGuardConfig    `mapstructure:"resource_guard" yaml:"resource_guard"`
+	Encryption     EncryptionConfig       `mapstructure:"encryption" yaml:"encryption"`
+	Diagnostics    DiagnosticsConfig      `mapstructure:"diagnostics" yaml:"diagnostics"`
+}
+
+// ServerDataFlowConfig holds settings for closing sessions whose downstream
+// stalls: writes to it keep failing while the client still sends data.
+type ServerDataFlowConfig struct {
+	Enabled bool `mapstructure:"enabled" yaml:"enabled"`
+	// CheckInterval is how often sessions are checked
+	CheckInterval time.Duration `mapstructure:"check_interval" yaml:"check_interval"`
+	// StallThreshold is how long downstream writes may fail before the
+	// session is closed
+	StallThreshold time.Duration `mapstructure:"stall_threshold" yaml:"stall_threshold"`
+}
+
+// validate checks the data flow settings.
+func (d ServerDataFlowConfig) validate() error {
+	if !d.Enabled {
+		return nil
+	}
+	if d.CheckInterval <= 0 {
+		return fmt.Errorf("dataflow check_interval must be positive")
+	}
+	if d.StallThreshold < d.CheckInterval {
+		return fmt.Errorf("dataflow stall_threshold must be at least check_interval")
+	}
+	return nil
+}
+
+// ServerLivenessConfig holds settings for closing idle streams whose
+// destination is gone: after idle_timeout without data in either direction,
+// the destination is sent probe_count TCP keepalive probes, probe_interval
+// apart, and the stream is closed if none is answered.
+type ServerLivenessConfig struct {
+	Enabled       bool          `mapstructure:"enabled" yaml:"enabled"`
+	IdleTimeout   time.Duration `mapstructure:"idle_timeout" yaml:"idle_timeout"`
+	ProbeInterval time.Duration `mapstructure:"probe_interval" yaml:"probe_interval"`
+	ProbeCount    int           `mapstructure:"probe_count" yaml:"probe_count"`
+}
+
+// validate checks the liveness settings.
+func (l ServerLivenessConfig) validate() error {
+	if !l.Enabled {
+		return nil
+	}
+	if l.IdleTimeout <= 0 || l.ProbeInterval <= 0 || l.ProbeCount <= 0 {
+		return fmt.Errorf("liveness idle_timeout, probe_interval and probe_count must be positive")
+	}
+	return nil
+}
+
+// ResourceGuardConfig holds the ceilings on open files, open streams (NAT
+// entries) and goroutines from which the server refuses new streams instead
+// of running out of resources.
+type ResourceGuardConfig struct {
+	Enabled bool `mapstructure:"enabled" yaml:"enabled"`
+	// CheckInterval is how often resource usage is sampled
+	CheckInterval time.Duration `mapstructure:"check_interval" yaml:"check_interval"`
+	// MaxOpenFiles is the open file descriptor ceiling; 0 uses the
+	// process's descriptor limit (ulimit -n)
+	MaxOpenFiles int `mapstructure:"max_open_files" yaml:"max_open_files"`
+	// MaxNatEntries is the ceiling on open streams (0 means no ceiling)
+	MaxNatEntries int `mapstructure:"max_nat_entries" yaml:"max_nat_entries"`
+	// MaxGoroutines is the goroutine ceiling (0 means no ceiling)
+	MaxGoroutines int `mapstructure:"max_goroutines" yaml:"max_goroutines"`
+	// ShedAt is the fraction of a ceiling from which new streams are
+	// refused
+	ShedAt float64 `mapstructure:"shed_at" yaml:"shed_at"`
+}
+
+// validate checks the resource guard settings.
+func (g ResourceGuardConfig) validate() error {
+	if !g.Enabled {
+		return nil
+	}
+	if g.CheckInterval <= 0 {
+		return fmt.Errorf("resource_guard check_interval must be positive")
+	}
+	if g.MaxOpenFiles < 0 || g.MaxNatEntries < 0 || g.MaxGoroutines < 0 {
+		return fmt.Errorf("resource_guard limits must not be negative")
+	}
+	if g.ShedAt <= 0 || g.ShedAt > 1 {
+		return fmt.Errorf("invalid resource_guard shed_at: %v (must be in (0, 1])", g.ShedAt)
+	}
+	return nil
+}
+
+// DiagnosticsConfig enables the diagnostic stream targets (echo.internal:7,
+// discard.internal:9 and chargen.internal:19) that the server answers itself,
+// used by `ht c bench`.
+type DiagnosticsConfig struct {
+	Enabled bool `mapstructure:"enabled" yaml:"enabled"`
 }
 
 // ServerSessionConfig holds session management settings for server.
+// Eviction decides what happens to new sessions at MaxSessions: "reject"
+// them, evict the least recently active session ("lru"), or evict it only if
+// it has been idle for EvictIdleAfter ("idle").
 type ServerSessionConfig struct {
-	Timeout     time.Duration `mapstructure:"timeout"`
-	MaxSessions int           `mapstructure:"max_sessions"`
+	Timeout        time.Duration      `mapstructure:"timeout" yaml:"timeout"`
+	MaxSessions    int                `mapstructure:"max_sessions" yaml:"max_sessions"`
+	Eviction       string             `mapstructure:"eviction" yaml:"eviction"`
+	EvictIdleAfter time.Duration      `mapstructure:"evict_idle_after" yaml:"evict_idle_after"`
+	Store          SessionStoreConfig `mapstructure:"store" yaml:"store"`
+}
+
+// SessionStoreConfig selects where session state is shared. The "memory"
+// backend keeps sessions in the server process; "redis" shares them between
+// instances, making max_sessions a global limit.
+type SessionStoreConfig struct {
+	Backend string           `mapstructure:"backend" yaml:"backend"`
+	Redis   RedisStoreConfig `mapstructure:"redis" yaml:"redis"`
+}
+
+// RedisStoreConfig holds Redis connection settings for the session store.
+type RedisStoreConfig struct {
+	Addr     string `mapstructure:"addr" yaml:"addr"`
+	Password string `mapstructure:"password" yaml:"password"`
+	DB       int    `mapstructure:"db" yaml:"db"`
+	Prefix   string `mapstructure:"prefix" yaml:"prefix"`
 }
 
 // ServerConnectionConfig holds connection settings for server.
+// MaxConcurrentDials bounds destination dials in progress across all
+// sessions (0 means no limit).
 type ServerConnectionConfig struct {
-	ReadBufferSize    int           `mapstructure:"read_buffer_size"`
-	WriteBufferSize   int           `mapstructure:"write_buffer_size"`
-	KeepaliveInterval time.Duration `mapstructure:"keepalive_interval"`
-	MaxMessageSize    int           `mapstructure:"max_message_size"`
+	ReadBufferSize     int           `mapstructure:"read_buffer_size" yaml:"read_buffer_size"`
+	WriteBufferSize    int           `mapstructure:"write_buffer_size" yaml:"write_buffer_size"`
+	KeepaliveInterval  time.Duration `mapstructure:"keepalive_interval" yaml:"keepalive_interval"`
+	MaxMessageSize     int           `mapstructure:"max_message_size" yaml:"max_message_size"`
+	SlowDialThreshold  time.Duration `mapstructure:"slow_dial_threshold" yaml:"slow_dial_threshold"`
+	MaxConcurrentDials int           `mapstructure:"max_concurrent_dials" yaml:"max_concurrent_dials"`
+	TCP                TCPConfig     `mapstructure:"tcp" yaml:"tcp"`
+	// AcceptQueueSize is the number of upgraded tunnel connections that may
+	// wait to be served per listener path; further upgrades are refused
+	// with 503 until there is room
+	AcceptQueueSize int `mapstructure:"accept_queue_size" yaml:"accept_queue_size"`
+	// MaxPayloadSize is the largest packet payload offered to clients; 0
+	// offers the largest that fits max_message_size, up to 65535 bytes
+	MaxPayloadSize int `mapstructure:"max_payload_size" yaml:"max_payload_size"`
+	// CorruptPacketPolicy decides what happens to the stream of a packet
+	// with a checksum mismatch: "reset" closes it, "drop" only drops the
+	// packet
+	CorruptPacketPolicy string `mapstructure:"corrupt_packet_policy" yaml:"corrupt_packet_policy"`
+	// SendQueueSize is the number of packets queued per tunnel connection
+	// for its single writer, which writes bursts back to back; senders wait
+	// while it is full (0 writes directly)
+	SendQueueSize int `mapstructure:"send_queue_size" yaml:"send_queue_size"`
+}
+
+// validatePayloadSize checks that packets of max_payload_size fit a
+// WebSocket message of max_message_size; otherwise they would be dropped.
+func (c ServerConnectionConfig) validatePayloadSize() error {
+	if c.MaxMessageSize <= protocol.PacketOverhead {
+		return fmt.Errorf("invalid max_message_size: %d (must be above %d)", c.MaxMessageSize, protocol.PacketOverhead)
+	}
+	if c.MaxPayloadSize < 0 || c.MaxPayloadSize > protocol.MaxJumboPayloadSize {
+		return fmt.Errorf("invalid max_payload_size: %d (must be 0 to %d)", c.MaxPayloadSize, protocol.MaxJumboPayloadSize)
+	}
+	if need := c.MaxPayloadSize + protocol.PacketOverhead; c.MaxPayloadSize > 0 && c.MaxMessageSize < need {
+		return fmt.Errorf("max_message_size %d is too small for max_payload_size %d: packets need up to %d bytes",
+			c.MaxMessageSize, c.MaxPayloadSize, need)
+	}
+	return nil
+}
+
+// TCPConfig holds TCP socket options for raw connections: tunnel listeners
+// and dials, SOCKS5 and port-forward connections on the client, and
+// destination connections on the server. KeepaliveIdle, KeepaliveInterval
+// and KeepaliveCount tune TCP keepalive probes; UserTimeout drops a
+// connection whose sent data stays unacknowledged that long (Linux only,
+// 0 keeps the OS default).
+type TCPConfig struct {
+	NoDelay           bool          `mapstructure:"nodelay" yaml:"nodelay"`
+	Keepalive         bool          `mapstructure:"keepalive" yaml:"keepalive"`
+	KeepaliveIdle     time.Duration `mapstructure:"keepalive_idle" yaml:"keepalive_idle"`
+	KeepaliveInterval time.Duration `mapstructure:"keepalive_interval" yaml:"keepalive_interval"`
+	KeepaliveCount    int           `mapstructure:"keepalive_count" yaml:"keepalive_count"`
+	UserTimeout       time.Duration `mapstructure:"user_timeout" yaml:"user_timeout"`
+}
+
+// DefaultTCPConfig returns the default TCP socket options.
+func DefaultTCPConfig() TCPConfig {
+	return TCPConfig{
+		NoDelay:           true,
+		Keepalive:         true,
+		KeepaliveIdle:     30 * time.Second,
+		KeepaliveInterval: 10 * time.Second,
+		KeepaliveCount:    3,
+	}
+}
+
+// SocketOptions returns the options in the form the transports apply them.
+func (t TCPConfig) SocketOptions() *sockopt.TCPConfig {
+	return &sockopt.TCPConfig{
+		NoDelay:           t.NoDelay,
+		KeepAlive:         t.Keepalive,
+		KeepAliveIdle:     t.KeepaliveIdle,
+		KeepAliveInterval: t.KeepaliveInterval,
+		KeepAliveCount:    t.KeepaliveCount,
+		UserTimeout:       t.UserTimeout,
+	}
+}
+
+// validate checks the TCP socket options.
+func (t TCPConfig) validate() error {
+	if t.KeepaliveIdle < 0 || t.KeepaliveInterval < 0 || t.KeepaliveCount < 0 {
+		return fmt.Errorf("tcp keepalive_idle, keepalive_interval and keepalive_count must not be negative")
+	}
+	if t.UserTimeout < 0 {
+		return fmt.Errorf("tcp user_timeout must not be negative")
+	}
+	return nil
+}
+
+// setTCPDefaults registers the TCP socket option defaults under prefix.
+func setTCPDefaults(v *viper.Viper, prefix string) {
+	defaults := DefaultTCPConfig()
+	v.SetDefault(prefix+".nodelay", defaults.NoDelay)
+	v.SetDefault(prefix+".keepalive", defaults.Keepalive)
+	v.SetDefault(prefix+".keepalive_idle", defaults.KeepaliveIdle)
+	v.SetDefault(prefix+".keepalive_interval", defaults.KeepaliveInterval)
+	v.SetDefault(prefix+".keepalive_count", defaults.KeepaliveCount)
+	v.SetDefault(prefix+".user_timeout", defaults.UserTimeout)
+}
+
+// CircuitBreakerConfig holds circuit breaker settings. On the server, after
+// MaxFailures consecutive failed dials to a destination, streams to it fail
+// immediately for Timeout; then HalfOpenRequests trial dials decide whether
+// it recovered. On the client, it pauses reconnects the same way.
+type CircuitBreakerConfig struct {
+	Enabled          bool          `mapstructure:"enabled" yaml:"enabled"`
+	MaxFailures      int           `mapstructure:"max_failures" yaml:"max_failures"`
+	Timeout          time.Duration `mapstructure:"timeout" yaml:"timeout"`
+	HalfOpenRequests int           `mapstructure:"half_open_requests" yaml:"half_open_requests"`
+}
+
+// validate checks the circuit breaker settings.
+func (c CircuitBreakerConfig) validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.MaxFailures < 1 {
+		return fmt.Errorf("invalid circuit_breaker max_failures: %d", c.MaxFailures)
+	}
+	if c.Timeout <= 0 {
+		return fmt.Errorf("circuit_breaker timeout must be positive")
+	}
+	if c.HalfOpenRequests < 1 {
+		return fmt.Errorf("invalid circuit_breaker half_open_requests: %d", c.HalfOpenRequests)
+	}
+	return nil
 }
 
 // EncryptionConfig holds encryption settings.
 type EncryptionConfig struct {
-	Enabled   bool   `mapstructure:"enabled"`
-	Algorithm string `mapstructure:"algorithm"`
+	Enabled   bool   `mapstructure:"enabled" yaml:"enabled"`
+	Algorithm string `mapstructure:"algorithm" yaml:"algorithm"`
 }
 
-// LoggingConfig holds logging configuration.
+// LoggingConfig holds logging configuration. Output is a file path or one of
+// stdout, stderr, syslog, journald or eventlog; Components overrides Level for
+// single components, e.g. transport: debug.
 type LoggingConfig struct {
-	Level  string `mapstructure:"level"`
-	Format string `mapstructure:"format"`
-	Output string `mapstructure:"output"`
+	Level      string            `mapstructure:"level" yaml:"level"`
+	Format     string            `mapstructure:"format" yaml:"format"`
+	Output     string            `mapstructure:"output" yaml:"output"`
+	Tag        string            `mapstructure:"tag" yaml:"tag"`
+	Components map[string]string `mapstructure:"components" yaml:"components"`
+	Rotation   LogRotationConfig `mapstructure:"rotation" yaml:"rotation"`
+}
+
+// LogRotationConfig rotates a log file by size and/or time, keeping
+// MaxBackups files no older than MaxAge. Zero values disable a limit.
+type LogRotationConfig struct {
+	MaxSize    string        `mapstructure:"max_size" yaml:"max_size"`
+	Interval   time.Duration `mapstructure:"interval" yaml:"interval"`
+	MaxBackups int           `mapstructure:"max_backups" yaml:"max_backups"`
+	MaxAge     time.Duration `mapstructure:"max_age" yaml:"max_age"`
+	Compress   bool          `mapstructure:"compress" yaml:"compress"`
+}
+
+// LoggerConfig converts the settings for logger.New.
+func (l LoggingConfig) LoggerConfig() (logger.Config, error) {
+	cfg := logger.Config{
+		Level:      l.Level,
+		Format:     l.Format,
+		Output:     l.Output,
+		Tag:        l.Tag,
+		Components: l.Components,
+		Rotation: logger.RotateConfig{
+			Interval:   l.Rotation.Interval,
+			MaxBackups: l.Rotation.MaxBackups,
+			MaxAge:     l.Rotation.MaxAge,
+			Compress:   l.Rotation.Compress,
+		},
+	}
+	if l.Rotation.MaxSize != "" {
+		size, err := ParseByteSize(l.Rotation.MaxSize)
+		if err != nil {
+			return logger.Config{}, fmt.Errorf("invalid log rotation max_size: %w", err)
+		}
+		cfg.Rotation.MaxSize = size
+	}
+	return cfg, nil
+}
+
+// validate checks the levels and rotation settings.
+func (l LoggingConfig) validate() error {
+	if l.Level != "" {
+		if err := logger.ValidateLevel(l.Level); err != nil {
+			return err
+		}
+	}
+	for component, level := range l.Components {
+		if err := logger.ValidateLevel(level); err != nil {
+			return fmt.Errorf("component %s: %w", component, err)
+		}
+	}
+	if l.Rotation.Interval < 0 || l.Rotation.MaxAge < 0 || l.Rotation.MaxBackups < 0 {
+		return fmt.Errorf("log rotation interval, max_age and max_backups must not be negative")
+	}
+	_, err := l.LoggerConfig()
+	return err
+}
+
+// ClusterConfig lists the other servers sharing clients behind one name,
+// e.g. with DNS round-robin. Every node must list all other nodes, identified
+// by server.name, and use the same secret and path token secret. The secret
+// signs the client address and certificate nodes pass along when relaying.
+type ClusterConfig struct {
+	Peers              []ClusterPeerConfig `mapstructure:"peers" yaml:"peers"`
+	Secret             string              `mapstructure:"secret" yaml:"secret"`
+	InsecureSkipVerify bool                `mapstructure:"insecure_skip_verify" yaml:"insecure_skip_verify"`
+}
+
+// ClusterPeerConfig describes a peer server. The URLs address the peer
+// directly, not the shared name, and omit any path token.
+type ClusterPeerConfig struct {
+	Name          string `mapstructure:"name" yaml:"name"`
+	UpstreamURL   string `mapstructure:"upstream_url" yaml:"upstream_url"`
+	DownstreamURL string `mapstructure:"downstream_url" yaml:"downstream_url"`
+}
+
+// validate checks the peer list against this server's name.
+func (c ClusterConfig) validate(self string) error {
+	if len(c.Peers) == 0 {
+		return nil
+	}
+	if self == "" {
+		return fmt.Errorf("cluster peers require server.name to be set")
+	}
+	if c.Secret == "" {
+		return fmt.Errorf("cluster peers require a cluster secret")
+	}
+	names := map[string]bool{self: true}
+	for _, peer := range c.Peers {
+		if peer.Name == "" {
+			return fmt.Errorf("cluster peer name is required")
+		}
+		if names[peer.Name] {
+			return fmt.Errorf("duplicate cluster node name: %s", peer.Name)
+		}
+		names[peer.Name] = true
+		for _, raw := range []string{peer.UpstreamURL, peer.DownstreamURL} {
+			u, err := url.Parse(raw)
+			if err != nil || (u.Scheme != "ws" && u.Scheme != "wss") || u.Host == "" {
+				return fmt.Errorf("invalid URL for cluster peer %s: %q (use ws:// or wss://)", peer.Name, raw)
+			}
+		}
+	}
+	return nil
+}
+
+// ControlConfig holds the local control socket configuration.
+type ControlConfig struct {
+	Enabled bool   `mapstructure:"enabled" yaml:"enabled"`
+	Socket  string `mapstructure:"socket" yaml:"socket"`
+}
+
+// NotifyConfig holds settings for notifications of critical events. They
+// are sent to each configured destination: a Telegram chat, a webhook and
+// email.
+type NotifyConfig struct {
+	Enabled bool `mapstructure:"enabled" yaml:"enabled"`
+	// Events are the events to notify about (empty means all)
+	Events []string `mapstructure:"events" yaml:"events"`
+	// Cooldown is the minimum time between notifications of the same event,
+	// e.g. of the same client's quota
+	Cooldown time.Duration        `mapstructure:"cooldown" yaml:"cooldown"`
+	Telegram TelegramNotifyConfig `mapstructure:"telegram" yaml:"telegram"`
+	Webhook  WebhookNotifyConfig  `mapstructure:"webhook" yaml:"webhook"`
+	Email    EmailNotifyConfig    `mapstructure:"email" yaml:"email"`
+}
+
+// TelegramNotifyConfig holds the Telegram bot notifications are sent
+// through. It is used when BotToken is set.
+type TelegramNotifyConfig struct {
+	BotToken string `mapstructure:"bot_token" yaml:"bot_token"`
+	ChatID   string `mapstructure:"chat_id" yaml:"chat_id"`
+}
+
+// WebhookNotifyConfig holds the URL notifications are posted to as JSON. It
+// is used when URL is set.
+type WebhookNotifyConfig struct {
+	URL     string            `mapstructure:"url" yaml:"url"`
+	Headers map[string]string `mapstructure:"headers" yaml:"headers"`
+}
+
+// EmailNotifyConfig holds the SMTP server notifications are emailed
+// through. It is used when Host is set.
+type EmailNotifyConfig struct {
+	Host     string   `mapstructure:"host" yaml:"host"`
+	Port     int      `mapstructure:"port" yaml:"port"`
+	Username string   `mapstructure:"username" yaml:"username"`
+	Password string   `mapstructure:"password" yaml:"password"`
+	From     string   `mapstructure:"from" yaml:"from"`
+	To       []string `mapstructure:"to" yaml:"to"`
+}
+
+// DefaultNotifyConfig returns the default notification settings.
+func DefaultNotifyConfig() NotifyConfig {
+	return NotifyConfig{
+		Enabled:  false,
+		Events:   []string{},
+		Cooldown: time.Hour,
+		Webhook: WebhookNotifyConfig{
+			Headers: map[string]string{},
+		},
+		Email: EmailNotifyConfig{
+			Port: 587,
+			To:   []string{},
+		},
+	}
+}
+
+// validate checks the notification settings against the events the client
+// or server sends.
+func (n NotifyConfig) validate(events []notify.Event) error {
+	if !n.Enabled {
+		return nil
+	}
+	for _, event := range n.Events {
+		if !slices.Contains(events, notify.Event(event)) {
+			return fmt.Errorf("invalid notifications event: %s (use %s)", event, joinEvents(events))
+		}
+	}
+	if n.Cooldown < 0 {
+		return fmt.Errorf("notifications cooldown must not be negative")
+	}
+	if n.Telegram.BotToken == "" && n.Webhook.URL == "" && n.Email.Host == "" {
+		return fmt.Errorf("notifications need a telegram bot_token, webhook url or email host")
+	}
+	if n.Telegram.BotToken != "" && n.Telegram.ChatID == "" {
+		return fmt.Errorf("notifications telegram chat_id is required with a bot_token")
+	}
+	if n.Webhook.URL != "" {
+		u, err := url.Parse(n.Webhook.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid notifications webhook url: %q (use http:// or https://)", n.Webhook.URL)
+		}
+	}
+	if n.Email.Host != "" {
+		if n.Email.Port <= 0 || n.Email.Port > 65535 {
+			return fmt.Errorf("invalid notifications email port: %d", n.Email.Port)
+		}
+		if n.Email.From == "" || len(n.Email.To) == 0 {
+			return fmt.Errorf("notifications email from and to are required with a host")
+		}
+	}
+	return nil
+}
+
+// joinEvents lists events for error messages.
+func joinEvents(events []notify.Event) string {
+	names := make([]string, len(events))
+	for i, event := range events {
+		names[i] = string(event)
+	}
+	return strings.Join(names, ", ")
+}
+
+// NotifierConfig converts the settings for notify.New, naming the sender
+// source. It returns nil when notifications are disabled.
+func (n NotifyConfig) NotifierConfig(source string) *notify.Config {
+	if !n.Enabled {
+		return nil
+	}
+	cfg := &notify.Config{
+		Source:   source,
+		Cooldown: n.Cooldown,
+	}
+	for _, event := range n.Events {
+		cfg.Events = append(cfg.Events, notify.Event(event))
+	}
+	if n.Telegram.BotToken != "" {
+		cfg.Senders = append(cfg.Senders, &notify.Telegram{BotToken: n.Telegram.BotToken, ChatID: n.Telegram.ChatID})
+	}
+	if n.Webhook.URL != "" {
+		cfg.Senders = append(cfg.Senders, &notify.Webhook{URL: n.Webhook.URL, Headers: n.Webhook.Headers})
+	}
+	if n.Email.Host != "" {
+		cfg.Senders = append(cfg.Senders, &notify.Email{
+			Host:     n.Email.Host,
+			Port:     n.Email.Port,
+			Username: n.Email.Username,
+			Password: n.Email.Password,
+			From:     n.Email.From,
+			To:       n.Email.To,
+		})
+	}
+	return cfg
+}
+
+// setNotifyDefaults registers the notification defaults under prefix.
+func setNotifyDefaults(v *viper.Viper, prefix string) {
+	defaults := DefaultNotifyConfig()
+	v.SetDefault(prefix+".enabled", defaults.Enabled)
+	v.SetDefault(prefix+".events", defaults.Events)
+	v.SetDefault(prefix+".cooldown", defaults.Cooldown)
+	v.SetDefault(prefix+".telegram.bot_token", defaults.Telegram.BotToken)
+	v.SetDefault(prefix+".telegram.chat_id", defaults.Telegram.ChatID)
+	v.SetDefault(prefix+".webhook.url", defaults.Webhook.URL)
+	v.SetDefault(prefix+".webhook.headers", defaults.Webhook.Headers)
+	v.SetDefault(prefix+".email.host", defaults.Email.Host)
+	v.SetDefault(prefix+".email.port", defaults.Email.Port)
+	v.SetDefault(prefix+".email.username", defaults.Email.Username)
+	v.SetDefault(prefix+".email.password", defaults.Email.Password)
+	v.SetDefault(prefix+".email.from", defaults.Email.From)
+	v.SetDefault(prefix+".email.to", defaults.Email.To)
+}
+
+// ServerNotifyConfig holds the server's notification settings.
+type ServerNotifyConfig struct {
+	NotifyConfig `mapstructure:",squash" yaml:",inline"`
+	// CertExpiryWarning is how long before a TLS certificate expires to
+	// notify about it
+	CertExpiryWarning time.Duration `mapstructure:"cert_expiry_warning" yaml:"cert_expiry_warning"`
+}
+
+// serverNotifyEvents are the events the server sends.
+var serverNotifyEvents = []notify.Event{notify.EventCertExpiring, notify.EventQuotaExceeded, notify.EventServerStarted}
+
+// validate checks the server's notification settings.
+func (n ServerNotifyConfig) validate() error {
+	if err := n.NotifyConfig.validate(serverNotifyEvents); err != nil {
+		return err
+	}
+	if n.Enabled && n.CertExpiryWarning < 0 {
+		return fmt.Errorf("notifications cert_expiry_warning must not be negative")
+	}
+	return nil
 }
 
 // ObservConfig holds observability configuration.
 type ObservConfig struct {
-	Metrics MetricsConfig `mapstructure:"metrics"`
-	Health  HealthConfig  `mapstructure:"health"`
+	Metrics MetricsConfig `mapstructure:"metrics" yaml:"metrics"`
+	Health  HealthConfig  `mapstructure:"health" yaml:"health"`
+	Admin   AdminConfig   `mapstructure:"admin" yaml:"admin"`
+	Debug   DebugConfig   `mapstructure:"debug" yaml:"debug"`
+	Audit   AuditConfig   `mapstructure:"audit" yaml:"audit"`
+}
+
+// AuditConfig enables the audit log, a JSON record of every stream opened
+// and closed that is written regardless of the log level. Output is a file
+// path or "syslog".
+type AuditConfig struct {
+	Enabled bool   `mapstructure:"enabled" yaml:"enabled"`
+	Output  string `mapstructure:"output" yaml:"output"`
 }
 
 // MetricsConfig holds metrics endpoint configuration.
 type MetricsConfig struct {
-	Enabled bool   `mapstructure:"enabled"`
-	Port    int    `mapstructure:"port"`
-	Path    string `mapstructure:"path"`
+	Enabled      bool               `mapstructure:"enabled" yaml:"enabled"`
+	Port         int                `mapstructure:"port" yaml:"port"`
+	Path         string             `mapstructure:"path" yaml:"path"`
+	StreamLabels StreamLabelsConfig `mapstructure:"stream_labels" yaml:"stream_labels"`
+}
+
+// StreamLabelsConfig bounds the labels of per-destination stream metrics.
+type StreamLabelsConfig struct {
+	// MaxDestHosts caps distinct dest_host values; later hosts are reported as "other"
+	MaxDestHosts int `mapstructure:"max_dest_hosts" yaml:"max_dest_hosts"`
+	// HashDestHosts reports hosts as a short hash instead of the hostname
+	HashDestHosts bool `mapstructure:"hash_dest_hosts" yaml:"hash_dest_hosts"`
 }
 
 // HealthConfig holds health endpoint configuration.
 type HealthConfig struct {
-	Enabled bool   `mapstructure:"enabled"`
-	Port    int    `mapstructure:"port"`
-	Path    string `mapstructure:"path"`
+	Enabled bool   `mapstructure:"enabled" yaml:"enabled"`
+	Port    int    `mapstructure:"port" yaml:"port"`
+	Path    string `mapstructure:"path" yaml:"path"`
+	// EchoProbe (client only) makes readiness send data through the tunnel
+	// to the server's echo diagnostic target
+	EchoProbe bool `mapstructure:"echo_probe" yaml:"echo_probe"`
+}
+
+// AdminConfig holds admin/status API configuration.
+type AdminConfig struct {
+	Enabled bool   `mapstructure:"enabled" yaml:"enabled"`
+	Listen  string `mapstructure:"listen" yaml:"listen"`
+	Port    int    `mapstructure:"port" yaml:"port"`
+	Path    string `mapstructure:"path" yaml:"path"`
+	// Token, if set, is required as "Authorization: Bearer <token>"
+	Token string `mapstructure:"token" yaml:"token"`
+}
+
+// Addr returns the admin API listen address.
+func (a AdminConfig) Addr() string {
+	return net.JoinHostPort(a.Listen, strconv.Itoa(a.Port))
+}
+
+// validate checks the admin API settings when enabled.
+func (a AdminConfig) validate() error {
+	if !a.Enabled {
+		return nil
+	}
+	if a.Port <= 0 || a.Port > 65535 {
+		return fmt.Errorf("invalid admin port: %d", a.Port)
+	}
+	if !strings.HasPrefix(a.Path, "/") {
+		return fmt.Errorf("admin path must start with '/': %q", a.Path)
+	}
+	return nil
+}
+
+// DebugConfig holds the profiling and runtime debug server configuration.
+// The endpoints expose internals of the process and have no
+// authentication; keep the server on localhost.
+type DebugConfig struct {
+	Enabled bool   `mapstructure:"enabled" yaml:"enabled"`
+	Listen  string `mapstructure:"listen" yaml:"listen"`
+	Port    int    `mapstructure:"port" yaml:"port"`
+	// DumpDir receives goroutine and heap dumps (empty = the temp directory)
+	DumpDir string `mapstructure:"dump_dir" yaml:"dump_dir"`
+}
+
+// Addr returns the debug server listen address.
+func (d DebugConfig) Addr() string {
+	return net.JoinHostPort(d.Listen, strconv.Itoa(d.Port))
+}
+
+// validate checks the debug server settings when enabled.
+func (d DebugConfig) validate() error {
+	if !d.Enabled {
+		return nil
+	}
+	if d.Port <= 0 || d.Port > 65535 {
+		return fmt.Errorf("invalid debug port: %d", d.Port)
+	}
+	return nil
 }
 
 // DefaultServerConfig returns a ServerConfig with sensible defaults.
@@ -109,10 +1176,18 @@ func DefaultServerConfig() *ServerConfig {
 		Server: ServerSettings{
 			Name:            "exit-server-01",
 			ExitOnPortInUse: false,
+			PathToken: PathTokenConfig{
+				Window: 5 * time.Minute,
+			},
+			UpgradeCookie: UpgradeCookieConfig{
+				Window: 5 * time.Minute,
+				Name:   pathtoken.DefaultCookie,
+			},
 			Upstream: ServerEndpoint{
-				Host: "0.0.0.0",
-				Port: 8443,
-				Path: "/ws/upstream",
+				Host:     "0.0.0.0",
+				Port:     8443,
+				IPFamily: "dual",
+				Path:     "/ws/upstream",
 				TLS: ServerTLSConfig{
 					Enabled:  false,
 					CertFile: "",
@@ -120,9 +1195,10 @@ func DefaultServerConfig() *ServerConfig {
 				},
 			},
 			Downstream: ServerEndpoint{
-				Host: "0.0.0.0",
-				Port: 8444,
-				Path: "/ws/downstream",
+				Host:     "0.0.0.0",
+				Port:     8444,
+				IPFamily: "dual",
+				Path:     "/ws/downstream",
 				TLS: ServerTLSConfig{
 					Enabled:  false,
 					CertFile: "",
@@ -134,23 +1210,99 @@ func DefaultServerConfig() *ServerConfig {
 			AllowedNetworks:      []string{"0.0.0.0/0", "::/0"},
 			BlockedNetworks:      []string{},
 			MaxStreamsPerSession: 100,
+			MaxConnectionsPerIP:  0,
+			Guest: GuestConfig{
+				Enabled:          false,
+				Secret:           "",
+				Required:         false,
+				WarnBefore:       5 * time.Minute,
+				WarnTrafficRatio: 0.9,
+			},
+			DialRetry: DialRetryConfig{
+				Attempts:   1,
+				Backoff:    250 * time.Millisecond,
+				MaxBackoff: 5 * time.Second,
+			},
+			Policy: PolicyConfig{
+				BlockPrivate: true,
+				BlockedPorts: []int{25},
+			},
+			Quotas: QuotaConfig{
+				Enabled:       false,
+				StateFile:     "/var/lib/half-tunnel/quotas.json",
+				FlushInterval: time.Minute,
+			},
+			ConnectionLimits: ConnLimitsConfig{
+				Enabled:           false,
+				AttemptsPerMinute: 30,
+				Burst:             10,
+				MaxFailures:       5,
+				FailureWindow:     10 * time.Minute,
+				BanDuration:       time.Hour,
+				FlushInterval:     time.Minute,
+			},
 		},
 		Tunnel: ServerTunnelConfig{
 			Session: ServerSessionConfig{
-				Timeout:     5 * time.Minute,
-				MaxSessions: 1000,
+				Timeout:        5 * time.Minute,
+				MaxSessions:    1000,
+				Eviction:       "reject",
+				EvictIdleAfter: 10 * time.Minute,
+				Store: SessionStoreConfig{
+					Backend: "memory",
+					Redis: RedisStoreConfig{
+						Addr:   "127.0.0.1:6379",
+						Prefix: "half-tunnel:",
+					},
+				},
 			},
 			Connection: ServerConnectionConfig{
-				ReadBufferSize:    32768,
-				WriteBufferSize:   32768,
-				KeepaliveInterval: 30 * time.Second,
-				MaxMessageSize:    65536,
+				ReadBufferSize:      32768,
+				WriteBufferSize:     32768,
+				KeepaliveInterval:   30 * time.Second,
+				MaxMessageSize:      65536,
+				SlowDialThreshold:   2 * time.Second,
+				MaxConcurrentDials:  256,
+				TCP:                 DefaultTCPConfig(),
+				AcceptQueueSize:     100,
+				CorruptPacketPolicy: CorruptPacketReset,
+				SendQueueSize:       256,
+			},
+			CircuitBreaker: CircuitBreakerConfig{
+				Enabled:          true,
+				MaxFailures:      5,
+				Timeout:          30 * time.Second,
+				HalfOpenRequests: 1,
+			},
+			DataFlow: ServerDataFlowConfig{
+				Enabled:        true,
+				CheckInterval:  30 * time.Second,
+				StallThreshold: 2 * time.Minute,
+			},
+			Liveness: ServerLivenessConfig{
+				IdleTimeout:   10 * time.Minute,
+				ProbeInterval: 10 * time.Second,
+				ProbeCount:    3,
+			},
+			ResourceGuard: ResourceGuardConfig{
+				Enabled:       true,
+				CheckInterval: 5 * time.Second,
+				ShedAt:        0.9,
 			},
 			Encryption: EncryptionConfig{
 				Enabled:   true,
 				Algorithm: "aes-256-gcm",
 			},
 		},
+		Egress: EgressConfig{
+			DNS: ResolverConfig{
+				Servers:   []string{},
+				Timeout:   5 * time.Second,
+				Prefer:    "auto",
+				CacheTTL:  time.Minute,
+				CacheSize: 1024,
+			},
+		},
 		Logging: LoggingConfig{
 			Level:  "info",
 			Format: "json",
@@ -167,6 +1319,29 @@ func DefaultServerConfig() *ServerConfig {
 				Port:    8080,
 				Path:    "/healthz",
 			},
+			Admin: AdminConfig{
+				Enabled: false,
+				Listen:  "127.0.0.1",
+				Port:    7070,
+				Path:    "/api",
+			},
+			Debug: DebugConfig{
+				Enabled: false,
+				Listen:  "127.0.0.1",
+				Port:    6060,
+			},
+			Audit: AuditConfig{
+				Enabled: false,
+				Output:  "/var/log/half-tunnel/audit.log",
+			},
+		},
+		Control: ControlConfig{
+			Enabled: true,
+			Socket:  "/run/half-tunnel/server.sock",
+		},
+		Notifications: ServerNotifyConfig{
+			NotifyConfig:      DefaultNotifyConfig(),
+			CertExpiryWarning: 14 * 24 * time.Hour,
 		},
 	}
 }
@@ -181,9 +1356,10 @@ func LoadServerConfig(configPath string) (*ServerConfig, error) {
 	// Set config file
 	if configPath != "" {
 		v.SetConfigFile(configPath)
+		v.SetConfigType(FormatFromPath(configPath))
 	} else {
+		// Finds server.json, server.toml, server.yaml or server.yml
 		v.SetConfigName("server")
-		v.SetConfigType("yaml")
 		v.AddConfigPath(".")
 		v.AddConfigPath("./configs")
 		v.AddConfigPath("/etc/half-tunnel/")
@@ -204,8 +1380,8 @@ func LoadServerConfig(configPath string) (*ServerConfig, error) {
 	}
 
 	var cfg ServerConfig
-	if err := v.Unmarshal(&cfg); err != nil {
-		return nil, fmt.Errorf("error unmarshaling config: %w", err)
+	if err := unmarshalResolved(v, &cfg); err != nil {
+		return nil, err
 	}
 
 	return &cfg, nil
@@ -225,27 +1401,84 @@ func setServerDefaults(v *viper.Viper) {
 
 	v.SetDefault("server.name", defaults.Server.Name)
 	v.SetDefault("server.exit_on_port_in_use", defaults.Server.ExitOnPortInUse)
+	v.SetDefault("server.path_token.window", defaults.Server.PathToken.Window)
+	v.SetDefault("server.upgrade_cookie.window", defaults.Server.UpgradeCookie.Window)
+	v.SetDefault("server.upgrade_cookie.name", defaults.Server.UpgradeCookie.Name)
 	v.SetDefault("server.upstream.host", defaults.Server.Upstream.Host)
 	v.SetDefault("server.upstream.port", defaults.Server.Upstream.Port)
+	v.SetDefault("server.upstream.ip_family", defaults.Server.Upstream.IPFamily)
 	v.SetDefault("server.upstream.path", defaults.Server.Upstream.Path)
 	v.SetDefault("server.upstream.tls.enabled", defaults.Server.Upstream.TLS.Enabled)
 	v.SetDefault("server.downstream.host", defaults.Server.Downstream.Host)
 	v.SetDefault("server.downstream.port", defaults.Server.Downstream.Port)
+	v.SetDefault("server.downstream.ip_family", defaults.Server.Downstream.IPFamily)
 	v.SetDefault("server.downstream.path", defaults.Server.Downstream.Path)
 	v.SetDefault("server.downstream.tls.enabled", defaults.Server.Downstream.TLS.Enabled)
 
 	v.SetDefault("access.allowed_networks", defaults.Access.AllowedNetworks)
 	v.SetDefault("access.blocked_networks", defaults.Access.BlockedNetworks)
 	v.SetDefault("access.max_streams_per_session", defaults.Access.MaxStreamsPerSession)
+	v.SetDefault("access.max_connections_per_ip", defaults.Access.MaxConnectionsPerIP)
+	v.SetDefault("access.guest.enabled", defaults.Access.Guest.Enabled)
+	v.SetDefault("access.guest.required", defaults.Access.Guest.Required)
+	v.SetDefault("access.guest.warn_before", defaults.Access.Guest.WarnBefore)
+	v.SetDefault("access.guest.warn_traffic_ratio", defaults.Access.Guest.WarnTrafficRatio)
+	v.SetDefault("access.dial_retry.attempts", defaults.Access.DialRetry.Attempts)
+	v.SetDefault("access.dial_retry.backoff", defaults.Access.DialRetry.Backoff)
+	v.SetDefault("access.dial_retry.max_backoff", defaults.Access.DialRetry.MaxBackoff)
+	v.SetDefault("access.policy.block_private", defaults.Access.Policy.BlockPrivate)
+	v.SetDefault("access.policy.blocked_ports", defaults.Access.Policy.BlockedPorts)
+	v.SetDefault("access.client_auth.required", defaults.Access.ClientAuth.Required)
+	v.SetDefault("access.quotas.enabled", defaults.Access.Quotas.Enabled)
+	v.SetDefault("access.quotas.state_file", defaults.Access.Quotas.StateFile)
+	v.SetDefault("access.quotas.flush_interval", defaults.Access.Quotas.FlushInterval)
+	v.SetDefault("access.connection_limits.enabled", defaults.Access.ConnectionLimits.Enabled)
+	v.SetDefault("access.connection_limits.attempts_per_minute", defaults.Access.ConnectionLimits.AttemptsPerMinute)
+	v.SetDefault("access.connection_limits.burst", defaults.Access.ConnectionLimits.Burst)
+	v.SetDefault("access.connection_limits.max_failures", defaults.Access.ConnectionLimits.MaxFailures)
+	v.SetDefault("access.connection_limits.failure_window", defaults.Access.ConnectionLimits.FailureWindow)
+	v.SetDefault("access.connection_limits.ban_duration", defaults.Access.ConnectionLimits.BanDuration)
+	v.SetDefault("access.connection_limits.state_file", defaults.Access.ConnectionLimits.StateFile)
+	v.SetDefault("access.connection_limits.flush_interval", defaults.Access.ConnectionLimits.FlushInterval)
 
 	v.SetDefault("tunnel.session.timeout", defaults.Tunnel.Session.Timeout)
 	v.SetDefault("tunnel.session.max_sessions", defaults.Tunnel.Session.MaxSessions)
+	v.SetDefault("tunnel.session.eviction", defaults.Tunnel.Session.Eviction)
+	v.SetDefault("tunnel.session.evict_idle_after", defaults.Tunnel.Session.EvictIdleAfter)
+	v.SetDefault("tunnel.session.store.backend", defaults.Tunnel.Session.Store.Backend)
+	v.SetDefault("tunnel.session.store.redis.addr", defaults.Tunnel.Session.Store.Redis.Addr)
+	v.SetDefault("tunnel.session.store.redis.prefix", defaults.Tunnel.Session.Store.Redis.Prefix)
 	v.SetDefault("tunnel.connection.read_buffer_size", defaults.Tunnel.Connection.ReadBufferSize)
 	v.SetDefault("tunnel.connection.write_buffer_size", defaults.Tunnel.Connection.WriteBufferSize)
 	v.SetDefault("tunnel.connection.keepalive_interval", defaults.Tunnel.Connection.KeepaliveInterval)
 	v.SetDefault("tunnel.connection.max_message_size", defaults.Tunnel.Connection.MaxMessageSize)
+	v.SetDefault("tunnel.connection.max_payload_size", defaults.Tunnel.Connection.MaxPayloadSize)
+	v.SetDefault("tunnel.connection.corrupt_packet_policy", defaults.Tunnel.Connection.CorruptPacketPolicy)
+	v.SetDefault("tunnel.connection.send_queue_size", defaults.Tunnel.Connection.SendQueueSize)
+	v.SetDefault("tunnel.connection.slow_dial_threshold", defaults.Tunnel.Connection.SlowDialThreshold)
+	v.SetDefault("tunnel.connection.max_concurrent_dials", defaults.Tunnel.Connection.MaxConcurrentDials)
+	v.SetDefault("tunnel.connection.accept_queue_size", defaults.Tunnel.Connection.AcceptQueueSize)
+	setTCPDefaults(v, "tunnel.connection.tcp")
+	v.SetDefault("tunnel.circuit_breaker.enabled", defaults.Tunnel.CircuitBreaker.Enabled)
+	v.SetDefault("tunnel.circuit_breaker.max_failures", defaults.Tunnel.CircuitBreaker.MaxFailures)
+	v.SetDefault("tunnel.circuit_breaker.timeout", defaults.Tunnel.CircuitBreaker.Timeout)
+	v.SetDefault("tunnel.circuit_breaker.half_open_requests", defaults.Tunnel.CircuitBreaker.HalfOpenRequests)
+	v.SetDefault("tunnel.dataflow.enabled", defaults.Tunnel.DataFlow.Enabled)
+	v.SetDefault("tunnel.dataflow.check_interval", defaults.Tunnel.DataFlow.CheckInterval)
+	v.SetDefault("tunnel.dataflow.stall_threshold", defaults.Tunnel.DataFlow.StallThreshold)
+	v.SetDefault("tunnel.liveness.enabled", defaults.Tunnel.Liveness.Enabled)
+	v.SetDefault("tunnel.liveness.idle_timeout", defaults.Tunnel.Liveness.IdleTimeout)
+	v.SetDefault("tunnel.liveness.probe_interval", defaults.Tunnel.Liveness.ProbeInterval)
+	v.SetDefault("tunnel.liveness.probe_count", defaults.Tunnel.Liveness.ProbeCount)
+	v.SetDefault("tunnel.resource_guard.enabled", defaults.Tunnel.ResourceGuard.Enabled)
+	v.SetDefault("tunnel.resource_guard.check_interval", defaults.Tunnel.ResourceGuard.CheckInterval)
+	v.SetDefault("tunnel.resource_guard.max_open_files", defaults.Tunnel.ResourceGuard.MaxOpenFiles)
+	v.SetDefault("tunnel.resource_guard.max_nat_entries", defaults.Tunnel.ResourceGuard.MaxNatEntries)
+	v.SetDefault("tunnel.resource_guard.max_goroutines", defaults.Tunnel.ResourceGuard.MaxGoroutines)
+	v.SetDefault("tunnel.resource_guard.shed_at", defaults.Tunnel.ResourceGuard.ShedAt)
 	v.SetDefault("tunnel.encryption.enabled", defaults.Tunnel.Encryption.Enabled)
 	v.SetDefault("tunnel.encryption.algorithm", defaults.Tunnel.Encryption.Algorithm)
+	v.SetDefault("tunnel.diagnostics.enabled", defaults.Tunnel.Diagnostics.Enabled)
 
 	v.SetDefault("logging.level", defaults.Logging.Level)
 	v.SetDefault("logging.format", defaults.Logging.Format)
@@ -257,6 +1490,27 @@ func setServerDefaults(v *viper.Viper) {
 	v.SetDefault("observability.health.enabled", defaults.Observability.Health.Enabled)
 	v.SetDefault("observability.health.port", defaults.Observability.Health.Port)
 	v.SetDefault("observability.health.path", defaults.Observability.Health.Path)
+	v.SetDefault("observability.admin.enabled", defaults.Observability.Admin.Enabled)
+	v.SetDefault("observability.admin.listen", defaults.Observability.Admin.Listen)
+	v.SetDefault("observability.admin.port", defaults.Observability.Admin.Port)
+	v.SetDefault("observability.admin.path", defaults.Observability.Admin.Path)
+	v.SetDefault("observability.debug.enabled", defaults.Observability.Debug.Enabled)
+	v.SetDefault("observability.debug.listen", defaults.Observability.Debug.Listen)
+	v.SetDefault("observability.debug.port", defaults.Observability.Debug.Port)
+	v.SetDefault("observability.audit.enabled", defaults.Observability.Audit.Enabled)
+	v.SetDefault("observability.audit.output", defaults.Observability.Audit.Output)
+	v.SetDefault("egress.bind_address", defaults.Egress.BindAddress)
+	v.SetDefault("egress.interface", defaults.Egress.Interface)
+	v.SetDefault("egress.mark", defaults.Egress.Mark)
+	v.SetDefault("egress.dns.servers", defaults.Egress.DNS.Servers)
+	v.SetDefault("egress.dns.timeout", defaults.Egress.DNS.Timeout)
+	v.SetDefault("egress.dns.prefer", defaults.Egress.DNS.Prefer)
+	v.SetDefault("egress.dns.cache_ttl", defaults.Egress.DNS.CacheTTL)
+	v.SetDefault("egress.dns.cache_size", defaults.Egress.DNS.CacheSize)
+	v.SetDefault("control.enabled", defaults.Control.Enabled)
+	v.SetDefault("control.socket", defaults.Control.Socket)
+	setNotifyDefaults(v, "notifications")
+	v.SetDefault("notifications.cert_expiry_warning", defaults.Notifications.CertExpiryWarning)
 }
 
 // Validate validates the server configuration.
@@ -274,6 +1528,9 @@ func (c *ServerConfig) Validate() error {
 		if c.Server.Upstream.TLS.KeyFile == "" {
 			return fmt.Errorf("upstream TLS enabled but key_file not specified")
 		}
+		if c.Server.Upstream.TLS.RequireClientCert && c.Server.Upstream.TLS.ClientCAFile == "" {
+			return fmt.Errorf("upstream require_client_cert set but client_ca_file not specified")
+		}
 	}
 	if c.Server.Downstream.TLS.Enabled {
 		if c.Server.Downstream.TLS.CertFile == "" {
@@ -282,6 +1539,139 @@ func (c *ServerConfig) Validate() error {
 		if c.Server.Downstream.TLS.KeyFile == "" {
 			return fmt.Errorf("downstream TLS enabled but key_file not specified")
 		}
+		if c.Server.Downstream.TLS.RequireClientCert && c.Server.Downstream.TLS.ClientCAFile == "" {
+			return fmt.Errorf("downstream require_client_cert set but client_ca_file not specified")
+		}
+	}
+	upstreamFamily, err := sockopt.ParseFamily(c.Server.Upstream.IPFamily)
+	if err != nil {
+		return fmt.Errorf("upstream %w", err)
+	}
+	downstreamFamily, err := sockopt.ParseFamily(c.Server.Downstream.IPFamily)
+	if err != nil {
+		return fmt.Errorf("downstream %w", err)
+	}
+	if c.Server.SinglePort() {
+		if upstreamFamily != downstreamFamily {
+			return fmt.Errorf("upstream and downstream share a port and need the same ip_family")
+		}
+		if strings.TrimSuffix(c.Server.Upstream.Path, "/") == strings.TrimSuffix(c.Server.Downstream.Path, "/") {
+			return fmt.Errorf("upstream and downstream share a port and need different paths")
+		}
+		if c.Server.Upstream.TLS.Enabled != c.Server.Downstream.TLS.Enabled {
+			return fmt.Errorf("upstream and downstream share a port and must both enable or disable TLS")
+		}
+	}
+	if c.Server.PathToken.Secret != "" && c.Server.PathToken.Window <= 0 {
+		return fmt.Errorf("invalid path_token window: %v", c.Server.PathToken.Window)
+	}
+	if err := c.Server.UpgradeCookie.validate(); err != nil {
+		return err
+	}
+	if c.Server.Decoy.Dir != "" && c.Server.Decoy.ProxyURL != "" {
+		return fmt.Errorf("decoy dir and proxy_url are mutually exclusive")
+	}
+	if c.Server.Decoy.ProxyURL != "" {
+		u, err := url.Parse(c.Server.Decoy.ProxyURL)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("invalid decoy proxy_url: %s", c.Server.Decoy.ProxyURL)
+		}
+	}
+	if c.Access.MaxConnectionsPerIP < 0 {
+		return fmt.Errorf("invalid max_connections_per_ip: %d", c.Access.MaxConnectionsPerIP)
+	}
+	if c.Access.MaxConnectionsPerIP > 0 {
+		// The cap honours the exempt networks even with limits disabled
+		if err := validateNetworks(c.Access.ConnectionLimits.ExemptNetworks); err != nil {
+			return fmt.Errorf("access connection_limits: %w", err)
+		}
+	}
+	if c.Access.Guest.Enabled {
+		if c.Access.Guest.Secret == "" {
+			return fmt.Errorf("guest sessions enabled but secret not specified")
+		}
+		if c.Access.Guest.WarnTrafficRatio <= 0 || c.Access.Guest.WarnTrafficRatio > 1 {
+			return fmt.Errorf("invalid guest warn_traffic_ratio: %v (must be in (0, 1])", c.Access.Guest.WarnTrafficRatio)
+		}
+	}
+	if err := c.Access.DialRetry.validate("access.dial_retry"); err != nil {
+		return err
+	}
+	for i, rule := range c.Access.Rules {
+		if err := rule.validate(); err != nil {
+			return fmt.Errorf("access rule %d: %w", i+1, err)
+		}
+	}
+	if err := validateNetworks(c.Access.AllowedNetworks); err != nil {
+		return fmt.Errorf("allowed_networks: %w", err)
+	}
+	if err := validateNetworks(c.Access.BlockedNetworks); err != nil {
+		return fmt.Errorf("blocked_networks: %w", err)
+	}
+	if err := c.Access.Policy.validate(); err != nil {
+		return fmt.Errorf("access policy: %w", err)
+	}
+	if err := c.Access.ClientAuth.validate(); err != nil {
+		return fmt.Errorf("access client_auth: %w", err)
+	}
+	if err := c.Access.Quotas.validate(); err != nil {
+		return fmt.Errorf("access quotas: %w", err)
+	}
+	if err := c.Access.ConnectionLimits.validate(); err != nil {
+		return fmt.Errorf("access connection_limits: %w", err)
+	}
+	if c.Tunnel.Session.MaxSessions < 0 {
+		return fmt.Errorf("invalid max_sessions: %d", c.Tunnel.Session.MaxSessions)
+	}
+	switch c.Tunnel.Session.Eviction {
+	case "", "reject", "lru":
+		// valid
+	case "idle":
+		if c.Tunnel.Session.EvictIdleAfter <= 0 {
+			return fmt.Errorf("idle session eviction requires a positive evict_idle_after")
+		}
+	default:
+		return fmt.Errorf("invalid session eviction policy: %s (use reject, lru or idle)", c.Tunnel.Session.Eviction)
+	}
+	switch c.Tunnel.Session.Store.Backend {
+	case "", "memory":
+		// valid
+	case "redis":
+		if c.Tunnel.Session.Store.Redis.Addr == "" {
+			return fmt.Errorf("redis session store requires an addr")
+		}
+	default:
+		return fmt.Errorf("invalid session store backend: %s (use memory or redis)", c.Tunnel.Session.Store.Backend)
+	}
+	if c.Tunnel.Connection.MaxConcurrentDials < 0 {
+		return fmt.Errorf("invalid max_concurrent_dials: %d", c.Tunnel.Connection.MaxConcurrentDials)
+	}
+	if c.Tunnel.Connection.AcceptQueueSize < 1 {
+		return fmt.Errorf("invalid accept_queue_size: %d", c.Tunnel.Connection.AcceptQueueSize)
+	}
+	if c.Tunnel.Connection.SendQueueSize < 0 {
+		return fmt.Errorf("invalid send_queue_size: %d", c.Tunnel.Connection.SendQueueSize)
+	}
+	if err := c.Tunnel.Connection.validatePayloadSize(); err != nil {
+		return err
+	}
+	if err := validateCorruptPacketPolicy(c.Tunnel.Connection.CorruptPacketPolicy); err != nil {
+		return err
+	}
+	if err := c.Tunnel.Connection.TCP.validate(); err != nil {
+		return err
+	}
+	if err := c.Tunnel.CircuitBreaker.validate(); err != nil {
+		return err
+	}
+	if err := c.Tunnel.DataFlow.validate(); err != nil {
+		return err
+	}
+	if err := c.Tunnel.Liveness.validate(); err != nil {
+		return err
+	}
+	if err := c.Tunnel.ResourceGuard.validate(); err != nil {
+		return err
 	}
 	if c.Tunnel.Encryption.Enabled {
 		switch c.Tunnel.Encryption.Algorithm {
@@ -291,5 +1681,29 @@ func (c *ServerConfig) Validate() error {
 			return fmt.Errorf("invalid encryption algorithm: %s (use aes-256-gcm or chacha20-poly1305)", c.Tunnel.Encryption.Algorithm)
 		}
 	}
+	if err := c.Logging.validate(); err != nil {
+		return err
+	}
+	if err := c.Observability.Admin.validate(); err != nil {
+		return err
+	}
+	if err := c.Observability.Debug.validate(); err != nil {
+		return err
+	}
+	if c.Observability.Audit.Enabled && c.Observability.Audit.Output == "" {
+		return fmt.Errorf("audit output is required when the audit log is enabled")
+	}
+	if c.Control.Enabled && c.Control.Socket == "" {
+		return fmt.Errorf("control socket path is required when the control socket is enabled")
+	}
+	if err := c.Cluster.validate(c.Server.Name); err != nil {
+		return err
+	}
+	if err := c.Egress.validate(); err != nil {
+		return err
+	}
+	if err := c.Notifications.validate(); err != nil {
+		return err
+	}
 	return nil
 }
@@ -3,6 +3,7 @@ package config
 import (
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 )
@@ -69,6 +70,150 @@ func TestServerConfigValidate(t *testing.T) {
 			},
 			wantErr: true,
 		},
+		{
+			name: "require client cert without CA",
+			modify: func(c *ServerConfig) {
+				c.Server.Downstream.TLS.Enabled = true
+				c.Server.Downstream.TLS.CertFile = "/path/to/cert"
+				c.Server.Downstream.TLS.KeyFile = "/path/to/key"
+				c.Server.Downstream.TLS.RequireClientCert = true
+			},
+			wantErr: true,
+		},
+		{
+			name: "require client cert with CA",
+			modify: func(c *ServerConfig) {
+				c.Server.Downstream.TLS.Enabled = true
+				c.Server.Downstream.TLS.CertFile = "/path/to/cert"
+				c.Server.Downstream.TLS.KeyFile = "/path/to/key"
+				c.Server.Downstream.TLS.ClientCAFile = "/path/to/ca"
+				c.Server.Downstream.TLS.RequireClientCert = true
+			},
+			wantErr: false,
+		},
+		{
+			name: "single port with distinct paths",
+			modify: func(c *ServerConfig) {
+				c.Server.Downstream.Port = c.Server.Upstream.Port
+			},
+			wantErr: false,
+		},
+		{
+			name: "single port with same path",
+			modify: func(c *ServerConfig) {
+				c.Server.Downstream.Port = c.Server.Upstream.Port
+				c.Server.Downstream.Path = c.Server.Upstream.Path
+			},
+			wantErr: true,
+		},
+		{
+			name: "single port with mixed TLS",
+			modify: func(c *ServerConfig) {
+				c.Server.Downstream.Port = c.Server.Upstream.Port
+				c.Server.Upstream.TLS.Enabled = true
+				c.Server.Upstream.TLS.CertFile = "/path/to/cert"
+				c.Server.Upstream.TLS.KeyFile = "/path/to/key"
+			},
+			wantErr: true,
+		},
+		{
+			name: "decoy dir and proxy both set",
+			modify: func(c *ServerConfig) {
+				c.Server.Decoy.Dir = "/var/www/html"
+				c.Server.Decoy.ProxyURL = "https://example.com"
+			},
+			wantErr: true,
+		},
+		{
+			name: "decoy proxy without scheme",
+			modify: func(c *ServerConfig) {
+				c.Server.Decoy.ProxyURL = "example.com"
+			},
+			wantErr: true,
+		},
+		{
+			name: "valid decoy proxy",
+			modify: func(c *ServerConfig) {
+				c.Server.Decoy.ProxyURL = "https://example.com"
+			},
+			wantErr: false,
+		},
+		{
+			name: "connection limits without burst",
+			modify: func(c *ServerConfig) {
+				c.Access.ConnectionLimits.Enabled = true
+				c.Access.ConnectionLimits.Burst = 0
+			},
+			wantErr: true,
+		},
+		{
+			name: "connection limits with invalid exempt network",
+			modify: func(c *ServerConfig) {
+				c.Access.ConnectionLimits.Enabled = true
+				c.Access.ConnectionLimits.ExemptNetworks = []string{"10.0.0.1"}
+			},
+			wantErr: true,
+		},
+		{
+			name: "valid connection limits",
+			modify: func(c *ServerConfig) {
+				c.Access.ConnectionLimits.Enabled = true
+				c.Access.ConnectionLimits.ExemptNetworks = []string{"10.0.0.0/8"}
+			},
+			wantErr: false,
+		},
+		{
+			name: "negative send queue size",
+			modify: func(c *ServerConfig) {
+				c.Tunnel.Connection.SendQueueSize = -1
+			},
+			wantErr: true,
+		},
+		{
+			name: "negative max connections per ip",
+			modify: func(c *ServerConfig) {
+				c.Access.MaxConnectionsPerIP = -1
+			},
+			wantErr: true,
+		},
+		{
+			name: "max connections per ip with invalid exempt network",
+			modify: func(c *ServerConfig) {
+				c.Access.MaxConnectionsPerIP = 8
+				c.Access.ConnectionLimits.ExemptNetworks = []string{"10.0.0.1"}
+			},
+			wantErr: true,
+		},
+		{
+			name: "valid max connections per ip",
+			modify: func(c *ServerConfig) {
+				c.Access.MaxConnectionsPerIP = 8
+			},
+			wantErr: false,
+		},
+		{
+			name: "valid upgrade cookie",
+			modify: func(c *ServerConfig) {
+				c.Server.UpgradeCookie.Secret = "cookie-secret"
+			},
+			wantErr: false,
+		},
+		{
+			name: "upgrade cookie name with separator",
+			modify: func(c *ServerConfig) {
+				c.Server.UpgradeCookie.Secret = "cookie-secret"
+				c.Server.UpgradeCookie.Name = "a;b"
+			},
+			wantErr: true,
+		},
+		{
+			name: "upgrade cookie without window",
+			modify: func(c *ServerConfig) {
+				c.Server.UpgradeCookie.Secret = "cookie-secret"
+				c.Server.UpgradeCookie.Window = 0
+			},
+			wantErr: true,
+		},
 		{
 			name: "invalid encryption algorithm",
 			modify: func(c *ServerConfig) {
@@ -93,6 +238,478 @@ func TestServerConfigValidate(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			name: "admin API enabled",
+			modify: func(c *ServerConfig) {
+				c.Observability.Admin.Enabled = true
+			},
+			wantErr: false,
+		},
+		{
+			name: "invalid admin port",
+			modify: func(c *ServerConfig) {
+				c.Observability.Admin.Enabled = true
+				c.Observability.Admin.Port = 0
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid admin path",
+			modify: func(c *ServerConfig) {
+				c.Observability.Admin.Enabled = true
+				c.Observability.Admin.Path = "api"
+			},
+			wantErr: true,
+		},
+		{
+			name: "lru session eviction",
+			modify: func(c *ServerConfig) {
+				c.Tunnel.Session.Eviction = "lru"
+			},
+			wantErr: false,
+		},
+		{
+			name: "idle eviction without threshold",
+			modify: func(c *ServerConfig) {
+				c.Tunnel.Session.Eviction = "idle"
+				c.Tunnel.Session.EvictIdleAfter = 0
+			},
+			wantErr: true,
+		},
+		{
+			name: "unknown session eviction",
+			modify: func(c *ServerConfig) {
+				c.Tunnel.Session.Eviction = "random"
+			},
+			wantErr: true,
+		},
+		{
+			name: "redis session store",
+			modify: func(c *ServerConfig) {
+				c.Tunnel.Session.Store.Backend = "redis"
+			},
+			wantErr: false,
+		},
+		{
+			name: "zero accept queue size",
+			modify: func(c *ServerConfig) {
+				c.Tunnel.Connection.AcceptQueueSize = 0
+			},
+			wantErr: true,
+		},
+		{
+			name: "negative max concurrent dials",
+			modify: func(c *ServerConfig) {
+				c.Tunnel.Connection.MaxConcurrentDials = -1
+			},
+			wantErr: true,
+		},
+		{
+			name: "payload size above message size",
+			modify: func(c *ServerConfig) {
+				c.Tunnel.Connection.MaxPayloadSize = 65535
+			},
+			wantErr: true,
+		},
+		{
+			name: "jumbo payload size",
+			modify: func(c *ServerConfig) {
+				c.Tunnel.Connection.MaxPayloadSize = 1 << 20
+				c.Tunnel.Connection.MaxMessageSize = 2 << 20
+			},
+			wantErr: false,
+		},
+		{
+			name: "payload size above jumbo limit",
+			modify: func(c *ServerConfig) {
+				c.Tunnel.Connection.MaxPayloadSize = 2 << 20
+				c.Tunnel.Connection.MaxMessageSize = 4 << 20
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid corrupt packet policy",
+			modify: func(c *ServerConfig) {
+				c.Tunnel.Connection.CorruptPacketPolicy = ""
+			},
+			wantErr: true,
+		},
+		{
+			name: "circuit breaker without failure threshold",
+			modify: func(c *ServerConfig) {
+				c.Tunnel.CircuitBreaker.MaxFailures = 0
+			},
+			wantErr: true,
+		},
+		{
+			name: "disabled circuit breaker ignores settings",
+			modify: func(c *ServerConfig) {
+				c.Tunnel.CircuitBreaker.Enabled = false
+				c.Tunnel.CircuitBreaker.Timeout = 0
+			},
+			wantErr: false,
+		},
+		{
+			name: "dataflow stall threshold below check interval",
+			modify: func(c *ServerConfig) {
+				c.Tunnel.DataFlow.StallThreshold = time.Second
+			},
+			wantErr: true,
+		},
+		{
+			name: "disabled dataflow ignores settings",
+			modify: func(c *ServerConfig) {
+				c.Tunnel.DataFlow.Enabled = false
+				c.Tunnel.DataFlow.CheckInterval = 0
+			},
+			wantErr: false,
+		},
+		{
+			name: "liveness without probes",
+			modify: func(c *ServerConfig) {
+				c.Tunnel.Liveness.Enabled = true
+				c.Tunnel.Liveness.ProbeCount = 0
+			},
+			wantErr: true,
+		},
+		{
+			name: "notifications without a destination",
+			modify: func(c *ServerConfig) {
+				c.Notifications.Enabled = true
+			},
+			wantErr: true,
+		},
+		{
+			name: "notifications of a client event",
+			modify: func(c *ServerConfig) {
+				c.Notifications.Enabled = true
+				c.Notifications.Webhook.URL = "https://hooks.example.com/half-tunnel"
+				c.Notifications.Events = []string{"reconnect_failing"}
+			},
+			wantErr: true,
+		},
+		{
+			name: "notifications to telegram",
+			modify: func(c *ServerConfig) {
+				c.Notifications.Enabled = true
+				c.Notifications.Telegram.BotToken = "123:abc"
+				c.Notifications.Telegram.ChatID = "42"
+				c.Notifications.Events = []string{"quota_exceeded"}
+			},
+			wantErr: false,
+		},
+		{
+			name: "resource guard shed_at above 1",
+			modify: func(c *ServerConfig) {
+				c.Tunnel.ResourceGuard.ShedAt = 1.5
+			},
+			wantErr: true,
+		},
+		{
+			name: "negative resource guard limit",
+			modify: func(c *ServerConfig) {
+				c.Tunnel.ResourceGuard.MaxNatEntries = -1
+			},
+			wantErr: true,
+		},
+		{
+			name: "valid resource guard limits",
+			modify: func(c *ServerConfig) {
+				c.Tunnel.ResourceGuard.MaxOpenFiles = 4096
+				c.Tunnel.ResourceGuard.MaxNatEntries = 10000
+				c.Tunnel.ResourceGuard.MaxGoroutines = 50000
+			},
+			wantErr: false,
+		},
+		{
+			name: "dial retry without attempts",
+			modify: func(c *ServerConfig) {
+				c.Access.DialRetry.Attempts = 0
+			},
+			wantErr: true,
+		},
+		{
+			name: "access rule with dial retry",
+			modify: func(c *ServerConfig) {
+				c.Access.Rules = []AccessRuleConfig{{
+					Networks:  []string{"10.0.0.0/8"},
+					Domains:   []string{"internal.example"},
+					Ports:     []int{443},
+					DialRetry: &DialRetryConfig{Attempts: 3},
+				}}
+			},
+			wantErr: false,
+		},
+		{
+			name: "access rule with invalid network",
+			modify: func(c *ServerConfig) {
+				c.Access.Rules = []AccessRuleConfig{{Networks: []string{"10.0.0.0"}}}
+			},
+			wantErr: true,
+		},
+		{
+			name: "access rule with invalid port",
+			modify: func(c *ServerConfig) {
+				c.Access.Rules = []AccessRuleConfig{{Ports: []int{70000}}}
+			},
+			wantErr: true,
+		},
+		{
+			name: "custom DNS servers and DoH",
+			modify: func(c *ServerConfig) {
+				c.Egress.DNS.Servers = []string{"1.1.1.1", "[2606:4700:4700::1111]:53"}
+				c.Egress.DNS.DoHURL = "https://cloudflare-dns.com/dns-query"
+				c.Egress.DNS.Prefer = "ipv4"
+			},
+			wantErr: false,
+		},
+		{
+			name: "DNS server hostname",
+			modify: func(c *ServerConfig) {
+				c.Egress.DNS.Servers = []string{"dns.example.com"}
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid DNS preference",
+			modify: func(c *ServerConfig) {
+				c.Egress.DNS.Prefer = "ipv5"
+			},
+			wantErr: true,
+		},
+		{
+			name: "valid egress binding",
+			modify: func(c *ServerConfig) {
+				c.Egress.BindAddress = "2001:db8::10"
+				c.Egress.Interface = "eth1"
+				c.Egress.Mark = 0x1a
+			},
+			wantErr: false,
+		},
+		{
+			name: "egress mark out of range",
+			modify: func(c *ServerConfig) {
+				c.Egress.Mark = 1 << 32
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid egress bind address",
+			modify: func(c *ServerConfig) {
+				c.Egress.BindAddress = "eth1"
+			},
+			wantErr: true,
+		},
+		{
+			name: "negative tcp keepalive count",
+			modify: func(c *ServerConfig) {
+				c.Tunnel.Connection.TCP.KeepaliveCount = -1
+			},
+			wantErr: true,
+		},
+		{
+			name: "ipv6-only upstream",
+			modify: func(c *ServerConfig) {
+				c.Server.Upstream.Host = "::"
+				c.Server.Upstream.IPFamily = "ipv6"
+			},
+			wantErr: false,
+		},
+		{
+			name: "invalid downstream ip family",
+			modify: func(c *ServerConfig) {
+				c.Server.Downstream.IPFamily = "ipv5"
+			},
+			wantErr: true,
+		},
+		{
+			name: "single port with different ip families",
+			modify: func(c *ServerConfig) {
+				c.Server.Downstream.Port = c.Server.Upstream.Port
+				c.Server.Downstream.Path = "/other"
+				c.Server.Upstream.IPFamily = "ipv4"
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid blocked network",
+			modify: func(c *ServerConfig) {
+				c.Access.BlockedNetworks = []string{"10.0.0.0"}
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid policy blocked port",
+			modify: func(c *ServerConfig) {
+				c.Access.Policy.BlockedPorts = []int{70000}
+			},
+			wantErr: true,
+		},
+		{
+			name: "policy client allow-list",
+			modify: func(c *ServerConfig) {
+				c.Access.Policy.Clients = []ClientPolicyConfig{{
+					Identity: "laptop",
+					Allow:    []PolicyRuleConfig{{Networks: []string{"203.0.113.0/24"}, Ports: []int{443}}},
+				}}
+			},
+			wantErr: false,
+		},
+		{
+			name: "policy client without identity",
+			modify: func(c *ServerConfig) {
+				c.Access.Policy.Clients = []ClientPolicyConfig{{Allow: []PolicyRuleConfig{{Domains: []string{"example.com"}}}}}
+			},
+			wantErr: true,
+		},
+		{
+			name: "duplicate policy client identity",
+			modify: func(c *ServerConfig) {
+				c.Access.Policy.Clients = []ClientPolicyConfig{{Identity: "laptop"}, {Identity: "laptop"}}
+			},
+			wantErr: true,
+		},
+		{
+			name: "client auth tokens",
+			modify: func(c *ServerConfig) {
+				c.Access.ClientAuth.Required = true
+				c.Access.ClientAuth.Clients = []ClientTokenConfig{
+					{Name: "laptop", Token: "htc_abc"},
+					{Name: "phone", TokenHash: strings.Repeat("ab", 32)},
+				}
+			},
+			wantErr: false,
+		},
+		{
+			name: "client auth token and hash",
+			modify: func(c *ServerConfig) {
+				c.Access.ClientAuth.Clients = []ClientTokenConfig{{Name: "laptop", Token: "htc_abc", TokenHash: strings.Repeat("ab", 32)}}
+			},
+			wantErr: true,
+		},
+		{
+			name: "client auth invalid hash",
+			modify: func(c *ServerConfig) {
+				c.Access.ClientAuth.Clients = []ClientTokenConfig{{Name: "laptop", TokenHash: "abc"}}
+			},
+			wantErr: true,
+		},
+		{
+			name: "client auth shared token",
+			modify: func(c *ServerConfig) {
+				c.Access.ClientAuth.Clients = []ClientTokenConfig{{Name: "a", Token: "htc_abc"}, {Name: "b", Token: "htc_abc"}}
+			},
+			wantErr: true,
+		},
+		{
+			name: "valid quotas",
+			modify: func(c *ServerConfig) {
+				c.Access.Quotas.Enabled = true
+				c.Access.Quotas.Default = QuotaLimitsConfig{Monthly: "100GB", Rate: "10MB"}
+				c.Access.Quotas.Clients = []ClientQuotaConfig{{Name: "laptop", QuotaLimitsConfig: QuotaLimitsConfig{Daily: "5G"}}}
+			},
+			wantErr: false,
+		},
+		{
+			name: "quotas invalid size",
+			modify: func(c *ServerConfig) {
+				c.Access.Quotas.Enabled = true
+				c.Access.Quotas.Default.Daily = "lots"
+			},
+			wantErr: true,
+		},
+		{
+			name: "quotas duplicate client",
+			modify: func(c *ServerConfig) {
+				c.Access.Quotas.Enabled = true
+				c.Access.Quotas.Clients = []ClientQuotaConfig{{Name: "laptop"}, {Name: "laptop"}}
+			},
+			wantErr: true,
+		},
+		{
+			name: "quotas without state file",
+			modify: func(c *ServerConfig) {
+				c.Access.Quotas.Enabled = true
+				c.Access.Quotas.StateFile = ""
+			},
+			wantErr: true,
+		},
+		{
+			name: "audit log without output",
+			modify: func(c *ServerConfig) {
+				c.Observability.Audit.Enabled = true
+				c.Observability.Audit.Output = ""
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid component log level",
+			modify: func(c *ServerConfig) {
+				c.Logging.Components = map[string]string{"transport": "verbose"}
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid log rotation size",
+			modify: func(c *ServerConfig) {
+				c.Logging.Rotation.MaxSize = "lots"
+			},
+			wantErr: true,
+		},
+		{
+			name: "redis session store without addr",
+			modify: func(c *ServerConfig) {
+				c.Tunnel.Session.Store.Backend = "redis"
+				c.Tunnel.Session.Store.Redis.Addr = ""
+			},
+			wantErr: true,
+		},
+		{
+			name: "unknown session store",
+			modify: func(c *ServerConfig) {
+				c.Tunnel.Session.Store.Backend = "etcd"
+			},
+			wantErr: true,
+		},
+		{
+			name: "valid cluster peers",
+			modify: func(c *ServerConfig) {
+				c.Cluster.Peers = []ClusterPeerConfig{
+					{Name: "exit-server-02", UpstreamURL: "wss://10.0.0.2:8443/ws/upstream", DownstreamURL: "wss://10.0.0.2:8444/ws/downstream"},
+				}
+				c.Cluster.Secret = "cluster-secret"
+			},
+			wantErr: false,
+		},
+		{
+			name: "cluster peers without secret",
+			modify: func(c *ServerConfig) {
+				c.Cluster.Peers = []ClusterPeerConfig{
+					{Name: "exit-server-02", UpstreamURL: "wss://10.0.0.2:8443/ws/upstream", DownstreamURL: "wss://10.0.0.2:8444/ws/downstream"},
+				}
+			},
+			wantErr: true,
+		},
+		{
+			name: "cluster peer named like this server",
+			modify: func(c *ServerConfig) {
+				c.Cluster.Peers = []ClusterPeerConfig{
+					{Name: "exit-server-01", UpstreamURL: "ws://10.0.0.2:8443/up", DownstreamURL: "ws://10.0.0.2:8444/down"},
+				}
+				c.Cluster.Secret = "cluster-secret"
+			},
+			wantErr: true,
+		},
+		{
+			name: "cluster peer with http URL",
+			modify: func(c *ServerConfig) {
+				c.Cluster.Peers = []ClusterPeerConfig{
+					{Name: "exit-server-02", UpstreamURL: "http://10.0.0.2:8443/up", DownstreamURL: "ws://10.0.0.2:8444/down"},
+				}
+				c.Cluster.Secret = "cluster-secret"
+			},
+			wantErr: true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -131,10 +748,19 @@ access:
   allowed_networks:
     - "0.0.0.0/0"
   max_streams_per_session: 50
+  rules:
+    - name: "internal"
+      networks: ["10.0.0.0/8"]
+      ports: [443]
+      dial_retry:
+        attempts: 3
+        backoff: "1s"
 tunnel:
   session:
     timeout: "10m"
     max_sessions: 500
+egress:
+  mark: 0x1a
 logging:
   level: "debug"
 `
@@ -156,6 +782,37 @@ logging:
 	if cfg.Access.MaxStreamsPerSession != 50 {
 		t.Errorf("Expected max_streams_per_session 50, got %d", cfg.Access.MaxStreamsPerSession)
 	}
+	if len(cfg.Access.Rules) != 1 || cfg.Access.Rules[0].DialRetry == nil {
+		t.Fatalf("Expected 1 access rule with dial_retry, got %+v", cfg.Access.Rules)
+	}
+	if retry := cfg.Access.Rules[0].DialRetry; retry.Attempts != 3 || retry.Backoff != time.Second {
+		t.Errorf("Expected 3 attempts with 1s backoff, got %+v", retry)
+	}
+	if cfg.Access.DialRetry.Attempts != 1 {
+		t.Errorf("Expected default dial_retry attempts 1, got %d", cfg.Access.DialRetry.Attempts)
+	}
+	if tcp := cfg.Tunnel.Connection.TCP; !tcp.NoDelay || tcp.KeepaliveIdle != 30*time.Second {
+		t.Errorf("Expected default tcp options, got %+v", tcp)
+	}
+	if cfg.Egress.Mark != 0x1a {
+		t.Errorf("Expected egress mark 0x1a, got %#x", cfg.Egress.Mark)
+	}
+}
+
+func TestServerEndpointAddr(t *testing.T) {
+	tests := []struct {
+		host string
+		want string
+	}{
+		{host: "0.0.0.0", want: "0.0.0.0:8443"},
+		{host: "::", want: "[::]:8443"},
+		{host: "2001:db8::1", want: "[2001:db8::1]:8443"},
+	}
+	for _, tt := range tests {
+		if got := (ServerEndpoint{Host: tt.host, Port: 8443}).Addr(); got != tt.want {
+			t.Errorf("Expected %s, got %s", tt.want, got)
+		}
+	}
 }
 
 func TestLoadServerConfigFileNotFound(t *testing.T) {
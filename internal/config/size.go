@@ -0,0 +1,58 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// byteUnits maps size suffixes to their multiplier (binary units).
+var byteUnits = []struct {
+	suffix string
+	factor int64
+}{
+	{"TB", 1 << 40},
+	{"GB", 1 << 30},
+	{"MB", 1 << 20},
+	{"KB", 1 << 10},
+	{"T", 1 << 40},
+	{"G", 1 << 30},
+	{"M", 1 << 20},
+	{"K", 1 << 10},
+	{"B", 1},
+}
+
+// ParseByteSize parses a human-readable size such as "512MB", "10G" or "4096"
+// into bytes. Units are binary (1KB = 1024 bytes) and case-insensitive.
+func ParseByteSize(s string) (int64, error) {
+	spec := strings.ToUpper(strings.TrimSpace(s))
+	if spec == "" {
+		return 0, fmt.Errorf("empty size")
+	}
+
+	factor := int64(1)
+	for _, unit := range byteUnits {
+		if strings.HasSuffix(spec, unit.suffix) {
+			factor = unit.factor
+			spec = strings.TrimSpace(strings.TrimSuffix(spec, unit.suffix))
+			break
+		}
+	}
+
+	value, err := strconv.ParseFloat(spec, 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("invalid size: %s", s)
+	}
+
+	return int64(value * float64(factor)), nil
+}
+
+// FormatByteSize formats bytes as a human-readable size using binary units.
+func FormatByteSize(n int64) string {
+	for _, unit := range byteUnits[:4] {
+		if n >= unit.factor {
+			return fmt.Sprintf("%.1f%s", float64(n)/float64(unit.factor), unit.suffix)
+		}
+	}
+	return fmt.Sprintf("%dB", n)
+}
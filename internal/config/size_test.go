@@ -0,0 +1,53 @@
+package config
+
+import "testing"
+
+func TestParseByteSize(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    int64
+		wantErr bool
+	}{
+		{input: "4096", want: 4096},
+		{input: "512B", want: 512},
+		{input: "1KB", want: 1024},
+		{input: "1.5k", want: 1536},
+		{input: "10MB", want: 10 << 20},
+		{input: "2G", want: 2 << 30},
+		{input: " 1 TB ", want: 1 << 40},
+		{input: "", wantErr: true},
+		{input: "abc", wantErr: true},
+		{input: "-1GB", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := ParseByteSize(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseByteSize(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("ParseByteSize(%q) = %d, want %d", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatByteSize(t *testing.T) {
+	tests := []struct {
+		input int64
+		want  string
+	}{
+		{input: 512, want: "512B"},
+		{input: 1536, want: "1.5KB"},
+		{input: 10 << 20, want: "10.0MB"},
+		{input: 3 << 30, want: "3.0GB"},
+	}
+
+	for _, tt := range tests {
+		if got := FormatByteSize(tt.input); got != tt.want {
+			t.Errorf("FormatByteSize(%d) = %s, want %s", tt.input, got, tt.want)
+		}
+	}
+}
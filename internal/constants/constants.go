@@ -11,4 +11,8 @@ const (
 
 	// DefaultMaxMessageSize is the default maximum WebSocket message size.
 	DefaultMaxMessageSize = 65536 // 64KB
+
+	// StreamBufferSize bounds the data a stream holds for reassembly,
+	// including packets that arrived out of order.
+	StreamBufferSize = 8 * DefaultBufferSize // 256KB
 )
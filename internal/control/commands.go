@@ -0,0 +1,146 @@
+package control
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/sahmadiut/half-tunnel/internal/admin"
+	"github.com/sahmadiut/half-tunnel/internal/config"
+	"github.com/sahmadiut/half-tunnel/pkg/logger"
+)
+
+// RegisterTunnelCommands registers the commands shared by clients and
+// servers: dump-state, close-session, close-stream and set-log-level.
+func (s *Server) RegisterTunnelCommands(p admin.Provider) {
+	s.Handle("dump-state", func(args []string) (interface{}, error) {
+		return p.AdminStatus(), nil
+	})
+
+	s.Handle("close-session", func(args []string) (interface{}, error) {
+		if len(args) != 1 {
+			return nil, errors.New("usage: close-session <session-id>")
+		}
+		sessionID, err := uuid.Parse(args[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid session ID: %s", args[0])
+		}
+		return "session closed", p.CloseSession(sessionID)
+	})
+
+	s.Handle("close-stream", func(args []string) (interface{}, error) {
+		sessionID, streamID, err := parseStreamArgs(p, args)
+		if err != nil {
+			return nil, err
+		}
+		return "stream closed", p.CloseStream(sessionID, streamID)
+	})
+
+	s.Handle("set-log-level", func(args []string) (interface{}, error) {
+		if len(args) != 1 {
+			return nil, errors.New("usage: set-log-level <debug|info|warn|error> | <component>=<level|default>")
+		}
+		if component, level, ok := strings.Cut(args[0], "="); ok {
+			if err := logger.SetComponentLevel(component, level); err != nil {
+				return nil, err
+			}
+			return "log level of " + component + " set to " + level, nil
+		}
+		if err := logger.SetLevel(args[0]); err != nil {
+			return nil, err
+		}
+		return "log level set to " + logger.Level(), nil
+	})
+}
+
+// parseStreamArgs parses "[session-id] <stream-id>". The session ID may be
+// omitted when there is exactly one session, as on a client.
+func parseStreamArgs(p admin.Provider, args []string) (uuid.UUID, uint32, error) {
+	var sessionID uuid.UUID
+	switch len(args) {
+	case 1:
+		sessions := p.AdminStatus().Sessions
+		if len(sessions) != 1 {
+			return uuid.Nil, 0, errors.New("session ID required: close-stream <session-id> <stream-id>")
+		}
+		sessionID = sessions[0].ID
+	case 2:
+		id, err := uuid.Parse(args[0])
+		if err != nil {
+			return uuid.Nil, 0, fmt.Errorf("invalid session ID: %s", args[0])
+		}
+		sessionID = id
+		args = args[1:]
+	default:
+		return uuid.Nil, 0, errors.New("usage: close-stream [session-id] <stream-id>")
+	}
+
+	streamID, err := strconv.ParseUint(args[0], 10, 32)
+	if err != nil {
+		return uuid.Nil, 0, fmt.Errorf("invalid stream ID: %s", args[0])
+	}
+	return sessionID, uint32(streamID), nil
+}
+
+// RegisterForwardCommands registers forward-list, forward-add and
+// forward-remove for managing port forwards at runtime.
+func (s *Server) RegisterForwardCommands(m admin.ForwardManager) {
+	s.Handle("forward-list", func(args []string) (interface{}, error) {
+		return m.Forwards(), nil
+	})
+
+	s.Handle("forward-add", func(args []string) (interface{}, error) {
+		if len(args) < 1 || len(args) > 2 {
+			return nil, errors.New("usage: forward-add <[[listen_host:]listen:]host:port> [name]")
+		}
+		f, err := parseForward(args[0])
+		if err != nil {
+			return nil, err
+		}
+		if len(args) == 2 {
+			f.Name = args[1]
+		}
+		if err := m.AddForward(f); err != nil {
+			return nil, err
+		}
+		return fmt.Sprintf("forwarding %d -> %s:%d", f.ListenPort, f.RemoteHost, f.RemotePort), nil
+	})
+
+	s.Handle("forward-remove", func(args []string) (interface{}, error) {
+		if len(args) != 1 {
+			return nil, errors.New("usage: forward-remove <listen-port>")
+		}
+		port, err := strconv.Atoi(args[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid listen port: %s", args[0])
+		}
+		if err := m.RemoveForward(port); err != nil {
+			return nil, err
+		}
+		return fmt.Sprintf("removed forward on port %d", port), nil
+	})
+}
+
+// parseForward parses a port forward in the config file's short form
+// ("port", "listen:remote", "listen:host:remote" or
+// "listen_host:listen:host:remote").
+func parseForward(spec string) (admin.Forward, error) {
+	if strings.Contains(spec, "-") && !strings.Contains(spec, ":") {
+		return admin.Forward{}, errors.New("port ranges cannot be added at runtime")
+	}
+	pf, err := config.ParsePortForwardString(spec)
+	if err != nil {
+		return admin.Forward{}, err
+	}
+	if pf.Protocol == "udp" {
+		return admin.Forward{}, errors.New("UDP port forwards are not supported")
+	}
+	return admin.Forward{
+		ListenHost: pf.ListenHost,
+		ListenPort: pf.ListenPort,
+		RemoteHost: pf.RemoteHost,
+		RemotePort: pf.RemotePort,
+	}, nil
+}
@@ -0,0 +1,205 @@
+// Package control provides the local control-plane socket for Half-Tunnel
+// clients and servers.
+//
+// The control socket is a unix domain socket that accepts one JSON request per
+// connection and answers with one JSON response. It is meant for runtime
+// commands issued by the ht CLI ("ht c ctl reload", "ht s ctl dump-state"),
+// and is only reachable by users with access to the socket file.
+package control
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sahmadiut/half-tunnel/pkg/logger"
+)
+
+// requestTimeout bounds how long a single control connection may take.
+const requestTimeout = 30 * time.Second
+
+// Request is a control command sent over the socket.
+type Request struct {
+	Command string   `json:"command"`
+	Args    []string `json:"args,omitempty"`
+}
+
+// Response is the reply to a Request.
+type Response struct {
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
+
+// Handler runs a command and returns a JSON-serializable result.
+type Handler func(args []string) (interface{}, error)
+
+// ErrUnknownCommand is returned for commands without a handler.
+var ErrUnknownCommand = errors.New("unknown command")
+
+// Server serves control commands on a unix socket.
+type Server struct {
+	path     string
+	log      *logger.Logger
+	handlers map[string]Handler
+	mu       sync.RWMutex
+	listener net.Listener
+}
+
+// NewServer creates a control server for the socket at path.
+func NewServer(path string, log *logger.Logger) *Server {
+	if log == nil {
+		log = logger.NewDefault()
+	}
+	return &Server{
+		path:     path,
+		log:      log,
+		handlers: make(map[string]Handler),
+	}
+}
+
+// Handle registers the handler for command.
+func (s *Server) Handle(command string, handler Handler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handlers[command] = handler
+}
+
+// Commands returns the registered command names, sorted.
+func (s *Server) Commands() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	commands := make([]string, 0, len(s.handlers))
+	for command := range s.handlers {
+		commands = append(commands, command)
+	}
+	sort.Strings(commands)
+	return commands
+}
+
+// Start creates the socket and serves commands until ctx is done or Close is
+// called. A stale socket file left by a previous run is replaced.
+func (s *Server) Start(ctx context.Context) error {
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return fmt.Errorf("failed to create control socket directory: %w", err)
+	}
+	if conn, err := net.Dial("unix", s.path); err == nil {
+		conn.Close()
+		return fmt.Errorf("control socket %s is in use", s.path)
+	}
+	_ = os.Remove(s.path)
+
+	listener, err := net.Listen("unix", s.path)
+	if err != nil {
+		return fmt.Errorf("failed to listen on control socket: %w", err)
+	}
+	if err := os.Chmod(s.path, 0600); err != nil {
+		listener.Close()
+		return fmt.Errorf("failed to restrict control socket permissions: %w", err)
+	}
+
+	s.mu.Lock()
+	s.listener = listener
+	s.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		s.Close()
+	}()
+	go s.serve(listener)
+	return nil
+}
+
+// Close stops the server and removes the socket file.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	listener := s.listener
+	s.listener = nil
+	s.mu.Unlock()
+
+	if listener == nil {
+		return nil
+	}
+	return listener.Close()
+}
+
+func (s *Server) serve(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handleConn(conn)
+	}
+}
+
+func (s *Server) handleConn(conn net.Conn) {
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(requestTimeout))
+
+	var req Request
+	if err := json.NewDecoder(conn).Decode(&req); err != nil {
+		_ = json.NewEncoder(conn).Encode(Response{Error: "invalid request: " + err.Error()})
+		return
+	}
+
+	s.log.Info().
+		Str("command", req.Command).
+		Strs("args", req.Args).
+		Msg("Control command received")
+
+	_ = json.NewEncoder(conn).Encode(s.Dispatch(req))
+}
+
+// Dispatch runs a request against the registered handlers.
+func (s *Server) Dispatch(req Request) Response {
+	s.mu.RLock()
+	handler, ok := s.handlers[req.Command]
+	s.mu.RUnlock()
+	if !ok {
+		return Response{Error: fmt.Sprintf("%v: %s", ErrUnknownCommand, req.Command)}
+	}
+
+	result, err := handler(req.Args)
+	if err != nil {
+		return Response{Error: err.Error()}
+	}
+	if result == nil {
+		return Response{}
+	}
+
+	data, err := json.Marshal(result)
+	if err != nil {
+		return Response{Error: "failed to encode result: " + err.Error()}
+	}
+	return Response{Result: data}
+}
+
+// Call sends a command to the control socket at path and returns its result.
+func Call(path string, command string, args ...string) (json.RawMessage, error) {
+	conn, err := net.DialTimeout("unix", path, 5*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to control socket: %w", err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(requestTimeout))
+
+	if err := json.NewEncoder(conn).Encode(Request{Command: command, Args: args}); err != nil {
+		return nil, fmt.Errorf("failed to send command: %w", err)
+	}
+
+	var resp Response
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	if resp.Error != "" {
+		return nil, errors.New(resp.Error)
+	}
+	return resp.Result, nil
+}
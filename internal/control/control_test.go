@@ -0,0 +1,217 @@
+package control
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/sahmadiut/half-tunnel/internal/admin"
+	"github.com/sahmadiut/half-tunnel/pkg/logger"
+)
+
+// socketPath returns a short socket path; unix socket paths are limited to
+// about 100 bytes, which t.TempDir can exceed.
+func socketPath(t *testing.T) string {
+	dir, err := os.MkdirTemp("", "ht-ctl")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return filepath.Join(dir, "ctl.sock")
+}
+
+func TestCallRoundTrip(t *testing.T) {
+	path := socketPath(t)
+	s := NewServer(path, nil)
+	s.Handle("echo", func(args []string) (interface{}, error) {
+		return strings.Join(args, " "), nil
+	})
+	s.Handle("fail", func(args []string) (interface{}, error) {
+		return nil, errors.New("boom")
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := s.Start(ctx); err != nil {
+		t.Fatalf("Failed to start control server: %v", err)
+	}
+	defer s.Close()
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Expected socket file: %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("Expected socket mode 0600, got %v", info.Mode().Perm())
+	}
+
+	result, err := Call(path, "echo", "hello", "world")
+	if err != nil {
+		t.Fatalf("Call failed: %v", err)
+	}
+	var text string
+	if err := json.Unmarshal(result, &text); err != nil || text != "hello world" {
+		t.Errorf("Expected \"hello world\", got %s (%v)", result, err)
+	}
+
+	if _, err := Call(path, "fail"); err == nil || err.Error() != "boom" {
+		t.Errorf("Expected handler error, got %v", err)
+	}
+	if _, err := Call(path, "nope"); err == nil || !strings.Contains(err.Error(), "unknown command") {
+		t.Errorf("Expected unknown command error, got %v", err)
+	}
+
+	// A second server must not steal a live socket
+	if err := NewServer(path, nil).Start(ctx); err == nil {
+		t.Error("Expected error when the socket is in use")
+	}
+}
+
+func TestStartReplacesStaleSocket(t *testing.T) {
+	path := socketPath(t)
+	if err := os.WriteFile(path, nil, 0600); err != nil {
+		t.Fatalf("Failed to create stale file: %v", err)
+	}
+
+	s := NewServer(path, nil)
+	if err := s.Start(context.Background()); err != nil {
+		t.Fatalf("Expected stale socket to be replaced, got %v", err)
+	}
+	s.Close()
+
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Expected socket file to be removed on close, got %v", err)
+	}
+}
+
+type fakeProvider struct {
+	sessionID uuid.UUID
+	closed    []uint32
+}
+
+func (f *fakeProvider) AdminStatus() *admin.Status {
+	return &admin.Status{Role: "client", Sessions: []admin.Session{{ID: f.sessionID}}}
+}
+
+func (f *fakeProvider) CloseStream(sessionID uuid.UUID, streamID uint32) error {
+	if sessionID != f.sessionID {
+		return admin.ErrSessionNotFound
+	}
+	f.closed = append(f.closed, streamID)
+	return nil
+}
+
+func (f *fakeProvider) DrainSession(sessionID uuid.UUID) error { return nil }
+
+func (f *fakeProvider) CloseSession(sessionID uuid.UUID) error {
+	if sessionID != f.sessionID {
+		return admin.ErrSessionNotFound
+	}
+	return nil
+}
+
+func TestTunnelCommands(t *testing.T) {
+	defer func() { _ = logger.SetLevel("info") }()
+
+	provider := &fakeProvider{sessionID: uuid.New()}
+	s := NewServer(socketPath(t), nil)
+	s.RegisterTunnelCommands(provider)
+
+	tests := []struct {
+		name    string
+		command string
+		args    []string
+		wantErr bool
+	}{
+		{"dump state", "dump-state", nil, false},
+		{"close stream in only session", "close-stream", []string{"7"}, false},
+		{"close stream with session", "close-stream", []string{provider.sessionID.String(), "8"}, false},
+		{"close stream unknown session", "close-stream", []string{uuid.New().String(), "8"}, true},
+		{"close stream invalid ID", "close-stream", []string{"x"}, true},
+		{"close session", "close-session", []string{provider.sessionID.String()}, false},
+		{"close session missing ID", "close-session", nil, true},
+		{"set log level", "set-log-level", []string{"debug"}, false},
+		{"set invalid log level", "set-log-level", []string{"loud"}, true},
+		{"set component log level", "set-log-level", []string{"transport=debug"}, false},
+		{"reset component log level", "set-log-level", []string{"transport=default"}, false},
+		{"set invalid component log level", "set-log-level", []string{"transport=loud"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := s.Dispatch(Request{Command: tt.command, Args: tt.args})
+			if (resp.Error != "") != tt.wantErr {
+				t.Errorf("Dispatch() error = %q, wantErr %v", resp.Error, tt.wantErr)
+			}
+		})
+	}
+
+	if len(provider.closed) != 2 || provider.closed[0] != 7 || provider.closed[1] != 8 {
+		t.Errorf("Expected streams 7 and 8 to be closed, got %v", provider.closed)
+	}
+	if logger.Level() != "debug" {
+		t.Errorf("Expected log level debug, got %s", logger.Level())
+	}
+}
+
+type fakeForwards struct {
+	forwards []admin.Forward
+}
+
+func (f *fakeForwards) Forwards() []admin.Forward { return f.forwards }
+
+func (f *fakeForwards) AddForward(fwd admin.Forward) error {
+	f.forwards = append(f.forwards, fwd)
+	return nil
+}
+
+func (f *fakeForwards) RemoveForward(listenPort int) error {
+	for i, fwd := range f.forwards {
+		if fwd.ListenPort == listenPort {
+			f.forwards = append(f.forwards[:i], f.forwards[i+1:]...)
+			return nil
+		}
+	}
+	return admin.ErrForwardNotFound
+}
+
+func TestForwardCommands(t *testing.T) {
+	m := &fakeForwards{}
+	s := NewServer(socketPath(t), nil)
+	s.RegisterForwardCommands(m)
+
+	if resp := s.Dispatch(Request{Command: "forward-add", Args: []string{"8443:example.com:443", "web"}}); resp.Error != "" {
+		t.Fatalf("forward-add failed: %s", resp.Error)
+	}
+	if len(m.forwards) != 1 {
+		t.Fatalf("Expected 1 forward, got %d", len(m.forwards))
+	}
+	f := m.forwards[0]
+	if f.Name != "web" || f.ListenPort != 8443 || f.RemoteHost != "example.com" || f.RemotePort != 443 {
+		t.Errorf("Unexpected forward: %+v", f)
+	}
+
+	resp := s.Dispatch(Request{Command: "forward-list"})
+	var listed []admin.Forward
+	if err := json.Unmarshal(resp.Result, &listed); err != nil || len(listed) != 1 {
+		t.Errorf("Expected 1 listed forward, got %s (%v)", resp.Result, err)
+	}
+
+	for _, args := range [][]string{nil, {"1000-1200"}, {"a:b:c"}, {"5353/udp"}} {
+		if resp := s.Dispatch(Request{Command: "forward-add", Args: args}); resp.Error == "" {
+			t.Errorf("Expected forward-add %v to fail", args)
+		}
+	}
+
+	if resp := s.Dispatch(Request{Command: "forward-remove", Args: []string{"8443"}}); resp.Error != "" {
+		t.Errorf("forward-remove failed: %s", resp.Error)
+	}
+	if resp := s.Dispatch(Request{Command: "forward-remove", Args: []string{"8443"}}); resp.Error == "" {
+		t.Error("Expected removing a missing forward to fail")
+	}
+}
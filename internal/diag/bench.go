@@ -0,0 +1,188 @@
+package diag
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"time"
+)
+
+// DialFunc opens a stream through the tunnel, e.g. via the client's SOCKS5
+// proxy.
+type DialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// BenchOptions configures a benchmark run.
+type BenchOptions struct {
+	// Duration is how long each throughput test runs
+	Duration time.Duration
+	// Pings is the number of echo round trips timed for latency
+	Pings int
+	// PingSize is the size of each echo message in bytes
+	PingSize int
+}
+
+// DefaultBenchOptions returns the options used by `ht c bench`.
+func DefaultBenchOptions() BenchOptions {
+	return BenchOptions{
+		Duration: 5 * time.Second,
+		Pings:    10,
+		PingSize: 64,
+	}
+}
+
+// Latency summarizes echo round trips through the tunnel.
+type Latency struct {
+	Samples int
+	Min     time.Duration
+	Avg     time.Duration
+	Max     time.Duration
+}
+
+// Throughput is the data moved in one direction during a test.
+type Throughput struct {
+	Bytes    int64
+	Duration time.Duration
+}
+
+// BytesPerSecond returns the average rate of the test.
+func (t Throughput) BytesPerSecond() float64 {
+	if t.Duration <= 0 {
+		return 0
+	}
+	return float64(t.Bytes) / t.Duration.Seconds()
+}
+
+// BenchReport holds the results of a benchmark run.
+type BenchReport struct {
+	Latency  Latency
+	Upload   Throughput
+	Download Throughput
+}
+
+// Bench measures latency with the echo target, upload throughput with the
+// discard target and download throughput with the chargen target. Upload is
+// measured at the sender, so it includes data still buffered in the tunnel
+// when the test ends; longer durations make that share smaller.
+func Bench(ctx context.Context, dial DialFunc, opts BenchOptions) (*BenchReport, error) {
+	report := &BenchReport{}
+	var err error
+
+	if report.Latency, err = benchLatency(ctx, dial, opts); err != nil {
+		return nil, fmt.Errorf("latency test failed: %w", err)
+	}
+	if report.Upload, err = benchUpload(ctx, dial, opts.Duration); err != nil {
+		return nil, fmt.Errorf("upload test failed: %w", err)
+	}
+	if report.Download, err = benchDownload(ctx, dial, opts.Duration); err != nil {
+		return nil, fmt.Errorf("download test failed: %w", err)
+	}
+	return report, nil
+}
+
+// benchLatency times echo round trips of opts.PingSize bytes.
+func benchLatency(ctx context.Context, dial DialFunc, opts BenchOptions) (Latency, error) {
+	conn, err := dial(ctx, "tcp", EchoAddr)
+	if err != nil {
+		return Latency{}, err
+	}
+	defer conn.Close()
+	defer stopOnCancel(ctx, conn)()
+
+	msg := make([]byte, opts.PingSize)
+	reply := make([]byte, opts.PingSize)
+	var lat Latency
+	var total time.Duration
+	for i := 0; i < opts.Pings; i++ {
+		start := time.Now()
+		if _, err := conn.Write(msg); err != nil {
+			return Latency{}, err
+		}
+		if _, err := io.ReadFull(conn, reply); err != nil {
+			return Latency{}, err
+		}
+		rtt := time.Since(start)
+
+		if lat.Samples == 0 || rtt < lat.Min {
+			lat.Min = rtt
+		}
+		if rtt > lat.Max {
+			lat.Max = rtt
+		}
+		total += rtt
+		lat.Samples++
+	}
+	if lat.Samples > 0 {
+		lat.Avg = total / time.Duration(lat.Samples)
+	}
+	return lat, nil
+}
+
+// benchUpload writes to the discard target for d.
+func benchUpload(ctx context.Context, dial DialFunc, d time.Duration) (Throughput, error) {
+	conn, err := dial(ctx, "tcp", DiscardAddr)
+	if err != nil {
+		return Throughput{}, err
+	}
+	defer conn.Close()
+	defer stopOnCancel(ctx, conn)()
+
+	chunk := make([]byte, chunkSize)
+	start := time.Now()
+	_ = conn.SetWriteDeadline(start.Add(d))
+	var t Throughput
+	for {
+		n, err := conn.Write(chunk)
+		t.Bytes += int64(n)
+		if err != nil {
+			t.Duration = time.Since(start)
+			if ctx.Err() != nil {
+				return t, ctx.Err()
+			}
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				return t, nil
+			}
+			return t, err
+		}
+	}
+}
+
+// benchDownload reads from the chargen target for d.
+func benchDownload(ctx context.Context, dial DialFunc, d time.Duration) (Throughput, error) {
+	conn, err := dial(ctx, "tcp", ChargenAddr)
+	if err != nil {
+		return Throughput{}, err
+	}
+	defer conn.Close()
+	defer stopOnCancel(ctx, conn)()
+
+	buf := make([]byte, chunkSize)
+	start := time.Now()
+	_ = conn.SetReadDeadline(start.Add(d))
+	var t Throughput
+	for {
+		n, err := conn.Read(buf)
+		t.Bytes += int64(n)
+		if err != nil {
+			t.Duration = time.Since(start)
+			if ctx.Err() != nil {
+				return t, ctx.Err()
+			}
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				return t, nil
+			}
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return t, err
+		}
+	}
+}
+
+// stopOnCancel unblocks conn's reads and writes when ctx is done. The
+// returned function stops watching ctx.
+func stopOnCancel(ctx context.Context, conn net.Conn) func() bool {
+	return context.AfterFunc(ctx, func() { _ = conn.SetDeadline(time.Now()) })
+}
@@ -0,0 +1,87 @@
+// Package diag provides diagnostic stream targets and the tunnel benchmark
+// that uses them.
+//
+// A server with diagnostics enabled handles streams to the magic destinations
+// below itself instead of dialing out, so a client can test the tunnel
+// without an external host:
+//
+//	echo.internal:7       returns everything it receives
+//	discard.internal:9    reads and drops everything it receives
+//	chargen.internal:19   sends data until the stream is closed
+package diag
+
+import (
+	"io"
+	"net"
+	"strconv"
+)
+
+// Diagnostic services.
+const (
+	Echo    = "echo"
+	Discard = "discard"
+	Chargen = "chargen"
+)
+
+// Addresses of the diagnostic targets.
+const (
+	EchoAddr    = "echo.internal:7"
+	DiscardAddr = "discard.internal:9"
+	ChargenAddr = "chargen.internal:19"
+)
+
+// Host and port of the echo target, for callers that open streams directly.
+const (
+	EchoHost        = "echo.internal"
+	EchoPort uint16 = 7
+)
+
+// chunkSize is the size of the writes chargen makes.
+const chunkSize = 32 * 1024
+
+// Lookup returns the diagnostic service at host:port, or "" if it is not a
+// diagnostic target.
+func Lookup(host string, port uint16) string {
+	switch net.JoinHostPort(host, strconv.Itoa(int(port))) {
+	case EchoAddr:
+		return Echo
+	case DiscardAddr:
+		return Discard
+	case ChargenAddr:
+		return Chargen
+	default:
+		return ""
+	}
+}
+
+// Serve runs service on conn until conn is closed or fails, then closes it.
+func Serve(conn net.Conn, service string) {
+	defer conn.Close()
+
+	switch service {
+	case Echo:
+		_, _ = io.Copy(conn, conn)
+	case Discard:
+		_, _ = io.Copy(io.Discard, conn)
+	case Chargen:
+		// Drain anything the client sends so its writes never block
+		go func() { _, _ = io.Copy(io.Discard, conn) }()
+		chunk := make([]byte, chunkSize)
+		for i := range chunk {
+			chunk[i] = byte(' ' + i%95)
+		}
+		for {
+			if _, err := conn.Write(chunk); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// Pipe returns one end of an in-memory connection with service running on
+// the other.
+func Pipe(service string) net.Conn {
+	local, remote := net.Pipe()
+	go Serve(remote, service)
+	return local
+}
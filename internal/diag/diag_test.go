@@ -0,0 +1,87 @@
+package diag
+
+import (
+	"context"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestLookup(t *testing.T) {
+	tests := []struct {
+		host string
+		port uint16
+		want string
+	}{
+		{"echo.internal", 7, Echo},
+		{"discard.internal", 9, Discard},
+		{"chargen.internal", 19, Chargen},
+		{"echo.internal", 8, ""},
+		{"example.com", 7, ""},
+	}
+
+	for _, tt := range tests {
+		if got := Lookup(tt.host, tt.port); got != tt.want {
+			t.Errorf("Lookup(%s, %d): expected %q, got %q", tt.host, tt.port, tt.want, got)
+		}
+	}
+}
+
+func TestEchoPipe(t *testing.T) {
+	conn := Pipe(Echo)
+	defer conn.Close()
+
+	go func() { _, _ = conn.Write([]byte("hello")) }()
+	reply := make([]byte, 5)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if string(reply) != "hello" {
+		t.Errorf("Expected hello echoed, got %q", reply)
+	}
+}
+
+func TestBench(t *testing.T) {
+	dial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, portStr, _ := net.SplitHostPort(addr)
+		port, _ := strconv.ParseUint(portStr, 10, 16)
+		return Pipe(Lookup(host, uint16(port))), nil
+	}
+
+	opts := DefaultBenchOptions()
+	opts.Duration = 50 * time.Millisecond
+	opts.Pings = 3
+
+	report, err := Bench(context.Background(), dial, opts)
+	if err != nil {
+		t.Fatalf("Bench failed: %v", err)
+	}
+	if report.Latency.Samples != 3 || report.Latency.Min > report.Latency.Avg || report.Latency.Avg > report.Latency.Max {
+		t.Errorf("Unexpected latency %+v", report.Latency)
+	}
+	if report.Upload.Bytes == 0 || report.Upload.Duration < opts.Duration {
+		t.Errorf("Expected upload to run for the test duration, got %+v", report.Upload)
+	}
+	if report.Download.Bytes == 0 || report.Download.BytesPerSecond() <= 0 {
+		t.Errorf("Expected data downloaded, got %+v", report.Download)
+	}
+}
+
+func TestBenchCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	dial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if addr == DiscardAddr {
+			cancel()
+		}
+		return Pipe(Discard), nil
+	}
+
+	opts := DefaultBenchOptions()
+	opts.Pings = 0
+	opts.Duration = time.Minute
+	if _, err := Bench(ctx, dial, opts); err == nil {
+		t.Error("Expected a cancelled bench to fail")
+	}
+}
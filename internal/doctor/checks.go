@@ -0,0 +1,232 @@
+package doctor
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/sahmadiut/half-tunnel/internal/config"
+)
+
+// listener is a TCP address a service listens on.
+type listener struct {
+	name string
+	// key is the config setting that sets the address
+	key  string
+	addr string
+}
+
+// certificate is a PEM certificate file used by a service.
+type certificate struct {
+	name string
+	path string
+}
+
+func serverListeners(cfg *config.ServerConfig) []listener {
+	listeners := []listener{{"upstream listener", "server.upstream.port", cfg.Server.Upstream.Addr()}}
+	if !cfg.Server.SinglePort() {
+		listeners = append(listeners, listener{"downstream listener", "server.downstream.port", cfg.Server.Downstream.Addr()})
+	}
+	return append(listeners, observabilityListeners(cfg.Observability.Metrics, cfg.Observability.Health, cfg.Observability.Admin, cfg.Observability.Debug)...)
+}
+
+func clientListeners(cfg *config.ClientConfig) []listener {
+	var listeners []listener
+	if cfg.SOCKS5.Enabled {
+		listeners = append(listeners, listener{"SOCKS5 proxy", "socks5.listen_port", cfg.SOCKS5.Addr()})
+	}
+	for i, l := range cfg.SOCKS5Listeners {
+		listeners = append(listeners, listener{"SOCKS5 listener " + l.Label(), fmt.Sprintf("socks5_listeners[%d].listen_port", i), l.Addr()})
+	}
+	if forwards, err := cfg.GetPortForwards(); err == nil {
+		for i, pf := range forwards {
+			if pf.Protocol == "udp" {
+				continue
+			}
+			name := "port forward"
+			if pf.Name != "" {
+				name += " " + pf.Name
+			}
+			listeners = append(listeners, listener{
+				name: name,
+				key:  fmt.Sprintf("port_forwards[%d]", i),
+				addr: net.JoinHostPort(pf.ListenHost, strconv.Itoa(pf.ListenPort)),
+			})
+		}
+	}
+	return append(listeners, observabilityListeners(cfg.Observability.Metrics, cfg.Observability.Health, cfg.Observability.Admin, cfg.Observability.Debug)...)
+}
+
+func observabilityListeners(metrics config.MetricsConfig, health config.HealthConfig, admin config.AdminConfig, debug config.DebugConfig) []listener {
+	var listeners []listener
+	if metrics.Enabled {
+		listeners = append(listeners, listener{"metrics", "observability.metrics.port", fmt.Sprintf(":%d", metrics.Port)})
+	}
+	if health.Enabled {
+		listeners = append(listeners, listener{"health", "observability.health.port", fmt.Sprintf(":%d", health.Port)})
+	}
+	if admin.Enabled {
+		listeners = append(listeners, listener{"admin API", "observability.admin.port", admin.Addr()})
+	}
+	if debug.Enabled {
+		listeners = append(listeners, listener{"debug server", "observability.debug.port", debug.Addr()})
+	}
+	return listeners
+}
+
+// checkListeners tries to bind each listener's address. While the service
+// is running its own addresses are expected to be taken.
+func checkListeners(listeners []listener, running bool) []Finding {
+	findings := make([]Finding, 0, len(listeners))
+	for _, l := range listeners {
+		findings = append(findings, checkListener(l, running))
+	}
+	return findings
+}
+
+func checkListener(l listener, running bool) Finding {
+	f := Finding{Check: "port"}
+	ln, err := net.Listen("tcp", l.addr)
+	if err == nil {
+		ln.Close()
+		f.Status = StatusOK
+		f.Message = fmt.Sprintf("%s %s is free", l.name, l.addr)
+		return f
+	}
+
+	_, port, _ := net.SplitHostPort(l.addr)
+	switch {
+	case errors.Is(err, syscall.EADDRINUSE) && running:
+		f.Status = StatusOK
+		f.Message = fmt.Sprintf("%s %s is in use by the running service", l.name, l.addr)
+	case errors.Is(err, syscall.EADDRINUSE):
+		f.Status = StatusFail
+		f.Message = fmt.Sprintf("%s %s is already in use", l.name, l.addr)
+		f.Fix = fmt.Sprintf("find the process with `ss -ltnp 'sport = :%s'` or change %s", port, l.key)
+	case errors.Is(err, syscall.EACCES):
+		f.Status = StatusFail
+		f.Message = fmt.Sprintf("%s %s needs privileges to bind", l.name, l.addr)
+		f.Fix = fmt.Sprintf("run the service as root, grant CAP_NET_BIND_SERVICE or change %s to a port above 1023", l.key)
+	case errors.Is(err, syscall.EADDRNOTAVAIL):
+		f.Status = StatusFail
+		f.Message = fmt.Sprintf("%s %s is not an address of this host", l.name, l.addr)
+		f.Fix = "change " + l.key + " or its listen host"
+	default:
+		f.Status = StatusFail
+		f.Message = fmt.Sprintf("%s %s: %v", l.name, l.addr, err)
+	}
+	return f
+}
+
+func serverCertificates(cfg *config.ServerConfig) []certificate {
+	var certs []certificate
+	for _, ep := range []struct {
+		name string
+		tls  config.ServerTLSConfig
+	}{{"upstream", cfg.Server.Upstream.TLS}, {"downstream", cfg.Server.Downstream.TLS}} {
+		if !ep.tls.Enabled {
+			continue
+		}
+		if ep.tls.CertFile != "" {
+			certs = append(certs, certificate{ep.name + " certificate", ep.tls.CertFile})
+		}
+		if ep.tls.ClientCAFile != "" {
+			certs = append(certs, certificate{ep.name + " client CA", ep.tls.ClientCAFile})
+		}
+	}
+	return certs
+}
+
+func clientCertificates(cfg *config.ClientConfig) []certificate {
+	var certs []certificate
+	for _, ep := range []struct {
+		name string
+		tls  config.ClientTLSConfig
+	}{{"upstream", cfg.Client.Upstream.TLS}, {"downstream", cfg.Client.Downstream.TLS}} {
+		if !ep.tls.Enabled {
+			continue
+		}
+		if ep.tls.CertFile != "" {
+			certs = append(certs, certificate{ep.name + " client certificate", ep.tls.CertFile})
+		}
+		if ep.tls.CAFile != "" {
+			certs = append(certs, certificate{ep.name + " CA", ep.tls.CAFile})
+		}
+	}
+	return certs
+}
+
+// checkCertificates reports certificate files that are unreadable, expired
+// or about to expire. The same file used by both paths is checked once.
+func checkCertificates(certs []certificate, now time.Time) []Finding {
+	var findings []Finding
+	seen := make(map[string]bool)
+	for _, c := range certs {
+		if seen[c.path] {
+			continue
+		}
+		seen[c.path] = true
+		findings = append(findings, checkCertificate(c, now))
+	}
+	return findings
+}
+
+func checkCertificate(c certificate, now time.Time) Finding {
+	f := Finding{Check: "certificate"}
+	cert, err := readCertificate(c.path)
+	if err != nil {
+		f.Status = StatusFail
+		f.Message = fmt.Sprintf("%s %s: %v", c.name, c.path, err)
+		f.Fix = "check the file path and that it holds a PEM certificate"
+		return f
+	}
+
+	subject := cert.Subject.CommonName
+	if subject == "" && len(cert.DNSNames) > 0 {
+		subject = strings.Join(cert.DNSNames, ", ")
+	}
+	left := cert.NotAfter.Sub(now)
+	switch {
+	case now.Before(cert.NotBefore):
+		f.Status = StatusFail
+		f.Message = fmt.Sprintf("%s %q is not valid until %s", c.name, subject, cert.NotBefore.Format(time.DateOnly))
+		f.Fix = "check the system clock or reissue the certificate"
+	case left <= 0:
+		f.Status = StatusFail
+		f.Message = fmt.Sprintf("%s %q expired on %s", c.name, subject, cert.NotAfter.Format(time.DateOnly))
+		f.Fix = "renew " + c.path
+	case left < certWarnBefore:
+		f.Status = StatusWarn
+		f.Message = fmt.Sprintf("%s %q expires in %d days (%s)", c.name, subject, int(left.Hours()/24), cert.NotAfter.Format(time.DateOnly))
+		f.Fix = "renew " + c.path
+	default:
+		f.Status = StatusOK
+		f.Message = fmt.Sprintf("%s %q valid until %s", c.name, subject, cert.NotAfter.Format(time.DateOnly))
+	}
+	return f
+}
+
+// readCertificate parses the first certificate in a PEM file.
+func readCertificate(path string) (*x509.Certificate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("no PEM certificate found")
+		}
+		if block.Type == "CERTIFICATE" {
+			return x509.ParseCertificate(block.Bytes)
+		}
+	}
+}
@@ -0,0 +1,285 @@
+// Package doctor checks the environment a Half-Tunnel service runs in: the
+// service manager, binary and config, listener ports, certificates and the
+// system clock. Each problem found comes with a suggested fix.
+package doctor
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/sahmadiut/half-tunnel/internal/config"
+	"github.com/sahmadiut/half-tunnel/internal/service"
+)
+
+// Status is the outcome of a check.
+type Status string
+
+const (
+	// StatusOK means nothing needs attention.
+	StatusOK Status = "ok"
+	// StatusWarn means the service can run but something is likely to cause
+	// trouble.
+	StatusWarn Status = "warn"
+	// StatusFail means the service will not run correctly.
+	StatusFail Status = "fail"
+	// StatusSkip means the check could not be made.
+	StatusSkip Status = "skip"
+)
+
+// Finding is the result of one check.
+type Finding struct {
+	Check   string
+	Status  Status
+	Message string
+	// Fix suggests what to do about a warning or failure
+	Fix string
+}
+
+// Options selects what to check.
+type Options struct {
+	// Service is the service type to check
+	Service service.ServiceType
+	// ConfigPath is the config to check; the installed service's config
+	// takes precedence
+	ConfigPath string
+	// BinaryPath is the binary to check; the installed service's binary
+	// takes precedence
+	BinaryPath string
+}
+
+// certWarnBefore is how long before expiry a certificate is reported.
+const certWarnBefore = 14 * 24 * time.Hour
+
+// maxClockSkew is the clock difference to the server that is reported.
+// Rotating path tokens and guest tokens depend on both clocks agreeing.
+const maxClockSkew = 30 * time.Second
+
+// Run checks the environment of the service and returns what it found.
+func Run(ctx context.Context, opts Options) []Finding {
+	var findings []Finding
+	installed := service.IsInstalled(opts.Service)
+	binaryPath, configPath := opts.BinaryPath, opts.ConfigPath
+	if installed {
+		if binary, cfg, err := service.InstalledPaths(opts.Service); err == nil {
+			binaryPath = binary
+			if cfg != "" {
+				configPath = cfg
+			}
+		}
+	}
+
+	findings = append(findings, checkInitSystem(opts.Service, installed))
+	findings = append(findings, checkBinary(binaryPath))
+
+	running := installed && service.IsRunning(opts.Service)
+	var clockCheck Finding
+	switch opts.Service {
+	case service.ServerService:
+		cfg, finding := loadServerConfig(configPath)
+		findings = append(findings, finding)
+		if cfg == nil {
+			break
+		}
+		findings = append(findings, checkListeners(serverListeners(cfg), running)...)
+		findings = append(findings, checkCertificates(serverCertificates(cfg), time.Now())...)
+	case service.ClientService:
+		cfg, finding := loadClientConfig(configPath)
+		findings = append(findings, finding)
+		if cfg == nil {
+			break
+		}
+		findings = append(findings, checkListeners(clientListeners(cfg), running)...)
+		findings = append(findings, checkCertificates(clientCertificates(cfg), time.Now())...)
+		clockCheck = checkServerClock(ctx, cfg)
+	}
+
+	findings = append(findings, checkTimeSync())
+	if clockCheck.Check != "" {
+		findings = append(findings, clockCheck)
+	}
+	return findings
+}
+
+// Failed reports whether any finding is a failure.
+func Failed(findings []Finding) bool {
+	for _, f := range findings {
+		if f.Status == StatusFail {
+			return true
+		}
+	}
+	return false
+}
+
+func checkInitSystem(t service.ServiceType, installed bool) Finding {
+	f := Finding{Check: "service"}
+	initSystem := service.InitSystemFor(t)
+	switch {
+	case !installed:
+		f.Status = StatusWarn
+		f.Message = fmt.Sprintf("%s is not installed", service.ServiceName(t))
+		f.Fix = fmt.Sprintf("ht %s install --config <path>", t)
+	case !initSystem.Available():
+		f.Status = StatusFail
+		f.Message = fmt.Sprintf("%s is installed with %s, which is not running", service.ServiceName(t), initSystem.Name())
+		f.Fix = fmt.Sprintf("ht %s install --init %s", t, service.DetectInitSystem().Name())
+	case initSystem.Name() == "plain":
+		f.Status = StatusWarn
+		f.Message = fmt.Sprintf("%s installed as a plain background process (%s); it is not restarted or started on boot",
+			service.ServiceName(t), initSystem.FilePath(t))
+		f.Fix = "start it from a boot script or container entrypoint, or use a supervisor"
+	default:
+		f.Status = StatusOK
+		f.Message = fmt.Sprintf("%s installed with %s (%s)", service.ServiceName(t), initSystem.Name(), initSystem.FilePath(t))
+	}
+	return f
+}
+
+func checkBinary(path string) Finding {
+	f := Finding{Check: "binary"}
+	info, err := os.Stat(path)
+	switch {
+	case err != nil:
+		f.Status = StatusFail
+		f.Message = fmt.Sprintf("%s not found", path)
+		f.Fix = "install the binary there or reinstall the service with --binary"
+	case info.IsDir() || info.Mode()&0111 == 0:
+		f.Status = StatusFail
+		f.Message = fmt.Sprintf("%s is not executable", path)
+		f.Fix = "chmod +x " + path
+	default:
+		f.Status = StatusOK
+		f.Message = path
+	}
+	return f
+}
+
+func loadServerConfig(path string) (*config.ServerConfig, Finding) {
+	f := Finding{Check: "config"}
+	cfg, err := config.LoadServerConfigFromFile(path)
+	if err == nil {
+		err = cfg.Validate()
+	}
+	if err != nil {
+		f.Status = StatusFail
+		f.Message = fmt.Sprintf("%s: %v", path, err)
+		f.Fix = "half-tunnel config validate --type server --config " + path
+		return nil, f
+	}
+	f.Status = StatusOK
+	f.Message = path + " is valid"
+	return cfg, f
+}
+
+func loadClientConfig(path string) (*config.ClientConfig, Finding) {
+	f := Finding{Check: "config"}
+	cfg, err := config.LoadClientConfigFromFile(path)
+	if err == nil {
+		err = cfg.Validate()
+	}
+	if err != nil {
+		f.Status = StatusFail
+		f.Message = fmt.Sprintf("%s: %v", path, err)
+		f.Fix = "half-tunnel config validate --type client --config " + path
+		return nil, f
+	}
+	f.Status = StatusOK
+	f.Message = path + " is valid"
+	return cfg, f
+}
+
+// checkTimeSync reports whether systemd-timesyncd or another NTP client keeps
+// the clock synchronized.
+func checkTimeSync() Finding {
+	f := Finding{Check: "clock"}
+	out, err := exec.Command("timedatectl", "show", "--property=NTPSynchronized", "--value").Output()
+	if err != nil {
+		f.Status = StatusSkip
+		f.Message = "cannot tell whether the clock is synchronized (timedatectl unavailable)"
+		return f
+	}
+	if strings.TrimSpace(string(out)) != "yes" {
+		f.Status = StatusWarn
+		f.Message = "system clock is not synchronized with NTP"
+		f.Fix = "timedatectl set-ntp true"
+		return f
+	}
+	f.Status = StatusOK
+	f.Message = "synchronized with NTP"
+	return f
+}
+
+// checkServerClock compares the local clock to the Date header of the
+// client's upstream server.
+func checkServerClock(ctx context.Context, cfg *config.ClientConfig) Finding {
+	f := Finding{Check: "clock skew"}
+	tlsConfig, err := cfg.Client.Upstream.TLS.Load()
+	if err != nil {
+		f.Status = StatusSkip
+		f.Message = err.Error()
+		return f
+	}
+	proxyURL, _ := cfg.Client.Upstream.Proxy()
+	skew, err := clockSkew(ctx, cfg.Client.Upstream.URL, &http.Transport{
+		TLSClientConfig: tlsConfig,
+		Proxy:           http.ProxyURL(proxyURL),
+	}, time.Now)
+	if err != nil {
+		f.Status = StatusSkip
+		f.Message = fmt.Sprintf("cannot read the server's clock: %v", err)
+		return f
+	}
+	return skewFinding(skew)
+}
+
+// skewFinding reports a clock difference to the server.
+func skewFinding(skew time.Duration) Finding {
+	f := Finding{Check: "clock skew", Status: StatusOK}
+	abs := skew
+	if abs < 0 {
+		abs = -abs
+	}
+	direction := "ahead of"
+	if skew < 0 {
+		direction = "behind"
+	}
+	f.Message = fmt.Sprintf("%s %s the server", abs.Round(time.Second), direction)
+	if abs > maxClockSkew {
+		f.Status = StatusWarn
+		f.Fix = "synchronize both clocks (timedatectl set-ntp true); path and guest tokens need them to agree"
+	}
+	return f
+}
+
+// clockSkew returns how far the local clock is ahead of the server at
+// rawURL, judged by the Date header of an HTTP request to it. The header
+// has one second resolution.
+func clockSkew(ctx context.Context, rawURL string, transport http.RoundTripper, now func() time.Time) (time.Duration, error) {
+	u := strings.Replace(strings.Replace(rawURL, "wss://", "https://", 1), "ws://", "http://", 1)
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, u, nil)
+	if err != nil {
+		return 0, err
+	}
+	start := now()
+	resp, err := (&http.Client{Transport: transport}).Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	end := now()
+
+	date, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		return 0, fmt.Errorf("no usable Date header")
+	}
+	// The server stamped the response somewhere during the round trip
+	local := start.Add(end.Sub(start) / 2)
+	return local.Sub(date), nil
+}
@@ -0,0 +1,143 @@
+package doctor
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCheckListener(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer ln.Close()
+	taken := listener{"SOCKS5 proxy", "socks5.listen_port", ln.Addr().String()}
+
+	if f := checkListener(taken, false); f.Status != StatusFail || f.Fix == "" {
+		t.Errorf("Expected a taken port to fail with a fix, got %+v", f)
+	}
+	if f := checkListener(taken, true); f.Status != StatusOK {
+		t.Errorf("Expected a port taken by the running service to pass, got %+v", f)
+	}
+
+	free := listener{"metrics", "observability.metrics.port", "127.0.0.1:0"}
+	if f := checkListener(free, false); f.Status != StatusOK {
+		t.Errorf("Expected a free port to pass, got %+v", f)
+	}
+}
+
+func TestCheckCertificate(t *testing.T) {
+	now := time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+	dir := t.TempDir()
+
+	tests := []struct {
+		name      string
+		notBefore time.Time
+		notAfter  time.Time
+		want      Status
+	}{
+		{"valid", now.AddDate(0, -1, 0), now.AddDate(1, 0, 0), StatusOK},
+		{"expiring", now.AddDate(0, -1, 0), now.AddDate(0, 0, 3), StatusWarn},
+		{"expired", now.AddDate(-1, 0, 0), now.AddDate(0, 0, -1), StatusFail},
+		{"not yet valid", now.AddDate(0, 0, 1), now.AddDate(1, 0, 0), StatusFail},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, tt.name+".pem")
+			writeCertificate(t, path, tt.notBefore, tt.notAfter)
+
+			f := checkCertificate(certificate{"upstream certificate", path}, now)
+			if f.Status != tt.want {
+				t.Errorf("Expected %s, got %s: %s", tt.want, f.Status, f.Message)
+			}
+		})
+	}
+
+	missing := checkCertificate(certificate{"upstream certificate", filepath.Join(dir, "missing.pem")}, now)
+	if missing.Status != StatusFail {
+		t.Errorf("Expected a missing file to fail, got %+v", missing)
+	}
+}
+
+func TestClockSkew(t *testing.T) {
+	serverTime := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Date", serverTime.Format(http.TimeFormat))
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer srv.Close()
+
+	now := func() time.Time { return serverTime.Add(2 * time.Minute) }
+	skew, err := clockSkew(context.Background(), "ws"+srv.URL[len("http"):]+"/upstream", http.DefaultTransport, now)
+	if err != nil {
+		t.Fatalf("clockSkew failed: %v", err)
+	}
+	if skew != 2*time.Minute {
+		t.Errorf("Expected 2m skew, got %v", skew)
+	}
+
+	if f := skewFinding(skew); f.Status != StatusWarn {
+		t.Errorf("Expected a 2m skew to warn, got %+v", f)
+	}
+	if f := skewFinding(-time.Second); f.Status != StatusOK {
+		t.Errorf("Expected a 1s skew to pass, got %+v", f)
+	}
+}
+
+func TestCheckBinary(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "ht-client")
+
+	if f := checkBinary(path); f.Status != StatusFail {
+		t.Errorf("Expected a missing binary to fail, got %+v", f)
+	}
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if f := checkBinary(path); f.Status != StatusFail {
+		t.Errorf("Expected a non-executable binary to fail, got %+v", f)
+	}
+	if err := os.Chmod(path, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if f := checkBinary(path); f.Status != StatusOK {
+		t.Errorf("Expected an executable binary to pass, got %+v", f)
+	}
+}
+
+// writeCertificate writes a self-signed PEM certificate valid between
+// notBefore and notAfter.
+func writeCertificate(t *testing.T, path string, notBefore, notAfter time.Time) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "tunnel.example.com"},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,370 @@
+// Package geoip looks up the country of IP addresses in a MaxMind DB (.mmdb)
+// file, such as GeoLite2-Country or the free DB-IP country databases.
+package geoip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"strings"
+)
+
+// metadataMarker precedes the metadata section at the end of the file.
+var metadataMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+// maxMetadataSize bounds the search for the metadata marker.
+const maxMetadataSize = 128 * 1024
+
+// dataSectionSeparator is the gap between the search tree and the data.
+const dataSectionSeparator = 16
+
+// ErrInvalidDatabase is returned for files that are not MaxMind databases.
+var ErrInvalidDatabase = errors.New("invalid MaxMind database")
+
+// Reader is an in-memory MaxMind database. It is safe for concurrent use.
+type Reader struct {
+	buf        []byte
+	tree       []byte
+	data       []byte
+	nodeCount  uint
+	recordSize uint
+	ipVersion  uint
+	// ipv4Start is the node reached after 96 zero bits in an IPv6 tree,
+	// where IPv4 addresses are stored
+	ipv4Start uint
+
+	// DatabaseType is the type from the metadata, e.g. "GeoLite2-Country"
+	DatabaseType string
+}
+
+// Open reads the database at path into memory.
+func Open(path string) (*Reader, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	r, err := FromBytes(buf)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return r, nil
+}
+
+// FromBytes parses a database held in buf.
+func FromBytes(buf []byte) (*Reader, error) {
+	searchFrom := 0
+	if len(buf) > maxMetadataSize {
+		searchFrom = len(buf) - maxMetadataSize
+	}
+	i := bytes.LastIndex(buf[searchFrom:], metadataMarker)
+	if i < 0 {
+		return nil, ErrInvalidDatabase
+	}
+	metaStart := searchFrom + i + len(metadataMarker)
+
+	meta, _, err := (&decoder{buf: buf[metaStart:]}).decode(0)
+	if err != nil {
+		return nil, fmt.Errorf("%w: metadata: %v", ErrInvalidDatabase, err)
+	}
+	m, ok := meta.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%w: metadata is not a map", ErrInvalidDatabase)
+	}
+
+	r := &Reader{buf: buf}
+	r.nodeCount = uint(toUint(m["node_count"]))
+	r.recordSize = uint(toUint(m["record_size"]))
+	r.ipVersion = uint(toUint(m["ip_version"]))
+	r.DatabaseType, _ = m["database_type"].(string)
+
+	switch r.recordSize {
+	case 24, 28, 32:
+	default:
+		return nil, fmt.Errorf("%w: unsupported record size %d", ErrInvalidDatabase, r.recordSize)
+	}
+	if r.ipVersion != 4 && r.ipVersion != 6 {
+		return nil, fmt.Errorf("%w: unsupported IP version %d", ErrInvalidDatabase, r.ipVersion)
+	}
+	treeSize := r.nodeCount * r.recordSize / 4
+	if treeSize+dataSectionSeparator > uint(searchFrom+i) {
+		return nil, fmt.Errorf("%w: search tree exceeds file", ErrInvalidDatabase)
+	}
+	r.tree = buf[:treeSize]
+	r.data = buf[treeSize+dataSectionSeparator : searchFrom+i]
+
+	if r.ipVersion == 6 {
+		node := uint(0)
+		for i := 0; i < 96 && node < r.nodeCount; i++ {
+			node = r.record(node, 0)
+		}
+		r.ipv4Start = node
+	}
+	return r, nil
+}
+
+// record returns the left (bit 0) or right (bit 1) record of node.
+func (r *Reader) record(node uint, bit uint) uint {
+	switch r.recordSize {
+	case 24:
+		off := node*6 + bit*3
+		b := r.tree[off : off+3]
+		return uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+	case 28:
+		off := node * 7
+		b := r.tree[off : off+7]
+		if bit == 0 {
+			return uint(b[3]&0xf0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+		}
+		return uint(b[3]&0x0f)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6])
+	default:
+		off := node*8 + bit*4
+		return uint(binary.BigEndian.Uint32(r.tree[off : off+4]))
+	}
+}
+
+// Lookup returns the record for ip, or nil if the database has none.
+func (r *Reader) Lookup(ip net.IP) (interface{}, error) {
+	node := uint(0)
+	var bits []byte
+	if ip4 := ip.To4(); ip4 != nil {
+		bits = ip4
+		if r.ipVersion == 6 {
+			node = r.ipv4Start
+		}
+	} else {
+		if r.ipVersion == 4 {
+			return nil, nil
+		}
+		bits = ip.To16()
+		if bits == nil {
+			return nil, fmt.Errorf("invalid IP address: %v", ip)
+		}
+	}
+
+	for i := 0; i < len(bits)*8 && node < r.nodeCount; i++ {
+		bit := uint(bits[i/8]>>(7-uint(i%8))) & 1
+		node = r.record(node, bit)
+	}
+	switch {
+	case node == r.nodeCount:
+		return nil, nil
+	case node < r.nodeCount:
+		return nil, fmt.Errorf("%w: search tree too deep", ErrInvalidDatabase)
+	}
+
+	offset := node - r.nodeCount - dataSectionSeparator
+	value, _, err := (&decoder{buf: r.data}).decode(offset)
+	return value, err
+}
+
+// Country returns the ISO 3166-1 alpha-2 code of the country ip is located
+// in, or of the country it is registered to when the location is unknown.
+// It returns "" for addresses not in the database.
+func (r *Reader) Country(ip net.IP) (string, error) {
+	value, err := r.Lookup(ip)
+	if err != nil || value == nil {
+		return "", err
+	}
+	record, _ := value.(map[string]interface{})
+	for _, key := range []string{"country", "registered_country"} {
+		if country, ok := record[key].(map[string]interface{}); ok {
+			if code, ok := country["iso_code"].(string); ok && code != "" {
+				return strings.ToUpper(code), nil
+			}
+		}
+	}
+	return "", nil
+}
+
+func toUint(v interface{}) uint64 {
+	n, _ := v.(uint64)
+	return n
+}
+
+// Data section types
+const (
+	typeExtended = iota
+	typePointer
+	typeString
+	typeDouble
+	typeBytes
+	typeUint16
+	typeUint32
+	typeMap
+	typeInt32
+	typeUint64
+	typeUint128
+	typeArray
+	typeContainer
+	typeEndMarker
+	typeBool
+	typeFloat
+)
+
+// maxDecodeDepth bounds nesting so corrupt files cannot exhaust the stack.
+const maxDecodeDepth = 64
+
+// decoder decodes values of the MaxMind DB data section format.
+type decoder struct {
+	buf   []byte
+	depth int
+}
+
+func (d *decoder) errTruncated() error {
+	return fmt.Errorf("%w: truncated data", ErrInvalidDatabase)
+}
+
+// decode decodes the value at offset and returns it with the offset after it.
+// Maps decode to map[string]interface{}, arrays to []interface{}, unsigned
+// integers to uint64, signed ones to int64 and floats to float64.
+func (d *decoder) decode(offset uint) (interface{}, uint, error) {
+	d.depth++
+	defer func() { d.depth-- }()
+	if d.depth > maxDecodeDepth {
+		return nil, 0, fmt.Errorf("%w: data nested too deeply", ErrInvalidDatabase)
+	}
+
+	if offset >= uint(len(d.buf)) {
+		return nil, 0, d.errTruncated()
+	}
+	ctrl := d.buf[offset]
+	offset++
+	typ := uint(ctrl >> 5)
+
+	if typ == typePointer {
+		pointer, next, err := d.pointer(ctrl, offset)
+		if err != nil {
+			return nil, 0, err
+		}
+		value, _, err := d.decode(pointer)
+		return value, next, err
+	}
+
+	if typ == typeExtended {
+		if offset >= uint(len(d.buf)) {
+			return nil, 0, d.errTruncated()
+		}
+		typ = 7 + uint(d.buf[offset])
+		offset++
+	}
+
+	size := uint(ctrl & 0x1f)
+	if size >= 29 {
+		n := size - 28
+		if offset+n > uint(len(d.buf)) {
+			return nil, 0, d.errTruncated()
+		}
+		extra := uint(0)
+		for _, b := range d.buf[offset : offset+n] {
+			extra = extra<<8 | uint(b)
+		}
+		offset += n
+		switch size {
+		case 29:
+			size = 29 + extra
+		case 30:
+			size = 285 + extra
+		default:
+			size = 65821 + extra
+		}
+	}
+
+	switch typ {
+	case typeMap:
+		m := make(map[string]interface{}, size)
+		for i := uint(0); i < size; i++ {
+			key, next, err := d.decode(offset)
+			if err != nil {
+				return nil, 0, err
+			}
+			k, ok := key.(string)
+			if !ok {
+				return nil, 0, fmt.Errorf("%w: map key is not a string", ErrInvalidDatabase)
+			}
+			value, next, err := d.decode(next)
+			if err != nil {
+				return nil, 0, err
+			}
+			m[k] = value
+			offset = next
+		}
+		return m, offset, nil
+	case typeArray:
+		a := make([]interface{}, 0, size)
+		for i := uint(0); i < size; i++ {
+			value, next, err := d.decode(offset)
+			if err != nil {
+				return nil, 0, err
+			}
+			a = append(a, value)
+			offset = next
+		}
+		return a, offset, nil
+	case typeBool:
+		return size != 0, offset, nil
+	}
+
+	if offset+size > uint(len(d.buf)) {
+		return nil, 0, d.errTruncated()
+	}
+	payload := d.buf[offset : offset+size]
+	offset += size
+
+	switch typ {
+	case typeString:
+		return string(payload), offset, nil
+	case typeBytes, typeUint128:
+		return append([]byte(nil), payload...), offset, nil
+	case typeUint16, typeUint32, typeUint64:
+		var n uint64
+		for _, b := range payload {
+			n = n<<8 | uint64(b)
+		}
+		return n, offset, nil
+	case typeInt32:
+		var n uint32
+		for _, b := range payload {
+			n = n<<8 | uint32(b)
+		}
+		return int64(int32(n)), offset, nil
+	case typeDouble:
+		if size != 8 {
+			return nil, 0, fmt.Errorf("%w: invalid double size %d", ErrInvalidDatabase, size)
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(payload)), offset, nil
+	case typeFloat:
+		if size != 4 {
+			return nil, 0, fmt.Errorf("%w: invalid float size %d", ErrInvalidDatabase, size)
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(payload))), offset, nil
+	default:
+		return nil, 0, fmt.Errorf("%w: unsupported data type %d", ErrInvalidDatabase, typ)
+	}
+}
+
+// pointer decodes the pointer whose control byte is ctrl and returns its
+// target and the offset after it.
+func (d *decoder) pointer(ctrl byte, offset uint) (uint, uint, error) {
+	n := uint(ctrl>>3)&0x3 + 1
+	if offset+n > uint(len(d.buf)) {
+		return 0, 0, d.errTruncated()
+	}
+	b := d.buf[offset : offset+n]
+	vvv := uint(ctrl & 0x7)
+	var pointer uint
+	switch n {
+	case 1:
+		pointer = vvv<<8 | uint(b[0])
+	case 2:
+		pointer = (vvv<<16 | uint(b[0])<<8 | uint(b[1])) + 2048
+	case 3:
+		pointer = (vvv<<24 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])) + 526336
+	default:
+		pointer = uint(binary.BigEndian.Uint32(b))
+	}
+	return pointer, offset + n, nil
+}
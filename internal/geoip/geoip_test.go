@@ -0,0 +1,222 @@
+package geoip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testWriter builds small MaxMind databases for tests.
+type testWriter struct {
+	data    bytes.Buffer
+	records [][2]int64 // >= 0: node, -1: empty, <= -2: data offset -(v+2)
+}
+
+func writeControl(buf *bytes.Buffer, typ, size int) {
+	var ctrl byte
+	var ext []byte
+	if typ > 7 {
+		ext = []byte{byte(typ - 7)}
+	} else {
+		ctrl = byte(typ << 5)
+	}
+	var extra []byte
+	switch {
+	case size < 29:
+		ctrl |= byte(size)
+	case size < 285:
+		ctrl |= 29
+		extra = []byte{byte(size - 29)}
+	default:
+		ctrl |= 30
+		extra = []byte{byte((size - 285) >> 8), byte(size - 285)}
+	}
+	buf.WriteByte(ctrl)
+	buf.Write(ext)
+	buf.Write(extra)
+}
+
+// encode appends v to the data section and returns its offset.
+func (w *testWriter) encode(v interface{}) int {
+	offset := w.data.Len()
+	encodeValue(&w.data, v)
+	return offset
+}
+
+// pointer is encoded as a data section pointer.
+type pointer int
+
+func encodeValue(buf *bytes.Buffer, v interface{}) {
+	switch val := v.(type) {
+	case string:
+		writeControl(buf, typeString, len(val))
+		buf.WriteString(val)
+	case uint32:
+		writeControl(buf, typeUint32, 4)
+		_ = binary.Write(buf, binary.BigEndian, val)
+	case uint16:
+		writeControl(buf, typeUint16, 2)
+		_ = binary.Write(buf, binary.BigEndian, val)
+	case bool:
+		size := 0
+		if val {
+			size = 1
+		}
+		writeControl(buf, typeBool, size)
+	case pointer:
+		p := int(val) - 2048
+		buf.Write([]byte{byte(typePointer<<5) | 1<<3 | byte(p>>16), byte(p >> 8), byte(p)})
+	case []interface{}:
+		writeControl(buf, typeArray, len(val))
+		for _, item := range val {
+			encodeValue(buf, item)
+		}
+	case map[string]interface{}:
+		writeControl(buf, typeMap, len(val))
+		for key, item := range val {
+			encodeValue(buf, key)
+			encodeValue(buf, item)
+		}
+	default:
+		panic("unsupported test value")
+	}
+}
+
+// insert maps the network to the data at offset in an IPv6 tree.
+func (w *testWriter) insert(cidr string, offset int) {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		panic(err)
+	}
+	ones, _ := network.Mask.Size()
+	ip := network.IP.To16()
+	if network.IP.To4() != nil {
+		ip = append(make(net.IP, 12), network.IP.To4()...)
+		ones += 96
+	}
+	if len(w.records) == 0 {
+		w.records = append(w.records, [2]int64{-1, -1})
+	}
+	node := 0
+	for i := 0; i < ones; i++ {
+		bit := (ip[i/8] >> (7 - uint(i%8))) & 1
+		if i == ones-1 {
+			w.records[node][bit] = -int64(offset) - 2
+			break
+		}
+		if w.records[node][bit] < 0 {
+			w.records = append(w.records, [2]int64{-1, -1})
+			w.records[node][bit] = int64(len(w.records) - 1)
+		}
+		node = int(w.records[node][bit])
+	}
+}
+
+// bytes returns the database with 28-bit records.
+func (w *testWriter) bytes() []byte {
+	nodeCount := int64(len(w.records))
+	value := func(v int64) int64 {
+		switch {
+		case v >= 0:
+			return v
+		case v == -1:
+			return nodeCount
+		default:
+			return nodeCount + 16 + (-v - 2)
+		}
+	}
+
+	var out bytes.Buffer
+	for _, rec := range w.records {
+		l, r := value(rec[0]), value(rec[1])
+		out.Write([]byte{byte(l >> 16), byte(l >> 8), byte(l), byte(l>>24)<<4 | byte(r>>24)&0x0f, byte(r >> 16), byte(r >> 8), byte(r)})
+	}
+	out.Write(make([]byte, 16))
+	out.Write(w.data.Bytes())
+	out.Write(metadataMarker)
+
+	var meta bytes.Buffer
+	encodeValue(&meta, map[string]interface{}{
+		"node_count":                  uint32(nodeCount),
+		"record_size":                 uint16(28),
+		"ip_version":                  uint16(6),
+		"database_type":               "Test-Country",
+		"binary_format_major_version": uint16(2),
+	})
+	out.Write(meta.Bytes())
+	return out.Bytes()
+}
+
+func TestCountry(t *testing.T) {
+	w := &testWriter{}
+	// Make the first pointer target beyond the 2048 bytes a short pointer can
+	// reach, so the two-byte pointer form is exercised
+	w.encode(map[string]interface{}{"padding": string(make([]byte, 2100))})
+	de := w.encode(map[string]interface{}{"iso_code": "DE", "names": map[string]interface{}{"en": "Germany"}})
+	german := w.encode(map[string]interface{}{"country": pointer(de), "continent": map[string]interface{}{"code": "EU"}})
+	registered := w.encode(map[string]interface{}{
+		"registered_country": map[string]interface{}{"iso_code": "us"},
+		"is_anycast":         true,
+		"tags":               []interface{}{"a", uint32(7)},
+	})
+	w.insert("192.0.2.0/24", german)
+	w.insert("2001:db8::/32", german)
+	w.insert("198.51.100.0/25", registered)
+
+	path := filepath.Join(t.TempDir(), "test.mmdb")
+	if err := os.WriteFile(path, w.bytes(), 0644); err != nil {
+		t.Fatalf("Failed to write database: %v", err)
+	}
+	r, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	if r.DatabaseType != "Test-Country" {
+		t.Errorf("Expected database type Test-Country, got %q", r.DatabaseType)
+	}
+
+	tests := []struct {
+		ip   string
+		want string
+	}{
+		{"192.0.2.77", "DE"},
+		{"2001:db8::1", "DE"},
+		{"198.51.100.5", "US"},
+		{"198.51.100.200", ""},
+		{"203.0.113.1", ""},
+		{"2001:db9::1", ""},
+	}
+	for _, tt := range tests {
+		got, err := r.Country(net.ParseIP(tt.ip))
+		if err != nil {
+			t.Errorf("Country(%s) returned error: %v", tt.ip, err)
+		}
+		if got != tt.want {
+			t.Errorf("Country(%s) = %q, want %q", tt.ip, got, tt.want)
+		}
+	}
+}
+
+func TestFromBytesInvalid(t *testing.T) {
+	if _, err := FromBytes([]byte("not a database")); !errors.Is(err, ErrInvalidDatabase) {
+		t.Errorf("Expected ErrInvalidDatabase, got %v", err)
+	}
+
+	// A valid header pointing at a tree larger than the file
+	var buf bytes.Buffer
+	buf.Write(metadataMarker)
+	var meta bytes.Buffer
+	encodeValue(&meta, map[string]interface{}{
+		"node_count":  uint32(1000),
+		"record_size": uint16(24),
+		"ip_version":  uint16(4),
+	})
+	buf.Write(meta.Bytes())
+	if _, err := FromBytes(buf.Bytes()); !errors.Is(err, ErrInvalidDatabase) {
+		t.Errorf("Expected ErrInvalidDatabase for a truncated tree, got %v", err)
+	}
+}
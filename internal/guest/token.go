@@ -0,0 +1,145 @@
+// Package guest provides signed, time-limited guest tokens for the Half-Tunnel server.
+//
+// A guest token is issued offline from the server's guest secret and carries its
+// own expiry and traffic cap, so the server can verify it without keeping state.
+// Sessions opened with a guest token are closed when the token expires or its
+// traffic cap is used up.
+package guest
+
+import (
+	"crypto/hmac"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/sahmadiut/half-tunnel/pkg/crypto"
+)
+
+// TokenPrefix identifies guest tokens.
+const TokenPrefix = "htg_"
+
+const (
+	idSize   = 8
+	bodySize = idSize + 8 + 8 // id + expiry + traffic cap
+	tagSize  = 16
+)
+
+// keySalt separates guest token keys from other uses of the same secret.
+var keySalt = []byte("half-tunnel-guest-token")
+
+// Errors
+var (
+	ErrMalformedToken   = errors.New("malformed guest token")
+	ErrInvalidSignature = errors.New("invalid guest token signature")
+	ErrTokenExpired     = errors.New("guest token expired")
+	ErrEmptySecret      = errors.New("guest secret is empty")
+)
+
+// Token describes the limits granted by a guest token.
+type Token struct {
+	// ID identifies the token; traffic is accounted per ID.
+	ID string
+	// ExpiresAt is when sessions using the token are closed.
+	ExpiresAt time.Time
+	// TrafficCap is the total number of bytes allowed (0 = unlimited).
+	TrafficCap int64
+}
+
+// Issue creates a signed guest token valid for ttl with the given traffic cap in bytes.
+func Issue(secret string, ttl time.Duration, trafficCap int64) (string, *Token, error) {
+	if ttl <= 0 {
+		return "", nil, errors.New("guest token TTL must be positive")
+	}
+	if trafficCap < 0 {
+		return "", nil, errors.New("guest token traffic cap must not be negative")
+	}
+
+	h, err := newSigner(secret)
+	if err != nil {
+		return "", nil, err
+	}
+
+	id, err := crypto.GenerateKey(idSize)
+	if err != nil {
+		return "", nil, err
+	}
+
+	token := &Token{
+		ID:         hex.EncodeToString(id),
+		ExpiresAt:  time.Now().Add(ttl).Truncate(time.Second),
+		TrafficCap: trafficCap,
+	}
+
+	body := make([]byte, bodySize, bodySize+tagSize)
+	copy(body, id)
+	binary.BigEndian.PutUint64(body[idSize:], uint64(token.ExpiresAt.Unix()))
+	binary.BigEndian.PutUint64(body[idSize+8:], uint64(trafficCap))
+	body = append(body, h.Sign(body)...)
+
+	return TokenPrefix + base64.RawURLEncoding.EncodeToString(body), token, nil
+}
+
+// Parse verifies a guest token against the secret and returns its limits.
+// Tokens past their expiry at now are rejected with ErrTokenExpired.
+func Parse(secret, s string, now time.Time) (*Token, error) {
+	h, err := newSigner(secret)
+	if err != nil {
+		return nil, err
+	}
+
+	if !strings.HasPrefix(s, TokenPrefix) {
+		return nil, ErrMalformedToken
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(s, TokenPrefix))
+	if err != nil || len(raw) != bodySize+tagSize {
+		return nil, ErrMalformedToken
+	}
+
+	body, tag := raw[:bodySize], raw[bodySize:]
+	if !h.Verify(body, tag) {
+		return nil, ErrInvalidSignature
+	}
+
+	token := &Token{
+		ID:         hex.EncodeToString(body[:idSize]),
+		ExpiresAt:  time.Unix(int64(binary.BigEndian.Uint64(body[idSize:])), 0),
+		TrafficCap: int64(binary.BigEndian.Uint64(body[idSize+8:])),
+	}
+	if !now.Before(token.ExpiresAt) {
+		return nil, ErrTokenExpired
+	}
+
+	return token, nil
+}
+
+// IsToken reports whether s looks like a guest token.
+func IsToken(s string) bool {
+	return strings.HasPrefix(s, TokenPrefix)
+}
+
+// signer signs token bodies with HMAC-SHA256 truncated to tagSize bytes.
+type signer struct {
+	hmac *crypto.HMAC
+}
+
+func newSigner(secret string) (*signer, error) {
+	if secret == "" {
+		return nil, ErrEmptySecret
+	}
+	h, err := crypto.NewHMAC(crypto.DeriveKeySHA256([]byte(secret), keySalt))
+	if err != nil {
+		return nil, err
+	}
+	return &signer{hmac: h}, nil
+}
+
+func (s *signer) Sign(data []byte) []byte {
+	return s.hmac.Sign(data)[:tagSize]
+}
+
+func (s *signer) Verify(data, tag []byte) bool {
+	return hmac.Equal(s.Sign(data), tag)
+}
@@ -0,0 +1,99 @@
+package guest
+
+import (
+	"testing"
+	"time"
+)
+
+func TestIssueAndParse(t *testing.T) {
+	tokenStr, issued, err := Issue("secret", time.Hour, 1024)
+	if err != nil {
+		t.Fatalf("Issue failed: %v", err)
+	}
+	if !IsToken(tokenStr) {
+		t.Errorf("Expected token with prefix %s, got %s", TokenPrefix, tokenStr)
+	}
+
+	token, err := Parse("secret", tokenStr, time.Now())
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if token.ID != issued.ID {
+		t.Errorf("Expected ID %s, got %s", issued.ID, token.ID)
+	}
+	if !token.ExpiresAt.Equal(issued.ExpiresAt) {
+		t.Errorf("Expected expiry %v, got %v", issued.ExpiresAt, token.ExpiresAt)
+	}
+	if token.TrafficCap != 1024 {
+		t.Errorf("Expected traffic cap 1024, got %d", token.TrafficCap)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tokenStr, _, err := Issue("secret", time.Hour, 0)
+	if err != nil {
+		t.Fatalf("Issue failed: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		secret  string
+		token   string
+		now     time.Time
+		wantErr error
+	}{
+		{
+			name:    "wrong secret",
+			secret:  "other",
+			token:   tokenStr,
+			now:     time.Now(),
+			wantErr: ErrInvalidSignature,
+		},
+		{
+			name:    "expired",
+			secret:  "secret",
+			token:   tokenStr,
+			now:     time.Now().Add(2 * time.Hour),
+			wantErr: ErrTokenExpired,
+		},
+		{
+			name:    "missing prefix",
+			secret:  "secret",
+			token:   "not-a-token",
+			now:     time.Now(),
+			wantErr: ErrMalformedToken,
+		},
+		{
+			name:    "truncated",
+			secret:  "secret",
+			token:   tokenStr[:len(tokenStr)-4],
+			now:     time.Now(),
+			wantErr: ErrMalformedToken,
+		},
+		{
+			name:    "empty secret",
+			secret:  "",
+			token:   tokenStr,
+			now:     time.Now(),
+			wantErr: ErrEmptySecret,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(tt.secret, tt.token, tt.now)
+			if err != tt.wantErr {
+				t.Errorf("Expected %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestIssueInvalidLimits(t *testing.T) {
+	if _, _, err := Issue("secret", 0, 0); err == nil {
+		t.Error("Expected error for zero TTL")
+	}
+	if _, _, err := Issue("secret", time.Hour, -1); err == nil {
+		t.Error("Expected error for negative traffic cap")
+	}
+}
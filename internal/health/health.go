@@ -24,6 +24,12 @@ const (
 // Check is a function that performs a health check.
 type Check func(ctx context.Context) error
 
+// Checker is implemented by components that provide their own readiness
+// checks, keyed by check name.
+type Checker interface {
+	HealthChecks() map[string]Check
+}
+
 // CheckResult represents the result of a health check.
 type CheckResult struct {
 	Name    string        `json:"name"`
@@ -77,6 +83,13 @@ func (h *Handler) RegisterCheck(name string, check Check) {
 	h.checks[name] = check
 }
 
+// RegisterChecker registers all checks provided by c.
+func (h *Handler) RegisterChecker(c Checker) {
+	for name, check := range c.HealthChecks() {
+		h.RegisterCheck(name, check)
+	}
+}
+
 // UnregisterCheck removes a health check.
 func (h *Handler) UnregisterCheck(name string) {
 	h.checksMu.Lock()
@@ -261,6 +274,11 @@ func (s *Server) RegisterCheck(name string, check Check) {
 	s.handler.RegisterCheck(name, check)
 }
 
+// RegisterChecker registers all checks provided by c with the server.
+func (s *Server) RegisterChecker(c Checker) {
+	s.handler.RegisterChecker(c)
+}
+
 // Start starts the health server.
 func (s *Server) Start() error {
 	return s.server.ListenAndServe()
@@ -0,0 +1,55 @@
+package metrics
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+)
+
+// OverflowLabel replaces label values once a LabelLimiter is full.
+const OverflowLabel = "other"
+
+// DefaultMaxDestHosts is the default number of distinct dest_host labels.
+const DefaultMaxDestHosts = 100
+
+// LabelLimiter caps the number of distinct values a metric label can take,
+// so labels derived from traffic (such as destination hosts) cannot grow the
+// number of time series without bound.
+type LabelLimiter struct {
+	max  int
+	hash bool
+	seen map[string]struct{}
+	mu   sync.Mutex
+}
+
+// NewLabelLimiter creates a limiter that admits up to max distinct values.
+// With hash set, values are replaced by a short SHA-256 digest so that
+// hostnames do not appear in the metrics.
+func NewLabelLimiter(max int, hash bool) *LabelLimiter {
+	return &LabelLimiter{
+		max:  max,
+		hash: hash,
+		seen: make(map[string]struct{}),
+	}
+}
+
+// Value returns the label value to use for v. Values seen before keep their
+// label; new values get OverflowLabel once the limit is reached.
+func (l *LabelLimiter) Value(v string) string {
+	if l.hash {
+		sum := sha256.Sum256([]byte(v))
+		v = hex.EncodeToString(sum[:6])
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, ok := l.seen[v]; ok {
+		return v
+	}
+	if len(l.seen) >= l.max {
+		return OverflowLabel
+	}
+	l.seen[v] = struct{}{}
+	return v
+}
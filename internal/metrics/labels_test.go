@@ -0,0 +1,38 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLabelLimiterCap(t *testing.T) {
+	l := NewLabelLimiter(2, false)
+
+	if got := l.Value("a"); got != "a" {
+		t.Errorf("expected a, got %s", got)
+	}
+	if got := l.Value("b"); got != "b" {
+		t.Errorf("expected b, got %s", got)
+	}
+	if got := l.Value("c"); got != OverflowLabel {
+		t.Errorf("expected %s once full, got %s", OverflowLabel, got)
+	}
+	if got := l.Value("a"); got != "a" {
+		t.Errorf("expected known value to keep its label, got %s", got)
+	}
+}
+
+func TestLabelLimiterHash(t *testing.T) {
+	l := NewLabelLimiter(10, true)
+
+	got := l.Value("secret.example.com")
+	if strings.Contains(got, "example") || len(got) != 12 {
+		t.Errorf("expected 12 character hash, got %s", got)
+	}
+	if again := l.Value("secret.example.com"); again != got {
+		t.Errorf("expected stable hash, got %s and %s", got, again)
+	}
+	if other := l.Value("other.example.com"); other == got {
+		t.Error("expected different hosts to hash differently")
+	}
+}
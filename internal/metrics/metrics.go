@@ -4,6 +4,7 @@ package metrics
 import (
 	"context"
 	"net/http"
+	"sync/atomic"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
@@ -20,14 +21,33 @@ type Collector struct {
 	PacketsReceived *prometheus.CounterVec
 	BytesSent       *prometheus.CounterVec
 	BytesReceived   *prometheus.CounterVec
+	// PacketsCorrupted counts received packets whose checksum did not match
+	PacketsCorrupted *prometheus.CounterVec
+	// PacketsReplayed counts authenticated packets rejected as replays
+	PacketsReplayed prometheus.Counter
 
 	// Session metrics
-	ActiveSessions prometheus.Gauge
-	TotalSessions  prometheus.Counter
+	ActiveSessions    prometheus.Gauge
+	TotalSessions     prometheus.Counter
+	SessionSaturation prometheus.Gauge
+	SessionsRejected  prometheus.Counter
+	SessionsEvicted   prometheus.Counter
+	SessionsClosed    *prometheus.CounterVec
+	// DownstreamWriteFailures counts failed writes to session downstream
+	// connections
+	DownstreamWriteFailures prometheus.Counter
+	// DownstreamFailingSessions is the number of sessions whose downstream
+	// writes are failing
+	DownstreamFailingSessions prometheus.Gauge
 
 	// Stream metrics
 	ActiveStreams prometheus.Gauge
 	TotalStreams  prometheus.Counter
+	StreamsClosed *prometheus.CounterVec
+	// StreamLifetime and StreamSize describe closed streams, telling many
+	// short connections from few long ones
+	StreamLifetime prometheus.Histogram
+	StreamSize     *prometheus.HistogramVec
 
 	// Latency metrics
 	StreamLatency  *prometheus.HistogramVec
@@ -36,6 +56,14 @@ type Collector struct {
 	// Connection status
 	ConnectionStatus *prometheus.GaugeVec
 
+	// Whether each tunnel leg is up, and when the session handshake last
+	// succeeded, for alerting on tunnel outages
+	TunnelUp      *prometheus.GaugeVec
+	LastHandshake prometheus.Gauge
+
+	// Smoothed round-trip time of each tunnel path, from keepalive probes
+	PathRTT *prometheus.GaugeVec
+
 	// Error metrics
 	Errors *prometheus.CounterVec
 
@@ -47,6 +75,59 @@ type Collector struct {
 	ReconnectAttempts *prometheus.CounterVec
 	ReconnectSuccess  *prometheus.CounterVec
 	ReconnectFailure  *prometheus.CounterVec
+
+	// Client graceful degradation while reconnecting
+	DegradationMode        prometheus.Gauge
+	DegradationTransitions *prometheus.CounterVec
+	DegradationDropped     *prometheus.CounterVec
+
+	// Destination dial metrics
+	DialDuration *prometheus.HistogramVec
+
+	// Destination name resolution metrics
+	DNSResolveDuration *prometheus.HistogramVec
+	DNSCacheHits       prometheus.Counter
+
+	// Client certificate (mTLS) metrics
+	ClientCertConnections *prometheus.CounterVec
+
+	// Per-client sessions and traffic of identified clients
+	ClientSessions *prometheus.CounterVec
+	ClientBytes    *prometheus.CounterVec
+
+	// Per-destination stream traffic
+	StreamBytes *prometheus.CounterVec
+
+	// Local listener connections refused by allow_from
+	ListenerRejections *prometheus.CounterVec
+
+	// Connection attempt limits and bans of tunnel sources
+	UpgradesRejected  *prometheus.CounterVec
+	HandshakeFailures *prometheus.CounterVec
+	SourcesBanned     *prometheus.CounterVec
+
+	// Tunnel connections the server dropped because its accept queue was
+	// full, their source had too many connections open or it was closing
+	ConnectionsDropped *prometheus.CounterVec
+
+	// Server resource usage as a fraction of its resource guard ceiling,
+	// and new streams refused near a ceiling
+	ResourceSaturation *prometheus.GaugeVec
+	StreamsShed        *prometheus.CounterVec
+
+	// Messages waiting in tunnel connection send queues, and messages
+	// written per batch by the queues' writers
+	SendQueueDepth *prometheus.GaugeVec
+	SendBatchSize  *prometheus.HistogramVec
+
+	// Client routing decisions per rule
+	RoutingHits *prometheus.CounterVec
+	// DestHosts bounds the dest_host label of StreamBytes
+	DestHosts *LabelLimiter
+
+	// Session counts behind SessionSaturation, updated atomically
+	activeSessions int64
+	maxSessions    int64
 }
 
 // NewCollector creates a new metrics collector with all metrics registered.
@@ -84,6 +165,21 @@ func NewCollector() *Collector {
 			},
 			[]string{"direction"},
 		),
+		PacketsCorrupted: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: Namespace,
+				Name:      "packets_corrupted_total",
+				Help:      "Total number of received packets dropped for a checksum mismatch, by path",
+			},
+			[]string{"path"},
+		),
+		PacketsReplayed: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Namespace: Namespace,
+				Name:      "packets_replayed_total",
+				Help:      "Total number of authenticated packets rejected as replays",
+			},
+		),
 		ActiveSessions: prometheus.NewGauge(
 			prometheus.GaugeOpts{
 				Namespace: Namespace,
@@ -98,6 +194,49 @@ func NewCollector() *Collector {
 				Help:      "Total number of sessions created",
 			},
 		),
+		SessionSaturation: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Namespace: Namespace,
+				Name:      "session_saturation",
+				Help:      "Active sessions as a fraction of the session limit (0 when unlimited)",
+			},
+		),
+		SessionsRejected: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Namespace: Namespace,
+				Name:      "sessions_rejected_total",
+				Help:      "Total number of new sessions rejected at the session limit",
+			},
+		),
+		SessionsEvicted: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Namespace: Namespace,
+				Name:      "sessions_evicted_total",
+				Help:      "Total number of sessions evicted to admit new ones",
+			},
+		),
+		SessionsClosed: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: Namespace,
+				Name:      "sessions_closed_total",
+				Help:      "Total number of sessions closed by the server, by reason",
+			},
+			[]string{"reason"},
+		),
+		DownstreamWriteFailures: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Namespace: Namespace,
+				Name:      "downstream_write_failures_total",
+				Help:      "Total number of failed writes to session downstream connections",
+			},
+		),
+		DownstreamFailingSessions: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Namespace: Namespace,
+				Name:      "downstream_failing_sessions",
+				Help:      "Number of sessions whose downstream writes are failing",
+			},
+		),
 		ActiveStreams: prometheus.NewGauge(
 			prometheus.GaugeOpts{
 				Namespace: Namespace,
@@ -112,6 +251,31 @@ func NewCollector() *Collector {
 				Help:      "Total number of streams created",
 			},
 		),
+		StreamsClosed: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: Namespace,
+				Name:      "streams_closed_total",
+				Help:      "Total number of streams closed, by the side that closed them and reason",
+			},
+			[]string{"closed_by", "reason"},
+		),
+		StreamLifetime: prometheus.NewHistogram(
+			prometheus.HistogramOpts{
+				Namespace: Namespace,
+				Name:      "stream_lifetime_seconds",
+				Help:      "Time from opening to closing a stream in seconds",
+				Buckets:   prometheus.ExponentialBuckets(0.01, 4, 12), // 10ms to ~12h
+			},
+		),
+		StreamSize: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Namespace: Namespace,
+				Name:      "stream_size_bytes",
+				Help:      "Bytes carried by a stream over its lifetime, by direction",
+				Buckets:   prometheus.ExponentialBuckets(64, 4, 12), // 64B to 256MiB
+			},
+			[]string{"direction"},
+		),
 		StreamLatency: prometheus.NewHistogramVec(
 			prometheus.HistogramOpts{
 				Namespace: Namespace,
@@ -138,6 +302,29 @@ func NewCollector() *Collector {
 			},
 			[]string{"connection"}, // "upstream", "downstream"
 		),
+		TunnelUp: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: Namespace,
+				Name:      "tunnel_up",
+				Help:      "Whether the tunnel leg is connected and carries the session (1 = up, 0 = down)",
+			},
+			[]string{"leg"}, // "upstream", "downstream"
+		),
+		LastHandshake: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Namespace: Namespace,
+				Name:      "last_successful_handshake_timestamp",
+				Help:      "Unix time of the last successful session handshake with the server",
+			},
+		),
+		PathRTT: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: Namespace,
+				Name:      "path_rtt_seconds",
+				Help:      "Smoothed round-trip time of each tunnel path in seconds",
+			},
+			[]string{"path"}, // "upstream", "downstream"
+		),
 		Errors: prometheus.NewCounterVec(
 			prometheus.CounterOpts{
 				Namespace: Namespace,
@@ -186,6 +373,168 @@ func NewCollector() *Collector {
 			},
 			[]string{"connection"},
 		),
+		DegradationMode: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Namespace: Namespace,
+				Name:      "degradation_mode",
+				Help:      "Client degradation mode (0 = normal, 1 = degraded, 2 = recovering, 3 = failed)",
+			},
+		),
+		DegradationTransitions: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: Namespace,
+				Name:      "degradation_transitions_total",
+				Help:      "Total number of client degradation mode changes, by the mode entered",
+			},
+			[]string{"mode"}, // "normal", "degraded", "recovering", "failed"
+		),
+		DegradationDropped: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: Namespace,
+				Name:      "degradation_packets_dropped_total",
+				Help:      "Total number of packets queued during a reconnect that were dropped",
+			},
+			[]string{"reason"}, // "queue_full", "timeout" or "recovery_timeout"
+		),
+		DialDuration: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Namespace: Namespace,
+				Name:      "dial_duration_seconds",
+				Help:      "Duration of destination dials in seconds",
+				Buckets:   prometheus.ExponentialBuckets(0.001, 2, 15), // 1ms to ~16s
+			},
+			[]string{"port", "result"}, // port: "80", "443", "other"; result: "success", "error"
+		),
+		DNSResolveDuration: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Namespace: Namespace,
+				Name:      "dns_resolve_duration_seconds",
+				Help:      "Duration of destination name lookups in seconds, excluding cache hits",
+				Buckets:   prometheus.ExponentialBuckets(0.001, 2, 14), // 1ms to ~8s
+			},
+			[]string{"result"}, // "success", "not_found", "error"
+		),
+		DNSCacheHits: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Namespace: Namespace,
+				Name:      "dns_cache_hits_total",
+				Help:      "Total number of destination name lookups answered from the cache",
+			},
+		),
+		ClientCertConnections: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: Namespace,
+				Name:      "client_cert_connections_total",
+				Help:      "Total number of connections authenticated with a client certificate",
+			},
+			[]string{"direction", "cn"},
+		),
+		ClientSessions: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: Namespace,
+				Name:      "client_sessions_total",
+				Help:      "Total number of sessions opened by identified clients",
+			},
+			[]string{"client"},
+		),
+		ClientBytes: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: Namespace,
+				Name:      "client_bytes_total",
+				Help:      "Total bytes carried for identified clients",
+			},
+			[]string{"client", "direction"}, // "upstream", "downstream"
+		),
+		StreamBytes: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: Namespace,
+				Name:      "stream_bytes_total",
+				Help:      "Total bytes carried by streams, by destination host and port forward",
+			},
+			[]string{"dest_host", "forward_name"},
+		),
+		ListenerRejections: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: Namespace,
+				Name:      "listener_connections_rejected_total",
+				Help:      "Total number of local connections rejected by a listener's allow_from list",
+			},
+			[]string{"listener"}, // port forward name or "socks5"
+		),
+		UpgradesRejected: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: Namespace,
+				Name:      "upgrades_rejected_total",
+				Help:      "Total number of WebSocket upgrade attempts refused by connection limits",
+			},
+			[]string{"reason"}, // "rate_limit" or "banned"
+		),
+		ConnectionsDropped: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: Namespace,
+				Name:      "connections_dropped_total",
+				Help:      "Total number of tunnel connections dropped by a full accept queue, the per-source cap or shutdown",
+			},
+			[]string{"direction", "reason"}, // "queue_full", "source_limit" or "closing"
+		),
+		HandshakeFailures: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: Namespace,
+				Name:      "handshake_failures_total",
+				Help:      "Total number of failed tunnel handshakes",
+			},
+			[]string{"reason"}, // "path_token", "upgrade_cookie" or "session_rejected"
+		),
+		SourcesBanned: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: Namespace,
+				Name:      "sources_banned_total",
+				Help:      "Total number of source IPs banned by connection limits",
+			},
+			[]string{"reason"}, // "rate_limit" or "handshake_failures"
+		),
+		ResourceSaturation: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: Namespace,
+				Name:      "resource_saturation",
+				Help:      "Server resource usage as a fraction of its resource guard ceiling",
+			},
+			[]string{"resource"}, // "open_files", "nat_entries" or "goroutines"
+		),
+		StreamsShed: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: Namespace,
+				Name:      "streams_shed_total",
+				Help:      "Total number of new streams refused because a resource was near its ceiling",
+			},
+			[]string{"resource"},
+		),
+		SendQueueDepth: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Namespace: Namespace,
+				Name:      "send_queue_depth",
+				Help:      "Messages waiting in tunnel connection send queues",
+			},
+			[]string{"connection"}, // "upstream" or "downstream"
+		),
+		SendBatchSize: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Namespace: Namespace,
+				Name:      "send_batch_size",
+				Help:      "Messages written back to back per tunnel connection send queue batch",
+				Buckets:   prometheus.ExponentialBuckets(1, 2, 9),
+			},
+			[]string{"connection"},
+		),
+		RoutingHits: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Namespace: Namespace,
+				Name:      "routing_rule_hits_total",
+				Help:      "Total number of client connections routed by each routing rule",
+			},
+			[]string{"rule", "action"}, // rule: rule name or "default"; action: "tunnel", "direct", "block"
+		),
+		DestHosts: NewLabelLimiter(DefaultMaxDestHosts, false),
 	}
 
 	return c
@@ -200,17 +549,51 @@ func (c *Collector) Register(reg prometheus.Registerer) error {
 		c.BytesReceived,
 		c.ActiveSessions,
 		c.TotalSessions,
+		c.SessionSaturation,
+		c.SessionsRejected,
+		c.SessionsEvicted,
+		c.SessionsClosed,
+		c.DownstreamWriteFailures,
+		c.DownstreamFailingSessions,
 		c.ActiveStreams,
 		c.TotalStreams,
+		c.StreamsClosed,
+		c.StreamLifetime,
+		c.StreamSize,
+		c.PacketsCorrupted,
+		c.PacketsReplayed,
 		c.StreamLatency,
 		c.PacketLatency,
 		c.ConnectionStatus,
+		c.TunnelUp,
+		c.LastHandshake,
+		c.PathRTT,
 		c.Errors,
 		c.CircuitBreakerState,
 		c.CircuitBreakerTrips,
 		c.ReconnectAttempts,
 		c.ReconnectSuccess,
 		c.ReconnectFailure,
+		c.DegradationMode,
+		c.DegradationTransitions,
+		c.DegradationDropped,
+		c.DialDuration,
+		c.DNSResolveDuration,
+		c.DNSCacheHits,
+		c.ClientCertConnections,
+		c.ClientSessions,
+		c.ClientBytes,
+		c.StreamBytes,
+		c.ListenerRejections,
+		c.UpgradesRejected,
+		c.ConnectionsDropped,
+		c.HandshakeFailures,
+		c.SourcesBanned,
+		c.ResourceSaturation,
+		c.StreamsShed,
+		c.SendQueueDepth,
+		c.SendBatchSize,
+		c.RoutingHits,
 	}
 
 	for _, collector := range collectors {
@@ -245,11 +628,56 @@ func (c *Collector) RecordPacketReceived(direction string, bytes int) {
 func (c *Collector) RecordSessionCreated() {
 	c.ActiveSessions.Inc()
 	c.TotalSessions.Inc()
+	c.updateSaturation(atomic.AddInt64(&c.activeSessions, 1))
 }
 
 // RecordSessionClosed records a session closure.
 func (c *Collector) RecordSessionClosed() {
 	c.ActiveSessions.Dec()
+	c.updateSaturation(atomic.AddInt64(&c.activeSessions, -1))
+}
+
+// SetMaxSessions sets the session limit SessionSaturation is relative to
+// (0 means unlimited).
+func (c *Collector) SetMaxSessions(max int) {
+	atomic.StoreInt64(&c.maxSessions, int64(max))
+	c.updateSaturation(atomic.LoadInt64(&c.activeSessions))
+}
+
+// RecordSessionRejected records a session rejected at the session limit.
+func (c *Collector) RecordSessionRejected() {
+	c.SessionsRejected.Inc()
+}
+
+// RecordSessionEvicted records a session evicted to admit a new one.
+func (c *Collector) RecordSessionEvicted() {
+	c.SessionsEvicted.Inc()
+}
+
+// RecordDownstreamWriteFailure records a failed write to a session's
+// downstream connection.
+func (c *Collector) RecordDownstreamWriteFailure() {
+	c.DownstreamWriteFailures.Inc()
+}
+
+// SetDownstreamFailingSessions sets the number of sessions whose downstream
+// writes are failing.
+func (c *Collector) SetDownstreamFailingSessions(n int) {
+	c.DownstreamFailingSessions.Set(float64(n))
+}
+
+// RecordSessionCloseReason records why the server closed a session.
+func (c *Collector) RecordSessionCloseReason(reason string) {
+	c.SessionsClosed.WithLabelValues(reason).Inc()
+}
+
+func (c *Collector) updateSaturation(active int64) {
+	max := atomic.LoadInt64(&c.maxSessions)
+	if max <= 0 {
+		c.SessionSaturation.Set(0)
+		return
+	}
+	c.SessionSaturation.Set(float64(active) / float64(max))
 }
 
 // RecordStreamCreated records a new stream creation.
@@ -263,6 +691,33 @@ func (c *Collector) RecordStreamClosed() {
 	c.ActiveStreams.Dec()
 }
 
+// RecordStreamLifetime records how long a closed stream was open and the
+// bytes it carried upstream (client to destination) and downstream.
+func (c *Collector) RecordStreamLifetime(lifetime time.Duration, bytesUp, bytesDown int64) {
+	c.StreamLifetime.Observe(lifetime.Seconds())
+	c.StreamSize.WithLabelValues("upstream").Observe(float64(bytesUp))
+	c.StreamSize.WithLabelValues("downstream").Observe(float64(bytesDown))
+}
+
+// RecordStreamCloseReason records why a stream was closed. closedBy is
+// "local" when this side closed it and "peer" when the other side's FIN did.
+func (c *Collector) RecordStreamCloseReason(closedBy, reason string) {
+	c.StreamsClosed.WithLabelValues(closedBy, reason).Inc()
+}
+
+// RecordPacketCorrupted records a received packet dropped because its
+// checksum did not match.
+func (c *Collector) RecordPacketCorrupted(path string) {
+	c.PacketsCorrupted.WithLabelValues(path).Inc()
+}
+
+// RecordPacketReplayed records an authenticated packet rejected because its
+// replay counter was already seen or is too old; it fits
+// PacketCrypto.SetOnReplay.
+func (c *Collector) RecordPacketReplayed() {
+	c.PacketsReplayed.Inc()
+}
+
 // RecordStreamLatency records stream operation latency.
 func (c *Collector) RecordStreamLatency(operation string, duration time.Duration) {
 	c.StreamLatency.WithLabelValues(operation).Observe(duration.Seconds())
@@ -282,6 +737,25 @@ func (c *Collector) SetConnectionStatus(connection string, connected bool) {
 	c.ConnectionStatus.WithLabelValues(connection).Set(value)
 }
 
+// SetTunnelUp sets whether a tunnel leg is up.
+func (c *Collector) SetTunnelUp(leg string, up bool) {
+	value := 0.0
+	if up {
+		value = 1.0
+	}
+	c.TunnelUp.WithLabelValues(leg).Set(value)
+}
+
+// RecordHandshake records a successful session handshake.
+func (c *Collector) RecordHandshake() {
+	c.LastHandshake.SetToCurrentTime()
+}
+
+// SetPathRTT records the smoothed round-trip time of a tunnel path.
+func (c *Collector) SetPathRTT(path string, rtt time.Duration) {
+	c.PathRTT.WithLabelValues(path).Set(rtt.Seconds())
+}
+
 // RecordError records an error.
 func (c *Collector) RecordError(errorType string) {
 	c.Errors.WithLabelValues(errorType).Inc()
@@ -298,6 +772,119 @@ func (c *Collector) RecordCircuitBreakerTrip(name string) {
 	c.CircuitBreakerTrips.WithLabelValues(name).Inc()
 }
 
+// RecordDialDuration records how long a destination dial took.
+func (c *Collector) RecordDialDuration(port string, success bool, duration time.Duration) {
+	result := "success"
+	if !success {
+		result = "error"
+	}
+	c.DialDuration.WithLabelValues(port, result).Observe(duration.Seconds())
+}
+
+// RecordDNSResolve records a destination name lookup. result is "success",
+// "not_found" or "error".
+func (c *Collector) RecordDNSResolve(result string, duration time.Duration) {
+	c.DNSResolveDuration.WithLabelValues(result).Observe(duration.Seconds())
+}
+
+// RecordDNSCacheHit records a lookup answered from the resolver cache.
+func (c *Collector) RecordDNSCacheHit() {
+	c.DNSCacheHits.Inc()
+}
+
+// RecordClientCertConnection records a connection authenticated with a client certificate.
+func (c *Collector) RecordClientCertConnection(direction, cn string) {
+	c.ClientCertConnections.WithLabelValues(direction, cn).Inc()
+}
+
+// RecordClientSession records a session opened by an identified client.
+func (c *Collector) RecordClientSession(client string) {
+	c.ClientSessions.WithLabelValues(client).Inc()
+}
+
+// RecordClientBytes records bytes carried for an identified client.
+func (c *Collector) RecordClientBytes(client, direction string, bytes int) {
+	c.ClientBytes.WithLabelValues(client, direction).Add(float64(bytes))
+}
+
+// RecordStreamBytes records traffic carried by a stream. The destination host
+// label is bounded by DestHosts.
+func (c *Collector) RecordStreamBytes(destHost, forwardName string, bytes int) {
+	c.StreamBytes.WithLabelValues(c.DestHosts.Value(destHost), forwardName).Add(float64(bytes))
+}
+
+// RecordListenerRejected records a local connection refused because its
+// source address is not in the listener's allow_from list.
+func (c *Collector) RecordListenerRejected(listener string) {
+	c.ListenerRejections.WithLabelValues(listener).Inc()
+}
+
+// RecordUpgradeRejected records a WebSocket upgrade attempt refused because
+// its source was rate limited or is banned.
+func (c *Collector) RecordUpgradeRejected(reason string) {
+	c.UpgradesRejected.WithLabelValues(reason).Inc()
+}
+
+// RecordConnectionDropped records a tunnel connection the direction's
+// handler dropped.
+func (c *Collector) RecordConnectionDropped(direction, reason string) {
+	c.ConnectionsDropped.WithLabelValues(direction, reason).Inc()
+}
+
+// RecordHandshakeFailure records a failed tunnel handshake.
+func (c *Collector) RecordHandshakeFailure(reason string) {
+	c.HandshakeFailures.WithLabelValues(reason).Inc()
+}
+
+// RecordSourceBanned records a source IP banned by connection limits.
+func (c *Collector) RecordSourceBanned(reason string) {
+	c.SourcesBanned.WithLabelValues(reason).Inc()
+}
+
+// SetResourceSaturation sets how close a resource is to its resource guard
+// ceiling, as a fraction.
+func (c *Collector) SetResourceSaturation(resource string, saturation float64) {
+	c.ResourceSaturation.WithLabelValues(resource).Set(saturation)
+}
+
+// RecordStreamShed records a new stream refused because resource was near
+// its ceiling.
+func (c *Collector) RecordStreamShed(resource string) {
+	c.StreamsShed.WithLabelValues(resource).Inc()
+}
+
+// SendQueueObserver exports the send queue activity of a tunnel connection
+// direction.
+type SendQueueObserver struct {
+	depth prometheus.Gauge
+	batch prometheus.Observer
+}
+
+// SendQueueObserver returns an observer for the send queues of connection
+// ("upstream" or "downstream").
+func (c *Collector) SendQueueObserver(connection string) *SendQueueObserver {
+	return &SendQueueObserver{
+		depth: c.SendQueueDepth.WithLabelValues(connection),
+		batch: c.SendBatchSize.WithLabelValues(connection),
+	}
+}
+
+// QueueChanged records messages added to or taken from a send queue.
+func (o *SendQueueObserver) QueueChanged(delta int) {
+	o.depth.Add(float64(delta))
+}
+
+// BatchWritten records the number of messages written in one batch.
+func (o *SendQueueObserver) BatchWritten(messages int) {
+	o.batch.Observe(float64(messages))
+}
+
+// RecordRoutingHit records a connection routed by rule ("default" when no
+// rule matched).
+func (c *Collector) RecordRoutingHit(rule, action string) {
+	c.RoutingHits.WithLabelValues(rule, action).Inc()
+}
+
 // RecordReconnectAttempt records a reconnection attempt.
 func (c *Collector) RecordReconnectAttempt(connection string) {
 	c.ReconnectAttempts.WithLabelValues(connection).Inc()
@@ -313,6 +900,19 @@ func (c *Collector) RecordReconnectFailure(connection string) {
 	c.ReconnectFailure.WithLabelValues(connection).Inc()
 }
 
+// SetDegradationMode records a change of the client's degradation mode.
+// mode: 0 = normal, 1 = degraded, 2 = recovering, 3 = failed
+func (c *Collector) SetDegradationMode(mode int, name string) {
+	c.DegradationMode.Set(float64(mode))
+	c.DegradationTransitions.WithLabelValues(name).Inc()
+}
+
+// RecordDegradationDrop records a packet queued during a reconnect that was
+// dropped before it could be sent.
+func (c *Collector) RecordDegradationDrop(reason string) {
+	c.DegradationDropped.WithLabelValues(reason).Inc()
+}
+
 // Server is an HTTP server that exposes Prometheus metrics.
 type Server struct {
 	server    *http.Server
@@ -325,6 +925,10 @@ type Server struct {
 type ServerConfig struct {
 	Addr string
 	Path string
+	// MaxDestHosts caps distinct dest_host label values (0 uses DefaultMaxDestHosts)
+	MaxDestHosts int
+	// HashDestHosts replaces dest_host values with a short hash
+	HashDestHosts bool
 }
 
 // DefaultServerConfig returns a ServerConfig with sensible defaults.
@@ -343,6 +947,11 @@ func NewServer(config *ServerConfig) *Server {
 
 	registry := prometheus.NewRegistry()
 	collector := NewCollector()
+	maxDestHosts := config.MaxDestHosts
+	if maxDestHosts <= 0 {
+		maxDestHosts = DefaultMaxDestHosts
+	}
+	collector.DestHosts = NewLabelLimiter(maxDestHosts, config.HashDestHosts)
 	collector.MustRegister(registry)
 
 	// Also register default Go collectors
@@ -109,6 +109,36 @@ func TestCollector_SessionMetrics(t *testing.T) {
 	}
 }
 
+func TestCollector_SessionSaturation(t *testing.T) {
+	c := NewCollector()
+	c.SetMaxSessions(4)
+
+	c.RecordSessionCreated()
+	if got := testutil.ToFloat64(c.SessionSaturation); got != 0.25 {
+		t.Errorf("expected saturation 0.25, got %v", got)
+	}
+	c.RecordSessionCreated()
+	c.RecordSessionClosed()
+	c.RecordSessionCreated()
+	if got := testutil.ToFloat64(c.SessionSaturation); got != 0.5 {
+		t.Errorf("expected saturation 0.5, got %v", got)
+	}
+
+	c.SetMaxSessions(0)
+	if got := testutil.ToFloat64(c.SessionSaturation); got != 0 {
+		t.Errorf("expected saturation 0 without a limit, got %v", got)
+	}
+
+	c.RecordSessionRejected()
+	c.RecordSessionEvicted()
+	if got := testutil.ToFloat64(c.SessionsRejected); got != 1 {
+		t.Errorf("expected 1 rejected session, got %v", got)
+	}
+	if got := testutil.ToFloat64(c.SessionsEvicted); got != 1 {
+		t.Errorf("expected 1 evicted session, got %v", got)
+	}
+}
+
 func TestCollector_StreamMetrics(t *testing.T) {
 	c := NewCollector()
 	registry := prometheus.NewRegistry()
@@ -158,6 +188,173 @@ func TestCollector_RecordPacketLatency(t *testing.T) {
 	}
 }
 
+func TestCollector_RecordDialDuration(t *testing.T) {
+	c := NewCollector()
+	registry := prometheus.NewRegistry()
+	c.MustRegister(registry)
+
+	c.RecordDialDuration("443", true, 50*time.Millisecond)
+	c.RecordDialDuration("443", false, 10*time.Second)
+	c.RecordDialDuration("other", true, 20*time.Millisecond)
+
+	count := testutil.CollectAndCount(c.DialDuration)
+	if count != 3 {
+		t.Errorf("expected 3 histogram metrics, got %d", count)
+	}
+}
+
+func TestCollector_DNSMetrics(t *testing.T) {
+	c := NewCollector()
+	registry := prometheus.NewRegistry()
+	c.MustRegister(registry)
+
+	c.RecordDNSResolve("success", 5*time.Millisecond)
+	c.RecordDNSResolve("not_found", 20*time.Millisecond)
+	c.RecordDNSCacheHit()
+	c.RecordDNSCacheHit()
+
+	if count := testutil.CollectAndCount(c.DNSResolveDuration); count != 2 {
+		t.Errorf("expected 2 histogram metrics, got %d", count)
+	}
+	if hits := testutil.ToFloat64(c.DNSCacheHits); hits != 2 {
+		t.Errorf("expected 2 cache hits, got %v", hits)
+	}
+}
+
+func TestCollector_RecordStreamBytes(t *testing.T) {
+	c := NewCollector()
+	c.DestHosts = NewLabelLimiter(2, false)
+	registry := prometheus.NewRegistry()
+	c.MustRegister(registry)
+
+	c.RecordStreamBytes("example.com", "socks5", 100)
+	c.RecordStreamBytes("example.com", "socks5", 50)
+	c.RecordStreamBytes("db.internal", "db", 10)
+	c.RecordStreamBytes("third.example", "socks5", 5)
+
+	if got := testutil.ToFloat64(c.StreamBytes.WithLabelValues("example.com", "socks5")); got != 150 {
+		t.Errorf("expected 150 bytes for example.com, got %v", got)
+	}
+	if got := testutil.ToFloat64(c.StreamBytes.WithLabelValues(OverflowLabel, "socks5")); got != 5 {
+		t.Errorf("expected 5 overflow bytes, got %v", got)
+	}
+	if count := testutil.CollectAndCount(c.StreamBytes); count != 3 {
+		t.Errorf("expected 3 series, got %d", count)
+	}
+}
+
+func TestCollector_RecordStreamCloseReason(t *testing.T) {
+	c := NewCollector()
+	registry := prometheus.NewRegistry()
+	c.MustRegister(registry)
+
+	c.RecordStreamCloseReason("local", "eof")
+	c.RecordStreamCloseReason("local", "eof")
+	c.RecordStreamCloseReason("peer", "dial_failed")
+
+	if got := testutil.ToFloat64(c.StreamsClosed.WithLabelValues("local", "eof")); got != 2 {
+		t.Errorf("expected 2 local eof closes, got %v", got)
+	}
+	if count := testutil.CollectAndCount(c.StreamsClosed); count != 2 {
+		t.Errorf("expected 2 series, got %d", count)
+	}
+}
+
+func TestCollector_RecordListenerRejected(t *testing.T) {
+	c := NewCollector()
+	registry := prometheus.NewRegistry()
+	c.MustRegister(registry)
+
+	c.RecordListenerRejected("socks5")
+	c.RecordListenerRejected("socks5")
+	c.RecordListenerRejected("db")
+
+	if got := testutil.ToFloat64(c.ListenerRejections.WithLabelValues("socks5")); got != 2 {
+		t.Errorf("expected 2 socks5 rejections, got %v", got)
+	}
+	if count := testutil.CollectAndCount(c.ListenerRejections); count != 2 {
+		t.Errorf("expected 2 series, got %d", count)
+	}
+}
+
+func TestCollector_RecordConnectionLimits(t *testing.T) {
+	c := NewCollector()
+	registry := prometheus.NewRegistry()
+	c.MustRegister(registry)
+
+	c.RecordHandshakeFailure("path_token")
+	c.RecordHandshakeFailure("path_token")
+	c.RecordSourceBanned("handshake_failures")
+	c.RecordUpgradeRejected("banned")
+
+	if got := testutil.ToFloat64(c.HandshakeFailures.WithLabelValues("path_token")); got != 2 {
+		t.Errorf("expected 2 path token failures, got %v", got)
+	}
+	if got := testutil.ToFloat64(c.SourcesBanned.WithLabelValues("handshake_failures")); got != 1 {
+		t.Errorf("expected 1 ban, got %v", got)
+	}
+	if got := testutil.ToFloat64(c.UpgradesRejected.WithLabelValues("banned")); got != 1 {
+		t.Errorf("expected 1 rejected upgrade, got %v", got)
+	}
+}
+
+func TestCollector_RecordDegradation(t *testing.T) {
+	c := NewCollector()
+	registry := prometheus.NewRegistry()
+	c.MustRegister(registry)
+
+	c.SetDegradationMode(1, "degraded")
+	c.SetDegradationMode(2, "recovering")
+	c.SetDegradationMode(0, "normal")
+	c.SetDegradationMode(1, "degraded")
+	c.RecordDegradationDrop("queue_full")
+
+	if got := testutil.ToFloat64(c.DegradationMode); got != 1 {
+		t.Errorf("expected mode 1, got %v", got)
+	}
+	if got := testutil.ToFloat64(c.DegradationTransitions.WithLabelValues("degraded")); got != 2 {
+		t.Errorf("expected 2 transitions to degraded, got %v", got)
+	}
+	if got := testutil.ToFloat64(c.DegradationDropped.WithLabelValues("queue_full")); got != 1 {
+		t.Errorf("expected 1 dropped packet, got %v", got)
+	}
+}
+
+func TestCollector_RecordRoutingHit(t *testing.T) {
+	c := NewCollector()
+	registry := prometheus.NewRegistry()
+	c.MustRegister(registry)
+
+	c.RecordRoutingHit("domestic", "direct")
+	c.RecordRoutingHit("domestic", "direct")
+	c.RecordRoutingHit("default", "tunnel")
+
+	if got := testutil.ToFloat64(c.RoutingHits.WithLabelValues("domestic", "direct")); got != 2 {
+		t.Errorf("expected 2 hits for domestic, got %v", got)
+	}
+	if count := testutil.CollectAndCount(c.RoutingHits); count != 2 {
+		t.Errorf("expected 2 series, got %d", count)
+	}
+}
+
+func TestCollector_RecordClient(t *testing.T) {
+	c := NewCollector()
+	registry := prometheus.NewRegistry()
+	c.MustRegister(registry)
+
+	c.RecordClientSession("laptop")
+	c.RecordClientBytes("laptop", "upstream", 100)
+	c.RecordClientBytes("laptop", "upstream", 50)
+	c.RecordClientBytes("laptop", "downstream", 10)
+
+	if got := testutil.ToFloat64(c.ClientSessions.WithLabelValues("laptop")); got != 1 {
+		t.Errorf("expected 1 session, got %v", got)
+	}
+	if got := testutil.ToFloat64(c.ClientBytes.WithLabelValues("laptop", "upstream")); got != 150 {
+		t.Errorf("expected 150 upstream bytes, got %v", got)
+	}
+}
+
 func TestCollector_SetConnectionStatus(t *testing.T) {
 	c := NewCollector()
 	registry := prometheus.NewRegistry()
@@ -177,6 +374,41 @@ halftunnel_connection_status{connection="upstream"} 1
 	}
 }
 
+func TestCollector_TunnelUp(t *testing.T) {
+	c := NewCollector()
+	registry := prometheus.NewRegistry()
+	c.MustRegister(registry)
+
+	c.SetTunnelUp("upstream", true)
+	c.SetTunnelUp("downstream", true)
+	c.SetTunnelUp("downstream", false)
+	if got := testutil.ToFloat64(c.TunnelUp.WithLabelValues("upstream")); got != 1 {
+		t.Errorf("Expected upstream up, got %v", got)
+	}
+	if got := testutil.ToFloat64(c.TunnelUp.WithLabelValues("downstream")); got != 0 {
+		t.Errorf("Expected downstream down, got %v", got)
+	}
+
+	before := time.Now().Unix()
+	c.RecordHandshake()
+	if got := int64(testutil.ToFloat64(c.LastHandshake)); got < before || got > time.Now().Unix() {
+		t.Errorf("Expected a current handshake timestamp, got %d", got)
+	}
+}
+
+func TestCollector_SetPathRTT(t *testing.T) {
+	c := NewCollector()
+	registry := prometheus.NewRegistry()
+	c.MustRegister(registry)
+
+	c.SetPathRTT("upstream", 120*time.Millisecond)
+	c.SetPathRTT("upstream", 80*time.Millisecond)
+
+	if got := testutil.ToFloat64(c.PathRTT.WithLabelValues("upstream")); got != 0.08 {
+		t.Errorf("Expected the latest RTT 0.08s, got %v", got)
+	}
+}
+
 func TestCollector_RecordError(t *testing.T) {
 	c := NewCollector()
 	registry := prometheus.NewRegistry()
@@ -269,3 +501,120 @@ func TestServer(t *testing.T) {
 		t.Errorf("expected :9099, got %s", s.Addr())
 	}
 }
+
+func TestCollector_RecordPacketCorrupted(t *testing.T) {
+	c := NewCollector()
+	registry := prometheus.NewRegistry()
+	c.MustRegister(registry)
+
+	c.RecordPacketCorrupted("upstream")
+	c.RecordPacketCorrupted("upstream")
+	c.RecordPacketCorrupted("downstream")
+
+	expected := `
+# HELP halftunnel_packets_corrupted_total Total number of received packets dropped for a checksum mismatch, by path
+# TYPE halftunnel_packets_corrupted_total counter
+halftunnel_packets_corrupted_total{path="downstream"} 1
+halftunnel_packets_corrupted_total{path="upstream"} 2
+`
+	if err := testutil.CollectAndCompare(c.PacketsCorrupted, strings.NewReader(expected)); err != nil {
+		t.Errorf("packets corrupted mismatch: %v", err)
+	}
+}
+
+func TestCollector_RecordPacketReplayed(t *testing.T) {
+	c := NewCollector()
+	c.RecordPacketReplayed()
+	c.RecordPacketReplayed()
+
+	if got := testutil.ToFloat64(c.PacketsReplayed); got != 2 {
+		t.Errorf("Expected 2 replayed packets, got %v", got)
+	}
+}
+
+func TestCollector_RecordDownstreamFailures(t *testing.T) {
+	c := NewCollector()
+	registry := prometheus.NewRegistry()
+	c.MustRegister(registry)
+
+	c.RecordDownstreamWriteFailure()
+	c.RecordDownstreamWriteFailure()
+	c.SetDownstreamFailingSessions(1)
+
+	if got := testutil.ToFloat64(c.DownstreamWriteFailures); got != 2 {
+		t.Errorf("expected 2 write failures, got %v", got)
+	}
+	if got := testutil.ToFloat64(c.DownstreamFailingSessions); got != 1 {
+		t.Errorf("expected 1 failing session, got %v", got)
+	}
+}
+
+func TestCollector_RecordStreamLifetime(t *testing.T) {
+	c := NewCollector()
+	registry := prometheus.NewRegistry()
+	c.MustRegister(registry)
+
+	c.RecordStreamLifetime(50*time.Millisecond, 100, 5000)
+	c.RecordStreamLifetime(2*time.Hour, 1<<20, 1<<30)
+
+	if got := testutil.CollectAndCount(c.StreamSize); got != 2 {
+		t.Errorf("expected upstream and downstream size series, got %d", got)
+	}
+	expected := `
+# HELP halftunnel_stream_lifetime_seconds Time from opening to closing a stream in seconds
+# TYPE halftunnel_stream_lifetime_seconds histogram
+halftunnel_stream_lifetime_seconds_bucket{le="0.01"} 0
+halftunnel_stream_lifetime_seconds_bucket{le="0.04"} 0
+halftunnel_stream_lifetime_seconds_bucket{le="0.16"} 1
+halftunnel_stream_lifetime_seconds_bucket{le="0.64"} 1
+halftunnel_stream_lifetime_seconds_bucket{le="2.56"} 1
+halftunnel_stream_lifetime_seconds_bucket{le="10.24"} 1
+halftunnel_stream_lifetime_seconds_bucket{le="40.96"} 1
+halftunnel_stream_lifetime_seconds_bucket{le="163.84"} 1
+halftunnel_stream_lifetime_seconds_bucket{le="655.36"} 1
+halftunnel_stream_lifetime_seconds_bucket{le="2621.44"} 1
+halftunnel_stream_lifetime_seconds_bucket{le="10485.76"} 2
+halftunnel_stream_lifetime_seconds_bucket{le="41943.04"} 2
+halftunnel_stream_lifetime_seconds_bucket{le="+Inf"} 2
+halftunnel_stream_lifetime_seconds_sum 7200.05
+halftunnel_stream_lifetime_seconds_count 2
+`
+	if err := testutil.CollectAndCompare(c.StreamLifetime, strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCollector_ResourceGuard(t *testing.T) {
+	c := NewCollector()
+	registry := prometheus.NewRegistry()
+	c.MustRegister(registry)
+
+	c.SetResourceSaturation("open_files", 0.25)
+	c.RecordStreamShed("nat_entries")
+	c.RecordStreamShed("nat_entries")
+
+	if got := testutil.ToFloat64(c.ResourceSaturation.WithLabelValues("open_files")); got != 0.25 {
+		t.Errorf("expected open_files saturation 0.25, got %v", got)
+	}
+	if got := testutil.ToFloat64(c.StreamsShed.WithLabelValues("nat_entries")); got != 2 {
+		t.Errorf("expected 2 streams shed, got %v", got)
+	}
+}
+
+func TestCollector_SendQueueObserver(t *testing.T) {
+	c := NewCollector()
+	registry := prometheus.NewRegistry()
+	c.MustRegister(registry)
+
+	observer := c.SendQueueObserver("upstream")
+	observer.QueueChanged(3)
+	observer.QueueChanged(-2)
+	observer.BatchWritten(2)
+
+	if got := testutil.ToFloat64(c.SendQueueDepth.WithLabelValues("upstream")); got != 1 {
+		t.Errorf("expected send queue depth 1, got %v", got)
+	}
+	if got := testutil.CollectAndCount(c.SendBatchSize); got != 1 {
+		t.Errorf("expected 1 batch size series, got %d", got)
+	}
+}
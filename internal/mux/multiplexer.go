@@ -4,20 +4,37 @@ package mux
 import (
 	"errors"
 	"sync"
-	"sync/atomic"
+	"time"
 
+	"github.com/sahmadiut/half-tunnel/internal/constants"
 	"github.com/sahmadiut/half-tunnel/internal/protocol"
 	"github.com/sahmadiut/half-tunnel/internal/session"
 )
 
 // Errors
 var (
-	ErrStreamNotFound = errors.New("stream not found")
-	ErrStreamClosed   = errors.New("stream is closed")
-	ErrMuxClosed      = errors.New("multiplexer is closed")
+	ErrStreamNotFound    = errors.New("stream not found")
+	ErrStreamClosed      = errors.New("stream is closed")
+	ErrMuxClosed         = errors.New("multiplexer is closed")
+	ErrStreamIDExhausted = errors.New("no free stream ID")
 )
 
+// StreamIDQuarantine is how long the ID of a closed stream is not reused
+// when the peer does not acknowledge that it forgot the stream (see
+// ReleaseStream).
+const StreamIDQuarantine = 5 * time.Minute
+
+// minQuarantineSweep is the number of quarantined IDs above which closing a
+// stream drops the expired ones.
+const minQuarantineSweep = 1024
+
 // Multiplexer routes packets to the correct stream within a session.
+//
+// Stream IDs are allocated from a counter that wraps around, skipping 0,
+// which is reserved for session-level packets. The ID of a closed stream is
+// quarantined until the peer acknowledges with a FIN-ACK that it forgot the
+// stream, or until StreamIDQuarantine passed, so a reused ID never reaches
+// the peer's state for an older stream.
 type Multiplexer struct {
 	session       *session.Session
 	nextStreamID  uint32
@@ -25,6 +42,15 @@ type Multiplexer struct {
 	closed        bool
 	mu            sync.RWMutex
 
+	// IDs of closed streams the peer may still know, with when they were
+	// closed
+	quarantined map[uint32]time.Time
+	sweepAt     int
+	// acked holds open streams the peer already forgot: their IDs are free
+	// as soon as they are closed
+	acked map[uint32]struct{}
+	now   func() time.Time
+
 	// Callbacks for handling packets
 	onPacket func(*protocol.Packet) error
 }
@@ -35,6 +61,10 @@ func NewMultiplexer(s *session.Session) *Multiplexer {
 		session:       s,
 		nextStreamID:  1,
 		streamBuffers: make(map[uint32]*StreamBuffer),
+		quarantined:   make(map[uint32]time.Time),
+		sweepAt:       minQuarantineSweep,
+		acked:         make(map[uint32]struct{}),
+		now:           time.Now,
 	}
 }
 
@@ -54,14 +84,45 @@ func (m *Multiplexer) OpenStream() (uint32, error) {
 		return 0, ErrMuxClosed
 	}
 
-	streamID := atomic.AddUint32(&m.nextStreamID, 1) - 1
+	streamID, err := m.allocateStreamID()
+	if err != nil {
+		return 0, err
+	}
 	m.session.GetStream(streamID)
-	m.streamBuffers[streamID] = NewStreamBuffer(1024) // 1KB default buffer
+	m.streamBuffers[streamID] = NewStreamBuffer(constants.StreamBufferSize)
 
 	return streamID, nil
 }
 
-// CloseStream closes a stream.
+// allocateStreamID returns the next stream ID that is neither open nor
+// quarantined. Every skipped ID is open or quarantined, so the search ends
+// after at most that many IDs. m.mu must be held.
+func (m *Multiplexer) allocateStreamID() (uint32, error) {
+	limit := m.session.StreamCount() + len(m.quarantined) + 1
+	now := m.now()
+	for i := 0; i <= limit; i++ {
+		id := m.nextStreamID
+		m.nextStreamID++
+		if id == 0 {
+			// Reserved for session-level packets
+			continue
+		}
+		if _, open := m.session.GetExistingStream(id); open {
+			continue
+		}
+		if closedAt, ok := m.quarantined[id]; ok {
+			if now.Sub(closedAt) < StreamIDQuarantine {
+				continue
+			}
+			delete(m.quarantined, id)
+		}
+		return id, nil
+	}
+	return 0, ErrStreamIDExhausted
+}
+
+// CloseStream closes a stream. Its ID is quarantined until ReleaseStream is
+// called for it, unless the peer already forgot the stream.
 func (m *Multiplexer) CloseStream(streamID uint32) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
@@ -75,9 +136,46 @@ func (m *Multiplexer) CloseStream(streamID uint32) error {
 	delete(m.streamBuffers, streamID)
 	m.session.RemoveStream(streamID)
 
+	if _, ok := m.acked[streamID]; ok {
+		delete(m.acked, streamID)
+		return nil
+	}
+	m.quarantined[streamID] = m.now()
+	if len(m.quarantined) >= m.sweepAt {
+		m.sweepQuarantine()
+	}
+
 	return nil
 }
 
+// ReleaseStream makes the ID of a stream the peer forgot, as told by its
+// FIN-ACK, available again. If the stream is still open, its ID is released
+// when it is closed.
+func (m *Multiplexer) ReleaseStream(streamID uint32) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.quarantined[streamID]; ok {
+		delete(m.quarantined, streamID)
+		return
+	}
+	if _, open := m.session.GetExistingStream(streamID); open {
+		m.acked[streamID] = struct{}{}
+	}
+}
+
+// sweepQuarantine drops the expired quarantined IDs, for peers that never
+// acknowledge closed streams. m.mu must be held.
+func (m *Multiplexer) sweepQuarantine() {
+	now := m.now()
+	for id, closedAt := range m.quarantined {
+		if now.Sub(closedAt) >= StreamIDQuarantine {
+			delete(m.quarantined, id)
+		}
+	}
+	m.sweepAt = max(minQuarantineSweep, 2*len(m.quarantined))
+}
+
 // HandlePacket routes an incoming packet to the correct stream.
 func (m *Multiplexer) HandlePacket(pkt *protocol.Packet) error {
 	m.mu.RLock()
@@ -93,7 +191,7 @@ func (m *Multiplexer) HandlePacket(pkt *protocol.Packet) error {
 	// Get or create buffer
 	buf, exists := m.streamBuffers[pkt.StreamID]
 	if !exists {
-		buf = NewStreamBuffer(1024)
+		buf = NewStreamBuffer(constants.StreamBufferSize)
 		m.streamBuffers[pkt.StreamID] = buf
 	}
 	m.mu.Unlock()
@@ -119,12 +217,32 @@ func (m *Multiplexer) HandlePacket(pkt *protocol.Packet) error {
 		if err := buf.Write(pkt.SeqNum, pkt.Payload); err != nil {
 			return err
 		}
+		stream.CountReceived(len(pkt.Payload))
 	}
 
 	return nil
 }
 
-// SendPacket creates and sends a packet for a stream.
+// MaxPayload returns the largest payload of the session's packets.
+func (m *Multiplexer) MaxPayload() int {
+	if n := m.session.MaxPayload(); n > 0 {
+		return n
+	}
+	return protocol.MaxPayloadSize
+}
+
+// ReadSize returns the buffer size to read stream data with for sending in
+// packets of at most maxPayload bytes: the default buffer size, unless the
+// payloads are smaller or jumbo frames allow larger reads.
+func ReadSize(maxPayload int) int {
+	if maxPayload > protocol.MaxPayloadSize || maxPayload < constants.DefaultBufferSize {
+		return maxPayload
+	}
+	return constants.DefaultBufferSize
+}
+
+// SendPacket creates and sends a packet for a stream. Data larger than the
+// session's maximum payload is split into several packets.
 func (m *Multiplexer) SendPacket(streamID uint32, flags protocol.Flag, payload []byte) error {
 	m.mu.RLock()
 	if m.closed {
@@ -147,14 +265,40 @@ func (m *Multiplexer) SendPacket(streamID uint32, flags protocol.Flag, payload [
 		return ErrStreamClosed
 	}
 
-	pkt, err := protocol.NewPacket(m.session.ID, streamID, flags, payload)
+	maxPayload := m.MaxPayload()
+	if len(payload) <= maxPayload {
+		return m.sendChunk(handler, stream, flags, payload)
+	}
+	if flags != protocol.FlagData {
+		return protocol.ErrPayloadTooLarge
+	}
+	for len(payload) > 0 {
+		n := min(len(payload), maxPayload)
+		if err := m.sendChunk(handler, stream, flags, payload[:n]); err != nil {
+			return err
+		}
+		payload = payload[n:]
+	}
+	return nil
+}
+
+// sendChunk sends one packet of a stream.
+func (m *Multiplexer) sendChunk(handler func(*protocol.Packet) error, stream *session.Stream, flags protocol.Flag, payload []byte) error {
+	pkt, err := protocol.NewJumboPacket(m.session.ID, stream.ID, flags, payload)
 	if err != nil {
 		return err
 	}
 
 	pkt.SeqNum = stream.NextSeqNum()
 
-	return handler(pkt)
+	if err := handler(pkt); err != nil {
+		return err
+	}
+	// The connect request is not stream data
+	if flags&protocol.FlagData != 0 && flags&protocol.FlagHandshake == 0 && len(payload) > 0 {
+		stream.CountSent(len(payload))
+	}
+	return nil
 }
 
 // ReadStream reads available data from a stream's buffer.
@@ -170,6 +314,26 @@ func (m *Multiplexer) ReadStream(streamID uint32) ([]byte, error) {
 	return buf.ReadAll(), nil
 }
 
+// NextSeq returns the sequence number of the next data packet a stream
+// expects, which is the number of its data packets received in order.
+func (m *Multiplexer) NextSeq(streamID uint32) uint32 {
+	m.mu.RLock()
+	buf, exists := m.streamBuffers[streamID]
+	m.mu.RUnlock()
+
+	if !exists {
+		return 0
+	}
+	return buf.NextSeq()
+}
+
+// GetStreamStats returns the traffic counters of an open stream: the data
+// sent and received through the multiplexer, when it was created and when it
+// last carried data.
+func (m *Multiplexer) GetStreamStats(streamID uint32) (session.StreamStats, bool) {
+	return m.session.GetStreamStats(streamID)
+}
+
 // Close closes the multiplexer and all streams.
 func (m *Multiplexer) Close() error {
 	m.mu.Lock()
@@ -258,6 +422,13 @@ func (b *StreamBuffer) ReadAll() []byte {
 	return data
 }
 
+// NextSeq returns the next expected sequence number.
+func (b *StreamBuffer) NextSeq() uint32 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.nextExpectedSeq
+}
+
 // Len returns the current size of buffered data.
 func (b *StreamBuffer) Len() int {
 	b.mu.Lock()
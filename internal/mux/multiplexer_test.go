@@ -1,9 +1,12 @@
 package mux
 
 import (
+	"math"
 	"testing"
+	"time"
 
 	"github.com/google/uuid"
+	"github.com/sahmadiut/half-tunnel/internal/constants"
 	"github.com/sahmadiut/half-tunnel/internal/protocol"
 	"github.com/sahmadiut/half-tunnel/internal/session"
 )
@@ -288,3 +291,180 @@ func TestMultiplexerHandlePacketClosed(t *testing.T) {
 		t.Errorf("Expected ErrMuxClosed, got %v", err)
 	}
 }
+
+func TestMultiplexerSendPacketChunks(t *testing.T) {
+	sess := session.New()
+	sess.SetMaxPayload(4)
+	mux := NewMultiplexer(sess)
+
+	var sent []*protocol.Packet
+	mux.SetPacketHandler(func(pkt *protocol.Packet) error {
+		sent = append(sent, pkt)
+		return nil
+	})
+	streamID, _ := mux.OpenStream()
+
+	if err := mux.SendPacket(streamID, protocol.FlagData, []byte("hello world")); err != nil {
+		t.Fatalf("SendPacket failed: %v", err)
+	}
+	want := []string{"hell", "o wo", "rld"}
+	if len(sent) != len(want) {
+		t.Fatalf("Expected %d packets, got %d", len(want), len(sent))
+	}
+	for i, pkt := range sent {
+		if string(pkt.Payload) != want[i] {
+			t.Errorf("Packet %d: expected %q, got %q", i, want[i], pkt.Payload)
+		}
+		if i > 0 && pkt.SeqNum != sent[i-1].SeqNum+1 {
+			t.Errorf("Packet %d: expected sequence number %d, got %d", i, sent[i-1].SeqNum+1, pkt.SeqNum)
+		}
+	}
+
+	if err := mux.SendPacket(streamID, protocol.FlagFin, []byte("too long")); err != protocol.ErrPayloadTooLarge {
+		t.Errorf("Expected ErrPayloadTooLarge for an oversized FIN, got %v", err)
+	}
+}
+
+func TestMultiplexerGetStreamStats(t *testing.T) {
+	sess := session.New()
+	sess.SetMaxPayload(4)
+	mux := NewMultiplexer(sess)
+	mux.SetPacketHandler(func(pkt *protocol.Packet) error { return nil })
+	streamID, _ := mux.OpenStream()
+
+	// The connect request is not counted as stream data
+	if err := mux.SendPacket(streamID, protocol.FlagData|protocol.FlagHandshake, []byte("conn")); err != nil {
+		t.Fatalf("SendPacket failed: %v", err)
+	}
+	if err := mux.SendPacket(streamID, protocol.FlagData, []byte("hello world")); err != nil {
+		t.Fatalf("SendPacket failed: %v", err)
+	}
+	pkt, _ := protocol.NewPacket(sess.ID, streamID, protocol.FlagData, []byte("reply"))
+	if err := mux.HandlePacket(pkt); err != nil {
+		t.Fatalf("HandlePacket failed: %v", err)
+	}
+
+	stats, ok := mux.GetStreamStats(streamID)
+	if !ok {
+		t.Fatal("Expected stats for an open stream")
+	}
+	if stats.BytesSent != 11 || stats.PacketsSent != 3 {
+		t.Errorf("Expected 11 bytes in 3 packets sent, got %d in %d", stats.BytesSent, stats.PacketsSent)
+	}
+	if stats.BytesRecv != 5 || stats.PacketsRecv != 1 {
+		t.Errorf("Expected 5 bytes in 1 packet received, got %d in %d", stats.BytesRecv, stats.PacketsRecv)
+	}
+
+	_ = mux.CloseStream(streamID)
+	if _, ok := mux.GetStreamStats(streamID); ok {
+		t.Error("Expected no stats for a closed stream")
+	}
+}
+
+func TestReadSize(t *testing.T) {
+	tests := []struct {
+		maxPayload int
+		want       int
+	}{
+		{protocol.MaxPayloadSize, constants.DefaultBufferSize},
+		{1024, 1024},
+		{protocol.MaxJumboPayloadSize, protocol.MaxJumboPayloadSize},
+	}
+	for _, tt := range tests {
+		if got := ReadSize(tt.maxPayload); got != tt.want {
+			t.Errorf("ReadSize(%d): expected %d, got %d", tt.maxPayload, tt.want, got)
+		}
+	}
+}
+
+func TestMultiplexerStreamIDWraparound(t *testing.T) {
+	mux := NewMultiplexer(session.New())
+
+	open, _ := mux.OpenStream()
+	closed, _ := mux.OpenStream()
+	if err := mux.CloseStream(closed); err != nil {
+		t.Fatalf("CloseStream failed: %v", err)
+	}
+
+	// Simulate 2^32 allocations since: the counter is about to wrap
+	mux.nextStreamID = math.MaxUint32 - 1
+	want := []uint32{math.MaxUint32 - 1, math.MaxUint32, 3, 4}
+	for _, w := range want {
+		id, err := mux.OpenStream()
+		if err != nil {
+			t.Fatalf("OpenStream failed: %v", err)
+		}
+		if id != w {
+			t.Errorf("Expected stream ID %d, got %d (0 is reserved, %d is open and %d quarantined)", w, id, open, closed)
+		}
+	}
+}
+
+func TestMultiplexerReleaseStream(t *testing.T) {
+	mux := NewMultiplexer(session.New())
+
+	// FIN-ACK after the stream was closed
+	first, _ := mux.OpenStream()
+	_ = mux.CloseStream(first)
+	mux.ReleaseStream(first)
+
+	// FIN-ACK before the stream was closed
+	second, _ := mux.OpenStream()
+	mux.ReleaseStream(second)
+	_ = mux.CloseStream(second)
+
+	// A FIN-ACK for an unknown stream is ignored
+	mux.ReleaseStream(42)
+
+	if n := len(mux.quarantined) + len(mux.acked); n != 0 {
+		t.Fatalf("Expected no quarantined or acked IDs, got %d", n)
+	}
+	mux.nextStreamID = first
+	for _, want := range []uint32{first, second} {
+		if id, _ := mux.OpenStream(); id != want {
+			t.Errorf("Expected released stream ID %d to be reused, got %d", want, id)
+		}
+	}
+}
+
+func TestMultiplexerStreamIDQuarantine(t *testing.T) {
+	now := time.Unix(1000, 0)
+	mux := NewMultiplexer(session.New())
+	mux.now = func() time.Time { return now }
+
+	id, _ := mux.OpenStream()
+	_ = mux.CloseStream(id)
+
+	mux.nextStreamID = id
+	if next, _ := mux.OpenStream(); next == id {
+		t.Fatalf("Stream ID %d reused while quarantined", id)
+	}
+
+	now = now.Add(StreamIDQuarantine)
+	mux.nextStreamID = id
+	if next, _ := mux.OpenStream(); next != id {
+		t.Errorf("Expected stream ID %d to be reused after the quarantine, got %d", id, next)
+	}
+	if len(mux.quarantined) != 0 {
+		t.Errorf("Expected the expired ID to leave the quarantine, got %d IDs", len(mux.quarantined))
+	}
+}
+
+func TestMultiplexerQuarantineSweep(t *testing.T) {
+	now := time.Unix(1000, 0)
+	mux := NewMultiplexer(session.New())
+	mux.now = func() time.Time { return now }
+
+	for i := 0; i < minQuarantineSweep-1; i++ {
+		id, _ := mux.OpenStream()
+		_ = mux.CloseStream(id)
+	}
+	now = now.Add(StreamIDQuarantine)
+
+	// Closing one more stream drops the expired IDs
+	id, _ := mux.OpenStream()
+	_ = mux.CloseStream(id)
+	if len(mux.quarantined) != 1 {
+		t.Errorf("Expected 1 quarantined ID after the sweep, got %d", len(mux.quarantined))
+	}
+}
@@ -0,0 +1,221 @@
+// Package notify sends notifications about critical Half-Tunnel events to
+// operators: a Telegram chat, a generic webhook and email.
+//
+// Notifications are sent in the background, one at a time, so the code
+// reporting an event never waits for the network. Repeated notifications of
+// the same event and key are suppressed for a cooldown.
+package notify
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sahmadiut/half-tunnel/pkg/logger"
+)
+
+// Event is the kind of event a notification reports.
+type Event string
+
+// Events.
+const (
+	// EventReconnectFailing is sent by the client when reconnecting keeps
+	// failing
+	EventReconnectFailing Event = "reconnect_failing"
+	// EventCertExpiring is sent by the server when a TLS certificate is
+	// about to expire
+	EventCertExpiring Event = "cert_expiring"
+	// EventQuotaExceeded is sent by the server when a client used up its
+	// traffic quota
+	EventQuotaExceeded Event = "quota_exceeded"
+	// EventServerStarted is sent by the server when it starts, e.g. after a
+	// crash or restart
+	EventServerStarted Event = "server_started"
+)
+
+// Events lists all events.
+var Events = []Event{EventReconnectFailing, EventCertExpiring, EventQuotaExceeded, EventServerStarted}
+
+// queueSize is how many notifications may wait to be sent; beyond it new
+// ones are dropped.
+const queueSize = 64
+
+// Notification is one event report.
+type Notification struct {
+	Event   Event             `json:"event"`
+	Time    time.Time         `json:"time"`
+	Source  string            `json:"source"`
+	Message string            `json:"message"`
+	Fields  map[string]string `json:"fields,omitempty"`
+}
+
+// Text renders the notification as plain text for chats and email.
+func (n Notification) Text() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "[%s] %s: %s", n.Source, n.Event, n.Message)
+	keys := make([]string, 0, len(n.Fields))
+	for k := range n.Fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(&b, "\n%s: %s", k, n.Fields[k])
+	}
+	return b.String()
+}
+
+// Sender delivers notifications to one destination.
+type Sender interface {
+	// Name identifies the destination in logs
+	Name() string
+	// Send delivers a notification
+	Send(ctx context.Context, n Notification) error
+}
+
+// Config holds notifier settings.
+type Config struct {
+	// Source names this client or server in notifications (default: the
+	// hostname)
+	Source string
+	// Events are the events to send (empty sends all)
+	Events []Event
+	// Cooldown is the minimum time between notifications of the same event
+	// and key (0 sends all)
+	Cooldown time.Duration
+	// Timeout bounds each delivery
+	Timeout time.Duration
+	// Senders are the destinations
+	Senders []Sender
+}
+
+// Notifier sends notifications to its senders. A nil Notifier discards
+// them.
+type Notifier struct {
+	config *Config
+	log    *logger.Logger
+	events map[Event]bool
+	queue  chan Notification
+	done   chan struct{}
+
+	mu   sync.Mutex
+	last map[string]time.Time // last notification per event and key
+	now  func() time.Time
+}
+
+// New creates a notifier. Start it to deliver notifications.
+func New(config *Config, log *logger.Logger) *Notifier {
+	if log == nil {
+		log = logger.NewDefault()
+	}
+	if config.Source == "" {
+		config.Source, _ = os.Hostname()
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = 10 * time.Second
+	}
+	n := &Notifier{
+		config: config,
+		log:    log,
+		queue:  make(chan Notification, queueSize),
+		done:   make(chan struct{}),
+		last:   make(map[string]time.Time),
+		now:    time.Now,
+	}
+	if len(config.Events) > 0 {
+		n.events = make(map[Event]bool, len(config.Events))
+		for _, e := range config.Events {
+			n.events[e] = true
+		}
+	}
+	return n
+}
+
+// Notify queues a notification of event. key tells apart occurrences of the
+// event that the cooldown applies to separately, such as the client whose
+// quota is used up.
+func (n *Notifier) Notify(event Event, key, message string, fields map[string]string) {
+	if n == nil || (n.events != nil && !n.events[event]) {
+		return
+	}
+	now := n.now()
+	if n.config.Cooldown > 0 {
+		id := string(event) + "/" + key
+		n.mu.Lock()
+		last, seen := n.last[id]
+		if seen && now.Sub(last) < n.config.Cooldown {
+			n.mu.Unlock()
+			return
+		}
+		n.last[id] = now
+		n.mu.Unlock()
+	}
+
+	select {
+	case n.queue <- Notification{Event: event, Time: now, Source: n.config.Source, Message: message, Fields: fields}:
+	default:
+		n.log.Warn().Str("event", string(event)).Msg("Too many notifications waiting, dropping")
+	}
+}
+
+// Start delivers queued notifications until ctx is done, then delivers the
+// ones still queued.
+func (n *Notifier) Start(ctx context.Context) {
+	if n == nil {
+		return
+	}
+	go func() {
+		defer close(n.done)
+		for {
+			select {
+			case <-ctx.Done():
+				n.drain()
+				return
+			case notification := <-n.queue:
+				n.deliver(notification)
+			}
+		}
+	}()
+}
+
+// Wait waits until a started notifier has stopped delivering.
+func (n *Notifier) Wait() {
+	if n != nil {
+		<-n.done
+	}
+}
+
+// drain delivers the notifications still queued.
+func (n *Notifier) drain() {
+	for {
+		select {
+		case notification := <-n.queue:
+			n.deliver(notification)
+		default:
+			return
+		}
+	}
+}
+
+// deliver sends a notification to every sender.
+func (n *Notifier) deliver(notification Notification) {
+	for _, sender := range n.config.Senders {
+		ctx, cancel := context.WithTimeout(context.Background(), n.config.Timeout)
+		err := sender.Send(ctx, notification)
+		cancel()
+		if err != nil {
+			n.log.Warn().Err(err).
+				Str("sender", sender.Name()).
+				Str("event", string(notification.Event)).
+				Msg("Failed to send notification")
+			continue
+		}
+		n.log.Debug().
+			Str("sender", sender.Name()).
+			Str("event", string(notification.Event)).
+			Msg("Notification sent")
+	}
+}
@@ -0,0 +1,155 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recorder is a Sender that keeps what it is sent.
+type recorder struct {
+	mu   sync.Mutex
+	sent []Notification
+}
+
+func (r *recorder) Name() string { return "recorder" }
+
+func (r *recorder) Send(_ context.Context, n Notification) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sent = append(r.sent, n)
+	return nil
+}
+
+func TestNotifierFiltersAndCoolsDown(t *testing.T) {
+	rec := &recorder{}
+	n := New(&Config{
+		Source:   "exit-1",
+		Events:   []Event{EventQuotaExceeded, EventServerStarted},
+		Cooldown: time.Hour,
+		Senders:  []Sender{rec},
+	}, nil)
+	now := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+	n.now = func() time.Time { return now }
+
+	ctx, cancel := context.WithCancel(context.Background())
+	n.Start(ctx)
+
+	n.Notify(EventServerStarted, "", "Server started", nil)
+	n.Notify(EventCertExpiring, "cert.pem", "Certificate expires soon", nil)
+	n.Notify(EventQuotaExceeded, "laptop", "Quota used up", map[string]string{"client": "laptop"})
+	n.Notify(EventQuotaExceeded, "laptop", "Quota used up", nil)
+	n.Notify(EventQuotaExceeded, "phone", "Quota used up", nil)
+	now = now.Add(time.Hour)
+	n.Notify(EventQuotaExceeded, "laptop", "Quota used up", nil)
+
+	cancel()
+	n.Wait()
+
+	var got []string
+	for _, sent := range rec.sent {
+		got = append(got, string(sent.Event)+"/"+sent.Message)
+		if sent.Source != "exit-1" {
+			t.Errorf("Expected source exit-1, got %q", sent.Source)
+		}
+	}
+	want := []string{
+		"server_started/Server started",
+		"quota_exceeded/Quota used up",
+		"quota_exceeded/Quota used up",
+		"quota_exceeded/Quota used up",
+	}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+}
+
+func TestNilNotifier(t *testing.T) {
+	var n *Notifier
+	n.Notify(EventServerStarted, "", "Server started", nil)
+	n.Start(context.Background())
+	n.Wait()
+}
+
+func TestWebhookAndTelegram(t *testing.T) {
+	var mu sync.Mutex
+	requests := make(map[string]map[string]interface{})
+	var auth string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/reject" {
+			http.Error(w, "nope", http.StatusForbidden)
+			return
+		}
+		var body map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		requests[r.URL.Path] = body
+		if r.URL.Path == "/hook" {
+			auth = r.Header.Get("Authorization")
+		}
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	n := Notification{
+		Event:   EventCertExpiring,
+		Time:    time.Now(),
+		Source:  "exit-1",
+		Message: "Certificate expires in 5 days",
+		Fields:  map[string]string{"file": "/etc/ssl/cert.pem"},
+	}
+	webhook := &Webhook{URL: srv.URL + "/hook", Headers: map[string]string{"Authorization": "Bearer secret"}}
+	if err := webhook.Send(context.Background(), n); err != nil {
+		t.Fatalf("Webhook failed: %v", err)
+	}
+	telegram := &Telegram{BotToken: "123:abc", ChatID: "42", APIURL: srv.URL}
+	if err := telegram.Send(context.Background(), n); err != nil {
+		t.Fatalf("Telegram failed: %v", err)
+	}
+
+	if got := requests["/hook"]; got["event"] != "cert_expiring" || got["source"] != "exit-1" || auth != "Bearer secret" {
+		t.Errorf("Unexpected webhook request %v (Authorization %q)", got, auth)
+	}
+	got := requests["/bot123:abc/sendMessage"]
+	if got["chat_id"] != "42" || got["text"] != "[exit-1] cert_expiring: Certificate expires in 5 days\nfile: /etc/ssl/cert.pem" {
+		t.Errorf("Unexpected Telegram request %v", got)
+	}
+
+	// Errors do not leak the bot token in the URL
+	failing := &Telegram{BotToken: "123:abc", ChatID: "42", APIURL: "http://127.0.0.1:1"}
+	if err := failing.Send(context.Background(), n); err == nil || strings.Contains(err.Error(), "123:abc") {
+		t.Errorf("Expected an error without the token, got %v", err)
+	}
+	rejecting := &Webhook{URL: srv.URL + "/reject"}
+	if err := rejecting.Send(context.Background(), n); err == nil || !strings.Contains(err.Error(), "403") {
+		t.Errorf("Expected a status error, got %v", err)
+	}
+}
+
+func TestEmailMessage(t *testing.T) {
+	e := &Email{From: "tunnel@example.com", To: []string{"ops@example.com", "me@example.com"}}
+	msg := string(e.message(Notification{
+		Event:   EventServerStarted,
+		Time:    time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC),
+		Source:  "exit-1",
+		Message: "Server started",
+	}))
+	for _, want := range []string{
+		"To: ops@example.com, me@example.com\r\n",
+		"Subject: [half-tunnel] server_started on exit-1\r\n",
+		"Date: Sun, 10 Mar 2024 12:00:00 +0000\r\n",
+		"\r\n\r\n[exit-1] server_started: Server started\r\n",
+	} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("Expected %q in\n%s", want, msg)
+		}
+	}
+}
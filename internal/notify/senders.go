@@ -0,0 +1,157 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// DefaultTelegramAPI is the Telegram Bot API base URL.
+const DefaultTelegramAPI = "https://api.telegram.org"
+
+// Telegram sends notifications to a Telegram chat through a bot.
+type Telegram struct {
+	// BotToken is the token BotFather issued for the bot
+	BotToken string
+	// ChatID is the chat, group or channel to post to
+	ChatID string
+	// APIURL is the Bot API base URL (default DefaultTelegramAPI)
+	APIURL string
+	// Client sends the requests (default http.DefaultClient)
+	Client *http.Client
+}
+
+// Name implements Sender.
+func (t *Telegram) Name() string { return "telegram" }
+
+// Send implements Sender.
+func (t *Telegram) Send(ctx context.Context, n Notification) error {
+	api := t.APIURL
+	if api == "" {
+		api = DefaultTelegramAPI
+	}
+	body, err := json.Marshal(map[string]string{"chat_id": t.ChatID, "text": n.Text()})
+	if err != nil {
+		return err
+	}
+	return postJSON(ctx, t.Client, strings.TrimSuffix(api, "/")+"/bot"+t.BotToken+"/sendMessage", nil, body)
+}
+
+// Webhook posts notifications as JSON to a URL.
+type Webhook struct {
+	// URL receives a POST with the Notification as JSON
+	URL string
+	// Headers are added to each request, e.g. Authorization
+	Headers map[string]string
+	// Client sends the requests (default http.DefaultClient)
+	Client *http.Client
+}
+
+// Name implements Sender.
+func (w *Webhook) Name() string { return "webhook" }
+
+// Send implements Sender.
+func (w *Webhook) Send(ctx context.Context, n Notification) error {
+	body, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	return postJSON(ctx, w.Client, w.URL, w.Headers, body)
+}
+
+// postJSON posts body to target and fails on a non-2xx response.
+func postJSON(ctx context.Context, client *http.Client, target string, headers map[string]string, body []byte) error {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		// The error contains the URL, which may hold a bot token
+		return fmt.Errorf("request failed: %w", unwrapURLError(err))
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("unexpected status %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return nil
+}
+
+// unwrapURLError strips the request URL from a client error.
+func unwrapURLError(err error) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return urlErr.Err
+	}
+	return err
+}
+
+// Email sends notifications by SMTP. Servers that offer STARTTLS are used
+// with TLS, and credentials are only sent over TLS or to localhost.
+type Email struct {
+	// Host and Port are the SMTP server (port 587 for submission)
+	Host string
+	Port int
+	// Username and Password authenticate with PLAIN auth (optional)
+	Username string
+	Password string
+	// From is the sender address
+	From string
+	// To are the recipient addresses
+	To []string
+}
+
+// Name implements Sender.
+func (e *Email) Name() string { return "email" }
+
+// Send implements Sender.
+func (e *Email) Send(ctx context.Context, n Notification) error {
+	var auth smtp.Auth
+	if e.Username != "" {
+		auth = smtp.PlainAuth("", e.Username, e.Password, e.Host)
+	}
+
+	msg := e.message(n)
+	addr := net.JoinHostPort(e.Host, strconv.Itoa(e.Port))
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- smtp.SendMail(addr, auth, e.From, e.To, msg)
+	}()
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// message returns the email for a notification.
+func (e *Email) message(n Notification) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", e.From)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(e.To, ", "))
+	fmt.Fprintf(&b, "Subject: [half-tunnel] %s on %s\r\n", n.Event, n.Source)
+	fmt.Fprintf(&b, "Date: %s\r\n", n.Time.Format("Mon, 02 Jan 2006 15:04:05 -0700"))
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(n.Text(), "\n", "\r\n"))
+	b.WriteString("\r\n")
+	return b.Bytes()
+}
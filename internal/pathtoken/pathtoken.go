@@ -0,0 +1,118 @@
+// Package pathtoken derives rotating WebSocket path tokens for Half-Tunnel
+// endpoints.
+//
+// With a shared secret, the server only accepts WebSocket upgrades on
+// <base>/<token>, where the token is an HMAC of the base path and the current
+// time window. The client computes the same token before every dial, so the
+// tunnel paths change every window and a passive observer cannot replay or
+// probe a fixed endpoint path.
+//
+// Upgrade cookies use the same scheme with a separate key: the client sends
+// the token for the endpoint path in a cookie, and the server answers
+// upgrade requests without it exactly like the decoy site, so an active
+// prober cannot confirm the tunnel.
+package pathtoken
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/sahmadiut/half-tunnel/pkg/crypto"
+)
+
+// DefaultWindow is how long a path token stays current.
+const DefaultWindow = 5 * time.Minute
+
+// tokenSize is the number of HMAC bytes encoded into a token.
+const tokenSize = 12
+
+// DefaultCookie is the name of the cookie carrying upgrade tokens.
+const DefaultCookie = "session_id"
+
+// keySalt separates path token keys from other uses of the same secret.
+var keySalt = []byte("half-tunnel-path-token")
+
+// cookieSalt separates upgrade cookie keys from path token keys.
+var cookieSalt = []byte("half-tunnel-upgrade-cookie")
+
+// ErrEmptySecret is returned when no secret is configured.
+var ErrEmptySecret = errors.New("path token secret is empty")
+
+// Generator computes and verifies path tokens.
+type Generator struct {
+	hmac   *crypto.HMAC
+	window time.Duration
+}
+
+// New creates a generator for secret. Tokens rotate every window
+// (DefaultWindow if window <= 0).
+func New(secret string, window time.Duration) (*Generator, error) {
+	return newGenerator(secret, window, keySalt)
+}
+
+// NewCookie creates a generator for upgrade cookie tokens. Its tokens differ
+// from path tokens of the same secret.
+func NewCookie(secret string, window time.Duration) (*Generator, error) {
+	return newGenerator(secret, window, cookieSalt)
+}
+
+func newGenerator(secret string, window time.Duration, salt []byte) (*Generator, error) {
+	if secret == "" {
+		return nil, ErrEmptySecret
+	}
+	if window <= 0 {
+		window = DefaultWindow
+	}
+
+	h, err := crypto.NewHMAC(crypto.DeriveKeySHA256([]byte(secret), salt))
+	if err != nil {
+		return nil, err
+	}
+	return &Generator{hmac: h, window: window}, nil
+}
+
+// Token returns the token for base path at time t.
+func (g *Generator) Token(base string, t time.Time) string {
+	return g.token(base, t.UnixNano()/int64(g.window))
+}
+
+// Path returns base with the token for time t appended as the last segment.
+func (g *Generator) Path(base string, t time.Time) string {
+	return strings.TrimSuffix(base, "/") + "/" + g.Token(base, t)
+}
+
+// Valid reports whether token is valid for base at time now. Tokens from the
+// previous and next window are accepted to tolerate clock skew and dials that
+// straddle a window boundary.
+func (g *Generator) Valid(base, token string, now time.Time) bool {
+	current := now.UnixNano() / int64(g.window)
+	for _, slot := range []int64{current, current - 1, current + 1} {
+		if hmac.Equal([]byte(g.token(base, slot)), []byte(token)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (g *Generator) token(base string, slot int64) string {
+	data := make([]byte, 8, 8+len(base))
+	binary.BigEndian.PutUint64(data, uint64(slot))
+	data = append(data, strings.TrimSuffix(base, "/")...)
+	return base64.RawURLEncoding.EncodeToString(g.hmac.Sign(data)[:tokenSize])
+}
+
+// RandomPath returns prefix followed by a random path segment, for
+// per-deployment endpoint paths that cannot be guessed.
+func RandomPath(prefix string) (string, error) {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(prefix, "/") + "/" + hex.EncodeToString(buf), nil
+}
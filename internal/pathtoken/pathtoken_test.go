@@ -0,0 +1,104 @@
+package pathtoken
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTokenRotation(t *testing.T) {
+	g, err := New("secret", time.Minute)
+	if err != nil {
+		t.Fatalf("Failed to create generator: %v", err)
+	}
+
+	now := time.Unix(1700000000, 0)
+	token := g.Token("/ws/upstream", now)
+
+	if !g.Valid("/ws/upstream", token, now) {
+		t.Error("Expected current token to be valid")
+	}
+	if !g.Valid("/ws/upstream", token, now.Add(time.Minute)) {
+		t.Error("Expected token from previous window to be valid")
+	}
+	if !g.Valid("/ws/upstream", token, now.Add(-time.Minute)) {
+		t.Error("Expected token from next window to be valid")
+	}
+	if g.Valid("/ws/upstream", token, now.Add(3*time.Minute)) {
+		t.Error("Expected stale token to be rejected")
+	}
+	if g.Token("/ws/upstream", now.Add(2*time.Minute)) == token {
+		t.Error("Expected token to change between windows")
+	}
+}
+
+func TestTokenBoundToPathAndSecret(t *testing.T) {
+	g, _ := New("secret", time.Minute)
+	other, _ := New("other-secret", time.Minute)
+	now := time.Now()
+
+	token := g.Token("/ws/upstream", now)
+	if g.Valid("/ws/downstream", token, now) {
+		t.Error("Expected upstream token to be rejected on downstream path")
+	}
+	if other.Valid("/ws/upstream", token, now) {
+		t.Error("Expected token from another secret to be rejected")
+	}
+	if g.Valid("/ws/upstream", "", now) {
+		t.Error("Expected empty token to be rejected")
+	}
+}
+
+func TestPath(t *testing.T) {
+	g, _ := New("secret", 0)
+	now := time.Now()
+
+	path := g.Path("/ws/upstream/", now)
+	token := strings.TrimPrefix(path, "/ws/upstream/")
+	if token == path || strings.Contains(token, "/") {
+		t.Fatalf("Expected token appended as one segment, got %s", path)
+	}
+	if !g.Valid("/ws/upstream", token, now) {
+		t.Error("Expected path token to be valid")
+	}
+}
+
+func TestNewEmptySecret(t *testing.T) {
+	if _, err := New("", time.Minute); err != ErrEmptySecret {
+		t.Errorf("Expected ErrEmptySecret, got %v", err)
+	}
+}
+
+func TestRandomPath(t *testing.T) {
+	a, err := RandomPath("/ws/")
+	if err != nil {
+		t.Fatalf("Failed to generate path: %v", err)
+	}
+	b, _ := RandomPath("/ws")
+	if !strings.HasPrefix(a, "/ws/") || len(a) != len("/ws/")+16 {
+		t.Errorf("Unexpected random path: %s", a)
+	}
+	if a == b {
+		t.Error("Expected random paths to differ")
+	}
+}
+
+func TestCookieTokensDifferFromPathTokens(t *testing.T) {
+	paths, _ := New("secret", time.Minute)
+	cookies, err := NewCookie("secret", time.Minute)
+	if err != nil {
+		t.Fatalf("Failed to create cookie generator: %v", err)
+	}
+	now := time.Now()
+
+	token := cookies.Token("/ws/upstream", now)
+	if !cookies.Valid("/ws/upstream", token, now) {
+		t.Error("Expected cookie token to be valid")
+	}
+	if paths.Valid("/ws/upstream", token, now) {
+		t.Error("Expected cookie token to be rejected as a path token")
+	}
+	if _, err := NewCookie("", time.Minute); err != ErrEmptySecret {
+		t.Errorf("Expected ErrEmptySecret, got %v", err)
+	}
+}
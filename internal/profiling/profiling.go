@@ -0,0 +1,147 @@
+// Package profiling provides the opt-in debug server of Half-Tunnel clients
+// and servers, for profiling the forwarding loops in production:
+//
+//	GET  /debug/pprof/        net/http/pprof profiles (CPU, heap, goroutine,
+//	                          block, mutex, allocs, threadcreate) and traces
+//	GET  /debug/vars          expvar variables (memstats, cmdline)
+//	POST /debug/dump          write a goroutine dump and a heap profile to
+//	                          the dump directory
+//
+// The server has no authentication and exposes internals of the process, so
+// it should only listen on localhost.
+package profiling
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"path/filepath"
+	"runtime"
+	rpprof "runtime/pprof"
+	"time"
+)
+
+// ServerConfig holds debug server configuration.
+type ServerConfig struct {
+	// Addr is the address to listen on
+	Addr string
+	// DumpDir receives the files written by POST /debug/dump; empty means
+	// the temp directory
+	DumpDir string
+}
+
+// DefaultServerConfig returns default debug server configuration.
+func DefaultServerConfig() *ServerConfig {
+	return &ServerConfig{
+		Addr: "127.0.0.1:6060",
+	}
+}
+
+// Dump lists the files written by a dump.
+type Dump struct {
+	Goroutines string `json:"goroutines"`
+	Heap       string `json:"heap"`
+}
+
+// Server serves the debug endpoints.
+type Server struct {
+	dumpDir string
+	server  *http.Server
+}
+
+// NewServer creates a debug server.
+func NewServer(config *ServerConfig) *Server {
+	if config == nil {
+		config = DefaultServerConfig()
+	}
+
+	s := &Server{dumpDir: config.DumpDir}
+	if s.dumpDir == "" {
+		s.dumpDir = os.TempDir()
+	}
+	s.server = &http.Server{
+		Addr:              config.Addr,
+		Handler:           s.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+		// CPU profiles and traces run for as long as the request asks
+		WriteTimeout: 0,
+	}
+	return s
+}
+
+// Handler returns the debug handler.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("GET /debug/vars", expvar.Handler())
+	mux.HandleFunc("POST /debug/dump", func(w http.ResponseWriter, r *http.Request) {
+		dump, err := WriteDump(s.dumpDir)
+		w.Header().Set("Content-Type", "application/json")
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+		_ = json.NewEncoder(w).Encode(dump)
+	})
+	return mux
+}
+
+// WriteDump writes the stack traces of all goroutines and a heap profile,
+// taken after a garbage collection, into dir.
+func WriteDump(dir string) (*Dump, error) {
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, fmt.Errorf("failed to create dump directory: %w", err)
+	}
+	stamp := time.Now().Format("20060102-150405")
+	dump := &Dump{
+		Goroutines: filepath.Join(dir, fmt.Sprintf("half-tunnel-goroutines-%s-%d.txt", stamp, os.Getpid())),
+		Heap:       filepath.Join(dir, fmt.Sprintf("half-tunnel-heap-%s-%d.pprof", stamp, os.Getpid())),
+	}
+
+	if err := writeProfile(dump.Goroutines, "goroutine", 2); err != nil {
+		return nil, err
+	}
+	// Report live objects only, as of the last collection
+	runtime.GC()
+	if err := writeProfile(dump.Heap, "heap", 0); err != nil {
+		return nil, err
+	}
+	return dump, nil
+}
+
+// writeProfile writes a runtime profile to path.
+func writeProfile(path, name string, debug int) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to write %s dump: %w", name, err)
+	}
+	if err := rpprof.Lookup(name).WriteTo(f, debug); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write %s dump: %w", name, err)
+	}
+	return f.Close()
+}
+
+// Start starts the debug server.
+func (s *Server) Start() error {
+	return s.server.ListenAndServe()
+}
+
+// Shutdown gracefully shuts down the debug server.
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.server.Shutdown(ctx)
+}
+
+// Addr returns the server address.
+func (s *Server) Addr() string {
+	return s.server.Addr
+}
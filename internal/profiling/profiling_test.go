@@ -0,0 +1,74 @@
+package profiling
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestEndpoints(t *testing.T) {
+	ts := httptest.NewServer(NewServer(&ServerConfig{DumpDir: t.TempDir()}).Handler())
+	defer ts.Close()
+
+	tests := []struct {
+		path     string
+		contains string
+	}{
+		{"/debug/pprof/", "goroutine"},
+		{"/debug/pprof/goroutine?debug=1", "goroutine profile"},
+		{"/debug/pprof/cmdline", ""},
+		{"/debug/vars", "memstats"},
+	}
+	for _, tc := range tests {
+		resp, err := http.Get(ts.URL + tc.path)
+		if err != nil {
+			t.Fatalf("GET %s: %v", tc.path, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("GET %s: expected 200, got %d", tc.path, resp.StatusCode)
+		}
+		if !strings.Contains(string(body), tc.contains) {
+			t.Errorf("GET %s: expected %q in the response", tc.path, tc.contains)
+		}
+	}
+}
+
+func TestDump(t *testing.T) {
+	dir := t.TempDir()
+	ts := httptest.NewServer(NewServer(&ServerConfig{DumpDir: dir}).Handler())
+	defer ts.Close()
+
+	if resp, err := http.Get(ts.URL + "/debug/dump"); err == nil {
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusMethodNotAllowed {
+			t.Errorf("Expected GET /debug/dump to be rejected, got %d", resp.StatusCode)
+		}
+	}
+
+	resp, err := http.Post(ts.URL+"/debug/dump", "", nil)
+	if err != nil {
+		t.Fatalf("Failed to trigger dump: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var dump Dump
+	if err := json.NewDecoder(resp.Body).Decode(&dump); err != nil {
+		t.Fatalf("Failed to decode dump: %v", err)
+	}
+	goroutines, err := os.ReadFile(dump.Goroutines)
+	if err != nil || !strings.Contains(string(goroutines), "goroutine ") {
+		t.Errorf("Expected a goroutine dump in %s: %v", dump.Goroutines, err)
+	}
+	if info, err := os.Stat(dump.Heap); err != nil || info.Size() == 0 {
+		t.Errorf("Expected a heap profile in %s: %v", dump.Heap, err)
+	}
+	if !strings.HasPrefix(dump.Heap, dir) {
+		t.Errorf("Expected the dump in %s, got %s", dir, dump.Heap)
+	}
+}
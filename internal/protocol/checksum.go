@@ -0,0 +1,42 @@
+package protocol
+
+import "encoding/binary"
+
+// ChecksumSize is the size of the checksum trailer.
+const ChecksumSize = 4
+
+// SetChecksum makes the packet carry its header checksum (see
+// CalculateHeaderChecksum) in a trailer after the payload and HMAC. Peers
+// add it only on sessions that negotiated CapChecksum. Packets are framed
+// by WebSocket messages, so receivers find the trailer from the message
+// length.
+func (p *Packet) SetChecksum() {
+	p.Checksum = p.CalculateHeaderChecksum()
+	p.HasChecksum = true
+}
+
+// ChecksumValid reports whether the packet's checksum trailer, if any,
+// matches its contents. For packets with the compact header, the session
+// ID must be resolved first.
+func (p *Packet) ChecksumValid() bool {
+	return !p.HasChecksum || p.VerifyHeaderChecksum(p.Checksum)
+}
+
+// appendChecksum appends the checksum trailer, if any, to a marshaled
+// packet.
+func (p *Packet) appendChecksum(buf []byte) []byte {
+	if !p.HasChecksum {
+		return buf
+	}
+	return binary.BigEndian.AppendUint32(buf, p.Checksum)
+}
+
+// readChecksum reads the checksum trailer of a packet whose payload and
+// HMAC end at end, if the data has one.
+func (p *Packet) readChecksum(data []byte, end int) {
+	if len(data) != end+ChecksumSize {
+		return
+	}
+	p.Checksum = binary.BigEndian.Uint32(data[end:])
+	p.HasChecksum = true
+}
@@ -0,0 +1,96 @@
+package protocol
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestChecksumRoundTrip(t *testing.T) {
+	tests := []struct {
+		name  string
+		index uint32
+		flags Flag
+	}{
+		{"full header", 0, FlagData},
+		{"compact header", 7, FlagData},
+		{"hmac", 0, FlagData | FlagHMAC},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sessionID := uuid.New()
+			pkt, err := NewPacket(sessionID, 3, tt.flags, []byte("payload"))
+			if err != nil {
+				t.Fatalf("NewPacket failed: %v", err)
+			}
+			pkt.SeqNum = 9
+			pkt.SessionIndex = tt.index
+			pkt.SetChecksum()
+
+			data, err := pkt.Marshal()
+			if err != nil {
+				t.Fatalf("Marshal failed: %v", err)
+			}
+			decoded, err := Unmarshal(data)
+			if err != nil {
+				t.Fatalf("Unmarshal failed: %v", err)
+			}
+			if decoded.IsCompact() {
+				decoded.SessionID = sessionID
+			}
+
+			if !decoded.HasChecksum || decoded.Checksum != pkt.Checksum {
+				t.Errorf("Expected checksum %08x, got %08x (present: %v)", pkt.Checksum, decoded.Checksum, decoded.HasChecksum)
+			}
+			if !decoded.ChecksumValid() {
+				t.Error("Expected valid checksum")
+			}
+		})
+	}
+}
+
+func TestChecksumDetectsCorruption(t *testing.T) {
+	pkt, err := NewPacket(uuid.New(), 3, FlagData, []byte("payload"))
+	if err != nil {
+		t.Fatalf("NewPacket failed: %v", err)
+	}
+	pkt.SetChecksum()
+	data, err := pkt.Marshal()
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+
+	// Flip a bit in the payload
+	data[HeaderSize] ^= 0x01
+
+	decoded, err := Unmarshal(data)
+	if err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if decoded.ChecksumValid() {
+		t.Error("Expected checksum mismatch")
+	}
+}
+
+func TestChecksumAbsentByDefault(t *testing.T) {
+	pkt, err := NewPacket(uuid.New(), 3, FlagData, []byte("payload"))
+	if err != nil {
+		t.Fatalf("NewPacket failed: %v", err)
+	}
+	data, err := pkt.Marshal()
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	if len(data) != HeaderSize+len(pkt.Payload) {
+		t.Errorf("Expected %d bytes without trailer, got %d", HeaderSize+len(pkt.Payload), len(data))
+	}
+
+	decoded, err := Unmarshal(data)
+	if err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if decoded.HasChecksum || !decoded.ChecksumValid() {
+		t.Errorf("Expected no checksum, got %08x", decoded.Checksum)
+	}
+}
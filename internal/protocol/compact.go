@@ -0,0 +1,160 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/google/uuid"
+)
+
+// Magic bytes of packets with the compact header ('h', 't').
+const (
+	CompactMagicByte1 byte = 0x68
+	CompactMagicByte2 byte = 0x74
+)
+
+// Compact header sizes: magic, version, flags and session index, followed
+// by stream ID, sequence number, ack number and payload length as uvarints.
+const (
+	CompactHeaderMinSize = 2 + 1 + 1 + 4 + 1 + 1 + 1 + 1 // 12 bytes
+	CompactHeaderMaxSize = 2 + 1 + 1 + 4 + 5 + 5 + 5 + 3 // 26 bytes
+)
+
+// Compact header errors
+var (
+	ErrInvalidSessionIndex = errors.New("invalid session index")
+	ErrInvalidHeader       = errors.New("invalid packet header")
+)
+
+// compactFixedSize is the size of the fixed-width part of the compact header.
+const compactFixedSize = 2 + 1 + 1 + 4
+
+// IsCompact reports whether the packet uses the compact header, which
+// replaces the session UUID with the session index the server assigned in
+// its hello and encodes the remaining fields as varints. Sessions use it
+// only after both sides announced CapCompactHeader.
+func (p *Packet) IsCompact() bool {
+	return p.SessionIndex != 0
+}
+
+// NewJumboPacket creates a packet like NewPacket, but with a payload of up
+// to MaxJumboPayloadSize. Payloads above MaxPayloadSize can only be sent
+// with the compact header.
+func NewJumboPacket(sessionID uuid.UUID, streamID uint32, flags Flag, payload []byte) (*Packet, error) {
+	if len(payload) > MaxJumboPayloadSize {
+		return nil, ErrPayloadTooLarge
+	}
+	if len(payload) <= MaxPayloadSize {
+		return NewPacket(sessionID, streamID, flags, payload)
+	}
+	return &Packet{
+		Magic:      [2]byte{MagicByte1, MagicByte2},
+		Version:    Version,
+		Flags:      flags,
+		SessionID:  sessionID,
+		StreamID:   streamID,
+		PayloadLen: uint32(len(payload)),
+		Payload:    payload,
+	}, nil
+}
+
+// marshalCompact serializes the packet with the compact header.
+func (p *Packet) marshalCompact() ([]byte, error) {
+	if p.PayloadLen > MaxJumboPayloadSize {
+		return nil, ErrPayloadTooLarge
+	}
+	size := CompactHeaderMaxSize + int(p.PayloadLen) + ChecksumSize
+	if p.Flags&FlagHMAC != 0 {
+		size += HMACSize
+	}
+
+	buf := make([]byte, compactFixedSize, size)
+	buf[0] = CompactMagicByte1
+	buf[1] = CompactMagicByte2
+	buf[2] = p.Version
+	buf[3] = byte(p.Flags)
+	binary.BigEndian.PutUint32(buf[4:], p.SessionIndex)
+
+	buf = binary.AppendUvarint(buf, uint64(p.StreamID))
+	buf = binary.AppendUvarint(buf, uint64(p.SeqNum))
+	buf = binary.AppendUvarint(buf, uint64(p.AckNum))
+	buf = binary.AppendUvarint(buf, uint64(p.PayloadLen))
+	offset := len(buf)
+	buf = buf[:offset+int(p.PayloadLen)]
+	copy(buf[offset:], p.Payload)
+
+	if p.Flags&FlagHMAC != 0 {
+		hmac := make([]byte, HMACSize)
+		if len(p.HMAC) == HMACSize {
+			copy(hmac, p.HMAC)
+		}
+		buf = append(buf, hmac...)
+	}
+	return p.appendChecksum(buf), nil
+}
+
+// unmarshalCompact deserializes a packet with the compact header. The
+// packet's SessionID is left zero for the receiver to resolve from
+// SessionIndex.
+func unmarshalCompact(data []byte) (*Packet, error) {
+	if len(data) < CompactHeaderMinSize {
+		return nil, ErrInsufficientData
+	}
+
+	p := &Packet{
+		Magic:        [2]byte{data[0], data[1]},
+		Version:      data[2],
+		Flags:        Flag(data[3]),
+		SessionIndex: binary.BigEndian.Uint32(data[4:]),
+	}
+	if p.Version < MinVersion || p.Version > Version {
+		return nil, ErrInvalidVersion
+	}
+	if p.SessionIndex == 0 {
+		return nil, ErrInvalidSessionIndex
+	}
+
+	offset := compactFixedSize
+	var fields [4]uint64
+	limits := [4]uint64{1<<32 - 1, 1<<32 - 1, 1<<32 - 1, MaxJumboPayloadSize}
+	for i := range fields {
+		v, n := binary.Uvarint(data[offset:])
+		if n == 0 {
+			return nil, ErrInsufficientData
+		}
+		if n < 0 || v > limits[i] {
+			return nil, ErrInvalidHeader
+		}
+		fields[i] = v
+		offset += n
+	}
+	p.StreamID = uint32(fields[0])
+	p.SeqNum = uint32(fields[1])
+	p.AckNum = uint32(fields[2])
+	p.PayloadLen = uint32(fields[3])
+
+	expectedSize := offset + int(p.PayloadLen)
+	if p.Flags&FlagHMAC != 0 {
+		expectedSize += HMACSize
+	}
+	if len(data) < expectedSize {
+		return nil, ErrInsufficientData
+	}
+
+	if p.PayloadLen > 0 {
+		p.Payload = make([]byte, p.PayloadLen)
+		copy(p.Payload, data[offset:offset+int(p.PayloadLen)])
+		offset += int(p.PayloadLen)
+	}
+	if p.Flags&FlagHMAC != 0 {
+		p.HMAC = make([]byte, HMACSize)
+		copy(p.HMAC, data[offset:offset+HMACSize])
+	}
+	p.readChecksum(data, expectedSize)
+	return p, nil
+}
+
+// isCompactData reports whether data starts with the compact header magic.
+func isCompactData(data []byte) bool {
+	return len(data) >= 2 && data[0] == CompactMagicByte1 && data[1] == CompactMagicByte2
+}
@@ -0,0 +1,160 @@
+package protocol
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestCompactPacketRoundTrip(t *testing.T) {
+	tests := []struct {
+		name    string
+		flags   Flag
+		payload []byte
+	}{
+		{"data", FlagData, []byte("hello")},
+		{"empty", FlagFin, nil},
+		{"hmac", FlagData | FlagHMAC, []byte("signed")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pkt, err := NewPacket(uuid.New(), 300, tt.flags, tt.payload)
+			if err != nil {
+				t.Fatalf("NewPacket failed: %v", err)
+			}
+			pkt.SeqNum = 1 << 20
+			pkt.AckNum = 5
+			pkt.SessionIndex = 42
+			if tt.flags&FlagHMAC != 0 {
+				pkt.HMAC = bytes.Repeat([]byte{0xab}, HMACSize)
+			}
+
+			data, err := pkt.Marshal()
+			if err != nil {
+				t.Fatalf("Marshal failed: %v", err)
+			}
+			decoded, err := Unmarshal(data)
+			if err != nil {
+				t.Fatalf("Unmarshal failed: %v", err)
+			}
+
+			if !decoded.IsCompact() || decoded.SessionIndex != 42 {
+				t.Errorf("Expected compact packet with index 42, got %d", decoded.SessionIndex)
+			}
+			if decoded.SessionID != uuid.Nil {
+				t.Errorf("Expected no session ID, got %s", decoded.SessionID)
+			}
+			if decoded.Flags != pkt.Flags || decoded.StreamID != pkt.StreamID ||
+				decoded.SeqNum != pkt.SeqNum || decoded.AckNum != pkt.AckNum {
+				t.Errorf("Header mismatch: got %+v, want %+v", decoded, pkt)
+			}
+			if !bytes.Equal(decoded.Payload, pkt.Payload) {
+				t.Errorf("Expected payload %q, got %q", pkt.Payload, decoded.Payload)
+			}
+			if !bytes.Equal(decoded.HMAC, pkt.HMAC) {
+				t.Errorf("Expected HMAC %x, got %x", pkt.HMAC, decoded.HMAC)
+			}
+		})
+	}
+}
+
+func TestCompactHeaderSize(t *testing.T) {
+	pkt, _ := NewPacket(uuid.New(), 1, FlagData, []byte("x"))
+	full, _ := pkt.Marshal()
+	pkt.SessionIndex = 1
+	compact, _ := pkt.Marshal()
+
+	if len(full) != HeaderSize+1 {
+		t.Errorf("Expected full packet of %d bytes, got %d", HeaderSize+1, len(full))
+	}
+	if len(compact) != CompactHeaderMinSize+1 {
+		t.Errorf("Expected compact packet of %d bytes, got %d", CompactHeaderMinSize+1, len(compact))
+	}
+}
+
+func TestUnmarshalCompactErrors(t *testing.T) {
+	pkt, _ := NewPacket(uuid.New(), 1, FlagData, []byte("payload"))
+	pkt.SessionIndex = 7
+	valid, _ := pkt.Marshal()
+
+	zeroIndex := append([]byte(nil), valid...)
+	copy(zeroIndex[4:8], []byte{0, 0, 0, 0})
+	badVersion := append([]byte(nil), valid...)
+	badVersion[2] = 0xff
+	overflow := append(append([]byte(nil), valid[:compactFixedSize]...), 0xff, 0xff, 0xff, 0xff, 0xff, 0x01, 0, 0, 0)
+
+	tests := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"truncated header", valid[:CompactHeaderMinSize-1], ErrInsufficientData},
+		{"truncated payload", valid[:len(valid)-1], ErrInsufficientData},
+		{"zero index", zeroIndex, ErrInvalidSessionIndex},
+		{"bad version", badVersion, ErrInvalidVersion},
+		{"stream ID overflow", overflow, ErrInvalidHeader},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Unmarshal(tt.data); err != tt.want {
+				t.Errorf("Expected %v, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestHelloSessionIndex(t *testing.T) {
+	hello := Hello{Version: Version, Capabilities: CapCompactHeader, SessionIndex: 9, MaxPayload: 1 << 20}
+
+	got, err := ParseHello(hello.Marshal())
+	if err != nil {
+		t.Fatalf("ParseHello failed: %v", err)
+	}
+	if got != hello {
+		t.Errorf("Expected %+v, got %+v", hello, got)
+	}
+	if len((Hello{Version: Version}).Marshal()) != helloSize {
+		t.Errorf("Expected a hello without index to be %d bytes", helloSize)
+	}
+
+	// A hello offering a payload size has no index yet
+	offer := Hello{Version: Version, MaxPayload: MaxPayloadSize}
+	if got, _ := ParseHello(offer.Marshal()); got != offer {
+		t.Errorf("Expected %+v, got %+v", offer, got)
+	}
+}
+
+func TestJumboPacketRoundTrip(t *testing.T) {
+	payload := bytes.Repeat([]byte{0x5a}, MaxPayloadSize+1000)
+
+	if _, err := NewPacket(uuid.New(), 1, FlagData, payload); err != ErrPayloadTooLarge {
+		t.Errorf("Expected NewPacket to reject a jumbo payload, got %v", err)
+	}
+	pkt, err := NewJumboPacket(uuid.New(), 1, FlagData, payload)
+	if err != nil {
+		t.Fatalf("NewJumboPacket failed: %v", err)
+	}
+	if _, err := pkt.Marshal(); err != ErrPayloadTooLarge {
+		t.Errorf("Expected the full header to reject a jumbo payload, got %v", err)
+	}
+
+	pkt.SessionIndex = 1
+	data, err := pkt.Marshal()
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	decoded, err := Unmarshal(data)
+	if err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if !bytes.Equal(decoded.Payload, payload) {
+		t.Errorf("Expected a %d-byte payload, got %d bytes", len(payload), len(decoded.Payload))
+	}
+
+	if _, err := NewJumboPacket(uuid.New(), 1, FlagData, make([]byte, MaxJumboPayloadSize+1)); err != ErrPayloadTooLarge {
+		t.Errorf("Expected ErrPayloadTooLarge above MaxJumboPayloadSize, got %v", err)
+	}
+}
@@ -0,0 +1,214 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/google/uuid"
+)
+
+// FlagControl marks a session-level control message carried on StreamID 0.
+// The payload starts with a ControlType byte followed by a type-specific body.
+const FlagControl Flag = 0x40
+
+// ControlType identifies the kind of control message.
+type ControlType byte
+
+const (
+	// ControlSessionWarning warns that a session limit is about to be reached.
+	ControlSessionWarning ControlType = 0x01
+	// ControlSessionExpired reports that the server closed the session because a limit was reached.
+	ControlSessionExpired ControlType = 0x02
+	// ControlSessionRejected reports that the server refused a new session because a limit was reached.
+	ControlSessionRejected ControlType = 0x03
+	// ControlStreamError reports why the server could not serve a stream. The
+	// stream's FIN follows it.
+	ControlStreamError ControlType = 0x04
+	// ControlHello negotiates the protocol version and capabilities of a
+	// session.
+	ControlHello ControlType = 0x05
+	// ControlPadding carries random bytes that the receiver discards, as
+	// cover traffic.
+	ControlPadding ControlType = 0x08
+)
+
+// LimitReason identifies which session limit a warning or expiry refers to.
+type LimitReason byte
+
+const (
+	// LimitTTL is the session lifetime; Remaining is in seconds.
+	LimitTTL LimitReason = 0x01
+	// LimitTraffic is the traffic cap; Remaining is in bytes.
+	LimitTraffic LimitReason = 0x02
+	// LimitSessions is the server's session limit; Remaining is unused.
+	LimitSessions LimitReason = 0x03
+	// LimitIdle is the server's idle session timeout; Remaining is unused.
+	LimitIdle LimitReason = 0x04
+)
+
+// String returns the string representation of the reason.
+func (r LimitReason) String() string {
+	switch r {
+	case LimitTTL:
+		return "ttl"
+	case LimitTraffic:
+		return "traffic"
+	case LimitSessions:
+		return "sessions"
+	case LimitIdle:
+		return "idle"
+	default:
+		return "unknown"
+	}
+}
+
+// sessionLimitSize is the encoded size of a SessionLimit: reason + remaining.
+const sessionLimitSize = 1 + 8
+
+// ErrInvalidControl is returned for malformed control messages.
+var ErrInvalidControl = errors.New("invalid control message")
+
+// SessionLimit is the body of ControlSessionWarning, ControlSessionExpired and
+// ControlSessionRejected messages.
+type SessionLimit struct {
+	Reason    LimitReason
+	Remaining uint64
+}
+
+// NewControlPacket creates a control packet with the given type and body.
+func NewControlPacket(sessionID uuid.UUID, ctrl ControlType, body []byte) (*Packet, error) {
+	payload := make([]byte, 0, 1+len(body))
+	payload = append(payload, byte(ctrl))
+	payload = append(payload, body...)
+	return NewPacket(sessionID, 0, FlagControl, payload)
+}
+
+// NewSessionLimitPacket creates a session warning or expiry control packet.
+func NewSessionLimitPacket(sessionID uuid.UUID, ctrl ControlType, limit SessionLimit) (*Packet, error) {
+	return NewControlPacket(sessionID, ctrl, limit.Marshal())
+}
+
+// IsControlMessage returns true if the packet carries a control message.
+func (p *Packet) IsControlMessage() bool {
+	return p.Flags&FlagControl != 0
+}
+
+// ParseControl splits a control packet into its type and body.
+func ParseControl(p *Packet) (ControlType, []byte, error) {
+	if !p.IsControlMessage() || len(p.Payload) < 1 {
+		return 0, nil, ErrInvalidControl
+	}
+	return ControlType(p.Payload[0]), p.Payload[1:], nil
+}
+
+// Marshal encodes the session limit.
+func (l SessionLimit) Marshal() []byte {
+	buf := make([]byte, sessionLimitSize)
+	buf[0] = byte(l.Reason)
+	binary.BigEndian.PutUint64(buf[1:], l.Remaining)
+	return buf
+}
+
+// ParseSessionLimit decodes a session limit body.
+func ParseSessionLimit(body []byte) (SessionLimit, error) {
+	if len(body) < sessionLimitSize {
+		return SessionLimit{}, ErrInvalidControl
+	}
+	return SessionLimit{
+		Reason:    LimitReason(body[0]),
+		Remaining: binary.BigEndian.Uint64(body[1:]),
+	}, nil
+}
+
+// StreamErrorCode identifies why a stream failed on the server.
+type StreamErrorCode byte
+
+const (
+	// StreamErrorConnectFailed is a destination connect failure not covered
+	// by a more specific code.
+	StreamErrorConnectFailed StreamErrorCode = 0x01
+	// StreamErrorTimeout means the destination did not answer in time.
+	StreamErrorTimeout StreamErrorCode = 0x02
+	// StreamErrorRefused means the destination refused the connection.
+	StreamErrorRefused StreamErrorCode = 0x03
+	// StreamErrorUnreachable means there is no route to the destination.
+	StreamErrorUnreachable StreamErrorCode = 0x04
+	// StreamErrorHostNotFound means the destination name did not resolve.
+	StreamErrorHostNotFound StreamErrorCode = 0x05
+	// StreamErrorCircuitOpen means the destination failed repeatedly and the
+	// server is not dialing it for now.
+	StreamErrorCircuitOpen StreamErrorCode = 0x06
+	// StreamErrorBlocked means the server's destination policy does not
+	// allow the connection.
+	StreamErrorBlocked StreamErrorCode = 0x07
+	// StreamErrorQuotaExceeded means the client has used up its traffic
+	// quota.
+	StreamErrorQuotaExceeded StreamErrorCode = 0x08
+	// StreamErrorOverloaded means the server is close to a resource limit
+	// and refuses new streams for now.
+	StreamErrorOverloaded StreamErrorCode = 0x09
+)
+
+// String returns the string representation of the code.
+func (c StreamErrorCode) String() string {
+	switch c {
+	case StreamErrorConnectFailed:
+		return "connect_failed"
+	case StreamErrorTimeout:
+		return "timeout"
+	case StreamErrorRefused:
+		return "refused"
+	case StreamErrorUnreachable:
+		return "unreachable"
+	case StreamErrorHostNotFound:
+		return "host_not_found"
+	case StreamErrorCircuitOpen:
+		return "circuit_open"
+	case StreamErrorBlocked:
+		return "blocked"
+	case StreamErrorQuotaExceeded:
+		return "quota_exceeded"
+	case StreamErrorOverloaded:
+		return "overloaded"
+	default:
+		return "unknown"
+	}
+}
+
+// streamErrorSize is the encoded size of a StreamError: stream ID + code +
+// attempts.
+const streamErrorSize = 4 + 1 + 2
+
+// StreamError is the body of ControlStreamError messages.
+type StreamError struct {
+	StreamID uint32
+	Code     StreamErrorCode
+	// Attempts is the number of destination dials made (0 if none were)
+	Attempts uint16
+}
+
+// NewStreamErrorPacket creates a stream error control packet.
+func NewStreamErrorPacket(sessionID uuid.UUID, e StreamError) (*Packet, error) {
+	return NewControlPacket(sessionID, ControlStreamError, e.Marshal())
+}
+
+// Marshal encodes the stream error.
+func (e StreamError) Marshal() []byte {
+	buf := make([]byte, streamErrorSize)
+	binary.BigEndian.PutUint32(buf[0:4], e.StreamID)
+	buf[4] = byte(e.Code)
+	binary.BigEndian.PutUint16(buf[5:7], e.Attempts)
+	return buf
+}
+
+// ParseStreamError decodes a stream error body.
+func ParseStreamError(body []byte) (StreamError, error) {
+	if len(body) < streamErrorSize {
+		return StreamError{}, ErrInvalidControl
+	}
+	return StreamError{
+		StreamID: binary.BigEndian.Uint32(body[0:4]),
+		Code:     StreamErrorCode(body[4]),
+		Attempts: binary.BigEndian.Uint16(body[5:7]),
+	}, nil
+}
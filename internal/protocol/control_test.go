@@ -0,0 +1,107 @@
+package protocol
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestSessionLimitPacketRoundTrip(t *testing.T) {
+	sessionID := uuid.New()
+	limit := SessionLimit{Reason: LimitTraffic, Remaining: 1 << 33}
+
+	pkt, err := NewSessionLimitPacket(sessionID, ControlSessionWarning, limit)
+	if err != nil {
+		t.Fatalf("NewSessionLimitPacket failed: %v", err)
+	}
+
+	data, err := pkt.Marshal()
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	decoded, err := Unmarshal(data)
+	if err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+
+	if !decoded.IsControlMessage() {
+		t.Fatal("Expected control message")
+	}
+	if decoded.StreamID != 0 {
+		t.Errorf("Expected StreamID 0, got %d", decoded.StreamID)
+	}
+	if decoded.PacketType() != "CONTROL" {
+		t.Errorf("PacketType should be CONTROL, got %s", decoded.PacketType())
+	}
+
+	ctrl, body, err := ParseControl(decoded)
+	if err != nil {
+		t.Fatalf("ParseControl failed: %v", err)
+	}
+	if ctrl != ControlSessionWarning {
+		t.Errorf("Expected ControlSessionWarning, got %d", ctrl)
+	}
+
+	got, err := ParseSessionLimit(body)
+	if err != nil {
+		t.Fatalf("ParseSessionLimit failed: %v", err)
+	}
+	if got != limit {
+		t.Errorf("Expected %+v, got %+v", limit, got)
+	}
+}
+
+func TestParseControlInvalid(t *testing.T) {
+	pkt, _ := NewPacket(uuid.New(), 0, FlagData, []byte{0x01})
+	if _, _, err := ParseControl(pkt); err != ErrInvalidControl {
+		t.Errorf("Expected ErrInvalidControl for non-control packet, got %v", err)
+	}
+
+	pkt, _ = NewPacket(uuid.New(), 0, FlagControl, nil)
+	if _, _, err := ParseControl(pkt); err != ErrInvalidControl {
+		t.Errorf("Expected ErrInvalidControl for empty payload, got %v", err)
+	}
+
+	if _, err := ParseSessionLimit([]byte{0x01, 0x00}); err != ErrInvalidControl {
+		t.Errorf("Expected ErrInvalidControl for short body, got %v", err)
+	}
+}
+
+func TestStreamErrorPacketRoundTrip(t *testing.T) {
+	streamErr := StreamError{StreamID: 42, Code: StreamErrorRefused, Attempts: 3}
+
+	pkt, err := NewStreamErrorPacket(uuid.New(), streamErr)
+	if err != nil {
+		t.Fatalf("NewStreamErrorPacket failed: %v", err)
+	}
+	data, err := pkt.Marshal()
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	decoded, err := Unmarshal(data)
+	if err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+
+	ctrl, body, err := ParseControl(decoded)
+	if err != nil {
+		t.Fatalf("ParseControl failed: %v", err)
+	}
+	if ctrl != ControlStreamError {
+		t.Errorf("Expected ControlStreamError, got %d", ctrl)
+	}
+	got, err := ParseStreamError(body)
+	if err != nil {
+		t.Fatalf("ParseStreamError failed: %v", err)
+	}
+	if got != streamErr {
+		t.Errorf("Expected %+v, got %+v", streamErr, got)
+	}
+	if got.Code.String() != "refused" {
+		t.Errorf("Expected code refused, got %s", got.Code)
+	}
+
+	if _, err := ParseStreamError([]byte{0, 0, 0, 42}); err != ErrInvalidControl {
+		t.Errorf("Expected ErrInvalidControl for short body, got %v", err)
+	}
+}
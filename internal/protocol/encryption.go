@@ -2,13 +2,28 @@
 package protocol
 
 import (
+	"crypto/subtle"
+	"encoding/binary"
+	"sync/atomic"
+
+	"github.com/google/uuid"
 	"github.com/sahmadiut/half-tunnel/pkg/crypto"
 )
 
+// ReplayCounterSize is the size of the replay counter at the start of the
+// HMAC field; the HMAC tag, truncated, fills the rest.
+const ReplayCounterSize = 8
+
 // PacketCrypto provides encryption and authentication for packets.
+// Authenticated packets are also checked against per-session replay
+// windows, so a recorded packet is accepted only once.
 type PacketCrypto struct {
 	cipher *crypto.AESGCMCipher
 	hmac   *crypto.HMAC
+
+	signed   uint64 // replay counter of the last signed packet, updated atomically
+	replay   replayFilter
+	onReplay func(p *Packet)
 }
 
 // NewPacketCrypto creates a new PacketCrypto with the given encryption and HMAC keys.
@@ -76,7 +91,7 @@ func (pc *PacketCrypto) EncryptPacket(p *Packet) (*Packet, error) {
 	// Create new packet with encrypted payload
 	encrypted := copyPacket(p)
 	encrypted.Payload = encryptedPayload
-	encrypted.PayloadLen = uint16(len(encryptedPayload))
+	encrypted.PayloadLen = uint32(len(encryptedPayload))
 
 	return encrypted, nil
 }
@@ -102,14 +117,16 @@ func (pc *PacketCrypto) DecryptPacket(p *Packet) (*Packet, error) {
 	// Create new packet with decrypted payload
 	decrypted := copyPacket(p)
 	decrypted.Payload = decryptedPayload
-	decrypted.PayloadLen = uint16(len(decryptedPayload))
+	decrypted.PayloadLen = uint32(len(decryptedPayload))
 
 	return decrypted, nil
 }
 
 // SignPacket adds HMAC authentication to the packet.
 // The HMAC is computed over the entire packet (header + payload) and stored in the HMAC field.
-// The FlagHMAC is set to indicate the presence of HMAC.
+// The FlagHMAC is set to indicate the presence of HMAC. The HMAC field
+// starts with the packet's replay counter, which the tag covers too; every
+// packet signed by pc gets the next counter.
 func (pc *PacketCrypto) SignPacket(p *Packet) (*Packet, error) {
 	if pc.hmac == nil {
 		// No HMAC configured, return copy of original
@@ -131,11 +148,25 @@ func (pc *PacketCrypto) SignPacket(p *Packet) (*Packet, error) {
 	}
 
 	// Compute HMAC
-	signed.HMAC = pc.hmac.Sign(dataToSign)
+	counter := atomic.AddUint64(&pc.signed, 1)
+	signed.HMAC = make([]byte, HMACSize)
+	binary.BigEndian.PutUint64(signed.HMAC, counter)
+	copy(signed.HMAC[ReplayCounterSize:], pc.tag(dataToSign, counter))
 
 	return signed, nil
 }
 
+// tag returns the truncated HMAC tag of data signed with counter.
+func (pc *PacketCrypto) tag(data []byte, counter uint64) []byte {
+	signed := binary.BigEndian.AppendUint64(data, counter)
+	return pc.hmac.Sign(signed)[:HMACSize-ReplayCounterSize]
+}
+
+// replayCounter returns the replay counter of a signed packet.
+func replayCounter(p *Packet) uint64 {
+	return binary.BigEndian.Uint64(p.HMAC)
+}
+
 // VerifyPacket verifies the HMAC of the packet.
 // Returns true if the HMAC is valid or if no HMAC is present.
 func (pc *PacketCrypto) VerifyPacket(p *Packet) bool {
@@ -159,7 +190,8 @@ func (pc *PacketCrypto) VerifyPacket(p *Packet) bool {
 		return false
 	}
 
-	return pc.hmac.Verify(dataToVerify, p.HMAC)
+	tag := pc.tag(dataToVerify, replayCounter(p))
+	return subtle.ConstantTimeCompare(tag, p.HMAC[ReplayCounterSize:]) == 1
 }
 
 // EncryptAndSign encrypts the payload and signs the packet.
@@ -174,15 +206,41 @@ func (pc *PacketCrypto) EncryptAndSign(p *Packet) (*Packet, error) {
 }
 
 // VerifyAndDecrypt verifies the packet signature and decrypts the payload.
-// Returns an error if verification fails.
+// Returns an error if verification fails. With an HMAC key, packets without
+// FlagHMAC fail verification too, so clearing the flag bypasses neither the
+// HMAC nor the replay check. A signed packet is accepted once per replay
+// counter and session, control packets on stream 0 included; replays, and
+// packets older than the session's replay window, return
+// ErrReplayedPacket. Compact packets must have their SessionID resolved
+// first.
 func (pc *PacketCrypto) VerifyAndDecrypt(p *Packet) (*Packet, error) {
-	if !pc.VerifyPacket(p) {
+	if (pc.hmac != nil && !p.HasHMAC()) || !pc.VerifyPacket(p) {
 		return nil, ErrHMACVerificationFailed
 	}
 
+	if pc.hmac != nil && !pc.replay.check(p.SessionID, replayCounter(p)) {
+		if pc.onReplay != nil {
+			pc.onReplay(p)
+		}
+		return nil, ErrReplayedPacket
+	}
+
 	return pc.DecryptPacket(p)
 }
 
+// SetOnReplay sets a callback invoked for every packet VerifyAndDecrypt
+// rejects as a replay, e.g. to count them in metrics.
+func (pc *PacketCrypto) SetOnReplay(fn func(p *Packet)) {
+	pc.onReplay = fn
+}
+
+// ForgetSession drops the replay window of a closed session. Replayed
+// packets of the session then pass VerifyAndDecrypt again, so the caller
+// must ignore packets of sessions it closed.
+func (pc *PacketCrypto) ForgetSession(sessionID uuid.UUID) {
+	pc.replay.forgetSession(sessionID)
+}
+
 // ErrHMACVerificationFailed is returned when HMAC verification fails.
 var ErrHMACVerificationFailed = errHMACVerificationFailed{}
 
@@ -195,14 +253,15 @@ func (errHMACVerificationFailed) Error() string {
 // copyPacket creates a deep copy of a packet.
 func copyPacket(p *Packet) *Packet {
 	newPacket := &Packet{
-		Magic:      p.Magic,
-		Version:    p.Version,
-		Flags:      p.Flags,
-		SessionID:  p.SessionID,
-		StreamID:   p.StreamID,
-		SeqNum:     p.SeqNum,
-		AckNum:     p.AckNum,
-		PayloadLen: p.PayloadLen,
+		Magic:        p.Magic,
+		Version:      p.Version,
+		Flags:        p.Flags,
+		SessionID:    p.SessionID,
+		StreamID:     p.StreamID,
+		SeqNum:       p.SeqNum,
+		AckNum:       p.AckNum,
+		PayloadLen:   p.PayloadLen,
+		SessionIndex: p.SessionIndex,
 	}
 
 	if len(p.Payload) > 0 {
@@ -219,9 +278,25 @@ func copyPacket(p *Packet) *Packet {
 }
 
 // marshalWithoutHMAC marshals the packet header and payload without the HMAC field.
-// This is used for computing HMAC signatures.
+// This is used for computing HMAC signatures. Compact packets are signed as
+// sent, without their checksum trailer; other packets in the full header
+// layout with a 4-byte PayloadLen, so the length of jumbo payloads is
+// signed in full.
 func marshalWithoutHMAC(p *Packet) ([]byte, error) {
-	size := HeaderSize + int(p.PayloadLen)
+	if p.IsCompact() {
+		unsigned := *p
+		unsigned.Flags &^= FlagHMAC
+		unsigned.HasChecksum = false
+		buf, err := unsigned.marshalCompact()
+		if err != nil {
+			return nil, err
+		}
+		// Keep HMAC flag set to indicate signature is present
+		buf[3] = byte(p.Flags)
+		return buf, nil
+	}
+
+	size := HeaderSize + 2 + int(p.PayloadLen)
 	buf := make([]byte, size)
 	offset := 0
 
@@ -264,9 +339,11 @@ func marshalWithoutHMAC(p *Packet) ([]byte, error) {
 	offset += 4
 
 	// PayloadLen
-	buf[offset] = byte(p.PayloadLen >> 8)
-	buf[offset+1] = byte(p.PayloadLen)
-	offset += 2
+	buf[offset] = byte(p.PayloadLen >> 24)
+	buf[offset+1] = byte(p.PayloadLen >> 16)
+	buf[offset+2] = byte(p.PayloadLen >> 8)
+	buf[offset+3] = byte(p.PayloadLen)
+	offset += 4
 
 	// Payload
 	if len(p.Payload) > 0 {
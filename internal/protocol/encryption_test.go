@@ -2,6 +2,7 @@ package protocol
 
 import (
 	"bytes"
+	"encoding/binary"
 	"testing"
 
 	"github.com/google/uuid"
@@ -380,10 +381,14 @@ func TestMarshalWithoutHMAC(t *testing.T) {
 		t.Fatalf("marshalWithoutHMAC failed: %v", err)
 	}
 
-	expectedSize := HeaderSize + len(pkt.Payload)
+	// PayloadLen is signed with 4 bytes, 2 more than in the header
+	expectedSize := HeaderSize + 2 + len(pkt.Payload)
 	if len(data) != expectedSize {
 		t.Errorf("Expected size %d, got %d", expectedSize, len(data))
 	}
+	if got := binary.BigEndian.Uint32(data[HeaderSize-2:]); got != pkt.PayloadLen {
+		t.Errorf("Expected PayloadLen %d, got %d", pkt.PayloadLen, got)
+	}
 
 	// Verify the data contains the correct header fields
 	// Magic bytes
@@ -410,6 +415,35 @@ func TestMarshalWithoutHMAC(t *testing.T) {
 	}
 }
 
+func TestSignJumboCompactPacket(t *testing.T) {
+	hmacKey, _ := crypto.GenerateHMACKey()
+	pc, _ := NewPacketCryptoHMACOnly(hmacKey)
+
+	// A payload over 64 KiB, whose length does not fit the full header
+	pkt, _ := NewJumboPacket(uuid.New(), 1, FlagData, make([]byte, 70000))
+	pkt.SessionIndex = 7
+	signed, err := pc.SignPacket(pkt)
+	if err != nil {
+		t.Fatalf("SignPacket failed: %v", err)
+	}
+	data, err := signed.Marshal()
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	decoded, err := Unmarshal(data)
+	if err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if decoded.PayloadLen != 70000 || !pc.VerifyPacket(decoded) {
+		t.Fatalf("Expected the jumbo packet to verify, got PayloadLen %d", decoded.PayloadLen)
+	}
+
+	decoded.SessionIndex++
+	if pc.VerifyPacket(decoded) {
+		t.Error("Expected a changed session index to fail verification")
+	}
+}
+
 func BenchmarkEncryptPacket(b *testing.B) {
 	encKey, _ := crypto.GenerateAES256Key()
 	hmacKey, _ := crypto.GenerateHMACKey()
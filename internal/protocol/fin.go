@@ -0,0 +1,127 @@
+package protocol
+
+import (
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+// CloseReason identifies why a stream was closed. It is carried in the
+// first byte of a FIN packet's payload; a FIN without payload, as sent by
+// older peers, has CloseUnspecified.
+type CloseReason byte
+
+const (
+	// CloseUnspecified means the peer gave no reason.
+	CloseUnspecified CloseReason = 0x00
+	// CloseEOF means the local connection or destination closed the stream.
+	CloseEOF CloseReason = 0x01
+	// CloseReadError means reading from the local connection or destination
+	// failed.
+	CloseReadError CloseReason = 0x02
+	// CloseWriteError means writing to the local connection or destination
+	// failed.
+	CloseWriteError CloseReason = 0x03
+	// ClosePolicy means a destination or routing policy does not allow the
+	// stream.
+	ClosePolicy CloseReason = 0x04
+	// CloseIdleTimeout means the stream or its session was idle for too long.
+	CloseIdleTimeout CloseReason = 0x05
+	// CloseQuota means a traffic quota was used up.
+	CloseQuota CloseReason = 0x06
+	// CloseDialFailed means the destination could not be connected.
+	CloseDialFailed CloseReason = 0x07
+	// CloseAdmin means an operator closed the stream through the admin API.
+	CloseAdmin CloseReason = 0x08
+	// CloseShutdown means the client or server is shutting down.
+	CloseShutdown CloseReason = 0x09
+	// CloseSessionClosed means the stream's session was closed.
+	CloseSessionClosed CloseReason = 0x0a
+	// CloseTunnelError means sending through the tunnel failed.
+	CloseTunnelError CloseReason = 0x0b
+	// CloseProtocolError means the stream's packets could not be processed.
+	CloseProtocolError CloseReason = 0x0c
+	// CloseOverloaded means the server refused the stream because it is
+	// close to a resource limit.
+	CloseOverloaded CloseReason = 0x0d
+)
+
+// String returns the string representation of the reason.
+func (r CloseReason) String() string {
+	switch r {
+	case CloseUnspecified:
+		return "unspecified"
+	case CloseEOF:
+		return "eof"
+	case CloseReadError:
+		return "read_error"
+	case CloseWriteError:
+		return "write_error"
+	case ClosePolicy:
+		return "policy"
+	case CloseIdleTimeout:
+		return "idle_timeout"
+	case CloseQuota:
+		return "quota"
+	case CloseDialFailed:
+		return "dial_failed"
+	case CloseAdmin:
+		return "admin"
+	case CloseShutdown:
+		return "shutdown"
+	case CloseSessionClosed:
+		return "session_closed"
+	case CloseTunnelError:
+		return "tunnel_error"
+	case CloseProtocolError:
+		return "protocol_error"
+	case CloseOverloaded:
+		return "overloaded"
+	default:
+		return "unknown"
+	}
+}
+
+// MaxFinMessage is the maximum length of a FIN message in bytes; longer
+// messages are truncated.
+const MaxFinMessage = 128
+
+// Fin is the payload of a FIN packet: the close reason followed by an
+// optional human-readable message.
+type Fin struct {
+	Reason  CloseReason
+	Message string
+}
+
+// NewFinReasonPacket creates a FIN packet carrying a close reason.
+func NewFinReasonPacket(sessionID uuid.UUID, streamID uint32, fin Fin) (*Packet, error) {
+	return NewPacket(sessionID, streamID, FlagFin, fin.Marshal())
+}
+
+// Marshal encodes the FIN payload, truncating the message to MaxFinMessage
+// bytes.
+func (f Fin) Marshal() []byte {
+	msg := f.Message
+	if len(msg) > MaxFinMessage {
+		msg = msg[:MaxFinMessage]
+		// Do not cut a multi-byte character in half
+		for len(msg) > 0 && !utf8.ValidString(msg) {
+			msg = msg[:len(msg)-1]
+		}
+	}
+	buf := make([]byte, 0, 1+len(msg))
+	buf = append(buf, byte(f.Reason))
+	return append(buf, msg...)
+}
+
+// ParseFin decodes a FIN payload. An empty payload has CloseUnspecified.
+func ParseFin(payload []byte) Fin {
+	if len(payload) == 0 {
+		return Fin{}
+	}
+	msg := payload[1:]
+	if len(msg) > MaxFinMessage {
+		msg = msg[:MaxFinMessage]
+	}
+	return Fin{Reason: CloseReason(payload[0]), Message: string(msg)}
+}
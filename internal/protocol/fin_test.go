@@ -0,0 +1,78 @@
+package protocol
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+func TestFinReasonPacketRoundTrip(t *testing.T) {
+	fin := Fin{Reason: CloseWriteError, Message: "broken pipe"}
+
+	pkt, err := NewFinReasonPacket(uuid.New(), 7, fin)
+	if err != nil {
+		t.Fatalf("NewFinReasonPacket failed: %v", err)
+	}
+	data, err := pkt.Marshal()
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	decoded, err := Unmarshal(data)
+	if err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+
+	if decoded.PacketType() != "FIN" {
+		t.Errorf("PacketType should be FIN, got %s", decoded.PacketType())
+	}
+	if got := ParseFin(decoded.Payload); got != fin {
+		t.Errorf("Expected %+v, got %+v", fin, got)
+	}
+}
+
+func TestParseFin(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload []byte
+		want    Fin
+	}{
+		{"empty", nil, Fin{}},
+		{"reason only", []byte{byte(CloseEOF)}, Fin{Reason: CloseEOF}},
+		{"reason and message", append([]byte{byte(ClosePolicy)}, "blocked"...), Fin{Reason: ClosePolicy, Message: "blocked"}},
+		{"unknown reason", []byte{0xff}, Fin{Reason: 0xff}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ParseFin(tt.payload); got != tt.want {
+				t.Errorf("Expected %+v, got %+v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestFinMarshalTruncatesMessage(t *testing.T) {
+	fin := Fin{Reason: CloseReadError, Message: strings.Repeat("é", MaxFinMessage)}
+
+	got := ParseFin(fin.Marshal())
+	if got.Reason != CloseReadError {
+		t.Errorf("Expected reason %s, got %s", CloseReadError, got.Reason)
+	}
+	if len(got.Message) > MaxFinMessage {
+		t.Errorf("Expected message of at most %d bytes, got %d", MaxFinMessage, len(got.Message))
+	}
+	if !utf8.ValidString(got.Message) {
+		t.Errorf("Expected valid UTF-8 message, got %q", got.Message)
+	}
+}
+
+func TestCloseReasonString(t *testing.T) {
+	if CloseIdleTimeout.String() != "idle_timeout" {
+		t.Errorf("Expected idle_timeout, got %s", CloseIdleTimeout)
+	}
+	if CloseReason(0xff).String() != "unknown" {
+		t.Errorf("Expected unknown, got %s", CloseReason(0xff))
+	}
+}
@@ -98,6 +98,8 @@ func (p *Packet) IsControlPacket() bool {
 // PacketType returns a string description of the packet type based on flags.
 func (p *Packet) PacketType() string {
 	switch {
+	case p.IsControlMessage():
+		return "CONTROL"
 	case p.IsHandshake() && p.IsAck():
 		return "HANDSHAKE_ACK"
 	case p.IsHandshake():
@@ -0,0 +1,45 @@
+package protocol
+
+import "encoding/binary"
+
+// openResultSize is the encoded size of an OpenResult: code + attempts.
+const openResultSize = 1 + 2
+
+// OpenResult is the payload of the HANDSHAKE_ACK a server sends on a stream
+// to a client that negotiated CapOpenAck, once the stream's destination is
+// connected or could not be.
+type OpenResult struct {
+	// Code is 0 if the destination is connected, else why it was not
+	Code StreamErrorCode
+	// Attempts is the number of destination dials made (0 if none were)
+	Attempts uint16
+}
+
+// OK reports whether the stream's destination is connected.
+func (r OpenResult) OK() bool {
+	return r.Code == 0
+}
+
+// StreamError returns the failure of a stream that was not opened.
+func (r OpenResult) StreamError(streamID uint32) StreamError {
+	return StreamError{StreamID: streamID, Code: r.Code, Attempts: r.Attempts}
+}
+
+// Marshal encodes the result.
+func (r OpenResult) Marshal() []byte {
+	buf := make([]byte, openResultSize)
+	buf[0] = byte(r.Code)
+	binary.BigEndian.PutUint16(buf[1:3], r.Attempts)
+	return buf
+}
+
+// ParseOpenResult decodes the payload of a stream's HANDSHAKE_ACK.
+func ParseOpenResult(payload []byte) (OpenResult, error) {
+	if len(payload) < openResultSize {
+		return OpenResult{}, ErrInsufficientData
+	}
+	return OpenResult{
+		Code:     StreamErrorCode(payload[0]),
+		Attempts: binary.BigEndian.Uint16(payload[1:3]),
+	}, nil
+}
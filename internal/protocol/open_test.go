@@ -0,0 +1,25 @@
+package protocol
+
+import "testing"
+
+func TestOpenResultRoundTrip(t *testing.T) {
+	for _, want := range []OpenResult{
+		{},
+		{Code: StreamErrorRefused, Attempts: 3},
+	} {
+		got, err := ParseOpenResult(want.Marshal())
+		if err != nil {
+			t.Fatalf("ParseOpenResult failed: %v", err)
+		}
+		if got != want {
+			t.Errorf("Expected %+v, got %+v", want, got)
+		}
+		if got.OK() != (want.Code == 0) {
+			t.Errorf("OK() = %v for code %s", got.OK(), got.Code)
+		}
+	}
+
+	if _, err := ParseOpenResult([]byte{0}); err != ErrInsufficientData {
+		t.Errorf("Expected ErrInsufficientData for a short payload, got %v", err)
+	}
+}
@@ -15,7 +15,8 @@ const (
 	MagicByte2 byte = 0x54 // 'T'
 )
 
-// Protocol version
+// Version is the newest packet version supported. Packets of any version
+// from MinVersion to Version are accepted.
 const Version byte = 0x01
 
 // Packet flags
@@ -36,6 +37,11 @@ const (
 	HeaderSize     = 2 + 1 + 1 + 16 + 4 + 4 + 4 + 2 // 34 bytes
 	HMACSize       = 32
 	MaxPayloadSize = 65535
+	// MaxJumboPayloadSize is the largest payload of a jumbo frame. Only the
+	// compact header can carry payloads above MaxPayloadSize.
+	MaxJumboPayloadSize = 1 << 20
+	// PacketOverhead is the largest size of a packet beyond its payload
+	PacketOverhead = HeaderSize + HMACSize + ChecksumSize
 )
 
 // Errors
@@ -55,9 +61,16 @@ type Packet struct {
 	StreamID   uint32
 	SeqNum     uint32
 	AckNum     uint32
-	PayloadLen uint16
+	PayloadLen uint32
 	Payload    []byte
 	HMAC       []byte // Optional, 32 bytes if FlagHMAC is set
+	// SessionIndex, when non-zero, stands in for SessionID on the wire:
+	// the packet is marshaled with the compact header
+	SessionIndex uint32
+	// Checksum is the header checksum sent in a trailer if HasChecksum is
+	// set (see SetChecksum)
+	Checksum    uint32
+	HasChecksum bool
 }
 
 // NewPacket creates a new packet with default magic and version.
@@ -72,13 +85,20 @@ func NewPacket(sessionID uuid.UUID, streamID uint32, flags Flag, payload []byte)
 		Flags:      flags,
 		SessionID:  sessionID,
 		StreamID:   streamID,
-		PayloadLen: uint16(len(payload)),
+		PayloadLen: uint32(len(payload)),
 		Payload:    payload,
 	}, nil
 }
 
 // Marshal serializes the packet to binary format.
 func (p *Packet) Marshal() ([]byte, error) {
+	if p.IsCompact() {
+		return p.marshalCompact()
+	}
+	if p.PayloadLen > MaxPayloadSize {
+		return nil, ErrPayloadTooLarge
+	}
+
 	size := HeaderSize + int(p.PayloadLen)
 	if p.Flags&FlagHMAC != 0 {
 		size += HMACSize
@@ -117,7 +137,7 @@ func (p *Packet) Marshal() ([]byte, error) {
 	offset += 4
 
 	// PayloadLen
-	binary.BigEndian.PutUint16(buf[offset:], p.PayloadLen)
+	binary.BigEndian.PutUint16(buf[offset:], uint16(p.PayloadLen))
 	offset += 2
 
 	// Payload
@@ -129,11 +149,15 @@ func (p *Packet) Marshal() ([]byte, error) {
 		copy(buf[offset:], p.HMAC)
 	}
 
-	return buf, nil
+	return p.appendChecksum(buf), nil
 }
 
 // Unmarshal deserializes binary data into a packet.
 func Unmarshal(data []byte) (*Packet, error) {
+	if isCompactData(data) {
+		return unmarshalCompact(data)
+	}
+
 	if len(data) < HeaderSize {
 		return nil, ErrInsufficientData
 	}
@@ -151,7 +175,7 @@ func Unmarshal(data []byte) (*Packet, error) {
 
 	// Version
 	p.Version = data[offset]
-	if p.Version != Version {
+	if p.Version < MinVersion || p.Version > Version {
 		return nil, ErrInvalidVersion
 	}
 	offset++
@@ -177,7 +201,7 @@ func Unmarshal(data []byte) (*Packet, error) {
 	offset += 4
 
 	// PayloadLen
-	p.PayloadLen = binary.BigEndian.Uint16(data[offset:])
+	p.PayloadLen = uint32(binary.BigEndian.Uint16(data[offset:]))
 	offset += 2
 
 	// Calculate expected total size
@@ -203,41 +227,87 @@ func Unmarshal(data []byte) (*Packet, error) {
 		copy(p.HMAC, data[offset:offset+HMACSize])
 	}
 
+	// Checksum trailer (optional)
+	p.readChecksum(data, expectedSize)
+
 	return p, nil
 }
 
-// ReadPacket reads a packet from an io.Reader.
-func ReadPacket(r io.Reader) (*Packet, error) {
-	header := make([]byte, HeaderSize)
+// ReadPacket reads a packet from an io.Reader, with either the full or the
+// compact header. Unmarshal recognizes a checksum trailer by the size of the
+// message, which a stream does not have, so checksum tells whether the
+// packet has one, as it does once the session negotiated CapChecksum.
+func ReadPacket(r io.Reader, checksum bool) (*Packet, error) {
+	header := make([]byte, 2, HeaderSize)
 	if _, err := io.ReadFull(r, header); err != nil {
 		return nil, err
 	}
 
-	// Parse header to get payload length and flags
-	flags := Flag(header[3])
-	payloadLen := binary.BigEndian.Uint16(header[32:34])
+	var payloadLen int
+	var err error
+	if isCompactData(header) {
+		header, payloadLen, err = readCompactHeader(r, header)
+	} else {
+		header = header[:HeaderSize]
+		if _, err = io.ReadFull(r, header[2:]); err == nil {
+			payloadLen = int(binary.BigEndian.Uint16(header[32:34]))
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
 
 	// Calculate additional bytes needed
-	additionalSize := int(payloadLen)
-	if flags&FlagHMAC != 0 {
+	additionalSize := payloadLen
+	if Flag(header[3])&FlagHMAC != 0 {
 		additionalSize += HMACSize
 	}
-
-	// Read additional data if any
-	var fullData []byte
-	if additionalSize > 0 {
-		additional := make([]byte, additionalSize)
-		if _, err := io.ReadFull(r, additional); err != nil {
-			return nil, err
-		}
-		fullData = append(header, additional...)
-	} else {
-		fullData = header
+	if checksum {
+		additionalSize += ChecksumSize
 	}
 
+	fullData := make([]byte, len(header)+additionalSize)
+	copy(fullData, header)
+	if _, err := io.ReadFull(r, fullData[len(header):]); err != nil {
+		return nil, err
+	}
 	return Unmarshal(fullData)
 }
 
+// readCompactHeader reads the rest of a compact header after its magic
+// bytes, which header holds, and returns the header and its payload length.
+func readCompactHeader(r io.Reader, header []byte) ([]byte, int, error) {
+	header = header[:compactFixedSize]
+	if _, err := io.ReadFull(r, header[2:]); err != nil {
+		return nil, 0, err
+	}
+
+	// Stream ID, sequence number, ack number and payload length, each a
+	// uvarint of at most 5 bytes
+	var b [1]byte
+	var length uint64
+	for i := 0; i < 4; i++ {
+		start := len(header)
+		for {
+			if _, err := io.ReadFull(r, b[:]); err != nil {
+				return nil, 0, err
+			}
+			header = append(header, b[0])
+			if b[0] < 0x80 {
+				break
+			}
+			if len(header)-start == binary.MaxVarintLen32 {
+				return nil, 0, ErrInvalidHeader
+			}
+		}
+		length, _ = binary.Uvarint(header[start:])
+	}
+	if length > MaxJumboPayloadSize {
+		return nil, 0, ErrInvalidHeader
+	}
+	return header, int(length), nil
+}
+
 // IsData returns true if the packet contains data.
 func (p *Packet) IsData() bool {
 	return p.Flags&FlagData != 0
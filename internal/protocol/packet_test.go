@@ -253,7 +253,7 @@ func TestReadPacket(t *testing.T) {
 	}
 
 	reader := bytes.NewReader(data)
-	restored, err := ReadPacket(reader)
+	restored, err := ReadPacket(reader, false)
 	if err != nil {
 		t.Fatalf("ReadPacket failed: %v", err)
 	}
@@ -272,6 +272,82 @@ func TestReadPacket(t *testing.T) {
 	}
 }
 
+func TestReadPacketLayouts(t *testing.T) {
+	tests := []struct {
+		name     string
+		index    uint32
+		flags    Flag
+		size     int
+		checksum bool
+	}{
+		{"full header", 0, FlagData, 16, false},
+		{"compact header", 7, FlagData, 16, false},
+		{"compact hmac", 7, FlagData | FlagHMAC, 16, false},
+		{"compact jumbo", 1 << 20, FlagData, MaxJumboPayloadSize, false},
+		{"full checksum", 0, FlagData | FlagHMAC, 16, true},
+		{"compact checksum", 300, FlagData, 16, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stream bytes.Buffer
+			var sent []*Packet
+			for i := 0; i < 2; i++ {
+				payload := bytes.Repeat([]byte{byte('a' + i)}, tt.size)
+				pkt, err := NewJumboPacket(uuid.New(), uint32(i+1), tt.flags, payload)
+				if err != nil {
+					t.Fatalf("NewJumboPacket failed: %v", err)
+				}
+				pkt.SeqNum = uint32(1000 * i)
+				pkt.SessionIndex = tt.index
+				if tt.flags&FlagHMAC != 0 {
+					pkt.HMAC = bytes.Repeat([]byte{0x5a}, HMACSize)
+				}
+				if tt.checksum {
+					pkt.SetChecksum()
+				}
+				data, err := pkt.Marshal()
+				if err != nil {
+					t.Fatalf("Marshal failed: %v", err)
+				}
+				stream.Write(data)
+				sent = append(sent, pkt)
+			}
+
+			for _, want := range sent {
+				got, err := ReadPacket(&stream, tt.checksum)
+				if err != nil {
+					t.Fatalf("ReadPacket failed: %v", err)
+				}
+				if got.SessionIndex != want.SessionIndex || got.StreamID != want.StreamID || got.SeqNum != want.SeqNum {
+					t.Errorf("Header mismatch: got index %d stream %d seq %d, want %d %d %d",
+						got.SessionIndex, got.StreamID, got.SeqNum, want.SessionIndex, want.StreamID, want.SeqNum)
+				}
+				if !bytes.Equal(got.Payload, want.Payload) {
+					t.Errorf("Payload mismatch")
+				}
+				if !bytes.Equal(got.HMAC, want.HMAC) {
+					t.Errorf("HMAC mismatch")
+				}
+				if got.HasChecksum != tt.checksum || got.Checksum != want.Checksum {
+					t.Errorf("Checksum mismatch: got %08x (present: %v), want %08x", got.Checksum, got.HasChecksum, want.Checksum)
+				}
+			}
+			if stream.Len() != 0 {
+				t.Errorf("Expected stream drained, %d bytes left", stream.Len())
+			}
+		})
+	}
+}
+
+func TestReadPacketRejectsOverlongVarint(t *testing.T) {
+	data := []byte{CompactMagicByte1, CompactMagicByte2, Version, byte(FlagData), 0, 0, 0, 1}
+	data = append(data, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff)
+	if _, err := ReadPacket(bytes.NewReader(data), false); err == nil {
+		t.Error("Expected error for overlong varint")
+	}
+}
+
 func TestEmptyPayload(t *testing.T) {
 	sessionID := uuid.New()
 
@@ -446,7 +522,7 @@ func TestPacket_ChecksumAfterModification(t *testing.T) {
 
 	// Modify payload
 	pkt.Payload = []byte("modified")
-	pkt.PayloadLen = uint16(len(pkt.Payload))
+	pkt.PayloadLen = uint32(len(pkt.Payload))
 
 	modifiedChecksum := pkt.CalculateChecksum()
 
@@ -485,3 +561,77 @@ func BenchmarkCalculateHeaderChecksum(b *testing.B) {
 		_ = pkt.CalculateHeaderChecksum()
 	}
 }
+
+// fuzzSeeds returns valid full and compact packets to seed the packet fuzz
+// targets.
+func fuzzSeeds(f *testing.F) [][]byte {
+	f.Helper()
+	var seeds [][]byte
+	for _, compact := range []bool{false, true} {
+		for _, flags := range []Flag{FlagData, FlagFin, FlagData | FlagHMAC, FlagHandshake | FlagData} {
+			pkt, err := NewPacket(uuid.New(), 7, flags, []byte("payload"))
+			if err != nil {
+				f.Fatalf("NewPacket failed: %v", err)
+			}
+			if flags&FlagHMAC != 0 {
+				pkt.HMAC = make([]byte, HMACSize)
+			}
+			if compact {
+				pkt.SessionIndex = 3
+			}
+			data, err := pkt.Marshal()
+			if err != nil {
+				f.Fatalf("Marshal failed: %v", err)
+			}
+			seeds = append(seeds, data)
+		}
+	}
+	return seeds
+}
+
+func FuzzUnmarshal(f *testing.F) {
+	for _, seed := range fuzzSeeds(f) {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		pkt, err := Unmarshal(data)
+		if err != nil {
+			return
+		}
+		if len(pkt.Payload) != int(pkt.PayloadLen) {
+			t.Fatalf("Payload of %d bytes with PayloadLen %d", len(pkt.Payload), pkt.PayloadLen)
+		}
+
+		// A packet that parses marshals back to one that parses the same
+		out, err := pkt.Marshal()
+		if err != nil {
+			return
+		}
+		again, err := Unmarshal(out)
+		if err != nil {
+			t.Fatalf("Unmarshal of a marshaled packet failed: %v", err)
+		}
+		if again.Flags != pkt.Flags || again.StreamID != pkt.StreamID || again.SeqNum != pkt.SeqNum ||
+			again.SessionID != pkt.SessionID || again.SessionIndex != pkt.SessionIndex ||
+			!bytes.Equal(again.Payload, pkt.Payload) {
+			t.Fatalf("Round trip changed the packet: %+v, then %+v", pkt, again)
+		}
+	})
+}
+
+func FuzzReadPacket(f *testing.F) {
+	for _, seed := range fuzzSeeds(f) {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		pkt, err := ReadPacket(bytes.NewReader(data), false)
+		if err != nil {
+			return
+		}
+		if len(pkt.Payload) != int(pkt.PayloadLen) {
+			t.Fatalf("Payload of %d bytes with PayloadLen %d", len(pkt.Payload), pkt.PayloadLen)
+		}
+	})
+}
@@ -0,0 +1,62 @@
+package protocol
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+
+	"github.com/google/uuid"
+)
+
+// PaddingTrailerSize is the size of the padding length that ends the data
+// payloads of sessions that negotiated CapPadding.
+const PaddingTrailerSize = 2
+
+// MaxPadding is the most padding one payload can carry.
+const MaxPadding = 1<<16 - 1
+
+// ErrInvalidPadding is returned for payloads whose padding trailer does not
+// fit the payload.
+var ErrInvalidPadding = errors.New("invalid padding")
+
+// Pad appends n random bytes and the padding trailer to the packet's
+// payload. On sessions that negotiated CapPadding, clients pad every data
+// packet they send, with n of 0 when a packet goes unpadded, so packet
+// sizes on the wire no longer follow the application's writes.
+func (p *Packet) Pad(n int) error {
+	n = min(max(n, 0), MaxPadding)
+	payload := make([]byte, len(p.Payload)+n+PaddingTrailerSize)
+	copy(payload, p.Payload)
+	if _, err := rand.Read(payload[len(p.Payload) : len(p.Payload)+n]); err != nil {
+		return err
+	}
+	binary.BigEndian.PutUint16(payload[len(payload)-PaddingTrailerSize:], uint16(n))
+	p.Payload = payload
+	p.PayloadLen = uint32(len(payload))
+	return nil
+}
+
+// Unpad removes the padding and trailer that Pad added.
+func (p *Packet) Unpad() error {
+	end := len(p.Payload) - PaddingTrailerSize
+	if end < 0 {
+		return ErrInvalidPadding
+	}
+	n := int(binary.BigEndian.Uint16(p.Payload[end:]))
+	if n > end {
+		return ErrInvalidPadding
+	}
+	p.Payload = p.Payload[:end-n]
+	p.PayloadLen = uint32(len(p.Payload))
+	return nil
+}
+
+// NewPaddingPacket creates a ControlPadding packet with size random bytes,
+// which the receiver discards. Clients send them as cover traffic.
+func NewPaddingPacket(sessionID uuid.UUID, size int) (*Packet, error) {
+	body := make([]byte, size)
+	if _, err := rand.Read(body); err != nil {
+		return nil, err
+	}
+	return NewControlPacket(sessionID, ControlPadding, body)
+}
@@ -0,0 +1,58 @@
+package protocol
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestPadRoundTrip(t *testing.T) {
+	for _, n := range []int{0, 1, 300, MaxPayloadSize - 100} {
+		pkt, _ := NewPacket(uuid.New(), 1, FlagData, []byte("payload"))
+		if err := pkt.Pad(n); err != nil {
+			t.Fatalf("Pad(%d) failed: %v", n, err)
+		}
+		if want := len("payload") + n + PaddingTrailerSize; len(pkt.Payload) != want || int(pkt.PayloadLen) != want {
+			t.Fatalf("Pad(%d): payload of %d bytes, want %d", n, len(pkt.Payload), want)
+		}
+
+		data, err := pkt.Marshal()
+		if err != nil {
+			t.Fatalf("Marshal failed: %v", err)
+		}
+		decoded, err := Unmarshal(data)
+		if err != nil {
+			t.Fatalf("Unmarshal failed: %v", err)
+		}
+		if err := decoded.Unpad(); err != nil {
+			t.Fatalf("Unpad failed: %v", err)
+		}
+		if !bytes.Equal(decoded.Payload, []byte("payload")) || decoded.PayloadLen != uint32(len("payload")) {
+			t.Errorf("Unpad(%d): got %q", n, decoded.Payload)
+		}
+	}
+}
+
+func TestUnpadInvalid(t *testing.T) {
+	for _, payload := range [][]byte{nil, {0x00}, {0x01, 0x00, 0x02}} {
+		pkt, _ := NewPacket(uuid.New(), 1, FlagData, payload)
+		if err := pkt.Unpad(); err != ErrInvalidPadding {
+			t.Errorf("Unpad(%x): expected ErrInvalidPadding, got %v", payload, err)
+		}
+	}
+}
+
+func TestNewPaddingPacket(t *testing.T) {
+	pkt, err := NewPaddingPacket(uuid.New(), 100)
+	if err != nil {
+		t.Fatalf("NewPaddingPacket failed: %v", err)
+	}
+	ctrl, body, err := ParseControl(pkt)
+	if err != nil {
+		t.Fatalf("ParseControl failed: %v", err)
+	}
+	if ctrl != ControlPadding || len(body) != 100 {
+		t.Errorf("Got control %d with %d bytes, want padding with 100", ctrl, len(body))
+	}
+}
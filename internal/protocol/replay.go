@@ -0,0 +1,150 @@
+package protocol
+
+import (
+	"container/list"
+	"errors"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// ReplayWindowSize is how many replay counters below the highest one seen
+// a replay window remembers. Older packets are rejected as replays.
+const ReplayWindowSize = 1024
+
+// ErrReplayedPacket is returned when an authenticated packet's replay
+// counter was already seen or has fallen out of the replay window.
+var ErrReplayedPacket = errors.New("replayed packet")
+
+// ReplayWindow tracks the replay counters seen on one session with a
+// sliding bitmap, so each counter is accepted once. Packets may arrive out
+// of order by up to ReplayWindowSize counters. Counters start at 1 and do
+// not wrap around.
+type ReplayWindow struct {
+	highest uint64
+	bitmap  [ReplayWindowSize / 64]uint64
+}
+
+// resumeReplayWindow returns a window that rejects every counter up to
+// highest, for a session whose window was dropped.
+func resumeReplayWindow(highest uint64) ReplayWindow {
+	w := ReplayWindow{highest: highest}
+	for i := range w.bitmap {
+		w.bitmap[i] = ^uint64(0)
+	}
+	return w
+}
+
+// Check reports whether counter is new, and records it if so.
+func (w *ReplayWindow) Check(counter uint64) bool {
+	if counter == 0 {
+		return false
+	}
+
+	if counter > w.highest {
+		// Slide the window, forgetting the counters it leaves
+		if counter-w.highest >= ReplayWindowSize {
+			w.bitmap = [ReplayWindowSize / 64]uint64{}
+		} else {
+			for n := w.highest + 1; n != counter; n++ {
+				w.clear(n)
+			}
+		}
+		w.highest = counter
+		w.set(counter)
+		return true
+	}
+
+	if w.highest-counter >= ReplayWindowSize || w.isSet(counter) {
+		return false
+	}
+	w.set(counter)
+	return true
+}
+
+func (w *ReplayWindow) set(counter uint64) {
+	i := counter % ReplayWindowSize
+	w.bitmap[i/64] |= 1 << (i % 64)
+}
+
+func (w *ReplayWindow) clear(counter uint64) {
+	i := counter % ReplayWindowSize
+	w.bitmap[i/64] &^= 1 << (i % 64)
+}
+
+func (w *ReplayWindow) isSet(counter uint64) bool {
+	i := counter % ReplayWindowSize
+	return w.bitmap[i/64]&(1<<(i%64)) != 0
+}
+
+// MaxReplayWindows bounds the replay windows kept, one per session. Beyond
+// it the least recently used window is dropped, keeping only the session's
+// highest counter, so memory stays small even if ForgetSession is never
+// called and the session's old packets stay rejected.
+const MaxReplayWindows = 65536
+
+// replayFilter holds the replay windows of sessions. Replay counters are
+// per sender and cover every signed packet, stream 0 included, so a
+// session needs a single window however its streams come and go.
+type replayFilter struct {
+	mu       sync.Mutex
+	sessions map[uuid.UUID]*list.Element
+	// lru holds the windows, most recently used first
+	lru list.List
+	// dropped holds the highest counter of sessions whose window the LRU
+	// dropped
+	dropped map[uuid.UUID]uint64
+}
+
+// replayEntry is a replay window in the filter's LRU list.
+type replayEntry struct {
+	sessionID uuid.UUID
+	window    ReplayWindow
+}
+
+// check reports whether the replay counter is new on its session.
+func (f *replayFilter) check(sessionID uuid.UUID, counter uint64) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.sessions == nil {
+		f.sessions = make(map[uuid.UUID]*list.Element)
+		f.dropped = make(map[uuid.UUID]uint64)
+	}
+	elem, ok := f.sessions[sessionID]
+	if ok {
+		f.lru.MoveToFront(elem)
+	} else {
+		entry := &replayEntry{sessionID: sessionID}
+		if highest, ok := f.dropped[sessionID]; ok {
+			entry.window = resumeReplayWindow(highest)
+			delete(f.dropped, sessionID)
+		}
+		elem = f.lru.PushFront(entry)
+		f.sessions[sessionID] = elem
+		if f.lru.Len() > MaxReplayWindows {
+			oldest := f.lru.Remove(f.lru.Back()).(*replayEntry)
+			delete(f.sessions, oldest.sessionID)
+			f.dropped[oldest.sessionID] = oldest.window.highest
+		}
+	}
+	return elem.Value.(*replayEntry).window.Check(counter)
+}
+
+// len returns the number of windows.
+func (f *replayFilter) len() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.lru.Len()
+}
+
+// forgetSession drops the replay window of a closed session.
+func (f *replayFilter) forgetSession(sessionID uuid.UUID) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if elem, ok := f.sessions[sessionID]; ok {
+		f.lru.Remove(elem)
+		delete(f.sessions, sessionID)
+	}
+	delete(f.dropped, sessionID)
+}
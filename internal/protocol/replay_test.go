@@ -0,0 +1,195 @@
+package protocol
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/sahmadiut/half-tunnel/pkg/crypto"
+)
+
+func TestReplayWindow(t *testing.T) {
+	tests := []struct {
+		name     string
+		counters []uint64
+		want     []bool
+	}{
+		{"in order", []uint64{1, 2, 3}, []bool{true, true, true}},
+		{"duplicate", []uint64{5, 5}, []bool{true, false}},
+		{"out of order", []uint64{10, 8, 9, 8}, []bool{true, true, true, false}},
+		{"too old", []uint64{ReplayWindowSize + 10, 10, 11}, []bool{true, false, true}},
+		{"large jump", []uint64{1, 3 * ReplayWindowSize, 1}, []bool{true, true, false}},
+		{"slide clears reused bits", []uint64{1, ReplayWindowSize + 1}, []bool{true, true}},
+		{"zero", []uint64{0, 1, 0}, []bool{false, true, false}},
+		{"beyond 32 bits", []uint64{1<<32 - 1, 1 << 32, 1<<32 + 1, 1 << 32}, []bool{true, true, true, false}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var w ReplayWindow
+			for i, counter := range tt.counters {
+				if got := w.Check(counter); got != tt.want[i] {
+					t.Errorf("Check(%d) at %d: expected %v, got %v", counter, i, tt.want[i], got)
+				}
+			}
+		})
+	}
+}
+
+func TestVerifyAndDecryptReplay(t *testing.T) {
+	encKey, _ := crypto.GenerateAES256Key()
+	hmacKey, _ := crypto.GenerateHMACKey()
+	pc, _ := NewPacketCrypto(encKey, hmacKey)
+
+	var replays int
+	pc.SetOnReplay(func(*Packet) { replays++ })
+
+	sessionID := uuid.New()
+	original, _ := NewPacket(sessionID, 1, FlagData, []byte("data"))
+	original.SeqNum = 7
+	secured, _ := pc.EncryptAndSign(original)
+
+	if _, err := pc.VerifyAndDecrypt(secured); err != nil {
+		t.Fatalf("VerifyAndDecrypt failed: %v", err)
+	}
+	if _, err := pc.VerifyAndDecrypt(secured); err != ErrReplayedPacket {
+		t.Errorf("Expected ErrReplayedPacket, got %v", err)
+	}
+	if replays != 1 {
+		t.Errorf("Expected 1 replay, got %d", replays)
+	}
+
+	// Control packets on stream 0 are checked too
+	hello, _ := NewHelloPacket(sessionID, Hello{Version: Version})
+	secured, _ = pc.EncryptAndSign(hello)
+	if _, err := pc.VerifyAndDecrypt(secured); err != nil {
+		t.Fatalf("VerifyAndDecrypt failed: %v", err)
+	}
+	if _, err := pc.VerifyAndDecrypt(secured); err != ErrReplayedPacket {
+		t.Errorf("Expected a replayed control packet rejected, got %v", err)
+	}
+
+	// Packets signed again get new counters, on any stream or session
+	secured, _ = pc.EncryptAndSign(original)
+	if _, err := pc.VerifyAndDecrypt(secured); err != nil {
+		t.Errorf("Expected a packet signed again to be accepted, got %v", err)
+	}
+	other := original.Clone()
+	other.SessionID = uuid.New()
+	secured, _ = pc.EncryptAndSign(other)
+	if _, err := pc.VerifyAndDecrypt(secured); err != nil {
+		t.Errorf("Expected another session to be accepted, got %v", err)
+	}
+}
+
+func TestVerifyAndDecryptReplayReusedStream(t *testing.T) {
+	encKey, _ := crypto.GenerateAES256Key()
+	hmacKey, _ := crypto.GenerateHMACKey()
+	pc, _ := NewPacketCrypto(encKey, hmacKey)
+
+	// A closed stream's packet, replayed once its ID is reused by a stream
+	// that restarts the sequence numbers
+	sessionID := uuid.New()
+	old, _ := NewPacket(sessionID, 5, FlagData, []byte("old"))
+	recorded, _ := pc.EncryptAndSign(old)
+	if _, err := pc.VerifyAndDecrypt(recorded); err != nil {
+		t.Fatalf("VerifyAndDecrypt failed: %v", err)
+	}
+	reused, _ := NewPacket(sessionID, 5, FlagData, []byte("new"))
+	secured, _ := pc.EncryptAndSign(reused)
+	if _, err := pc.VerifyAndDecrypt(secured); err != nil {
+		t.Fatalf("Expected the reused stream's packet accepted, got %v", err)
+	}
+	if _, err := pc.VerifyAndDecrypt(recorded); err != ErrReplayedPacket {
+		t.Errorf("Expected the old packet rejected, got %v", err)
+	}
+}
+
+func TestVerifyAndDecryptReplayTamperedCounter(t *testing.T) {
+	encKey, _ := crypto.GenerateAES256Key()
+	hmacKey, _ := crypto.GenerateHMACKey()
+	pc, _ := NewPacketCrypto(encKey, hmacKey)
+
+	original, _ := NewPacket(uuid.New(), 1, FlagData, []byte("data"))
+	secured, _ := pc.EncryptAndSign(original)
+	if _, err := pc.VerifyAndDecrypt(secured); err != nil {
+		t.Fatalf("VerifyAndDecrypt failed: %v", err)
+	}
+
+	// The tag covers the counter, so a replay with a new one fails
+	tampered := secured.Clone()
+	tampered.HMAC[ReplayCounterSize-1]++
+	if _, err := pc.VerifyAndDecrypt(tampered); err != ErrHMACVerificationFailed {
+		t.Errorf("Expected ErrHMACVerificationFailed, got %v", err)
+	}
+}
+
+func TestVerifyAndDecryptReplayStrippedHMAC(t *testing.T) {
+	encKey, _ := crypto.GenerateAES256Key()
+	hmacKey, _ := crypto.GenerateHMACKey()
+	pc, _ := NewPacketCrypto(encKey, hmacKey)
+
+	original, _ := NewPacket(uuid.New(), 1, FlagData, []byte("data"))
+	original.SeqNum = 7
+	secured, _ := pc.EncryptAndSign(original)
+	if _, err := pc.VerifyAndDecrypt(secured); err != nil {
+		t.Fatalf("VerifyAndDecrypt failed: %v", err)
+	}
+
+	// Replaying the packet with FlagHMAC cleared must not skip the checks
+	stripped := copyPacket(secured)
+	stripped.Flags &^= FlagHMAC
+	stripped.HMAC = nil
+	for i := 0; i < 2; i++ {
+		if _, err := pc.VerifyAndDecrypt(stripped); err != ErrHMACVerificationFailed {
+			t.Errorf("Expected ErrHMACVerificationFailed, got %v", err)
+		}
+	}
+}
+
+func TestVerifyAndDecryptReplayUnsigned(t *testing.T) {
+	encKey, _ := crypto.GenerateAES256Key()
+	pc, _ := NewPacketCryptoEncryptOnly(encKey)
+
+	original, _ := NewPacket(uuid.New(), 1, FlagData, []byte("data"))
+	secured, _ := pc.EncryptAndSign(original)
+	for i := 0; i < 2; i++ {
+		if _, err := pc.VerifyAndDecrypt(secured); err != nil {
+			t.Errorf("Expected unsigned packets not to be checked, got %v", err)
+		}
+	}
+}
+
+func TestReplayFilterBounded(t *testing.T) {
+	var f replayFilter
+	sessions := make([]uuid.UUID, MaxReplayWindows+10)
+	for i := range sessions {
+		sessions[i] = uuid.New()
+		f.check(sessions[i], 5)
+	}
+	if n := f.len(); n != MaxReplayWindows {
+		t.Fatalf("Expected %d windows, got %d", MaxReplayWindows, n)
+	}
+	// The least recently used windows were dropped, the newest kept
+	if _, ok := f.sessions[sessions[0]]; ok || f.dropped[sessions[0]] != 5 {
+		t.Error("Expected the oldest window dropped, keeping its highest counter")
+	}
+	if f.check(sessions[len(sessions)-1], 5) {
+		t.Error("Expected the newest window to be kept")
+	}
+
+	// A dropped session still rejects its old counters
+	if f.check(sessions[0], 5) || f.check(sessions[0], 4) {
+		t.Error("Expected old counters of a dropped window rejected")
+	}
+	if !f.check(sessions[0], 6) {
+		t.Error("Expected new counters of a dropped window accepted")
+	}
+
+	f.forgetSession(sessions[0])
+	if n := f.len(); n != MaxReplayWindows-1 {
+		t.Errorf("Expected ForgetSession to drop a window, got %d", n)
+	}
+	if _, ok := f.dropped[sessions[0]]; ok {
+		t.Error("Expected ForgetSession to drop the session's highest counter")
+	}
+}
@@ -0,0 +1,181 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// MinVersion is the oldest packet version accepted. Peers send packets of
+// MinVersion until the session's hello exchange settled on a newer one, so
+// older peers can always read the handshake.
+const MinVersion byte = 0x01
+
+// Capability is a bitmap of optional protocol features. A feature is used
+// on a session only if both sides announced it in their hello.
+type Capability uint32
+
+const (
+	// CapEncryption is end-to-end payload encryption.
+	CapEncryption Capability = 1 << 0
+	// CapCompression is payload compression.
+	CapCompression Capability = 1 << 1
+	// CapReliable is acknowledged, retransmitted stream delivery.
+	CapReliable Capability = 1 << 2
+	// CapCloseReasons is a close reason in FIN payloads.
+	CapCloseReasons Capability = 1 << 3
+	// CapHalfClose is half-closing streams with a FIN carrying CloseEOF.
+	CapHalfClose Capability = 1 << 4
+	// CapCompactHeader is the compact packet header, which identifies the
+	// session by the index from the server's hello.
+	CapCompactHeader Capability = 1 << 5
+	// CapChecksum is a header checksum trailer on every packet, verified on
+	// receive.
+	CapChecksum Capability = 1 << 6
+	// CapPadding is random padding at the end of data payloads from the
+	// client (see Packet.Pad).
+	CapPadding Capability = 1 << 8
+	// CapFinAck is a FIN with FlagAck the server sends once it forgot a
+	// stream, after which the client may reuse the stream's ID.
+	CapFinAck Capability = 1 << 9
+	// CapOpenAck is a HANDSHAKE_ACK the server sends on a stream once it
+	// connected its destination or failed to (see OpenResult).
+	CapOpenAck Capability = 1 << 10
+)
+
+// SupportedCapabilities are the features this implementation supports.
+const SupportedCapabilities = CapCloseReasons | CapHalfClose | CapCompactHeader | CapChecksum | CapPadding | CapFinAck | CapOpenAck
+
+// capabilityNames maps each known capability to its name, in bit order.
+var capabilityNames = []struct {
+	cap  Capability
+	name string
+}{
+	{CapEncryption, "encryption"},
+	{CapCompression, "compression"},
+	{CapReliable, "reliable"},
+	{CapCloseReasons, "close_reasons"},
+	{CapHalfClose, "half_close"},
+	{CapCompactHeader, "compact_header"},
+	{CapChecksum, "checksum"},
+	{CapPadding, "padding"},
+	{CapFinAck, "fin_ack"},
+	{CapOpenAck, "open_ack"},
+}
+
+// Has reports whether c includes every capability of other.
+func (c Capability) Has(other Capability) bool {
+	return c&other == other
+}
+
+// Names returns the names of the known capabilities in c.
+func (c Capability) Names() []string {
+	names := []string{}
+	for _, n := range capabilityNames {
+		if c.Has(n.cap) {
+			names = append(names, n.name)
+		}
+	}
+	return names
+}
+
+// String returns the capability names separated by commas.
+func (c Capability) String() string {
+	return strings.Join(c.Names(), ",")
+}
+
+// helloSize is the encoded size of a Hello: version + capabilities. The
+// session index and the maximum payload size, if any, follow.
+const helloSize = 1 + 4
+
+// Hello is the body of ControlHello messages. The client sends its highest
+// packet version and its capabilities after the session handshake; the
+// server answers with the negotiated version and capabilities. Servers that
+// predate the exchange do not answer, which leaves the session at
+// MinVersion without capabilities.
+type Hello struct {
+	Version      byte
+	Capabilities Capability
+	// SessionIndex is the index the server assigned to the session for
+	// compact headers; it is only set in replies that grant
+	// CapCompactHeader.
+	SessionIndex uint32
+	// MaxPayload is the largest packet payload the sender can receive; in
+	// replies, the largest payload either side may send. 0 means
+	// MaxPayloadSize.
+	MaxPayload uint32
+}
+
+// LocalHello returns the hello announcing this implementation's version and
+// capabilities.
+func LocalHello() Hello {
+	return Hello{Version: Version, Capabilities: SupportedCapabilities, MaxPayload: MaxPayloadSize}
+}
+
+// NewHelloPacket creates a hello control packet.
+func NewHelloPacket(sessionID uuid.UUID, h Hello) (*Packet, error) {
+	return NewControlPacket(sessionID, ControlHello, h.Marshal())
+}
+
+// Marshal encodes the hello.
+func (h Hello) Marshal() []byte {
+	buf := make([]byte, helloSize, helloSize+4+4)
+	buf[0] = h.Version
+	binary.BigEndian.PutUint32(buf[1:], uint32(h.Capabilities))
+	if h.SessionIndex != 0 || h.MaxPayload != 0 {
+		buf = binary.BigEndian.AppendUint32(buf, h.SessionIndex)
+	}
+	if h.MaxPayload != 0 {
+		buf = binary.BigEndian.AppendUint32(buf, h.MaxPayload)
+	}
+	return buf
+}
+
+// ParseHello decodes a hello body. Trailing bytes, which newer versions may
+// add, are ignored.
+func ParseHello(body []byte) (Hello, error) {
+	if len(body) < helloSize {
+		return Hello{}, ErrInvalidControl
+	}
+	h := Hello{
+		Version:      body[0],
+		Capabilities: Capability(binary.BigEndian.Uint32(body[1:])),
+	}
+	if len(body) >= helloSize+4 {
+		h.SessionIndex = binary.BigEndian.Uint32(body[helloSize:])
+	}
+	if len(body) >= helloSize+4+4 {
+		h.MaxPayload = binary.BigEndian.Uint32(body[helloSize+4:])
+	}
+	return h, nil
+}
+
+// Negotiate returns the highest version, the capabilities and the largest
+// payload both local and remote support. Payloads stay within
+// MaxPayloadSize unless both support the compact header. It fails if the
+// remote version is older than MinVersion.
+func Negotiate(local, remote Hello) (Hello, error) {
+	if remote.Version < MinVersion {
+		return Hello{}, ErrInvalidVersion
+	}
+	version := local.Version
+	if remote.Version < version {
+		version = remote.Version
+	}
+	caps := local.Capabilities & remote.Capabilities
+
+	maxPayload := min(local.maxPayload(), remote.maxPayload())
+	if !caps.Has(CapCompactHeader) {
+		maxPayload = min(maxPayload, MaxPayloadSize)
+	}
+	return Hello{Version: version, Capabilities: caps, MaxPayload: maxPayload}, nil
+}
+
+// maxPayload returns the maximum payload size the hello announces.
+func (h Hello) maxPayload() uint32 {
+	if h.MaxPayload == 0 {
+		return MaxPayloadSize
+	}
+	return min(h.MaxPayload, MaxJumboPayloadSize)
+}
@@ -0,0 +1,133 @@
+package protocol
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestHelloPacketRoundTrip(t *testing.T) {
+	hello := Hello{Version: Version, Capabilities: CapCloseReasons | CapHalfClose}
+
+	pkt, err := NewHelloPacket(uuid.New(), hello)
+	if err != nil {
+		t.Fatalf("NewHelloPacket failed: %v", err)
+	}
+	data, err := pkt.Marshal()
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	decoded, err := Unmarshal(data)
+	if err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+
+	ctrl, body, err := ParseControl(decoded)
+	if err != nil {
+		t.Fatalf("ParseControl failed: %v", err)
+	}
+	if ctrl != ControlHello {
+		t.Errorf("Expected ControlHello, got %d", ctrl)
+	}
+	got, err := ParseHello(body)
+	if err != nil {
+		t.Fatalf("ParseHello failed: %v", err)
+	}
+	if got != hello {
+		t.Errorf("Expected %+v, got %+v", hello, got)
+	}
+}
+
+func TestParseHello(t *testing.T) {
+	if _, err := ParseHello([]byte{0x01}); err != ErrInvalidControl {
+		t.Errorf("Expected ErrInvalidControl for short body, got %v", err)
+	}
+
+	// Newer peers may append fields
+	got, err := ParseHello([]byte{0x02, 0x00, 0x00, 0x00, 0x18, 0xff, 0xff})
+	if err != nil {
+		t.Fatalf("ParseHello failed: %v", err)
+	}
+	if got.Version != 0x02 || got.Capabilities != CapCloseReasons|CapHalfClose {
+		t.Errorf("Unexpected hello: %+v", got)
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		name    string
+		local   Hello
+		remote  Hello
+		want    Hello
+		wantErr error
+	}{
+		{
+			name:   "same version",
+			local:  Hello{Version: 1, Capabilities: CapCloseReasons | CapHalfClose},
+			remote: Hello{Version: 1, Capabilities: CapCloseReasons | CapHalfClose},
+			want:   Hello{Version: 1, Capabilities: CapCloseReasons | CapHalfClose, MaxPayload: MaxPayloadSize},
+		},
+		{
+			name:   "newer remote",
+			local:  Hello{Version: 1, Capabilities: CapCloseReasons},
+			remote: Hello{Version: 3, Capabilities: CapCloseReasons | CapCompression},
+			want:   Hello{Version: 1, Capabilities: CapCloseReasons, MaxPayload: MaxPayloadSize},
+		},
+		{
+			name:   "older remote",
+			local:  Hello{Version: 3, Capabilities: CapCloseReasons | CapEncryption},
+			remote: Hello{Version: 2, Capabilities: CapEncryption},
+			want:   Hello{Version: 2, Capabilities: CapEncryption, MaxPayload: MaxPayloadSize},
+		},
+		{
+			name:   "smaller remote payload",
+			local:  Hello{Version: 1, MaxPayload: MaxPayloadSize},
+			remote: Hello{Version: 1, MaxPayload: 16384},
+			want:   Hello{Version: 1, MaxPayload: 16384},
+		},
+		{
+			name:   "jumbo frames",
+			local:  Hello{Version: 1, Capabilities: CapCompactHeader, MaxPayload: MaxJumboPayloadSize},
+			remote: Hello{Version: 1, Capabilities: CapCompactHeader, MaxPayload: 256 << 10},
+			want:   Hello{Version: 1, Capabilities: CapCompactHeader, MaxPayload: 256 << 10},
+		},
+		{
+			name:   "jumbo frames without compact header",
+			local:  Hello{Version: 1, MaxPayload: MaxJumboPayloadSize},
+			remote: Hello{Version: 1, MaxPayload: MaxJumboPayloadSize},
+			want:   Hello{Version: 1, MaxPayload: MaxPayloadSize},
+		},
+		{
+			name:    "unsupported remote",
+			local:   LocalHello(),
+			remote:  Hello{Version: 0},
+			wantErr: ErrInvalidVersion,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Negotiate(tt.local, tt.remote)
+			if err != tt.wantErr {
+				t.Fatalf("Expected error %v, got %v", tt.wantErr, err)
+			}
+			if got != tt.want {
+				t.Errorf("Expected %+v, got %+v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestCapabilityNames(t *testing.T) {
+	caps := CapEncryption | CapHalfClose | Capability(1<<31)
+	if got := caps.Names(); !reflect.DeepEqual(got, []string{"encryption", "half_close"}) {
+		t.Errorf("Expected [encryption half_close], got %v", got)
+	}
+	if caps.String() != "encryption,half_close" {
+		t.Errorf("Expected encryption,half_close, got %s", caps)
+	}
+	if !SupportedCapabilities.Has(CapHalfClose) || SupportedCapabilities.Has(CapCompression) {
+		t.Errorf("Unexpected supported capabilities: %s", SupportedCapabilities)
+	}
+}
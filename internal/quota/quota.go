@@ -0,0 +1,339 @@
+// Package quota enforces per-client traffic quotas and rate caps on the
+// Half-Tunnel server.
+//
+// Traffic is counted per client for the current local calendar day and month
+// and stored in a JSON state file, so usage survives restarts. Counters roll
+// over at local midnight and at the start of each month.
+package quota
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sahmadiut/half-tunnel/internal/statefile"
+)
+
+// Layouts of the day and month keys in the state file.
+const (
+	DayFormat   = "2006-01-02"
+	MonthFormat = "2006-01"
+)
+
+// Periods a quota applies to.
+const (
+	PeriodDaily   = "daily"
+	PeriodMonthly = "monthly"
+)
+
+// ErrUnknownClient is returned by Reset for a client with no limits or usage.
+var ErrUnknownClient = errors.New("unknown client")
+
+// Limits are the quotas and rate cap for one client. Zero values are
+// unlimited.
+type Limits struct {
+	// Daily is the traffic allowed per calendar day in bytes
+	Daily int64
+	// Monthly is the traffic allowed per calendar month in bytes
+	Monthly int64
+	// Rate caps the client's throughput in bytes per second, shared by all
+	// its sessions and both directions
+	Rate int64
+}
+
+// Usage is a client's traffic in the current day and month.
+type Usage struct {
+	Day        string `json:"day"`
+	DayBytes   int64  `json:"day_bytes"`
+	Month      string `json:"month"`
+	MonthBytes int64  `json:"month_bytes"`
+}
+
+// roll resets counters whose period has ended.
+func (u *Usage) roll(now time.Time) {
+	if day := now.Format(DayFormat); u.Day != day {
+		u.Day = day
+		u.DayBytes = 0
+	}
+	if month := now.Format(MonthFormat); u.Month != month {
+		u.Month = month
+		u.MonthBytes = 0
+	}
+}
+
+// exceeded returns the period whose quota usage has reached, or "".
+func (u *Usage) exceeded(limits Limits) string {
+	switch {
+	case limits.Daily > 0 && u.DayBytes >= limits.Daily:
+		return PeriodDaily
+	case limits.Monthly > 0 && u.MonthBytes >= limits.Monthly:
+		return PeriodMonthly
+	default:
+		return ""
+	}
+}
+
+// State is the persisted quota state.
+type State struct {
+	Version int               `json:"version"`
+	Clients map[string]*Usage `json:"clients"`
+}
+
+// Status describes a client's limits and current usage.
+type Status struct {
+	Client string
+	Limits Limits
+	Usage
+	// Exceeded is the period whose quota is used up, or "" if none is
+	Exceeded string
+}
+
+// Load reads a state file. A missing file yields an empty state.
+func Load(path string) (*State, error) {
+	state := &State{Version: 1, Clients: make(map[string]*Usage)}
+
+	if err := statefile.Load(path, "quota state", state); err != nil {
+		return nil, err
+	}
+	if state.Clients == nil {
+		state.Clients = make(map[string]*Usage)
+	}
+	return state, nil
+}
+
+// bucket is a token bucket for a client's rate cap.
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Tracker counts client traffic against their quotas and paces it under their
+// rate caps, periodically writing usage to a state file.
+type Tracker struct {
+	path     string
+	limits   map[string]Limits
+	defaults Limits
+	now      func() time.Time
+
+	state   *State
+	buckets map[string]*bucket
+	dirty   bool
+	mu      sync.Mutex
+
+	shutdown chan struct{}
+	wg       sync.WaitGroup
+}
+
+// New creates a tracker backed by the state file at path, loading any
+// existing usage. limits holds per-client limits; clients without an entry
+// get defaults.
+func New(path string, limits map[string]Limits, defaults Limits) (*Tracker, error) {
+	state, err := Load(path)
+	if err != nil {
+		return nil, err
+	}
+	if limits == nil {
+		limits = make(map[string]Limits)
+	}
+
+	return &Tracker{
+		path:     path,
+		limits:   limits,
+		defaults: defaults,
+		now:      time.Now,
+		state:    state,
+		buckets:  make(map[string]*bucket),
+		shutdown: make(chan struct{}),
+	}, nil
+}
+
+// Limits returns the limits that apply to client.
+func (t *Tracker) Limits(client string) Limits {
+	if limits, ok := t.limits[client]; ok {
+		return limits
+	}
+	return t.defaults
+}
+
+// usage returns client's usage for the current periods, creating it if
+// needed. Must be called with the lock held.
+func (t *Tracker) usage(client string) *Usage {
+	u, ok := t.state.Clients[client]
+	if !ok {
+		u = &Usage{}
+		t.state.Clients[client] = u
+	}
+	u.roll(t.now())
+	return u
+}
+
+// Add records n bytes of traffic for client. It returns the period whose
+// quota the client has used up, or "" while it is within its quotas.
+func (t *Tracker) Add(client string, n int) string {
+	if n <= 0 {
+		return t.Exceeded(client)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	u := t.usage(client)
+	u.DayBytes += int64(n)
+	u.MonthBytes += int64(n)
+	t.dirty = true
+	return u.exceeded(t.Limits(client))
+}
+
+// Exceeded returns the period whose quota client has used up, or "".
+func (t *Tracker) Exceeded(client string) string {
+	limits := t.Limits(client)
+	if limits.Daily <= 0 && limits.Monthly <= 0 {
+		return ""
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	u, ok := t.state.Clients[client]
+	if !ok {
+		return ""
+	}
+	u.roll(t.now())
+	return u.exceeded(limits)
+}
+
+// Reserve takes n bytes from client's rate cap and returns how long the
+// caller should wait before sending them. The allowance holds up to one
+// second of traffic, so short bursts are not delayed.
+func (t *Tracker) Reserve(client string, n int) time.Duration {
+	rate := t.Limits(client).Rate
+	if rate <= 0 || n <= 0 {
+		return 0
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	b, ok := t.buckets[client]
+	if !ok {
+		b = &bucket{tokens: float64(rate), last: now}
+		t.buckets[client] = b
+	}
+	b.tokens += now.Sub(b.last).Seconds() * float64(rate)
+	if b.tokens > float64(rate) {
+		b.tokens = float64(rate)
+	}
+	b.last = now
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / float64(rate) * float64(time.Second))
+}
+
+// Snapshot returns the status of every client with limits or usage, sorted
+// by name.
+func (t *Tracker) Snapshot() []Status {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	names := make(map[string]struct{}, len(t.state.Clients)+len(t.limits))
+	for name := range t.state.Clients {
+		names[name] = struct{}{}
+	}
+	for name := range t.limits {
+		names[name] = struct{}{}
+	}
+
+	statuses := make([]Status, 0, len(names))
+	for name := range names {
+		u := t.usage(name)
+		limits := t.Limits(name)
+		statuses = append(statuses, Status{
+			Client:   name,
+			Limits:   limits,
+			Usage:    *u,
+			Exceeded: u.exceeded(limits),
+		})
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Client < statuses[j].Client })
+	return statuses
+}
+
+// Reset clears client's usage for the current day and month.
+func (t *Tracker) Reset(client string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	_, hasUsage := t.state.Clients[client]
+	if _, hasLimits := t.limits[client]; !hasUsage && !hasLimits {
+		return ErrUnknownClient
+	}
+	u := t.usage(client)
+	u.DayBytes = 0
+	u.MonthBytes = 0
+	t.dirty = true
+	return nil
+}
+
+// Flush writes the state file if anything changed since the last flush.
+// A failed write leaves the usage pending for the next flush.
+func (t *Tracker) Flush() error {
+	t.mu.Lock()
+	if !t.dirty {
+		t.mu.Unlock()
+		return nil
+	}
+	data, err := json.MarshalIndent(t.state, "", "  ")
+	if err != nil {
+		t.mu.Unlock()
+		return fmt.Errorf("failed to encode quota state: %w", err)
+	}
+	t.dirty = false
+	t.mu.Unlock()
+
+	if err := statefile.Save(t.path, "quota state", data); err != nil {
+		t.mu.Lock()
+		t.dirty = true
+		t.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// Start flushes the state file every interval until Stop is called.
+// Flush errors are passed to onError if it is non-nil.
+func (t *Tracker) Start(interval time.Duration, onError func(error)) {
+	t.wg.Add(1)
+	go func() {
+		defer t.wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-t.shutdown:
+				return
+			case <-ticker.C:
+				if err := t.Flush(); err != nil && onError != nil {
+					onError(err)
+				}
+			}
+		}
+	}()
+}
+
+// Stop stops periodic flushing and writes any pending usage.
+func (t *Tracker) Stop() error {
+	select {
+	case <-t.shutdown:
+	default:
+		close(t.shutdown)
+	}
+	t.wg.Wait()
+	return t.Flush()
+}
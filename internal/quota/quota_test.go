@@ -0,0 +1,161 @@
+package quota
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTrackerQuotasRollOver(t *testing.T) {
+	tr, err := New(filepath.Join(t.TempDir(), "quotas.json"), map[string]Limits{
+		"laptop": {Daily: 100, Monthly: 250},
+	}, Limits{})
+	if err != nil {
+		t.Fatalf("Failed to create tracker: %v", err)
+	}
+
+	now := time.Date(2024, 3, 29, 12, 0, 0, 0, time.Local)
+	tr.now = func() time.Time { return now }
+
+	if got := tr.Add("laptop", 60); got != "" {
+		t.Errorf("Expected laptop within quota, got %q", got)
+	}
+	if got := tr.Add("laptop", 40); got != PeriodDaily {
+		t.Errorf("Expected daily quota used up, got %q", got)
+	}
+	if got := tr.Add("phone", 1000); got != "" {
+		t.Errorf("Expected a client without limits never to exceed, got %q", got)
+	}
+
+	// The daily counter rolls over at midnight, the monthly one does not
+	now = now.AddDate(0, 0, 1)
+	if got := tr.Exceeded("laptop"); got != "" {
+		t.Errorf("Expected a new day to reset the daily quota, got %q", got)
+	}
+	if got := tr.Add("laptop", 150); got != PeriodDaily {
+		t.Errorf("Expected daily quota used up, got %q", got)
+	}
+	now = now.AddDate(0, 0, 1)
+	if got := tr.Exceeded("laptop"); got != PeriodMonthly {
+		t.Errorf("Expected monthly quota used up, got %q", got)
+	}
+
+	now = now.AddDate(0, 0, 1)
+	if got := tr.Exceeded("laptop"); got != "" {
+		t.Errorf("Expected a new month to reset the monthly quota, got %q", got)
+	}
+}
+
+func TestTrackerPersistsAndResets(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "quotas.json")
+	limits := map[string]Limits{"laptop": {Monthly: 100}}
+
+	tr, err := New(path, limits, Limits{Daily: 1000})
+	if err != nil {
+		t.Fatalf("Failed to create tracker: %v", err)
+	}
+	tr.Add("laptop", 100)
+	tr.Add("phone", 10)
+	if err := tr.Stop(); err != nil {
+		t.Fatalf("Failed to stop tracker: %v", err)
+	}
+
+	// A new tracker picks up where the previous one left off
+	tr, err = New(path, limits, Limits{Daily: 1000})
+	if err != nil {
+		t.Fatalf("Failed to reopen tracker: %v", err)
+	}
+	if got := tr.Exceeded("laptop"); got != PeriodMonthly {
+		t.Errorf("Expected usage restored from the state file, got %q", got)
+	}
+
+	statuses := tr.Snapshot()
+	if len(statuses) != 2 || statuses[0].Client != "laptop" || statuses[1].Client != "phone" {
+		t.Fatalf("Expected laptop and phone, got %+v", statuses)
+	}
+	if statuses[0].MonthBytes != 100 || statuses[0].Exceeded != PeriodMonthly {
+		t.Errorf("Expected laptop at its monthly quota, got %+v", statuses[0])
+	}
+	if statuses[1].Limits.Daily != 1000 {
+		t.Errorf("Expected phone to get the default limits, got %+v", statuses[1].Limits)
+	}
+
+	if err := tr.Reset("laptop"); err != nil {
+		t.Fatalf("Reset failed: %v", err)
+	}
+	if got := tr.Exceeded("laptop"); got != "" {
+		t.Errorf("Expected reset to clear the quota, got %q", got)
+	}
+	if err := tr.Reset("tablet"); err != ErrUnknownClient {
+		t.Errorf("Expected ErrUnknownClient, got %v", err)
+	}
+}
+
+func TestTrackerReserveRate(t *testing.T) {
+	tr, err := New(filepath.Join(t.TempDir(), "quotas.json"), map[string]Limits{
+		"laptop": {Rate: 1000},
+	}, Limits{})
+	if err != nil {
+		t.Fatalf("Failed to create tracker: %v", err)
+	}
+	now := time.Date(2024, 3, 10, 12, 0, 0, 0, time.Local)
+	tr.now = func() time.Time { return now }
+
+	// The first second of traffic is allowed at once
+	if d := tr.Reserve("laptop", 1000); d != 0 {
+		t.Errorf("Expected no delay within the burst, got %v", d)
+	}
+	if d := tr.Reserve("phone", 1<<20); d != 0 {
+		t.Errorf("Expected no delay without a rate cap, got %v", d)
+	}
+	if d := tr.Reserve("laptop", 100); d != 100*time.Millisecond {
+		t.Errorf("Expected 100ms delay past the burst, got %v", d)
+	}
+	if d := tr.Reserve("laptop", 400); d != 500*time.Millisecond {
+		t.Errorf("Expected reservations to queue up, got %v", d)
+	}
+
+	// The allowance refills at the rate and holds at most one second
+	now = now.Add(10 * time.Second)
+	if d := tr.Reserve("laptop", 1000); d != 0 {
+		t.Errorf("Expected the allowance refilled, got %v", d)
+	}
+	if d := tr.Reserve("laptop", 1); d == 0 {
+		t.Error("Expected the allowance capped at one second of traffic")
+	}
+}
+
+func TestTrackerFlushRetriesFailedWrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "quotas.json")
+	tr, err := New(path, map[string]Limits{"laptop": {Daily: 100}}, Limits{})
+	if err != nil {
+		t.Fatalf("Failed to create tracker: %v", err)
+	}
+	tr.Add("laptop", 60)
+
+	// A directory in the way makes the write fail
+	if err := os.Mkdir(path, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(path, "keep"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := tr.Flush(); err == nil {
+		t.Fatal("Expected flush to fail")
+	}
+
+	if err := os.RemoveAll(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := tr.Flush(); err != nil {
+		t.Fatalf("Failed to flush: %v", err)
+	}
+	state, err := Load(path)
+	if err != nil {
+		t.Fatalf("Failed to load: %v", err)
+	}
+	if u := state.Clients["laptop"]; u == nil || u.DayBytes != 60 {
+		t.Errorf("Expected the usage of the failed flush written, got %+v", u)
+	}
+}
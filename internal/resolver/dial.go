@@ -0,0 +1,129 @@
+package resolver
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+// DialContext connects to address (host:port) with dialer, resolving host
+// through the resolver. When the answer holds both address families, the
+// first family is dialed first and the other joins after FallbackDelay or as
+// soon as the first family fails; the first connection established wins.
+// If the dialer has a local IP address, only addresses of its family are
+// dialed.
+func (r *Resolver) DialContext(ctx context.Context, dialer *net.Dialer, network, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	ips, err := r.LookupIP(ctx, host)
+	if err != nil {
+		return nil, &net.OpError{Op: "dial", Net: network, Err: err}
+	}
+	if local, ok := dialer.LocalAddr.(*net.TCPAddr); ok && local.IP != nil && !local.IP.IsUnspecified() {
+		ips = sameFamily(ips, local.IP.To4() != nil)
+		if len(ips) == 0 {
+			return nil, &net.OpError{Op: "dial", Net: network, Source: local, Err: &net.DNSError{
+				Err:        "no address matching the local address family",
+				Name:       host,
+				IsNotFound: true,
+			}}
+		}
+	}
+
+	var primaries, fallbacks []string
+	primaryV4 := ips[0].To4() != nil
+	for _, ip := range ips {
+		addr := net.JoinHostPort(ip.String(), port)
+		if (ip.To4() != nil) == primaryV4 {
+			primaries = append(primaries, addr)
+		} else {
+			fallbacks = append(fallbacks, addr)
+		}
+	}
+	return dialParallel(ctx, dialer, network, primaries, fallbacks, r.config.FallbackDelay)
+}
+
+// sameFamily returns the IPv4 (v4 true) or IPv6 addresses of ips.
+func sameFamily(ips []net.IP, v4 bool) []net.IP {
+	var matched []net.IP
+	for _, ip := range ips {
+		if (ip.To4() != nil) == v4 {
+			matched = append(matched, ip)
+		}
+	}
+	return matched
+}
+
+type dialResult struct {
+	conn    net.Conn
+	err     error
+	primary bool
+}
+
+// dialParallel races serial dials of primaries and fallbacks, starting the
+// fallbacks after delay or once the primaries have all failed.
+func dialParallel(ctx context.Context, dialer *net.Dialer, network string, primaries, fallbacks []string, delay time.Duration) (net.Conn, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make(chan dialResult)
+	returned := make(chan struct{})
+	defer close(returned)
+
+	dialSerial := func(addrs []string, primary bool) {
+		var err error
+		for _, addr := range addrs {
+			var conn net.Conn
+			conn, err = dialer.DialContext(ctx, network, addr)
+			if err == nil {
+				select {
+				case results <- dialResult{conn: conn, primary: primary}:
+				case <-returned:
+					conn.Close()
+				}
+				return
+			}
+		}
+		select {
+		case results <- dialResult{err: err, primary: primary}:
+		case <-returned:
+		}
+	}
+
+	go dialSerial(primaries, true)
+	pending := 1
+	var fallbackTimer <-chan time.Time
+	if len(fallbacks) > 0 {
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		fallbackTimer = timer.C
+	}
+	startFallback := func() {
+		fallbackTimer = nil
+		pending++
+		go dialSerial(fallbacks, false)
+	}
+
+	var firstErr error
+	for {
+		select {
+		case <-fallbackTimer:
+			startFallback()
+		case res := <-results:
+			if res.err == nil {
+				return res.conn, nil
+			}
+			if firstErr == nil || res.primary {
+				firstErr = res.err
+			}
+			pending--
+			if res.primary && fallbackTimer != nil {
+				startFallback()
+			} else if pending == 0 {
+				return nil, firstErr
+			}
+		}
+	}
+}
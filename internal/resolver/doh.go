@@ -0,0 +1,151 @@
+package resolver
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// maxDoHResponse bounds the size of a DNS-over-HTTPS response body.
+const maxDoHResponse = 64 * 1024
+
+// dohAnswer is the outcome of one DNS-over-HTTPS query. A nil error with no
+// addresses means the name has no records of the queried type.
+type dohAnswer struct {
+	ips []net.IP
+	ttl time.Duration
+	err error
+}
+
+// lookupDoH resolves host over DNS-over-HTTPS, querying A and AAAA records
+// in parallel as the family preference allows. It returns the lowest record
+// TTL.
+func (r *Resolver) lookupDoH(ctx context.Context, host string) ([]net.IP, time.Duration, error) {
+	var types []dnsmessage.Type
+	switch r.network() {
+	case "ip4":
+		types = []dnsmessage.Type{dnsmessage.TypeA}
+	case "ip6":
+		types = []dnsmessage.Type{dnsmessage.TypeAAAA}
+	default:
+		types = []dnsmessage.Type{dnsmessage.TypeA, dnsmessage.TypeAAAA}
+	}
+
+	answers := make([]dohAnswer, len(types))
+	done := make(chan struct{})
+	for i, qtype := range types {
+		go func(i int, qtype dnsmessage.Type) {
+			answers[i] = r.queryDoH(ctx, host, qtype)
+			done <- struct{}{}
+		}(i, qtype)
+	}
+	for range types {
+		<-done
+	}
+
+	var ips []net.IP
+	var ttl time.Duration
+	var firstErr error
+	for _, answer := range answers {
+		if answer.err != nil {
+			if firstErr == nil {
+				firstErr = answer.err
+			}
+			continue
+		}
+		ips = append(ips, answer.ips...)
+		if len(answer.ips) > 0 && (ttl == 0 || answer.ttl < ttl) {
+			ttl = answer.ttl
+		}
+	}
+
+	if len(ips) > 0 {
+		return ips, ttl, nil
+	}
+	if firstErr != nil {
+		return nil, 0, &net.DNSError{
+			Err:       firstErr.Error(),
+			Name:      host,
+			Server:    r.config.DoHURL,
+			IsTimeout: ctx.Err() == context.DeadlineExceeded,
+		}
+	}
+	return nil, 0, &net.DNSError{Err: "no such host", Name: host, Server: r.config.DoHURL, IsNotFound: true}
+}
+
+// queryDoH sends one query to the DNS-over-HTTPS endpoint.
+func (r *Resolver) queryDoH(ctx context.Context, host string, qtype dnsmessage.Type) dohAnswer {
+	name, err := dnsmessage.NewName(host + ".")
+	if err != nil {
+		return dohAnswer{err: fmt.Errorf("invalid name: %w", err)}
+	}
+	query := dnsmessage.Message{
+		Header: dnsmessage.Header{RecursionDesired: true},
+		Questions: []dnsmessage.Question{
+			{Name: name, Type: qtype, Class: dnsmessage.ClassINET},
+		},
+	}
+	packed, err := query.Pack()
+	if err != nil {
+		return dohAnswer{err: err}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.config.DoHURL, bytes.NewReader(packed))
+	if err != nil {
+		return dohAnswer{err: err}
+	}
+	req.Header.Set("Content-Type", "application/dns-message")
+	req.Header.Set("Accept", "application/dns-message")
+
+	resp, err := r.http.Do(req)
+	if err != nil {
+		return dohAnswer{err: err}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return dohAnswer{err: fmt.Errorf("DoH server returned %s", resp.Status)}
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxDoHResponse))
+	if err != nil {
+		return dohAnswer{err: err}
+	}
+
+	var reply dnsmessage.Message
+	if err := reply.Unpack(body); err != nil {
+		return dohAnswer{err: fmt.Errorf("invalid DoH response: %w", err)}
+	}
+	switch reply.RCode {
+	case dnsmessage.RCodeSuccess:
+	case dnsmessage.RCodeNameError:
+		return dohAnswer{}
+	default:
+		return dohAnswer{err: fmt.Errorf("DNS server returned %s", reply.RCode)}
+	}
+
+	var answer dohAnswer
+	for _, rr := range reply.Answers {
+		var ip net.IP
+		switch body := rr.Body.(type) {
+		case *dnsmessage.AResource:
+			ip = net.IP(body.A[:])
+		case *dnsmessage.AAAAResource:
+			ip = net.IP(body.AAAA[:])
+		default:
+			// CNAMEs are followed by the server; their targets' records
+			// are in the same answer
+			continue
+		}
+		answer.ips = append(answer.ips, ip)
+		ttl := time.Duration(rr.Header.TTL) * time.Second
+		if answer.ttl == 0 || ttl < answer.ttl {
+			answer.ttl = ttl
+		}
+	}
+	return answer
+}
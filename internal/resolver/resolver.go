@@ -0,0 +1,271 @@
+// Package resolver resolves destination names for the exit server. It can use
+// custom DNS servers or DNS-over-HTTPS instead of the system resolver, orders
+// addresses by family preference, caches answers, and dials resolved
+// addresses with happy eyeballs (RFC 8305).
+package resolver
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Preference selects which address families are used and in what order.
+type Preference string
+
+const (
+	// PreferAuto keeps the order of the resolver's answer.
+	PreferAuto Preference = "auto"
+	// PreferIPv4 tries IPv4 addresses first.
+	PreferIPv4 Preference = "ipv4"
+	// PreferIPv6 tries IPv6 addresses first.
+	PreferIPv6 Preference = "ipv6"
+	// PreferIPv4Only uses only IPv4 addresses.
+	PreferIPv4Only Preference = "ipv4_only"
+	// PreferIPv6Only uses only IPv6 addresses.
+	PreferIPv6Only Preference = "ipv6_only"
+)
+
+// Lookup results passed to the lookup observer.
+const (
+	ResultSuccess  = "success"
+	ResultNotFound = "not_found"
+	ResultError    = "error"
+)
+
+// Config holds resolver settings.
+type Config struct {
+	// Servers are DNS servers (host or host:port) queried instead of the
+	// system resolver
+	Servers []string
+	// DoHURL is a DNS-over-HTTPS endpoint (RFC 8484); it takes precedence
+	// over Servers
+	DoHURL string
+	// Timeout bounds each lookup
+	Timeout time.Duration
+	// Prefer selects address families and their order
+	Prefer Preference
+	// CacheTTL is the longest an answer is cached; records with a shorter
+	// TTL expire sooner (0 disables the cache)
+	CacheTTL time.Duration
+	// CacheSize caps the number of cached names
+	CacheSize int
+	// FallbackDelay is how long the preferred family gets before the other
+	// family is dialed in parallel
+	FallbackDelay time.Duration
+}
+
+// DefaultConfig returns default resolver configuration.
+func DefaultConfig() *Config {
+	return &Config{
+		Timeout:       5 * time.Second,
+		Prefer:        PreferAuto,
+		CacheTTL:      time.Minute,
+		CacheSize:     1024,
+		FallbackDelay: 300 * time.Millisecond,
+	}
+}
+
+// Resolver resolves and dials destination names.
+type Resolver struct {
+	config *Config
+	system *net.Resolver
+	http   *http.Client
+
+	cache   map[string]cacheEntry
+	cacheMu sync.Mutex
+
+	// next rotates through Servers
+	next uint32
+
+	onLookup func(result string, cached bool, duration time.Duration)
+}
+
+type cacheEntry struct {
+	ips     []net.IP
+	expires time.Time
+}
+
+// New creates a resolver.
+func New(config *Config) *Resolver {
+	if config == nil {
+		config = DefaultConfig()
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = 5 * time.Second
+	}
+	if config.FallbackDelay <= 0 {
+		config.FallbackDelay = 300 * time.Millisecond
+	}
+
+	r := &Resolver{
+		config: config,
+		system: net.DefaultResolver,
+		http:   &http.Client{Timeout: config.Timeout},
+		cache:  make(map[string]cacheEntry),
+	}
+	if len(config.Servers) > 0 {
+		r.system = &net.Resolver{PreferGo: true, Dial: r.dialServer}
+	}
+	return r
+}
+
+// SetOnLookup sets a callback run after every lookup with its result, whether
+// it was answered from the cache, and how long it took.
+func (r *Resolver) SetOnLookup(fn func(result string, cached bool, duration time.Duration)) {
+	r.onLookup = fn
+}
+
+// LookupIP resolves host to addresses ordered by preference. IP literals are
+// returned as is. Failures are *net.DNSError values.
+func (r *Resolver) LookupIP(ctx context.Context, host string) ([]net.IP, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IP{ip}, nil
+	}
+
+	key := strings.ToLower(strings.TrimSuffix(host, "."))
+	if ips, ok := r.cached(key); ok {
+		r.observe(ResultSuccess, true, 0)
+		return ips, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, r.config.Timeout)
+	defer cancel()
+
+	start := time.Now()
+	var ips []net.IP
+	var ttl time.Duration
+	var err error
+	if r.config.DoHURL != "" {
+		ips, ttl, err = r.lookupDoH(ctx, key)
+	} else {
+		ips, err = r.system.LookupIP(ctx, r.network(), key)
+	}
+	if err == nil {
+		ips = r.order(ips)
+		if len(ips) == 0 {
+			err = &net.DNSError{Err: "no suitable address found", Name: host, IsNotFound: true}
+		}
+	}
+	duration := time.Since(start)
+
+	if err != nil {
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+			r.observe(ResultNotFound, false, duration)
+		} else {
+			r.observe(ResultError, false, duration)
+		}
+		return nil, err
+	}
+
+	r.observe(ResultSuccess, false, duration)
+	r.store(key, ips, ttl)
+	return ips, nil
+}
+
+// network returns the lookup network for the family preference.
+func (r *Resolver) network() string {
+	switch r.config.Prefer {
+	case PreferIPv4Only:
+		return "ip4"
+	case PreferIPv6Only:
+		return "ip6"
+	default:
+		return "ip"
+	}
+}
+
+// order drops addresses of excluded families and moves the preferred family
+// first, keeping the resolver's order within each family.
+func (r *Resolver) order(ips []net.IP) []net.IP {
+	var v4, v6 []net.IP
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			v4 = append(v4, ip)
+		} else {
+			v6 = append(v6, ip)
+		}
+	}
+
+	switch r.config.Prefer {
+	case PreferIPv4:
+		return append(v4, v6...)
+	case PreferIPv6:
+		return append(v6, v4...)
+	case PreferIPv4Only:
+		return v4
+	case PreferIPv6Only:
+		return v6
+	default:
+		return ips
+	}
+}
+
+// dialServer connects the Go resolver to the next configured DNS server.
+func (r *Resolver) dialServer(ctx context.Context, network, _ string) (net.Conn, error) {
+	n := atomic.AddUint32(&r.next, 1)
+	server := r.config.Servers[int(n)%len(r.config.Servers)]
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(strings.Trim(server, "[]"), "53")
+	}
+	var d net.Dialer
+	return d.DialContext(ctx, network, server)
+}
+
+func (r *Resolver) observe(result string, cached bool, duration time.Duration) {
+	if r.onLookup != nil {
+		r.onLookup(result, cached, duration)
+	}
+}
+
+// cached returns the cached addresses for key, if fresh.
+func (r *Resolver) cached(key string) ([]net.IP, bool) {
+	if r.config.CacheTTL <= 0 {
+		return nil, false
+	}
+	r.cacheMu.Lock()
+	defer r.cacheMu.Unlock()
+
+	entry, ok := r.cache[key]
+	if !ok || time.Now().After(entry.expires) {
+		return nil, false
+	}
+	return entry.ips, true
+}
+
+// store caches ips for key for the record TTL, capped at CacheTTL. A ttl of
+// 0 means the record TTL is unknown.
+func (r *Resolver) store(key string, ips []net.IP, ttl time.Duration) {
+	if r.config.CacheTTL <= 0 || r.config.CacheSize <= 0 {
+		return
+	}
+	if ttl <= 0 || ttl > r.config.CacheTTL {
+		ttl = r.config.CacheTTL
+	}
+
+	r.cacheMu.Lock()
+	defer r.cacheMu.Unlock()
+
+	now := time.Now()
+	if len(r.cache) >= r.config.CacheSize {
+		for k, entry := range r.cache {
+			if now.After(entry.expires) {
+				delete(r.cache, k)
+			}
+		}
+	}
+	if len(r.cache) >= r.config.CacheSize {
+		// Still full of live entries: drop an arbitrary one
+		for k := range r.cache {
+			delete(r.cache, k)
+			break
+		}
+	}
+	r.cache[key] = cacheEntry{ips: ips, expires: now.Add(ttl)}
+}
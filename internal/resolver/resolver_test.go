@@ -0,0 +1,213 @@
+package resolver
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// answerQuery builds a reply to a packed DNS query from records, a map of
+// names to addresses. Unknown names get NXDOMAIN.
+func answerQuery(t *testing.T, packed []byte, records map[string][]net.IP) []byte {
+	t.Helper()
+	var query dnsmessage.Message
+	if err := query.Unpack(packed); err != nil {
+		t.Errorf("invalid query: %v", err)
+		return nil
+	}
+	q := query.Questions[0]
+	reply := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: query.ID, Response: true, RecursionAvailable: true},
+		Questions: query.Questions,
+	}
+
+	ips, ok := records[q.Name.String()]
+	if !ok {
+		reply.RCode = dnsmessage.RCodeNameError
+	}
+	for _, ip := range ips {
+		header := dnsmessage.ResourceHeader{Name: q.Name, Type: q.Type, Class: dnsmessage.ClassINET, TTL: 30}
+		if ip4 := ip.To4(); ip4 != nil && q.Type == dnsmessage.TypeA {
+			var a [4]byte
+			copy(a[:], ip4)
+			reply.Answers = append(reply.Answers, dnsmessage.Resource{Header: header, Body: &dnsmessage.AResource{A: a}})
+		} else if ip.To4() == nil && q.Type == dnsmessage.TypeAAAA {
+			var aaaa [16]byte
+			copy(aaaa[:], ip)
+			reply.Answers = append(reply.Answers, dnsmessage.Resource{Header: header, Body: &dnsmessage.AAAAResource{AAAA: aaaa}})
+		}
+	}
+
+	data, err := reply.Pack()
+	if err != nil {
+		t.Errorf("failed to pack reply: %v", err)
+	}
+	return data
+}
+
+var testRecords = map[string][]net.IP{
+	"example.com.": {net.ParseIP("192.0.2.1"), net.ParseIP("2001:db8::1")},
+	"v4.example.":  {net.ParseIP("192.0.2.2")},
+}
+
+func newDoHServer(t *testing.T, queries *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(queries, 1)
+		if r.Header.Get("Content-Type") != "application/dns-message" {
+			http.Error(w, "bad content type", http.StatusUnsupportedMediaType)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/dns-message")
+		_, _ = w.Write(answerQuery(t, body, testRecords))
+	}))
+}
+
+func TestLookupIPLiteral(t *testing.T) {
+	r := New(nil)
+	ips, err := r.LookupIP(context.Background(), "2001:db8::5")
+	if err != nil || len(ips) != 1 || !ips[0].Equal(net.ParseIP("2001:db8::5")) {
+		t.Errorf("Expected the literal back, got %v, %v", ips, err)
+	}
+}
+
+func TestLookupDoHWithCache(t *testing.T) {
+	var queries int32
+	server := newDoHServer(t, &queries)
+	defer server.Close()
+
+	config := DefaultConfig()
+	config.DoHURL = server.URL
+	config.Prefer = PreferIPv6
+	r := New(config)
+
+	var hits int
+	r.SetOnLookup(func(result string, cached bool, _ time.Duration) {
+		if cached {
+			hits++
+		}
+	})
+
+	ips, err := r.LookupIP(context.Background(), "example.com")
+	if err != nil {
+		t.Fatalf("LookupIP failed: %v", err)
+	}
+	if len(ips) != 2 || !ips[0].Equal(net.ParseIP("2001:db8::1")) || !ips[1].Equal(net.ParseIP("192.0.2.1")) {
+		t.Errorf("Expected IPv6 address first, got %v", ips)
+	}
+	if n := atomic.LoadInt32(&queries); n != 2 {
+		t.Errorf("Expected A and AAAA queries, got %d", n)
+	}
+
+	if _, err := r.LookupIP(context.Background(), "EXAMPLE.com."); err != nil {
+		t.Fatalf("Cached LookupIP failed: %v", err)
+	}
+	if n := atomic.LoadInt32(&queries); n != 2 || hits != 1 {
+		t.Errorf("Expected the second lookup from the cache, got %d queries and %d hits", n, hits)
+	}
+
+	_, err = r.LookupIP(context.Background(), "missing.example")
+	var dnsErr *net.DNSError
+	if !errors.As(err, &dnsErr) || !dnsErr.IsNotFound {
+		t.Errorf("Expected not found error, got %v", err)
+	}
+}
+
+func TestLookupFamilyOnly(t *testing.T) {
+	var queries int32
+	server := newDoHServer(t, &queries)
+	defer server.Close()
+
+	config := DefaultConfig()
+	config.DoHURL = server.URL
+	config.Prefer = PreferIPv6Only
+	r := New(config)
+
+	_, err := r.LookupIP(context.Background(), "v4.example")
+	var dnsErr *net.DNSError
+	if !errors.As(err, &dnsErr) || !dnsErr.IsNotFound {
+		t.Errorf("Expected not found for an IPv4-only name, got %v", err)
+	}
+	if n := atomic.LoadInt32(&queries); n != 1 {
+		t.Errorf("Expected only an AAAA query, got %d queries", n)
+	}
+}
+
+func TestLookupCustomServer(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("ListenPacket failed: %v", err)
+	}
+	defer conn.Close()
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			_, _ = conn.WriteTo(answerQuery(t, buf[:n], testRecords), addr)
+		}
+	}()
+
+	config := DefaultConfig()
+	config.Servers = []string{conn.LocalAddr().String()}
+	config.Prefer = PreferIPv4Only
+	r := New(config)
+
+	ips, err := r.LookupIP(context.Background(), "example.com")
+	if err != nil {
+		t.Fatalf("LookupIP failed: %v", err)
+	}
+	if len(ips) != 1 || !ips[0].Equal(net.ParseIP("192.0.2.1")) {
+		t.Errorf("Expected only the IPv4 address, got %v", ips)
+	}
+}
+
+func TestDialParallelFallsBack(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err == nil {
+			conn.Close()
+		}
+	}()
+
+	// A port with nothing listening on it
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	closedAddr := closed.Addr().String()
+	closed.Close()
+
+	var dialer net.Dialer
+	start := time.Now()
+	conn, err := dialParallel(context.Background(), &dialer, "tcp", []string{closedAddr}, []string{ln.Addr().String()}, time.Minute)
+	if err != nil {
+		t.Fatalf("dialParallel failed: %v", err)
+	}
+	conn.Close()
+	if conn.RemoteAddr().String() != ln.Addr().String() {
+		t.Errorf("Expected the fallback address, got %s", conn.RemoteAddr())
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Error("Expected the fallback to start as soon as the primary failed")
+	}
+
+	if _, err := dialParallel(context.Background(), &dialer, "tcp", []string{closedAddr}, nil, time.Minute); err == nil {
+		t.Error("Expected an error when every address fails")
+	}
+}
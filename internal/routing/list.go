@@ -0,0 +1,48 @@
+package routing
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+)
+
+// LoadList reads a list file for a rule. Each line holds a domain (matching
+// its subdomains too) or a CIDR; a "domain:" prefix, a leading "*." or "."
+// and everything after a "#" are ignored, as are blank lines.
+func LoadList(path string) ([]string, []*net.IPNet, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	var domains []string
+	var networks []*net.IPNet
+	scanner := bufio.NewScanner(f)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if _, network, err := net.ParseCIDR(line); err == nil {
+			networks = append(networks, network)
+			continue
+		}
+		line = strings.TrimPrefix(line, "domain:")
+		line = strings.TrimPrefix(strings.TrimPrefix(line, "*"), ".")
+		if line == "" || strings.ContainsAny(line, " \t/:") {
+			return nil, nil, fmt.Errorf("%s:%d: invalid entry %q", path, lineNo, scanner.Text())
+		}
+		domains = append(domains, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+	return domains, networks, nil
+}
@@ -0,0 +1,226 @@
+// Package routing decides how the client handles a destination: through the
+// tunnel, dialed directly from the client, or blocked.
+package routing
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Action is what the client does with a connection.
+type Action string
+
+const (
+	// ActionTunnel sends the connection through the tunnel.
+	ActionTunnel Action = "tunnel"
+	// ActionDirect dials the destination from the client.
+	ActionDirect Action = "direct"
+	// ActionBlock refuses the connection.
+	ActionBlock Action = "block"
+)
+
+// ParseAction returns the action called name.
+func ParseAction(name string) (Action, error) {
+	switch Action(name) {
+	case ActionTunnel, ActionDirect, ActionBlock:
+		return Action(name), nil
+	default:
+		return "", fmt.Errorf("invalid routing action: %q (use tunnel, direct or block)", name)
+	}
+}
+
+// Rule applies an action to the destinations it matches. A rule matches a
+// destination whose host is in one of Domains, Networks or Countries (any
+// host when all are empty) and whose port is in Ports (any port when empty).
+// Networks and Countries match destinations given as IP addresses, and names
+// too when the router resolves them.
+type Rule struct {
+	// Name identifies the rule in logs and metrics
+	Name string
+	// Domains match destination names and their subdomains
+	Domains []string
+	// Networks match destination addresses
+	Networks []*net.IPNet
+	// Countries match destination addresses located in these countries
+	// (ISO 3166-1 alpha-2 codes); they need a GeoIP database
+	Countries []string
+	// Ports restrict the rule to these destination ports
+	Ports []uint16
+	// Action is applied to matching destinations
+	Action Action
+}
+
+// CountryLookup returns the ISO country code of an address, or "" when it is
+// unknown. *geoip.Reader implements it.
+type CountryLookup interface {
+	Country(ip net.IP) (string, error)
+}
+
+// rule is a Rule prepared for matching.
+type rule struct {
+	name      string
+	domains   map[string]struct{}
+	networks  []*net.IPNet
+	countries map[string]struct{}
+	ports     []uint16
+	action    Action
+}
+
+// needsIP reports whether the rule can match on the destination address.
+func (r *rule) needsIP() bool {
+	return len(r.networks) > 0 || len(r.countries) > 0
+}
+
+// Router picks the action for a destination from an ordered list of rules.
+type Router struct {
+	rules         []rule
+	defaultAction Action
+	geoip         CountryLookup
+	resolve       bool
+	lookupIP      func(ctx context.Context, host string) ([]net.IP, error)
+}
+
+// New creates a router. Rules are checked in order and the first match wins;
+// defaultAction applies when none matches (empty means ActionTunnel).
+// Unnamed rules are called "rule-<n>", counting from 1.
+func New(rules []Rule, defaultAction Action) *Router {
+	if defaultAction == "" {
+		defaultAction = ActionTunnel
+	}
+	r := &Router{
+		defaultAction: defaultAction,
+		lookupIP: func(ctx context.Context, host string) ([]net.IP, error) {
+			return net.DefaultResolver.LookupIP(ctx, "ip", host)
+		},
+	}
+	for i, src := range rules {
+		compiled := rule{
+			name:     src.Name,
+			networks: src.Networks,
+			ports:    src.Ports,
+			action:   src.Action,
+		}
+		if compiled.name == "" {
+			compiled.name = fmt.Sprintf("rule-%d", i+1)
+		}
+		if len(src.Domains) > 0 {
+			compiled.domains = make(map[string]struct{}, len(src.Domains))
+			for _, domain := range src.Domains {
+				compiled.domains[normalizeDomain(domain)] = struct{}{}
+			}
+		}
+		if len(src.Countries) > 0 {
+			compiled.countries = make(map[string]struct{}, len(src.Countries))
+			for _, country := range src.Countries {
+				compiled.countries[strings.ToUpper(country)] = struct{}{}
+			}
+		}
+		r.rules = append(r.rules, compiled)
+	}
+	return r
+}
+
+// SetGeoIP sets the database used by rules with Countries.
+func (r *Router) SetGeoIP(db CountryLookup) {
+	r.geoip = db
+}
+
+// SetResolve makes the router resolve destination names so that Networks and
+// Countries match them too. Names are resolved on the client, once per
+// connection, and only when a rule needs an address.
+func (r *Router) SetResolve(resolve bool) {
+	r.resolve = resolve
+}
+
+// Route returns the action for host:port and the name of the matching rule,
+// empty when the default applied. A nil router tunnels everything.
+func (r *Router) Route(ctx context.Context, host string, port uint16) (Action, string) {
+	if r == nil {
+		return ActionTunnel, ""
+	}
+
+	// Names are resolved at most once, when the first rule needs addresses
+	var ips []net.IP
+	name := ""
+	resolved := true
+	if ip := net.ParseIP(host); ip != nil {
+		ips = []net.IP{ip}
+	} else {
+		name = normalizeDomain(host)
+		resolved = !r.resolve
+	}
+
+	for i := range r.rules {
+		rl := &r.rules[i]
+		if len(rl.ports) > 0 && !containsPort(rl.ports, port) {
+			continue
+		}
+		if rl.domains == nil && !rl.needsIP() {
+			return rl.action, rl.name
+		}
+		if name != "" && rl.domains != nil && matchDomain(rl.domains, name) {
+			return rl.action, rl.name
+		}
+		if !rl.needsIP() {
+			continue
+		}
+		if !resolved {
+			ips, _ = r.lookupIP(ctx, host)
+			resolved = true
+		}
+		if r.matchesIP(rl, ips) {
+			return rl.action, rl.name
+		}
+	}
+	return r.defaultAction, ""
+}
+
+// matchesIP reports whether one of ips is in the rule's networks or
+// countries.
+func (r *Router) matchesIP(rl *rule, ips []net.IP) bool {
+	for _, ip := range ips {
+		for _, network := range rl.networks {
+			if network.Contains(ip) {
+				return true
+			}
+		}
+		if rl.countries != nil && r.geoip != nil {
+			if country, err := r.geoip.Country(ip); err == nil && country != "" {
+				if _, ok := rl.countries[country]; ok {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// matchDomain reports whether name or one of its parent domains is in
+// domains.
+func matchDomain(domains map[string]struct{}, name string) bool {
+	for {
+		if _, ok := domains[name]; ok {
+			return true
+		}
+		i := strings.IndexByte(name, '.')
+		if i < 0 {
+			return false
+		}
+		name = name[i+1:]
+	}
+}
+
+func normalizeDomain(domain string) string {
+	return strings.Trim(strings.ToLower(domain), ".")
+}
+
+func containsPort(ports []uint16, port uint16) bool {
+	for _, p := range ports {
+		if p == port {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,162 @@
+package routing
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func mustCIDR(t *testing.T, s string) *net.IPNet {
+	t.Helper()
+	_, network, err := net.ParseCIDR(s)
+	if err != nil {
+		t.Fatalf("invalid CIDR %q: %v", s, err)
+	}
+	return network
+}
+
+func TestRoute(t *testing.T) {
+	router := New([]Rule{
+		{Name: "ads", Domains: []string{"ads.example"}, Action: ActionBlock},
+		{Name: "lan", Networks: []*net.IPNet{mustCIDR(t, "192.168.0.0/16")}, Action: ActionDirect},
+		{Name: "local-web", Domains: []string{"example.org"}, Ports: []uint16{80, 443}, Action: ActionDirect},
+		{Name: "smtp", Ports: []uint16{25}, Action: ActionBlock},
+	}, ActionTunnel)
+
+	tests := []struct {
+		host       string
+		port       uint16
+		wantAction Action
+		wantRule   string
+	}{
+		{"tracker.ads.example", 443, ActionBlock, "ads"},
+		{"ADS.example.", 80, ActionBlock, "ads"},
+		{"badads.example", 80, ActionTunnel, ""},
+		{"192.168.1.10", 22, ActionDirect, "lan"},
+		{"10.0.0.1", 22, ActionTunnel, ""},
+		{"www.example.org", 443, ActionDirect, "local-web"},
+		{"www.example.org", 8080, ActionTunnel, ""},
+		{"mail.example.com", 25, ActionBlock, "smtp"},
+	}
+	for _, tt := range tests {
+		action, rule := router.Route(context.Background(), tt.host, tt.port)
+		if action != tt.wantAction || rule != tt.wantRule {
+			t.Errorf("Route(%s, %d) = %s, %q; want %s, %q", tt.host, tt.port, action, rule, tt.wantAction, tt.wantRule)
+		}
+	}
+}
+
+func TestRouteDefaults(t *testing.T) {
+	var nilRouter *Router
+	if action, _ := nilRouter.Route(context.Background(), "example.com", 443); action != ActionTunnel {
+		t.Errorf("Expected a nil router to tunnel, got %s", action)
+	}
+	if action, _ := New(nil, "").Route(context.Background(), "example.com", 443); action != ActionTunnel {
+		t.Errorf("Expected tunnel by default, got %s", action)
+	}
+	if action, _ := New(nil, ActionDirect).Route(context.Background(), "example.com", 443); action != ActionDirect {
+		t.Errorf("Expected the configured default, got %s", action)
+	}
+}
+
+func TestParseAction(t *testing.T) {
+	for _, name := range []string{"tunnel", "direct", "block"} {
+		if _, err := ParseAction(name); err != nil {
+			t.Errorf("ParseAction(%q) returned error: %v", name, err)
+		}
+	}
+	if _, err := ParseAction("drop"); err == nil {
+		t.Error("Expected error for an unknown action")
+	}
+}
+
+// fakeGeoIP maps addresses to countries.
+type fakeGeoIP map[string]string
+
+func (f fakeGeoIP) Country(ip net.IP) (string, error) {
+	if ip.Equal(net.ParseIP("203.0.113.99")) {
+		return "", errors.New("lookup failed")
+	}
+	return f[ip.String()], nil
+}
+
+func TestRouteCountriesAndResolve(t *testing.T) {
+	router := New([]Rule{
+		{Domains: []string{"blocked.example"}, Action: ActionBlock},
+		{Name: "domestic", Countries: []string{"de"}, Action: ActionDirect},
+	}, ActionTunnel)
+	router.SetGeoIP(fakeGeoIP{"192.0.2.1": "DE", "198.51.100.1": "US"})
+
+	var lookups int
+	router.lookupIP = func(ctx context.Context, host string) ([]net.IP, error) {
+		lookups++
+		if host == "shop.example.de" {
+			return []net.IP{net.ParseIP("192.0.2.1")}, nil
+		}
+		return nil, errors.New("no such host")
+	}
+
+	tests := []struct {
+		host       string
+		resolve    bool
+		wantAction Action
+		wantRule   string
+	}{
+		{"192.0.2.1", false, ActionDirect, "domestic"},
+		{"198.51.100.1", false, ActionTunnel, ""},
+		{"203.0.113.99", false, ActionTunnel, ""},
+		{"shop.example.de", false, ActionTunnel, ""},
+		{"shop.example.de", true, ActionDirect, "domestic"},
+		{"missing.example", true, ActionTunnel, ""},
+		{"www.blocked.example", true, ActionBlock, "rule-1"},
+	}
+	for _, tt := range tests {
+		router.SetResolve(tt.resolve)
+		action, rule := router.Route(context.Background(), tt.host, 443)
+		if action != tt.wantAction || rule != tt.wantRule {
+			t.Errorf("Route(%s, resolve=%v) = %s, %q; want %s, %q", tt.host, tt.resolve, action, rule, tt.wantAction, tt.wantRule)
+		}
+	}
+	if lookups != 2 {
+		t.Errorf("Expected 2 lookups, got %d", lookups)
+	}
+}
+
+func TestLoadList(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "domestic.txt")
+	content := `# Domestic sites
+example.de
+domain:shop.example   # with a prefix
+*.cdn.example
+10.0.0.0/8
+
+`
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write list: %v", err)
+	}
+
+	domains, networks, err := LoadList(path)
+	if err != nil {
+		t.Fatalf("LoadList failed: %v", err)
+	}
+	if want := []string{"example.de", "shop.example", "cdn.example"}; !reflect.DeepEqual(domains, want) {
+		t.Errorf("Expected domains %v, got %v", want, domains)
+	}
+	if len(networks) != 1 || networks[0].String() != "10.0.0.0/8" {
+		t.Errorf("Expected the 10.0.0.0/8 network, got %v", networks)
+	}
+
+	if err := os.WriteFile(path, []byte("10.0.0.0/33\n"), 0644); err != nil {
+		t.Fatalf("Failed to write list: %v", err)
+	}
+	if _, _, err := LoadList(path); err == nil {
+		t.Error("Expected error for an invalid entry")
+	}
+	if _, _, err := LoadList(filepath.Join(t.TempDir(), "missing.txt")); err == nil {
+		t.Error("Expected error for a missing file")
+	}
+}
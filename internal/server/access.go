@@ -0,0 +1,111 @@
+package server
+
+import (
+	"net"
+	"strings"
+	"time"
+)
+
+// DialRetryPolicy controls how often the server tries to connect to a
+// destination before giving up on a stream.
+type DialRetryPolicy struct {
+	// Attempts is the total number of dials (1 disables retries)
+	Attempts int
+	// Backoff is the delay before the first retry; it doubles per retry
+	Backoff time.Duration
+	// MaxBackoff caps the delay between retries
+	MaxBackoff time.Duration
+}
+
+// DefaultDialRetryPolicy returns the default policy: a single attempt.
+func DefaultDialRetryPolicy() DialRetryPolicy {
+	return DialRetryPolicy{
+		Attempts:   1,
+		Backoff:    250 * time.Millisecond,
+		MaxBackoff: 5 * time.Second,
+	}
+}
+
+// withDefaults fills unset fields from def.
+func (p DialRetryPolicy) withDefaults(def DialRetryPolicy) DialRetryPolicy {
+	if p.Attempts <= 0 {
+		p.Attempts = def.Attempts
+	}
+	if p.Backoff <= 0 {
+		p.Backoff = def.Backoff
+	}
+	if p.MaxBackoff <= 0 {
+		p.MaxBackoff = def.MaxBackoff
+	}
+	return p
+}
+
+// AccessRule applies settings to the destinations it matches. A rule matches
+// a destination whose host is in one of Networks or Domains (any host when
+// both are empty) and whose port is in Ports (any port when empty).
+type AccessRule struct {
+	// Name identifies the rule in logs
+	Name string
+	// Networks match destinations given as IP addresses
+	Networks []*net.IPNet
+	// Domains match destination names and their subdomains
+	Domains []string
+	// Ports restrict the rule to these destination ports
+	Ports []uint16
+	// DialRetry overrides the server's dial retry policy (nil keeps it)
+	DialRetry *DialRetryPolicy
+}
+
+// Matches reports whether the rule applies to host:port.
+func (r *AccessRule) Matches(host string, port uint16) bool {
+	if len(r.Ports) > 0 && !containsPort(r.Ports, port) {
+		return false
+	}
+	if len(r.Networks) == 0 && len(r.Domains) == 0 {
+		return true
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		for _, network := range r.Networks {
+			if network.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}
+	return matchesDomain(r.Domains, host)
+}
+
+// matchesDomain reports whether host is one of domains or a subdomain of
+// one.
+func matchesDomain(domains []string, host string) bool {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	for _, domain := range domains {
+		domain = strings.TrimSuffix(strings.ToLower(domain), ".")
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsPort(ports []uint16, port uint16) bool {
+	for _, p := range ports {
+		if p == port {
+			return true
+		}
+	}
+	return false
+}
+
+// dialRetryPolicy returns the retry policy for a destination: that of the
+// first matching rule with one, or the server default.
+func (s *Server) dialRetryPolicy(host string, port uint16) DialRetryPolicy {
+	def := s.config.DialRetry.withDefaults(DefaultDialRetryPolicy())
+	for i := range s.config.AccessRules {
+		rule := &s.config.AccessRules[i]
+		if rule.DialRetry != nil && rule.Matches(host, port) {
+			return rule.DialRetry.withDefaults(def)
+		}
+	}
+	return def
+}
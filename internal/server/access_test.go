@@ -0,0 +1,89 @@
+package server
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/sahmadiut/half-tunnel/internal/protocol"
+)
+
+func TestAccessRuleMatches(t *testing.T) {
+	_, private, _ := net.ParseCIDR("10.0.0.0/8")
+	rule := AccessRule{
+		Networks: []*net.IPNet{private},
+		Domains:  []string{"internal.example"},
+		Ports:    []uint16{443},
+	}
+
+	tests := []struct {
+		host string
+		port uint16
+		want bool
+	}{
+		{"10.1.2.3", 443, true},
+		{"10.1.2.3", 80, false},
+		{"192.168.1.1", 443, false},
+		{"internal.example", 443, true},
+		{"API.Internal.Example.", 443, true},
+		{"notinternal.example", 443, false},
+	}
+
+	for _, tt := range tests {
+		if got := rule.Matches(tt.host, tt.port); got != tt.want {
+			t.Errorf("Matches(%s, %d) = %v, want %v", tt.host, tt.port, got, tt.want)
+		}
+	}
+
+	allHosts := AccessRule{Ports: []uint16{22}}
+	if !allHosts.Matches("example.com", 22) || allHosts.Matches("example.com", 23) {
+		t.Error("Expected a rule without hosts to match any host on its ports")
+	}
+}
+
+func TestDialRetryPolicySelection(t *testing.T) {
+	config := DefaultConfig()
+	config.AccessRules = []AccessRule{
+		{Name: "no-override", Domains: []string{"example.com"}},
+		{Name: "flaky", Domains: []string{"example.com"}, DialRetry: &DialRetryPolicy{Attempts: 4}},
+	}
+	s := New(config, nil)
+	defer s.sessionStore.Close()
+
+	policy := s.dialRetryPolicy("api.example.com", 443)
+	if policy.Attempts != 4 {
+		t.Errorf("Expected 4 attempts from the matching rule, got %d", policy.Attempts)
+	}
+	if policy.Backoff != DefaultDialRetryPolicy().Backoff {
+		t.Errorf("Expected unset backoff to inherit the default, got %v", policy.Backoff)
+	}
+	if policy := s.dialRetryPolicy("example.org", 443); policy.Attempts != 1 {
+		t.Errorf("Expected the default single attempt, got %d", policy.Attempts)
+	}
+}
+
+func TestDialWithRetry(t *testing.T) {
+	// Reserve a port with nothing listening on it
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	s := New(DefaultConfig(), nil)
+	defer s.sessionStore.Close()
+
+	policy := DialRetryPolicy{Attempts: 3, Backoff: 10 * time.Millisecond, MaxBackoff: 20 * time.Millisecond}
+	_, attempts, err := s.dialWithRetry(context.Background(), addr, 0, policy)
+	if err == nil {
+		t.Fatal("Expected dial to fail")
+	}
+	if attempts != 3 {
+		t.Errorf("Expected 3 attempts, got %d", attempts)
+	}
+	if code := dialErrorCode(err); code != protocol.StreamErrorRefused {
+		t.Errorf("Expected refused, got %s", code)
+	}
+}
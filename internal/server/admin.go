@@ -0,0 +1,233 @@
+package server
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sahmadiut/half-tunnel/internal/admin"
+	"github.com/sahmadiut/half-tunnel/internal/protocol"
+)
+
+// maxClosedStreamHistory is the number of closed streams kept for the admin
+// API.
+const maxClosedStreamHistory = 50
+
+// AdminStatus returns a snapshot of the server's sessions, streams and NAT
+// table for the admin API.
+func (s *Server) AdminStatus() *admin.Status {
+	s.metricsMu.RLock()
+	traffic := admin.Traffic{
+		BytesSent:       s.metrics.BytesSent,
+		BytesReceived:   s.metrics.BytesReceived,
+		PacketsSent:     s.metrics.PacketsSent,
+		PacketsReceived: s.metrics.PacketsReceived,
+	}
+	s.metricsMu.RUnlock()
+
+	status := &admin.Status{
+		Role:       "server",
+		Connected:  atomic.LoadInt32(&s.running) == 1,
+		StartedAt:  s.createdAt,
+		Traffic:    traffic,
+		Sessions:   []admin.Session{},
+		Streams:    []admin.Stream{},
+		NAT:        []admin.NATEntry{},
+		Reconnects: []admin.Reconnect{},
+	}
+	status.ClosedStreams = s.closedStreamHistory()
+
+	for _, sess := range s.sessionStore.List() {
+		version, caps := sess.Protocol()
+		failures, failingSince := s.sessionFlowStats(sess.ID)
+		info := admin.Session{
+			ID:                 sess.ID,
+			Client:             sess.Identity(),
+			CreatedAt:          sess.CreatedAt,
+			LastActivity:       sess.LastActivity(),
+			Streams:            len(s.sessionStreams(sess.ID)),
+			Protocol:           int(version),
+			Capabilities:       protocol.Capability(caps).Names(),
+			DownstreamFailures: failures,
+		}
+		if failures > 0 {
+			info.DownstreamFailingSince = &failingSince
+		}
+		status.Sessions = append(status.Sessions, info)
+	}
+
+	s.natTableMu.RLock()
+	for key, entry := range s.natTable {
+		stream := admin.Stream{
+			SessionID:    key.SessionID,
+			StreamID:     key.StreamID,
+			Dest:         entry.destAddr,
+			BytesUp:      atomic.LoadInt64(&entry.bytesUp),
+			BytesDown:    atomic.LoadInt64(&entry.bytesDown),
+			CreatedAt:    entry.created,
+			LastActivity: entry.created,
+		}
+		if entry.stream != nil {
+			// Up is from the client, which the server receives
+			stats := entry.stream.Stats()
+			stream.PacketsUp = stats.PacketsRecv
+			stream.PacketsDown = stats.PacketsSent
+			stream.LastActivity = stats.LastActivity
+		}
+		status.Streams = append(status.Streams, stream)
+		// Streams still dialing their destination have no NAT mapping yet
+		conn := entry.destConn()
+		if conn == nil {
+			continue
+		}
+		status.NAT = append(status.NAT, admin.NATEntry{
+			SessionID:  key.SessionID,
+			StreamID:   key.StreamID,
+			LocalAddr:  conn.LocalAddr().String(),
+			RemoteAddr: conn.RemoteAddr().String(),
+			CreatedAt:  entry.created,
+		})
+	}
+	s.natTableMu.RUnlock()
+
+	return status
+}
+
+// CloseStream closes one stream, telling the client with a FIN.
+func (s *Server) CloseStream(sessionID uuid.UUID, streamID uint32) error {
+	s.natTableMu.RLock()
+	_, exists := s.natTable[natKey{SessionID: sessionID, StreamID: streamID}]
+	s.natTableMu.RUnlock()
+	if !exists {
+		return admin.ErrStreamNotFound
+	}
+
+	fin := protocol.Fin{Reason: protocol.CloseAdmin}
+	s.sendFin(sessionID, streamID, fin)
+	s.closeNatEntry(sessionID, streamID, streamCloseAdmin, fin)
+	return nil
+}
+
+// DrainSession closes every stream of a session. The session itself stays
+// connected and can open new streams.
+func (s *Server) DrainSession(sessionID uuid.UUID) error {
+	if _, exists := s.sessionStore.Get(sessionID); !exists {
+		return admin.ErrSessionNotFound
+	}
+
+	fin := protocol.Fin{Reason: protocol.CloseAdmin}
+	streams := s.sessionStreams(sessionID)
+	for _, streamID := range streams {
+		s.sendFin(sessionID, streamID, fin)
+		s.closeNatEntry(sessionID, streamID, streamCloseAdmin, fin)
+	}
+
+	s.log.Info().
+		Str("session_id", sessionID.String()).
+		Int("streams", len(streams)).
+		Msg("Session drained")
+	return nil
+}
+
+// CloseSession closes a session's streams and downstream connection and
+// removes it from the session store. The client reconnects with a new session.
+func (s *Server) CloseSession(sessionID uuid.UUID) error {
+	if _, exists := s.sessionStore.Get(sessionID); !exists {
+		return admin.ErrSessionNotFound
+	}
+
+	s.teardownSession(sessionID, closeReasonAdmin)
+	return nil
+}
+
+// Reasons the server closes a session, used in logs and metrics.
+const (
+	closeReasonAdmin   = "admin"
+	closeReasonGuest   = "guest_limit"
+	closeReasonEvicted = "evicted"
+	closeReasonExpired = "expired"
+)
+
+// teardownSession closes a session's streams and downstream connection and
+// removes it from the session store.
+func (s *Server) teardownSession(sessionID uuid.UUID, reason string) {
+	fin := protocol.Fin{Reason: protocol.CloseSessionClosed, Message: reason}
+	if reason == closeReasonExpired {
+		fin.Reason = protocol.CloseIdleTimeout
+	}
+	streams := s.sessionStreams(sessionID)
+	for _, streamID := range streams {
+		s.closeNatEntry(sessionID, streamID, "session_"+reason, fin)
+	}
+
+	s.downstreamConnsMu.Lock()
+	conn, exists := s.downstreamConns[sessionID]
+	delete(s.downstreamConns, sessionID)
+	s.downstreamConnsMu.Unlock()
+	if exists {
+		conn.Close()
+	}
+
+	s.sessionStore.Remove(sessionID)
+	s.releaseSessionIndex(sessionID)
+	s.forgetFlow(sessionID)
+
+	s.log.Info().
+		Str("session_id", sessionID.String()).
+		Str("reason", reason).
+		Int("streams", len(streams)).
+		Bool("downstream_connected", exists).
+		Msg("Session closed")
+	if s.config.Metrics != nil {
+		s.config.Metrics.RecordSessionCloseReason(reason)
+	}
+}
+
+// sessionStreams returns the IDs of the session's streams in the NAT table.
+func (s *Server) sessionStreams(sessionID uuid.UUID) []uint32 {
+	s.natTableMu.RLock()
+	defer s.natTableMu.RUnlock()
+
+	var streams []uint32
+	for key := range s.natTable {
+		if key.SessionID == sessionID {
+			streams = append(streams, key.StreamID)
+		}
+	}
+	return streams
+}
+
+// recordClosedStream appends a closed stream to the history, dropping the
+// oldest entry once maxClosedStreamHistory is reached.
+func (s *Server) recordClosedStream(key natKey, entry *natEntry, closedBy string, fin protocol.Fin) {
+	closed := admin.ClosedStream{
+		SessionID: key.SessionID,
+		StreamID:  key.StreamID,
+		Dest:      entry.destAddr,
+		BytesUp:   atomic.LoadInt64(&entry.bytesUp),
+		BytesDown: atomic.LoadInt64(&entry.bytesDown),
+		CreatedAt: entry.created,
+		ClosedAt:  time.Now(),
+		ClosedBy:  closedBy,
+		Reason:    fin.Reason.String(),
+		Message:   fin.Message,
+	}
+
+	s.closedStreamsMu.Lock()
+	defer s.closedStreamsMu.Unlock()
+
+	if len(s.closedStreams) >= maxClosedStreamHistory {
+		s.closedStreams = s.closedStreams[1:]
+	}
+	s.closedStreams = append(s.closedStreams, closed)
+}
+
+// closedStreamHistory returns a copy of the closed stream history.
+func (s *Server) closedStreamHistory() []admin.ClosedStream {
+	s.closedStreamsMu.Lock()
+	defer s.closedStreamsMu.Unlock()
+
+	history := make([]admin.ClosedStream, len(s.closedStreams))
+	copy(history, s.closedStreams)
+	return history
+}
@@ -0,0 +1,128 @@
+package server
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sahmadiut/half-tunnel/internal/admin"
+	"github.com/sahmadiut/half-tunnel/internal/protocol"
+)
+
+func TestAdminStatusAndActions(t *testing.T) {
+	s := New(nil, nil)
+	defer s.sessionStore.Close()
+
+	sessionID := uuid.New()
+	s.sessionStore.GetOrCreate(sessionID)
+	for _, streamID := range []uint32{1, 2} {
+		conn, peer := net.Pipe()
+		defer peer.Close()
+		s.natTable[natKey{SessionID: sessionID, StreamID: streamID}] = &natEntry{
+			conn:     conn,
+			destAddr: "example.com:443",
+			created:  time.Now(),
+			bytesUp:  int64(streamID * 10),
+		}
+	}
+
+	status := s.AdminStatus()
+	if status.Role != "server" || len(status.Sessions) != 1 || status.Sessions[0].Streams != 2 {
+		t.Fatalf("Unexpected status: %+v", status)
+	}
+	if len(status.Streams) != 2 || len(status.NAT) != 2 {
+		t.Fatalf("Expected 2 streams and NAT entries, got %d and %d", len(status.Streams), len(status.NAT))
+	}
+	for _, stream := range status.Streams {
+		if stream.Dest != "example.com:443" || stream.BytesUp != int64(stream.StreamID*10) {
+			t.Errorf("Unexpected stream: %+v", stream)
+		}
+	}
+
+	if err := s.CloseStream(sessionID, 9); err != admin.ErrStreamNotFound {
+		t.Errorf("Expected ErrStreamNotFound, got %v", err)
+	}
+	if err := s.CloseStream(sessionID, 1); err != nil {
+		t.Errorf("Expected stream to close, got %v", err)
+	}
+	if n := s.GetNatEntryCount(); n != 1 {
+		t.Errorf("Expected 1 NAT entry after close, got %d", n)
+	}
+
+	if err := s.DrainSession(uuid.New()); err != admin.ErrSessionNotFound {
+		t.Errorf("Expected ErrSessionNotFound, got %v", err)
+	}
+	if err := s.DrainSession(sessionID); err != nil {
+		t.Errorf("Expected session to drain, got %v", err)
+	}
+	if n := s.GetNatEntryCount(); n != 0 {
+		t.Errorf("Expected no NAT entries after drain, got %d", n)
+	}
+	if s.GetSessionCount() != 1 {
+		t.Error("Expected drained session to remain")
+	}
+
+	closed := s.AdminStatus().ClosedStreams
+	if len(closed) != 2 {
+		t.Fatalf("Expected 2 closed streams, got %d", len(closed))
+	}
+	if closed[0].StreamID != 1 || closed[0].ClosedBy != admin.ClosedByLocal || closed[0].Reason != "admin" || closed[0].BytesUp != 10 {
+		t.Errorf("Unexpected closed stream: %+v", closed[0])
+	}
+}
+
+func TestAdminStatusStreamStats(t *testing.T) {
+	s := New(nil, nil)
+	defer s.sessionStore.Close()
+
+	sessionID := uuid.New()
+	sess := s.sessionStore.GetOrCreate(sessionID)
+	conn, peer := net.Pipe()
+	defer peer.Close()
+	entry := &natEntry{conn: conn, destAddr: "example.com:443", created: time.Now(), stream: sess.GetStream(1)}
+	s.natTable[natKey{SessionID: sessionID, StreamID: 1}] = entry
+
+	entry.countReceived(100)
+	entry.countReceived(20)
+	entry.countSent(5000)
+
+	status := s.AdminStatus()
+	if len(status.Streams) != 1 {
+		t.Fatalf("Expected 1 stream, got %d", len(status.Streams))
+	}
+	stream := status.Streams[0]
+	if stream.PacketsUp != 2 || stream.PacketsDown != 1 {
+		t.Errorf("Expected 2 packets up and 1 down, got %d and %d", stream.PacketsUp, stream.PacketsDown)
+	}
+	if stream.LastActivity.Before(stream.CreatedAt) {
+		t.Errorf("Expected last activity after creation, got %v", stream.LastActivity)
+	}
+
+	s.closeNatEntry(sessionID, 1, streamCloseClient, protocol.Fin{Reason: protocol.CloseEOF})
+	if _, ok := sess.GetStreamStats(1); ok {
+		t.Error("Expected the session stream removed with its NAT entry")
+	}
+}
+
+func TestClosedStreamHistoryIsBounded(t *testing.T) {
+	s := New(nil, nil)
+	defer s.sessionStore.Close()
+
+	sessionID := uuid.New()
+	for streamID := uint32(1); streamID <= maxClosedStreamHistory+5; streamID++ {
+		conn, peer := net.Pipe()
+		peer.Close()
+		s.natTable[natKey{SessionID: sessionID, StreamID: streamID}] = &natEntry{conn: conn, created: time.Now()}
+		s.closeNatEntry(sessionID, streamID, streamCloseClient, protocol.Fin{Reason: protocol.CloseReadError, Message: "reset"})
+	}
+
+	closed := s.closedStreamHistory()
+	if len(closed) != maxClosedStreamHistory {
+		t.Fatalf("Expected %d closed streams, got %d", maxClosedStreamHistory, len(closed))
+	}
+	last := closed[len(closed)-1]
+	if last.StreamID != maxClosedStreamHistory+5 || last.ClosedBy != admin.ClosedByPeer || last.Reason != "read_error" || last.Message != "reset" {
+		t.Errorf("Unexpected last closed stream: %+v", last)
+	}
+}
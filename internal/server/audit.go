@@ -0,0 +1,62 @@
+package server
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/sahmadiut/half-tunnel/internal/audit"
+)
+
+// Reasons a stream is closed, recorded in the audit log. Streams closed with
+// their session are recorded as "session_" plus the session's close reason.
+const (
+	streamCloseClient           = "client_fin"
+	streamCloseDestination      = "destination_fin"
+	streamCloseDestinationError = "destination_error"
+	streamCloseHalfCloseTimeout = "half_close_timeout"
+	streamCloseIdle             = "idle"
+	streamCloseDownstreamError  = "downstream_error"
+	streamCloseDialFailed       = "dial_failed"
+	streamCloseCircuitOpen      = "circuit_open"
+	streamCloseBlocked          = "blocked"
+	streamCloseQuota            = "quota_exceeded"
+	streamCloseAdmin            = "admin"
+	streamCloseShutdown         = "shutdown"
+	streamCloseCorrupt          = "corrupt_packet"
+)
+
+// auditStreamOpen records a stream registered for its destination.
+func (s *Server) auditStreamOpen(key natKey, entry *natEntry) {
+	s.writeAudit(audit.Event{
+		Time:      entry.created,
+		Event:     audit.EventStreamOpen,
+		SessionID: key.SessionID.String(),
+		StreamID:  key.StreamID,
+		Client:    entry.identity,
+		Dest:      entry.destAddr,
+	})
+}
+
+// auditStreamClose records a closed stream with its traffic and lifetime.
+func (s *Server) auditStreamClose(key natKey, entry *natEntry, reason string) {
+	s.writeAudit(audit.Event{
+		Event:      audit.EventStreamClose,
+		SessionID:  key.SessionID.String(),
+		StreamID:   key.StreamID,
+		Client:     entry.identity,
+		Dest:       entry.destAddr,
+		BytesUp:    atomic.LoadInt64(&entry.bytesUp),
+		BytesDown:  atomic.LoadInt64(&entry.bytesDown),
+		DurationMS: time.Since(entry.created).Milliseconds(),
+		Reason:     reason,
+	})
+}
+
+func (s *Server) writeAudit(e audit.Event) {
+	if s.config.Audit == nil {
+		return
+	}
+	if err := s.config.Audit.Log(e); err != nil {
+		s.log.Debug().Err(err).Str("event", e.Event).Msg("Failed to write audit event")
+	}
+}
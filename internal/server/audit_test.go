@@ -0,0 +1,82 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sahmadiut/half-tunnel/internal/audit"
+	"github.com/sahmadiut/half-tunnel/internal/protocol"
+)
+
+func TestAuditStreamLifecycle(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	defer ln.Close()
+
+	var buf bytes.Buffer
+	config := DefaultConfig()
+	config.Audit = audit.New(&buf)
+	s := New(config, nil)
+	defer s.sessionStore.Close()
+
+	sessionID := uuid.New()
+	s.sessionStore.GetOrCreate(sessionID).SetIdentity("laptop")
+	open, _ := protocol.NewPacket(sessionID, 1, protocol.FlagHandshake|protocol.FlagData, connectPayload(t, ln.Addr()))
+	data, _ := protocol.NewPacket(sessionID, 1, protocol.FlagData, []byte("hello"))
+	s.handleUpstreamPacket(context.Background(), open)
+
+	_ = ln.(*net.TCPListener).SetDeadline(time.Now().Add(2 * time.Second))
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatalf("Accept failed: %v", err)
+	}
+	defer conn.Close()
+
+	// Send the data once the stream is established so it is written, and
+	// counted, before the FIN
+	s.natTableMu.RLock()
+	entry := s.natTable[natKey{SessionID: sessionID, StreamID: 1}]
+	s.natTableMu.RUnlock()
+	for deadline := time.Now().Add(2 * time.Second); entry.destConn() == nil; {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for the stream to be established")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	s.handleUpstreamPacket(context.Background(), data)
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := io.ReadFull(conn, make([]byte, len("hello"))); err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+
+	fin, _ := protocol.NewPacket(sessionID, 1, protocol.FlagFin, nil)
+	s.handleUpstreamPacket(context.Background(), fin)
+	s.wg.Wait()
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected open and close events, got %q", buf.String())
+	}
+	var opened, closed audit.Event
+	_ = json.Unmarshal([]byte(lines[0]), &opened)
+	_ = json.Unmarshal([]byte(lines[1]), &closed)
+
+	if opened.Event != audit.EventStreamOpen || opened.Client != "laptop" || opened.Dest != ln.Addr().String() {
+		t.Errorf("Unexpected open event: %+v", opened)
+	}
+	if closed.Event != audit.EventStreamClose || closed.SessionID != sessionID.String() || closed.StreamID != 1 {
+		t.Errorf("Unexpected close event: %+v", closed)
+	}
+	if closed.BytesUp != 5 || closed.Reason != streamCloseClient {
+		t.Errorf("Expected 5 bytes up closed by the client, got %d, %q", closed.BytesUp, closed.Reason)
+	}
+}
@@ -0,0 +1,73 @@
+package server
+
+import (
+	"github.com/google/uuid"
+	"github.com/sahmadiut/half-tunnel/internal/protocol"
+)
+
+// backendAddStream records a new stream with the session backend.
+func (s *Server) backendAddStream(sessionID uuid.UUID, streamID uint32, destAddr string) {
+	if s.config.SessionBackend == nil {
+		return
+	}
+	if err := s.config.SessionBackend.AddStream(sessionID, streamID, destAddr); err != nil {
+		s.log.Debug().Err(err).
+			Str("session_id", sessionID.String()).
+			Uint32("stream_id", streamID).
+			Msg("Failed to record stream in session backend")
+		s.recordError("session_backend")
+	}
+}
+
+// backendRemoveStream removes a closed stream from the session backend.
+func (s *Server) backendRemoveStream(sessionID uuid.UUID, streamID uint32) {
+	if s.config.SessionBackend == nil {
+		return
+	}
+	if err := s.config.SessionBackend.RemoveStream(sessionID, streamID); err != nil {
+		s.log.Debug().Err(err).
+			Str("session_id", sessionID.String()).
+			Uint32("stream_id", streamID).
+			Msg("Failed to remove stream from session backend")
+		s.recordError("session_backend")
+	}
+}
+
+// resetStaleStreams closes streams the session backend has on record but
+// this server has no connection for, such as streams left by an instance
+// that restarted. The client gets a FIN for each, so it does not wait on
+// them.
+func (s *Server) resetStaleStreams(sessionID uuid.UUID) {
+	if s.config.SessionBackend == nil {
+		return
+	}
+	streams, err := s.config.SessionBackend.Streams(sessionID)
+	if err != nil {
+		s.log.Debug().Err(err).
+			Str("session_id", sessionID.String()).
+			Msg("Failed to load streams from session backend")
+		s.recordError("session_backend")
+		return
+	}
+
+	reset := 0
+	for streamID := range streams {
+		s.natTableMu.RLock()
+		_, exists := s.natTable[natKey{SessionID: sessionID, StreamID: streamID}]
+		s.natTableMu.RUnlock()
+		if exists {
+			continue
+		}
+		s.sendFin(sessionID, streamID, protocol.Fin{Reason: protocol.CloseShutdown, Message: "server restarted"})
+		s.sendFinAck(sessionID, streamID)
+		_ = s.config.SessionBackend.RemoveStream(sessionID, streamID)
+		reset++
+	}
+
+	if reset > 0 {
+		s.log.Info().
+			Str("session_id", sessionID.String()).
+			Int("streams", reset).
+			Msg("Reset streams left by a previous server instance")
+	}
+}
@@ -0,0 +1,44 @@
+package server
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sahmadiut/half-tunnel/internal/protocol"
+	"github.com/sahmadiut/half-tunnel/internal/session"
+)
+
+func TestResetStaleStreams(t *testing.T) {
+	backend := session.NewMemoryBackend(0, time.Minute)
+	config := DefaultConfig()
+	config.Name = "node-a"
+	config.SessionBackend = backend
+	s := New(config, nil)
+	defer s.sessionStore.Close()
+
+	sessionID := uuid.New()
+	if _, err := s.sessionStore.Admit(sessionID); err != nil {
+		t.Fatalf("Admit failed: %v", err)
+	}
+
+	// Stream 1 is live on this server; stream 2 was left by a previous instance
+	live, dest := net.Pipe()
+	defer dest.Close()
+	s.natTable[natKey{SessionID: sessionID, StreamID: 1}] = &natEntry{conn: live, destAddr: "example.com:443", created: time.Now()}
+	_ = backend.AddStream(sessionID, 1, "example.com:443")
+	_ = backend.AddStream(sessionID, 2, "example.org:80")
+
+	s.resetStaleStreams(sessionID)
+
+	streams, _ := backend.Streams(sessionID)
+	if len(streams) != 1 || streams[1] != "example.com:443" {
+		t.Errorf("Expected only the live stream to remain, got %v", streams)
+	}
+
+	s.closeNatEntry(sessionID, 1, streamCloseClient, protocol.Fin{Reason: protocol.CloseEOF})
+	if streams, _ := backend.Streams(sessionID); len(streams) != 0 {
+		t.Errorf("Expected closed stream to be removed from the backend, got %v", streams)
+	}
+}
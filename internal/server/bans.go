@@ -0,0 +1,121 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"net/http"
+
+	"github.com/gorilla/websocket"
+	"github.com/sahmadiut/half-tunnel/internal/admin"
+	"github.com/sahmadiut/half-tunnel/internal/banlist"
+)
+
+// Reasons a tunnel handshake fails, used in metrics.
+const (
+	handshakePathToken       = "path_token"
+	handshakeUpgradeCookie   = "upgrade_cookie"
+	handshakeSessionRejected = "session_rejected"
+	handshakeClientCert      = "client_cert"
+	handshakeRelay           = "relay"
+)
+
+// sourceIP returns the IP of a remote address.
+func sourceIP(remoteAddr string) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return remoteAddr
+	}
+	return host
+}
+
+// limitUpgrades passes WebSocket upgrades to next unless their source is
+// banned or makes too many attempts; those get the decoy. Other requests
+// are not limited.
+func (s *Server) limitUpgrades(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.config.Bans == nil || !websocket.IsWebSocketUpgrade(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ip := sourceIP(r.RemoteAddr)
+		switch s.config.Bans.Attempt(ip) {
+		case banlist.Allowed:
+			next.ServeHTTP(w, r)
+			return
+		case banlist.RateLimited:
+			s.log.Warn().
+				Str("remote_addr", r.RemoteAddr).
+				Msg("Banned source over the upgrade attempt limit")
+			if s.config.Metrics != nil {
+				s.config.Metrics.RecordSourceBanned(banlist.ReasonRateLimit)
+				s.config.Metrics.RecordUpgradeRejected(banlist.ReasonRateLimit)
+			}
+		case banlist.Banned:
+			if s.config.Metrics != nil {
+				s.config.Metrics.RecordUpgradeRejected("banned")
+			}
+		}
+		s.serveDecoy(w, r)
+	})
+}
+
+// handshakeFailed records a failed tunnel handshake from remoteAddr, banning
+// the source once it fails too often.
+func (s *Server) handshakeFailed(remoteAddr, reason string) {
+	if s.config.Metrics != nil {
+		s.config.Metrics.RecordHandshakeFailure(reason)
+	}
+	if s.config.Bans == nil || !s.config.Bans.Fail(sourceIP(remoteAddr)) {
+		return
+	}
+	s.log.Warn().
+		Str("remote_addr", remoteAddr).
+		Str("reason", reason).
+		Msg("Banned source after repeated handshake failures")
+	if s.config.Metrics != nil {
+		s.config.Metrics.RecordSourceBanned(banlist.ReasonFailures)
+	}
+}
+
+// Bans returns the banned sources for the admin API.
+func (s *Server) Bans() []admin.Ban {
+	bans := []admin.Ban{}
+	if s.config.Bans == nil {
+		return bans
+	}
+	for _, ban := range s.config.Bans.Bans() {
+		bans = append(bans, admin.Ban{
+			IP:     ban.IP,
+			Reason: ban.Reason,
+			Since:  ban.Since,
+			Until:  ban.Until,
+		})
+	}
+	return bans
+}
+
+// Unban lifts the ban on a source IP.
+func (s *Server) Unban(ip string) error {
+	if s.config.Bans == nil {
+		return admin.ErrBanNotFound
+	}
+	if err := s.config.Bans.Unban(ip); err != nil {
+		if errors.Is(err, banlist.ErrNotBanned) {
+			return admin.ErrBanNotFound
+		}
+		return err
+	}
+	s.log.Info().Str("ip", ip).Msg("Ban lifted")
+	return nil
+}
+
+// ClearBans lifts every ban.
+func (s *Server) ClearBans() error {
+	if s.config.Bans == nil {
+		return nil
+	}
+	n := s.config.Bans.Clear()
+	s.log.Info().Int("bans", n).Msg("All bans lifted")
+	return nil
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/sahmadiut/half-tunnel/internal/admin"
+	"github.com/sahmadiut/half-tunnel/internal/banlist"
+	"github.com/sahmadiut/half-tunnel/internal/pathtoken"
+)
+
+func TestLimitUpgradesBansOverRate(t *testing.T) {
+	bans, err := banlist.New("", banlist.Config{AttemptsPerMinute: 1, Burst: 2, BanDuration: time.Hour})
+	if err != nil {
+		t.Fatalf("Failed to create ban list: %v", err)
+	}
+	config := DefaultConfig()
+	config.Bans = bans
+	s := New(config, nil)
+	mux := s.newTunnelMux(tunnelRoute{"/ws/upstream", stubTunnel})
+
+	want := []int{http.StatusNoContent, http.StatusNoContent, http.StatusNotFound, http.StatusNotFound}
+	for i, code := range want {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, upgradeRequest("/ws/upstream"))
+		if w.Code != code {
+			t.Errorf("Attempt %d: expected status %d, got %d", i, code, w.Code)
+		}
+	}
+	if !bans.Banned("192.0.2.1") {
+		t.Error("Expected the source to be banned")
+	}
+
+	// Plain requests are not counted against the limit
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected decoy status, got %d", w.Code)
+	}
+}
+
+func TestHandshakeFailuresBan(t *testing.T) {
+	bans, _ := banlist.New("", banlist.Config{MaxFailures: 2, FailureWindow: time.Minute, BanDuration: time.Hour})
+	config := DefaultConfig()
+	config.Bans = bans
+	s := New(config, nil)
+	pathTokens, err := pathtoken.New("path-secret", time.Minute)
+	if err != nil {
+		t.Fatalf("Failed to create path tokens: %v", err)
+	}
+	s.pathTokens = pathTokens
+	mux := s.newTunnelMux(tunnelRoute{"/ws/upstream", stubTunnel})
+
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, upgradeRequest("/ws/upstream/not-a-token"))
+	}
+
+	// A banned source gets the decoy even with a valid token
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, upgradeRequest(pathTokens.Path("/ws/upstream", time.Now())))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected banned source to get status %d, got %d", http.StatusNotFound, w.Code)
+	}
+
+	got := s.Bans()
+	if len(got) != 1 || got[0].IP != "192.0.2.1" || got[0].Reason != banlist.ReasonFailures {
+		t.Fatalf("Unexpected bans: %+v", got)
+	}
+	if err := s.Unban("192.0.2.1"); err != nil {
+		t.Errorf("Unban failed: %v", err)
+	}
+	if err := s.Unban("192.0.2.1"); !errors.Is(err, admin.ErrBanNotFound) {
+		t.Errorf("Expected ErrBanNotFound, got %v", err)
+	}
+
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, upgradeRequest(pathTokens.Path("/ws/upstream", time.Now())))
+	if w.Code != http.StatusNoContent {
+		t.Errorf("Expected status %d after the ban was lifted, got %d", http.StatusNoContent, w.Code)
+	}
+}
+
+func TestRelayedHandshakeFailureBansClient(t *testing.T) {
+	bans, _ := banlist.New("", banlist.Config{MaxFailures: 1, FailureWindow: time.Minute, BanDuration: time.Hour})
+	config := DefaultConfig()
+	config.Bans = bans
+	config.Cluster = ClusterConfig{Node: "a", Secret: "cluster-secret", Peers: []ClusterPeer{{Name: "b"}}}
+	s := New(config, nil)
+	pathTokens, err := pathtoken.New("path-secret", time.Minute)
+	if err != nil {
+		t.Fatalf("Failed to create path tokens: %v", err)
+	}
+	s.pathTokens = pathTokens
+	handler := s.acceptRelays(TLSConfig{}, s.newTunnelMux(tunnelRoute{"/ws/upstream", stubTunnel}))
+
+	r := upgradeRequest("/ws/upstream/not-a-token")
+	r.Header.Set(relayHeader, s.signRelay("198.51.100.7:40000", "", time.Now()))
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+
+	if !bans.Banned("198.51.100.7") {
+		t.Error("Expected the relayed client to be banned")
+	}
+	if bans.Banned(sourceIP(r.RemoteAddr)) {
+		t.Error("Expected the relaying peer not to be banned")
+	}
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"net"
+
+	"github.com/sahmadiut/half-tunnel/internal/circuitbreaker"
+)
+
+// newDestinationBreaker creates the per-destination circuit breakers for
+// config, exporting state changes when metrics are enabled. It returns nil
+// when breakers are disabled.
+func (s *Server) newDestinationBreaker() *circuitbreaker.DestinationBreaker {
+	if s.config.DestinationBreaker == nil {
+		return nil
+	}
+	breakers := circuitbreaker.NewDestinationBreaker(s.config.DestinationBreaker)
+	breakers.SetOnStateChange(func(dest string, from, to circuitbreaker.State) {
+		s.log.Debug().
+			Str("dest_addr", dest).
+			Str("from", from.String()).
+			Str("to", to.String()).
+			Msg("Destination circuit breaker state changed")
+		if to == circuitbreaker.StateOpen {
+			s.log.Warn().
+				Str("dest_addr", dest).
+				Msg("Destination failing repeatedly, rejecting new streams to it")
+		}
+
+		if s.config.Metrics == nil {
+			return
+		}
+		name := breakerMetricName(s.config.Metrics.DestHosts.Value(destHost(dest)))
+		s.config.Metrics.SetCircuitBreakerState(name, int(to))
+		if to == circuitbreaker.StateOpen {
+			s.config.Metrics.RecordCircuitBreakerTrip(name)
+		}
+	})
+	return breakers
+}
+
+// breakerMetricName is the circuit breaker metric name for a destination
+// host label.
+func breakerMetricName(host string) string {
+	return "dest:" + host
+}
+
+// destHost returns the host part of a host:port destination.
+func destHost(dest string) string {
+	host, _, err := net.SplitHostPort(dest)
+	if err != nil {
+		return dest
+	}
+	return host
+}
+
+// allowDial reports whether a stream may dial dest. Calls that return true
+// must be followed by recordDialResult.
+func (s *Server) allowDial(dest string) bool {
+	if s.breakers == nil {
+		return true
+	}
+	return s.breakers.IsAllowed(dest)
+}
+
+// recordDialResult feeds a dial outcome to dest's circuit breaker.
+func (s *Server) recordDialResult(dest string, err error) {
+	if s.breakers == nil {
+		return
+	}
+	if err != nil {
+		s.breakers.RecordFailure(dest)
+	} else {
+		s.breakers.RecordSuccess(dest)
+	}
+}
+
+// pruneBreakers forgets destinations whose breakers hold no failures.
+func (s *Server) pruneBreakers() {
+	if s.breakers != nil {
+		s.breakers.Prune()
+	}
+}
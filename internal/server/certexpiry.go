@@ -0,0 +1,69 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/sahmadiut/half-tunnel/internal/notify"
+)
+
+// certExpiryCheckInterval is how often the TLS certificates' expiry is
+// checked.
+const certExpiryCheckInterval = 12 * time.Hour
+
+// certExpiryLoop checks the TLS certificates' expiry at start and then
+// periodically until the server stops.
+func (s *Server) certExpiryLoop(ctx context.Context) {
+	defer s.wg.Done()
+
+	s.checkCertExpiry(time.Now())
+	ticker := time.NewTicker(certExpiryCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.shutdown:
+			return
+		case now := <-ticker.C:
+			s.checkCertExpiry(now)
+		}
+	}
+}
+
+// checkCertExpiry notifies about certificates that expire within
+// CertExpiryWarning of now. A renewed certificate counts once it has been
+// reloaded.
+func (s *Server) checkCertExpiry(now time.Time) {
+	for _, cert := range s.certs {
+		notAfter, err := cert.NotAfter()
+		if err != nil {
+			s.log.Warn().Err(err).Str("cert_file", cert.certFile).Msg("Failed to check TLS certificate expiry")
+			continue
+		}
+		left := notAfter.Sub(now)
+		if left >= s.config.CertExpiryWarning {
+			continue
+		}
+
+		var message string
+		switch days := int(left.Hours() / 24); {
+		case left <= 0:
+			message = "TLS certificate has expired"
+		case days == 0:
+			message = "TLS certificate expires in less than a day"
+		default:
+			message = fmt.Sprintf("TLS certificate expires in %d days", days)
+		}
+		s.log.Warn().
+			Str("cert_file", cert.certFile).
+			Time("not_after", notAfter).
+			Msg(message)
+		s.config.Notifier.Notify(notify.EventCertExpiring, cert.certFile, message, map[string]string{
+			"file":      cert.certFile,
+			"not_after": notAfter.UTC().Format(time.RFC3339),
+		})
+	}
+}
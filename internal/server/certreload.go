@@ -0,0 +1,127 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/sahmadiut/half-tunnel/pkg/logger"
+)
+
+// certCheckInterval limits how often the certificate files are stat'ed.
+const certCheckInterval = 5 * time.Second
+
+// CertReloader serves a TLS certificate from disk and reloads it when the
+// certificate or key file changes, so renewed certificates (e.g. from
+// Let's Encrypt) are picked up without restarting the server.
+type CertReloader struct {
+	certFile string
+	keyFile  string
+	log      *logger.Logger
+
+	cert      *tls.Certificate
+	certMod   time.Time
+	keyMod    time.Time
+	lastCheck time.Time
+	mu        sync.Mutex
+}
+
+// NewCertReloader loads the certificate and key and returns a reloader for them.
+func NewCertReloader(certFile, keyFile string, log *logger.Logger) (*CertReloader, error) {
+	if log == nil {
+		log = logger.NewDefault()
+	}
+
+	r := &CertReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+		log:      log,
+	}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// GetCertificate returns the current certificate, reloading it first if the
+// files changed on disk. It is meant to be used as tls.Config.GetCertificate.
+func (r *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if time.Since(r.lastCheck) >= certCheckInterval {
+		r.lastCheck = time.Now()
+		if r.changed() {
+			if err := r.reload(); err != nil {
+				// Keep serving the previous certificate until the files are valid again
+				r.log.Error().Err(err).Str("cert_file", r.certFile).Msg("Failed to reload TLS certificate")
+			} else {
+				r.log.Info().Str("cert_file", r.certFile).Msg("TLS certificate reloaded")
+			}
+		}
+	}
+
+	return r.cert, nil
+}
+
+// TLSConfig returns a tls.Config that serves certificates from the reloader.
+func (r *CertReloader) TLSConfig() *tls.Config {
+	return &tls.Config{
+		GetCertificate: r.GetCertificate,
+	}
+}
+
+// changed reports whether the certificate or key file was modified since the
+// last successful load. Must be called with the lock held.
+func (r *CertReloader) changed() bool {
+	certInfo, err := os.Stat(r.certFile)
+	if err != nil {
+		return false
+	}
+	keyInfo, err := os.Stat(r.keyFile)
+	if err != nil {
+		return false
+	}
+	return !certInfo.ModTime().Equal(r.certMod) || !keyInfo.ModTime().Equal(r.keyMod)
+}
+
+// reload reads the certificate and key from disk.
+// Must be called with the lock held or before the reloader is shared.
+func (r *CertReloader) reload() error {
+	certInfo, err := os.Stat(r.certFile)
+	if err != nil {
+		return fmt.Errorf("failed to stat certificate: %w", err)
+	}
+	keyInfo, err := os.Stat(r.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to stat key: %w", err)
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load certificate: %w", err)
+	}
+
+	r.cert = &cert
+	r.certMod = certInfo.ModTime()
+	r.keyMod = keyInfo.ModTime()
+	return nil
+}
+
+// NotAfter returns when the current certificate expires.
+func (r *CertReloader) NotAfter() (time.Time, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	leaf := r.cert.Leaf
+	if leaf == nil {
+		var err error
+		if leaf, err = x509.ParseCertificate(r.cert.Certificate[0]); err != nil {
+			return time.Time{}, fmt.Errorf("failed to parse certificate: %w", err)
+		}
+	}
+	return leaf.NotAfter, nil
+}
@@ -0,0 +1,173 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sahmadiut/half-tunnel/internal/notify"
+)
+
+// writeTestCert writes a self-signed certificate for commonName to the given paths.
+func writeTestCert(t *testing.T, certFile, keyFile, commonName string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("Failed to marshal key: %v", err)
+	}
+
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatalf("Failed to write certificate: %v", err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatalf("Failed to write key: %v", err)
+	}
+}
+
+func leafCommonName(t *testing.T, r *CertReloader) string {
+	t.Helper()
+
+	cert, err := r.GetCertificate(nil)
+	if err != nil {
+		t.Fatalf("GetCertificate returned error: %v", err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatalf("Failed to parse certificate: %v", err)
+	}
+	return leaf.Subject.CommonName
+}
+
+func TestCertReloaderReloadsOnChange(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	writeTestCert(t, certFile, keyFile, "first")
+
+	r, err := NewCertReloader(certFile, keyFile, nil)
+	if err != nil {
+		t.Fatalf("NewCertReloader returned error: %v", err)
+	}
+	if cn := leafCommonName(t, r); cn != "first" {
+		t.Fatalf("Expected CN first, got %s", cn)
+	}
+
+	writeTestCert(t, certFile, keyFile, "second")
+	future := time.Now().Add(time.Minute)
+	_ = os.Chtimes(certFile, future, future)
+	_ = os.Chtimes(keyFile, future, future)
+
+	// Skip the check interval
+	r.lastCheck = time.Time{}
+	if cn := leafCommonName(t, r); cn != "second" {
+		t.Errorf("Expected CN second after rotation, got %s", cn)
+	}
+}
+
+func TestCertReloaderKeepsCertOnInvalidFiles(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	writeTestCert(t, certFile, keyFile, "valid")
+
+	r, err := NewCertReloader(certFile, keyFile, nil)
+	if err != nil {
+		t.Fatalf("NewCertReloader returned error: %v", err)
+	}
+
+	if err := os.WriteFile(certFile, []byte("not a certificate"), 0600); err != nil {
+		t.Fatalf("Failed to overwrite certificate: %v", err)
+	}
+	future := time.Now().Add(time.Minute)
+	_ = os.Chtimes(certFile, future, future)
+
+	r.lastCheck = time.Time{}
+	if cn := leafCommonName(t, r); cn != "valid" {
+		t.Errorf("Expected previous certificate to be kept, got CN %s", cn)
+	}
+}
+
+func TestNewCertReloaderMissingFiles(t *testing.T) {
+	if _, err := NewCertReloader("/nonexistent/cert.pem", "/nonexistent/key.pem", nil); err == nil {
+		t.Error("Expected error for missing certificate files")
+	}
+}
+
+// notificationRecorder is a notify.Sender that keeps what it is sent.
+type notificationRecorder struct {
+	mu   sync.Mutex
+	sent []notify.Notification
+}
+
+func (r *notificationRecorder) Name() string { return "recorder" }
+
+func (r *notificationRecorder) Send(_ context.Context, n notify.Notification) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sent = append(r.sent, n)
+	return nil
+}
+
+func TestCertExpiryNotification(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	writeTestCert(t, certFile, keyFile, "exit.example.com")
+	reloader, err := NewCertReloader(certFile, keyFile, nil)
+	if err != nil {
+		t.Fatalf("NewCertReloader failed: %v", err)
+	}
+
+	rec := &notificationRecorder{}
+	config := DefaultConfig()
+	config.Notifier = notify.New(&notify.Config{Senders: []notify.Sender{rec}}, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	config.Notifier.Start(ctx)
+	s := New(config, nil)
+	s.certs = []*CertReloader{reloader}
+
+	// The certificate expires in an hour
+	s.config.CertExpiryWarning = 30 * time.Minute
+	s.checkCertExpiry(time.Now())
+	s.config.CertExpiryWarning = 14 * 24 * time.Hour
+	s.checkCertExpiry(time.Now())
+	s.checkCertExpiry(time.Now().Add(2 * time.Hour))
+	cancel()
+	config.Notifier.Wait()
+
+	if len(rec.sent) != 2 {
+		t.Fatalf("Expected 2 notifications, got %d", len(rec.sent))
+	}
+	if n := rec.sent[0]; n.Event != notify.EventCertExpiring || n.Fields["file"] != certFile || n.Message != "TLS certificate expires in less than a day" {
+		t.Errorf("Unexpected notification %+v", n)
+	}
+	if n := rec.sent[1]; n.Message != "TLS certificate has expired" {
+		t.Errorf("Expected an expired notification, got %q", n.Message)
+	}
+}
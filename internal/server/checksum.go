@@ -0,0 +1,42 @@
+package server
+
+import (
+	"github.com/google/uuid"
+	"github.com/sahmadiut/half-tunnel/internal/protocol"
+)
+
+// checksumsEnabled reports whether a session negotiated packet checksums.
+func (s *Server) checksumsEnabled(sessionID uuid.UUID) bool {
+	sess, ok := s.sessionStore.Get(sessionID)
+	if !ok {
+		return false
+	}
+	_, caps := sess.Protocol()
+	return protocol.Capability(caps).Has(protocol.CapChecksum)
+}
+
+// checksumValid verifies the checksum of a packet received on path. A
+// corrupted packet is dropped and, if ResetCorruptStreams is set, its
+// stream is reset, since the stream's data has a gap.
+func (s *Server) checksumValid(pkt *protocol.Packet, path string) bool {
+	if pkt.ChecksumValid() {
+		return true
+	}
+
+	s.log.Warn().
+		Str("session_id", pkt.SessionID.String()).
+		Uint32("stream_id", pkt.StreamID).
+		Uint32("seq", pkt.SeqNum).
+		Str("path", path).
+		Msg("Dropped packet with checksum mismatch")
+	if s.config.Metrics != nil {
+		s.config.Metrics.RecordPacketCorrupted(path)
+	}
+
+	if s.config.ResetCorruptStreams && pkt.StreamID != 0 {
+		fin := protocol.Fin{Reason: protocol.CloseProtocolError, Message: "corrupted packet"}
+		s.sendFin(pkt.SessionID, pkt.StreamID, fin)
+		s.closeNatEntry(pkt.SessionID, pkt.StreamID, streamCloseCorrupt, fin)
+	}
+	return false
+}
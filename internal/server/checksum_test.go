@@ -0,0 +1,91 @@
+package server
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sahmadiut/half-tunnel/internal/metrics"
+	"github.com/sahmadiut/half-tunnel/internal/protocol"
+)
+
+func TestChecksumNegotiated(t *testing.T) {
+	s := New(nil, nil)
+	defer s.sessionStore.Close()
+
+	sessionID := uuid.New()
+	s.sessionStore.GetOrCreate(sessionID)
+	negotiateCompact(t, s, sessionID)
+	if !s.checksumsEnabled(sessionID) {
+		t.Fatal("Expected checksums to be negotiated")
+	}
+
+	ping, _ := protocol.NewPacket(sessionID, 0, protocol.FlagKeepAlive, []byte("ping"))
+	ping.SetChecksum()
+	data, _ := ping.Marshal()
+	reply, err := s.handleDownstreamPacket(sessionID, data)
+	if err != nil {
+		t.Fatalf("handleDownstreamPacket failed: %v", err)
+	}
+	ack, err := protocol.Unmarshal(reply)
+	if err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if !ack.HasChecksum || !ack.ChecksumValid() {
+		t.Errorf("Expected a reply with a valid checksum, got %+v", ack)
+	}
+
+	// A corrupted keepalive is dropped without a reply
+	data[protocol.HeaderSize] ^= 0x01
+	reply, err = s.handleDownstreamPacket(sessionID, data)
+	if err != nil || reply != nil {
+		t.Errorf("Expected the corrupted packet to be dropped, got %v, %v", reply, err)
+	}
+}
+
+func TestCorruptPacketPolicy(t *testing.T) {
+	tests := []struct {
+		name      string
+		reset     bool
+		wantEntry bool
+	}{
+		{"reset", true, false},
+		{"drop", false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			collector := metrics.NewCollector()
+			config := DefaultConfig()
+			config.Metrics = collector
+			config.ResetCorruptStreams = tt.reset
+			s := New(config, nil)
+			defer s.sessionStore.Close()
+
+			sessionID := uuid.New()
+			conn, dest := net.Pipe()
+			defer dest.Close()
+			key := natKey{SessionID: sessionID, StreamID: 1}
+			s.natTable[key] = &natEntry{conn: conn, destAddr: "example.com:443", created: time.Now()}
+
+			pkt, _ := protocol.NewPacket(sessionID, 1, protocol.FlagData, []byte("hello"))
+			pkt.SetChecksum()
+			pkt.Payload[0] ^= 0x01
+
+			if s.checksumValid(pkt, "upstream") {
+				t.Fatal("Expected checksum mismatch")
+			}
+			if got := testutil.ToFloat64(collector.PacketsCorrupted.WithLabelValues("upstream")); got != 1 {
+				t.Errorf("Expected 1 corrupted packet, got %v", got)
+			}
+			s.natTableMu.RLock()
+			_, exists := s.natTable[key]
+			s.natTableMu.RUnlock()
+			if exists != tt.wantEntry {
+				t.Errorf("Expected stream open: %v, got %v", tt.wantEntry, exists)
+			}
+		})
+	}
+}
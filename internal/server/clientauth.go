@@ -0,0 +1,84 @@
+package server
+
+import (
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/sahmadiut/half-tunnel/internal/clientauth"
+	"github.com/sahmadiut/half-tunnel/internal/protocol"
+)
+
+var errClientAuthRequired = errors.New("client token or certificate required")
+
+// ClientAuthConfig holds settings for identifying clients.
+type ClientAuthConfig struct {
+	// Clients identifies clients by the token sent with their handshake
+	// (nil disables client tokens)
+	Clients *clientauth.Verifier
+	// Required rejects sessions whose client is identified by neither a
+	// token nor a client certificate
+	Required bool
+}
+
+// authenticateClient checks the client behind a packet. For session
+// handshakes it returns the client's identity: the client named by its token,
+// or else the common name of its certificate. Other packets are only checked
+// when identification is required, and return no identity.
+func (s *Server) authenticateClient(pkt *protocol.Packet, clientCN string) (string, error) {
+	auth := s.config.ClientAuth
+	if !pkt.IsHandshake() || pkt.StreamID != 0 {
+		if auth.Required && !s.sessionIdentified(pkt.SessionID) {
+			return "", errClientAuthRequired
+		}
+		return "", nil
+	}
+
+	if token := string(pkt.Payload); auth.Clients != nil && clientauth.IsToken(token) {
+		return auth.Clients.Identify(token)
+	}
+	if clientCN == "" && auth.Required {
+		return "", errClientAuthRequired
+	}
+	return clientCN, nil
+}
+
+// sessionIdentified reports whether the session's client has been
+// identified.
+func (s *Server) sessionIdentified(sessionID uuid.UUID) bool {
+	sess, ok := s.sessionStore.Get(sessionID)
+	return ok && sess.Identity() != ""
+}
+
+// identifySession attaches a client identity to a session.
+func (s *Server) identifySession(sessionID uuid.UUID, identity string) {
+	if identity == "" {
+		return
+	}
+	if !s.sessionStore.GetOrCreate(sessionID).SetIdentity(identity) {
+		return
+	}
+	s.log.Info().
+		Str("session_id", sessionID.String()).
+		Str("client", identity).
+		Msg("Client identified for session")
+	if s.config.Metrics != nil {
+		s.config.Metrics.RecordClientSession(identity)
+	}
+}
+
+// recordClientTraffic adds bytes carried by a stream to its client's traffic
+// and quota. It returns false, after refusing the stream, if the client has
+// used up its quota.
+func (s *Server) recordClientTraffic(sessionID uuid.UUID, streamID uint32, entry *natEntry, direction string, n int) bool {
+	if entry.identity == "" {
+		return true
+	}
+	if s.config.Metrics != nil {
+		s.config.Metrics.RecordClientBytes(entry.identity, direction, n)
+	}
+	if s.config.Quotas != nil && s.config.Quotas.Add(entry.identity, n) != "" {
+		s.refuseOverQuota(sessionID, streamID, entry.identity)
+		return false
+	}
+	return true
+}
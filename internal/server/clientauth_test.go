@@ -0,0 +1,66 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sahmadiut/half-tunnel/internal/clientauth"
+	"github.com/sahmadiut/half-tunnel/internal/metrics"
+	"github.com/sahmadiut/half-tunnel/internal/protocol"
+)
+
+func TestAuthenticateClient(t *testing.T) {
+	token, _ := clientauth.Generate()
+	verifier, err := clientauth.NewVerifier([]clientauth.Client{{Name: "laptop", TokenHash: clientauth.Hash(token)}})
+	if err != nil {
+		t.Fatalf("NewVerifier failed: %v", err)
+	}
+
+	config := DefaultConfig()
+	config.Metrics = metrics.NewCollector()
+	config.ClientAuth = ClientAuthConfig{Clients: verifier, Required: true}
+	s := New(config, nil)
+	defer s.sessionStore.Close()
+
+	sessionID := uuid.New()
+	identity, err := s.authenticateClient(guestHandshake(t, sessionID, token), "")
+	if err != nil || identity != "laptop" {
+		t.Fatalf("Expected the token's client, got %q, %v", identity, err)
+	}
+	s.identifySession(sessionID, identity)
+	s.identifySession(sessionID, identity)
+	if got := testutil.ToFloat64(config.Metrics.ClientSessions.WithLabelValues("laptop")); got != 1 {
+		t.Errorf("Expected 1 client session, got %v", got)
+	}
+
+	// Later packets of the identified session pass
+	dataPkt, _ := protocol.NewPacket(sessionID, 1, protocol.FlagData, []byte("x"))
+	if _, err := s.authenticateClient(dataPkt, ""); err != nil {
+		t.Errorf("Expected packets of an identified session to pass, got %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		pkt      *protocol.Packet
+		clientCN string
+		want     string
+		wantErr  error
+	}{
+		{"unknown token", guestHandshake(t, uuid.New(), clientauth.TokenPrefix+"other"), "", "", clientauth.ErrUnknownToken},
+		{"no token", guestHandshake(t, uuid.New(), ""), "", "", errClientAuthRequired},
+		{"client certificate", guestHandshake(t, uuid.New(), ""), "device-1", "device-1", nil},
+		{"token over certificate", guestHandshake(t, uuid.New(), token), "device-1", "laptop", nil},
+	}
+	for _, tt := range tests {
+		identity, err := s.authenticateClient(tt.pkt, tt.clientCN)
+		if identity != tt.want || err != tt.wantErr {
+			t.Errorf("%s: got %q, %v; want %q, %v", tt.name, identity, err, tt.want, tt.wantErr)
+		}
+	}
+
+	unknown, _ := protocol.NewPacket(uuid.New(), 1, protocol.FlagData, []byte("x"))
+	if _, err := s.authenticateClient(unknown, ""); err != errClientAuthRequired {
+		t.Errorf("Expected packets of unidentified sessions rejected, got %v", err)
+	}
+}
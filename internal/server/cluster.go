@@ -0,0 +1,336 @@
+package server
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"github.com/sahmadiut/half-tunnel/internal/transport"
+)
+
+// ClusterConfig lets several servers behind one name share clients. Every
+// session is owned by one node, chosen by rendezvous hashing of the session
+// ID over the node names, so the upstream and downstream legs of a session
+// meet on the same server even when they connect to different ones. A leg
+// that arrives at a node that does not own its session is relayed to the
+// owner, which learns the client's address and certificate from a header
+// signed with Secret. All nodes must list the same peers and share Secret
+// and the path token secret.
+type ClusterConfig struct {
+	// Node is this server's name; it must be unique within the cluster
+	Node string
+	// Peers are the other servers in the cluster (empty disables clustering)
+	Peers []ClusterPeer
+	// Secret signs the client details nodes pass along with relayed
+	// connections
+	Secret string
+	// InsecureSkipVerify disables certificate checks when dialing peers
+	InsecureSkipVerify bool
+}
+
+// ClusterPeer is another server in the cluster.
+type ClusterPeer struct {
+	// Name is the peer's node name
+	Name string
+	// UpstreamURL is the peer's upstream WebSocket URL, without path token
+	UpstreamURL string
+	// DownstreamURL is the peer's downstream WebSocket URL, without path token
+	DownstreamURL string
+}
+
+// clusterEnabled reports whether sessions are shared with peers.
+func (s *Server) clusterEnabled() bool {
+	return len(s.config.Cluster.Peers) > 0
+}
+
+// sessionOwner returns the peer that owns sessionID, or false when this node
+// owns it.
+func (s *Server) sessionOwner(sessionID uuid.UUID) (ClusterPeer, bool) {
+	best := rendezvousScore(s.config.Cluster.Node, sessionID)
+	var owner ClusterPeer
+	remote := false
+	for _, peer := range s.config.Cluster.Peers {
+		if score := rendezvousScore(peer.Name, sessionID); score > best {
+			best = score
+			owner = peer
+			remote = true
+		}
+	}
+	return owner, remote
+}
+
+// rendezvousScore is the weight of node for sessionID; the highest wins.
+func rendezvousScore(node string, sessionID uuid.UUID) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(node))
+	_, _ = h.Write(sessionID[:])
+	return h.Sum64()
+}
+
+// relayToOwner forwards a connection whose session belongs to a peer. first
+// is the packet already read from conn. It returns false without touching
+// conn if the session is owned locally or conn was relayed to this node
+// already, in which case the caller handles the connection itself. If the
+// owner cannot be reached, conn is closed so the client retries, possibly
+// through another node; serving the session here would split it.
+func (s *Server) relayToOwner(ctx context.Context, conn *transport.Connection, sessionID uuid.UUID, first []byte, direction string) bool {
+	if !s.clusterEnabled() {
+		return false
+	}
+	peer, remote := s.sessionOwner(sessionID)
+	if !remote {
+		return false
+	}
+	if conn.Relayed() {
+		// The relaying node sees a different peer list; relaying again
+		// could loop
+		s.log.Warn().
+			Str("session_id", sessionID.String()).
+			Str("peer", peer.Name).
+			Str("direction", direction).
+			Msg("Relayed session belongs to another node, check that all nodes list the same peers")
+		return false
+	}
+
+	target := peer.UpstreamURL
+	if direction == "downstream" {
+		target = peer.DownstreamURL
+	}
+	peerConn, err := s.dialPeer(ctx, target, conn)
+	if err == nil {
+		err = peerConn.Write(first)
+		if err != nil {
+			peerConn.Close()
+		}
+	}
+	if err != nil {
+		s.log.Warn().Err(err).
+			Str("session_id", sessionID.String()).
+			Str("peer", peer.Name).
+			Str("direction", direction).
+			Msg("Session owner unreachable, closing connection")
+		s.recordError("cluster_relay")
+		conn.Close()
+		return true
+	}
+
+	s.log.Info().
+		Str("session_id", sessionID.String()).
+		Str("peer", peer.Name).
+		Str("direction", direction).
+		Str("remote_addr", conn.RemoteAddr()).
+		Msg("Relaying connection to session owner")
+
+	pipeConnections(ctx, s.shutdown, conn, peerConn)
+
+	s.log.Debug().
+		Str("session_id", sessionID.String()).
+		Str("peer", peer.Name).
+		Str("direction", direction).
+		Msg("Relay closed")
+	return true
+}
+
+// dialPeer opens a WebSocket connection to a peer endpoint on behalf of the
+// client of conn, adding the signed relay header and the current path token
+// and upgrade cookie when they are enabled.
+func (s *Server) dialPeer(ctx context.Context, target string, conn *transport.Connection) (*transport.Connection, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, fmt.Errorf("invalid peer URL %q: %w", target, err)
+	}
+	base := u.Path
+	if s.pathTokens != nil {
+		u.Path = s.pathTokens.Path(u.Path, time.Now())
+	}
+
+	config := transport.DefaultConfig(u.String())
+	config.Header = http.Header{relayHeader: {s.signRelay(conn.RemoteAddr(), conn.PeerCommonName(), time.Now())}}
+	if s.upgradeTokens != nil {
+		cookie := &http.Cookie{Name: s.upgradeCookieName(), Value: s.upgradeTokens.Token(base, time.Now())}
+		config.Header.Set("Cookie", cookie.String())
+	}
+	config.MaxMessageSize = int64(s.config.MaxMessageSize)
+	config.ReadBufferSize = s.config.ReadBufferSize
+	config.WriteBufferSize = s.config.WriteBufferSize
+	// The client side of the relay enforces its own read timeout
+	config.ReadTimeout = 0
+	if s.config.Cluster.InsecureSkipVerify {
+		config.TLSConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+
+	dialCtx, cancel := context.WithTimeout(ctx, s.config.DialTimeout)
+	defer cancel()
+	return transport.Dial(dialCtx, config)
+}
+
+// relayHeader carries the details of the client a node relays a connection
+// for, signed with the cluster secret.
+const relayHeader = "X-Half-Tunnel-Relay"
+
+// relayMaxAge is how far the signing time of a relay header may be from the
+// receiving node's clock.
+const relayMaxAge = 30 * time.Second
+
+// Reasons a relay header is rejected.
+var (
+	errRelayDisabled  = errors.New("relayed connection but clustering is disabled")
+	errRelaySignature = errors.New("invalid relay signature")
+	errRelayExpired   = errors.New("relay header expired")
+)
+
+// signRelay returns the relay header value for a client at remoteAddr whose
+// verified certificate has common name cn.
+func (s *Server) signRelay(remoteAddr, cn string, now time.Time) string {
+	fields := url.Values{}
+	fields.Set("node", s.config.Cluster.Node)
+	fields.Set("addr", remoteAddr)
+	fields.Set("cn", cn)
+	fields.Set("ts", strconv.FormatInt(now.Unix(), 10))
+	payload := fields.Encode()
+	return payload + "&sig=" + s.relaySignature(payload)
+}
+
+// verifyRelay checks a relay header value from a peer and returns the
+// client's address and certificate common name.
+func (s *Server) verifyRelay(value string, now time.Time) (string, string, error) {
+	if !s.clusterEnabled() || s.config.Cluster.Secret == "" {
+		return "", "", errRelayDisabled
+	}
+	i := strings.LastIndex(value, "&sig=")
+	if i < 0 {
+		return "", "", errRelaySignature
+	}
+	payload, sig := value[:i], value[i+len("&sig="):]
+	if !hmac.Equal([]byte(sig), []byte(s.relaySignature(payload))) {
+		return "", "", errRelaySignature
+	}
+
+	fields, err := url.ParseQuery(payload)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid relay header: %w", err)
+	}
+	ts, err := strconv.ParseInt(fields.Get("ts"), 10, 64)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid relay time: %w", err)
+	}
+	if age := now.Sub(time.Unix(ts, 0)); age > relayMaxAge || age < -relayMaxAge {
+		return "", "", errRelayExpired
+	}
+	addr := fields.Get("addr")
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return "", "", fmt.Errorf("invalid relayed client address %q: %w", addr, err)
+	}
+	return addr, fields.Get("cn"), nil
+}
+
+// relaySignature returns the hex HMAC-SHA256 of payload under the cluster
+// secret.
+func (s *Server) relaySignature(payload string) string {
+	mac := hmac.New(sha256.New, []byte(s.config.Cluster.Secret))
+	_, _ = mac.Write([]byte(payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// acceptRelays passes requests to next. Upgrades relayed by a peer must carry
+// a valid relay header and are passed on with the client's address and
+// certificate; other upgrades with a bad header get the decoy. With
+// clustering, listeners that require client certificates accept TLS
+// connections without one so peers can relay, and direct upgrades without a
+// certificate are refused here instead.
+func (s *Server) acceptRelays(tlsConfig TLSConfig, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !websocket.IsWebSocketUpgrade(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		value := r.Header.Get(relayHeader)
+		if value == "" {
+			if s.clusterEnabled() && tlsConfig.Enabled && tlsConfig.RequireClientCert && (r.TLS == nil || len(r.TLS.VerifiedChains) == 0) {
+				s.log.Debug().
+					Str("remote_addr", r.RemoteAddr).
+					Msg("Rejected upgrade without client certificate")
+				s.handshakeFailed(r.RemoteAddr, handshakeClientCert)
+				s.serveDecoy(w, r)
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		addr, cn, err := s.verifyRelay(value, time.Now())
+		if err != nil {
+			s.log.Warn().Err(err).
+				Str("remote_addr", r.RemoteAddr).
+				Msg("Rejected relayed upgrade")
+			s.handshakeFailed(r.RemoteAddr, handshakeRelay)
+			s.serveDecoy(w, r)
+			return
+		}
+		relayed := r.WithContext(transport.WithRelayedClient(r.Context(), cn))
+		relayed.RemoteAddr = addr
+		next.ServeHTTP(w, relayed)
+	})
+}
+
+// allowRelays lets TLS connections without a client certificate through a
+// listener that requires one when clustering is enabled, so peers can relay
+// to it; acceptRelays refuses direct upgrades without one instead.
+func (s *Server) allowRelays(tlsConfig *tls.Config) {
+	if s.clusterEnabled() && tlsConfig.ClientAuth == tls.RequireAndVerifyClientCert {
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+}
+
+// pipeConnections copies messages in both directions until either side
+// closes, ctx is done or stop is closed, then closes both.
+func pipeConnections(ctx context.Context, stop <-chan struct{}, a, b *transport.Connection) {
+	var once sync.Once
+	done := make(chan struct{})
+	closeBoth := func() {
+		once.Do(func() {
+			a.Close()
+			b.Close()
+			close(done)
+		})
+	}
+
+	copyMessages := func(dst, src *transport.Connection) {
+		defer closeBoth()
+		for {
+			data, err := src.Read()
+			if err != nil {
+				return
+			}
+			if err := dst.Write(data); err != nil {
+				return
+			}
+		}
+	}
+
+	go copyMessages(a, b)
+	go copyMessages(b, a)
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		closeBoth()
+	case <-stop:
+		closeBoth()
+	}
+}
@@ -0,0 +1,276 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sahmadiut/half-tunnel/internal/protocol"
+	"github.com/sahmadiut/half-tunnel/internal/transport"
+)
+
+func TestSessionOwnerAgreesAcrossNodes(t *testing.T) {
+	nodes := []string{"a", "b", "c"}
+	servers := make([]*Server, len(nodes))
+	for i, node := range nodes {
+		config := DefaultConfig()
+		config.Cluster.Node = node
+		for _, peer := range nodes {
+			if peer != node {
+				config.Cluster.Peers = append(config.Cluster.Peers, ClusterPeer{Name: peer})
+			}
+		}
+		servers[i] = New(config, nil)
+	}
+
+	owned := make(map[string]int)
+	for i := 0; i < 300; i++ {
+		sessionID := uuid.New()
+		owners := make(map[string]bool)
+		for j, s := range servers {
+			owner := nodes[j]
+			if peer, remote := s.sessionOwner(sessionID); remote {
+				owner = peer.Name
+			}
+			owners[owner] = true
+		}
+		if len(owners) != 1 {
+			t.Fatalf("Nodes disagree on the owner of %s: %v", sessionID, owners)
+		}
+		for owner := range owners {
+			owned[owner]++
+		}
+	}
+	for _, node := range nodes {
+		if owned[node] == 0 {
+			t.Errorf("Expected node %s to own some sessions, got %v", node, owned)
+		}
+	}
+}
+
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to find a free port: %v", err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+func TestRelayToSessionOwner(t *testing.T) {
+	addrs := map[string]string{"a": freeAddr(t), "b": freeAddr(t)}
+	servers := make(map[string]*Server)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	for node, addr := range addrs {
+		config := DefaultConfig()
+		config.UpstreamAddr = addr
+		config.DownstreamAddr = addr
+		config.Cluster.Node = node
+		config.Cluster.Secret = "cluster-secret"
+		for peer, peerAddr := range addrs {
+			if peer != node {
+				config.Cluster.Peers = append(config.Cluster.Peers, ClusterPeer{
+					Name:          peer,
+					UpstreamURL:   fmt.Sprintf("ws://%s/upstream", peerAddr),
+					DownstreamURL: fmt.Sprintf("ws://%s/downstream", peerAddr),
+				})
+			}
+		}
+		s := New(config, nil)
+		if err := s.Start(ctx); err != nil {
+			t.Fatalf("Failed to start server %s: %v", node, err)
+		}
+		defer s.Stop(context.Background())
+		servers[node] = s
+	}
+
+	// Pick a session owned by "a" and connect both legs to "b"
+	var sessionID uuid.UUID
+	for {
+		sessionID = uuid.New()
+		if _, remote := servers["a"].sessionOwner(sessionID); !remote {
+			break
+		}
+	}
+	handshake, _ := protocol.NewPacket(sessionID, 0, protocol.FlagHandshake, nil)
+	data, _ := handshake.Marshal()
+
+	for _, path := range []string{"/upstream", "/downstream"} {
+		conn, err := transport.Dial(ctx, transport.DefaultConfig("ws://"+addrs["b"]+path))
+		if err != nil {
+			t.Fatalf("Failed to dial %s: %v", path, err)
+		}
+		defer conn.Close()
+		if err := conn.Write(data); err != nil {
+			t.Fatalf("Failed to send handshake on %s: %v", path, err)
+		}
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		servers["a"].downstreamConnsMu.RLock()
+		_, hasDownstream := servers["a"].downstreamConns[sessionID]
+		servers["a"].downstreamConnsMu.RUnlock()
+		if _, hasSession := servers["a"].sessionStore.Get(sessionID); hasSession && hasDownstream {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if _, exists := servers["a"].sessionStore.Get(sessionID); !exists {
+		t.Error("Expected the owner to hold the session")
+	}
+	servers["a"].downstreamConnsMu.RLock()
+	downstream, hasDownstream := servers["a"].downstreamConns[sessionID]
+	servers["a"].downstreamConnsMu.RUnlock()
+	if !hasDownstream {
+		t.Error("Expected the owner to hold the downstream connection")
+	} else if !downstream.Relayed() {
+		t.Error("Expected the owner to see a relayed connection")
+	}
+	if servers["b"].GetSessionCount() != 0 {
+		t.Errorf("Expected the relaying node to hold no sessions, got %d", servers["b"].GetSessionCount())
+	}
+}
+
+// clusterServer returns a server of node "a" in a cluster with node "b".
+func clusterServer(secret string) *Server {
+	config := DefaultConfig()
+	config.Cluster.Node = "a"
+	config.Cluster.Secret = secret
+	config.Cluster.Peers = []ClusterPeer{{Name: "b"}}
+	return New(config, nil)
+}
+
+func TestVerifyRelay(t *testing.T) {
+	s := clusterServer("cluster-secret")
+	now := time.Now()
+	value := s.signRelay("198.51.100.7:40000", "alice", now)
+
+	addr, cn, err := s.verifyRelay(value, now.Add(time.Second))
+	if err != nil {
+		t.Fatalf("verifyRelay failed: %v", err)
+	}
+	if addr != "198.51.100.7:40000" || cn != "alice" {
+		t.Errorf("Expected the client's address and name, got %s and %q", addr, cn)
+	}
+
+	tests := []struct {
+		name  string
+		s     *Server
+		value string
+		now   time.Time
+	}{
+		{"other secret", clusterServer("other-secret"), value, now},
+		{"clustering disabled", New(nil, nil), value, now},
+		{"changed address", s, strings.Replace(value, "198.51.100.7", "198.51.100.8", 1), now},
+		{"no signature", s, value[:strings.LastIndex(value, "&sig=")], now},
+		{"expired", s, value, now.Add(relayMaxAge + time.Second)},
+	}
+	for _, tt := range tests {
+		if _, _, err := tt.s.verifyRelay(tt.value, tt.now); err == nil {
+			t.Errorf("%s: expected the relay header to be rejected", tt.name)
+		}
+	}
+}
+
+func TestAcceptRelays(t *testing.T) {
+	s := clusterServer("cluster-secret")
+	var gotAddr string
+	handler := s.acceptRelays(TLSConfig{}, s.newTunnelMux(tunnelRoute{"/ws/upstream", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotAddr = r.RemoteAddr
+		w.WriteHeader(http.StatusNoContent)
+	})}))
+
+	r := upgradeRequest("/ws/upstream")
+	r.Header.Set(relayHeader, s.signRelay("198.51.100.7:40000", "", time.Now()))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("Expected the relayed upgrade to pass, got %d", w.Code)
+	}
+	if gotAddr != "198.51.100.7:40000" {
+		t.Errorf("Expected the client's address, got %s", gotAddr)
+	}
+
+	r = upgradeRequest("/ws/upstream")
+	r.Header.Set(relayHeader, clusterServer("other-secret").signRelay("198.51.100.7:40000", "", time.Now()))
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected the decoy for a forged relay header, got %d", w.Code)
+	}
+
+	// Listeners requiring client certificates let relays through without one
+	handler = s.acceptRelays(TLSConfig{Enabled: true, RequireClientCert: true}, s.newTunnelMux(tunnelRoute{"/ws/upstream", stubTunnel}))
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, upgradeRequest("/ws/upstream"))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected the decoy for a direct upgrade without certificate, got %d", w.Code)
+	}
+	r = upgradeRequest("/ws/upstream")
+	r.Header.Set(relayHeader, s.signRelay("198.51.100.7:40000", "alice", time.Now()))
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusNoContent {
+		t.Errorf("Expected the relayed upgrade to pass, got %d", w.Code)
+	}
+}
+
+func TestRelayFailsClosed(t *testing.T) {
+	addr := freeAddr(t)
+	config := DefaultConfig()
+	config.UpstreamAddr = addr
+	config.DownstreamAddr = addr
+	config.Cluster.Node = "a"
+	config.Cluster.Secret = "cluster-secret"
+	unreachable := freeAddr(t)
+	config.Cluster.Peers = []ClusterPeer{{
+		Name:          "b",
+		UpstreamURL:   "ws://" + unreachable + "/upstream",
+		DownstreamURL: "ws://" + unreachable + "/downstream",
+	}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := New(config, nil)
+	if err := s.Start(ctx); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer s.Stop(context.Background())
+
+	var sessionID uuid.UUID
+	for {
+		sessionID = uuid.New()
+		if _, remote := s.sessionOwner(sessionID); remote {
+			break
+		}
+	}
+
+	conn, err := transport.Dial(ctx, transport.DefaultConfig("ws://"+addr+"/downstream"))
+	if err != nil {
+		t.Fatalf("Failed to dial downstream: %v", err)
+	}
+	defer conn.Close()
+	handshake, _ := protocol.NewHandshakePacket(sessionID)
+	data, _ := handshake.Marshal()
+	if err := conn.Write(data); err != nil {
+		t.Fatalf("Failed to send handshake: %v", err)
+	}
+
+	if _, err := conn.Read(); err == nil {
+		t.Error("Expected the connection to be closed")
+	}
+	if s.GetSessionCount() != 0 {
+		t.Errorf("Expected no local session, got %d", s.GetSessionCount())
+	}
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/google/uuid"
+	"github.com/sahmadiut/half-tunnel/internal/protocol"
+)
+
+// sessionIndex is the compact header index of a session. The server only
+// sends compact headers once the client used its index, so the client has
+// seen the hello that assigned it.
+type sessionIndex struct {
+	sessionID uuid.UUID
+	index     uint32
+	confirmed int32 // set by the first compact packet, updated atomically
+}
+
+// assignSessionIndex returns the compact header index of a session,
+// assigning one if the session has none yet. Indexes are random, so they
+// tell nothing about other sessions, and never zero.
+func (s *Server) assignSessionIndex(sessionID uuid.UUID) uint32 {
+	s.sessionIndexMu.Lock()
+	defer s.sessionIndexMu.Unlock()
+
+	if idx, ok := s.sessionIndexes[sessionID]; ok {
+		return idx.index
+	}
+	for {
+		var b [4]byte
+		_, _ = rand.Read(b[:])
+		index := binary.BigEndian.Uint32(b[:])
+		if _, taken := s.sessionsByIndex[index]; index == 0 || taken {
+			continue
+		}
+		idx := &sessionIndex{sessionID: sessionID, index: index}
+		s.sessionIndexes[sessionID] = idx
+		s.sessionsByIndex[index] = idx
+		return index
+	}
+}
+
+// compactIndex returns the index to send packets of a session with, or 0
+// if the session does not use compact headers.
+func (s *Server) compactIndex(sessionID uuid.UUID) uint32 {
+	s.sessionIndexMu.RLock()
+	idx, ok := s.sessionIndexes[sessionID]
+	s.sessionIndexMu.RUnlock()
+	if !ok || atomic.LoadInt32(&idx.confirmed) == 0 {
+		return 0
+	}
+	return idx.index
+}
+
+// releaseSessionIndex frees the index of a closed session.
+func (s *Server) releaseSessionIndex(sessionID uuid.UUID) {
+	s.sessionIndexMu.Lock()
+	defer s.sessionIndexMu.Unlock()
+
+	if idx, ok := s.sessionIndexes[sessionID]; ok {
+		delete(s.sessionIndexes, sessionID)
+		delete(s.sessionsByIndex, idx.index)
+	}
+}
+
+// resolveSessionIndex fills in the session ID of a packet with the compact
+// header. An index only stands for the session its connection is bound to,
+// bound, so guessing the index of another session gets nowhere; connections
+// not bound yet (uuid.Nil) cannot use compact headers.
+func (s *Server) resolveSessionIndex(pkt *protocol.Packet, bound uuid.UUID) error {
+	if !pkt.IsCompact() {
+		return nil
+	}
+	s.sessionIndexMu.RLock()
+	idx, ok := s.sessionsByIndex[pkt.SessionIndex]
+	s.sessionIndexMu.RUnlock()
+	if !ok || bound == uuid.Nil || idx.sessionID != bound {
+		return fmt.Errorf("unknown session index %d", pkt.SessionIndex)
+	}
+	atomic.StoreInt32(&idx.confirmed, 1)
+	pkt.SessionID = idx.sessionID
+	return nil
+}
+
+// unmarshalPacket decodes a packet received from a client on a connection
+// bound to the session bound (uuid.Nil if not bound yet), resolving the
+// session of compact headers.
+func (s *Server) unmarshalPacket(data []byte, bound uuid.UUID) (*protocol.Packet, error) {
+	pkt, err := protocol.Unmarshal(data)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.resolveSessionIndex(pkt, bound); err != nil {
+		return nil, err
+	}
+	return pkt, nil
+}
+
+// marshalPacket encodes a packet for a client, with the compact header and
+// checksum if its session negotiated them.
+func (s *Server) marshalPacket(pkt *protocol.Packet) ([]byte, error) {
+	pkt.SessionIndex = s.compactIndex(pkt.SessionID)
+	if s.checksumsEnabled(pkt.SessionID) {
+		pkt.SetChecksum()
+	}
+	return pkt.Marshal()
+}
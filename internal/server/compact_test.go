@@ -0,0 +1,176 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sahmadiut/half-tunnel/internal/metrics"
+	"github.com/sahmadiut/half-tunnel/internal/protocol"
+	"github.com/sahmadiut/half-tunnel/internal/transport"
+)
+
+// negotiateCompact runs the hello exchange for a session and returns the
+// session index the server assigned.
+func negotiateCompact(t *testing.T, s *Server, sessionID uuid.UUID) uint32 {
+	t.Helper()
+	hello, _ := protocol.NewHelloPacket(sessionID, protocol.LocalHello())
+	data, _ := hello.Marshal()
+	reply, err := s.handleDownstreamPacket(sessionID, data)
+	if err != nil {
+		t.Fatalf("handleDownstreamPacket failed: %v", err)
+	}
+	pkt, err := protocol.Unmarshal(reply)
+	if err != nil {
+		t.Fatalf("Expected a hello reply, got %v", err)
+	}
+	if pkt.IsCompact() {
+		t.Fatal("Expected the hello reply to have the full header")
+	}
+	_, body, _ := protocol.ParseControl(pkt)
+	got, _ := protocol.ParseHello(body)
+	return got.SessionIndex
+}
+
+func TestCompactHeaderSession(t *testing.T) {
+	s := New(nil, nil)
+	defer s.sessionStore.Close()
+
+	sessionID := uuid.New()
+	s.sessionStore.GetOrCreate(sessionID)
+	index := negotiateCompact(t, s, sessionID)
+	if index == 0 {
+		t.Fatal("Expected a session index")
+	}
+	if again := negotiateCompact(t, s, sessionID); again != index {
+		t.Errorf("Expected the session to keep index %d, got %d", index, again)
+	}
+
+	// Replies keep the full header until the client used its index
+	ping, _ := protocol.NewPacket(sessionID, 0, protocol.FlagKeepAlive, []byte("ping"))
+	data, _ := ping.Marshal()
+	reply, err := s.handleDownstreamPacket(sessionID, data)
+	if err != nil {
+		t.Fatalf("handleDownstreamPacket failed: %v", err)
+	}
+	if ack, _ := protocol.Unmarshal(reply); ack.IsCompact() {
+		t.Error("Expected a full header before the client used its index")
+	}
+
+	ping.SessionIndex = index
+	data, _ = ping.Marshal()
+	reply, err = s.handleDownstreamPacket(sessionID, data)
+	if err != nil {
+		t.Fatalf("handleDownstreamPacket failed: %v", err)
+	}
+	ack, _ := protocol.Unmarshal(reply)
+	if !ack.IsCompact() || ack.SessionIndex != index {
+		t.Errorf("Expected a compact reply with index %d, got %+v", index, ack)
+	}
+
+	s.teardownSession(sessionID, closeReasonAdmin)
+	if _, err := s.handleDownstreamPacket(sessionID, data); err == nil {
+		t.Error("Expected the index to be released with the session")
+	}
+}
+
+func TestCompactHeaderNotOffered(t *testing.T) {
+	s := New(nil, nil)
+	defer s.sessionStore.Close()
+
+	sessionID := uuid.New()
+	hello, _ := protocol.NewHelloPacket(sessionID, protocol.Hello{
+		Version:      protocol.Version,
+		Capabilities: protocol.CapCloseReasons,
+	})
+	data, _ := hello.Marshal()
+	reply, err := s.handleDownstreamPacket(sessionID, data)
+	if err != nil {
+		t.Fatalf("handleDownstreamPacket failed: %v", err)
+	}
+	pkt, _ := protocol.Unmarshal(reply)
+	_, body, _ := protocol.ParseControl(pkt)
+	if got, _ := protocol.ParseHello(body); got.SessionIndex != 0 {
+		t.Errorf("Expected no session index, got %d", got.SessionIndex)
+	}
+	if index := s.compactIndex(sessionID); index != 0 {
+		t.Errorf("Expected no compact index, got %d", index)
+	}
+}
+
+func TestCompactHeaderBoundToSession(t *testing.T) {
+	s := New(nil, nil)
+	defer s.sessionStore.Close()
+
+	owner := uuid.New()
+	other := uuid.New()
+	s.sessionStore.GetOrCreate(owner)
+	s.sessionStore.GetOrCreate(other)
+	index := negotiateCompact(t, s, owner)
+	negotiateCompact(t, s, other)
+
+	ping, _ := protocol.NewPacket(owner, 0, protocol.FlagKeepAlive, []byte("ping"))
+	ping.SessionIndex = index
+	data, _ := ping.Marshal()
+	if _, err := s.handleDownstreamPacket(other, data); err == nil {
+		t.Error("Expected another session's index to be rejected")
+	}
+	if _, err := s.unmarshalPacket(data, uuid.Nil); err == nil {
+		t.Error("Expected a compact header on an unbound connection to be rejected")
+	}
+	if _, err := s.handleDownstreamPacket(owner, data); err != nil {
+		t.Errorf("Expected the owning session to use its index: %v", err)
+	}
+}
+
+func TestUpstreamConnectionBoundToSession(t *testing.T) {
+	addr := freeAddr(t)
+	config := DefaultConfig()
+	config.UpstreamAddr = addr
+	config.DownstreamAddr = addr
+	config.Metrics = metrics.NewCollector()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := New(config, nil)
+	if err := s.Start(ctx); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer s.Stop(context.Background())
+
+	conn, err := transport.Dial(ctx, transport.DefaultConfig("ws://"+addr+"/upstream"))
+	if err != nil {
+		t.Fatalf("Failed to dial upstream: %v", err)
+	}
+	defer conn.Close()
+
+	owner := uuid.New()
+	handshake, _ := protocol.NewHandshakePacket(owner)
+	data, _ := handshake.Marshal()
+	if err := conn.Write(data); err != nil {
+		t.Fatalf("Failed to send handshake: %v", err)
+	}
+	other := uuid.New()
+	ping, _ := protocol.NewPacket(other, 0, protocol.FlagKeepAlive, []byte("ping"))
+	data, _ = ping.Marshal()
+	if err := conn.Write(data); err != nil {
+		t.Fatalf("Failed to send packet: %v", err)
+	}
+
+	mismatches := config.Metrics.Errors.WithLabelValues("session_mismatch")
+	deadline := time.Now().Add(2 * time.Second)
+	for testutil.ToFloat64(mismatches) != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the packet for another session to be dropped")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, ok := s.sessionStore.Get(other); ok {
+		t.Error("Expected no session for the dropped packet")
+	}
+	if _, ok := s.sessionStore.Get(owner); !ok {
+		t.Error("Expected the bound session to exist")
+	}
+}
@@ -0,0 +1,61 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+)
+
+// DecoyConfig selects what the tunnel listeners serve for requests that are
+// not tunnel WebSocket upgrades. At most one of Dir and ProxyURL may be set;
+// with neither, such requests get a plain 404.
+type DecoyConfig struct {
+	// Dir is a directory of static files to serve
+	Dir string
+	// ProxyURL is a website to reverse proxy to
+	ProxyURL string
+}
+
+// newDecoyHandler builds the handler for non-tunnel requests. It returns nil
+// if no decoy is configured.
+func newDecoyHandler(cfg DecoyConfig) (http.Handler, error) {
+	switch {
+	case cfg.Dir != "" && cfg.ProxyURL != "":
+		return nil, fmt.Errorf("decoy dir and proxy_url are mutually exclusive")
+	case cfg.Dir != "":
+		info, err := os.Stat(cfg.Dir)
+		if err != nil {
+			return nil, fmt.Errorf("invalid decoy dir: %w", err)
+		}
+		if !info.IsDir() {
+			return nil, fmt.Errorf("invalid decoy dir: %s is not a directory", cfg.Dir)
+		}
+		return http.FileServer(http.Dir(cfg.Dir)), nil
+	case cfg.ProxyURL != "":
+		target, err := url.Parse(cfg.ProxyURL)
+		if err != nil || target.Scheme == "" || target.Host == "" {
+			return nil, fmt.Errorf("invalid decoy proxy_url: %s", cfg.ProxyURL)
+		}
+		proxy := httputil.NewSingleHostReverseProxy(target)
+		director := proxy.Director
+		proxy.Director = func(r *http.Request) {
+			director(r)
+			// Present the upstream site's own host name, as a browser would
+			r.Host = target.Host
+		}
+		return proxy, nil
+	default:
+		return nil, nil
+	}
+}
+
+// serveDecoy answers a request that is not a tunnel connection.
+func (s *Server) serveDecoy(w http.ResponseWriter, r *http.Request) {
+	if s.decoy == nil {
+		http.NotFound(w, r)
+		return
+	}
+	s.decoy.ServeHTTP(w, r)
+}
@@ -0,0 +1,376 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sahmadiut/half-tunnel/internal/diag"
+	"github.com/sahmadiut/half-tunnel/internal/protocol"
+	"github.com/sahmadiut/half-tunnel/internal/resolver"
+	"github.com/sahmadiut/half-tunnel/internal/retry"
+	"github.com/sahmadiut/half-tunnel/internal/session"
+)
+
+// maxPendingBytes caps the data queued for a stream while its destination is
+// being dialed. A client that sends more before the dial completes has the
+// stream closed.
+const maxPendingBytes = 256 * 1024
+
+var (
+	errPendingLimit = errors.New("too much data queued while dialing destination")
+	errDialAborted  = errors.New("dial aborted")
+)
+
+// destConn returns the destination connection, or nil while it is being
+// dialed.
+func (e *natEntry) destConn() net.Conn {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.conn
+}
+
+// queue holds a copy of payload if the destination is still being dialed. It
+// returns false when the connection is established and the caller should
+// write the payload itself.
+func (e *natEntry) queue(payload []byte) (bool, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.conn != nil {
+		return false, nil
+	}
+	if e.closed {
+		return true, nil
+	}
+	if e.pendingBytes+len(payload) > maxPendingBytes {
+		return false, errPendingLimit
+	}
+	e.pending = append(e.pending, append([]byte(nil), payload...))
+	e.pendingBytes += len(payload)
+	return true, nil
+}
+
+// establish writes the queued data to conn and makes it the entry's
+// connection. It fails if the entry was closed while dialing or the queued
+// data cannot be written; conn is left for the caller to close. Holding the
+// lock while flushing keeps queued data ahead of later writes.
+func (e *natEntry) establish(conn net.Conn) (int, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.closed {
+		return 0, net.ErrClosed
+	}
+	written := 0
+	for _, data := range e.pending {
+		if _, err := conn.Write(data); err != nil {
+			return written, err
+		}
+		written += len(data)
+	}
+	// The client finished sending while the destination was being dialed
+	if e.halfClosed() {
+		if err := halfClose(conn); err != nil {
+			return written, err
+		}
+	}
+	e.pending = nil
+	e.pendingBytes = 0
+	e.conn = conn
+	return written, nil
+}
+
+// close marks the entry closed and closes its connection, if any.
+func (e *natEntry) close() {
+	e.mu.Lock()
+	conn := e.conn
+	e.closed = true
+	e.pending = nil
+	e.mu.Unlock()
+
+	if conn != nil {
+		conn.Close()
+	}
+}
+
+// countReceived counts a data packet of n bytes received from the client.
+func (e *natEntry) countReceived(n int) {
+	if e.stream != nil {
+		e.stream.CountReceived(n)
+	}
+}
+
+// countSent counts a data packet of n bytes sent to the client.
+func (e *natEntry) countSent(n int) {
+	if e.stream != nil {
+		e.stream.CountSent(n)
+	}
+}
+
+// acquireDialSlot waits for room under MaxConcurrentDials. It returns false
+// if ctx is done or the server shuts down first.
+func (s *Server) acquireDialSlot(ctx context.Context) bool {
+	if s.dialSlots == nil {
+		return true
+	}
+	select {
+	case s.dialSlots <- struct{}{}:
+		return true
+	case <-ctx.Done():
+		return false
+	case <-s.shutdown:
+		return false
+	}
+}
+
+// releaseDialSlot frees a slot taken by acquireDialSlot.
+func (s *Server) releaseDialSlot() {
+	if s.dialSlots != nil {
+		<-s.dialSlots
+	}
+}
+
+// dialWithRetry dials destAddr up to policy.Attempts times, backing off
+// between attempts. Each attempt takes a dial slot, which is released while
+// backing off. It returns the number of dials made.
+func (s *Server) dialWithRetry(ctx context.Context, destAddr string, destPort uint16, policy DialRetryPolicy) (net.Conn, int, error) {
+	var retryer *retry.Retryer
+	if policy.Attempts > 1 {
+		retryer = retry.New(&retry.Config{
+			InitialDelay: policy.Backoff,
+			MaxDelay:     policy.MaxBackoff,
+			Multiplier:   2.0,
+			Jitter:       0.1,
+			MaxAttempts:  policy.Attempts - 1,
+		})
+	}
+
+	attempts := 0
+	for {
+		if !s.acquireDialSlot(ctx) {
+			return nil, attempts, errDialAborted
+		}
+
+		s.log.Debug().
+			Str("dest_addr", destAddr).
+			Int("attempt", attempts+1).
+			Msg("Connecting to destination")
+
+		dialStart := time.Now()
+		conn, err := s.dialTCP(ctx, s.newDialer(), destAddr)
+		s.releaseDialSlot()
+		s.recordDial(destPort, err == nil, time.Since(dialStart))
+		attempts++
+
+		if err == nil || retryer == nil || !retryableDialError(err) {
+			return conn, attempts, err
+		}
+		if waitErr := retryer.Wait(ctx); waitErr != nil {
+			return nil, attempts, err
+		}
+		s.log.Debug().Err(err).
+			Str("dest_addr", destAddr).
+			Int("attempt", attempts).
+			Msg("Destination dial failed, retrying")
+	}
+}
+
+// dialTCP connects to destAddr, resolving its host with the configured
+// resolver if there is one, and applies the TCP socket options.
+func (s *Server) dialTCP(ctx context.Context, dialer *net.Dialer, destAddr string) (net.Conn, error) {
+	var conn net.Conn
+	var err error
+	if s.resolver != nil {
+		conn, err = s.resolver.DialContext(ctx, dialer, "tcp", destAddr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", destAddr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := s.config.TCP.Apply(conn); err != nil {
+		s.log.Debug().Err(err).Str("dest_addr", destAddr).Msg("Failed to set destination socket options")
+	}
+	return conn, nil
+}
+
+// newResolver creates the destination resolver, exporting lookups when
+// metrics are enabled. It returns nil when the system resolver is used.
+func (s *Server) newResolver() *resolver.Resolver {
+	if s.config.DNS == nil {
+		return nil
+	}
+	r := resolver.New(s.config.DNS)
+	if s.config.Metrics != nil {
+		r.SetOnLookup(func(result string, cached bool, duration time.Duration) {
+			if cached {
+				s.config.Metrics.RecordDNSCacheHit()
+			} else {
+				s.config.Metrics.RecordDNSResolve(result, duration)
+			}
+		})
+	}
+	return r
+}
+
+// retryableDialError reports whether another dial might succeed.
+func retryableDialError(err error) bool {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+		return false
+	}
+	return !errors.Is(err, context.Canceled) && !errors.Is(err, errBlockedByPolicy)
+}
+
+// dialErrorCode classifies a dial error for the client.
+func dialErrorCode(err error) protocol.StreamErrorCode {
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	switch {
+	case errors.Is(err, errBlockedByPolicy):
+		return protocol.StreamErrorBlocked
+	case errors.As(err, &dnsErr) && dnsErr.IsNotFound:
+		return protocol.StreamErrorHostNotFound
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return protocol.StreamErrorRefused
+	case errors.Is(err, syscall.ENETUNREACH), errors.Is(err, syscall.EHOSTUNREACH):
+		return protocol.StreamErrorUnreachable
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return protocol.StreamErrorTimeout
+	default:
+		return protocol.StreamErrorConnectFailed
+	}
+}
+
+// sendStreamError tells the client why a stream failed: with the stream's
+// HANDSHAKE_ACK if it negotiated CapOpenAck, else with a ControlStreamError.
+func (s *Server) sendStreamError(sessionID uuid.UUID, streamErr protocol.StreamError) {
+	var err error
+	if s.openAckEnabled(sessionID) {
+		err = s.sendOpenResult(sessionID, streamErr.StreamID, protocol.OpenResult{Code: streamErr.Code, Attempts: streamErr.Attempts})
+	} else {
+		payload := append([]byte{byte(protocol.ControlStreamError)}, streamErr.Marshal()...)
+		err = s.sendDownstreamPacket(sessionID, 0, protocol.FlagControl, payload)
+	}
+	if err != nil {
+		s.log.Debug().Err(err).
+			Uint32("stream_id", streamErr.StreamID).
+			Msg("Failed to send stream error")
+	}
+}
+
+// dialDestination connects a registered stream to its destination, then
+// forwards the destination's responses downstream. If the dial fails or the
+// destination resolves to an address blocked by the policy the client is sent
+// a stream error and a FIN; if the stream is closed before the dial completes
+// it is only sent a FIN.
+func (s *Server) dialDestination(ctx context.Context, sess *session.Session, streamID uint32, entry *natEntry, destHost string, destPort uint16) {
+	defer s.wg.Done()
+	sessionID := sess.ID
+
+	fail := func(reason string, fin protocol.Fin) {
+		s.closeNatEntry(sessionID, streamID, reason, fin)
+		s.sendFin(sessionID, streamID, fin)
+	}
+
+	if handler := s.streamHandler(entry.destAddr); handler != nil {
+		stream := StreamInfo{SessionID: sessionID, StreamID: streamID, DestAddr: entry.destAddr, Identity: entry.identity}
+		s.connectStream(ctx, sess, streamID, entry, pipeHandler(handler, stream))
+		return
+	}
+
+	if s.config.Diagnostics {
+		if service := diag.Lookup(destHost, destPort); service != "" {
+			s.connectStream(ctx, sess, streamID, entry, diag.Pipe(service))
+			return
+		}
+	}
+
+	if !s.allowDial(entry.destAddr) {
+		s.log.Debug().
+			Str("dest_addr", entry.destAddr).
+			Uint32("stream_id", streamID).
+			Msg("Destination circuit open, not dialing")
+		s.recordError("circuit_open")
+		s.sendStreamError(sessionID, protocol.StreamError{StreamID: streamID, Code: protocol.StreamErrorCircuitOpen})
+		fail(streamCloseCircuitOpen, protocol.Fin{Reason: protocol.CloseDialFailed, Message: "circuit open"})
+		return
+	}
+
+	policy := s.dialRetryPolicy(destHost, destPort)
+	conn, attempts, err := s.dialWithRetry(ctx, entry.destAddr, destPort, policy)
+	if errors.Is(err, errBlockedByPolicy) {
+		// The name resolved to a blocked address, which is not a failure
+		// of the destination for its circuit breaker
+		s.recordDialResult(entry.destAddr, nil)
+		existed := s.closeNatEntry(sessionID, streamID, streamCloseBlocked, protocol.Fin{Reason: protocol.ClosePolicy})
+		s.rejectStream(sessionID, streamID, destHost, destPort, existed)
+		return
+	}
+	s.recordDialResult(entry.destAddr, err)
+	if err != nil {
+		s.log.Error().Err(err).
+			Str("dest_addr", entry.destAddr).
+			Int("attempts", attempts).
+			Msg("Failed to connect to destination")
+		s.recordError("dial")
+		if attempts > 0 {
+			s.sendStreamError(sessionID, protocol.StreamError{
+				StreamID: streamID,
+				Code:     dialErrorCode(err),
+				Attempts: uint16(attempts),
+			})
+		}
+		fail(streamCloseDialFailed, protocol.Fin{Reason: protocol.CloseDialFailed, Message: err.Error()})
+		return
+	}
+
+	s.connectStream(ctx, sess, streamID, entry, conn)
+}
+
+// connectStream makes conn the destination of a registered stream, flushing
+// data queued while it was being dialed, then forwards the destination's
+// responses downstream.
+func (s *Server) connectStream(ctx context.Context, sess *session.Session, streamID uint32, entry *natEntry, conn net.Conn) {
+	sessionID := sess.ID
+
+	written, err := entry.establish(conn)
+	if err != nil {
+		conn.Close()
+		if !errors.Is(err, net.ErrClosed) {
+			s.log.Error().Err(err).
+				Uint32("stream_id", streamID).
+				Msg("Error writing to destination")
+			s.recordError("destination_write")
+		}
+		fin := protocol.Fin{Reason: protocol.CloseWriteError, Message: err.Error()}
+		s.closeNatEntry(sessionID, streamID, streamCloseDestinationError, fin)
+		s.sendFin(sessionID, streamID, fin)
+		return
+	}
+	if written > 0 {
+		atomic.AddInt64(&entry.bytesUp, int64(written))
+		s.recordGuestTraffic(sessionID, written)
+		if !s.recordClientTraffic(sessionID, streamID, entry, "upstream", written) {
+			return
+		}
+	}
+
+	s.log.Debug().
+		Str("session_id", sessionID.String()).
+		Uint32("stream_id", streamID).
+		Str("dest_addr", entry.destAddr).
+		Msg("Stream opened")
+	s.confirmOpen(sessionID, streamID)
+
+	// Mark stream as active
+	stream := sess.GetStream(streamID)
+	stream.SetState(session.StateActive)
+
+	s.forwardDestToDownstream(ctx, sessionID, streamID, entry)
+}
@@ -0,0 +1,157 @@
+package server
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sahmadiut/half-tunnel/internal/circuitbreaker"
+	"github.com/sahmadiut/half-tunnel/internal/metrics"
+	"github.com/sahmadiut/half-tunnel/internal/protocol"
+	"github.com/sahmadiut/half-tunnel/internal/socks5"
+)
+
+// connectPayload encodes addr as an IPv4 connect payload.
+func connectPayload(t *testing.T, addr net.Addr) []byte {
+	t.Helper()
+	tcpAddr := addr.(*net.TCPAddr)
+	payload := []byte{socks5.AddrTypeIPv4}
+	payload = append(payload, tcpAddr.IP.To4()...)
+	return binary.BigEndian.AppendUint16(payload, uint16(tcpAddr.Port))
+}
+
+func TestDialDoesNotBlockPacketHandling(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	defer ln.Close()
+
+	config := DefaultConfig()
+	config.MaxConcurrentDials = 1
+	s := New(config, nil)
+	defer s.sessionStore.Close()
+
+	// Occupy the only dial slot so the stream's dial has to wait
+	s.dialSlots <- struct{}{}
+
+	sessionID := uuid.New()
+	open, _ := protocol.NewPacket(sessionID, 1, protocol.FlagHandshake|protocol.FlagData, connectPayload(t, ln.Addr()))
+	first, _ := protocol.NewPacket(sessionID, 1, protocol.FlagData, []byte("hello "))
+	second, _ := protocol.NewPacket(sessionID, 1, protocol.FlagData, []byte("world"))
+
+	handled := make(chan struct{})
+	go func() {
+		ctx := context.Background()
+		s.handleUpstreamPacket(ctx, open)
+		s.handleUpstreamPacket(ctx, first)
+		s.handleUpstreamPacket(ctx, second)
+		close(handled)
+	}()
+
+	select {
+	case <-handled:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected packets to be handled while the dial is pending")
+	}
+
+	s.natTableMu.RLock()
+	entry := s.natTable[natKey{SessionID: sessionID, StreamID: 1}]
+	s.natTableMu.RUnlock()
+	if entry == nil {
+		t.Fatal("Expected stream to be registered before the dial completes")
+	}
+	if entry.destConn() != nil {
+		t.Error("Expected no destination connection while the dial slot is taken")
+	}
+
+	s.releaseDialSlot()
+
+	_ = ln.(*net.TCPListener).SetDeadline(time.Now().Add(2 * time.Second))
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatalf("Accept failed: %v", err)
+	}
+	defer conn.Close()
+
+	buf := make([]byte, len("hello world"))
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if string(buf) != "hello world" {
+		t.Errorf("Expected queued data in order, got %q", buf)
+	}
+
+	s.closeNatEntry(sessionID, 1, streamCloseClient, protocol.Fin{Reason: protocol.CloseEOF})
+	close(s.shutdown)
+	s.wg.Wait()
+}
+
+func TestNatEntryPendingLimit(t *testing.T) {
+	entry := &natEntry{}
+
+	if queued, err := entry.queue(make([]byte, maxPendingBytes)); !queued || err != nil {
+		t.Fatalf("Expected data to be queued, got queued=%v err=%v", queued, err)
+	}
+	if _, err := entry.queue([]byte("x")); err != errPendingLimit {
+		t.Errorf("Expected errPendingLimit, got %v", err)
+	}
+
+	entry.close()
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	if _, err := entry.establish(server); err == nil {
+		t.Error("Expected establish to fail on a closed entry")
+	}
+}
+
+func TestDestinationBreakerFailsFast(t *testing.T) {
+	// Reserve a port with nothing listening on it
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	addr := ln.Addr()
+	ln.Close()
+
+	config := DefaultConfig()
+	config.Metrics = metrics.NewCollector()
+	config.DestinationBreaker = &circuitbreaker.Config{
+		MaxFailures:         2,
+		Timeout:             time.Minute,
+		MaxHalfOpenRequests: 1,
+	}
+	s := New(config, nil)
+	defer s.sessionStore.Close()
+
+	sessionID := uuid.New()
+	for streamID := uint32(1); streamID <= 3; streamID++ {
+		open, _ := protocol.NewPacket(sessionID, streamID, protocol.FlagHandshake|protocol.FlagData, connectPayload(t, addr))
+		s.handleUpstreamPacket(context.Background(), open)
+		s.wg.Wait()
+	}
+
+	if n := s.GetNatEntryCount(); n != 0 {
+		t.Errorf("Expected failed streams to be closed, got %d", n)
+	}
+	if got := testutil.ToFloat64(config.Metrics.Errors.WithLabelValues("dial")); got != 2 {
+		t.Errorf("Expected 2 dial errors, got %v", got)
+	}
+	if got := testutil.ToFloat64(config.Metrics.Errors.WithLabelValues("circuit_open")); got != 1 {
+		t.Errorf("Expected 1 stream rejected by the open circuit, got %v", got)
+	}
+	name := breakerMetricName("127.0.0.1")
+	if got := testutil.ToFloat64(config.Metrics.CircuitBreakerState.WithLabelValues(name)); got != float64(circuitbreaker.StateOpen) {
+		t.Errorf("Expected breaker state open, got %v", got)
+	}
+	if got := testutil.ToFloat64(config.Metrics.CircuitBreakerTrips.WithLabelValues(name)); got != 1 {
+		t.Errorf("Expected 1 breaker trip, got %v", got)
+	}
+}
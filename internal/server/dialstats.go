@@ -0,0 +1,116 @@
+package server
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// dialWindowSize is the number of recent dials used for percentiles.
+	dialWindowSize = 200
+	// dialMinSamples is the number of dials needed before p95 is trusted.
+	dialMinSamples = 20
+	// slowDialWarnInterval limits slow-dial warnings per port class.
+	slowDialWarnInterval = time.Minute
+)
+
+// dialPortClass groups destination ports for dial statistics.
+func dialPortClass(port uint16) string {
+	switch port {
+	case 80:
+		return "80"
+	case 443:
+		return "443"
+	default:
+		return "other"
+	}
+}
+
+// dialWindow is a ring buffer of recent dial durations for one port class.
+type dialWindow struct {
+	durations []time.Duration
+	next      int
+	lastWarn  time.Time
+}
+
+// dialStats tracks destination dial durations and detects slow egress.
+type dialStats struct {
+	threshold time.Duration
+	windows   map[string]*dialWindow
+	mu        sync.Mutex
+}
+
+// newDialStats creates dial statistics that flag a port class as slow when
+// its p95 dial duration exceeds threshold. A zero threshold disables warnings.
+func newDialStats(threshold time.Duration) *dialStats {
+	return &dialStats{
+		threshold: threshold,
+		windows:   make(map[string]*dialWindow),
+	}
+}
+
+// record adds a dial duration and returns the current p95 for the class.
+// warn is true when p95 exceeds the threshold and no warning was issued for
+// this class within slowDialWarnInterval.
+func (d *dialStats) record(class string, duration time.Duration, now time.Time) (p95 time.Duration, warn bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	w, ok := d.windows[class]
+	if !ok {
+		w = &dialWindow{durations: make([]time.Duration, 0, dialWindowSize)}
+		d.windows[class] = w
+	}
+
+	if len(w.durations) < dialWindowSize {
+		w.durations = append(w.durations, duration)
+	} else {
+		w.durations[w.next] = duration
+		w.next = (w.next + 1) % dialWindowSize
+	}
+
+	p95 = percentile(w.durations, 0.95)
+
+	if d.threshold <= 0 || len(w.durations) < dialMinSamples || p95 <= d.threshold {
+		return p95, false
+	}
+	if now.Sub(w.lastWarn) < slowDialWarnInterval {
+		return p95, false
+	}
+	w.lastWarn = now
+	return p95, true
+}
+
+// percentile returns the q-th percentile (0..1) of the given durations.
+func percentile(durations []time.Duration, q float64) time.Duration {
+	if len(durations) == 0 {
+		return 0
+	}
+
+	sorted := make([]time.Duration, len(durations))
+	copy(sorted, durations)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	idx := int(q*float64(len(sorted)-1) + 0.5)
+	return sorted[idx]
+}
+
+// recordDial records a destination dial in the metrics and dial statistics,
+// warning when the p95 dial duration for the port class becomes slow.
+func (s *Server) recordDial(port uint16, success bool, duration time.Duration) {
+	class := dialPortClass(port)
+
+	if s.config.Metrics != nil {
+		s.config.Metrics.RecordDialDuration(class, success, duration)
+	}
+
+	p95, warn := s.dialStats.record(class, duration, time.Now())
+	if warn {
+		s.log.Warn().
+			Str("port_class", class).
+			Dur("p95", p95).
+			Dur("threshold", s.config.SlowDialThreshold).
+			Msg("Slow destination dials detected")
+	}
+}
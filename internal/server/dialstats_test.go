@@ -0,0 +1,94 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDialPortClass(t *testing.T) {
+	tests := []struct {
+		port uint16
+		want string
+	}{
+		{80, "80"},
+		{443, "443"},
+		{8080, "other"},
+		{22, "other"},
+	}
+
+	for _, tt := range tests {
+		if got := dialPortClass(tt.port); got != tt.want {
+			t.Errorf("dialPortClass(%d) = %s, want %s", tt.port, got, tt.want)
+		}
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	var durations []time.Duration
+	for i := 100; i >= 1; i-- {
+		durations = append(durations, time.Duration(i)*time.Millisecond)
+	}
+
+	if got := percentile(durations, 0.95); got != 95*time.Millisecond {
+		t.Errorf("Expected p95 of 95ms, got %v", got)
+	}
+	if got := percentile(nil, 0.95); got != 0 {
+		t.Errorf("Expected 0 for empty input, got %v", got)
+	}
+}
+
+func TestDialStatsSlowWarning(t *testing.T) {
+	stats := newDialStats(time.Second)
+	now := time.Now()
+
+	// Fast dials never warn
+	for i := 0; i < dialMinSamples; i++ {
+		if _, warn := stats.record("443", 10*time.Millisecond, now); warn {
+			t.Fatal("Expected no warning for fast dials")
+		}
+	}
+
+	// Slow dials push p95 over the threshold once enough of them arrive
+	warned := false
+	for i := 0; i < dialMinSamples; i++ {
+		if _, warn := stats.record("443", 3*time.Second, now); warn {
+			if warned {
+				t.Fatal("Expected warnings to be rate limited")
+			}
+			warned = true
+		}
+	}
+	if !warned {
+		t.Fatal("Expected a slow dial warning")
+	}
+
+	// Other port classes are tracked independently
+	if _, warn := stats.record("80", 3*time.Second, now); warn {
+		t.Error("Expected no warning before enough samples for a class")
+	}
+
+	// The warning repeats once the rate limit interval has passed
+	if _, warn := stats.record("443", 3*time.Second, now.Add(slowDialWarnInterval)); !warn {
+		t.Error("Expected warning after the rate limit interval")
+	}
+}
+
+func TestDialStatsWindowWraps(t *testing.T) {
+	stats := newDialStats(0)
+	now := time.Now()
+
+	for i := 0; i < dialWindowSize; i++ {
+		stats.record("other", 5*time.Second, now)
+	}
+	var p95 time.Duration
+	for i := 0; i < dialWindowSize; i++ {
+		p95, _ = stats.record("other", time.Millisecond, now)
+	}
+
+	if p95 != time.Millisecond {
+		t.Errorf("Expected old samples to be evicted, got p95 %v", p95)
+	}
+	if len(stats.windows["other"].durations) != dialWindowSize {
+		t.Errorf("Expected window of %d samples, got %d", dialWindowSize, len(stats.windows["other"].durations))
+	}
+}
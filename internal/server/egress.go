@@ -0,0 +1,82 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+)
+
+// newDialer returns a dialer for destination connections that applies the
+// egress settings and the destination policy.
+func (s *Server) newDialer() *net.Dialer {
+	dialer := &net.Dialer{Timeout: s.config.DialTimeout}
+	if s.config.BindAddress != nil {
+		dialer.LocalAddr = &net.TCPAddr{IP: s.config.BindAddress}
+	}
+	if s.config.Interface != "" || s.config.Mark != 0 || s.config.Policy != nil {
+		dialer.Control = s.controlEgressSocket
+	}
+	return dialer
+}
+
+// controlEgressSocket checks a destination connection's address against the
+// policy and sets its socket options before it connects.
+func (s *Server) controlEgressSocket(_, address string, c syscall.RawConn) error {
+	if err := s.checkDialAddr(address); err != nil {
+		return err
+	}
+	var sockErr error
+	err := c.Control(func(fd uintptr) {
+		if s.config.Interface != "" {
+			if err := bindToDevice(fd, s.config.Interface); err != nil {
+				sockErr = fmt.Errorf("failed to bind to interface %s: %w", s.config.Interface, err)
+				return
+			}
+		}
+		if s.config.Mark != 0 {
+			if err := setMark(fd, s.config.Mark); err != nil {
+				sockErr = fmt.Errorf("failed to set socket mark %#x: %w", s.config.Mark, err)
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
+
+// checkEgress verifies that the egress settings can be applied on this host.
+func (s *Server) checkEgress() error {
+	if s.config.Interface != "" {
+		if !bindToDeviceSupported {
+			return fmt.Errorf("binding to an interface is not supported on this platform")
+		}
+		if _, err := net.InterfaceByName(s.config.Interface); err != nil {
+			return fmt.Errorf("interface %s: %w", s.config.Interface, err)
+		}
+	}
+	if s.config.Mark != 0 && !markSupported {
+		return fmt.Errorf("socket marks are not supported on this platform")
+	}
+	if ip := s.config.BindAddress; ip != nil && !ip.IsUnspecified() {
+		if !hostHasAddress(ip) {
+			return fmt.Errorf("bind address %s is not assigned to this host", ip)
+		}
+	}
+	return nil
+}
+
+// hostHasAddress reports whether ip is assigned to a local interface.
+func hostHasAddress(ip net.IP) bool {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		// Let the dial report the problem
+		return true
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,18 @@
+package server
+
+import "syscall"
+
+const (
+	bindToDeviceSupported = true
+	markSupported         = true
+)
+
+// bindToDevice restricts a socket to a network interface (SO_BINDTODEVICE).
+func bindToDevice(fd uintptr, iface string) error {
+	return syscall.SetsockoptString(int(fd), syscall.SOL_SOCKET, syscall.SO_BINDTODEVICE, iface)
+}
+
+// setMark sets the packet mark (fwmark) of a socket (SO_MARK).
+func setMark(fd uintptr, mark uint32) error {
+	return syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_MARK, int(mark))
+}
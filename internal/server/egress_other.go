@@ -0,0 +1,20 @@
+//go:build !linux
+
+package server
+
+import "errors"
+
+const (
+	bindToDeviceSupported = false
+	markSupported         = false
+)
+
+var errNotSupported = errors.New("not supported on this platform")
+
+func bindToDevice(_ uintptr, _ string) error {
+	return errNotSupported
+}
+
+func setMark(_ uintptr, _ uint32) error {
+	return errNotSupported
+}
@@ -0,0 +1,68 @@
+package server
+
+import (
+	"context"
+	"net"
+	"testing"
+)
+
+func TestEgressBindAddress(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err == nil {
+			conn.Close()
+		}
+	}()
+
+	config := DefaultConfig()
+	config.BindAddress = net.ParseIP("127.0.0.1")
+	s := New(config, nil)
+	if err := s.checkEgress(); err != nil {
+		t.Fatalf("checkEgress failed: %v", err)
+	}
+
+	conn, _, err := s.dialWithRetry(context.Background(), ln.Addr().String(), 0, DefaultDialRetryPolicy())
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+	local := conn.LocalAddr().(*net.TCPAddr)
+	if !local.IP.Equal(config.BindAddress) {
+		t.Errorf("Expected source address %s, got %s", config.BindAddress, local.IP)
+	}
+}
+
+func TestCheckEgress(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(*Config)
+	}{
+		{
+			name: "unassigned bind address",
+			modify: func(c *Config) {
+				c.BindAddress = net.ParseIP("192.0.2.123")
+			},
+		},
+		{
+			name: "missing interface",
+			modify: func(c *Config) {
+				c.Interface = "ht-missing0"
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := DefaultConfig()
+			tt.modify(config)
+			if err := New(config, nil).checkEgress(); err == nil {
+				t.Error("Expected an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,20 @@
+package server
+
+import (
+	"github.com/google/uuid"
+	"github.com/sahmadiut/half-tunnel/internal/protocol"
+)
+
+// sendFinAck tells a client that negotiated CapFinAck that the server forgot
+// a stream, so the client may reuse its ID. It is sent once per stream: when
+// its NAT entry is closed, or when the stream is refused before it had one.
+func (s *Server) sendFinAck(sessionID uuid.UUID, streamID uint32) {
+	sess, ok := s.sessionStore.Get(sessionID)
+	if !ok {
+		return
+	}
+	if _, caps := sess.Protocol(); !protocol.Capability(caps).Has(protocol.CapFinAck) {
+		return
+	}
+	_ = s.sendDownstreamPacket(sessionID, streamID, protocol.FlagFin|protocol.FlagAck, nil)
+}
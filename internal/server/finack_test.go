@@ -0,0 +1,74 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sahmadiut/half-tunnel/internal/protocol"
+)
+
+func TestFinAckSentOnceForNegotiatedSessions(t *testing.T) {
+	s := New(DefaultConfig(), nil)
+	defer s.sessionStore.Close()
+
+	for _, tt := range []struct {
+		name string
+		caps protocol.Capability
+		want int64
+	}{
+		{"fin_ack", protocol.CapFinAck, 1},
+		{"legacy", 0, 0},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			sessionID := uuid.New()
+			sess, err := s.sessionStore.Admit(sessionID)
+			if err != nil {
+				t.Fatalf("Failed to admit session: %v", err)
+			}
+			sess.SetProtocol(protocol.Version, uint32(tt.caps))
+			s.natTable[natKey{SessionID: sessionID, StreamID: 1}] = &natEntry{created: time.Now()}
+
+			// Without a downstream connection, every packet sent is a
+			// failed write
+			fin := protocol.Fin{Reason: protocol.CloseEOF}
+			if !s.closeNatEntry(sessionID, 1, streamCloseClient, fin) {
+				t.Fatal("Expected the NAT entry to exist")
+			}
+			if s.closeNatEntry(sessionID, 1, streamCloseClient, fin) {
+				t.Error("Expected the second close to find no NAT entry")
+			}
+			if failures, _ := s.sessionFlowStats(sessionID); failures != tt.want {
+				t.Errorf("Expected %d FIN-ACK, got %d packets", tt.want, failures)
+			}
+		})
+	}
+}
+
+func TestFinAckSentOnceForBlockedStreams(t *testing.T) {
+	s := New(DefaultConfig(), nil)
+	defer s.sessionStore.Close()
+
+	// A stream blocked when dialing has a NAT entry, whose close already
+	// acknowledges it; one blocked on open has none. Both send one FIN-ACK.
+	failures := make([]int64, 2)
+	for i, existed := range []bool{true, false} {
+		sessionID := uuid.New()
+		sess, err := s.sessionStore.Admit(sessionID)
+		if err != nil {
+			t.Fatalf("Failed to admit session: %v", err)
+		}
+		sess.SetProtocol(protocol.Version, uint32(protocol.CapFinAck))
+		if existed {
+			s.natTable[natKey{SessionID: sessionID, StreamID: 1}] = &natEntry{created: time.Now()}
+			if !s.closeNatEntry(sessionID, 1, streamCloseBlocked, protocol.Fin{Reason: protocol.ClosePolicy}) {
+				t.Fatal("Expected the NAT entry to exist")
+			}
+		}
+		s.rejectStream(sessionID, 1, "10.0.0.1", 80, existed)
+		failures[i], _ = s.sessionFlowStats(sessionID)
+	}
+	if failures[0] != failures[1] {
+		t.Errorf("Expected the same packets for both blocked streams, got %d and %d", failures[0], failures[1])
+	}
+}
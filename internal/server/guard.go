@@ -0,0 +1,204 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sahmadiut/half-tunnel/internal/protocol"
+)
+
+// Resources watched by the resource guard, used in metrics and errors.
+const (
+	resourceOpenFiles  = "open_files"
+	resourceNatEntries = "nat_entries"
+	resourceGoroutines = "goroutines"
+)
+
+// GuardConfig holds the ceilings of the resource guard, which refuses new
+// streams while the server is close to one of them.
+type GuardConfig struct {
+	// CheckInterval is how often open files and goroutines are sampled
+	CheckInterval time.Duration
+	// MaxOpenFiles is the open file descriptor ceiling (0 uses the
+	// process's descriptor limit where the platform reports it)
+	MaxOpenFiles int
+	// MaxNatEntries is the ceiling on open streams (0 means no ceiling)
+	MaxNatEntries int
+	// MaxGoroutines is the goroutine ceiling (0 means no ceiling)
+	MaxGoroutines int
+	// ShedAt is the fraction of a ceiling from which new streams are
+	// refused
+	ShedAt float64
+}
+
+// DefaultGuardConfig returns the default resource guard settings.
+func DefaultGuardConfig() *GuardConfig {
+	return &GuardConfig{
+		CheckInterval: 5 * time.Second,
+		ShedAt:        0.9,
+	}
+}
+
+// OverloadError is returned when a new stream would take the server too
+// close to a resource ceiling.
+type OverloadError struct {
+	Resource string
+	Used     int
+	Limit    int
+}
+
+func (e *OverloadError) Error() string {
+	return fmt.Sprintf("%s near limit: %d of %d", e.Resource, e.Used, e.Limit)
+}
+
+// resourceGuard holds the ceilings in effect and the latest samples.
+type resourceGuard struct {
+	config    *GuardConfig
+	fileLimit int
+	// Latest samples, updated atomically
+	openFiles  int64
+	goroutines int64
+	// overloaded holds the resources over the shed threshold at the last
+	// check; only used by the check loop
+	overloaded map[string]bool
+}
+
+// newResourceGuard creates the resource guard, or returns nil if it is
+// disabled.
+func (s *Server) newResourceGuard() *resourceGuard {
+	if s.config.Guard == nil {
+		return nil
+	}
+	g := &resourceGuard{
+		config:     s.config.Guard,
+		fileLimit:  s.config.Guard.MaxOpenFiles,
+		overloaded: make(map[string]bool),
+	}
+	if g.fileLimit == 0 {
+		limit, err := fileLimit()
+		if err != nil {
+			s.log.Debug().Err(err).Msg("File descriptor limit unknown, not guarding open files")
+		}
+		g.fileLimit = limit
+	}
+	return g
+}
+
+// checkResources returns an *OverloadError if a new stream would exceed the
+// shed threshold of a resource ceiling.
+func (s *Server) checkResources() error {
+	g := s.guard
+	if g == nil {
+		return nil
+	}
+	if err := g.check(resourceOpenFiles, int(atomic.LoadInt64(&g.openFiles)), g.fileLimit); err != nil {
+		return err
+	}
+	if err := g.check(resourceGoroutines, int(atomic.LoadInt64(&g.goroutines)), g.config.MaxGoroutines); err != nil {
+		return err
+	}
+	if g.config.MaxNatEntries > 0 {
+		return g.check(resourceNatEntries, s.GetNatEntryCount(), g.config.MaxNatEntries)
+	}
+	return nil
+}
+
+// check returns an *OverloadError if used is at or above the shed threshold
+// of limit. A limit of 0 is not enforced.
+func (g *resourceGuard) check(resource string, used, limit int) error {
+	if limit <= 0 || float64(used) < g.config.ShedAt*float64(limit) {
+		return nil
+	}
+	return &OverloadError{Resource: resource, Used: used, Limit: limit}
+}
+
+// shedStream refuses a new stream because the server is overloaded,
+// telling the client why.
+func (s *Server) shedStream(sessionID uuid.UUID, streamID uint32, err error) {
+	s.log.Debug().Err(err).
+		Str("session_id", sessionID.String()).
+		Uint32("stream_id", streamID).
+		Msg("Server overloaded, refusing stream")
+	s.recordError("overloaded")
+	var overload *OverloadError
+	if s.config.Metrics != nil && errors.As(err, &overload) {
+		s.config.Metrics.RecordStreamShed(overload.Resource)
+	}
+	s.sendStreamError(sessionID, protocol.StreamError{StreamID: streamID, Code: protocol.StreamErrorOverloaded})
+	s.sendFin(sessionID, streamID, protocol.Fin{Reason: protocol.CloseOverloaded})
+	s.sendFinAck(sessionID, streamID)
+}
+
+// guardLoop periodically samples the guarded resources.
+func (s *Server) guardLoop(ctx context.Context) {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(s.guard.config.CheckInterval)
+	defer ticker.Stop()
+
+	s.sampleResources()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.shutdown:
+			return
+		case <-ticker.C:
+			s.sampleResources()
+		}
+	}
+}
+
+// sampleResources samples open files and goroutines, exports how close each
+// resource is to its ceiling and logs when one crosses the shed threshold.
+func (s *Server) sampleResources() {
+	g := s.guard
+	openFiles, err := countOpenFiles()
+	if err != nil {
+		openFiles = 0
+	}
+	goroutines := runtime.NumGoroutine()
+	atomic.StoreInt64(&g.openFiles, int64(openFiles))
+	atomic.StoreInt64(&g.goroutines, int64(goroutines))
+
+	usage := []struct {
+		resource    string
+		used, limit int
+	}{
+		{resourceOpenFiles, openFiles, g.fileLimit},
+		{resourceNatEntries, s.GetNatEntryCount(), g.config.MaxNatEntries},
+		{resourceGoroutines, goroutines, g.config.MaxGoroutines},
+	}
+	for _, u := range usage {
+		if u.limit <= 0 {
+			continue
+		}
+		if s.config.Metrics != nil {
+			s.config.Metrics.SetResourceSaturation(u.resource, float64(u.used)/float64(u.limit))
+		}
+
+		overloaded := g.check(u.resource, u.used, u.limit) != nil
+		if overloaded == g.overloaded[u.resource] {
+			continue
+		}
+		g.overloaded[u.resource] = overloaded
+		if overloaded {
+			s.log.Warn().
+				Str("resource", u.resource).
+				Int("used", u.used).
+				Int("limit", u.limit).
+				Msg("Resource near its limit, refusing new streams")
+		} else {
+			s.log.Info().
+				Str("resource", u.resource).
+				Int("used", u.used).
+				Int("limit", u.limit).
+				Msg("Resource back below its limit, accepting new streams")
+		}
+	}
+}
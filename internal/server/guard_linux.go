@@ -0,0 +1,25 @@
+package server
+
+import (
+	"os"
+	"syscall"
+)
+
+// countOpenFiles returns the number of file descriptors the process has
+// open.
+func countOpenFiles() (int, error) {
+	entries, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		return 0, err
+	}
+	return len(entries), nil
+}
+
+// fileLimit returns the process's file descriptor limit (RLIMIT_NOFILE).
+func fileLimit() (int, error) {
+	var limit syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &limit); err != nil {
+		return 0, err
+	}
+	return int(limit.Cur), nil
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+package server
+
+func countOpenFiles() (int, error) {
+	return 0, errNotSupported
+}
+
+func fileLimit() (int, error) {
+	return 0, errNotSupported
+}
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sahmadiut/half-tunnel/internal/metrics"
+	"github.com/sahmadiut/half-tunnel/internal/protocol"
+)
+
+func TestResourceGuardShedsStreams(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	defer ln.Close()
+
+	config := DefaultConfig()
+	config.Metrics = metrics.NewCollector()
+	config.Guard = &GuardConfig{
+		CheckInterval: time.Second,
+		MaxOpenFiles:  1 << 20,
+		MaxNatEntries: 2,
+		ShedAt:        0.5,
+	}
+	s := New(config, nil)
+	defer s.sessionStore.Close()
+
+	sessionID := uuid.New()
+	s.sessionStore.GetOrCreate(sessionID)
+	if err := s.checkResources(); err != nil {
+		t.Fatalf("Expected no overload without streams, got %v", err)
+	}
+
+	// One open stream reaches half of the NAT entry ceiling
+	s.natTable[natKey{SessionID: sessionID, StreamID: 1}] = &natEntry{created: time.Now()}
+	var overload *OverloadError
+	if err := s.checkResources(); !errors.As(err, &overload) || overload.Resource != resourceNatEntries {
+		t.Fatalf("Expected a nat_entries overload, got %v", err)
+	}
+
+	open, _ := protocol.NewPacket(sessionID, 2, protocol.FlagHandshake|protocol.FlagData, connectPayload(t, ln.Addr()))
+	s.handleUpstreamPacket(context.Background(), open)
+	if n := s.GetNatEntryCount(); n != 1 {
+		t.Errorf("Expected the new stream refused, got %d entries", n)
+	}
+	if got := testutil.ToFloat64(config.Metrics.StreamsShed.WithLabelValues(resourceNatEntries)); got != 1 {
+		t.Errorf("Expected 1 stream shed, got %v", got)
+	}
+
+	s.sampleResources()
+	if got := testutil.ToFloat64(config.Metrics.ResourceSaturation.WithLabelValues(resourceNatEntries)); got != 0.5 {
+		t.Errorf("Expected nat_entries saturation 0.5, got %v", got)
+	}
+	if !s.guard.overloaded[resourceNatEntries] || s.guard.overloaded[resourceOpenFiles] {
+		t.Errorf("Expected only nat_entries overloaded, got %v", s.guard.overloaded)
+	}
+}
+
+func TestResourceGuardDisabled(t *testing.T) {
+	config := DefaultConfig()
+	config.Guard = nil
+	s := New(config, nil)
+	defer s.sessionStore.Close()
+
+	if s.guard != nil {
+		t.Fatal("Expected no resource guard")
+	}
+	if err := s.checkResources(); err != nil {
+		t.Errorf("Expected no overload with the guard disabled, got %v", err)
+	}
+}
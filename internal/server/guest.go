@@ -0,0 +1,253 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sahmadiut/half-tunnel/internal/clientauth"
+	"github.com/sahmadiut/half-tunnel/internal/guest"
+	"github.com/sahmadiut/half-tunnel/internal/protocol"
+)
+
+// guestCheckInterval is how often guest sessions are checked for expiry.
+const guestCheckInterval = time.Second
+
+// Guest admission errors
+var (
+	errGuestTokenRequired = errors.New("guest token required")
+	errGuestSessionClosed = errors.New("guest session closed")
+	errGuestTrafficUsed   = errors.New("guest token traffic cap reached")
+)
+
+// GuestConfig holds settings for time-limited guest sessions.
+type GuestConfig struct {
+	// Enabled turns on guest token verification
+	Enabled bool
+	// Secret is the key guest tokens are signed with
+	Secret string
+	// Required rejects sessions that do not present a valid guest token
+	Required bool
+	// WarnBefore is how long before the TTL ends the client is warned
+	WarnBefore time.Duration
+	// WarnTrafficRatio is the fraction of the traffic cap at which the client is warned
+	WarnTrafficRatio float64
+}
+
+// DefaultGuestConfig returns default guest session configuration.
+func DefaultGuestConfig() GuestConfig {
+	return GuestConfig{
+		Enabled:          false,
+		Required:         false,
+		WarnBefore:       5 * time.Minute,
+		WarnTrafficRatio: 0.9,
+	}
+}
+
+// guestUsage tracks traffic used by a guest token across its sessions.
+type guestUsage struct {
+	bytes     int64
+	expiresAt time.Time
+}
+
+// guestSession holds the limits of a session opened with a guest token.
+type guestSession struct {
+	token         *guest.Token
+	usage         *guestUsage
+	warnedTTL     bool
+	warnedTraffic bool
+	closed        bool
+}
+
+// admitSession checks whether a packet may be processed for its session.
+// Handshakes carrying a guest token register the session as a guest session.
+func (s *Server) admitSession(pkt *protocol.Packet) error {
+	if !s.config.Guest.Enabled {
+		return nil
+	}
+
+	s.guestMu.Lock()
+	defer s.guestMu.Unlock()
+
+	if gs, exists := s.guestSessions[pkt.SessionID]; exists {
+		if gs.closed {
+			return errGuestSessionClosed
+		}
+		return nil
+	}
+
+	var tokenStr string
+	if pkt.IsHandshake() && pkt.StreamID == 0 && !clientauth.IsToken(string(pkt.Payload)) {
+		tokenStr = string(pkt.Payload)
+	}
+	if tokenStr == "" {
+		if s.config.Guest.Required {
+			return errGuestTokenRequired
+		}
+		return nil
+	}
+
+	token, err := guest.Parse(s.config.Guest.Secret, tokenStr, time.Now())
+	if err != nil {
+		return err
+	}
+
+	usage, exists := s.guestUsage[token.ID]
+	if !exists {
+		usage = &guestUsage{expiresAt: token.ExpiresAt}
+		s.guestUsage[token.ID] = usage
+	}
+	if token.TrafficCap > 0 && atomic.LoadInt64(&usage.bytes) >= token.TrafficCap {
+		return errGuestTrafficUsed
+	}
+
+	s.guestSessions[pkt.SessionID] = &guestSession{
+		token: token,
+		usage: usage,
+	}
+
+	s.log.Info().
+		Str("session_id", pkt.SessionID.String()).
+		Str("guest_id", token.ID).
+		Time("expires_at", token.ExpiresAt).
+		Int64("traffic_cap", token.TrafficCap).
+		Msg("Guest session admitted")
+
+	return nil
+}
+
+// recordGuestTraffic adds transferred bytes to a guest session's token and
+// warns or closes the session when the traffic cap is approached or reached.
+func (s *Server) recordGuestTraffic(sessionID uuid.UUID, n int) {
+	if !s.config.Guest.Enabled || n <= 0 {
+		return
+	}
+
+	s.guestMu.Lock()
+	gs, exists := s.guestSessions[sessionID]
+	if !exists || gs.closed || gs.token.TrafficCap <= 0 {
+		s.guestMu.Unlock()
+		return
+	}
+
+	used := atomic.AddInt64(&gs.usage.bytes, int64(n))
+	remaining := gs.token.TrafficCap - used
+	if remaining <= 0 {
+		gs.closed = true
+		s.guestMu.Unlock()
+		s.closeGuestSession(sessionID, protocol.LimitTraffic)
+		return
+	}
+
+	warn := !gs.warnedTraffic && float64(used) >= float64(gs.token.TrafficCap)*s.config.Guest.WarnTrafficRatio
+	if warn {
+		gs.warnedTraffic = true
+	}
+	s.guestMu.Unlock()
+
+	if warn {
+		s.sendSessionLimit(sessionID, protocol.ControlSessionWarning, protocol.SessionLimit{
+			Reason:    protocol.LimitTraffic,
+			Remaining: uint64(remaining),
+		})
+	}
+}
+
+// guestExpiryLoop warns guest sessions about their TTL and closes expired ones.
+func (s *Server) guestExpiryLoop(ctx context.Context) {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(guestCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.shutdown:
+			return
+		case now := <-ticker.C:
+			s.checkGuestSessions(now)
+		}
+	}
+}
+
+// checkGuestSessions handles TTL warnings and expiry for all guest sessions.
+func (s *Server) checkGuestSessions(now time.Time) {
+	var warn, expire []uuid.UUID
+	remaining := make(map[uuid.UUID]time.Duration)
+
+	s.guestMu.Lock()
+	for id, gs := range s.guestSessions {
+		left := gs.token.ExpiresAt.Sub(now)
+		if left <= 0 {
+			if !gs.closed {
+				gs.closed = true
+				expire = append(expire, id)
+			} else if -left > s.config.SessionTimeout {
+				// Keep closed sessions around long enough to reject their
+				// remaining packets, then drop them
+				delete(s.guestSessions, id)
+			}
+			continue
+		}
+		if !gs.closed && !gs.warnedTTL && left <= s.config.Guest.WarnBefore {
+			gs.warnedTTL = true
+			warn = append(warn, id)
+			remaining[id] = left
+		}
+	}
+	for id, usage := range s.guestUsage {
+		if !now.Before(usage.expiresAt) {
+			delete(s.guestUsage, id)
+		}
+	}
+	s.guestMu.Unlock()
+
+	for _, id := range warn {
+		s.sendSessionLimit(id, protocol.ControlSessionWarning, protocol.SessionLimit{
+			Reason:    protocol.LimitTTL,
+			Remaining: uint64(remaining[id].Seconds()),
+		})
+	}
+	for _, id := range expire {
+		s.closeGuestSession(id, protocol.LimitTTL)
+	}
+}
+
+// closeGuestSession notifies the client that a guest limit was reached and
+// tears down the session's streams and downstream connection.
+func (s *Server) closeGuestSession(sessionID uuid.UUID, reason protocol.LimitReason) {
+	s.log.Info().
+		Str("session_id", sessionID.String()).
+		Str("reason", reason.String()).
+		Msg("Closing guest session")
+
+	s.sendSessionLimit(sessionID, protocol.ControlSessionExpired, protocol.SessionLimit{Reason: reason})
+	s.teardownSession(sessionID, closeReasonGuest)
+}
+
+// sendSessionLimit sends a session warning or expiry control packet downstream.
+func (s *Server) sendSessionLimit(sessionID uuid.UUID, ctrl protocol.ControlType, limit protocol.SessionLimit) {
+	if err := s.sendDownstreamPacket(sessionID, 0, protocol.FlagControl, append([]byte{byte(ctrl)}, limit.Marshal()...)); err != nil {
+		s.log.Debug().Err(err).
+			Str("session_id", sessionID.String()).
+			Msg("Failed to send session limit notice")
+	}
+}
+
+// GetGuestSessionCount returns the number of active guest sessions.
+func (s *Server) GetGuestSessionCount() int {
+	s.guestMu.RLock()
+	defer s.guestMu.RUnlock()
+
+	count := 0
+	for _, gs := range s.guestSessions {
+		if !gs.closed {
+			count++
+		}
+	}
+	return count
+}
@@ -0,0 +1,137 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sahmadiut/half-tunnel/internal/guest"
+	"github.com/sahmadiut/half-tunnel/internal/protocol"
+)
+
+func newGuestTestServer(required bool) *Server {
+	config := DefaultConfig()
+	config.Guest.Enabled = true
+	config.Guest.Secret = "test-secret"
+	config.Guest.Required = required
+	return New(config, nil)
+}
+
+func guestHandshake(t *testing.T, sessionID uuid.UUID, token string) *protocol.Packet {
+	t.Helper()
+	pkt, err := protocol.NewPacket(sessionID, 0, protocol.FlagHandshake, []byte(token))
+	if err != nil {
+		t.Fatalf("Failed to create handshake: %v", err)
+	}
+	return pkt
+}
+
+func TestAdmitSessionGuestToken(t *testing.T) {
+	s := newGuestTestServer(true)
+
+	token, _, err := guest.Issue("test-secret", time.Hour, 0)
+	if err != nil {
+		t.Fatalf("Failed to issue token: %v", err)
+	}
+
+	sessionID := uuid.New()
+	if err := s.admitSession(guestHandshake(t, sessionID, token)); err != nil {
+		t.Fatalf("Expected valid token to be admitted, got %v", err)
+	}
+	if s.GetGuestSessionCount() != 1 {
+		t.Errorf("Expected 1 guest session, got %d", s.GetGuestSessionCount())
+	}
+
+	// Subsequent packets of an admitted session pass without a token
+	dataPkt, _ := protocol.NewPacket(sessionID, 1, protocol.FlagData, []byte("x"))
+	if err := s.admitSession(dataPkt); err != nil {
+		t.Errorf("Expected admitted session packet to pass, got %v", err)
+	}
+
+	// Sessions without a token are rejected when tokens are required
+	if err := s.admitSession(guestHandshake(t, uuid.New(), "")); err != errGuestTokenRequired {
+		t.Errorf("Expected errGuestTokenRequired, got %v", err)
+	}
+
+	// Tokens signed with another secret are rejected
+	forged, _, _ := guest.Issue("other-secret", time.Hour, 0)
+	if err := s.admitSession(guestHandshake(t, uuid.New(), forged)); err != guest.ErrInvalidSignature {
+		t.Errorf("Expected ErrInvalidSignature, got %v", err)
+	}
+}
+
+func TestAdmitSessionOptionalToken(t *testing.T) {
+	s := newGuestTestServer(false)
+
+	if err := s.admitSession(guestHandshake(t, uuid.New(), "")); err != nil {
+		t.Errorf("Expected session without token to be admitted, got %v", err)
+	}
+	if s.GetGuestSessionCount() != 0 {
+		t.Errorf("Expected no guest sessions, got %d", s.GetGuestSessionCount())
+	}
+}
+
+func TestGuestTrafficCap(t *testing.T) {
+	s := newGuestTestServer(true)
+
+	token, _, err := guest.Issue("test-secret", time.Hour, 100)
+	if err != nil {
+		t.Fatalf("Failed to issue token: %v", err)
+	}
+
+	sessionID := uuid.New()
+	if err := s.admitSession(guestHandshake(t, sessionID, token)); err != nil {
+		t.Fatalf("Failed to admit session: %v", err)
+	}
+
+	s.recordGuestTraffic(sessionID, 60)
+	if s.GetGuestSessionCount() != 1 {
+		t.Fatalf("Expected session to stay open below the cap")
+	}
+
+	s.recordGuestTraffic(sessionID, 40)
+	if s.GetGuestSessionCount() != 0 {
+		t.Errorf("Expected session to be closed once the cap is reached")
+	}
+
+	dataPkt, _ := protocol.NewPacket(sessionID, 1, protocol.FlagData, []byte("x"))
+	if err := s.admitSession(dataPkt); err != errGuestSessionClosed {
+		t.Errorf("Expected errGuestSessionClosed, got %v", err)
+	}
+
+	// A new session with the exhausted token is rejected
+	if err := s.admitSession(guestHandshake(t, uuid.New(), token)); err != errGuestTrafficUsed {
+		t.Errorf("Expected errGuestTrafficUsed, got %v", err)
+	}
+}
+
+func TestGuestSessionTTLExpiry(t *testing.T) {
+	s := newGuestTestServer(true)
+
+	token, info, err := guest.Issue("test-secret", time.Hour, 0)
+	if err != nil {
+		t.Fatalf("Failed to issue token: %v", err)
+	}
+
+	sessionID := uuid.New()
+	if err := s.admitSession(guestHandshake(t, sessionID, token)); err != nil {
+		t.Fatalf("Failed to admit session: %v", err)
+	}
+
+	// Within the warning window the session is warned but kept
+	s.checkGuestSessions(info.ExpiresAt.Add(-time.Minute))
+	s.guestMu.RLock()
+	warned := s.guestSessions[sessionID].warnedTTL
+	s.guestMu.RUnlock()
+	if !warned {
+		t.Error("Expected TTL warning to be recorded")
+	}
+	if s.GetGuestSessionCount() != 1 {
+		t.Fatalf("Expected session to stay open before expiry")
+	}
+
+	s.checkGuestSessions(info.ExpiresAt.Add(time.Second))
+	if s.GetGuestSessionCount() != 0 {
+		t.Errorf("Expected session to be closed after expiry")
+	}
+}
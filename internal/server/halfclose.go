@@ -0,0 +1,77 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// halfCloseIdleTimeout is how long a stream the client finished sending on
+// stays open without data from the destination.
+const halfCloseIdleTimeout = time.Minute
+
+var errNoHalfClose = errors.New("destination connection cannot be half-closed")
+
+// closeWriter is implemented by connections that can shut down their
+// writing side, such as *net.TCPConn.
+type closeWriter interface {
+	CloseWrite() error
+}
+
+// halfClose shuts down the writing side of conn and starts the idle timeout
+// of its reading side.
+func halfClose(conn net.Conn) error {
+	cw, ok := conn.(closeWriter)
+	if !ok {
+		return errNoHalfClose
+	}
+	if err := cw.CloseWrite(); err != nil {
+		return err
+	}
+	return conn.SetReadDeadline(time.Now().Add(halfCloseIdleTimeout))
+}
+
+// closeWrite half-closes the destination connection after the client
+// finished sending, so the destination reads EOF but can still respond. A
+// connection still being dialed is half-closed by establish. It returns
+// false if the connection cannot be half-closed.
+func (e *natEntry) closeWrite() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.closed {
+		return false
+	}
+	atomic.StoreInt32(&e.writeClosed, 1)
+	if e.conn == nil {
+		return true
+	}
+	return halfClose(e.conn) == nil
+}
+
+// halfClosed reports whether the client finished sending on the stream.
+func (e *natEntry) halfClosed() bool {
+	return atomic.LoadInt32(&e.writeClosed) == 1
+}
+
+// halfCloseNatEntry half-closes a stream whose client finished sending. The
+// stream is closed once the destination closes its side or stays idle for
+// halfCloseIdleTimeout. It returns false if the stream does not exist or
+// cannot be half-closed.
+func (s *Server) halfCloseNatEntry(sessionID uuid.UUID, streamID uint32) bool {
+	s.natTableMu.RLock()
+	entry, exists := s.natTable[natKey{SessionID: sessionID, StreamID: streamID}]
+	s.natTableMu.RUnlock()
+
+	if !exists || !entry.closeWrite() {
+		return false
+	}
+	s.log.Debug().
+		Str("session_id", sessionID.String()).
+		Uint32("stream_id", streamID).
+		Msg("Stream half-closed by client")
+	return true
+}
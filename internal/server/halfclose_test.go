@@ -0,0 +1,126 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sahmadiut/half-tunnel/internal/protocol"
+)
+
+// openTestStream opens stream 1 of a new session to a local listener
+// through the server and returns the accepted destination connection.
+func openTestStream(t *testing.T, s *Server) (uuid.UUID, net.Conn) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	defer ln.Close()
+
+	sessionID := uuid.New()
+	open, _ := protocol.NewPacket(sessionID, 1, protocol.FlagHandshake|protocol.FlagData, connectPayload(t, ln.Addr()))
+	data, _ := protocol.NewPacket(sessionID, 1, protocol.FlagData, []byte("request"))
+	s.handleUpstreamPacket(context.Background(), open)
+	s.handleUpstreamPacket(context.Background(), data)
+
+	_ = ln.(*net.TCPListener).SetDeadline(time.Now().Add(2 * time.Second))
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatalf("Accept failed: %v", err)
+	}
+	return sessionID, conn
+}
+
+func TestClientFinHalfClosesStream(t *testing.T) {
+	s := New(nil, nil)
+	defer s.sessionStore.Close()
+
+	sessionID, dest := openTestStream(t, s)
+	defer dest.Close()
+
+	fin, _ := protocol.NewFinReasonPacket(sessionID, 1, protocol.Fin{Reason: protocol.CloseEOF})
+	s.handleUpstreamPacket(context.Background(), fin)
+
+	// The destination reads the request, then EOF, while the stream stays
+	// open for its response
+	_ = dest.SetReadDeadline(time.Now().Add(2 * time.Second))
+	got, err := io.ReadAll(dest)
+	if err != nil {
+		t.Fatalf("Expected EOF after the request, got %v", err)
+	}
+	if string(got) != "request" {
+		t.Errorf("Expected request, got %q", got)
+	}
+	if n := s.GetNatEntryCount(); n != 1 {
+		t.Fatalf("Expected half-closed stream to stay open, got %d NAT entries", n)
+	}
+
+	// Closing the destination's side ends the stream
+	dest.Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for s.GetNatEntryCount() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected stream to close after the destination closed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestClientFinWithoutReasonClosesStream(t *testing.T) {
+	s := New(nil, nil)
+	defer s.sessionStore.Close()
+
+	sessionID, dest := openTestStream(t, s)
+	defer dest.Close()
+
+	// Older clients send no reason and close their side completely
+	fin, _ := protocol.NewFinPacket(sessionID, 1)
+	s.handleUpstreamPacket(context.Background(), fin)
+
+	if n := s.GetNatEntryCount(); n != 0 {
+		t.Errorf("Expected stream to close, got %d NAT entries", n)
+	}
+}
+
+func TestHalfCloseWhileDialing(t *testing.T) {
+	entry := &natEntry{}
+	if !entry.closeWrite() {
+		t.Fatal("Expected a stream being dialed to accept the half-close")
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	defer ln.Close()
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+	dest, err := ln.Accept()
+	if err != nil {
+		t.Fatalf("Accept failed: %v", err)
+	}
+	defer dest.Close()
+
+	if _, err := entry.establish(conn); err != nil {
+		t.Fatalf("establish failed: %v", err)
+	}
+	_ = dest.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := dest.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("Expected destination to read EOF, got %v", err)
+	}
+
+	// Pipes cannot be half-closed
+	pipe, peer := net.Pipe()
+	defer peer.Close()
+	entry = &natEntry{conn: pipe}
+	if entry.closeWrite() {
+		t.Error("Expected half-close of a pipe to fail")
+	}
+}
@@ -0,0 +1,172 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// StreamInfo describes a stream served by a StreamHandler.
+type StreamInfo struct {
+	SessionID uuid.UUID
+	StreamID  uint32
+	// DestAddr is the host:port the client asked for
+	DestAddr string
+	// Identity is the client identity of the session, if known
+	Identity string
+}
+
+// StreamHandler serves a stream in the server instead of dialing its
+// destination. conn carries the stream's data; closing it closes the stream,
+// and it is closed when the handler returns.
+type StreamHandler func(conn net.Conn, stream StreamInfo)
+
+// streamHandlers maps destinations to the handlers serving them.
+type streamHandlers struct {
+	mu       sync.RWMutex
+	handlers map[string]StreamHandler
+}
+
+// handlerKey normalizes a host:port destination for lookups.
+func handlerKey(addr string) (string, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil || port == 0 {
+		return "", fmt.Errorf("invalid port %q", portStr)
+	}
+	if host == "" {
+		return "", fmt.Errorf("missing host in %q", addr)
+	}
+	return net.JoinHostPort(strings.ToLower(host), strconv.Itoa(int(port))), nil
+}
+
+// Handle serves streams to addr (host:port) with handler instead of dialing
+// addr; a nil handler removes the handler of addr. Handlers take precedence
+// over the diagnostic targets but not over the destination policy, which
+// still decides who may open streams to addr. Handle may be called while
+// the server runs; streams already open keep their handler.
+func (s *Server) Handle(addr string, handler StreamHandler) error {
+	key, err := handlerKey(addr)
+	if err != nil {
+		return fmt.Errorf("invalid handler address: %w", err)
+	}
+
+	s.streamHandlers.mu.Lock()
+	defer s.streamHandlers.mu.Unlock()
+	if handler == nil {
+		delete(s.streamHandlers.handlers, key)
+		return nil
+	}
+	if s.streamHandlers.handlers == nil {
+		s.streamHandlers.handlers = make(map[string]StreamHandler)
+	}
+	s.streamHandlers.handlers[key] = handler
+	return nil
+}
+
+// streamHandler returns the handler serving destAddr, or nil.
+func (s *Server) streamHandler(destAddr string) StreamHandler {
+	key, err := handlerKey(destAddr)
+	if err != nil {
+		return nil
+	}
+	s.streamHandlers.mu.RLock()
+	defer s.streamHandlers.mu.RUnlock()
+	return s.streamHandlers.handlers[key]
+}
+
+// pipeHandler returns one end of an in-memory connection with handler
+// serving the stream on the other.
+func pipeHandler(handler StreamHandler, stream StreamInfo) net.Conn {
+	local, remote := net.Pipe()
+	go func() {
+		defer remote.Close()
+		handler(remote, stream)
+	}()
+	return local
+}
+
+// streamInfoKey is the request context key of the StreamInfo of requests
+// served by HTTPStreamHandler.
+type streamInfoKey struct{}
+
+// HTTPStreamHandler serves the HTTP requests of a stream with h, e.g. to
+// expose an in-process service at a virtual destination. h can get the
+// stream's StreamInfo with StreamInfoFromContext.
+func HTTPStreamHandler(h http.Handler) StreamHandler {
+	return func(conn net.Conn, stream StreamInfo) {
+		srv := &http.Server{
+			Handler: h,
+			ConnContext: func(ctx context.Context, _ net.Conn) context.Context {
+				return context.WithValue(ctx, streamInfoKey{}, stream)
+			},
+		}
+		_ = srv.Serve(newConnListener(conn))
+	}
+}
+
+// StreamInfoFromContext returns the StreamInfo of a request served by
+// HTTPStreamHandler.
+func StreamInfoFromContext(ctx context.Context) (StreamInfo, bool) {
+	stream, ok := ctx.Value(streamInfoKey{}).(StreamInfo)
+	return stream, ok
+}
+
+// connListener is a net.Listener that accepts a single connection, then
+// blocks until that connection is closed, so http.Server.Serve returns once
+// it is done with the connection.
+type connListener struct {
+	conn     net.Conn
+	accepted chan struct{}
+	closed   chan struct{}
+	once     sync.Once
+}
+
+func newConnListener(conn net.Conn) *connListener {
+	l := &connListener{
+		accepted: make(chan struct{}, 1),
+		closed:   make(chan struct{}),
+	}
+	l.conn = &listenerConn{Conn: conn, listener: l}
+	l.accepted <- struct{}{}
+	return l
+}
+
+func (l *connListener) Accept() (net.Conn, error) {
+	select {
+	case <-l.accepted:
+		return l.conn, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *connListener) Close() error {
+	l.once.Do(func() { close(l.closed) })
+	return nil
+}
+
+func (l *connListener) Addr() net.Addr {
+	return l.conn.LocalAddr()
+}
+
+// listenerConn closes its listener when it is closed.
+type listenerConn struct {
+	net.Conn
+	listener *connListener
+}
+
+func (c *listenerConn) Close() error {
+	err := c.Conn.Close()
+	_ = c.listener.Close()
+	return err
+}
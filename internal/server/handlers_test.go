@@ -0,0 +1,88 @@
+package server
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestHandleAddresses(t *testing.T) {
+	s := New(DefaultConfig(), nil)
+	defer s.sessionStore.Close()
+
+	for _, addr := range []string{"internal.service", "internal.service:http", "internal.service:0", ":80"} {
+		if err := s.Handle(addr, func(net.Conn, StreamInfo) {}); err == nil {
+			t.Errorf("Expected an error for %q", addr)
+		}
+	}
+
+	if err := s.Handle("Internal.Service:80", func(net.Conn, StreamInfo) {}); err != nil {
+		t.Fatalf("Handle failed: %v", err)
+	}
+	if s.streamHandler("internal.service:80") == nil {
+		t.Error("Expected the handler found regardless of case")
+	}
+	if s.streamHandler("internal.service:81") != nil {
+		t.Error("Expected no handler for another port")
+	}
+
+	if err := s.Handle("internal.service:80", nil); err != nil {
+		t.Fatalf("Removing the handler failed: %v", err)
+	}
+	if s.streamHandler("internal.service:80") != nil {
+		t.Error("Expected the handler removed")
+	}
+}
+
+func TestPipeHandlerClosesConnection(t *testing.T) {
+	conn := pipeHandler(func(conn net.Conn, stream StreamInfo) {
+		_, _ = io.WriteString(conn, stream.DestAddr)
+	}, StreamInfo{DestAddr: "internal.service:80"})
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(2 * time.Second))
+
+	got, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if string(got) != "internal.service:80" {
+		t.Errorf("Expected %q, got %q", "internal.service:80", got)
+	}
+}
+
+func TestHTTPStreamHandler(t *testing.T) {
+	sessionID := uuid.New()
+	handler := HTTPStreamHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		stream, ok := StreamInfoFromContext(r.Context())
+		if !ok {
+			http.Error(w, "no stream info", http.StatusInternalServerError)
+			return
+		}
+		_, _ = io.WriteString(w, stream.SessionID.String()+" "+r.URL.Path)
+	}))
+	conn := pipeHandler(handler, StreamInfo{SessionID: sessionID, StreamID: 1, DestAddr: "internal.service:80"})
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(2 * time.Second))
+
+	reader := bufio.NewReader(conn)
+	for _, path := range []string{"/first", "/second"} {
+		req, _ := http.NewRequest(http.MethodGet, "http://internal.service"+path, nil)
+		if err := req.Write(conn); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+		resp, err := http.ReadResponse(reader, req)
+		if err != nil {
+			t.Fatalf("ReadResponse failed: %v", err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if want := sessionID.String() + " " + path; string(body) != want {
+			t.Errorf("Expected %q, got %q", want, body)
+		}
+	}
+}
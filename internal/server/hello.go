@@ -0,0 +1,55 @@
+package server
+
+import (
+	"github.com/google/uuid"
+	"github.com/sahmadiut/half-tunnel/internal/protocol"
+)
+
+// handleDownstreamControl handles a control message the client sent on its
+// downstream connection and returns the reply to write back, if any.
+func (s *Server) handleDownstreamControl(sessionID uuid.UUID, pkt *protocol.Packet) ([]byte, error) {
+	ctrl, body, err := protocol.ParseControl(pkt)
+	if err != nil {
+		return nil, err
+	}
+	if ctrl != protocol.ControlHello {
+		return nil, nil
+	}
+
+	remote, err := protocol.ParseHello(body)
+	if err != nil {
+		return nil, err
+	}
+	local := protocol.LocalHello()
+	local.MaxPayload = uint32(s.maxPayload())
+	hello, err := protocol.Negotiate(local, remote)
+	if err != nil {
+		s.log.Warn().Err(err).
+			Str("session_id", sessionID.String()).
+			Uint8("client_version", remote.Version).
+			Msg("Client protocol version not supported")
+		s.recordError("protocol")
+		return nil, nil
+	}
+
+	sess := s.sessionStore.GetOrCreate(sessionID)
+	sess.SetProtocol(hello.Version, uint32(hello.Capabilities))
+	sess.SetMaxPayload(int(hello.MaxPayload))
+	s.log.Debug().
+		Str("session_id", sessionID.String()).
+		Uint8("version", hello.Version).
+		Str("capabilities", hello.Capabilities.String()).
+		Uint32("max_payload", hello.MaxPayload).
+		Msg("Protocol negotiated with client")
+
+	if hello.Capabilities.Has(protocol.CapCompactHeader) {
+		hello.SessionIndex = s.assignSessionIndex(sessionID)
+	}
+
+	// The reply keeps the full header: the client learns the index from it
+	reply, err := protocol.NewHelloPacket(sessionID, hello)
+	if err != nil {
+		return nil, err
+	}
+	return reply.Marshal()
+}
@@ -0,0 +1,66 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/sahmadiut/half-tunnel/internal/protocol"
+)
+
+func TestDownstreamHelloNegotiation(t *testing.T) {
+	s := New(nil, nil)
+	defer s.sessionStore.Close()
+
+	sessionID := uuid.New()
+	s.sessionStore.GetOrCreate(sessionID)
+
+	// A newer client offering a capability this server does not support
+	hello, _ := protocol.NewHelloPacket(sessionID, protocol.Hello{
+		Version:      protocol.Version + 1,
+		Capabilities: protocol.SupportedCapabilities | protocol.CapCompression,
+	})
+	data, _ := hello.Marshal()
+
+	reply, err := s.handleDownstreamPacket(sessionID, data)
+	if err != nil {
+		t.Fatalf("handleDownstreamPacket failed: %v", err)
+	}
+	pkt, err := protocol.Unmarshal(reply)
+	if err != nil {
+		t.Fatalf("Expected a hello reply, got %v", err)
+	}
+	ctrl, body, err := protocol.ParseControl(pkt)
+	if err != nil || ctrl != protocol.ControlHello {
+		t.Fatalf("Expected ControlHello, got %d (%v)", ctrl, err)
+	}
+	got, _ := protocol.ParseHello(body)
+	want := protocol.LocalHello()
+	if got.Version != want.Version || got.Capabilities != want.Capabilities {
+		t.Errorf("Expected %+v, got %+v", want, got)
+	}
+	if got.SessionIndex == 0 {
+		t.Error("Expected a session index for compact headers")
+	}
+
+	sess, _ := s.sessionStore.Get(sessionID)
+	if version, caps := sess.Protocol(); version != want.Version || protocol.Capability(caps) != want.Capabilities {
+		t.Errorf("Expected session protocol %+v, got version %d, capabilities %s", want, version, protocol.Capability(caps))
+	}
+	if status := s.AdminStatus(); status.Sessions[0].Protocol != int(want.Version) {
+		t.Errorf("Expected admin status protocol %d, got %d", want.Version, status.Sessions[0].Protocol)
+	}
+}
+
+func TestDownstreamHelloUnsupportedVersion(t *testing.T) {
+	s := New(nil, nil)
+	defer s.sessionStore.Close()
+
+	sessionID := uuid.New()
+	hello, _ := protocol.NewHelloPacket(sessionID, protocol.Hello{Version: 0})
+	data, _ := hello.Marshal()
+
+	reply, err := s.handleDownstreamPacket(sessionID, data)
+	if err != nil || reply != nil {
+		t.Errorf("Expected no reply, got %v, %v", reply, err)
+	}
+}
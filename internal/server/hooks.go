@@ -0,0 +1,54 @@
+package server
+
+import "github.com/google/uuid"
+
+// Hooks are called on server lifecycle events, e.g. by a program embedding
+// the server. They run on the goroutine of the event and must not block.
+// Any of them may be nil.
+type Hooks struct {
+	// SessionOpened is called when a client session is created
+	SessionOpened func(sessionID uuid.UUID)
+	// SessionClosed is called when a client session is removed; sessions
+	// outlive their connections until they expire or are closed
+	SessionClosed func(sessionID uuid.UUID)
+	// StreamOpened is called when a client opens a stream to destAddr
+	StreamOpened func(sessionID uuid.UUID, streamID uint32, destAddr string)
+	// StreamClosed is called when a stream is closed; closedBy is "local"
+	// or "peer"
+	StreamClosed func(sessionID uuid.UUID, streamID uint32, closedBy, reason string)
+}
+
+// sessionCallbacks returns the session store callbacks feeding the metrics
+// and the hooks.
+func (s *Server) sessionCallbacks() (onCreate, onRemove func(uuid.UUID)) {
+	m, h := s.config.Metrics, s.config.Hooks
+	onCreate = func(id uuid.UUID) {
+		if m != nil {
+			m.RecordSessionCreated()
+		}
+		if h != nil && h.SessionOpened != nil {
+			h.SessionOpened(id)
+		}
+	}
+	onRemove = func(id uuid.UUID) {
+		if m != nil {
+			m.RecordSessionClosed()
+		}
+		if h != nil && h.SessionClosed != nil {
+			h.SessionClosed(id)
+		}
+	}
+	return onCreate, onRemove
+}
+
+func (s *Server) hookStreamOpened(key natKey, entry *natEntry) {
+	if h := s.config.Hooks; h != nil && h.StreamOpened != nil {
+		h.StreamOpened(key.SessionID, key.StreamID, entry.destAddr)
+	}
+}
+
+func (s *Server) hookStreamClosed(key natKey, closedBy, reason string) {
+	if h := s.config.Hooks; h != nil && h.StreamClosed != nil {
+		h.StreamClosed(key.SessionID, key.StreamID, closedBy, reason)
+	}
+}
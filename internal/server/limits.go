@@ -0,0 +1,77 @@
+package server
+
+import (
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/sahmadiut/half-tunnel/internal/protocol"
+	"github.com/sahmadiut/half-tunnel/internal/session"
+	"github.com/sahmadiut/half-tunnel/internal/transport"
+)
+
+// admitToStore admits a session to the session store. It returns
+// session.ErrSessionLimit if the session limit refuses a new session; other
+// store errors are logged and the session is served anyway.
+func (s *Server) admitToStore(sessionID uuid.UUID) error {
+	_, err := s.sessionStore.Admit(sessionID)
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, session.ErrSessionLimit) {
+		s.recordError("session_limit")
+		if s.config.Metrics != nil {
+			s.config.Metrics.RecordSessionRejected()
+		}
+		return err
+	}
+
+	s.log.Warn().Err(err).
+		Str("session_id", sessionID.String()).
+		Msg("Session backend unavailable, tracking session locally")
+	s.recordError("session_backend")
+	return nil
+}
+
+// rejectSession tells a client on conn that its session was refused at the
+// session limit. The caller closes conn.
+func (s *Server) rejectSession(conn *transport.Connection, sessionID uuid.UUID) {
+	pkt, err := protocol.NewSessionLimitPacket(sessionID, protocol.ControlSessionRejected, protocol.SessionLimit{
+		Reason: protocol.LimitSessions,
+	})
+	if err != nil {
+		return
+	}
+	data, err := pkt.Marshal()
+	if err != nil {
+		return
+	}
+	s.recordPacketSent(int64(len(data)))
+	if err := conn.Write(data); err != nil {
+		s.log.Debug().Err(err).
+			Str("session_id", sessionID.String()).
+			Msg("Failed to send session rejection")
+	}
+}
+
+// evictSession closes a session evicted from the store to admit a new one,
+// telling the client why.
+func (s *Server) evictSession(sessionID uuid.UUID) {
+	s.log.Info().
+		Str("session_id", sessionID.String()).
+		Str("policy", string(s.config.SessionEviction)).
+		Msg("Evicting session to admit a new one")
+	if s.config.Metrics != nil {
+		s.config.Metrics.RecordSessionEvicted()
+	}
+
+	s.sendSessionLimit(sessionID, protocol.ControlSessionExpired, protocol.SessionLimit{Reason: protocol.LimitSessions})
+	s.teardownSession(sessionID, closeReasonEvicted)
+}
+
+// expireSession closes a session removed from the store after being idle for
+// SessionTimeout, so its destination connections and downstream connection do
+// not outlive it.
+func (s *Server) expireSession(sessionID uuid.UUID) {
+	s.sendSessionLimit(sessionID, protocol.ControlSessionExpired, protocol.SessionLimit{Reason: protocol.LimitIdle})
+	s.teardownSession(sessionID, closeReasonExpired)
+}
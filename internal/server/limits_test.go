@@ -0,0 +1,146 @@
+package server
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sahmadiut/half-tunnel/internal/metrics"
+	"github.com/sahmadiut/half-tunnel/internal/protocol"
+	"github.com/sahmadiut/half-tunnel/internal/transport"
+)
+
+func TestSessionLimitRejectsDownstream(t *testing.T) {
+	addr := freeAddr(t)
+	config := DefaultConfig()
+	config.UpstreamAddr = addr
+	config.DownstreamAddr = addr
+	config.MaxSessions = 1
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := New(config, nil)
+	if err := s.Start(ctx); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer s.Stop(context.Background())
+
+	if _, err := s.sessionStore.Admit(uuid.New()); err != nil {
+		t.Fatalf("Failed to admit first session: %v", err)
+	}
+
+	conn, err := transport.Dial(ctx, transport.DefaultConfig("ws://"+addr+"/downstream"))
+	if err != nil {
+		t.Fatalf("Failed to dial downstream: %v", err)
+	}
+	defer conn.Close()
+
+	sessionID := uuid.New()
+	handshake, _ := protocol.NewHandshakePacket(sessionID)
+	data, _ := handshake.Marshal()
+	if err := conn.Write(data); err != nil {
+		t.Fatalf("Failed to send handshake: %v", err)
+	}
+
+	reply, err := conn.Read()
+	if err != nil {
+		t.Fatalf("Expected a rejection packet, got %v", err)
+	}
+	pkt, err := protocol.Unmarshal(reply)
+	if err != nil {
+		t.Fatalf("Failed to parse reply: %v", err)
+	}
+	ctrl, body, err := protocol.ParseControl(pkt)
+	if err != nil || ctrl != protocol.ControlSessionRejected {
+		t.Fatalf("Expected ControlSessionRejected, got %v (%v)", ctrl, err)
+	}
+	if limit, _ := protocol.ParseSessionLimit(body); limit.Reason != protocol.LimitSessions {
+		t.Errorf("Expected reason sessions, got %s", limit.Reason)
+	}
+	if _, err := conn.Read(); err == nil {
+		t.Error("Expected the rejected connection to be closed")
+	}
+	if s.GetSessionCount() != 1 {
+		t.Errorf("Expected 1 session, got %d", s.GetSessionCount())
+	}
+}
+
+func TestExpiredSessionIsTornDown(t *testing.T) {
+	config := DefaultConfig()
+	config.SessionTimeout = 40 * time.Millisecond
+	config.Metrics = metrics.NewCollector()
+	s := New(config, nil)
+	defer s.sessionStore.Close()
+
+	sessionID := uuid.New()
+	if _, err := s.sessionStore.Admit(sessionID); err != nil {
+		t.Fatalf("Admit failed: %v", err)
+	}
+	conn, dest := net.Pipe()
+	defer dest.Close()
+	s.natTableMu.Lock()
+	s.natTable[natKey{SessionID: sessionID, StreamID: 1}] = &natEntry{conn: conn, destAddr: "example.com:443", created: time.Now()}
+	s.natTableMu.Unlock()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) && s.GetNatEntryCount() > 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if n := s.GetNatEntryCount(); n != 0 {
+		t.Errorf("Expected NAT entries of the expired session to be closed, got %d", n)
+	}
+	if n := s.GetSessionCount(); n != 0 {
+		t.Errorf("Expected expired session to be removed, got %d", n)
+	}
+	if got := testutil.ToFloat64(config.Metrics.SessionsClosed.WithLabelValues(closeReasonExpired)); got != 1 {
+		t.Errorf("Expected 1 expired session in metrics, got %v", got)
+	}
+}
+
+func TestConnectionLimitCountsRelayedClients(t *testing.T) {
+	addr := freeAddr(t)
+	config := DefaultConfig()
+	config.UpstreamAddr = addr
+	config.DownstreamAddr = addr
+	config.MaxConnectionsPerIP = 1
+	config.Cluster = ClusterConfig{Node: "a", Secret: "cluster-secret", Peers: []ClusterPeer{{Name: "b"}}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := New(config, nil)
+	if err := s.Start(ctx); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer s.Stop(context.Background())
+
+	// A peer relays two clients, then a second connection of the first
+	var conns []*transport.Connection
+	defer func() {
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}()
+	dial := func(client string) error {
+		dialConfig := transport.DefaultConfig("ws://" + addr + "/upstream")
+		dialConfig.Header = http.Header{relayHeader: {s.signRelay(client, "", time.Now())}}
+		conn, err := transport.Dial(ctx, dialConfig)
+		if err == nil {
+			conns = append(conns, conn)
+		}
+		return err
+	}
+	if err := dial("198.51.100.7:40000"); err != nil {
+		t.Fatalf("Failed to relay the first client: %v", err)
+	}
+	if err := dial("198.51.100.8:40000"); err != nil {
+		t.Fatalf("Expected the second client not to count against the peer: %v", err)
+	}
+	if err := dial("198.51.100.7:40001"); err == nil {
+		t.Error("Expected the first client's second connection to be refused")
+	}
+}
@@ -0,0 +1,142 @@
+package server
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// minLivenessCheckInterval bounds how often streams are checked when
+// IdleTimeout is short.
+const minLivenessCheckInterval = time.Second
+
+// LivenessConfig holds settings for closing idle streams whose destination
+// is gone without closing its connection, such as a host that lost power or
+// a NAT that dropped the mapping.
+type LivenessConfig struct {
+	// IdleTimeout is how long a stream may pass no data in either direction
+	// before its destination is probed
+	IdleTimeout time.Duration
+	// ProbeInterval is the time between TCP keepalive probes of an idle
+	// destination
+	ProbeInterval time.Duration
+	// ProbeCount is the number of unanswered probes before the destination
+	// is considered gone
+	ProbeCount int
+}
+
+// DefaultLivenessConfig returns the default stream liveness settings.
+func DefaultLivenessConfig() *LivenessConfig {
+	return &LivenessConfig{
+		IdleTimeout:   10 * time.Minute,
+		ProbeInterval: 10 * time.Second,
+		ProbeCount:    3,
+	}
+}
+
+// checkInterval returns how often streams are checked for idleness.
+func (c *LivenessConfig) checkInterval() time.Duration {
+	if interval := c.IdleTimeout / 4; interval > minLivenessCheckInterval {
+		return interval
+	}
+	return minLivenessCheckInterval
+}
+
+// lastActivity returns when the stream last passed data in either
+// direction.
+func (e *natEntry) lastActivity() time.Time {
+	if e.stream == nil {
+		return e.created
+	}
+	return e.stream.Stats().LastActivity
+}
+
+// probing reports whether the stream's destination is being probed.
+func (e *natEntry) probing() bool {
+	return atomic.LoadInt64(&e.probedActivity) != 0
+}
+
+// probe starts TCP keepalive probes on the destination connection, so the
+// kernel fails its reads once the destination stops answering. It returns
+// false if the connection cannot be probed.
+func (e *natEntry) probe(config *LivenessConfig, last time.Time) bool {
+	tcpConn, ok := e.destConn().(*net.TCPConn)
+	if !ok {
+		return false
+	}
+	if err := tcpConn.SetKeepAliveConfig(net.KeepAliveConfig{
+		Enable:   true,
+		Idle:     config.ProbeInterval,
+		Interval: config.ProbeInterval,
+		Count:    config.ProbeCount,
+	}); err != nil {
+		return false
+	}
+	atomic.StoreInt64(&e.probedActivity, last.UnixNano())
+	return true
+}
+
+// stopProbing restores the destination connection's keepalive settings
+// once the stream passes data again.
+func (s *Server) stopProbing(e *natEntry) {
+	atomic.StoreInt64(&e.probedActivity, 0)
+	conn := e.destConn()
+	if s.config.TCP != nil {
+		_ = s.config.TCP.Apply(conn)
+	} else if tcpConn, ok := conn.(*net.TCPConn); ok {
+		// The dialer's default
+		_ = tcpConn.SetKeepAliveConfig(net.KeepAliveConfig{Enable: true})
+	}
+}
+
+// livenessCheckLoop periodically probes the destinations of idle streams.
+func (s *Server) livenessCheckLoop(ctx context.Context) {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(s.config.Liveness.checkInterval())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.shutdown:
+			return
+		case now := <-ticker.C:
+			s.checkIdleStreams(now)
+		}
+	}
+}
+
+// checkIdleStreams starts probing the destinations of streams idle for
+// IdleTimeout and stops probing those that passed data since. A probed
+// stream whose destination does not answer fails its read and is closed by
+// forwardDestToDownstream with close reason idle_timeout.
+func (s *Server) checkIdleStreams(now time.Time) {
+	config := s.config.Liveness
+
+	s.natTableMu.RLock()
+	entries := make([]*natEntry, 0, len(s.natTable))
+	for _, entry := range s.natTable {
+		entries = append(entries, entry)
+	}
+	s.natTableMu.RUnlock()
+
+	for _, entry := range entries {
+		last := entry.lastActivity()
+		if probed := atomic.LoadInt64(&entry.probedActivity); probed != 0 {
+			if last.UnixNano() != probed {
+				s.stopProbing(entry)
+			}
+			continue
+		}
+		if now.Sub(last) < config.IdleTimeout || !entry.probe(config, last) {
+			continue
+		}
+		s.log.Debug().
+			Str("dest", entry.destAddr).
+			Time("last_activity", last).
+			Msg("Probing idle stream's destination")
+	}
+}
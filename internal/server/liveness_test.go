@@ -0,0 +1,106 @@
+package server
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sahmadiut/half-tunnel/internal/admin"
+	"github.com/sahmadiut/half-tunnel/internal/metrics"
+	"github.com/sahmadiut/half-tunnel/internal/protocol"
+	"github.com/sahmadiut/half-tunnel/internal/session"
+)
+
+func TestIdleStreamProbed(t *testing.T) {
+	config := DefaultConfig()
+	config.Metrics = metrics.NewCollector()
+	config.Liveness = &LivenessConfig{IdleTimeout: time.Minute, ProbeInterval: time.Second, ProbeCount: 1}
+	s := New(config, nil)
+	defer s.sessionStore.Close()
+
+	sessionID, dest := openTestStream(t, s)
+	defer dest.Close()
+	entry := s.natTable[natKey{SessionID: sessionID, StreamID: 1}]
+	// The destination may accept before the dial returns
+	deadline := time.Now().Add(2 * time.Second)
+	for entry.destConn() == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the destination to be dialed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	s.checkIdleStreams(time.Now())
+	if entry.probing() {
+		t.Fatal("Expected a recently active stream not to be probed")
+	}
+
+	s.checkIdleStreams(time.Now().Add(2 * time.Minute))
+	if !entry.probing() {
+		t.Fatal("Expected an idle stream to be probed")
+	}
+
+	// A destination that vanished resets the connection, like one whose
+	// probes go unanswered
+	_ = dest.(*net.TCPConn).SetLinger(0)
+	dest.Close()
+	deadline = time.Now().Add(2 * time.Second)
+	for s.GetNatEntryCount() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the probed stream to close")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	reason := protocol.CloseIdleTimeout.String()
+	if got := testutil.ToFloat64(config.Metrics.StreamsClosed.WithLabelValues(admin.ClosedByLocal, reason)); got != 1 {
+		t.Errorf("Expected 1 stream closed as %s, got %v", reason, got)
+	}
+}
+
+func TestActiveStreamStopsProbing(t *testing.T) {
+	config := DefaultConfig()
+	config.Liveness = DefaultLivenessConfig()
+	s := New(config, nil)
+	defer s.sessionStore.Close()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	defer ln.Close()
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+
+	entry := &natEntry{conn: conn, created: time.Now(), stream: session.NewStream(1)}
+	s.natTable[natKey{SessionID: uuid.New(), StreamID: 1}] = entry
+
+	s.checkIdleStreams(time.Now().Add(time.Hour))
+	if !entry.probing() {
+		t.Fatal("Expected an idle stream to be probed")
+	}
+	s.checkIdleStreams(time.Now().Add(time.Hour))
+	if !entry.probing() {
+		t.Fatal("Expected a stream without data to stay probed")
+	}
+
+	// Probes stop once the stream passes data again
+	time.Sleep(time.Millisecond)
+	entry.countReceived(10)
+	s.checkIdleStreams(time.Now())
+	if entry.probing() {
+		t.Error("Expected probing to stop after data passed")
+	}
+
+	// Pipes cannot be probed
+	pipe, peer := net.Pipe()
+	defer pipe.Close()
+	defer peer.Close()
+	if (&natEntry{conn: pipe}).probe(config.Liveness, time.Now()) {
+		t.Error("Expected a pipe not to be probed")
+	}
+}
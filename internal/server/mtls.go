@@ -0,0 +1,48 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+
+	"github.com/sahmadiut/half-tunnel/internal/transport"
+)
+
+// configureClientAuth enables client certificate verification on tlsConfig
+// when a client CA file is configured. Certificates are verified whenever they
+// are presented; RequireClientCert additionally rejects clients without one.
+func configureClientAuth(tlsConfig *tls.Config, cfg TLSConfig) error {
+	if cfg.ClientCAFile == "" {
+		if cfg.RequireClientCert {
+			return fmt.Errorf("require_client_cert needs a client CA file")
+		}
+		return nil
+	}
+
+	caCert, err := os.ReadFile(cfg.ClientCAFile)
+	if err != nil {
+		return fmt.Errorf("failed to read client CA file: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caCert) {
+		return fmt.Errorf("failed to parse client CA certificate")
+	}
+
+	tlsConfig.ClientCAs = pool
+	tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	if cfg.RequireClientCert {
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return nil
+}
+
+// recordClientCert records the client certificate of an accepted connection
+// in the metrics, if one was presented.
+func (s *Server) recordClientCert(direction string, conn *transport.Connection) {
+	cn := conn.PeerCommonName()
+	if cn == "" || s.config.Metrics == nil {
+		return
+	}
+	s.config.Metrics.RecordClientCertConnection(direction, cn)
+}
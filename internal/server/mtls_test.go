@@ -0,0 +1,49 @@
+package server
+
+import (
+	"crypto/tls"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestConfigureClientAuth(t *testing.T) {
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "ca.crt")
+	writeTestCert(t, caFile, filepath.Join(dir, "ca.key"), "test-ca")
+
+	badFile := filepath.Join(dir, "bad.crt")
+	if err := os.WriteFile(badFile, []byte("not a certificate"), 0600); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		cfg      TLSConfig
+		wantAuth tls.ClientAuthType
+		wantErr  bool
+	}{
+		{"disabled", TLSConfig{}, tls.NoClientCert, false},
+		{"require without CA", TLSConfig{RequireClientCert: true}, tls.NoClientCert, true},
+		{"optional", TLSConfig{ClientCAFile: caFile}, tls.VerifyClientCertIfGiven, false},
+		{"required", TLSConfig{ClientCAFile: caFile, RequireClientCert: true}, tls.RequireAndVerifyClientCert, false},
+		{"missing CA file", TLSConfig{ClientCAFile: filepath.Join(dir, "missing.crt")}, tls.NoClientCert, true},
+		{"invalid CA file", TLSConfig{ClientCAFile: badFile}, tls.NoClientCert, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tlsConfig := &tls.Config{}
+			err := configureClientAuth(tlsConfig, tt.cfg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Expected error %v, got %v", tt.wantErr, err)
+			}
+			if tlsConfig.ClientAuth != tt.wantAuth {
+				t.Errorf("Expected client auth %v, got %v", tt.wantAuth, tlsConfig.ClientAuth)
+			}
+			if tt.cfg.ClientCAFile != "" && !tt.wantErr && tlsConfig.ClientCAs == nil {
+				t.Error("Expected client CA pool to be set")
+			}
+		})
+	}
+}
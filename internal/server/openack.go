@@ -0,0 +1,36 @@
+package server
+
+import (
+	"github.com/google/uuid"
+	"github.com/sahmadiut/half-tunnel/internal/protocol"
+)
+
+// openAckEnabled reports whether a session negotiated stream open
+// confirmations.
+func (s *Server) openAckEnabled(sessionID uuid.UUID) bool {
+	sess, ok := s.sessionStore.Get(sessionID)
+	if !ok {
+		return false
+	}
+	_, caps := sess.Protocol()
+	return protocol.Capability(caps).Has(protocol.CapOpenAck)
+}
+
+// sendOpenResult tells the client whether a stream's destination is
+// connected with a HANDSHAKE_ACK on the stream.
+func (s *Server) sendOpenResult(sessionID uuid.UUID, streamID uint32, result protocol.OpenResult) error {
+	return s.sendDownstreamPacket(sessionID, streamID, protocol.FlagHandshake|protocol.FlagAck, result.Marshal())
+}
+
+// confirmOpen tells a client that negotiated CapOpenAck that a stream's
+// destination is connected, so it can report the connection as established.
+func (s *Server) confirmOpen(sessionID uuid.UUID, streamID uint32) {
+	if !s.openAckEnabled(sessionID) {
+		return
+	}
+	if err := s.sendOpenResult(sessionID, streamID, protocol.OpenResult{}); err != nil {
+		s.log.Debug().Err(err).
+			Uint32("stream_id", streamID).
+			Msg("Failed to confirm stream open")
+	}
+}
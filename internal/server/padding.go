@@ -0,0 +1,27 @@
+package server
+
+import (
+	"github.com/sahmadiut/half-tunnel/internal/protocol"
+	"github.com/sahmadiut/half-tunnel/internal/session"
+)
+
+// stripPadding removes the padding of a data packet from a session that
+// negotiated CapPadding. Packets with a malformed padding trailer are
+// dropped.
+func (s *Server) stripPadding(sess *session.Session, pkt *protocol.Packet) bool {
+	if !pkt.IsData() {
+		return true
+	}
+	if _, caps := sess.Protocol(); !protocol.Capability(caps).Has(protocol.CapPadding) {
+		return true
+	}
+	if err := pkt.Unpad(); err != nil {
+		s.log.Debug().Err(err).
+			Str("session_id", pkt.SessionID.String()).
+			Uint32("stream_id", pkt.StreamID).
+			Msg("Dropped packet with invalid padding")
+		s.recordError("protocol")
+		return false
+	}
+	return true
+}
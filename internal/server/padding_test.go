@@ -0,0 +1,41 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sahmadiut/half-tunnel/internal/protocol"
+)
+
+func TestStripPadding(t *testing.T) {
+	s := New(nil, nil)
+	defer s.sessionStore.Close()
+
+	sessionID := uuid.New()
+	sess := s.sessionStore.GetOrCreate(sessionID)
+	sess.SetProtocol(protocol.Version, uint32(protocol.CapPadding))
+	key := natKey{SessionID: sessionID, StreamID: 1}
+	entry := &natEntry{destAddr: "example.com:443", created: time.Now()}
+	s.natTable[key] = entry
+
+	data, _ := protocol.NewPacket(sessionID, 1, protocol.FlagData, []byte("hello"))
+	if err := data.Pad(100); err != nil {
+		t.Fatalf("Pad failed: %v", err)
+	}
+	s.handleUpstreamPacket(context.Background(), data)
+
+	// A malformed trailer drops the packet
+	bad, _ := protocol.NewPacket(sessionID, 1, protocol.FlagData, []byte{0xff, 0xff})
+	s.handleUpstreamPacket(context.Background(), bad)
+
+	// Cover traffic is discarded
+	cover, _ := protocol.NewPaddingPacket(sessionID, 64)
+	s.handleUpstreamPacket(context.Background(), cover)
+
+	if len(entry.pending) != 1 || !bytes.Equal(entry.pending[0], []byte("hello")) {
+		t.Errorf("Expected only the unpadded payload to be queued, got %q", entry.pending)
+	}
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/sahmadiut/half-tunnel/internal/pathtoken"
+)
+
+// tunnelRoute is a WebSocket path and the handler for its direction.
+type tunnelRoute struct {
+	path    string
+	handler http.Handler
+}
+
+// newTunnelMux returns a mux serving the given tunnel routes, with the decoy
+// for everything else. A single-port listener passes both directions.
+func (s *Server) newTunnelMux(routes ...tunnelRoute) *http.ServeMux {
+	mux := http.NewServeMux()
+	servesRoot := false
+	for _, route := range routes {
+		if s.handleTunnelPath(mux, route.path, route.handler) {
+			servesRoot = true
+		}
+	}
+	if !servesRoot {
+		mux.HandleFunc("/", s.serveDecoy)
+	}
+	return mux
+}
+
+// handleTunnelPath registers the WebSocket handler for path on mux. Plain
+// HTTP requests on the tunnel path are sent to the decoy, so the endpoint
+// looks like an ordinary web server. When path tokens are enabled, only
+// <path>/<token> with a currently valid token is upgraded. It reports whether
+// the registered pattern covers the root path.
+func (s *Server) handleTunnelPath(mux *http.ServeMux, path string, handler http.Handler) bool {
+	base := strings.TrimSuffix(path, "/")
+	if s.pathTokens == nil {
+		mux.Handle(path, s.limitUpgrades(s.upgradeOnly(base, handler)))
+		return path == "/"
+	}
+
+	if base != "" {
+		// Registering the bare path stops ServeMux from redirecting it to base+"/"
+		mux.HandleFunc(base, s.serveDecoy)
+	}
+	mux.Handle(base+"/", s.limitUpgrades(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.URL.Path, base+"/")
+		if !s.pathTokens.Valid(base, token, time.Now()) {
+			s.log.Debug().
+				Str("remote_addr", r.RemoteAddr).
+				Str("path", r.URL.Path).
+				Msg("Rejected request with invalid path token")
+			if websocket.IsWebSocketUpgrade(r) {
+				s.handshakeFailed(r.RemoteAddr, handshakePathToken)
+			}
+			s.serveDecoy(w, r)
+			return
+		}
+		s.upgradeOnly(base, handler).ServeHTTP(w, r)
+	})))
+	return base == ""
+}
+
+// upgradeOnly passes WebSocket upgrade requests to handler and serves the
+// decoy for anything else. When upgrade cookies are enabled, upgrades
+// without a valid cookie for the base path get the decoy too, so a prober
+// sees the same response as for any other page.
+func (s *Server) upgradeOnly(base string, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !websocket.IsWebSocketUpgrade(r) {
+			s.serveDecoy(w, r)
+			return
+		}
+		if !s.validUpgradeCookie(r, base) {
+			s.log.Debug().
+				Str("remote_addr", r.RemoteAddr).
+				Str("path", r.URL.Path).
+				Msg("Rejected upgrade without a valid upgrade cookie")
+			s.handshakeFailed(r.RemoteAddr, handshakeUpgradeCookie)
+			s.serveDecoy(w, r)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// validUpgradeCookie reports whether r carries a currently valid upgrade
+// cookie for base. It always holds when upgrade cookies are disabled.
+func (s *Server) validUpgradeCookie(r *http.Request, base string) bool {
+	if s.upgradeTokens == nil {
+		return true
+	}
+	cookie, err := r.Cookie(s.upgradeCookieName())
+	if err != nil {
+		return false
+	}
+	return s.upgradeTokens.Valid(base, cookie.Value, time.Now())
+}
+
+// upgradeCookieName returns the configured upgrade cookie name.
+func (s *Server) upgradeCookieName() string {
+	if s.config.UpgradeCookie == "" {
+		return pathtoken.DefaultCookie
+	}
+	return s.config.UpgradeCookie
+}
@@ -0,0 +1,239 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sahmadiut/half-tunnel/internal/pathtoken"
+)
+
+// stubTunnel answers 204 so tests can tell tunnel requests from decoy ones.
+var stubTunnel = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusNoContent)
+})
+
+func upgradeRequest(path string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, path, nil)
+	r.Header.Set("Connection", "Upgrade")
+	r.Header.Set("Upgrade", "websocket")
+	return r
+}
+
+func TestHandleTunnelPathTokens(t *testing.T) {
+	s := New(DefaultConfig(), nil)
+	pathTokens, err := pathtoken.New("path-secret", time.Minute)
+	if err != nil {
+		t.Fatalf("Failed to create path tokens: %v", err)
+	}
+	s.pathTokens = pathTokens
+	mux := s.newTunnelMux(tunnelRoute{"/ws/upstream", stubTunnel})
+
+	tests := []struct {
+		name string
+		path string
+		want int
+	}{
+		{"valid token", pathTokens.Path("/ws/upstream", time.Now()), http.StatusNoContent},
+		{"bare path", "/ws/upstream", http.StatusNotFound},
+		{"invalid token", "/ws/upstream/not-a-token", http.StatusNotFound},
+		{"stale token", pathTokens.Path("/ws/upstream", time.Now().Add(-time.Hour)), http.StatusNotFound},
+		{"other path token", pathTokens.Path("/ws/downstream", time.Now()), http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, upgradeRequest(tt.path))
+			if w.Code != tt.want {
+				t.Errorf("Expected status %d for %s, got %d", tt.want, tt.path, w.Code)
+			}
+		})
+	}
+}
+
+func TestHandleTunnelPathWithoutTokens(t *testing.T) {
+	s := New(DefaultConfig(), nil)
+	mux := s.newTunnelMux(tunnelRoute{"/ws/upstream", stubTunnel})
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, upgradeRequest("/ws/upstream"))
+	if w.Code != http.StatusNoContent {
+		t.Errorf("Expected status %d, got %d", http.StatusNoContent, w.Code)
+	}
+
+	// Plain HTTP requests on the tunnel path are not handed to the tunnel
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/ws/upstream", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d, got %d", http.StatusNotFound, w.Code)
+	}
+}
+
+func TestHandleTunnelPathUpgradeCookie(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "404.html"), []byte("not here"), 0644); err != nil {
+		t.Fatalf("Failed to write page: %v", err)
+	}
+
+	s := New(DefaultConfig(), nil)
+	upgradeTokens, err := pathtoken.NewCookie("cookie-secret", time.Minute)
+	if err != nil {
+		t.Fatalf("Failed to create upgrade tokens: %v", err)
+	}
+	s.upgradeTokens = upgradeTokens
+	decoy, err := newDecoyHandler(DecoyConfig{Dir: dir})
+	if err != nil {
+		t.Fatalf("Failed to create decoy: %v", err)
+	}
+	s.decoy = decoy
+	mux := s.newTunnelMux(tunnelRoute{"/ws/upstream", stubTunnel})
+
+	tests := []struct {
+		name   string
+		cookie string
+		want   int
+	}{
+		{"valid cookie", upgradeTokens.Token("/ws/upstream", time.Now()), http.StatusNoContent},
+		{"no cookie", "", http.StatusNotFound},
+		{"invalid cookie", "not-a-token", http.StatusNotFound},
+		{"other path cookie", upgradeTokens.Token("/ws/downstream", time.Now()), http.StatusNotFound},
+	}
+
+	// A rejected upgrade must look exactly like a request for a missing page
+	want := httptest.NewRecorder()
+	mux.ServeHTTP(want, httptest.NewRequest(http.MethodGet, "/ws/upstream", nil))
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := upgradeRequest("/ws/upstream")
+			if tt.cookie != "" {
+				r.AddCookie(&http.Cookie{Name: pathtoken.DefaultCookie, Value: tt.cookie})
+			}
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Errorf("Expected status %d, got %d", tt.want, w.Code)
+			}
+			if tt.want == http.StatusNotFound && w.Body.String() != want.Body.String() {
+				t.Errorf("Expected decoy response %q, got %q", want.Body.String(), w.Body.String())
+			}
+		})
+	}
+}
+
+func TestHandleTunnelPathStaticDecoy(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "index.html"), []byte("<h1>Welcome</h1>"), 0644); err != nil {
+		t.Fatalf("Failed to write index: %v", err)
+	}
+
+	s := New(DefaultConfig(), nil)
+	decoy, err := newDecoyHandler(DecoyConfig{Dir: dir})
+	if err != nil {
+		t.Fatalf("Failed to create decoy: %v", err)
+	}
+	s.decoy = decoy
+	mux := s.newTunnelMux(tunnelRoute{"/ws/upstream", stubTunnel})
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusOK || w.Body.String() != "<h1>Welcome</h1>" {
+		t.Errorf("Expected decoy page, got %d %q", w.Code, w.Body.String())
+	}
+
+	// The tunnel path looks like any other missing page to plain requests
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/ws/upstream", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d, got %d", http.StatusNotFound, w.Code)
+	}
+}
+
+func TestHandleTunnelPathProxyDecoy(t *testing.T) {
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Server", "nginx")
+		_, _ = w.Write([]byte("real site " + r.URL.Path))
+	}))
+	defer site.Close()
+
+	s := New(DefaultConfig(), nil)
+	decoy, err := newDecoyHandler(DecoyConfig{ProxyURL: site.URL})
+	if err != nil {
+		t.Fatalf("Failed to create decoy: %v", err)
+	}
+	s.decoy = decoy
+	mux := s.newTunnelMux(tunnelRoute{"/ws/upstream", stubTunnel})
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/about", nil))
+	if w.Body.String() != "real site /about" || w.Header().Get("Server") != "nginx" {
+		t.Errorf("Expected proxied response, got %q (Server: %q)", w.Body.String(), w.Header().Get("Server"))
+	}
+}
+
+func TestNewDecoyHandlerErrors(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(file, nil, 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		cfg  DecoyConfig
+	}{
+		{"both set", DecoyConfig{Dir: t.TempDir(), ProxyURL: "https://example.com"}},
+		{"missing dir", DecoyConfig{Dir: filepath.Join(t.TempDir(), "missing")}},
+		{"dir is file", DecoyConfig{Dir: file}},
+		{"relative proxy URL", DecoyConfig{ProxyURL: "example.com"}},
+	}
+
+	for _, tt := range tests {
+		if _, err := newDecoyHandler(tt.cfg); err == nil {
+			t.Errorf("%s: expected error", tt.name)
+		}
+	}
+
+	if h, err := newDecoyHandler(DecoyConfig{}); h != nil || err != nil {
+		t.Errorf("Expected no decoy without config, got %v, %v", h, err)
+	}
+}
+
+func TestNewTunnelMuxSinglePort(t *testing.T) {
+	s := New(DefaultConfig(), nil)
+	pathTokens, err := pathtoken.New("path-secret", time.Minute)
+	if err != nil {
+		t.Fatalf("Failed to create path tokens: %v", err)
+	}
+	s.pathTokens = pathTokens
+
+	var got string
+	route := func(direction string) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got = direction
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}
+	mux := s.newTunnelMux(
+		tunnelRoute{"/ws/upstream", route("upstream")},
+		tunnelRoute{"/ws/downstream", route("downstream")},
+	)
+
+	for _, direction := range []string{"upstream", "downstream"} {
+		got = ""
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, upgradeRequest(pathTokens.Path("/ws/"+direction, time.Now())))
+		if w.Code != http.StatusNoContent || got != direction {
+			t.Errorf("Expected %s handler, got %q (status %d)", direction, got, w.Code)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d, got %d", http.StatusNotFound, w.Code)
+	}
+}
@@ -0,0 +1,32 @@
+package server
+
+import (
+	"github.com/google/uuid"
+	"github.com/sahmadiut/half-tunnel/internal/protocol"
+)
+
+// maxPayload returns the largest packet payload the server accepts: the
+// configured size, or the largest that fits a WebSocket message.
+func (s *Server) maxPayload() int {
+	n := protocol.MaxPayloadSize
+	if s.config.MaxPayloadSize > 0 {
+		n = s.config.MaxPayloadSize
+	}
+	if s.config.MaxMessageSize > 0 {
+		n = min(n, s.config.MaxMessageSize-protocol.PacketOverhead)
+	}
+	return n
+}
+
+// sessionMaxPayload returns the largest payload to send to a session's
+// client. Jumbo frames wait until the client uses the compact header.
+func (s *Server) sessionMaxPayload(sessionID uuid.UUID) int {
+	n := protocol.MaxPayloadSize
+	if sess, ok := s.sessionStore.Get(sessionID); ok && sess.MaxPayload() > 0 {
+		n = sess.MaxPayload()
+	}
+	if s.compactIndex(sessionID) == 0 {
+		n = min(n, protocol.MaxPayloadSize)
+	}
+	return n
+}
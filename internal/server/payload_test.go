@@ -0,0 +1,75 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/sahmadiut/half-tunnel/internal/protocol"
+)
+
+func TestMaxPayload(t *testing.T) {
+	tests := []struct {
+		name           string
+		maxPayload     int
+		maxMessageSize int
+		want           int
+	}{
+		{"fits message size", 0, 65536, 65536 - protocol.PacketOverhead},
+		{"large messages", 0, 1 << 20, protocol.MaxPayloadSize},
+		{"configured", 16384, 65536, 16384},
+		{"jumbo", 1 << 20, 2 << 20, 1 << 20},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := DefaultConfig()
+			config.MaxPayloadSize = tt.maxPayload
+			config.MaxMessageSize = tt.maxMessageSize
+			s := New(config, nil)
+			defer s.sessionStore.Close()
+
+			if got := s.maxPayload(); got != tt.want {
+				t.Errorf("Expected %d, got %d", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestJumboFramesNegotiation(t *testing.T) {
+	config := DefaultConfig()
+	config.MaxPayloadSize = 1 << 20
+	config.MaxMessageSize = 2 << 20
+	s := New(config, nil)
+	defer s.sessionStore.Close()
+
+	sessionID := uuid.New()
+	s.sessionStore.GetOrCreate(sessionID)
+	offer := protocol.LocalHello()
+	offer.MaxPayload = 256 << 10
+	hello, _ := protocol.NewHelloPacket(sessionID, offer)
+	data, _ := hello.Marshal()
+	reply, err := s.handleDownstreamPacket(sessionID, data)
+	if err != nil {
+		t.Fatalf("handleDownstreamPacket failed: %v", err)
+	}
+	pkt, _ := protocol.Unmarshal(reply)
+	_, body, _ := protocol.ParseControl(pkt)
+	got, _ := protocol.ParseHello(body)
+	if got.MaxPayload != 256<<10 {
+		t.Errorf("Expected max payload %d, got %d", 256<<10, got.MaxPayload)
+	}
+
+	// Jumbo frames wait for the client to use the compact header
+	if n := s.sessionMaxPayload(sessionID); n != protocol.MaxPayloadSize {
+		t.Errorf("Expected %d before the compact header is in use, got %d", protocol.MaxPayloadSize, n)
+	}
+	ping, _ := protocol.NewPacket(sessionID, 0, protocol.FlagKeepAlive, nil)
+	ping.SessionIndex = got.SessionIndex
+	data, _ = ping.Marshal()
+	if _, err := s.handleDownstreamPacket(sessionID, data); err != nil {
+		t.Fatalf("handleDownstreamPacket failed: %v", err)
+	}
+	if n := s.sessionMaxPayload(sessionID); n != 256<<10 {
+		t.Errorf("Expected %d with the compact header, got %d", 256<<10, n)
+	}
+}
@@ -0,0 +1,174 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/google/uuid"
+	"github.com/sahmadiut/half-tunnel/internal/protocol"
+)
+
+// errBlockedByPolicy is returned when a destination is not allowed by the
+// server's policy.
+var errBlockedByPolicy = errors.New("destination blocked by policy")
+
+// privateNetworks are the address ranges blocked by Policy.BlockPrivate:
+// private (RFC 1918, RFC 4193), loopback, link-local, shared (RFC 6598) and
+// unspecified addresses.
+var privateNetworks = mustParseCIDRs(
+	"0.0.0.0/8",
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"127.0.0.0/8",
+	"169.254.0.0/16",
+	"172.16.0.0/12",
+	"192.168.0.0/16",
+	"::/128",
+	"::1/128",
+	"fc00::/7",
+	"fe80::/10",
+)
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
+
+// Policy decides which destinations clients may connect to. Destinations are
+// checked when a stream opens and again against the resolved address when
+// it is dialed, so names that resolve to blocked addresses are rejected too.
+type Policy struct {
+	// BlockPrivate blocks private, loopback and link-local destinations
+	BlockPrivate bool
+	// AllowedNetworks limits destination addresses to these networks (empty
+	// allows any address)
+	AllowedNetworks []*net.IPNet
+	// BlockedNetworks are destination addresses no client may connect to
+	BlockedNetworks []*net.IPNet
+	// BlockedDomains are destination names, with their subdomains, no
+	// client may connect to
+	BlockedDomains []string
+	// BlockedPorts are destination ports no client may connect to
+	BlockedPorts []uint16
+	// Clients restrict identified clients to the destinations they list
+	Clients []ClientPolicy
+}
+
+// ClientPolicy restricts a client identity to a set of destinations.
+type ClientPolicy struct {
+	// Identity is the client identity the policy applies to
+	Identity string
+	// Allow lists the destinations the client may connect to; the server's
+	// blocks still apply
+	Allow []AccessRule
+}
+
+// Check returns errBlockedByPolicy if the client with identity may not
+// connect to host:port. Names are checked against domain rules only; their
+// addresses are checked with CheckAddr when dialed.
+func (p *Policy) Check(identity, host string, port uint16) error {
+	if p == nil {
+		return nil
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		if err := p.CheckAddr(ip, port); err != nil {
+			return err
+		}
+	} else if containsPort(p.BlockedPorts, port) || matchesDomain(p.BlockedDomains, host) {
+		return errBlockedByPolicy
+	}
+	if client := p.client(identity); client != nil {
+		for i := range client.Allow {
+			if client.Allow[i].Matches(host, port) {
+				return nil
+			}
+		}
+		return errBlockedByPolicy
+	}
+	return nil
+}
+
+// CheckAddr returns errBlockedByPolicy if no client may connect to ip:port.
+func (p *Policy) CheckAddr(ip net.IP, port uint16) error {
+	if p == nil {
+		return nil
+	}
+	if containsPort(p.BlockedPorts, port) || p.blockedIP(ip) {
+		return errBlockedByPolicy
+	}
+	return nil
+}
+
+// blockedIP reports whether the policy blocks ip.
+func (p *Policy) blockedIP(ip net.IP) bool {
+	if len(p.AllowedNetworks) > 0 && !containsIP(p.AllowedNetworks, ip) {
+		return true
+	}
+	if p.BlockPrivate && containsIP(privateNetworks, ip) {
+		return true
+	}
+	return containsIP(p.BlockedNetworks, ip)
+}
+
+func containsIP(networks []*net.IPNet, ip net.IP) bool {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// client returns the policy for identity, or nil if it has none.
+func (p *Policy) client(identity string) *ClientPolicy {
+	if identity == "" {
+		return nil
+	}
+	for i := range p.Clients {
+		if p.Clients[i].Identity == identity {
+			return &p.Clients[i]
+		}
+	}
+	return nil
+}
+
+// checkDialAddr applies the policy to the address a destination socket is
+// about to connect to.
+func (s *Server) checkDialAddr(address string) error {
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if ip == nil || err != nil {
+		return fmt.Errorf("unexpected dial address %q", address)
+	}
+	return s.config.Policy.CheckAddr(ip, uint16(port))
+}
+
+// rejectStream refuses a stream to a destination blocked by the policy,
+// telling the client why before closing the stream. existed reports whether
+// the stream's NAT entry was already closed, which acknowledged the stream.
+func (s *Server) rejectStream(sessionID uuid.UUID, streamID uint32, host string, port uint16, existed bool) {
+	s.log.Warn().
+		Str("session_id", sessionID.String()).
+		Uint32("stream_id", streamID).
+		Str("dest_addr", net.JoinHostPort(host, strconv.Itoa(int(port)))).
+		Msg("Destination blocked by policy")
+	s.recordError("policy_blocked")
+	s.sendStreamError(sessionID, protocol.StreamError{StreamID: streamID, Code: protocol.StreamErrorBlocked})
+	s.sendFin(sessionID, streamID, protocol.Fin{Reason: protocol.ClosePolicy})
+	if !existed {
+		s.sendFinAck(sessionID, streamID)
+	}
+}
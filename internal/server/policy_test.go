@@ -0,0 +1,149 @@
+package server
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sahmadiut/half-tunnel/internal/metrics"
+	"github.com/sahmadiut/half-tunnel/internal/protocol"
+	"github.com/sahmadiut/half-tunnel/internal/socks5"
+)
+
+func TestPolicyCheck(t *testing.T) {
+	_, office, _ := net.ParseCIDR("203.0.113.0/24")
+	_, bad, _ := net.ParseCIDR("198.51.100.128/25")
+	policy := &Policy{
+		BlockPrivate:    true,
+		BlockedNetworks: []*net.IPNet{bad},
+		BlockedDomains:  []string{"blocked.example"},
+		BlockedPorts:    []uint16{25},
+		Clients: []ClientPolicy{{
+			Identity: "laptop",
+			Allow: []AccessRule{
+				{Networks: []*net.IPNet{office}},
+				{Domains: []string{"example.com"}, Ports: []uint16{443}},
+			},
+		}},
+	}
+
+	tests := []struct {
+		identity string
+		host     string
+		port     uint16
+		blocked  bool
+	}{
+		{"", "198.51.100.1", 443, false},
+		{"", "10.1.2.3", 443, true},
+		{"", "192.168.1.1", 80, true},
+		{"", "127.0.0.1", 8080, true},
+		{"", "::1", 8080, true},
+		{"", "fd00::1", 443, true},
+		{"", "::ffff:172.16.0.1", 443, true},
+		{"", "mail.example.net", 25, true},
+		{"", "www.blocked.example", 443, true},
+		{"", "198.51.100.200", 443, true},
+		{"other", "198.51.100.1", 443, false},
+		{"laptop", "203.0.113.9", 22, false},
+		{"laptop", "www.example.com", 443, false},
+		{"laptop", "www.example.com", 80, true},
+		{"laptop", "198.51.100.1", 443, true},
+		{"laptop", "203.0.113.9", 25, true},
+	}
+
+	for _, tt := range tests {
+		err := policy.Check(tt.identity, tt.host, tt.port)
+		if blocked := err == errBlockedByPolicy; blocked != tt.blocked {
+			t.Errorf("Check(%q, %s, %d) = %v, want blocked %v", tt.identity, tt.host, tt.port, err, tt.blocked)
+		}
+	}
+
+	var none *Policy
+	if err := none.Check("", "10.0.0.1", 25); err != nil {
+		t.Errorf("Expected a nil policy to allow everything, got %v", err)
+	}
+	if err := (&Policy{}).CheckAddr(net.ParseIP("127.0.0.1"), 80); err != nil {
+		t.Errorf("Expected private addresses allowed without BlockPrivate, got %v", err)
+	}
+	allowed := &Policy{AllowedNetworks: []*net.IPNet{office}}
+	if allowed.CheckAddr(net.ParseIP("203.0.113.1"), 80) != nil || allowed.CheckAddr(net.ParseIP("198.51.100.1"), 80) == nil {
+		t.Error("Expected only addresses in AllowedNetworks to be allowed")
+	}
+}
+
+func TestPolicyBlocksStream(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	defer ln.Close()
+	accepted := make(chan struct{}, 2)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+			accepted <- struct{}{}
+		}
+	}()
+
+	config := DefaultConfig()
+	config.Metrics = metrics.NewCollector()
+	config.Policy = &Policy{BlockPrivate: true}
+	s := New(config, nil)
+	defer s.sessionStore.Close()
+
+	// A literal private address is rejected before dialing, and a name
+	// resolving to one when it is dialed
+	port := uint16(ln.Addr().(*net.TCPAddr).Port)
+	named := []byte{socks5.AddrTypeDomain, byte(len("localhost"))}
+	named = append(named, "localhost"...)
+	named = binary.BigEndian.AppendUint16(named, port)
+
+	sessionID := uuid.New()
+	for streamID, payload := range map[uint32][]byte{1: connectPayload(t, ln.Addr()), 2: named} {
+		open, _ := protocol.NewPacket(sessionID, streamID, protocol.FlagHandshake|protocol.FlagData, payload)
+		s.handleUpstreamPacket(context.Background(), open)
+	}
+	s.wg.Wait()
+
+	if n := s.GetNatEntryCount(); n != 0 {
+		t.Errorf("Expected blocked streams to be closed, got %d", n)
+	}
+	if got := testutil.ToFloat64(config.Metrics.Errors.WithLabelValues("policy_blocked")); got != 2 {
+		t.Errorf("Expected 2 streams blocked by policy, got %v", got)
+	}
+	select {
+	case <-accepted:
+		t.Error("Expected no connection to the blocked destination")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestPolicyClientIdentity(t *testing.T) {
+	config := DefaultConfig()
+	config.Metrics = metrics.NewCollector()
+	config.Policy = &Policy{Clients: []ClientPolicy{{
+		Identity: "restricted",
+		Allow:    []AccessRule{{Domains: []string{"example.com"}}},
+	}}}
+	s := New(config, nil)
+	defer s.sessionStore.Close()
+
+	sessionID := uuid.New()
+	s.sessionStore.GetOrCreate(sessionID).SetIdentity("restricted")
+	open, _ := protocol.NewPacket(sessionID, 1, protocol.FlagHandshake|protocol.FlagData,
+		connectPayload(t, &net.TCPAddr{IP: net.ParseIP("198.51.100.1"), Port: 443}))
+	s.handleUpstreamPacket(context.Background(), open)
+	s.wg.Wait()
+
+	if got := testutil.ToFloat64(config.Metrics.Errors.WithLabelValues("policy_blocked")); got != 1 {
+		t.Errorf("Expected the destination outside the allow-list blocked, got %v", got)
+	}
+}
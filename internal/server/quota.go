@@ -0,0 +1,103 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/sahmadiut/half-tunnel/internal/admin"
+	"github.com/sahmadiut/half-tunnel/internal/notify"
+	"github.com/sahmadiut/half-tunnel/internal/protocol"
+	"github.com/sahmadiut/half-tunnel/internal/quota"
+)
+
+// overQuota reports whether the client has used up its traffic quota.
+func (s *Server) overQuota(identity string) bool {
+	return s.config.Quotas != nil && identity != "" && s.config.Quotas.Exceeded(identity) != ""
+}
+
+// refuseOverQuota closes a stream whose client has used up its traffic
+// quota, telling the client why.
+func (s *Server) refuseOverQuota(sessionID uuid.UUID, streamID uint32, identity string) {
+	period := s.config.Quotas.Exceeded(identity)
+	s.log.Warn().
+		Str("session_id", sessionID.String()).
+		Uint32("stream_id", streamID).
+		Str("client", identity).
+		Str("period", period).
+		Msg("Client traffic quota used up, refusing stream")
+	s.recordError("quota_exceeded")
+	s.config.Notifier.Notify(notify.EventQuotaExceeded, identity, "Client traffic quota used up", map[string]string{
+		"client": identity,
+		"period": period,
+	})
+	fin := protocol.Fin{Reason: protocol.CloseQuota, Message: period}
+	existed := s.closeNatEntry(sessionID, streamID, streamCloseQuota, fin)
+	s.sendStreamError(sessionID, protocol.StreamError{StreamID: streamID, Code: protocol.StreamErrorQuotaExceeded})
+	s.sendFin(sessionID, streamID, fin)
+	if !existed {
+		s.sendFinAck(sessionID, streamID)
+	}
+}
+
+// waitClientRate delays n bytes of a stream's traffic until its client's rate
+// cap allows them. It returns false if ctx is done or the server shuts down
+// first.
+func (s *Server) waitClientRate(ctx context.Context, entry *natEntry, n int) bool {
+	if s.config.Quotas == nil || entry.identity == "" {
+		return true
+	}
+	delay := s.config.Quotas.Reserve(entry.identity, n)
+	if delay <= 0 {
+		return true
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	case <-s.shutdown:
+		return false
+	}
+}
+
+// Quotas returns every client's traffic quota and usage for the admin API.
+func (s *Server) Quotas() []admin.Quota {
+	quotas := []admin.Quota{}
+	if s.config.Quotas == nil {
+		return quotas
+	}
+	for _, st := range s.config.Quotas.Snapshot() {
+		quotas = append(quotas, admin.Quota{
+			Client:       st.Client,
+			DailyLimit:   st.Limits.Daily,
+			MonthlyLimit: st.Limits.Monthly,
+			RateLimit:    st.Limits.Rate,
+			Day:          st.Day,
+			DayBytes:     st.DayBytes,
+			Month:        st.Month,
+			MonthBytes:   st.MonthBytes,
+			Exceeded:     st.Exceeded,
+		})
+	}
+	return quotas
+}
+
+// ResetQuota clears a client's traffic usage for the current day and month.
+func (s *Server) ResetQuota(client string) error {
+	if s.config.Quotas == nil {
+		return admin.ErrClientNotFound
+	}
+	if err := s.config.Quotas.Reset(client); err != nil {
+		if errors.Is(err, quota.ErrUnknownClient) {
+			return admin.ErrClientNotFound
+		}
+		return err
+	}
+	s.log.Info().Str("client", client).Msg("Client traffic quota reset")
+	return nil
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sahmadiut/half-tunnel/internal/admin"
+	"github.com/sahmadiut/half-tunnel/internal/metrics"
+	"github.com/sahmadiut/half-tunnel/internal/protocol"
+	"github.com/sahmadiut/half-tunnel/internal/quota"
+)
+
+func TestClientQuota(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	tracker, err := quota.New(filepath.Join(t.TempDir(), "quotas.json"), map[string]quota.Limits{
+		"laptop": {Daily: 10},
+	}, quota.Limits{})
+	if err != nil {
+		t.Fatalf("Failed to create tracker: %v", err)
+	}
+
+	config := DefaultConfig()
+	config.Metrics = metrics.NewCollector()
+	config.Quotas = tracker
+	s := New(config, nil)
+	defer s.sessionStore.Close()
+
+	sessionID := uuid.New()
+	s.sessionStore.GetOrCreate(sessionID).SetIdentity("laptop")
+
+	// The stream carrying the client past its quota is closed
+	open, _ := protocol.NewPacket(sessionID, 1, protocol.FlagHandshake|protocol.FlagData, connectPayload(t, ln.Addr()))
+	s.handleUpstreamPacket(context.Background(), open)
+	data, _ := protocol.NewPacket(sessionID, 1, protocol.FlagData, make([]byte, 20))
+	s.handleUpstreamPacket(context.Background(), data)
+	s.wg.Wait()
+
+	if n := s.GetNatEntryCount(); n != 0 {
+		t.Errorf("Expected the stream over quota closed, got %d entries", n)
+	}
+
+	// New streams are refused until the quota is reset
+	open, _ = protocol.NewPacket(sessionID, 2, protocol.FlagHandshake|protocol.FlagData, connectPayload(t, ln.Addr()))
+	s.handleUpstreamPacket(context.Background(), open)
+	if n := s.GetNatEntryCount(); n != 0 {
+		t.Errorf("Expected the new stream refused, got %d entries", n)
+	}
+	if got := testutil.ToFloat64(config.Metrics.Errors.WithLabelValues("quota_exceeded")); got != 2 {
+		t.Errorf("Expected 2 streams refused over quota, got %v", got)
+	}
+
+	quotas := s.Quotas()
+	if len(quotas) != 1 || quotas[0].DayBytes != 20 || quotas[0].Exceeded != quota.PeriodDaily {
+		t.Errorf("Expected laptop over its daily quota, got %+v", quotas)
+	}
+	if err := s.ResetQuota("laptop"); err != nil {
+		t.Fatalf("ResetQuota failed: %v", err)
+	}
+	if err := s.ResetQuota("phone"); err != admin.ErrClientNotFound {
+		t.Errorf("Expected ErrClientNotFound, got %v", err)
+	}
+
+	open, _ = protocol.NewPacket(sessionID, 3, protocol.FlagHandshake|protocol.FlagData, connectPayload(t, ln.Addr()))
+	s.handleUpstreamPacket(context.Background(), open)
+	if n := s.GetNatEntryCount(); n != 1 {
+		t.Errorf("Expected a stream allowed after the reset, got %d entries", n)
+	}
+	s.closeNatEntry(sessionID, 3, streamCloseClient, protocol.Fin{Reason: protocol.CloseEOF})
+	s.wg.Wait()
+}
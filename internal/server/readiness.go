@@ -0,0 +1,48 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/sahmadiut/half-tunnel/internal/health"
+)
+
+// HealthChecks returns the server's readiness checks. The server is ready
+// once both tunnel listeners are bound and serving.
+func (s *Server) HealthChecks() map[string]health.Check {
+	return map[string]health.Check{
+		"upstream_listener": func(ctx context.Context) error {
+			return s.checkListening("upstream")
+		},
+		"downstream_listener": func(ctx context.Context) error {
+			return s.checkListening("downstream")
+		},
+	}
+}
+
+// listening returns the listener state flag for direction. In single-port
+// mode the upstream listener serves both directions.
+func (s *Server) listening(direction string) *int32 {
+	if direction == "downstream" && !s.singlePort() {
+		return &s.downstreamListening
+	}
+	return &s.upstreamListening
+}
+
+// setListening records whether the listener for direction is serving.
+func (s *Server) setListening(direction string, listening bool) {
+	var v int32
+	if listening {
+		v = 1
+	}
+	atomic.StoreInt32(s.listening(direction), v)
+}
+
+// checkListening returns an error unless the listener for direction is serving.
+func (s *Server) checkListening(direction string) error {
+	if atomic.LoadInt32(s.listening(direction)) == 0 {
+		return fmt.Errorf("%s listener not bound", direction)
+	}
+	return nil
+}
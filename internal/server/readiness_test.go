@@ -0,0 +1,54 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestHealthChecksFollowListeners(t *testing.T) {
+	config := DefaultConfig()
+	config.UpstreamAddr = "127.0.0.1:0"
+	config.DownstreamAddr = "127.0.0.1:0"
+	server := New(config, nil)
+	checks := server.HealthChecks()
+
+	for name, check := range checks {
+		if err := check(context.Background()); err == nil {
+			t.Errorf("Expected %s to fail before start", name)
+		}
+	}
+
+	if err := server.Start(context.Background()); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	for name, check := range checks {
+		if err := check(context.Background()); err != nil {
+			t.Errorf("Expected %s to pass after start, got %v", name, err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := server.Stop(ctx); err != nil {
+		t.Fatalf("Failed to stop server: %v", err)
+	}
+	for name, check := range checks {
+		if err := check(context.Background()); err == nil {
+			t.Errorf("Expected %s to fail after stop", name)
+		}
+	}
+}
+
+func TestHealthChecksSeparateListeners(t *testing.T) {
+	server := New(DefaultConfig(), nil)
+	checks := server.HealthChecks()
+
+	server.setListening("upstream", true)
+	if err := checks["upstream_listener"](context.Background()); err != nil {
+		t.Errorf("Expected upstream listener to be ready, got %v", err)
+	}
+	if err := checks["downstream_listener"](context.Background()); err == nil {
+		t.Error("Expected downstream listener not to be ready")
+	}
+}
@@ -14,15 +14,27 @@ import (
 	"io"
 	"net"
 	"net/http"
+	"os"
+	"strconv"
 	"sync"
 	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/google/uuid"
-	"github.com/sahmadiut/half-tunnel/internal/constants"
+	"github.com/sahmadiut/half-tunnel/internal/admin"
+	"github.com/sahmadiut/half-tunnel/internal/audit"
+	"github.com/sahmadiut/half-tunnel/internal/banlist"
+	"github.com/sahmadiut/half-tunnel/internal/circuitbreaker"
+	"github.com/sahmadiut/half-tunnel/internal/metrics"
+	"github.com/sahmadiut/half-tunnel/internal/mux"
+	"github.com/sahmadiut/half-tunnel/internal/notify"
+	"github.com/sahmadiut/half-tunnel/internal/pathtoken"
 	"github.com/sahmadiut/half-tunnel/internal/protocol"
+	"github.com/sahmadiut/half-tunnel/internal/quota"
+	"github.com/sahmadiut/half-tunnel/internal/resolver"
 	"github.com/sahmadiut/half-tunnel/internal/session"
+	"github.com/sahmadiut/half-tunnel/internal/sockopt"
 	"github.com/sahmadiut/half-tunnel/internal/socks5"
 	"github.com/sahmadiut/half-tunnel/internal/transport"
 	"github.com/sahmadiut/half-tunnel/pkg/logger"
@@ -32,26 +44,147 @@ import (
 type Config struct {
 	// UpstreamAddr is the address to listen for upstream connections (Domain A)
 	UpstreamAddr string
+	// UpstreamFamily restricts the upstream listener to IPv4 or IPv6
+	// (empty listens dual-stack)
+	UpstreamFamily sockopt.Family
 	// UpstreamPath is the WebSocket path for upstream connections
 	UpstreamPath string
 	// UpstreamTLS holds TLS settings for upstream server
 	UpstreamTLS TLSConfig
 	// DownstreamAddr is the address to listen for downstream connections (Domain B)
 	DownstreamAddr string
+	// DownstreamFamily restricts the downstream listener to IPv4 or IPv6
+	// (empty listens dual-stack)
+	DownstreamFamily sockopt.Family
 	// DownstreamPath is the WebSocket path for downstream connections
 	DownstreamPath string
 	// DownstreamTLS holds TLS settings for downstream server
 	DownstreamTLS TLSConfig
+	// PathSecret enables rotating HMAC tokens appended to the WebSocket paths
+	PathSecret string
+	// PathWindow is how often path tokens rotate
+	PathWindow time.Duration
+	// UpgradeSecret requires a cookie with a token derived from this secret
+	// on WebSocket upgrades; requests without it get the decoy
+	UpgradeSecret string
+	// UpgradeWindow is how often upgrade cookie tokens rotate
+	UpgradeWindow time.Duration
+	// UpgradeCookie is the name of the upgrade cookie
+	UpgradeCookie string
+	// Decoy selects what non-tunnel HTTP requests are served
+	Decoy DecoyConfig
 	// ExitOnPortInUse controls whether to stop when listener ports are already in use
 	ExitOnPortInUse bool
 	// Session settings
 	SessionTimeout time.Duration
 	MaxSessions    int
+	// SessionEviction selects how room is made for new sessions at MaxSessions
+	SessionEviction session.EvictionPolicy
+	// EvictIdleAfter is how long a session must be idle before the idle
+	// policy evicts it
+	EvictIdleAfter time.Duration
 	// Connection settings
 	ReadBufferSize  int
 	WriteBufferSize int
 	MaxMessageSize  int
-	DialTimeout     time.Duration
+	// MaxPayloadSize is the largest packet payload offered to clients; 0
+	// offers the largest that fits MaxMessageSize, up to
+	// protocol.MaxPayloadSize
+	MaxPayloadSize int
+	// ResetCorruptStreams resets the stream of a packet with a checksum
+	// mismatch instead of only dropping the packet
+	ResetCorruptStreams bool
+	DialTimeout         time.Duration
+	// SlowDialThreshold triggers a warning when the p95 destination dial
+	// duration for a port class exceeds it (0 disables warnings)
+	SlowDialThreshold time.Duration
+	// MaxConcurrentDials bounds destination dials in progress across all
+	// sessions (0 means no limit)
+	MaxConcurrentDials int
+	// AcceptQueueSize is the number of upgraded connections per tunnel path
+	// that may wait to be served; further upgrades get 503 (0 uses the
+	// transport default)
+	AcceptQueueSize int
+	// SendQueueSize is the number of messages queued per tunnel connection
+	// for its single writer; writes wait while it is full (0 writes
+	// directly)
+	SendQueueSize int
+	// Faults injects faults into the messages sent to clients, for tests
+	// (nil sends them faithfully)
+	Faults *transport.FaultConfig
+	// MaxConnectionsPerIP caps the open tunnel connections per source IP,
+	// upstream and downstream together; further upgrades get 429 (0 means
+	// no cap)
+	MaxConnectionsPerIP int
+	// ConnectionLimitExempt holds sources MaxConnectionsPerIP does not
+	// apply to, such as reverse proxies
+	ConnectionLimitExempt []*net.IPNet
+	// DialRetry is the default destination dial retry policy
+	DialRetry DialRetryPolicy
+	// AccessRules override settings for matching destinations; the first
+	// matching rule that sets an override wins
+	AccessRules []AccessRule
+	// Policy restricts the destinations clients may connect to (nil allows
+	// all destinations)
+	Policy *Policy
+	// BindAddress is the source address for destination connections
+	// (optional)
+	BindAddress net.IP
+	// Interface binds destination connections to a network interface
+	// (optional, Linux only)
+	Interface string
+	// Mark is the packet mark (fwmark) set on destination connections for
+	// policy routing and firewall rules (0 disables it, Linux only)
+	Mark uint32
+	// TCP sets socket options on tunnel and destination connections (nil
+	// keeps the OS defaults)
+	TCP *sockopt.TCPConfig
+	// DNS configures destination name resolution (nil uses the system
+	// resolver)
+	DNS *resolver.Config
+	// DestinationBreaker fails streams to repeatedly failing destinations
+	// fast (nil disables it)
+	DestinationBreaker *circuitbreaker.Config
+	// Stall closes sessions whose downstream keeps failing while the client
+	// still sends upstream data (nil disables it)
+	Stall *StallConfig
+	// Liveness probes the destinations of idle streams and closes those
+	// that do not answer (nil disables it)
+	Liveness *LivenessConfig
+	// Guard refuses new streams while the server is close to a resource
+	// ceiling (nil disables it)
+	Guard *GuardConfig
+	// Diagnostics serves streams to the diag package's echo, discard and
+	// chargen targets in the server instead of dialing them
+	Diagnostics bool
+	// Metrics receives Prometheus metrics (optional)
+	Metrics *metrics.Collector
+	// Hooks are called on session and stream events (optional)
+	Hooks *Hooks
+	// Guest holds settings for time-limited guest sessions
+	Guest GuestConfig
+	// ClientAuth holds settings for identifying clients
+	ClientAuth ClientAuthConfig
+	// Audit records every stream opened and closed (nil disables it)
+	Audit *audit.Logger
+	// Quotas enforces identified clients' traffic quotas and rate caps (nil
+	// disables them). The caller starts and stops its state file flushing.
+	Quotas *quota.Tracker
+	// Bans rate limits WebSocket upgrade attempts per source IP and bans
+	// sources that exceed the limits or fail too many handshakes (nil
+	// disables them). The caller starts and stops its state file flushing.
+	Bans *banlist.List
+	// Cluster shares sessions with peer servers (optional)
+	Cluster ClusterConfig
+	// Name identifies this server to the session backend
+	Name string
+	// SessionBackend shares session and NAT state with other instances (optional)
+	SessionBackend session.Backend
+	// Notifier sends notifications of critical events (optional)
+	Notifier *notify.Notifier
+	// CertExpiryWarning is how long before a TLS certificate expires the
+	// Notifier is told about it (0 disables the check)
+	CertExpiryWarning time.Duration
 }
 
 // TLSConfig holds TLS certificate settings.
@@ -59,24 +192,39 @@ type TLSConfig struct {
 	Enabled  bool
 	CertFile string
 	KeyFile  string
+	// ClientCAFile enables verification of client certificates (mTLS)
+	ClientCAFile string
+	// RequireClientCert rejects clients that present no valid certificate
+	RequireClientCert bool
 }
 
 // DefaultConfig returns default server configuration.
 func DefaultConfig() *Config {
 	return &Config{
-		UpstreamAddr:    ":8080",
-		UpstreamPath:    "/upstream",
-		DownstreamAddr:  ":8081",
-		DownstreamPath:  "/downstream",
-		UpstreamTLS:     TLSConfig{},
-		DownstreamTLS:   TLSConfig{},
-		ExitOnPortInUse: false,
-		SessionTimeout:  5 * time.Minute,
-		MaxSessions:     1000,
-		ReadBufferSize:  32768,
-		WriteBufferSize: 32768,
-		MaxMessageSize:  65536,
-		DialTimeout:     10 * time.Second,
+		UpstreamAddr:        ":8080",
+		UpstreamPath:        "/upstream",
+		DownstreamAddr:      ":8081",
+		DownstreamPath:      "/downstream",
+		UpstreamTLS:         TLSConfig{},
+		DownstreamTLS:       TLSConfig{},
+		ExitOnPortInUse:     false,
+		SessionTimeout:      5 * time.Minute,
+		MaxSessions:         1000,
+		SessionEviction:     session.EvictNone,
+		EvictIdleAfter:      10 * time.Minute,
+		ReadBufferSize:      32768,
+		WriteBufferSize:     32768,
+		MaxMessageSize:      65536,
+		ResetCorruptStreams: true,
+		DialTimeout:         10 * time.Second,
+		SlowDialThreshold:   2 * time.Second,
+		MaxConcurrentDials:  256,
+		SendQueueSize:       256,
+		DialRetry:           DefaultDialRetryPolicy(),
+		DestinationBreaker:  circuitbreaker.DefaultConfig(),
+		Stall:               DefaultStallConfig(),
+		Guard:               DefaultGuardConfig(),
+		Guest:               DefaultGuestConfig(),
 	}
 }
 
@@ -94,22 +242,76 @@ type Server struct {
 	upstreamServer   *http.Server
 	downstreamServer *http.Server
 
+	// Path token verification (nil when disabled)
+	pathTokens *pathtoken.Generator
+
+	// Upgrade cookie verification (nil when disabled)
+	upgradeTokens *pathtoken.Generator
+
+	// Handler for non-tunnel requests (nil serves 404)
+	decoy http.Handler
+
+	// TLS certificates served, checked for expiry
+	certs []*CertReloader
+
 	// Session to downstream connection mapping
 	downstreamConns   map[uuid.UUID]*transport.Connection
 	downstreamConnsMu sync.RWMutex
 
+	// Compact header indexes of sessions that negotiated them
+	sessionIndexes  map[uuid.UUID]*sessionIndex
+	sessionsByIndex map[uint32]*sessionIndex
+	sessionIndexMu  sync.RWMutex
+
 	// Stream to destination connection mapping (NAT table)
 	natTable   map[natKey]*natEntry
 	natTableMu sync.RWMutex
 
+	// Recently closed streams, oldest first, for the admin API
+	closedStreams   []admin.ClosedStream
+	closedStreamsMu sync.Mutex
+
+	// Data flow of each session, for stall detection
+	flows   map[uuid.UUID]*sessionFlow
+	flowsMu sync.RWMutex
+
+	// Guest sessions and per-token traffic usage
+	guestSessions map[uuid.UUID]*guestSession
+	guestUsage    map[string]*guestUsage
+	guestMu       sync.RWMutex
+
 	// Connection metrics
 	metrics   ConnectionMetrics
 	metricsMu sync.RWMutex
 
+	// Destination dial statistics
+	dialStats *dialStats
+
+	// Semaphore bounding concurrent destination dials (nil for no limit)
+	dialSlots chan struct{}
+
+	// Per-destination circuit breakers (nil when disabled)
+	breakers *circuitbreaker.DestinationBreaker
+
+	// Resource guard shedding new streams near resource ceilings (nil
+	// when disabled)
+	guard *resourceGuard
+
+	// Destination resolver (nil uses the system resolver)
+	resolver *resolver.Resolver
+
+	// In-process handlers of destinations, registered with Handle
+	streamHandlers streamHandlers
+
+	// Listener state for readiness checks
+	upstreamListening   int32
+	downstreamListening int32
+
 	// State
-	running  int32
-	shutdown chan struct{}
-	wg       sync.WaitGroup
+	createdAt time.Time
+	running   int32
+	shutdown  chan struct{}
+	wg        sync.WaitGroup
 }
 
 // natKey uniquely identifies a stream within a session.
@@ -118,11 +320,33 @@ type natKey struct {
 	StreamID  uint32
 }
 
-// natEntry holds the destination connection for a stream.
+// natEntry holds the destination connection for a stream. conn is nil
+// while the destination is being dialed; data for the stream is queued in
+// pending until the dial completes.
 type natEntry struct {
-	conn     net.Conn
-	destAddr string
-	created  time.Time
+	conn      net.Conn
+	destAddr  string
+	identity  string // client identity of the session, if known
+	created   time.Time
+	bytesUp   int64 // bytes written to the destination, updated atomically
+	bytesDown int64 // bytes read from the destination, updated atomically
+
+	// stream holds the stream's packet counters in the session (nil in
+	// entries made by tests)
+	stream *session.Stream
+
+	// writeClosed is set once the client finished sending, updated atomically
+	writeClosed int32
+
+	// probedActivity is the stream's last activity when liveness probes of
+	// the destination started, in Unix nanoseconds (0 while not probing),
+	// updated atomically
+	probedActivity int64
+
+	mu           sync.Mutex
+	pending      [][]byte
+	pendingBytes int
+	closed       bool
 }
 
 // ConnectionMetrics holds metrics for monitoring data transfer.
@@ -142,14 +366,47 @@ func New(config *Config, log *logger.Logger) *Server {
 		log = logger.NewDefault()
 	}
 
-	return &Server{
+	s := &Server{
 		config:          config,
 		log:             log,
 		sessionStore:    session.NewStore(config.SessionTimeout),
 		downstreamConns: make(map[uuid.UUID]*transport.Connection),
+		sessionIndexes:  make(map[uuid.UUID]*sessionIndex),
+		sessionsByIndex: make(map[uint32]*sessionIndex),
 		natTable:        make(map[natKey]*natEntry),
+		flows:           make(map[uuid.UUID]*sessionFlow),
+		guestSessions:   make(map[uuid.UUID]*guestSession),
+		guestUsage:      make(map[string]*guestUsage),
+		dialStats:       newDialStats(config.SlowDialThreshold),
+		createdAt:       time.Now(),
 		shutdown:        make(chan struct{}),
 	}
+
+	if config.MaxConcurrentDials > 0 {
+		s.dialSlots = make(chan struct{}, config.MaxConcurrentDials)
+	}
+	s.breakers = s.newDestinationBreaker()
+	s.resolver = s.newResolver()
+	s.guard = s.newResourceGuard()
+
+	s.sessionStore.SetLimit(session.Limit{
+		MaxSessions: config.MaxSessions,
+		Policy:      config.SessionEviction,
+		IdleAfter:   config.EvictIdleAfter,
+	}, s.evictSession)
+	s.sessionStore.SetExpiryHandler(s.expireSession)
+
+	if config.Metrics != nil {
+		config.Metrics.SetMaxSessions(config.MaxSessions)
+	}
+	if config.Metrics != nil || config.Hooks != nil {
+		s.sessionStore.SetCallbacks(s.sessionCallbacks())
+	}
+	if config.SessionBackend != nil {
+		s.sessionStore.SetBackend(config.SessionBackend, config.Name)
+	}
+
+	return s
 }
 
 // Start starts the server.
@@ -159,36 +416,116 @@ func (s *Server) Start(ctx context.Context) error {
 	}
 
 	transportConfig := &transport.ServerConfig{
-		ReadBufferSize:   s.config.ReadBufferSize,
-		WriteBufferSize:  s.config.WriteBufferSize,
-		MaxMessageSize:   int64(s.config.MaxMessageSize),
-		HandshakeTimeout: s.config.DialTimeout,
+		ReadBufferSize:    s.config.ReadBufferSize,
+		WriteBufferSize:   s.config.WriteBufferSize,
+		MaxMessageSize:    int64(s.config.MaxMessageSize),
+		ChannelBufferSize: s.config.AcceptQueueSize,
+		HandshakeTimeout:  s.config.DialTimeout,
+		SendQueueSize:     s.config.SendQueueSize,
+		Faults:            s.config.Faults,
+	}
+	if s.config.MaxConnectionsPerIP > 0 {
+		// Shared by both handlers, so the cap covers both directions
+		transportConfig.Sources = transport.NewSourceLimiter(s.config.MaxConnectionsPerIP, s.config.ConnectionLimitExempt)
 	}
 
 	// Create upstream handler
-	s.upstreamHandler = transport.NewServerHandler(transportConfig, s.log.WithStr("direction", "upstream"))
+	s.upstreamHandler = transport.NewServerHandler(s.directionTransportConfig(transportConfig, "upstream"), s.log.Component("transport").WithStr("direction", "upstream"))
+	s.upstreamHandler.SetOnDrop(s.connectionDropped("upstream"))
 
 	// Create downstream handler
-	s.downstreamHandler = transport.NewServerHandler(transportConfig, s.log.WithStr("direction", "downstream"))
+	s.downstreamHandler = transport.NewServerHandler(s.directionTransportConfig(transportConfig, "downstream"), s.log.Component("transport").WithStr("direction", "downstream"))
+	s.downstreamHandler.SetOnDrop(s.connectionDropped("downstream"))
+
+	if s.config.PathSecret != "" {
+		pathTokens, err := pathtoken.New(s.config.PathSecret, s.config.PathWindow)
+		if err != nil {
+			return fmt.Errorf("failed to set up path tokens: %w", err)
+		}
+		s.pathTokens = pathTokens
+	}
+	if s.config.UpgradeSecret != "" {
+		upgradeTokens, err := pathtoken.NewCookie(s.config.UpgradeSecret, s.config.UpgradeWindow)
+		if err != nil {
+			return fmt.Errorf("failed to set up upgrade cookies: %w", err)
+		}
+		s.upgradeTokens = upgradeTokens
+	}
+
+	decoy, err := newDecoyHandler(s.config.Decoy)
+	if err != nil {
+		return fmt.Errorf("failed to set up decoy site: %w", err)
+	}
+	s.decoy = decoy
+
+	if err := s.checkEgress(); err != nil {
+		return fmt.Errorf("invalid egress settings: %w", err)
+	}
+
+	if s.clusterEnabled() {
+		if s.config.Cluster.Secret == "" {
+			return fmt.Errorf("cluster peers require a cluster secret")
+		}
+		if s.config.Cluster.InsecureSkipVerify {
+			s.log.Warn().Msg("Cluster peer certificates are not verified: anyone between the nodes can impersonate a peer and read relayed sessions")
+		}
+	}
+
+	// Upstream and downstream on the same address share one listener and are
+	// told apart by path, for deployments that can only expose one port
+	singlePort := s.singlePort()
+	if singlePort && s.config.UpstreamPath == s.config.DownstreamPath {
+		return fmt.Errorf("upstream and downstream share %s but have the same path %s", s.config.UpstreamAddr, s.config.UpstreamPath)
+	}
 
 	// Set up upstream HTTP server
-	upstreamMux := http.NewServeMux()
-	upstreamMux.Handle(s.config.UpstreamPath, s.upstreamHandler)
+	upstreamRoutes := []tunnelRoute{{s.config.UpstreamPath, s.upstreamHandler}}
+	if singlePort {
+		upstreamRoutes = append(upstreamRoutes, tunnelRoute{s.config.DownstreamPath, s.downstreamHandler})
+	}
 	s.upstreamServer = &http.Server{
 		Addr:    s.config.UpstreamAddr,
-		Handler: upstreamMux,
+		Handler: s.acceptRelays(s.config.UpstreamTLS, s.newTunnelMux(upstreamRoutes...)),
 	}
 
 	// Set up downstream HTTP server
-	downstreamMux := http.NewServeMux()
-	downstreamMux.Handle(s.config.DownstreamPath, s.downstreamHandler)
-	s.downstreamServer = &http.Server{
-		Addr:    s.config.DownstreamAddr,
-		Handler: downstreamMux,
+	if !singlePort {
+		s.downstreamServer = &http.Server{
+			Addr:    s.config.DownstreamAddr,
+			Handler: s.acceptRelays(s.config.DownstreamTLS, s.newTunnelMux(tunnelRoute{s.config.DownstreamPath, s.downstreamHandler})),
+		}
+	}
+
+	// Load TLS certificates; they are reloaded from disk when renewed
+	if s.config.UpstreamTLS.Enabled {
+		reloader, err := NewCertReloader(s.config.UpstreamTLS.CertFile, s.config.UpstreamTLS.KeyFile, s.log.Component("tls").WithStr("direction", "upstream"))
+		if err != nil {
+			return fmt.Errorf("failed to load upstream TLS certificate: %w", err)
+		}
+		s.certs = append(s.certs, reloader)
+		tlsConfig := reloader.TLSConfig()
+		if err := configureClientAuth(tlsConfig, s.config.UpstreamTLS); err != nil {
+			return fmt.Errorf("failed to configure upstream client authentication: %w", err)
+		}
+		s.allowRelays(tlsConfig)
+		s.upstreamServer.TLSConfig = tlsConfig
+	}
+	if s.config.DownstreamTLS.Enabled && !singlePort {
+		reloader, err := NewCertReloader(s.config.DownstreamTLS.CertFile, s.config.DownstreamTLS.KeyFile, s.log.Component("tls").WithStr("direction", "downstream"))
+		if err != nil {
+			return fmt.Errorf("failed to load downstream TLS certificate: %w", err)
+		}
+		s.certs = append(s.certs, reloader)
+		tlsConfig := reloader.TLSConfig()
+		if err := configureClientAuth(tlsConfig, s.config.DownstreamTLS); err != nil {
+			return fmt.Errorf("failed to configure downstream client authentication: %w", err)
+		}
+		s.allowRelays(tlsConfig)
+		s.downstreamServer.TLSConfig = tlsConfig
 	}
 
 	// Start upstream server
-	upstreamListener, upstreamErr := net.Listen("tcp", s.config.UpstreamAddr)
+	upstreamListener, upstreamErr := sockopt.Listen(s.config.UpstreamFamily, s.config.UpstreamAddr)
 	if upstreamErr != nil {
 		if s.shouldExitOnListenError(upstreamErr) {
 			return fmt.Errorf("failed to listen on upstream %s: %w", s.config.UpstreamAddr, upstreamErr)
@@ -196,33 +533,41 @@ func (s *Server) Start(ctx context.Context) error {
 		s.log.Error().Err(upstreamErr).Str("addr", s.config.UpstreamAddr).Msg("Failed to start upstream listener")
 	}
 
-	downstreamListener, downstreamErr := net.Listen("tcp", s.config.DownstreamAddr)
-	if downstreamErr != nil {
-		if s.shouldExitOnListenError(downstreamErr) {
-			if upstreamListener != nil {
-				_ = upstreamListener.Close()
+	var downstreamListener net.Listener
+	if !singlePort {
+		var downstreamErr error
+		downstreamListener, downstreamErr = sockopt.Listen(s.config.DownstreamFamily, s.config.DownstreamAddr)
+		if downstreamErr != nil {
+			if s.shouldExitOnListenError(downstreamErr) {
+				if upstreamListener != nil {
+					_ = upstreamListener.Close()
+				}
+				return fmt.Errorf("failed to listen on downstream %s: %w", s.config.DownstreamAddr, downstreamErr)
 			}
-			return fmt.Errorf("failed to listen on downstream %s: %w", s.config.DownstreamAddr, downstreamErr)
+			s.log.Error().Err(downstreamErr).Str("addr", s.config.DownstreamAddr).Msg("Failed to start downstream listener")
 		}
-		s.log.Error().Err(downstreamErr).Str("addr", s.config.DownstreamAddr).Msg("Failed to start downstream listener")
 	}
 
 	if upstreamListener != nil {
+		upstreamListener = sockopt.Listener(upstreamListener, s.config.TCP)
+		s.setListening("upstream", true)
 		s.wg.Add(1)
 		go func() {
 			defer s.wg.Done()
+			defer s.setListening("upstream", false)
 			if s.config.UpstreamTLS.Enabled {
 				s.log.Info().
 					Str("addr", s.config.UpstreamAddr).
 					Bool("tls", true).
+					Bool("single_port", singlePort).
 					Str("cert_file", s.config.UpstreamTLS.CertFile).
 					Msg("Starting upstream server with TLS")
-				if err := s.upstreamServer.ServeTLS(upstreamListener, s.config.UpstreamTLS.CertFile, s.config.UpstreamTLS.KeyFile); err != nil && err != http.ErrServerClosed {
+				if err := s.upstreamServer.ServeTLS(upstreamListener, "", ""); err != nil && err != http.ErrServerClosed {
 					s.log.Error().Err(err).Msg("Upstream server error")
 				}
 				return
 			}
-			s.log.Info().Str("addr", s.config.UpstreamAddr).Bool("tls", false).Msg("Starting upstream server")
+			s.log.Info().Str("addr", s.config.UpstreamAddr).Bool("tls", false).Bool("single_port", singlePort).Msg("Starting upstream server")
 			if err := s.upstreamServer.Serve(upstreamListener); err != nil && err != http.ErrServerClosed {
 				s.log.Error().Err(err).Msg("Upstream server error")
 			}
@@ -230,16 +575,19 @@ func (s *Server) Start(ctx context.Context) error {
 	}
 
 	if downstreamListener != nil {
+		downstreamListener = sockopt.Listener(downstreamListener, s.config.TCP)
+		s.setListening("downstream", true)
 		s.wg.Add(1)
 		go func() {
 			defer s.wg.Done()
+			defer s.setListening("downstream", false)
 			if s.config.DownstreamTLS.Enabled {
 				s.log.Info().
 					Str("addr", s.config.DownstreamAddr).
 					Bool("tls", true).
 					Str("cert_file", s.config.DownstreamTLS.CertFile).
 					Msg("Starting downstream server with TLS")
-				if err := s.downstreamServer.ServeTLS(downstreamListener, s.config.DownstreamTLS.CertFile, s.config.DownstreamTLS.KeyFile); err != nil && err != http.ErrServerClosed {
+				if err := s.downstreamServer.ServeTLS(downstreamListener, "", ""); err != nil && err != http.ErrServerClosed {
 					s.log.Error().Err(err).Msg("Downstream server error")
 				}
 				return
@@ -262,6 +610,35 @@ func (s *Server) Start(ctx context.Context) error {
 	s.wg.Add(1)
 	go s.logMetricsPeriodically(ctx)
 
+	if s.config.Guest.Enabled {
+		s.wg.Add(1)
+		go s.guestExpiryLoop(ctx)
+	}
+
+	if s.config.Stall != nil {
+		s.wg.Add(1)
+		go s.stallCheckLoop(ctx)
+	}
+
+	if s.config.Liveness != nil {
+		s.wg.Add(1)
+		go s.livenessCheckLoop(ctx)
+	}
+
+	if s.guard != nil {
+		s.wg.Add(1)
+		go s.guardLoop(ctx)
+	}
+
+	if s.config.Notifier != nil && s.config.CertExpiryWarning > 0 && len(s.certs) > 0 {
+		s.wg.Add(1)
+		go s.certExpiryLoop(ctx)
+	}
+
+	s.config.Notifier.Notify(notify.EventServerStarted, "", "Server started", map[string]string{
+		"upstream":   s.config.UpstreamAddr,
+		"downstream": s.config.DownstreamAddr,
+	})
 	return nil
 }
 
@@ -291,8 +668,10 @@ func (s *Server) Stop(ctx context.Context) error {
 
 	// Close all NAT entries
 	s.natTableMu.Lock()
-	for _, entry := range s.natTable {
-		entry.conn.Close()
+	for key, entry := range s.natTable {
+		entry.close()
+		s.auditStreamClose(key, entry, streamCloseShutdown)
+		s.recordStreamClosed(entry)
 	}
 	s.natTable = make(map[natKey]*natEntry)
 	s.natTableMu.Unlock()
@@ -314,6 +693,33 @@ func (s *Server) Stop(ctx context.Context) error {
 	return nil
 }
 
+// singlePort reports whether upstream and downstream are served on one
+// listener.
+func (s *Server) singlePort() bool {
+	return s.config.UpstreamAddr == s.config.DownstreamAddr
+}
+
+// directionTransportConfig returns the transport config of the direction's
+// handler, which exports its send queues as metrics.
+func (s *Server) directionTransportConfig(config *transport.ServerConfig, direction string) *transport.ServerConfig {
+	if s.config.Metrics == nil {
+		return config
+	}
+	directed := *config
+	directed.QueueObserver = s.config.Metrics.SendQueueObserver(direction)
+	return &directed
+}
+
+// connectionDropped returns the callback for tunnel connections the
+// direction's handler drops, which exports them as metrics.
+func (s *Server) connectionDropped(direction string) func(reason string) {
+	return func(reason string) {
+		if s.config.Metrics != nil {
+			s.config.Metrics.RecordConnectionDropped(direction, reason)
+		}
+	}
+}
+
 func (s *Server) shouldExitOnListenError(err error) bool {
 	return s.config.ExitOnPortInUse && isAddrInUse(err)
 }
@@ -377,10 +783,17 @@ func (s *Server) handleDownstreamConnections(ctx context.Context) {
 // handleUpstreamConnection handles packets from an upstream connection.
 func (s *Server) handleUpstreamConnection(ctx context.Context, conn *transport.Connection) {
 	defer conn.Close()
+	clientCN := conn.PeerCommonName()
 	s.log.Info().
 		Str("remote_addr", conn.RemoteAddr()).
+		Str("client_cn", clientCN).
 		Msg("Upstream connection established")
+	s.recordClientCert("upstream", conn)
 
+	// The connection carries the session of its first admitted packet
+	// only; packets of other sessions are dropped
+	var bound uuid.UUID
+	first := true
 	for {
 		select {
 		case <-ctx.Done():
@@ -401,11 +814,64 @@ func (s *Server) handleUpstreamConnection(ctx context.Context, conn *transport.C
 		// Record received packet metrics
 		s.recordPacketReceived(int64(len(data)))
 
-		pkt, err := protocol.Unmarshal(data)
+		pkt, err := s.unmarshalPacket(data, bound)
 		if err != nil {
 			s.log.Error().Err(err).Msg("Error unmarshaling packet")
+			s.recordError("protocol")
 			continue
 		}
+		if !s.checksumValid(pkt, "upstream") {
+			continue
+		}
+		if bound != uuid.Nil && pkt.SessionID != bound {
+			s.log.Warn().
+				Str("session_id", pkt.SessionID.String()).
+				Str("bound_session_id", bound.String()).
+				Str("remote_addr", conn.RemoteAddr()).
+				Msg("Dropped upstream packet for another session")
+			s.recordError("session_mismatch")
+			continue
+		}
+
+		if first {
+			first = false
+			if s.relayToOwner(ctx, conn, pkt.SessionID, data, "upstream") {
+				return
+			}
+		}
+
+		if err := s.admitSession(pkt); err != nil {
+			s.log.Warn().Err(err).
+				Str("session_id", pkt.SessionID.String()).
+				Str("remote_addr", conn.RemoteAddr()).
+				Msg("Rejected upstream session")
+			s.recordError("session_rejected")
+			s.handshakeFailed(conn.RemoteAddr(), handshakeSessionRejected)
+			return
+		}
+
+		identity, err := s.authenticateClient(pkt, clientCN)
+		if err != nil {
+			s.log.Warn().Err(err).
+				Str("session_id", pkt.SessionID.String()).
+				Str("remote_addr", conn.RemoteAddr()).
+				Msg("Rejected upstream session")
+			s.recordError("session_rejected")
+			s.handshakeFailed(conn.RemoteAddr(), handshakeSessionRejected)
+			return
+		}
+
+		if err := s.admitToStore(pkt.SessionID); err != nil {
+			s.log.Warn().Err(err).
+				Str("session_id", pkt.SessionID.String()).
+				Str("remote_addr", conn.RemoteAddr()).
+				Msg("Rejected upstream session")
+			// The rejection reaches the client if its downstream is connected
+			s.sendSessionLimit(pkt.SessionID, protocol.ControlSessionRejected, protocol.SessionLimit{Reason: protocol.LimitSessions})
+			return
+		}
+		s.identifySession(pkt.SessionID, identity)
+		bound = pkt.SessionID
 
 		s.handleUpstreamPacket(ctx, pkt)
 	}
@@ -423,12 +889,51 @@ func (s *Server) registerDownstreamConnection(ctx context.Context, conn *transpo
 		return
 	}
 
-	pkt, err := protocol.Unmarshal(data)
+	pkt, err := s.unmarshalPacket(data, uuid.Nil)
 	if err != nil {
 		s.log.Error().Err(err).Msg("Error unmarshaling initial downstream packet")
+		s.recordError("protocol")
+		conn.Close()
+		return
+	}
+
+	if s.relayToOwner(ctx, conn, pkt.SessionID, data, "downstream") {
+		return
+	}
+
+	if err := s.admitSession(pkt); err != nil {
+		s.log.Warn().Err(err).
+			Str("session_id", pkt.SessionID.String()).
+			Str("remote_addr", conn.RemoteAddr()).
+			Msg("Rejected downstream session")
+		s.recordError("session_rejected")
+		s.handshakeFailed(conn.RemoteAddr(), handshakeSessionRejected)
+		conn.Close()
+		return
+	}
+
+	identity, err := s.authenticateClient(pkt, conn.PeerCommonName())
+	if err != nil {
+		s.log.Warn().Err(err).
+			Str("session_id", pkt.SessionID.String()).
+			Str("remote_addr", conn.RemoteAddr()).
+			Msg("Rejected downstream session")
+		s.recordError("session_rejected")
+		s.handshakeFailed(conn.RemoteAddr(), handshakeSessionRejected)
+		conn.Close()
+		return
+	}
+
+	if err := s.admitToStore(pkt.SessionID); err != nil {
+		s.log.Warn().Err(err).
+			Str("session_id", pkt.SessionID.String()).
+			Str("remote_addr", conn.RemoteAddr()).
+			Msg("Rejected downstream session")
+		s.rejectSession(conn, pkt.SessionID)
 		conn.Close()
 		return
 	}
+	s.identifySession(pkt.SessionID, identity)
 
 	// Register the downstream connection for this session
 	s.downstreamConnsMu.Lock()
@@ -438,7 +943,10 @@ func (s *Server) registerDownstreamConnection(ctx context.Context, conn *transpo
 	s.log.Info().
 		Str("session_id", pkt.SessionID.String()).
 		Str("remote_addr", conn.RemoteAddr()).
+		Str("client_cn", conn.PeerCommonName()).
 		Msg("Client downstream connected")
+	s.recordClientCert("downstream", conn)
+	s.resetStaleStreams(pkt.SessionID)
 
 	// Keep reading (for keep-alive, etc.)
 	for {
@@ -474,7 +982,7 @@ func (s *Server) registerDownstreamConnection(ctx context.Context, conn *transpo
 }
 
 func (s *Server) handleDownstreamPacket(sessionID uuid.UUID, data []byte) ([]byte, error) {
-	pkt, err := protocol.Unmarshal(data)
+	pkt, err := s.unmarshalPacket(data, sessionID)
 	if err != nil {
 		return nil, err
 	}
@@ -482,13 +990,21 @@ func (s *Server) handleDownstreamPacket(sessionID uuid.UUID, data []byte) ([]byt
 	if pkt.SessionID != sessionID {
 		return nil, fmt.Errorf("downstream packet session mismatch")
 	}
+	if !s.checksumValid(pkt, "downstream") {
+		return nil, nil
+	}
 
+	if pkt.IsControlMessage() {
+		return s.handleDownstreamControl(sessionID, pkt)
+	}
+
+	// Acks echo the payload so clients can time the round trip
 	if pkt.IsKeepAlive() && !pkt.IsAck() {
-		ack, ackErr := protocol.NewKeepAliveAckPacket(sessionID)
+		ack, ackErr := protocol.NewPacket(sessionID, 0, protocol.FlagKeepAlive|protocol.FlagAck, pkt.Payload)
 		if ackErr != nil {
 			return nil, ackErr
 		}
-		return ack.Marshal()
+		return s.marshalPacket(ack)
 	}
 
 	return nil, nil
@@ -498,6 +1014,9 @@ func (s *Server) handleDownstreamPacket(sessionID uuid.UUID, data []byte) ([]byt
 func (s *Server) handleUpstreamPacket(ctx context.Context, pkt *protocol.Packet) {
 	// Get or create session
 	sess := s.sessionStore.GetOrCreate(pkt.SessionID)
+	if !s.stripPadding(sess, pkt) {
+		return
+	}
 
 	s.log.Debug().
 		Str("session_id", pkt.SessionID.String()).
@@ -515,70 +1034,89 @@ func (s *Server) handleUpstreamPacket(ctx context.Context, pkt *protocol.Packet)
 
 	if pkt.IsKeepAlive() {
 		if !pkt.IsAck() {
-			_ = s.sendDownstreamPacket(pkt.SessionID, pkt.StreamID, protocol.FlagKeepAlive|protocol.FlagAck, nil)
+			_ = s.sendDownstreamPacket(pkt.SessionID, pkt.StreamID, protocol.FlagKeepAlive|protocol.FlagAck, pkt.Payload)
 		}
 		return
 	}
 
+	// Control messages are answered on downstream; upstream only carries
+	// the client's cover traffic (ControlPadding), which is discarded
+	if pkt.IsControlMessage() {
+		return
+	}
+
 	// Handle handshake for new streams (contains destination info)
 	if pkt.IsHandshake() && pkt.IsData() && len(pkt.Payload) > 0 {
 		destHost, destPort, err := parseConnectPayload(pkt.Payload)
 		if err != nil {
 			s.log.Error().Err(err).Msg("Error parsing connect payload")
+			s.recordError("protocol")
 			return
 		}
 
-		// Connect to destination
-		destAddr := fmt.Sprintf("%s:%d", destHost, destPort)
-		s.log.Debug().
-			Str("dest_addr", destAddr).
-			Uint32("stream_id", pkt.StreamID).
-			Msg("Connecting to destination")
-
-		conn, err := net.DialTimeout("tcp", destAddr, s.config.DialTimeout)
-		if err != nil {
-			s.log.Error().Err(err).Str("dest_addr", destAddr).Msg("Failed to connect to destination")
-			// Send FIN packet back
-			_ = s.sendDownstreamPacket(pkt.SessionID, pkt.StreamID, protocol.FlagFin, nil)
+		if err := s.config.Policy.Check(sess.Identity(), destHost, destPort); err != nil {
+			s.rejectStream(pkt.SessionID, pkt.StreamID, destHost, destPort, false)
+			return
+		}
+		if s.overQuota(sess.Identity()) {
+			s.refuseOverQuota(pkt.SessionID, pkt.StreamID, sess.Identity())
+			return
+		}
+		if err := s.checkResources(); err != nil {
+			s.shedStream(pkt.SessionID, pkt.StreamID, err)
 			return
 		}
 
-		s.log.Debug().
-			Str("session_id", pkt.SessionID.String()).
-			Uint32("stream_id", pkt.StreamID).
-			Str("dest_addr", destAddr).
-			Msg("Stream opened")
-
-		// Register in NAT table
+		// Register the stream before dialing so data that arrives while the
+		// dial is in progress is queued rather than dropped
+		destAddr := net.JoinHostPort(destHost, strconv.Itoa(int(destPort)))
 		key := natKey{SessionID: pkt.SessionID, StreamID: pkt.StreamID}
 		entry := &natEntry{
-			conn:     conn,
 			destAddr: destAddr,
+			identity: sess.Identity(),
 			created:  time.Now(),
+			stream:   sess.GetStream(pkt.StreamID),
 		}
 
 		s.natTableMu.Lock()
 		s.natTable[key] = entry
 		s.natTableMu.Unlock()
+		s.auditStreamOpen(key, entry)
+		s.hookStreamOpened(key, entry)
+		if s.config.Metrics != nil {
+			s.config.Metrics.RecordStreamCreated()
+		}
+		s.backendAddStream(pkt.SessionID, pkt.StreamID, destAddr)
 
-		// Mark stream as active
-		stream := sess.GetStream(pkt.StreamID)
-		stream.SetState(session.StateActive)
-
-		// Start forwarding responses from destination to downstream
-		go s.forwardDestToDownstream(ctx, pkt.SessionID, pkt.StreamID, conn)
+		// Dial off the read loop so a slow destination does not stall the
+		// session's other streams
+		s.wg.Add(1)
+		go s.dialDestination(ctx, sess, pkt.StreamID, entry, destHost, destPort)
 
 		return
 	}
 
 	// Handle FIN packets
 	if pkt.IsFin() {
-		s.closeNatEntry(pkt.SessionID, pkt.StreamID)
+		fin := protocol.ParseFin(pkt.Payload)
+		s.log.Debug().
+			Str("session_id", pkt.SessionID.String()).
+			Uint32("stream_id", pkt.StreamID).
+			Str("reason", fin.Reason.String()).
+			Str("message", fin.Message).
+			Msg("Stream closed by client")
+		// A client that finished sending may still read the response
+		if fin.Reason == protocol.CloseEOF && s.halfCloseNatEntry(pkt.SessionID, pkt.StreamID) {
+			return
+		}
+		s.closeNatEntry(pkt.SessionID, pkt.StreamID, streamCloseClient, fin)
 		return
 	}
 
 	// Handle data packets - forward to destination
 	if pkt.IsData() && len(pkt.Payload) > 0 {
+		s.recordUpstreamData(pkt.SessionID)
+
 		// Per-packet DEBUG logging (see package doc for performance notes)
 		s.log.Debug().
 			Uint32("stream_id", pkt.StreamID).
@@ -597,21 +1135,43 @@ func (s *Server) handleUpstreamPacket(ctx context.Context, pkt *protocol.Packet)
 				Msg("No NAT entry for stream")
 			return
 		}
+		entry.countReceived(len(pkt.Payload))
 
-		if _, err := entry.conn.Write(pkt.Payload); err != nil {
+		queued, err := entry.queue(pkt.Payload)
+		if queued {
+			return
+		}
+		if err == nil {
+			if !s.waitClientRate(ctx, entry, len(pkt.Payload)) {
+				return
+			}
+			_, err = entry.destConn().Write(pkt.Payload)
+		}
+		if err != nil {
 			s.log.Error().Err(err).
 				Uint32("stream_id", pkt.StreamID).
 				Msg("Error writing to destination")
-			s.closeNatEntry(pkt.SessionID, pkt.StreamID)
+			s.recordError("destination_write")
+			fin := protocol.Fin{Reason: protocol.CloseWriteError, Message: err.Error()}
+			s.closeNatEntry(pkt.SessionID, pkt.StreamID, streamCloseDestinationError, fin)
+			s.sendFin(pkt.SessionID, pkt.StreamID, fin)
+			return
 		}
+		atomic.AddInt64(&entry.bytesUp, int64(len(pkt.Payload)))
+		s.recordGuestTraffic(pkt.SessionID, len(pkt.Payload))
+		s.recordClientTraffic(pkt.SessionID, pkt.StreamID, entry, "upstream", len(pkt.Payload))
 	}
 }
 
 // forwardDestToDownstream forwards data from destination to downstream.
-func (s *Server) forwardDestToDownstream(ctx context.Context, sessionID uuid.UUID, streamID uint32, destConn net.Conn) {
-	defer s.closeNatEntry(sessionID, streamID)
+func (s *Server) forwardDestToDownstream(ctx context.Context, sessionID uuid.UUID, streamID uint32, entry *natEntry) {
+	reason, fin := streamCloseShutdown, protocol.Fin{Reason: protocol.CloseShutdown}
+	defer func() { s.closeNatEntry(sessionID, streamID, reason, fin) }()
+	destConn := entry.destConn()
 
-	buf := make([]byte, constants.DefaultBufferSize)
+	buf := make([]byte, mux.ReadSize(s.sessionMaxPayload(sessionID)))
+	// The client reassembles the stream's data packets by sequence number
+	var seq uint32
 
 	for {
 		select {
@@ -624,17 +1184,35 @@ func (s *Server) forwardDestToDownstream(ctx context.Context, sessionID uuid.UUI
 
 		n, err := destConn.Read(buf)
 		if err != nil {
-			if err != io.EOF {
+			reason, fin = streamCloseDestination, protocol.Fin{Reason: protocol.CloseEOF}
+			if errors.Is(err, os.ErrDeadlineExceeded) && entry.halfClosed() {
+				reason = streamCloseHalfCloseTimeout
+				fin = protocol.Fin{Reason: protocol.CloseIdleTimeout, Message: "half-closed stream idle"}
+			} else if err != io.EOF && entry.probing() {
+				reason = streamCloseIdle
+				fin = protocol.Fin{Reason: protocol.CloseIdleTimeout, Message: "destination did not answer liveness probes"}
+				s.log.Debug().Err(err).
+					Uint32("stream_id", streamID).
+					Str("dest", entry.destAddr).
+					Msg("Idle stream's destination is gone")
+			} else if err != io.EOF {
+				reason = streamCloseDestinationError
+				fin = protocol.Fin{Reason: protocol.CloseReadError, Message: err.Error()}
 				s.log.Debug().Err(err).
 					Uint32("stream_id", streamID).
 					Msg("Error reading from destination")
 			}
-			// Send FIN packet
-			_ = s.sendDownstreamPacket(sessionID, streamID, protocol.FlagFin, nil)
+			// The FIN's sequence number is the number of data packets sent
+			// before it, so the client can wait for any it overtook
+			_ = s.sendDownstreamSeq(sessionID, streamID, protocol.FlagFin, seq, fin.Marshal())
 			return
 		}
 
 		if n > 0 {
+			if entry.halfClosed() {
+				_ = destConn.SetReadDeadline(time.Now().Add(halfCloseIdleTimeout))
+			}
+
 			// Per-packet DEBUG logging (see package doc for performance notes)
 			s.log.Debug().
 				Uint32("stream_id", streamID).
@@ -642,10 +1220,23 @@ func (s *Server) forwardDestToDownstream(ctx context.Context, sessionID uuid.UUI
 				Str("direction", "from_dest").
 				Msg("Data transfer")
 
-			if err := s.sendDownstreamPacket(sessionID, streamID, protocol.FlagData, buf[:n]); err != nil {
+			if !s.waitClientRate(ctx, entry, n) {
+				return
+			}
+			err := s.sendDownstreamSeq(sessionID, streamID, protocol.FlagData, seq, buf[:n])
+			seq++
+			if err != nil {
 				s.log.Error().Err(err).
 					Uint32("stream_id", streamID).
 					Msg("Error sending downstream packet")
+				reason = streamCloseDownstreamError
+				fin = protocol.Fin{Reason: protocol.CloseTunnelError, Message: err.Error()}
+				return
+			}
+			atomic.AddInt64(&entry.bytesDown, int64(n))
+			entry.countSent(n)
+			s.recordGuestTraffic(sessionID, n)
+			if !s.recordClientTraffic(sessionID, streamID, entry, "downstream", n) {
 				return
 			}
 		}
@@ -654,20 +1245,34 @@ func (s *Server) forwardDestToDownstream(ctx context.Context, sessionID uuid.UUI
 
 // sendDownstreamPacket sends a packet through the downstream connection.
 func (s *Server) sendDownstreamPacket(sessionID uuid.UUID, streamID uint32, flags protocol.Flag, payload []byte) error {
+	return s.sendDownstreamSeq(sessionID, streamID, flags, 0, payload)
+}
+
+// sendFin closes a stream on the client, telling it why.
+func (s *Server) sendFin(sessionID uuid.UUID, streamID uint32, fin protocol.Fin) {
+	_ = s.sendDownstreamPacket(sessionID, streamID, protocol.FlagFin, fin.Marshal())
+}
+
+// sendDownstreamSeq sends a packet with sequence number seq through the
+// downstream connection.
+func (s *Server) sendDownstreamSeq(sessionID uuid.UUID, streamID uint32, flags protocol.Flag, seq uint32, payload []byte) error {
 	s.downstreamConnsMu.RLock()
 	conn, exists := s.downstreamConns[sessionID]
 	s.downstreamConnsMu.RUnlock()
 
 	if !exists {
-		return fmt.Errorf("no downstream connection for session %s", sessionID)
+		err := fmt.Errorf("no downstream connection for session %s", sessionID)
+		s.recordDownstreamWrite(sessionID, err)
+		return err
 	}
 
-	pkt, err := protocol.NewPacket(sessionID, streamID, flags, payload)
+	pkt, err := protocol.NewJumboPacket(sessionID, streamID, flags, payload)
 	if err != nil {
 		return err
 	}
+	pkt.SeqNum = seq
 
-	data, err := pkt.Marshal()
+	data, err := s.marshalPacket(pkt)
 	if err != nil {
 		return err
 	}
@@ -675,11 +1280,21 @@ func (s *Server) sendDownstreamPacket(sessionID uuid.UUID, streamID uint32, flag
 	// Record sent packet metrics
 	s.recordPacketSent(int64(len(data)))
 
-	return conn.Write(data)
+	err = conn.Write(data)
+	s.recordDownstreamWrite(sessionID, err)
+	return err
 }
 
-// closeNatEntry closes a NAT entry.
-func (s *Server) closeNatEntry(sessionID uuid.UUID, streamID uint32) {
+// closeNatEntry closes a NAT entry, recording reason in the audit log and
+// fin, the close reason sent to or received from the client, in the metrics
+// and the closed stream history. It returns false if the stream had no NAT
+// entry.
+func (s *Server) closeNatEntry(sessionID uuid.UUID, streamID uint32, reason string, fin protocol.Fin) bool {
+	closedBy := admin.ClosedByLocal
+	if reason == streamCloseClient {
+		closedBy = admin.ClosedByPeer
+	}
+
 	key := natKey{SessionID: sessionID, StreamID: streamID}
 
 	s.natTableMu.Lock()
@@ -689,13 +1304,41 @@ func (s *Server) closeNatEntry(sessionID uuid.UUID, streamID uint32) {
 	}
 	s.natTableMu.Unlock()
 
-	if exists && entry.conn != nil {
+	if exists {
+		s.recordStreamClosed(entry)
+		if s.config.Metrics != nil {
+			s.config.Metrics.RecordStreamCloseReason(closedBy, fin.Reason.String())
+		}
+	}
+	if exists {
+		s.backendRemoveStream(sessionID, streamID)
+		if sess, ok := s.sessionStore.Get(sessionID); ok {
+			sess.RemoveStream(streamID)
+		}
+	}
+	if exists {
 		s.log.Debug().
 			Str("session_id", sessionID.String()).
 			Uint32("stream_id", streamID).
+			Str("closed_by", closedBy).
+			Str("reason", fin.Reason.String()).
 			Msg("Stream closed")
-		entry.conn.Close()
+		entry.close()
+		s.auditStreamClose(key, entry, reason)
+		s.hookStreamClosed(key, closedBy, fin.Reason.String())
+		s.recordClosedStream(key, entry, closedBy, fin)
+		s.sendFinAck(sessionID, streamID)
 	}
+	return exists
+}
+
+// recordStreamClosed exports a closed stream's lifetime and traffic.
+func (s *Server) recordStreamClosed(entry *natEntry) {
+	if s.config.Metrics == nil {
+		return
+	}
+	s.config.Metrics.RecordStreamClosed()
+	s.config.Metrics.RecordStreamLifetime(time.Since(entry.created), atomic.LoadInt64(&entry.bytesUp), atomic.LoadInt64(&entry.bytesDown))
 }
 
 // parseConnectPayload parses the destination from a connect packet payload.
@@ -722,6 +1365,11 @@ func parseConnectPayload(payload []byte) (string, uint16, error) {
 		if len(payload) < 2+domainLen+2 {
 			return "", 0, fmt.Errorf("payload too short for domain")
 		}
+		// An empty domain would have the destination dialed on the local
+		// host
+		if domainLen == 0 {
+			return "", 0, fmt.Errorf("empty domain")
+		}
 		host = string(payload[2 : 2+domainLen])
 		portOffset = 2 + domainLen
 
@@ -767,6 +1415,7 @@ func (s *Server) logMetricsPeriodically(ctx context.Context) {
 			return
 		case <-ticker.C:
 			s.logMetrics()
+			s.pruneBreakers()
 		}
 	}
 }
@@ -799,6 +1448,10 @@ func (s *Server) recordPacketReceived(bytes int64) {
 	s.metrics.PacketsReceived++
 	s.metrics.BytesReceived += bytes
 	s.metricsMu.Unlock()
+
+	if s.config.Metrics != nil {
+		s.config.Metrics.RecordPacketReceived("upstream", int(bytes))
+	}
 }
 
 // recordPacketSent increments the packets sent counter.
@@ -807,4 +1460,15 @@ func (s *Server) recordPacketSent(bytes int64) {
 	s.metrics.PacketsSent++
 	s.metrics.BytesSent += bytes
 	s.metricsMu.Unlock()
+
+	if s.config.Metrics != nil {
+		s.config.Metrics.RecordPacketSent("downstream", int(bytes))
+	}
+}
+
+// recordError counts an error of the given type in the Prometheus metrics.
+func (s *Server) recordError(errorType string) {
+	if s.config.Metrics != nil {
+		s.config.Metrics.RecordError(errorType)
+	}
 }
@@ -1,8 +1,14 @@
 package server
 
 import (
+	"net"
 	"testing"
+	"time"
 	
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sahmadiut/half-tunnel/internal/metrics"
+	"github.com/sahmadiut/half-tunnel/internal/protocol"
 	"github.com/sahmadiut/half-tunnel/internal/socks5"
 )
 
@@ -70,6 +76,11 @@ func TestParseConnectPayload(t *testing.T) {
 			payload: []byte{0x01, 0x01},
 			wantErr: true,
 		},
+		{
+			name:    "EmptyDomain",
+			payload: []byte{socks5.AddrTypeDomain, 0x00, 0x00, 0x50},
+			wantErr: true,
+		},
 		{
 			name:    "InvalidType",
 			payload: []byte{0xFF, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06},
@@ -96,6 +107,26 @@ func TestParseConnectPayload(t *testing.T) {
 	}
 }
 
+func FuzzParseConnectPayload(f *testing.F) {
+	f.Add([]byte{socks5.AddrTypeIPv4, 127, 0, 0, 1, 0x1F, 0x90})
+	f.Add(append([]byte{socks5.AddrTypeDomain, 11}, "example.com\x01\xbb"...))
+	f.Add(append([]byte{socks5.AddrTypeIPv6}, make([]byte, 18)...))
+	f.Add([]byte{socks5.AddrTypeDomain, 0xff, 0})
+
+	f.Fuzz(func(t *testing.T, payload []byte) {
+		host, _, err := parseConnectPayload(payload)
+		if err != nil || payload[0] != socks5.AddrTypeDomain {
+			return
+		}
+		if host == "" {
+			t.Fatal("Accepted an empty domain")
+		}
+		if want := string(payload[2 : 2+int(payload[1])]); host != want {
+			t.Fatalf("Expected domain %q, got %q", want, host)
+		}
+	})
+}
+
 func TestNewServer(t *testing.T) {
 	server := New(nil, nil)
 	if server == nil {
@@ -122,3 +153,88 @@ func TestServerGetters(t *testing.T) {
 		t.Errorf("Expected 0 NAT entries, got %d", count)
 	}
 }
+
+func TestServerExportsMetrics(t *testing.T) {
+	config := DefaultConfig()
+	config.Metrics = metrics.NewCollector()
+	server := New(config, nil)
+	defer server.sessionStore.Close()
+
+	server.recordPacketReceived(100)
+	server.recordPacketSent(40)
+	server.recordError("dial")
+
+	id := uuid.New()
+	server.sessionStore.GetOrCreate(id)
+	server.sessionStore.GetOrCreate(id)
+
+	m := config.Metrics
+	if got := testutil.ToFloat64(m.BytesReceived.WithLabelValues("upstream")); got != 100 {
+		t.Errorf("Expected 100 upstream bytes received, got %v", got)
+	}
+	if got := testutil.ToFloat64(m.BytesSent.WithLabelValues("downstream")); got != 40 {
+		t.Errorf("Expected 40 downstream bytes sent, got %v", got)
+	}
+	if got := testutil.ToFloat64(m.Errors.WithLabelValues("dial")); got != 1 {
+		t.Errorf("Expected 1 dial error, got %v", got)
+	}
+	if got := testutil.ToFloat64(m.ActiveSessions); got != 1 {
+		t.Errorf("Expected 1 active session, got %v", got)
+	}
+
+	server.sessionStore.Remove(id)
+	if got := testutil.ToFloat64(m.ActiveSessions); got != 0 {
+		t.Errorf("Expected 0 active sessions after removal, got %v", got)
+	}
+}
+
+func TestDownstreamKeepAliveEchoesPayload(t *testing.T) {
+	s := New(DefaultConfig(), nil)
+	defer s.sessionStore.Close()
+
+	sessionID := uuid.New()
+	probe, _ := protocol.NewPacket(sessionID, 0, protocol.FlagKeepAlive, []byte("probe-123"))
+	data, _ := probe.Marshal()
+
+	reply, err := s.handleDownstreamPacket(sessionID, data)
+	if err != nil {
+		t.Fatalf("handleDownstreamPacket failed: %v", err)
+	}
+	ack, err := protocol.Unmarshal(reply)
+	if err != nil {
+		t.Fatalf("Failed to parse ack: %v", err)
+	}
+	if !ack.IsKeepAlive() || !ack.IsAck() {
+		t.Errorf("Expected a keepalive ack, got flags %v", ack.Flags)
+	}
+	if string(ack.Payload) != "probe-123" {
+		t.Errorf("Expected the probe payload echoed, got %q", ack.Payload)
+	}
+}
+
+func TestStreamCloseMetrics(t *testing.T) {
+	config := DefaultConfig()
+	config.Metrics = metrics.NewCollector()
+	s := New(config, nil)
+	defer s.sessionStore.Close()
+
+	sessionID := uuid.New()
+	conn, peer := net.Pipe()
+	defer peer.Close()
+	key := natKey{SessionID: sessionID, StreamID: 1}
+	s.natTable[key] = &natEntry{conn: conn, created: time.Now().Add(-time.Minute), bytesUp: 100, bytesDown: 5000}
+	config.Metrics.RecordStreamCreated()
+
+	fin := protocol.Fin{Reason: protocol.CloseEOF}
+	s.closeNatEntry(sessionID, 1, streamCloseDestination, fin)
+	// A second close of the same stream is not counted again
+	s.closeNatEntry(sessionID, 1, streamCloseDestination, fin)
+
+	if got := testutil.ToFloat64(config.Metrics.ActiveStreams); got != 0 {
+		t.Errorf("Expected no active streams, got %v", got)
+	}
+	// Sizes are observed per direction once the stream is closed
+	if got := testutil.CollectAndCount(config.Metrics.StreamSize); got != 2 {
+		t.Errorf("Expected upstream and downstream stream sizes, got %d series", got)
+	}
+}
@@ -0,0 +1,193 @@
+package server
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// closeReasonStalled is the close reason of sessions whose downstream stopped
+// accepting data while the client kept sending.
+const closeReasonStalled = "stalled"
+
+// StallConfig holds settings for detecting sessions whose downstream stalls.
+type StallConfig struct {
+	// CheckInterval is how often sessions are checked
+	CheckInterval time.Duration
+	// StallThreshold is how long downstream writes may keep failing while
+	// upstream data arrives before the session is closed
+	StallThreshold time.Duration
+}
+
+// DefaultStallConfig returns the default stall detection settings.
+func DefaultStallConfig() *StallConfig {
+	return &StallConfig{
+		CheckInterval:  30 * time.Second,
+		StallThreshold: 2 * time.Minute,
+	}
+}
+
+// sessionFlow tracks a session's data flow in both directions. Times are
+// Unix nanoseconds, updated atomically.
+type sessionFlow struct {
+	lastUpstream   int64
+	lastDownstream int64
+	// failingSince is when downstream writes started failing (0 while
+	// they succeed)
+	failingSince int64
+	failures     int64
+}
+
+// flow returns the data flow of a session, creating it if needed. It
+// returns nil when stall detection is disabled.
+func (s *Server) flow(sessionID uuid.UUID) *sessionFlow {
+	if s.config.Stall == nil {
+		return nil
+	}
+	s.flowsMu.RLock()
+	f, exists := s.flows[sessionID]
+	s.flowsMu.RUnlock()
+	if exists {
+		return f
+	}
+
+	s.flowsMu.Lock()
+	defer s.flowsMu.Unlock()
+	if f, exists = s.flows[sessionID]; !exists {
+		f = &sessionFlow{}
+		s.flows[sessionID] = f
+	}
+	return f
+}
+
+// recordUpstreamData records stream data received from a session's client.
+func (s *Server) recordUpstreamData(sessionID uuid.UUID) {
+	if f := s.flow(sessionID); f != nil {
+		atomic.StoreInt64(&f.lastUpstream, time.Now().UnixNano())
+	}
+}
+
+// recordDownstreamWrite records the outcome of a write to a session's
+// downstream connection.
+func (s *Server) recordDownstreamWrite(sessionID uuid.UUID, err error) {
+	if err != nil && s.config.Metrics != nil {
+		s.config.Metrics.RecordDownstreamWriteFailure()
+	}
+	f := s.flow(sessionID)
+	if f == nil {
+		return
+	}
+	now := time.Now().UnixNano()
+	if err == nil {
+		atomic.StoreInt64(&f.lastDownstream, now)
+		atomic.StoreInt64(&f.failingSince, 0)
+		atomic.StoreInt64(&f.failures, 0)
+		return
+	}
+	atomic.CompareAndSwapInt64(&f.failingSince, 0, now)
+	atomic.AddInt64(&f.failures, 1)
+}
+
+// stalled reports whether downstream writes have failed for at least
+// threshold while the client kept sending upstream data.
+func (f *sessionFlow) stalled(now time.Time, threshold time.Duration) bool {
+	failingSince := atomic.LoadInt64(&f.failingSince)
+	if failingSince == 0 || now.Sub(time.Unix(0, failingSince)) < threshold {
+		return false
+	}
+	return atomic.LoadInt64(&f.lastUpstream) >= failingSince
+}
+
+// stallCheckLoop periodically closes stalled sessions.
+func (s *Server) stallCheckLoop(ctx context.Context) {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(s.config.Stall.CheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.shutdown:
+			return
+		case now := <-ticker.C:
+			s.checkStalledSessions(now)
+		}
+	}
+}
+
+// checkStalledSessions closes sessions whose downstream stalled, so their
+// destination connections are released and the client reconnects, and
+// forgets the data flow of sessions that are gone.
+func (s *Server) checkStalledSessions(now time.Time) {
+	type stalledSession struct {
+		id   uuid.UUID
+		flow *sessionFlow
+	}
+	var stalled []stalledSession
+	failing := 0
+
+	s.flowsMu.RLock()
+	flows := make(map[uuid.UUID]*sessionFlow, len(s.flows))
+	for id, f := range s.flows {
+		flows[id] = f
+	}
+	s.flowsMu.RUnlock()
+
+	for id, f := range flows {
+		if _, exists := s.sessionStore.Get(id); !exists {
+			s.forgetFlow(id)
+			continue
+		}
+		if atomic.LoadInt64(&f.failingSince) != 0 {
+			failing++
+		}
+		if f.stalled(now, s.config.Stall.StallThreshold) {
+			stalled = append(stalled, stalledSession{id, f})
+		}
+	}
+
+	if s.config.Metrics != nil {
+		s.config.Metrics.SetDownstreamFailingSessions(failing)
+	}
+
+	for _, sess := range stalled {
+		f := sess.flow
+		event := s.log.Warn().
+			Str("session_id", sess.id.String()).
+			Int64("failed_writes", atomic.LoadInt64(&f.failures)).
+			Time("failing_since", time.Unix(0, atomic.LoadInt64(&f.failingSince))).
+			Time("last_upstream", time.Unix(0, atomic.LoadInt64(&f.lastUpstream)))
+		if last := atomic.LoadInt64(&f.lastDownstream); last != 0 {
+			event = event.Time("last_downstream", time.Unix(0, last))
+		}
+		event.Msg("Downstream stalled while upstream is active, closing session")
+		s.teardownSession(sess.id, closeReasonStalled)
+	}
+}
+
+// forgetFlow drops the data flow of a closed session.
+func (s *Server) forgetFlow(sessionID uuid.UUID) {
+	s.flowsMu.Lock()
+	delete(s.flows, sessionID)
+	s.flowsMu.Unlock()
+}
+
+// sessionFlowStats returns the downstream write failures of a session and
+// when they started, for the admin API.
+func (s *Server) sessionFlowStats(sessionID uuid.UUID) (int64, time.Time) {
+	s.flowsMu.RLock()
+	f, exists := s.flows[sessionID]
+	s.flowsMu.RUnlock()
+	if !exists {
+		return 0, time.Time{}
+	}
+	failingSince := atomic.LoadInt64(&f.failingSince)
+	if failingSince == 0 {
+		return 0, time.Time{}
+	}
+	return atomic.LoadInt64(&f.failures), time.Unix(0, failingSince)
+}
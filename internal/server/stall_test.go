@@ -0,0 +1,72 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sahmadiut/half-tunnel/internal/metrics"
+)
+
+func TestStalledSessionClosed(t *testing.T) {
+	config := DefaultConfig()
+	config.Metrics = metrics.NewCollector()
+	s := New(config, nil)
+
+	stalled, idle := uuid.New(), uuid.New()
+	for _, id := range []uuid.UUID{stalled, idle} {
+		if _, err := s.sessionStore.Admit(id); err != nil {
+			t.Fatalf("Failed to admit session: %v", err)
+		}
+		// Neither session has a downstream connection
+		_ = s.sendDownstreamPacket(id, 1, 0, []byte("data"))
+	}
+	// Only one client keeps sending
+	s.recordUpstreamData(stalled)
+
+	failures, since := s.sessionFlowStats(stalled)
+	if failures != 1 || since.IsZero() {
+		t.Fatalf("Expected one downstream failure, got %d since %v", failures, since)
+	}
+
+	// Not stalled before the threshold
+	s.checkStalledSessions(time.Now())
+	if _, exists := s.sessionStore.Get(stalled); !exists {
+		t.Fatal("Expected session to stay open before the stall threshold")
+	}
+
+	s.checkStalledSessions(time.Now().Add(config.Stall.StallThreshold))
+	if _, exists := s.sessionStore.Get(stalled); exists {
+		t.Error("Expected stalled session to be closed")
+	}
+	if _, exists := s.sessionStore.Get(idle); !exists {
+		t.Error("Expected session without upstream data to stay open")
+	}
+	if got := testutil.ToFloat64(config.Metrics.SessionsClosed.WithLabelValues(closeReasonStalled)); got != 1 {
+		t.Errorf("Expected 1 stalled session closed, got %v", got)
+	}
+	if got := testutil.ToFloat64(config.Metrics.DownstreamWriteFailures); got != 2 {
+		t.Errorf("Expected 2 downstream write failures, got %v", got)
+	}
+}
+
+func TestDownstreamWriteSuccessClearsStall(t *testing.T) {
+	s := New(DefaultConfig(), nil)
+	id := uuid.New()
+	if _, err := s.sessionStore.Admit(id); err != nil {
+		t.Fatalf("Failed to admit session: %v", err)
+	}
+
+	_ = s.sendDownstreamPacket(id, 1, 0, []byte("data"))
+	s.recordUpstreamData(id)
+	s.recordDownstreamWrite(id, nil)
+
+	if failures, _ := s.sessionFlowStats(id); failures != 0 {
+		t.Errorf("Expected a successful write to clear failures, got %d", failures)
+	}
+	s.checkStalledSessions(time.Now().Add(time.Hour))
+	if _, exists := s.sessionStore.Get(id); !exists {
+		t.Error("Expected session with a working downstream to stay open")
+	}
+}
@@ -0,0 +1,134 @@
+package service
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// InitSystem installs and controls the Half-Tunnel services with one init
+// system or service manager.
+type InitSystem interface {
+	// Name identifies the init system, e.g. "systemd"
+	Name() string
+	// Available reports whether the init system manages services on this host
+	Available() bool
+	// FilePath returns the file that defines the service
+	FilePath(t ServiceType) string
+	// IsInstalled reports whether the service is installed with this init
+	// system
+	IsInstalled(t ServiceType) bool
+	// Install writes the service definition. The binary and config paths
+	// are already checked and the defaults set.
+	Install(cfg *ServiceConfig) error
+	// Uninstall stops the service and removes its definition
+	Uninstall(t ServiceType) error
+	Start(t ServiceType) error
+	Stop(t ServiceType) error
+	Restart(t ServiceType) error
+	// Enable makes the service start on boot
+	Enable(t ServiceType) error
+	// Disable stops the service from starting on boot
+	Disable(t ServiceType) error
+	// Status returns a human-readable status. The error is set if the
+	// service is not running.
+	Status(t ServiceType) (string, error)
+	IsRunning(t ServiceType) bool
+	// LogCommand returns the command that prints the service's logs
+	LogCommand(t ServiceType, follow bool, lines int) *exec.Cmd
+	// InstalledPaths returns the binary and config paths in the installed
+	// service definition
+	InstalledPaths(t ServiceType) (binary, config string, err error)
+}
+
+// InitSystemNames returns the names of the supported init systems.
+func InitSystemNames() []string {
+	names := make([]string, len(initSystems))
+	for i, s := range initSystems {
+		names[i] = s.Name()
+	}
+	return names
+}
+
+// LookupInitSystem returns the init system with the given name.
+func LookupInitSystem(name string) (InitSystem, error) {
+	for _, s := range initSystems {
+		if s.Name() == name {
+			return s, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown init system %q (use %s)", name, strings.Join(InitSystemNames(), ", "))
+}
+
+// DetectInitSystem returns the first init system that runs on this host.
+func DetectInitSystem() InitSystem {
+	for _, s := range initSystems {
+		if s.Available() {
+			return s
+		}
+	}
+	return initSystems[len(initSystems)-1]
+}
+
+// InitSystemFor returns the init system the service is installed with, or
+// the detected one if it is not installed.
+func InitSystemFor(t ServiceType) InitSystem {
+	for _, s := range initSystems {
+		if s.IsInstalled(t) {
+			return s
+		}
+	}
+	return DetectInitSystem()
+}
+
+// fileExists reports whether path exists.
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// parseCommandLine returns the binary and config path of a command line in
+// which the binary is directly followed by -config.
+func parseCommandLine(line string) (binary, config string, ok bool) {
+	fields := strings.Fields(line)
+	for i := 1; i < len(fields)-1; i++ {
+		if fields[i] == "-config" || fields[i] == "--config" {
+			return fields[i-1], fields[i+1], true
+		}
+	}
+	return "", "", false
+}
+
+// tailCommand returns a tail command for a log file.
+func tailCommand(path string, follow bool, lines int) *exec.Cmd {
+	if lines <= 0 {
+		lines = 100
+	}
+	args := []string{"-n", fmt.Sprintf("%d", lines)}
+	if follow {
+		args = append(args, "-F")
+	}
+	return exec.Command("tail", append(args, path)...)
+}
+
+// runCommand runs a command with its output on the terminal.
+func runCommand(name string, args ...string) error {
+	cmd := exec.Command(name, args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// writeServiceFile renders a service definition template to path.
+func writeServiceFile(path string, tmpl string, cfg *ServiceConfig, perm os.FileMode) error {
+	content, err := renderTemplate(tmpl, cfg)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, content, perm); err != nil {
+		return fmt.Errorf("failed to create service file: %w (try running with sudo)", err)
+	}
+	// WriteFile keeps the mode of an existing file
+	return os.Chmod(path, perm)
+}
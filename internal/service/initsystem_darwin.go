@@ -0,0 +1,7 @@
+//go:build darwin
+
+package service
+
+// initSystems lists the supported init systems. macOS runs services with
+// launchd only.
+var initSystems = []InitSystem{launchd{}}
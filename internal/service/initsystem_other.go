@@ -0,0 +1,7 @@
+//go:build !windows && !darwin
+
+package service
+
+// initSystems lists the supported init systems in detection order. The
+// plain init system is always available and comes last.
+var initSystems = []InitSystem{systemd{}, openRC{}, runit{}, plain{}}
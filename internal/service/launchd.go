@@ -0,0 +1,235 @@
+package service
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+const launchdTemplate = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<!-- Half-Tunnel {{.TypeTitle}}, installed by "ht {{.Type}} install" -->
+<plist version="1.0">
+<dict>
+	<key>Label</key>
+	<string>half-tunnel-{{.Type}}</string>
+	<key>ProgramArguments</key>
+	<array>
+		<string>{{.BinaryPath}}</string>
+		<string>-config</string>
+		<string>{{.ConfigPath}}</string>
+	</array>
+	<key>UserName</key>
+	<string>{{.User}}</string>
+	<key>WorkingDirectory</key>
+	<string>{{.WorkingDir}}</string>
+	<key>RunAtLoad</key>
+	<true/>
+	<key>KeepAlive</key>
+	<true/>
+	<key>ThrottleInterval</key>
+	<integer>5</integer>
+	<key>SoftResourceLimits</key>
+	<dict>
+		<key>NumberOfFiles</key>
+		<integer>65535</integer>
+	</dict>
+	<key>StandardOutPath</key>
+	<string>/var/log/half-tunnel-{{.Type}}.log</string>
+	<key>StandardErrorPath</key>
+	<string>/var/log/half-tunnel-{{.Type}}.log</string>
+</dict>
+</plist>
+`
+
+// launchd manages the services as launchd daemons on macOS. launchd loads
+// every daemon in /Library/LaunchDaemons on boot, so an installed service
+// starts on boot unless it is disabled, and a disabled service cannot be
+// started.
+type launchd struct{}
+
+func (launchd) Name() string { return "launchd" }
+
+func (launchd) Available() bool {
+	_, err := exec.LookPath("launchctl")
+	return err == nil
+}
+
+func (launchd) FilePath(t ServiceType) string {
+	return fmt.Sprintf("/Library/LaunchDaemons/%s.plist", ServiceName(t))
+}
+
+func (l launchd) IsInstalled(t ServiceType) bool { return fileExists(l.FilePath(t)) }
+
+// target returns the launchctl service target of a service.
+func (launchd) target(t ServiceType) string {
+	return "system/" + ServiceName(t)
+}
+
+// loaded reports whether launchd has loaded the service.
+func (l launchd) loaded(t ServiceType) bool {
+	return exec.Command("launchctl", "print", l.target(t)).Run() == nil
+}
+
+func (l launchd) Install(cfg *ServiceConfig) error {
+	if err := writeServiceFile(l.FilePath(cfg.Type), launchdTemplate, cfg, 0644); err != nil {
+		return err
+	}
+	// A loaded daemon keeps its old definition until it is reloaded
+	if l.loaded(cfg.Type) {
+		_ = runCommand("launchctl", "bootout", l.target(cfg.Type))
+		if err := runCommand("launchctl", "bootstrap", "system", l.FilePath(cfg.Type)); err != nil {
+			return fmt.Errorf("failed to reload service: %w", err)
+		}
+	}
+	return nil
+}
+
+func (l launchd) Uninstall(t ServiceType) error {
+	if l.loaded(t) {
+		_ = runCommand("launchctl", "bootout", l.target(t))
+	}
+	if err := os.Remove(l.FilePath(t)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove service file: %w", err)
+	}
+	return nil
+}
+
+func (l launchd) Start(t ServiceType) error {
+	if l.loaded(t) {
+		return runCommand("launchctl", "kickstart", l.target(t))
+	}
+	// Loading the daemon starts it (RunAtLoad)
+	if err := runCommand("launchctl", "bootstrap", "system", l.FilePath(t)); err != nil {
+		return fmt.Errorf("failed to load %s: %w (if it is disabled, run \"ht %s enable\" first)", ServiceName(t), err, t)
+	}
+	return nil
+}
+
+// Stop unloads the daemon; launchd would restart a stopped process that it
+// keeps alive.
+func (l launchd) Stop(t ServiceType) error {
+	if !l.loaded(t) {
+		return fmt.Errorf("%s is not running", ServiceName(t))
+	}
+	return runCommand("launchctl", "bootout", l.target(t))
+}
+
+func (l launchd) Restart(t ServiceType) error {
+	if !l.loaded(t) {
+		return l.Start(t)
+	}
+	return runCommand("launchctl", "kickstart", "-k", l.target(t))
+}
+
+func (l launchd) Enable(t ServiceType) error {
+	return runCommand("launchctl", "enable", l.target(t))
+}
+
+func (l launchd) Disable(t ServiceType) error {
+	return runCommand("launchctl", "disable", l.target(t))
+}
+
+func (l launchd) Status(t ServiceType) (string, error) {
+	var status strings.Builder
+	fmt.Fprintf(&status, "%s (launchd daemon)\n", ServiceName(t))
+	fmt.Fprintf(&status, "  Definition: %s\n", l.FilePath(t))
+
+	output, err := exec.Command("launchctl", "print", l.target(t)).CombinedOutput()
+	if err != nil {
+		status.WriteString("  State:      not loaded\n")
+		return status.String(), fmt.Errorf("service is not running")
+	}
+	state, pid := parseLaunchctlPrint(string(output))
+	if pid != "" {
+		fmt.Fprintf(&status, "  State:      %s (pid %s)\n", state, pid)
+	} else {
+		fmt.Fprintf(&status, "  State:      %s\n", state)
+	}
+	if state != "running" {
+		return status.String(), fmt.Errorf("service is not running")
+	}
+	return status.String(), nil
+}
+
+func (l launchd) IsRunning(t ServiceType) bool {
+	_, err := l.Status(t)
+	return err == nil
+}
+
+// LogCommand shows the unified log entries of the service process and of
+// launchd about the service. Output written before the logger starts, such
+// as configuration errors, is in /var/log/half-tunnel-<type>.log.
+func (l launchd) LogCommand(t ServiceType, follow bool, lines int) *exec.Cmd {
+	binary, _, err := l.InstalledPaths(t)
+	if err != nil {
+		binary = GetDefaultBinaryPath(t)
+	}
+	predicate := fmt.Sprintf(`process == "%s" OR eventMessage CONTAINS "%s"`, filepath.Base(binary), ServiceName(t))
+	if follow {
+		return exec.Command("log", "stream", "--style", "compact", "--predicate", predicate)
+	}
+	if lines <= 0 {
+		lines = 100
+	}
+	script := fmt.Sprintf("log show --style compact --last 1d --predicate '%s' | tail -n %d", predicate, lines)
+	return exec.Command("sh", "-c", script)
+}
+
+func (l launchd) InstalledPaths(t ServiceType) (binary, config string, err error) {
+	data, err := os.ReadFile(l.FilePath(t))
+	if err != nil {
+		return "", "", err
+	}
+	binary, config, ok := parseProgramArguments(string(data))
+	if !ok {
+		return "", "", fmt.Errorf("no ProgramArguments in %s", l.FilePath(t))
+	}
+	return binary, config, nil
+}
+
+// parseProgramArguments returns the binary and config path of a plist's
+// ProgramArguments array.
+func parseProgramArguments(plist string) (binary, config string, ok bool) {
+	_, rest, found := strings.Cut(plist, "<key>ProgramArguments</key>")
+	if !found {
+		return "", "", false
+	}
+	array, _, found := strings.Cut(rest, "</array>")
+	if !found {
+		return "", "", false
+	}
+	var args []string
+	for _, line := range strings.Split(array, "\n") {
+		line = strings.TrimSpace(line)
+		if arg, found := strings.CutPrefix(line, "<string>"); found {
+			args = append(args, strings.TrimSuffix(arg, "</string>"))
+		}
+	}
+	return parseCommandLine(strings.Join(args, " "))
+}
+
+// parseLaunchctlPrint returns the state and pid of a "launchctl print"
+// output.
+func parseLaunchctlPrint(output string) (state, pid string) {
+	state = "unknown"
+	for _, line := range strings.Split(output, "\n") {
+		key, value, found := strings.Cut(strings.TrimSpace(line), " = ")
+		if !found {
+			continue
+		}
+		switch key {
+		case "state":
+			if state == "unknown" {
+				state = value
+			}
+		case "pid":
+			if pid == "" {
+				pid = value
+			}
+		}
+	}
+	return state, pid
+}
@@ -0,0 +1,123 @@
+package service
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+const openRCTemplate = `#!/sbin/openrc-run
+# Half-Tunnel {{.TypeTitle}}, installed by "ht {{.Type}} install"
+
+name="half-tunnel-{{.Type}}"
+description="Half-Tunnel {{.TypeTitle}}"
+command="{{.BinaryPath}}"
+command_args="-config {{.ConfigPath}}"
+command_user="{{.User}}"
+directory="{{.WorkingDir}}"
+supervisor="supervise-daemon"
+respawn_delay=5
+respawn_max=0
+rc_ulimit="-n 65535"
+output_log="/var/log/half-tunnel-{{.Type}}.log"
+error_log="/var/log/half-tunnel-{{.Type}}.log"
+
+depend() {
+	need net
+	after firewall
+}
+
+start_pre() {
+	checkpath --directory --mode 0755 --owner "$command_user" /run/half-tunnel
+	checkpath --file --mode 0640 --owner "$command_user" "$output_log"
+}
+`
+
+// openRC manages the services as OpenRC init scripts supervised by
+// supervise-daemon, as on Alpine and Gentoo.
+type openRC struct{}
+
+func (openRC) Name() string { return "openrc" }
+
+func (openRC) Available() bool {
+	// Only present when OpenRC booted the system
+	_, err := os.Stat("/run/openrc")
+	return err == nil
+}
+
+func (openRC) FilePath(t ServiceType) string {
+	return "/etc/init.d/" + ServiceName(t)
+}
+
+func (o openRC) IsInstalled(t ServiceType) bool { return fileExists(o.FilePath(t)) }
+
+func (o openRC) Install(cfg *ServiceConfig) error {
+	return writeServiceFile(o.FilePath(cfg.Type), openRCTemplate, cfg, 0755)
+}
+
+func (o openRC) Uninstall(t ServiceType) error {
+	_ = runCommand("rc-service", ServiceName(t), "stop")
+	_ = runCommand("rc-update", "del", ServiceName(t), "default")
+
+	if err := os.Remove(o.FilePath(t)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove service file: %w", err)
+	}
+	return nil
+}
+
+func (openRC) Start(t ServiceType) error { return runCommand("rc-service", ServiceName(t), "start") }
+
+func (openRC) Stop(t ServiceType) error { return runCommand("rc-service", ServiceName(t), "stop") }
+
+func (openRC) Restart(t ServiceType) error {
+	return runCommand("rc-service", ServiceName(t), "restart")
+}
+
+func (openRC) Enable(t ServiceType) error {
+	return runCommand("rc-update", "add", ServiceName(t), "default")
+}
+
+func (openRC) Disable(t ServiceType) error {
+	return runCommand("rc-update", "del", ServiceName(t), "default")
+}
+
+func (openRC) Status(t ServiceType) (string, error) {
+	output, err := exec.Command("rc-service", ServiceName(t), "status").CombinedOutput()
+	return string(output), err
+}
+
+func (openRC) IsRunning(t ServiceType) bool {
+	return exec.Command("rc-service", ServiceName(t), "status").Run() == nil
+}
+
+func (openRC) LogCommand(t ServiceType, follow bool, lines int) *exec.Cmd {
+	return tailCommand(fmt.Sprintf("/var/log/%s.log", ServiceName(t)), follow, lines)
+}
+
+func (o openRC) InstalledPaths(t ServiceType) (binary, config string, err error) {
+	data, err := os.ReadFile(o.FilePath(t))
+	if err != nil {
+		return "", "", err
+	}
+	vars := parseAssignments(string(data))
+	binary, config, ok := parseCommandLine(vars["command"] + " " + vars["command_args"])
+	if !ok {
+		return "", "", fmt.Errorf("no command in %s", o.FilePath(t))
+	}
+	return binary, config, nil
+}
+
+// parseAssignments returns the shell variable assignments of a script,
+// with the quotes removed.
+func parseAssignments(script string) map[string]string {
+	vars := make(map[string]string)
+	for _, line := range strings.Split(script, "\n") {
+		name, value, found := strings.Cut(strings.TrimSpace(line), "=")
+		if !found || strings.ContainsAny(name, " \t#") {
+			continue
+		}
+		vars[name] = strings.Trim(value, `"'`)
+	}
+	return vars
+}
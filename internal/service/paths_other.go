@@ -0,0 +1,34 @@
+//go:build !windows
+
+package service
+
+import "os"
+
+// GetDefaultBinaryPath returns the default binary path for the given service type.
+func GetDefaultBinaryPath(t ServiceType) string {
+	switch t {
+	case ClientService:
+		return "/usr/local/bin/ht-client"
+	case ServerService:
+		return "/usr/local/bin/ht-server"
+	default:
+		return ""
+	}
+}
+
+// GetDefaultConfigPath returns the default config path for the given service type.
+func GetDefaultConfigPath(t ServiceType) string {
+	switch t {
+	case ClientService:
+		return "/etc/half-tunnel/client.yml"
+	case ServerService:
+		return "/etc/half-tunnel/server.yml"
+	default:
+		return ""
+	}
+}
+
+// EnsureConfigDir ensures the config directory exists.
+func EnsureConfigDir() error {
+	return os.MkdirAll("/etc/half-tunnel", 0755)
+}
@@ -0,0 +1,40 @@
+//go:build windows
+
+package service
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// GetDefaultBinaryPath returns the default binary path for the given service
+// type: next to the running ht.exe.
+func GetDefaultBinaryPath(t ServiceType) string {
+	dir := "."
+	if exe, err := os.Executable(); err == nil {
+		dir = filepath.Dir(exe)
+	}
+	return filepath.Join(dir, "ht-"+string(t)+".exe")
+}
+
+// GetDefaultConfigPath returns the default config path for the given service
+// type, in the current user's configuration directory
+// (%AppData%\half-tunnel).
+func GetDefaultConfigPath(t ServiceType) string {
+	return filepath.Join(configDir(), string(t)+".yml")
+}
+
+// EnsureConfigDir ensures the config directory exists.
+func EnsureConfigDir() error {
+	return os.MkdirAll(configDir(), 0755)
+}
+
+// configDir returns the current user's Half-Tunnel configuration directory,
+// or the machine-wide one under %ProgramData% if the user has none.
+func configDir() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		dir = os.Getenv("ProgramData")
+	}
+	return filepath.Join(dir, "half-tunnel")
+}
@@ -0,0 +1,186 @@
+package service
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const plainTemplate = `# Half-Tunnel {{.TypeTitle}}, run in the background by "ht {{.Type}} start"
+BINARY={{.BinaryPath}}
+CONFIG={{.ConfigPath}}
+USER={{.User}}
+WORKING_DIR={{.WorkingDir}}
+`
+
+// plainStopTimeout is how long Stop waits for the process to exit before
+// killing it.
+const plainStopTimeout = 10 * time.Second
+
+// plain runs the services as background processes tracked by a pidfile, for
+// hosts and containers without a supported init system. It does not restart
+// crashed processes or start them on boot.
+type plain struct{}
+
+func (plain) Name() string { return "plain" }
+
+func (plain) Available() bool { return true }
+
+func (plain) FilePath(t ServiceType) string {
+	return fmt.Sprintf("/etc/half-tunnel/%s.env", ServiceName(t))
+}
+
+func (p plain) IsInstalled(t ServiceType) bool { return fileExists(p.FilePath(t)) }
+
+func (plain) pidFile(t ServiceType) string {
+	return fmt.Sprintf("/run/%s.pid", ServiceName(t))
+}
+
+func (plain) logFile(t ServiceType) string {
+	return fmt.Sprintf("/var/log/%s.log", ServiceName(t))
+}
+
+func (p plain) Install(cfg *ServiceConfig) error {
+	return writeServiceFile(p.FilePath(cfg.Type), plainTemplate, cfg, 0644)
+}
+
+func (p plain) Uninstall(t ServiceType) error {
+	if p.IsRunning(t) {
+		if err := p.Stop(t); err != nil {
+			return err
+		}
+	}
+	if err := os.Remove(p.FilePath(t)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove service file: %w", err)
+	}
+	return nil
+}
+
+func (p plain) Start(t ServiceType) error {
+	if pid, ok := p.pid(t); ok {
+		return fmt.Errorf("%s is already running (pid %d)", ServiceName(t), pid)
+	}
+	data, err := os.ReadFile(p.FilePath(t))
+	if err != nil {
+		return err
+	}
+	vars := parseAssignments(string(data))
+
+	log, err := os.OpenFile(p.logFile(t), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0640)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+	defer log.Close()
+
+	attr, err := detachedProcAttr(vars["USER"])
+	if err != nil {
+		return err
+	}
+	if err := prepareRuntimeDir(vars["USER"]); err != nil {
+		return err
+	}
+
+	cmd := exec.Command(vars["BINARY"], "-config", vars["CONFIG"])
+	cmd.Dir = vars["WORKING_DIR"]
+	cmd.Stdout = log
+	cmd.Stderr = log
+	cmd.SysProcAttr = attr
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start %s: %w", vars["BINARY"], err)
+	}
+	pid := cmd.Process.Pid
+	if err := os.WriteFile(p.pidFile(t), []byte(strconv.Itoa(pid)+"\n"), 0644); err != nil {
+		_ = cmd.Process.Kill()
+		return fmt.Errorf("failed to write pidfile: %w", err)
+	}
+	return cmd.Process.Release()
+}
+
+func (p plain) Stop(t ServiceType) error {
+	pid, ok := p.pid(t)
+	if !ok {
+		return fmt.Errorf("%s is not running", ServiceName(t))
+	}
+	if err := terminateProcess(pid); err != nil {
+		return err
+	}
+	deadline := time.Now().Add(plainStopTimeout)
+	for processAlive(pid) {
+		if time.Now().After(deadline) {
+			if err := killProcess(pid); err != nil {
+				return err
+			}
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return os.Remove(p.pidFile(t))
+}
+
+func (p plain) Restart(t ServiceType) error {
+	if p.IsRunning(t) {
+		if err := p.Stop(t); err != nil {
+			return err
+		}
+	}
+	return p.Start(t)
+}
+
+func (plain) Enable(t ServiceType) error {
+	return fmt.Errorf("the plain init system cannot start services on boot; run \"ht %s start\" from a boot script or container entrypoint", t)
+}
+
+func (p plain) Disable(t ServiceType) error {
+	return p.Enable(t)
+}
+
+func (p plain) Status(t ServiceType) (string, error) {
+	var status strings.Builder
+	fmt.Fprintf(&status, "%s (plain background process)\n", ServiceName(t))
+	fmt.Fprintf(&status, "  Definition: %s\n", p.FilePath(t))
+	fmt.Fprintf(&status, "  Log:        %s\n", p.logFile(t))
+	pid, ok := p.pid(t)
+	if !ok {
+		status.WriteString("  State:      stopped\n")
+		return status.String(), fmt.Errorf("service is not running")
+	}
+	fmt.Fprintf(&status, "  State:      running (pid %d)\n", pid)
+	return status.String(), nil
+}
+
+func (p plain) IsRunning(t ServiceType) bool {
+	_, ok := p.pid(t)
+	return ok
+}
+
+func (p plain) LogCommand(t ServiceType, follow bool, lines int) *exec.Cmd {
+	return tailCommand(p.logFile(t), follow, lines)
+}
+
+func (p plain) InstalledPaths(t ServiceType) (binary, config string, err error) {
+	data, err := os.ReadFile(p.FilePath(t))
+	if err != nil {
+		return "", "", err
+	}
+	vars := parseAssignments(string(data))
+	if vars["BINARY"] == "" {
+		return "", "", fmt.Errorf("no BINARY in %s", p.FilePath(t))
+	}
+	return vars["BINARY"], vars["CONFIG"], nil
+}
+
+// pid returns the pid in the pidfile if that process is alive.
+func (p plain) pid(t ServiceType) (int, bool) {
+	data, err := os.ReadFile(p.pidFile(t))
+	if err != nil {
+		return 0, false
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || pid <= 0 || !processAlive(pid) {
+		return 0, false
+	}
+	return pid, true
+}
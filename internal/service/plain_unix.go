@@ -0,0 +1,76 @@
+//go:build !windows
+
+package service
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+// detachedProcAttr starts a process in its own session, so it outlives the
+// terminal, running as username.
+func detachedProcAttr(username string) (*syscall.SysProcAttr, error) {
+	attr := &syscall.SysProcAttr{Setsid: true}
+	uid, gid, err := lookupUser(username)
+	if err != nil {
+		return nil, err
+	}
+	if uid != os.Getuid() {
+		attr.Credential = &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid)}
+	}
+	return attr, nil
+}
+
+// prepareRuntimeDir creates the directory for control sockets, owned by
+// username.
+func prepareRuntimeDir(username string) error {
+	uid, gid, err := lookupUser(username)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(runtimeDir, 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", runtimeDir, err)
+	}
+	if uid != os.Getuid() {
+		return os.Chown(runtimeDir, uid, gid)
+	}
+	return nil
+}
+
+// lookupUser returns the uid and gid of username, or of the current user if
+// username is empty.
+func lookupUser(username string) (uid, gid int, err error) {
+	if username == "" {
+		return os.Getuid(), os.Getgid(), nil
+	}
+	u, err := user.Lookup(username)
+	if err != nil {
+		return 0, 0, err
+	}
+	if uid, err = strconv.Atoi(u.Uid); err != nil {
+		return 0, 0, err
+	}
+	if gid, err = strconv.Atoi(u.Gid); err != nil {
+		return 0, 0, err
+	}
+	return uid, gid, nil
+}
+
+// processAlive reports whether a process with the pid exists.
+func processAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
+
+// terminateProcess asks a process to shut down.
+func terminateProcess(pid int) error {
+	return syscall.Kill(pid, syscall.SIGTERM)
+}
+
+// killProcess kills a process that did not shut down.
+func killProcess(pid int) error {
+	return syscall.Kill(pid, syscall.SIGKILL)
+}
@@ -0,0 +1,44 @@
+//go:build windows
+
+package service
+
+import (
+	"os"
+	"syscall"
+)
+
+// detachedProcAttr starts a process without a console of its own. Windows
+// processes always run as the user starting them.
+func detachedProcAttr(string) (*syscall.SysProcAttr, error) {
+	return &syscall.SysProcAttr{HideWindow: true}, nil
+}
+
+// prepareRuntimeDir does nothing; control sockets do not live in a shared
+// runtime directory on Windows.
+func prepareRuntimeDir(string) error {
+	return nil
+}
+
+// processAlive reports whether a process with the pid exists.
+func processAlive(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	p.Release()
+	return true
+}
+
+// terminateProcess stops a process. Windows has no SIGTERM, so it is killed.
+func terminateProcess(pid int) error {
+	return killProcess(pid)
+}
+
+// killProcess kills a process.
+func killProcess(pid int) error {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+	return p.Kill()
+}
@@ -1,4 +1,3 @@
-// Package service provides systemd service management for Half-Tunnel.
 package service
 
 import (
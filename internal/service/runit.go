@@ -0,0 +1,200 @@
+package service
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const runitRunTemplate = `#!/bin/sh
+# Half-Tunnel {{.TypeTitle}}, installed by "ht {{.Type}} install"
+exec 2>&1
+mkdir -p /run/half-tunnel
+chown {{.User}} /run/half-tunnel
+ulimit -n 65535
+cd {{.WorkingDir}} || exit 1
+exec chpst -u {{.User}} {{.BinaryPath}} -config {{.ConfigPath}}
+`
+
+const runitLogTemplate = `#!/bin/sh
+mkdir -p /var/log/half-tunnel-{{.Type}}
+exec svlogd -tt /var/log/half-tunnel-{{.Type}}
+`
+
+// runitServiceDirs are the directories runsvdir supervises on common
+// distributions, in lookup order.
+var runitServiceDirs = []string{"/var/service", "/etc/service", "/etc/runit/runsvdir/default"}
+
+// runitSuperviseTimeout is how long Start waits for runsv to pick up a newly
+// linked service.
+const runitSuperviseTimeout = 10 * time.Second
+
+// runit manages the services as runit service directories under /etc/sv,
+// as on Void Linux and in many containers. A "down" file keeps a service
+// from starting when runsvdir starts until it is enabled.
+type runit struct{}
+
+func (runit) Name() string { return "runit" }
+
+func (r runit) Available() bool {
+	if _, err := exec.LookPath("sv"); err != nil {
+		return false
+	}
+	return r.serviceDir() != ""
+}
+
+// serviceDir returns the directory runsvdir supervises.
+func (runit) serviceDir() string {
+	for _, dir := range runitServiceDirs {
+		if info, err := os.Stat(dir); err == nil && info.IsDir() {
+			return dir
+		}
+	}
+	return ""
+}
+
+// dir returns the service directory of a service.
+func (runit) dir(t ServiceType) string {
+	return filepath.Join("/etc/sv", ServiceName(t))
+}
+
+func (r runit) FilePath(t ServiceType) string {
+	return filepath.Join(r.dir(t), "run")
+}
+
+func (r runit) IsInstalled(t ServiceType) bool { return fileExists(r.FilePath(t)) }
+
+func (r runit) Install(cfg *ServiceConfig) error {
+	dir := r.dir(cfg.Type)
+	enabled := r.enabled(cfg.Type)
+	if err := os.MkdirAll(filepath.Join(dir, "log"), 0755); err != nil {
+		return fmt.Errorf("failed to create service directory: %w (try running with sudo)", err)
+	}
+	if err := writeServiceFile(r.FilePath(cfg.Type), runitRunTemplate, cfg, 0755); err != nil {
+		return err
+	}
+	if err := writeServiceFile(filepath.Join(dir, "log", "run"), runitLogTemplate, cfg, 0755); err != nil {
+		return err
+	}
+
+	// Installed services start only when started or enabled; a reinstall
+	// keeps the service enabled
+	if !enabled {
+		if err := os.WriteFile(filepath.Join(dir, "down"), nil, 0644); err != nil {
+			return err
+		}
+	}
+
+	serviceDir := r.serviceDir()
+	if serviceDir == "" {
+		return fmt.Errorf("no runsvdir service directory found (tried %s)", strings.Join(runitServiceDirs, ", "))
+	}
+	link := filepath.Join(serviceDir, ServiceName(cfg.Type))
+	if _, err := os.Lstat(link); os.IsNotExist(err) {
+		if err := os.Symlink(dir, link); err != nil {
+			return fmt.Errorf("failed to link service: %w", err)
+		}
+	}
+	return nil
+}
+
+func (r runit) Uninstall(t ServiceType) error {
+	_ = runCommand("sv", "down", r.dir(t))
+
+	if serviceDir := r.serviceDir(); serviceDir != "" {
+		link := filepath.Join(serviceDir, ServiceName(t))
+		if err := os.Remove(link); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to unlink service: %w", err)
+		}
+	}
+	if err := os.RemoveAll(r.dir(t)); err != nil {
+		return fmt.Errorf("failed to remove service directory: %w", err)
+	}
+	return nil
+}
+
+func (r runit) Start(t ServiceType) error {
+	if err := r.waitSupervised(t); err != nil {
+		return err
+	}
+	return runCommand("sv", "up", r.dir(t))
+}
+
+func (r runit) Stop(t ServiceType) error { return runCommand("sv", "down", r.dir(t)) }
+
+func (r runit) Restart(t ServiceType) error {
+	if err := r.waitSupervised(t); err != nil {
+		return err
+	}
+	return runCommand("sv", "restart", r.dir(t))
+}
+
+func (r runit) Enable(t ServiceType) error {
+	if err := os.Remove(filepath.Join(r.dir(t), "down")); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (r runit) Disable(t ServiceType) error {
+	return os.WriteFile(filepath.Join(r.dir(t), "down"), nil, 0644)
+}
+
+func (r runit) Status(t ServiceType) (string, error) {
+	output, err := exec.Command("sv", "status", r.dir(t)).CombinedOutput()
+	if err == nil && !strings.HasPrefix(string(output), "run:") {
+		err = fmt.Errorf("service is not running")
+	}
+	return string(output), err
+}
+
+func (r runit) IsRunning(t ServiceType) bool {
+	_, err := r.Status(t)
+	return err == nil
+}
+
+func (runit) LogCommand(t ServiceType, follow bool, lines int) *exec.Cmd {
+	return tailCommand(fmt.Sprintf("/var/log/%s/current", ServiceName(t)), follow, lines)
+}
+
+func (r runit) InstalledPaths(t ServiceType) (binary, config string, err error) {
+	data, err := os.ReadFile(r.FilePath(t))
+	if err != nil {
+		return "", "", err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if binary, config, ok := parseCommandLine(line); ok {
+			return binary, config, nil
+		}
+	}
+	return "", "", fmt.Errorf("no command in %s", r.FilePath(t))
+}
+
+// enabled reports whether the service starts when runsvdir starts.
+func (r runit) enabled(t ServiceType) bool {
+	_, err := os.Stat(r.FilePath(t))
+	if err != nil {
+		return false
+	}
+	_, err = os.Stat(filepath.Join(r.dir(t), "down"))
+	return os.IsNotExist(err)
+}
+
+// waitSupervised waits for runsv to supervise a newly linked service;
+// runsvdir scans for new services every five seconds.
+func (r runit) waitSupervised(t ServiceType) error {
+	ok := filepath.Join(r.dir(t), "supervise", "ok")
+	deadline := time.Now().Add(runitSuperviseTimeout)
+	for {
+		if _, err := os.Stat(ok); err == nil {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%s is not supervised by runsvdir; is runit running?", ServiceName(t))
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+}
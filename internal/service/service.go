@@ -1,11 +1,13 @@
-// Package service provides systemd service management for Half-Tunnel.
+// Package service provides service management for Half-Tunnel with
+// systemd, OpenRC, runit, launchd, the Windows service control manager or
+// as a plain background process.
 package service
 
 import (
 	"bufio"
+	"bytes"
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
 	"text/template"
@@ -28,45 +30,29 @@ type ServiceConfig struct {
 	ConfigPath string
 	User       string
 	WorkingDir string
+	// InitSystem names the init system to install with; empty detects it
+	InitSystem string
 }
 
-const serviceTemplate = `[Unit]
-Description=Half-Tunnel {{.TypeTitle}}
-Documentation=https://github.com/sahmadiut/half-tunnel
-After=network.target
-Wants=network-online.target
-
-[Service]
-Type=simple
-ExecStart={{.BinaryPath}} -config {{.ConfigPath}}
-Restart=always
-RestartSec=5
-User={{.User}}
-WorkingDirectory={{.WorkingDir}}
-LimitNOFILE=65535
-StandardOutput=journal
-StandardError=journal
-SyslogIdentifier=half-tunnel-{{.Type}}
-
-[Install]
-WantedBy=multi-user.target
-`
-
-// ServiceName returns the systemd service name for the given type.
+// runtimeDir holds the control sockets of the services.
+const runtimeDir = "/run/half-tunnel"
+
+// ServiceName returns the service name for the given type.
 func ServiceName(t ServiceType) string {
 	return fmt.Sprintf("half-tunnel-%s", t)
 }
 
-// ServiceFilePath returns the systemd service file path for the given type.
-func ServiceFilePath(t ServiceType) string {
-	return fmt.Sprintf("/etc/systemd/system/%s.service", ServiceName(t))
-}
-
-// Install installs the systemd service.
+// Install installs the service with the configured or detected init system.
 func Install(cfg *ServiceConfig) error {
-	// Check if systemd is available
-	if !isSystemdAvailable() {
-		return fmt.Errorf("systemd is not available on this system")
+	initSystem := DetectInitSystem()
+	if cfg.InitSystem != "" {
+		var err error
+		if initSystem, err = LookupInitSystem(cfg.InitSystem); err != nil {
+			return err
+		}
+		if !initSystem.Available() {
+			return fmt.Errorf("%s is not available on this system", initSystem.Name())
+		}
 	}
 
 	// Validate binary exists
@@ -87,10 +73,21 @@ func Install(cfg *ServiceConfig) error {
 		cfg.WorkingDir = filepath.Dir(cfg.ConfigPath)
 	}
 
-	// Generate service file content
-	tmpl, err := template.New("service").Parse(serviceTemplate)
+	// Replace an installation with another init system
+	if installed := InitSystemFor(cfg.Type); installed.Name() != initSystem.Name() && IsInstalled(cfg.Type) {
+		if err := installed.Uninstall(cfg.Type); err != nil {
+			return fmt.Errorf("failed to remove the %s service: %w", installed.Name(), err)
+		}
+	}
+
+	return initSystem.Install(cfg)
+}
+
+// renderTemplate renders a service definition template for cfg.
+func renderTemplate(text string, cfg *ServiceConfig) ([]byte, error) {
+	tmpl, err := template.New("service").Parse(text)
 	if err != nil {
-		return fmt.Errorf("failed to parse service template: %w", err)
+		return nil, fmt.Errorf("failed to parse service template: %w", err)
 	}
 
 	data := struct {
@@ -109,116 +106,69 @@ func Install(cfg *ServiceConfig) error {
 		WorkingDir: cfg.WorkingDir,
 	}
 
-	// Create service file
-	servicePath := ServiceFilePath(cfg.Type)
-	f, err := os.Create(servicePath)
-	if err != nil {
-		return fmt.Errorf("failed to create service file: %w (try running with sudo)", err)
-	}
-	defer f.Close()
-
-	if err := tmpl.Execute(f, data); err != nil {
-		return fmt.Errorf("failed to write service file: %w", err)
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return nil, fmt.Errorf("failed to write service file: %w", err)
 	}
-
-	// Reload systemd
-	if err := runSystemctl("daemon-reload"); err != nil {
-		return fmt.Errorf("failed to reload systemd: %w", err)
-	}
-
-	return nil
+	return buf.Bytes(), nil
 }
 
-// Uninstall removes the systemd service.
+// Uninstall stops and removes the service.
 func Uninstall(t ServiceType) error {
-	if !isSystemdAvailable() {
-		return fmt.Errorf("systemd is not available on this system")
-	}
-
-	serviceName := ServiceName(t)
-
-	// Stop the service if running
-	_ = runSystemctl("stop", serviceName)
-
-	// Disable the service
-	_ = runSystemctl("disable", serviceName)
-
-	// Remove service file
-	servicePath := ServiceFilePath(t)
-	if err := os.Remove(servicePath); err != nil && !os.IsNotExist(err) {
-		return fmt.Errorf("failed to remove service file: %w", err)
-	}
-
-	// Reload systemd
-	if err := runSystemctl("daemon-reload"); err != nil {
-		return fmt.Errorf("failed to reload systemd: %w", err)
-	}
-
-	return nil
+	return InitSystemFor(t).Uninstall(t)
 }
 
-// Start starts the systemd service.
+// Start starts the service.
 func Start(t ServiceType) error {
-	return runSystemctl("start", ServiceName(t))
+	return InitSystemFor(t).Start(t)
 }
 
-// Stop stops the systemd service.
+// Stop stops the service.
 func Stop(t ServiceType) error {
-	return runSystemctl("stop", ServiceName(t))
+	return InitSystemFor(t).Stop(t)
 }
 
-// Restart restarts the systemd service.
+// Restart restarts the service.
 func Restart(t ServiceType) error {
-	return runSystemctl("restart", ServiceName(t))
+	return InitSystemFor(t).Restart(t)
 }
 
-// Enable enables the systemd service to start on boot.
+// Enable enables the service to start on boot.
 func Enable(t ServiceType) error {
-	return runSystemctl("enable", ServiceName(t))
+	return InitSystemFor(t).Enable(t)
 }
 
-// Disable disables the systemd service from starting on boot.
+// Disable disables the service from starting on boot.
 func Disable(t ServiceType) error {
-	return runSystemctl("disable", ServiceName(t))
+	return InitSystemFor(t).Disable(t)
 }
 
-// Status returns the status of the systemd service.
+// Status returns the status of the service.
 func Status(t ServiceType) (string, error) {
-	cmd := exec.Command("systemctl", "status", ServiceName(t))
-	output, err := cmd.CombinedOutput()
-	// systemctl status returns non-zero for inactive services
-	return string(output), err
+	return InitSystemFor(t).Status(t)
 }
 
-// IsInstalled checks if the service is installed.
+// IsInstalled checks if the service is installed with any init system.
 func IsInstalled(t ServiceType) bool {
-	_, err := os.Stat(ServiceFilePath(t))
-	return err == nil
+	return InitSystemFor(t).IsInstalled(t)
+}
+
+// InstalledPaths returns the binary and config paths in the installed
+// service definition.
+func InstalledPaths(t ServiceType) (binary, config string, err error) {
+	return InitSystemFor(t).InstalledPaths(t)
 }
 
 // IsRunning checks if the service is currently running.
 func IsRunning(t ServiceType) bool {
-	cmd := exec.Command("systemctl", "is-active", "--quiet", ServiceName(t))
-	return cmd.Run() == nil
+	return InitSystemFor(t).IsRunning(t)
 }
 
 // Logs streams logs for the service.
 // If follow is true, it follows the log output (like tail -f).
 // If lines is > 0, it shows only the last N lines.
 func Logs(t ServiceType, follow bool, lines int) error {
-	args := []string{"-u", ServiceName(t)}
-
-	if lines > 0 {
-		args = append(args, "-n", fmt.Sprintf("%d", lines))
-	} else {
-		args = append(args, "-n", "100") // default to last 100 lines
-	}
-
-	if follow {
-		args = append(args, "-f")
-	}
-
-	cmd := exec.Command("journalctl", args...)
+	cmd := InitSystemFor(t).LogCommand(t, follow, lines)
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 	return cmd.Run()
@@ -226,31 +176,10 @@ func Logs(t ServiceType, follow bool, lines int) error {
 
 // LogsOutput returns logs as a string.
 func LogsOutput(t ServiceType, lines int) (string, error) {
-	args := []string{"-u", ServiceName(t), "--no-pager"}
-
-	if lines > 0 {
-		args = append(args, "-n", fmt.Sprintf("%d", lines))
-	}
-
-	cmd := exec.Command("journalctl", args...)
-	output, err := cmd.CombinedOutput()
+	output, err := InitSystemFor(t).LogCommand(t, false, lines).CombinedOutput()
 	return string(output), err
 }
 
-// runSystemctl runs a systemctl command.
-func runSystemctl(args ...string) error {
-	cmd := exec.Command("systemctl", args...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	return cmd.Run()
-}
-
-// isSystemdAvailable checks if systemd is available.
-func isSystemdAvailable() bool {
-	_, err := os.Stat("/run/systemd/system")
-	return err == nil
-}
-
 // toTitleCase converts a string to title case (first letter uppercase).
 func toTitleCase(s string) string {
 	if s == "" {
@@ -259,48 +188,27 @@ func toTitleCase(s string) string {
 	return strings.ToUpper(s[:1]) + s[1:]
 }
 
-// GetDefaultBinaryPath returns the default binary path for the given service type.
-func GetDefaultBinaryPath(t ServiceType) string {
-	switch t {
-	case ClientService:
-		return "/usr/local/bin/ht-client"
-	case ServerService:
-		return "/usr/local/bin/ht-server"
-	default:
-		return ""
-	}
-}
-
-// GetDefaultConfigPath returns the default config path for the given service type.
-func GetDefaultConfigPath(t ServiceType) string {
-	switch t {
-	case ClientService:
-		return "/etc/half-tunnel/client.yml"
-	case ServerService:
-		return "/etc/half-tunnel/server.yml"
-	default:
-		return ""
-	}
-}
-
-// EnsureConfigDir ensures the config directory exists.
-func EnsureConfigDir() error {
-	return os.MkdirAll("/etc/half-tunnel", 0755)
-}
-
 // PrintServiceInfo prints information about the installed service.
 func PrintServiceInfo(t ServiceType) {
-	serviceName := ServiceName(t)
-	fmt.Printf("\nService: %s\n", serviceName)
-	fmt.Printf("Service file: %s\n", ServiceFilePath(t))
+	initSystem := InitSystemFor(t)
+	fmt.Printf("\nService: %s\n", ServiceName(t))
+	fmt.Printf("Init system: %s\n", initSystem.Name())
+	fmt.Printf("Service file: %s\n", initSystem.FilePath(t))
 	fmt.Printf("Installed: %v\n", IsInstalled(t))
 	fmt.Printf("Running: %v\n", IsRunning(t))
+	sudo := "sudo "
+	if initSystem.Name() == "windows" {
+		sudo = ""
+	}
 	fmt.Println("\nUseful commands:")
-	fmt.Printf("  Start:   sudo systemctl start %s\n", serviceName)
-	fmt.Printf("  Stop:    sudo systemctl stop %s\n", serviceName)
-	fmt.Printf("  Restart: sudo systemctl restart %s\n", serviceName)
-	fmt.Printf("  Status:  sudo systemctl status %s\n", serviceName)
-	fmt.Printf("  Logs:    journalctl -u %s -f\n", serviceName)
+	fmt.Printf("  Start:   %sht %s start\n", sudo, t)
+	fmt.Printf("  Stop:    %sht %s stop\n", sudo, t)
+	fmt.Printf("  Restart: %sht %s restart\n", sudo, t)
+	fmt.Printf("  Status:  %sht %s status\n", sudo, t)
+	fmt.Printf("  Logs:    %sht %s logs\n", sudo, t)
+	if initSystem.Name() == "plain" {
+		fmt.Println("\nThe plain init system does not restart the service or start it on boot.")
+	}
 }
 
 // InteractiveInstall performs an interactive installation prompting for paths.
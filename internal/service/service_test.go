@@ -1,8 +1,10 @@
-// Package service provides systemd service management for Half-Tunnel.
 package service
 
 import (
+	"bytes"
+	"strings"
 	"testing"
+	"text/template"
 )
 
 func TestServiceName(t *testing.T) {
@@ -91,3 +93,119 @@ func TestIsInstalled_NotInstalled(t *testing.T) {
 		t.Skip("half-tunnel-server service is already installed")
 	}
 }
+
+func TestParseExecStart(t *testing.T) {
+	var unit bytes.Buffer
+	tmpl := template.Must(template.New("service").Parse(serviceTemplate))
+	if err := tmpl.Execute(&unit, map[string]string{
+		"Type":       "client",
+		"TypeTitle":  "Client",
+		"BinaryPath": "/opt/ht/ht-client",
+		"ConfigPath": "/etc/ht/client.yml",
+		"User":       "root",
+		"WorkingDir": "/",
+	}); err != nil {
+		t.Fatalf("Failed to render unit: %v", err)
+	}
+
+	binary, config, ok := parseExecStart(unit.String())
+	if !ok {
+		t.Fatal("Expected ExecStart to be found")
+	}
+	if binary != "/opt/ht/ht-client" {
+		t.Errorf("expected binary %q, got %q", "/opt/ht/ht-client", binary)
+	}
+	if config != "/etc/ht/client.yml" {
+		t.Errorf("expected config %q, got %q", "/etc/ht/client.yml", config)
+	}
+
+	if _, _, ok := parseExecStart("[Unit]\nDescription=x\n"); ok {
+		t.Error("Expected no ExecStart")
+	}
+}
+
+func TestInitSystemDefinitions(t *testing.T) {
+	cfg := &ServiceConfig{
+		Type:       ClientService,
+		BinaryPath: "/opt/ht/ht-client",
+		ConfigPath: "/etc/ht/client.yml",
+		User:       "nobody",
+		WorkingDir: "/etc/ht",
+	}
+
+	tests := []struct {
+		name  string
+		tmpl  string
+		parse func(string) (string, string, bool)
+	}{
+		{"systemd", serviceTemplate, parseExecStart},
+		{"openrc", openRCTemplate, func(s string) (string, string, bool) {
+			vars := parseAssignments(s)
+			return parseCommandLine(vars["command"] + " " + vars["command_args"])
+		}},
+		{"runit", runitRunTemplate, func(s string) (string, string, bool) {
+			for _, line := range strings.Split(s, "\n") {
+				if binary, config, ok := parseCommandLine(line); ok {
+					return binary, config, true
+				}
+			}
+			return "", "", false
+		}},
+		{"launchd", launchdTemplate, parseProgramArguments},
+		{"plain", plainTemplate, func(s string) (string, string, bool) {
+			vars := parseAssignments(s)
+			return vars["BINARY"], vars["CONFIG"], vars["USER"] == "nobody"
+		}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			content, err := renderTemplate(tc.tmpl, cfg)
+			if err != nil {
+				t.Fatalf("Failed to render: %v", err)
+			}
+			binary, config, ok := tc.parse(string(content))
+			if !ok || binary != cfg.BinaryPath || config != cfg.ConfigPath {
+				t.Errorf("expected %q and %q, got %q and %q", cfg.BinaryPath, cfg.ConfigPath, binary, config)
+			}
+		})
+	}
+}
+
+func TestParseLaunchctlPrint(t *testing.T) {
+	output := `system/half-tunnel-client = {
+	active count = 1
+	path = /Library/LaunchDaemons/half-tunnel-client.plist
+	state = running
+
+	program = /usr/local/bin/ht-client
+	pid = 4242
+	endpoints = {
+		state = active
+	}
+}`
+	state, pid := parseLaunchctlPrint(output)
+	if state != "running" || pid != "4242" {
+		t.Errorf("Expected running with pid 4242, got %q with pid %q", state, pid)
+	}
+
+	state, pid = parseLaunchctlPrint("system/half-tunnel-client = {\n\tstate = not running\n}")
+	if state != "not running" || pid != "" {
+		t.Errorf("Expected not running without pid, got %q with pid %q", state, pid)
+	}
+}
+
+func TestLookupInitSystem(t *testing.T) {
+	for _, name := range InitSystemNames() {
+		s, err := LookupInitSystem(name)
+		if err != nil || s.Name() != name {
+			t.Errorf("expected init system %q, got %v", name, err)
+		}
+	}
+	if _, err := LookupInitSystem("upstart"); err == nil {
+		t.Error("Expected error for an unknown init system")
+	}
+	if !(plain{}).Available() {
+		t.Error("Expected the plain init system to be available everywhere")
+	}
+}
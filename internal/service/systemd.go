@@ -0,0 +1,162 @@
+package service
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+const serviceTemplate = `[Unit]
+Description=Half-Tunnel {{.TypeTitle}}
+Documentation=https://github.com/sahmadiut/half-tunnel
+After=network.target
+Wants=network-online.target
+
+[Service]
+Type=simple
+ExecStart={{.BinaryPath}} -config {{.ConfigPath}}
+Restart=always
+RestartSec=5
+User={{.User}}
+WorkingDirectory={{.WorkingDir}}
+LimitNOFILE=65535
+RuntimeDirectory=half-tunnel
+RuntimeDirectoryPreserve=yes
+StandardOutput=journal
+StandardError=journal
+SyslogIdentifier=half-tunnel-{{.Type}}
+
+[Install]
+WantedBy=multi-user.target
+`
+
+// systemd manages the services as systemd units.
+type systemd struct{}
+
+func (systemd) Name() string { return "systemd" }
+
+func (systemd) Available() bool { return IsSystemdAvailable() }
+
+func (systemd) FilePath(t ServiceType) string { return ServiceFilePath(t) }
+
+func (s systemd) IsInstalled(t ServiceType) bool { return fileExists(s.FilePath(t)) }
+
+func (s systemd) Install(cfg *ServiceConfig) error {
+	if err := writeServiceFile(s.FilePath(cfg.Type), serviceTemplate, cfg, 0644); err != nil {
+		return err
+	}
+	if err := runSystemctl("daemon-reload"); err != nil {
+		return fmt.Errorf("failed to reload systemd: %w", err)
+	}
+	return nil
+}
+
+func (s systemd) Uninstall(t ServiceType) error {
+	serviceName := ServiceName(t)
+
+	// Stop the service if running
+	_ = runSystemctl("stop", serviceName)
+
+	// Disable the service
+	_ = runSystemctl("disable", serviceName)
+
+	// Remove service file
+	if err := os.Remove(s.FilePath(t)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove service file: %w", err)
+	}
+
+	// Reload systemd
+	if err := runSystemctl("daemon-reload"); err != nil {
+		return fmt.Errorf("failed to reload systemd: %w", err)
+	}
+	return nil
+}
+
+func (systemd) Start(t ServiceType) error { return runSystemctl("start", ServiceName(t)) }
+
+func (systemd) Stop(t ServiceType) error { return runSystemctl("stop", ServiceName(t)) }
+
+func (systemd) Restart(t ServiceType) error { return runSystemctl("restart", ServiceName(t)) }
+
+func (systemd) Enable(t ServiceType) error { return runSystemctl("enable", ServiceName(t)) }
+
+func (systemd) Disable(t ServiceType) error { return runSystemctl("disable", ServiceName(t)) }
+
+func (systemd) Status(t ServiceType) (string, error) {
+	cmd := exec.Command("systemctl", "status", ServiceName(t))
+	output, err := cmd.CombinedOutput()
+	// systemctl status returns non-zero for inactive services
+	return string(output), err
+}
+
+func (systemd) IsRunning(t ServiceType) bool {
+	cmd := exec.Command("systemctl", "is-active", "--quiet", ServiceName(t))
+	return cmd.Run() == nil
+}
+
+func (systemd) LogCommand(t ServiceType, follow bool, lines int) *exec.Cmd {
+	args := []string{"-u", ServiceName(t), "--no-pager"}
+
+	if lines > 0 {
+		args = append(args, "-n", fmt.Sprintf("%d", lines))
+	} else {
+		args = append(args, "-n", "100") // default to last 100 lines
+	}
+
+	if follow {
+		args = append(args, "-f")
+	}
+
+	return exec.Command("journalctl", args...)
+}
+
+func (s systemd) InstalledPaths(t ServiceType) (binary, config string, err error) {
+	data, err := os.ReadFile(s.FilePath(t))
+	if err != nil {
+		return "", "", err
+	}
+	binary, config, ok := parseExecStart(string(data))
+	if !ok {
+		return "", "", fmt.Errorf("no ExecStart in %s", s.FilePath(t))
+	}
+	return binary, config, nil
+}
+
+// ServiceFilePath returns the systemd service file path for the given type.
+func ServiceFilePath(t ServiceType) string {
+	return fmt.Sprintf("/etc/systemd/system/%s.service", ServiceName(t))
+}
+
+// parseExecStart returns the binary and -config argument of a unit file's
+// ExecStart line.
+func parseExecStart(unit string) (binary, config string, ok bool) {
+	for _, line := range strings.Split(unit, "\n") {
+		cmdline, found := strings.CutPrefix(strings.TrimSpace(line), "ExecStart=")
+		if !found {
+			continue
+		}
+		fields := strings.Fields(cmdline)
+		if len(fields) == 0 {
+			return "", "", false
+		}
+		for i := 1; i < len(fields)-1; i++ {
+			if fields[i] == "-config" || fields[i] == "--config" {
+				config = fields[i+1]
+			}
+		}
+		return fields[0], config, true
+	}
+	return "", "", false
+}
+
+// runSystemctl runs a systemctl command.
+func runSystemctl(args ...string) error {
+	return runCommand("systemctl", args...)
+}
+
+// IsSystemdAvailable checks if systemd is running on this system.
+func IsSystemdAvailable() bool {
+	_, err := os.Stat("/run/systemd/system")
+	return err == nil
+}
@@ -0,0 +1,277 @@
+//go:build windows
+
+package service
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/eventlog"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// windowsStopTimeout is how long Stop waits for the service to stop.
+const windowsStopTimeout = 20 * time.Second
+
+// initSystems lists the supported init systems; Windows has only its
+// service control manager.
+var initSystems = []InitSystem{windowsService{}}
+
+// windowsService manages the services with the Windows service control
+// manager. The services run as LocalSystem, restart after failures and log
+// to the Application event log with their service name as the source.
+type windowsService struct{}
+
+func (windowsService) Name() string { return "windows" }
+
+func (windowsService) Available() bool { return true }
+
+func (windowsService) FilePath(t ServiceType) string {
+	return `HKLM\SYSTEM\CurrentControlSet\Services\` + ServiceName(t)
+}
+
+func (windowsService) IsInstalled(t ServiceType) bool {
+	return withService(t, func(*mgr.Service) error { return nil }) == nil
+}
+
+func (windowsService) Install(cfg *ServiceConfig) error {
+	if cfg.User != "root" && cfg.User != "" && !strings.EqualFold(cfg.User, "LocalSystem") {
+		return fmt.Errorf("services run as LocalSystem on Windows; other users are not supported")
+	}
+
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("failed to connect to the service manager: %w (run as Administrator)", err)
+	}
+	defer m.Disconnect()
+
+	config := mgr.Config{
+		DisplayName: "Half-Tunnel " + toTitleCase(string(cfg.Type)),
+		Description: "Half-Tunnel split-path tunnel " + string(cfg.Type),
+		StartType:   mgr.StartManual,
+	}
+	s, err := m.OpenService(ServiceName(cfg.Type))
+	if err == nil {
+		// Reinstalling keeps the start type
+		current, err := s.Config()
+		if err != nil {
+			s.Close()
+			return err
+		}
+		current.BinaryPathName = windows.ComposeCommandLine([]string{cfg.BinaryPath, "-config", cfg.ConfigPath})
+		current.DisplayName = config.DisplayName
+		current.Description = config.Description
+		err = s.UpdateConfig(current)
+		if err != nil {
+			s.Close()
+			return fmt.Errorf("failed to update service: %w", err)
+		}
+	} else {
+		s, err = m.CreateService(ServiceName(cfg.Type), cfg.BinaryPath, config, "-config", cfg.ConfigPath)
+		if err != nil {
+			return fmt.Errorf("failed to create service: %w", err)
+		}
+	}
+	defer s.Close()
+
+	// Restart after crashes and after the client exits on its own
+	if err := s.SetRecoveryActions([]mgr.RecoveryAction{{Type: mgr.ServiceRestart, Delay: 5 * time.Second}}, 24*60*60); err != nil {
+		return fmt.Errorf("failed to set recovery actions: %w", err)
+	}
+	if err := s.SetRecoveryActionsOnNonCrashFailures(true); err != nil {
+		return fmt.Errorf("failed to set recovery actions: %w", err)
+	}
+
+	// The source exists already when reinstalling
+	_ = eventlog.InstallAsEventCreate(ServiceName(cfg.Type), eventlog.Error|eventlog.Warning|eventlog.Info)
+	return nil
+}
+
+func (w windowsService) Uninstall(t ServiceType) error {
+	if w.IsRunning(t) {
+		_ = w.Stop(t)
+	}
+	if err := withService(t, (*mgr.Service).Delete); err != nil {
+		return fmt.Errorf("failed to delete service: %w", err)
+	}
+	_ = eventlog.Remove(ServiceName(t))
+	return nil
+}
+
+func (windowsService) Start(t ServiceType) error {
+	return withService(t, func(s *mgr.Service) error { return s.Start() })
+}
+
+func (windowsService) Stop(t ServiceType) error {
+	return withService(t, func(s *mgr.Service) error {
+		status, err := s.Control(svc.Stop)
+		if err != nil {
+			return err
+		}
+		deadline := time.Now().Add(windowsStopTimeout)
+		for status.State != svc.Stopped {
+			if time.Now().After(deadline) {
+				return fmt.Errorf("%s did not stop within %s", ServiceName(t), windowsStopTimeout)
+			}
+			time.Sleep(300 * time.Millisecond)
+			if status, err = s.Query(); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (w windowsService) Restart(t ServiceType) error {
+	if w.IsRunning(t) {
+		if err := w.Stop(t); err != nil {
+			return err
+		}
+	}
+	return w.Start(t)
+}
+
+func (windowsService) Enable(t ServiceType) error {
+	return setStartType(t, mgr.StartAutomatic)
+}
+
+func (windowsService) Disable(t ServiceType) error {
+	return setStartType(t, mgr.StartManual)
+}
+
+func (windowsService) Status(t ServiceType) (string, error) {
+	var status strings.Builder
+	var running bool
+	err := withService(t, func(s *mgr.Service) error {
+		config, err := s.Config()
+		if err != nil {
+			return err
+		}
+		state, err := s.Query()
+		if err != nil {
+			return err
+		}
+		running = state.State == svc.Running
+		startType := "manual"
+		if config.StartType == mgr.StartAutomatic {
+			startType = "automatic"
+		}
+		fmt.Fprintf(&status, "%s (%s)\n", ServiceName(t), config.DisplayName)
+		fmt.Fprintf(&status, "  Command:    %s\n", config.BinaryPathName)
+		fmt.Fprintf(&status, "  Start type: %s\n", startType)
+		fmt.Fprintf(&status, "  State:      %s", stateName(state.State))
+		if state.ProcessId != 0 {
+			fmt.Fprintf(&status, " (pid %d)", state.ProcessId)
+		}
+		status.WriteString("\n")
+		return nil
+	})
+	if err == nil && !running {
+		err = errors.New("service is not running")
+	}
+	return status.String(), err
+}
+
+func (windowsService) IsRunning(t ServiceType) bool {
+	return withService(t, func(s *mgr.Service) error {
+		status, err := s.Query()
+		if err == nil && status.State != svc.Running {
+			err = errors.New("not running")
+		}
+		return err
+	}) == nil
+}
+
+// windowsLogScript prints the newest events of an event log source and
+// optionally polls for new ones.
+const windowsLogScript = `$source = '%s'; $follow = $%t; $last = -1
+function Show($events) {
+  foreach ($e in $events | Sort-Object Index) {
+    '{0:yyyy-MM-ddTHH:mm:ss} {1} {2}' -f $e.TimeGenerated, $e.EntryType, $e.Message.TrimEnd()
+    $script:last = $e.Index
+  }
+}
+Show (Get-EventLog -LogName Application -Source $source -Newest %d -ErrorAction SilentlyContinue)
+while ($follow) {
+  Start-Sleep -Seconds 1
+  Show (Get-EventLog -LogName Application -Source $source -Newest 200 -ErrorAction SilentlyContinue | Where-Object { $_.Index -gt $last })
+}`
+
+func (windowsService) LogCommand(t ServiceType, follow bool, lines int) *exec.Cmd {
+	if lines <= 0 {
+		lines = 100
+	}
+	script := fmt.Sprintf(windowsLogScript, ServiceName(t), follow, lines)
+	return exec.Command("powershell", "-NoProfile", "-NonInteractive", "-Command", script)
+}
+
+func (windowsService) InstalledPaths(t ServiceType) (binary, config string, err error) {
+	err = withService(t, func(s *mgr.Service) error {
+		c, err := s.Config()
+		if err != nil {
+			return err
+		}
+		args, err := windows.DecomposeCommandLine(c.BinaryPathName)
+		if err != nil || len(args) == 0 {
+			return fmt.Errorf("cannot parse the service command %q", c.BinaryPathName)
+		}
+		binary = args[0]
+		for i := 1; i < len(args)-1; i++ {
+			if args[i] == "-config" || args[i] == "--config" {
+				config = args[i+1]
+			}
+		}
+		return nil
+	})
+	return binary, config, err
+}
+
+// withService calls fn with the installed service.
+func withService(t ServiceType, fn func(*mgr.Service) error) error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("failed to connect to the service manager: %w", err)
+	}
+	defer m.Disconnect()
+	s, err := m.OpenService(ServiceName(t))
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	return fn(s)
+}
+
+// setStartType changes whether the service starts on boot.
+func setStartType(t ServiceType, startType uint32) error {
+	return withService(t, func(s *mgr.Service) error {
+		config, err := s.Config()
+		if err != nil {
+			return err
+		}
+		config.StartType = startType
+		return s.UpdateConfig(config)
+	})
+}
+
+// stateName describes a service state.
+func stateName(state svc.State) string {
+	switch state {
+	case svc.Stopped:
+		return "stopped"
+	case svc.StartPending:
+		return "starting"
+	case svc.StopPending:
+		return "stopping"
+	case svc.Running:
+		return "running"
+	case svc.Paused, svc.PausePending, svc.ContinuePending:
+		return "paused"
+	default:
+		return fmt.Sprintf("state %d", state)
+	}
+}
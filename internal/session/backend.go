@@ -0,0 +1,182 @@
+package session
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrSessionLimit is returned when a backend refuses a new session because
+// the global session limit has been reached.
+var ErrSessionLimit = errors.New("session limit reached")
+
+// Backend shares session and NAT state between server instances. A Store
+// keeps its sessions locally and mirrors their lifecycle to the backend, so
+// instances can enforce a global session limit and learn about streams a
+// previous instance left behind.
+type Backend interface {
+	// Register records a session owned by node. Registering an existing
+	// session refreshes it; a new session fails with ErrSessionLimit when the
+	// backend is full.
+	Register(id uuid.UUID, node string) error
+	// Refresh extends the lifetime of the given sessions.
+	Refresh(ids []uuid.UUID) error
+	// Unregister removes a session and its streams.
+	Unregister(id uuid.UUID) error
+	// Owner returns the node that registered a session.
+	Owner(id uuid.UUID) (string, bool, error)
+	// Count returns the number of sessions across all instances.
+	Count() (int, error)
+	// AddStream records a stream's destination.
+	AddStream(id uuid.UUID, streamID uint32, dest string) error
+	// RemoveStream forgets a stream.
+	RemoveStream(id uuid.UUID, streamID uint32) error
+	// Streams returns the recorded streams of a session by stream ID.
+	Streams(id uuid.UUID) (map[uint32]string, error)
+	// Close releases the backend's resources.
+	Close() error
+}
+
+// MemoryBackend is an in-process Backend. It is useful for tests and for
+// embedding several servers in one process.
+type MemoryBackend struct {
+	maxSessions int
+	ttl         time.Duration
+	sessions    map[uuid.UUID]*memorySession
+	mu          sync.Mutex
+}
+
+type memorySession struct {
+	node    string
+	expires time.Time
+	streams map[uint32]string
+}
+
+// NewMemoryBackend creates an in-process backend. Sessions that are not
+// refreshed within ttl expire; maxSessions of 0 means no limit.
+func NewMemoryBackend(maxSessions int, ttl time.Duration) *MemoryBackend {
+	return &MemoryBackend{
+		maxSessions: maxSessions,
+		ttl:         ttl,
+		sessions:    make(map[uuid.UUID]*memorySession),
+	}
+}
+
+// Register records a session owned by node.
+func (m *MemoryBackend) Register(id uuid.UUID, node string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.expire()
+	if sess, exists := m.sessions[id]; exists {
+		sess.node = node
+		sess.expires = time.Now().Add(m.ttl)
+		return nil
+	}
+	if m.maxSessions > 0 && len(m.sessions) >= m.maxSessions {
+		return ErrSessionLimit
+	}
+	m.sessions[id] = &memorySession{
+		node:    node,
+		expires: time.Now().Add(m.ttl),
+		streams: make(map[uint32]string),
+	}
+	return nil
+}
+
+// Refresh extends the lifetime of the given sessions.
+func (m *MemoryBackend) Refresh(ids []uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	expires := time.Now().Add(m.ttl)
+	for _, id := range ids {
+		if sess, exists := m.sessions[id]; exists {
+			sess.expires = expires
+		}
+	}
+	return nil
+}
+
+// Unregister removes a session and its streams.
+func (m *MemoryBackend) Unregister(id uuid.UUID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.sessions, id)
+	return nil
+}
+
+// Owner returns the node that registered a session.
+func (m *MemoryBackend) Owner(id uuid.UUID) (string, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.expire()
+	sess, exists := m.sessions[id]
+	if !exists {
+		return "", false, nil
+	}
+	return sess.node, true, nil
+}
+
+// Count returns the number of registered sessions.
+func (m *MemoryBackend) Count() (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.expire()
+	return len(m.sessions), nil
+}
+
+// AddStream records a stream's destination.
+func (m *MemoryBackend) AddStream(id uuid.UUID, streamID uint32, dest string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if sess, exists := m.sessions[id]; exists {
+		sess.streams[streamID] = dest
+	}
+	return nil
+}
+
+// RemoveStream forgets a stream.
+func (m *MemoryBackend) RemoveStream(id uuid.UUID, streamID uint32) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if sess, exists := m.sessions[id]; exists {
+		delete(sess.streams, streamID)
+	}
+	return nil
+}
+
+// Streams returns the recorded streams of a session.
+func (m *MemoryBackend) Streams(id uuid.UUID) (map[uint32]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	streams := make(map[uint32]string)
+	if sess, exists := m.sessions[id]; exists {
+		for streamID, dest := range sess.streams {
+			streams[streamID] = dest
+		}
+	}
+	return streams, nil
+}
+
+// Close is a no-op for the in-process backend.
+func (m *MemoryBackend) Close() error {
+	return nil
+}
+
+// expire drops expired sessions. The caller must hold m.mu.
+func (m *MemoryBackend) expire() {
+	now := time.Now()
+	for id, sess := range m.sessions {
+		if now.After(sess.expires) {
+			delete(m.sessions, id)
+		}
+	}
+}
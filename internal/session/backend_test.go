@@ -0,0 +1,73 @@
+package session
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestMemoryBackendLimit(t *testing.T) {
+	b := NewMemoryBackend(2, time.Minute)
+
+	first, second := uuid.New(), uuid.New()
+	for _, id := range []uuid.UUID{first, second} {
+		if err := b.Register(id, "node-a"); err != nil {
+			t.Fatalf("Register failed: %v", err)
+		}
+	}
+	if err := b.Register(uuid.New(), "node-a"); !errors.Is(err, ErrSessionLimit) {
+		t.Errorf("Expected ErrSessionLimit, got %v", err)
+	}
+
+	// Known sessions can re-register at the limit, e.g. after a restart
+	if err := b.Register(first, "node-b"); err != nil {
+		t.Errorf("Expected re-registration to succeed, got %v", err)
+	}
+	if node, ok, _ := b.Owner(first); !ok || node != "node-b" {
+		t.Errorf("Expected owner node-b, got %q (%v)", node, ok)
+	}
+
+	_ = b.Unregister(second)
+	if err := b.Register(uuid.New(), "node-a"); err != nil {
+		t.Errorf("Expected registration after unregister to succeed, got %v", err)
+	}
+}
+
+func TestMemoryBackendExpiry(t *testing.T) {
+	b := NewMemoryBackend(0, 20*time.Millisecond)
+
+	kept, dropped := uuid.New(), uuid.New()
+	_ = b.Register(kept, "node-a")
+	_ = b.Register(dropped, "node-a")
+
+	time.Sleep(15 * time.Millisecond)
+	_ = b.Refresh([]uuid.UUID{kept})
+	time.Sleep(15 * time.Millisecond)
+
+	if n, _ := b.Count(); n != 1 {
+		t.Errorf("Expected 1 session after expiry, got %d", n)
+	}
+	if _, ok, _ := b.Owner(dropped); ok {
+		t.Error("Expected unrefreshed session to expire")
+	}
+}
+
+func TestMemoryBackendStreams(t *testing.T) {
+	b := NewMemoryBackend(0, time.Minute)
+	id := uuid.New()
+	_ = b.Register(id, "node-a")
+
+	_ = b.AddStream(id, 1, "example.com:443")
+	_ = b.AddStream(id, 2, "example.org:80")
+	_ = b.RemoveStream(id, 1)
+
+	streams, err := b.Streams(id)
+	if err != nil {
+		t.Fatalf("Streams failed: %v", err)
+	}
+	if len(streams) != 1 || streams[2] != "example.org:80" {
+		t.Errorf("Expected only stream 2, got %v", streams)
+	}
+}
@@ -0,0 +1,369 @@
+package session
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// RedisConfig holds settings for the Redis session backend.
+type RedisConfig struct {
+	// Addr is the Redis server address (host:port)
+	Addr string
+	// Password authenticates with AUTH when set
+	Password string
+	// DB selects the Redis database
+	DB int
+	// Prefix is prepended to every key, so deployments can share a server
+	Prefix string
+	// MaxSessions caps sessions across all instances (0 means no limit)
+	MaxSessions int
+	// TTL is how long a session lives without being refreshed
+	TTL time.Duration
+	// Timeout bounds dialing and each command
+	Timeout time.Duration
+}
+
+// registerScript atomically expires old sessions, checks the global limit
+// and registers a session. It returns 0 when the limit is reached.
+//
+// KEYS: sessions sorted set, session key
+// ARGV: session ID, node, now (ms), expiry (ms), max sessions, TTL (ms)
+const registerScript = `
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', ARGV[3])
+if not redis.call('ZSCORE', KEYS[1], ARGV[1]) then
+  local max = tonumber(ARGV[5])
+  if max > 0 and redis.call('ZCARD', KEYS[1]) >= max then
+    return 0
+  end
+end
+redis.call('ZADD', KEYS[1], ARGV[4], ARGV[1])
+redis.call('SET', KEYS[2], ARGV[2], 'PX', ARGV[6])
+return 1
+`
+
+// RedisBackend stores shared session state in Redis. Sessions are members
+// of a sorted set scored by expiry time, so every instance can count live
+// sessions; each session's owner and streams are kept in keys that expire
+// with it. It speaks the Redis protocol directly over one connection, which
+// is re-established after errors.
+type RedisBackend struct {
+	config RedisConfig
+	conn   net.Conn
+	rw     *bufio.ReadWriter
+	mu     sync.Mutex
+}
+
+// redisError is an error reply from Redis.
+type redisError string
+
+func (e redisError) Error() string { return "redis: " + string(e) }
+
+// NewRedisBackend connects to Redis and returns a backend.
+func NewRedisBackend(config RedisConfig) (*RedisBackend, error) {
+	if config.Timeout <= 0 {
+		config.Timeout = 5 * time.Second
+	}
+	if config.TTL <= 0 {
+		config.TTL = 5 * time.Minute
+	}
+
+	r := &RedisBackend{config: config}
+	if _, err := r.do("PING"); err != nil {
+		return nil, fmt.Errorf("failed to connect to redis at %s: %w", config.Addr, err)
+	}
+	return r, nil
+}
+
+// Register records a session owned by node.
+func (r *RedisBackend) Register(id uuid.UUID, node string) error {
+	now := time.Now()
+	reply, err := r.do("EVAL", registerScript, "2",
+		r.key("sessions"), r.key("session:"+id.String()),
+		id.String(),
+		node,
+		strconv.FormatInt(now.UnixMilli(), 10),
+		strconv.FormatInt(now.Add(r.config.TTL).UnixMilli(), 10),
+		strconv.Itoa(r.config.MaxSessions),
+		strconv.FormatInt(r.config.TTL.Milliseconds(), 10),
+	)
+	if err != nil {
+		return err
+	}
+	if n, ok := reply.(int64); ok && n == 0 {
+		return ErrSessionLimit
+	}
+	return nil
+}
+
+// Refresh extends the lifetime of the given sessions.
+func (r *RedisBackend) Refresh(ids []uuid.UUID) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	expiry := strconv.FormatInt(time.Now().Add(r.config.TTL).UnixMilli(), 10)
+	ttl := strconv.FormatInt(r.config.TTL.Milliseconds(), 10)
+
+	cmds := make([][]string, 0, len(ids)*3)
+	for _, id := range ids {
+		cmds = append(cmds,
+			[]string{"ZADD", r.key("sessions"), "XX", expiry, id.String()},
+			[]string{"PEXPIRE", r.key("session:" + id.String()), ttl},
+			[]string{"PEXPIRE", r.key("streams:" + id.String()), ttl},
+		)
+	}
+	return r.pipeline(cmds)
+}
+
+// Unregister removes a session and its streams.
+func (r *RedisBackend) Unregister(id uuid.UUID) error {
+	return r.pipeline([][]string{
+		{"ZREM", r.key("sessions"), id.String()},
+		{"DEL", r.key("session:" + id.String()), r.key("streams:" + id.String())},
+	})
+}
+
+// Owner returns the node that registered a session.
+func (r *RedisBackend) Owner(id uuid.UUID) (string, bool, error) {
+	reply, err := r.do("GET", r.key("session:"+id.String()))
+	if err != nil {
+		return "", false, err
+	}
+	node, ok := reply.(string)
+	return node, ok, nil
+}
+
+// Count returns the number of live sessions across all instances.
+func (r *RedisBackend) Count() (int, error) {
+	now := strconv.FormatInt(time.Now().UnixMilli(), 10)
+	reply, err := r.do("ZCOUNT", r.key("sessions"), "("+now, "+inf")
+	if err != nil {
+		return 0, err
+	}
+	n, _ := reply.(int64)
+	return int(n), nil
+}
+
+// AddStream records a stream's destination.
+func (r *RedisBackend) AddStream(id uuid.UUID, streamID uint32, dest string) error {
+	key := r.key("streams:" + id.String())
+	return r.pipeline([][]string{
+		{"HSET", key, strconv.FormatUint(uint64(streamID), 10), dest},
+		{"PEXPIRE", key, strconv.FormatInt(r.config.TTL.Milliseconds(), 10)},
+	})
+}
+
+// RemoveStream forgets a stream.
+func (r *RedisBackend) RemoveStream(id uuid.UUID, streamID uint32) error {
+	_, err := r.do("HDEL", r.key("streams:"+id.String()), strconv.FormatUint(uint64(streamID), 10))
+	return err
+}
+
+// Streams returns the recorded streams of a session.
+func (r *RedisBackend) Streams(id uuid.UUID) (map[uint32]string, error) {
+	reply, err := r.do("HGETALL", r.key("streams:"+id.String()))
+	if err != nil {
+		return nil, err
+	}
+	fields, _ := reply.([]interface{})
+	streams := make(map[uint32]string, len(fields)/2)
+	for i := 0; i+1 < len(fields); i += 2 {
+		field, _ := fields[i].(string)
+		dest, _ := fields[i+1].(string)
+		streamID, err := strconv.ParseUint(field, 10, 32)
+		if err != nil {
+			continue
+		}
+		streams[uint32(streamID)] = dest
+	}
+	return streams, nil
+}
+
+// Close closes the Redis connection.
+func (r *RedisBackend) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.conn == nil {
+		return nil
+	}
+	err := r.conn.Close()
+	r.conn = nil
+	return err
+}
+
+func (r *RedisBackend) key(name string) string {
+	return r.config.Prefix + name
+}
+
+// do sends one command and returns its reply.
+func (r *RedisBackend) do(args ...string) (interface{}, error) {
+	var reply interface{}
+	err := r.exchange([][]string{args}, func(i int, v interface{}) { reply = v })
+	return reply, err
+}
+
+// pipeline sends several commands in one round trip, returning the first
+// error reply.
+func (r *RedisBackend) pipeline(cmds [][]string) error {
+	return r.exchange(cmds, func(int, interface{}) {})
+}
+
+// exchange writes cmds and reads one reply per command, passing each to
+// handle. Connection errors drop the connection so the next call redials.
+func (r *RedisBackend) exchange(cmds [][]string, handle func(i int, reply interface{})) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.connect(); err != nil {
+		return err
+	}
+	_ = r.conn.SetDeadline(time.Now().Add(r.config.Timeout))
+
+	for _, args := range cmds {
+		if err := writeCommand(r.rw.Writer, args); err != nil {
+			r.drop()
+			return err
+		}
+	}
+	if err := r.rw.Flush(); err != nil {
+		r.drop()
+		return err
+	}
+
+	var firstErr error
+	for i := range cmds {
+		reply, err := readReply(r.rw.Reader)
+		var replyErr redisError
+		if errors.As(err, &replyErr) {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		if err != nil {
+			r.drop()
+			return err
+		}
+		handle(i, reply)
+	}
+	return firstErr
+}
+
+// connect dials Redis if there is no connection. The caller must hold r.mu.
+func (r *RedisBackend) connect() error {
+	if r.conn != nil {
+		return nil
+	}
+	conn, err := net.DialTimeout("tcp", r.config.Addr, r.config.Timeout)
+	if err != nil {
+		return err
+	}
+	r.conn = conn
+	r.rw = bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn))
+
+	var setup [][]string
+	if r.config.Password != "" {
+		setup = append(setup, []string{"AUTH", r.config.Password})
+	}
+	if r.config.DB != 0 {
+		setup = append(setup, []string{"SELECT", strconv.Itoa(r.config.DB)})
+	}
+	_ = conn.SetDeadline(time.Now().Add(r.config.Timeout))
+	for _, args := range setup {
+		if err := writeCommand(r.rw.Writer, args); err == nil {
+			err = r.rw.Flush()
+		}
+		if err == nil {
+			_, err = readReply(r.rw.Reader)
+		}
+		if err != nil {
+			r.drop()
+			return fmt.Errorf("redis %s failed: %w", args[0], err)
+		}
+	}
+	return nil
+}
+
+// drop closes a broken connection. The caller must hold r.mu.
+func (r *RedisBackend) drop() {
+	if r.conn != nil {
+		_ = r.conn.Close()
+		r.conn = nil
+	}
+}
+
+// writeCommand encodes args as a RESP array of bulk strings.
+func writeCommand(w *bufio.Writer, args []string) error {
+	if _, err := fmt.Fprintf(w, "*%d\r\n", len(args)); err != nil {
+		return err
+	}
+	for _, arg := range args {
+		if _, err := fmt.Fprintf(w, "$%d\r\n%s\r\n", len(arg), arg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readReply decodes one RESP reply. Simple and bulk strings are returned as
+// string, integers as int64, arrays as []interface{} and nil replies as nil.
+// Error replies are returned as redisError.
+func readReply(r *bufio.Reader) (interface{}, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("redis: malformed reply %q", line)
+	}
+	kind, body := line[0], line[1:len(line)-2]
+
+	switch kind {
+	case '+':
+		return body, nil
+	case '-':
+		return nil, redisError(body)
+	case ':':
+		return strconv.ParseInt(body, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(body)
+		if err != nil {
+			return nil, fmt.Errorf("redis: invalid bulk length %q", body)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(body)
+		if err != nil {
+			return nil, fmt.Errorf("redis: invalid array length %q", body)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			item, err := readReply(r)
+			var replyErr redisError
+			if err != nil && !errors.As(err, &replyErr) {
+				return nil, err
+			}
+			items[i] = item
+		}
+		return items, nil
+	default:
+		return nil, fmt.Errorf("redis: unknown reply type %q", kind)
+	}
+}
@@ -0,0 +1,115 @@
+package session
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestWriteCommand(t *testing.T) {
+	var buf bytes.Buffer
+	w := bufio.NewWriter(&buf)
+	if err := writeCommand(w, []string{"SET", "key", "a b"}); err != nil {
+		t.Fatalf("writeCommand failed: %v", err)
+	}
+	_ = w.Flush()
+
+	want := "*3\r\n$3\r\nSET\r\n$3\r\nkey\r\n$3\r\na b\r\n"
+	if buf.String() != want {
+		t.Errorf("Expected %q, got %q", want, buf.String())
+	}
+}
+
+func TestReadReply(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  interface{}
+		err   bool
+	}{
+		{"simple string", "+OK\r\n", "OK", false},
+		{"integer", ":42\r\n", int64(42), false},
+		{"bulk string", "$5\r\nhello\r\n", "hello", false},
+		{"nil bulk", "$-1\r\n", nil, false},
+		{"array", "*2\r\n$1\r\n1\r\n$11\r\nexample:443\r\n", []interface{}{"1", "example:443"}, false},
+		{"error", "-ERR wrong type\r\n", nil, true},
+		{"malformed", "?\n", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := readReply(bufio.NewReader(strings.NewReader(tt.input)))
+			if (err != nil) != tt.err {
+				t.Fatalf("readReply() error = %v, wantErr %v", err, tt.err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("readReply() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+// fakeRedis answers each command with the next canned reply.
+func fakeRedis(t *testing.T, replies ...string) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		for _, reply := range replies {
+			if _, err := readReply(r); err != nil {
+				return
+			}
+			if _, err := conn.Write([]byte(reply)); err != nil {
+				return
+			}
+		}
+	}()
+	return l.Addr().String()
+}
+
+func TestRedisBackendRegister(t *testing.T) {
+	addr := fakeRedis(t, "+PONG\r\n", ":1\r\n", ":0\r\n", "-NOSCRIPT no scripting\r\n")
+	b, err := NewRedisBackend(RedisConfig{Addr: addr, Timeout: time.Second})
+	if err != nil {
+		t.Fatalf("NewRedisBackend failed: %v", err)
+	}
+	defer b.Close()
+
+	if err := b.Register(uuid.New(), "node-a"); err != nil {
+		t.Errorf("Expected registration to succeed, got %v", err)
+	}
+	if err := b.Register(uuid.New(), "node-a"); !errors.Is(err, ErrSessionLimit) {
+		t.Errorf("Expected ErrSessionLimit, got %v", err)
+	}
+	var replyErr redisError
+	if err := b.Register(uuid.New(), "node-a"); !errors.As(err, &replyErr) {
+		t.Errorf("Expected a redis error reply, got %v", err)
+	}
+}
+
+func TestRedisBackendUnreachable(t *testing.T) {
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	addr := l.Addr().String()
+	l.Close()
+
+	if _, err := NewRedisBackend(RedisConfig{Addr: addr, Timeout: time.Second}); err == nil {
+		t.Error("Expected error for unreachable redis")
+	}
+}
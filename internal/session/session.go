@@ -49,6 +49,8 @@ type Stream struct {
 	AckNum       uint32 // Next expected sequence number
 	BytesSent    int64  // Total bytes sent on this stream
 	BytesRecv    int64  // Total bytes received on this stream
+	PacketsSent  int64  // Data packets sent on this stream
+	PacketsRecv  int64  // Data packets received on this stream
 	LastActivity time.Time
 	Checksum     uint32 // Rolling checksum for data integrity verification
 	CreatedAt    time.Time
@@ -144,6 +146,53 @@ func (s *Stream) RecordReceive(bytes int64, data []byte) {
 	s.Checksum = updateChecksum(s.Checksum, data)
 }
 
+// StreamStats is a snapshot of a stream's traffic counters.
+type StreamStats struct {
+	ID           uint32
+	BytesSent    int64
+	BytesRecv    int64
+	PacketsSent  int64
+	PacketsRecv  int64
+	CreatedAt    time.Time
+	LastActivity time.Time
+}
+
+// CountSent counts a data packet of bytes sent on this stream. Unlike
+// RecordSend it leaves the checksum alone, so it is cheap enough for every
+// packet.
+func (s *Stream) CountSent(bytes int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.BytesSent += int64(bytes)
+	s.PacketsSent++
+	s.LastActivity = time.Now()
+}
+
+// CountReceived counts a data packet of bytes received on this stream,
+// leaving the checksum alone like CountSent.
+func (s *Stream) CountReceived(bytes int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.BytesRecv += int64(bytes)
+	s.PacketsRecv++
+	s.LastActivity = time.Now()
+}
+
+// Stats returns a snapshot of the stream's traffic counters.
+func (s *Stream) Stats() StreamStats {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return StreamStats{
+		ID:           s.ID,
+		BytesSent:    s.BytesSent,
+		BytesRecv:    s.BytesRecv,
+		PacketsSent:  s.PacketsSent,
+		PacketsRecv:  s.PacketsRecv,
+		CreatedAt:    s.CreatedAt,
+		LastActivity: s.LastActivity,
+	}
+}
+
 // GetStreamState returns a snapshot of the stream state for persistence.
 func (s *Stream) GetStreamState() StreamState {
 	s.mu.RLock()
@@ -186,7 +235,14 @@ type Session struct {
 	streams   map[uint32]*Stream
 	CreatedAt time.Time
 	UpdatedAt time.Time
-	mu        sync.RWMutex
+	identity  string
+	// Negotiated packet version and capability bitmap; version 0 until the
+	// peers exchanged hellos
+	version      byte
+	capabilities uint32
+	// Largest packet payload negotiated for the session; 0 until negotiated
+	maxPayload int
+	mu         sync.RWMutex
 }
 
 // New creates a new session with a random UUID.
@@ -241,6 +297,16 @@ func (s *Session) RemoveStream(streamID uint32) {
 	s.UpdatedAt = time.Now()
 }
 
+// GetStreamStats returns the traffic counters of a stream, or false if the
+// session has no such stream.
+func (s *Session) GetStreamStats(streamID uint32) (StreamStats, bool) {
+	stream, exists := s.GetExistingStream(streamID)
+	if !exists {
+		return StreamStats{}, false
+	}
+	return stream.Stats(), true
+}
+
 // StreamCount returns the number of active streams.
 func (s *Session) StreamCount() int {
 	s.mu.RLock()
@@ -262,6 +328,66 @@ func (s *Session) Touch() {
 	s.UpdatedAt = time.Now()
 }
 
+// LastActivity returns when the session was last used.
+func (s *Session) LastActivity() time.Time {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.UpdatedAt
+}
+
+// SetIdentity records the identity of the client that owns the session. It
+// reports whether the identity changed.
+func (s *Session) SetIdentity(identity string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.identity == identity {
+		return false
+	}
+	s.identity = identity
+	return true
+}
+
+// Identity returns the identity of the client that owns the session, or ""
+// if the client has not been identified.
+func (s *Session) Identity() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.identity
+}
+
+// SetProtocol records the packet version and capabilities negotiated for
+// the session.
+func (s *Session) SetProtocol(version byte, capabilities uint32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.version = version
+	s.capabilities = capabilities
+}
+
+// Protocol returns the packet version and capabilities negotiated for the
+// session; the version is 0 if none were negotiated.
+func (s *Session) Protocol() (version byte, capabilities uint32) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.version, s.capabilities
+}
+
+// SetMaxPayload records the largest packet payload negotiated for the
+// session.
+func (s *Session) SetMaxPayload(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.maxPayload = n
+}
+
+// MaxPayload returns the largest packet payload negotiated for the session,
+// or 0 if none was negotiated.
+func (s *Session) MaxPayload() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.maxPayload
+}
+
 // ResumeStream restores a stream from a saved state, allowing stream resumption after reconnection.
 // If the stream already exists and has progressed beyond the saved state, the resumption is skipped.
 func (s *Session) ResumeStream(state StreamState) error {
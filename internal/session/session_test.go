@@ -157,6 +157,26 @@ func TestSessionTouch(t *testing.T) {
 	}
 }
 
+func TestSessionProtocol(t *testing.T) {
+	s := New()
+	if version, caps := s.Protocol(); version != 0 || caps != 0 {
+		t.Errorf("Expected no negotiated protocol, got version %d, capabilities %d", version, caps)
+	}
+
+	s.SetProtocol(1, 0x18)
+	if version, caps := s.Protocol(); version != 1 || caps != 0x18 {
+		t.Errorf("Expected version 1, capabilities 0x18, got %d, %#x", version, caps)
+	}
+
+	if n := s.MaxPayload(); n != 0 {
+		t.Errorf("Expected no negotiated payload size, got %d", n)
+	}
+	s.SetMaxPayload(1 << 20)
+	if n := s.MaxPayload(); n != 1<<20 {
+		t.Errorf("Expected payload size %d, got %d", 1<<20, n)
+	}
+}
+
 func TestStateString(t *testing.T) {
 	tests := []struct {
 		state    State
@@ -209,6 +229,35 @@ func TestStreamRecordSendReceive(t *testing.T) {
 	}
 }
 
+func TestStreamStats(t *testing.T) {
+	sess := New()
+	stream := sess.GetStream(1)
+	created := stream.Stats().LastActivity
+
+	time.Sleep(time.Millisecond)
+	stream.CountSent(100)
+	stream.CountSent(50)
+	stream.CountReceived(10)
+
+	stats, ok := sess.GetStreamStats(1)
+	if !ok {
+		t.Fatal("Expected stats for an existing stream")
+	}
+	if stats.ID != 1 || stats.BytesSent != 150 || stats.PacketsSent != 2 || stats.BytesRecv != 10 || stats.PacketsRecv != 1 {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+	if !stats.LastActivity.After(created) {
+		t.Error("Expected counting to update the last activity")
+	}
+	if stream.GetChecksum() != 0 {
+		t.Error("Expected counting to leave the checksum alone")
+	}
+
+	if _, ok := sess.GetStreamStats(2); ok {
+		t.Error("Expected no stats for an unknown stream")
+	}
+}
+
 func TestStreamGetChecksum(t *testing.T) {
 	stream := NewStream(1)
 
@@ -3,12 +3,37 @@ package session
 
 import (
 	"context"
+	"errors"
 	"sync"
 	"time"
 
 	"github.com/google/uuid"
 )
 
+// EvictionPolicy decides what happens when a new session arrives while the
+// store is at its session limit.
+type EvictionPolicy string
+
+const (
+	// EvictNone rejects new sessions.
+	EvictNone EvictionPolicy = "reject"
+	// EvictLRU evicts the least recently active session.
+	EvictLRU EvictionPolicy = "lru"
+	// EvictIdle evicts the least recently active session if it has been idle
+	// for at least Limit.IdleAfter, and rejects the new session otherwise.
+	EvictIdle EvictionPolicy = "idle"
+)
+
+// Limit caps the number of sessions admitted to a store.
+type Limit struct {
+	// MaxSessions is the session limit (0 means no limit)
+	MaxSessions int
+	// Policy selects how room is made for new sessions at the limit
+	Policy EvictionPolicy
+	// IdleAfter is the minimum idle time before EvictIdle evicts a session
+	IdleAfter time.Duration
+}
+
 // Store provides thread-safe storage for sessions with TTL eviction.
 type Store struct {
 	sessions   map[uuid.UUID]*Session
@@ -16,6 +41,15 @@ type Store struct {
 	ttl        time.Duration
 	cleanupCtx context.Context
 	cancelFunc context.CancelFunc
+	onCreate   func(id uuid.UUID)
+	onRemove   func(id uuid.UUID)
+	onEvict    func(id uuid.UUID)
+	onExpire   func(id uuid.UUID)
+	limit      Limit
+
+	// Shared state across server instances (nil keeps sessions local)
+	backend Backend
+	node    string
 }
 
 // NewStore creates a new session store with the given TTL for session eviction.
@@ -31,6 +65,133 @@ func NewStore(ttl time.Duration) *Store {
 	return s
 }
 
+// SetCallbacks registers functions called when a session is added to or
+// removed from the store, including TTL eviction. Either may be nil. They run
+// with the store locked and must not call back into the store.
+func (s *Store) SetCallbacks(onCreate, onRemove func(id uuid.UUID)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onCreate = onCreate
+	s.onRemove = onRemove
+}
+
+// SetExpiryHandler registers a function called without the store locked for
+// each session removed by TTL eviction, after the remove callback.
+func (s *Store) SetExpiryHandler(onExpire func(id uuid.UUID)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onExpire = onExpire
+}
+
+// SetLimit sets the session limit enforced by Admit. onEvict, if not nil, is
+// called without the store locked for each session evicted to make room.
+func (s *Store) SetLimit(limit Limit, onEvict func(id uuid.UUID)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.limit = limit
+	s.onEvict = onEvict
+}
+
+// SetBackend mirrors sessions to a shared backend, registering them as owned
+// by node. It must be called before the store is used.
+func (s *Store) SetBackend(backend Backend, node string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.backend = backend
+	s.node = node
+}
+
+// Backend returns the shared backend, or nil if sessions are local only.
+func (s *Store) Backend() Backend {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.backend
+}
+
+// Admit returns the session with the given ID, creating it if needed. New
+// sessions are subject to the store's limit and are registered with the
+// backend; ErrSessionLimit is returned if either refuses them. Other backend
+// errors are returned along with the session, which is then tracked locally
+// only. Unlike GetOrCreate, Admit is meant for sessions arriving from clients.
+func (s *Store) Admit(id uuid.UUID) (*Session, error) {
+	s.mu.RLock()
+	session, exists := s.sessions[id]
+	backend, node := s.backend, s.node
+	s.mu.RUnlock()
+	if exists {
+		session.Touch()
+		return session, nil
+	}
+
+	var backendErr error
+	if backend != nil {
+		// Registration is idempotent, so racing admissions of one ID are safe
+		backendErr = backend.Register(id, node)
+		if errors.Is(backendErr, ErrSessionLimit) {
+			return nil, backendErr
+		}
+	}
+
+	s.mu.Lock()
+	if session, exists := s.sessions[id]; exists {
+		s.mu.Unlock()
+		session.Touch()
+		return session, backendErr
+	}
+	victim, err := s.makeRoom()
+	if err != nil {
+		s.mu.Unlock()
+		if backend != nil && backendErr == nil {
+			_ = backend.Unregister(id)
+		}
+		return nil, err
+	}
+	session = NewWithID(id)
+	s.sessions[id] = session
+	s.created(id)
+	onEvict := s.onEvict
+	s.mu.Unlock()
+
+	if victim != uuid.Nil {
+		if backend != nil {
+			_ = backend.Unregister(victim)
+		}
+		if onEvict != nil {
+			onEvict(victim)
+		}
+	}
+	return session, backendErr
+}
+
+// makeRoom applies the eviction policy when the store is full. It returns the
+// evicted session ID, if any, or ErrSessionLimit if no room can be made. The
+// caller must hold s.mu.
+func (s *Store) makeRoom() (uuid.UUID, error) {
+	if s.limit.MaxSessions <= 0 || len(s.sessions) < s.limit.MaxSessions {
+		return uuid.Nil, nil
+	}
+	if s.limit.Policy != EvictLRU && s.limit.Policy != EvictIdle {
+		return uuid.Nil, ErrSessionLimit
+	}
+
+	var victim *Session
+	for _, session := range s.sessions {
+		if victim == nil || session.LastActivity().Before(victim.LastActivity()) {
+			victim = session
+		}
+	}
+	if victim == nil {
+		return uuid.Nil, ErrSessionLimit
+	}
+	if s.limit.Policy == EvictIdle && time.Since(victim.LastActivity()) < s.limit.IdleAfter {
+		return uuid.Nil, ErrSessionLimit
+	}
+
+	delete(s.sessions, victim.ID)
+	s.removed(victim.ID)
+	return victim.ID, nil
+}
+
 // Get retrieves a session by ID.
 func (s *Store) Get(id uuid.UUID) (*Session, bool) {
 	s.mu.RLock()
@@ -51,6 +212,7 @@ func (s *Store) GetOrCreate(id uuid.UUID) *Session {
 
 	session := NewWithID(id)
 	s.sessions[id] = session
+	s.created(id)
 	return session
 }
 
@@ -61,14 +223,24 @@ func (s *Store) Create() *Session {
 
 	session := New()
 	s.sessions[session.ID] = session
+	s.created(session.ID)
 	return session
 }
 
 // Remove removes a session by ID.
 func (s *Store) Remove(id uuid.UUID) {
 	s.mu.Lock()
-	defer s.mu.Unlock()
-	delete(s.sessions, id)
+	_, exists := s.sessions[id]
+	if exists {
+		delete(s.sessions, id)
+		s.removed(id)
+	}
+	backend := s.backend
+	s.mu.Unlock()
+
+	if exists && backend != nil {
+		_ = backend.Unregister(id)
+	}
 }
 
 // Count returns the number of active sessions.
@@ -78,6 +250,17 @@ func (s *Store) Count() int {
 	return len(s.sessions)
 }
 
+// List returns all sessions currently in the store.
+func (s *Store) List() []*Session {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	sessions := make([]*Session, 0, len(s.sessions))
+	for _, session := range s.sessions {
+		sessions = append(sessions, session)
+	}
+	return sessions
+}
+
 // Close stops the cleanup goroutine.
 func (s *Store) Close() {
 	s.cancelFunc()
@@ -94,6 +277,7 @@ func (s *Store) cleanupLoop() {
 			return
 		case <-ticker.C:
 			s.cleanup()
+			s.refreshBackend()
 		}
 	}
 }
@@ -101,11 +285,53 @@ func (s *Store) cleanupLoop() {
 // cleanup removes all expired sessions.
 func (s *Store) cleanup() {
 	s.mu.Lock()
-	defer s.mu.Unlock()
-
+	var expired []uuid.UUID
 	for id, session := range s.sessions {
 		if session.IsExpired(s.ttl) {
 			delete(s.sessions, id)
+			s.removed(id)
+			expired = append(expired, id)
 		}
 	}
+	backend, onExpire := s.backend, s.onExpire
+	s.mu.Unlock()
+
+	for _, id := range expired {
+		if backend != nil {
+			_ = backend.Unregister(id)
+		}
+		if onExpire != nil {
+			onExpire(id)
+		}
+	}
+}
+
+// refreshBackend extends the backend lifetime of the local sessions, so
+// sessions of an instance that stops expire from the backend on their own.
+func (s *Store) refreshBackend() {
+	s.mu.RLock()
+	backend := s.backend
+	ids := make([]uuid.UUID, 0, len(s.sessions))
+	for id := range s.sessions {
+		ids = append(ids, id)
+	}
+	s.mu.RUnlock()
+
+	if backend != nil {
+		_ = backend.Refresh(ids)
+	}
+}
+
+// created runs the create callback. The caller must hold s.mu.
+func (s *Store) created(id uuid.UUID) {
+	if s.onCreate != nil {
+		s.onCreate(id)
+	}
+}
+
+// removed runs the remove callback. The caller must hold s.mu.
+func (s *Store) removed(id uuid.UUID) {
+	if s.onRemove != nil {
+		s.onRemove(id)
+	}
 }
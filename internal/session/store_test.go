@@ -1,6 +1,7 @@
 package session
 
 import (
+	"errors"
 	"testing"
 	"time"
 
@@ -106,6 +107,26 @@ func TestStoreRemove(t *testing.T) {
 	}
 }
 
+func TestStoreList(t *testing.T) {
+	store := NewStore(time.Minute)
+	defer store.Close()
+
+	a := store.Create()
+	b := store.Create()
+
+	sessions := store.List()
+	if len(sessions) != 2 {
+		t.Fatalf("Expected 2 sessions, got %d", len(sessions))
+	}
+	seen := map[uuid.UUID]bool{}
+	for _, s := range sessions {
+		seen[s.ID] = true
+	}
+	if !seen[a.ID] || !seen[b.ID] {
+		t.Error("Expected both sessions to be listed")
+	}
+}
+
 func TestStoreCleanup(t *testing.T) {
 	// Use very short TTL for testing
 	ttl := 50 * time.Millisecond
@@ -126,6 +147,39 @@ func TestStoreCleanup(t *testing.T) {
 	}
 }
 
+func TestStoreCallbacks(t *testing.T) {
+	store := NewStore(time.Minute)
+	defer store.Close()
+
+	var created, removed int
+	store.SetCallbacks(
+		func(uuid.UUID) { created++ },
+		func(uuid.UUID) { removed++ },
+	)
+
+	id := uuid.New()
+	store.GetOrCreate(id)
+	store.GetOrCreate(id)
+	store.Create()
+	store.Remove(id)
+	store.Remove(id)
+
+	if created != 2 {
+		t.Errorf("Expected 2 create callbacks, got %d", created)
+	}
+	if removed != 1 {
+		t.Errorf("Expected 1 remove callback, got %d", removed)
+	}
+
+	for _, s := range store.sessions {
+		s.UpdatedAt = time.Now().Add(-time.Hour)
+	}
+	store.cleanup()
+	if removed != 2 {
+		t.Errorf("Expected expired session to trigger remove callback, got %d", removed)
+	}
+}
+
 func TestStoreConcurrency(t *testing.T) {
 	store := NewStore(time.Minute)
 	defer store.Close()
@@ -149,3 +203,120 @@ func TestStoreConcurrency(t *testing.T) {
 		t.Errorf("Store should have 100 sessions, got %d", store.Count())
 	}
 }
+
+func TestStoreAdmitWithBackend(t *testing.T) {
+	store := NewStore(time.Minute)
+	defer store.Close()
+	backend := NewMemoryBackend(1, time.Minute)
+	store.SetBackend(backend, "node-a")
+
+	id := uuid.New()
+	if _, err := store.Admit(id); err != nil {
+		t.Fatalf("Admit failed: %v", err)
+	}
+	if node, ok, _ := backend.Owner(id); !ok || node != "node-a" {
+		t.Errorf("Expected session registered to node-a, got %q (%v)", node, ok)
+	}
+
+	// Existing sessions are admitted at the limit, new ones are refused
+	if _, err := store.Admit(id); err != nil {
+		t.Errorf("Expected existing session to be admitted, got %v", err)
+	}
+	if _, err := store.Admit(uuid.New()); !errors.Is(err, ErrSessionLimit) {
+		t.Errorf("Expected ErrSessionLimit, got %v", err)
+	}
+	if store.Count() != 1 {
+		t.Errorf("Expected 1 local session, got %d", store.Count())
+	}
+
+	store.Remove(id)
+	if n, _ := backend.Count(); n != 0 {
+		t.Errorf("Expected removal to unregister the session, got %d", n)
+	}
+}
+
+func TestStoreLimitPolicies(t *testing.T) {
+	tests := []struct {
+		name      string
+		limit     Limit
+		idle      bool
+		wantErr   bool
+		wantEvict bool
+	}{
+		{"reject", Limit{MaxSessions: 2, Policy: EvictNone}, true, true, false},
+		{"lru", Limit{MaxSessions: 2, Policy: EvictLRU}, false, false, true},
+		{"idle with active sessions", Limit{MaxSessions: 2, Policy: EvictIdle, IdleAfter: time.Minute}, false, true, false},
+		{"idle with idle session", Limit{MaxSessions: 2, Policy: EvictIdle, IdleAfter: time.Minute}, true, false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewStore(time.Hour)
+			defer store.Close()
+
+			var evicted []uuid.UUID
+			store.SetLimit(tt.limit, func(id uuid.UUID) { evicted = append(evicted, id) })
+
+			oldest, _ := store.Admit(uuid.New())
+			newer, _ := store.Admit(uuid.New())
+			if tt.idle {
+				oldest.mu.Lock()
+				oldest.UpdatedAt = time.Now().Add(-2 * time.Minute)
+				oldest.mu.Unlock()
+			} else {
+				oldest.mu.Lock()
+				oldest.UpdatedAt = time.Now().Add(-time.Second)
+				oldest.mu.Unlock()
+			}
+
+			_, err := store.Admit(uuid.New())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Admit() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr && !errors.Is(err, ErrSessionLimit) {
+				t.Errorf("Expected ErrSessionLimit, got %v", err)
+			}
+			if store.Count() != 2 {
+				t.Errorf("Expected 2 sessions, got %d", store.Count())
+			}
+
+			if tt.wantEvict {
+				if len(evicted) != 1 || evicted[0] != oldest.ID {
+					t.Errorf("Expected the oldest session to be evicted, got %v", evicted)
+				}
+				if _, exists := store.Get(newer.ID); !exists {
+					t.Error("Expected the newer session to be kept")
+				}
+			} else if len(evicted) != 0 {
+				t.Errorf("Expected no evictions, got %v", evicted)
+			}
+
+			// Existing sessions are always admitted
+			if _, err := store.Admit(newer.ID); err != nil {
+				t.Errorf("Expected existing session to be admitted, got %v", err)
+			}
+		})
+	}
+}
+
+func TestStoreExpiryHandler(t *testing.T) {
+	store := NewStore(20 * time.Millisecond)
+	defer store.Close()
+
+	expired := make(chan uuid.UUID, 1)
+	store.SetExpiryHandler(func(id uuid.UUID) {
+		// The store is unlocked, so the handler may use it
+		store.Remove(id)
+		expired <- id
+	})
+
+	s := store.Create()
+	select {
+	case id := <-expired:
+		if id != s.ID {
+			t.Errorf("Expected session %s to expire, got %s", s.ID, id)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the expiry handler to be called")
+	}
+}
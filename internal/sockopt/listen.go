@@ -0,0 +1,64 @@
+package sockopt
+
+import (
+	"fmt"
+	"net"
+)
+
+// Family selects the IP versions a listener accepts.
+type Family string
+
+const (
+	// FamilyDual accepts IPv4 and IPv6 on wildcard addresses
+	FamilyDual Family = "dual"
+	// FamilyIPv4 accepts IPv4 only
+	FamilyIPv4 Family = "ipv4"
+	// FamilyIPv6 accepts IPv6 only (IPV6_V6ONLY on wildcard addresses)
+	FamilyIPv6 Family = "ipv6"
+)
+
+// ParseFamily parses a family name. An empty name is FamilyDual.
+func ParseFamily(name string) (Family, error) {
+	switch Family(name) {
+	case "", FamilyDual:
+		return FamilyDual, nil
+	case FamilyIPv4, FamilyIPv6:
+		return Family(name), nil
+	default:
+		return "", fmt.Errorf("invalid IP family: %s (use dual, ipv4 or ipv6)", name)
+	}
+}
+
+// Listen opens a TCP listener on address restricted to family. A wildcard
+// host ("", "0.0.0.0" or "::") listens on every address of the family, so
+// "0.0.0.0" with FamilyIPv6 means all IPv6 addresses.
+func Listen(family Family, address string) (net.Listener, error) {
+	network, address, err := listenNetwork("tcp", family, address)
+	if err != nil {
+		return nil, err
+	}
+	return net.Listen(network, address)
+}
+
+// listenNetwork returns the network and address to listen on for family.
+func listenNetwork(base string, family Family, address string) (string, string, error) {
+	family, err := ParseFamily(string(family))
+	if err != nil {
+		return "", "", err
+	}
+	if family == FamilyDual {
+		return base, address, nil
+	}
+
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return "", "", err
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsUnspecified() {
+		host = ""
+	}
+	if family == FamilyIPv4 {
+		return base + "4", net.JoinHostPort(host, port), nil
+	}
+	return base + "6", net.JoinHostPort(host, port), nil
+}
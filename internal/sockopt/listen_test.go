@@ -0,0 +1,54 @@
+package sockopt
+
+import (
+	"net"
+	"testing"
+)
+
+func TestListenNetwork(t *testing.T) {
+	tests := []struct {
+		family      Family
+		address     string
+		wantNetwork string
+		wantAddress string
+		wantErr     bool
+	}{
+		{family: "", address: "0.0.0.0:80", wantNetwork: "tcp", wantAddress: "0.0.0.0:80"},
+		{family: FamilyDual, address: "[::]:80", wantNetwork: "tcp", wantAddress: "[::]:80"},
+		{family: FamilyIPv4, address: "[::]:80", wantNetwork: "tcp4", wantAddress: ":80"},
+		{family: FamilyIPv4, address: "127.0.0.1:80", wantNetwork: "tcp4", wantAddress: "127.0.0.1:80"},
+		{family: FamilyIPv6, address: "0.0.0.0:80", wantNetwork: "tcp6", wantAddress: ":80"},
+		{family: FamilyIPv6, address: "[2001:db8::1]:80", wantNetwork: "tcp6", wantAddress: "[2001:db8::1]:80"},
+		{family: "ipv5", address: ":80", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		network, address, err := listenNetwork("tcp", tt.family, tt.address)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s %s: expected an error", tt.family, tt.address)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s %s: unexpected error: %v", tt.family, tt.address, err)
+			continue
+		}
+		if network != tt.wantNetwork || address != tt.wantAddress {
+			t.Errorf("%s %s: expected %s %s, got %s %s", tt.family, tt.address, tt.wantNetwork, tt.wantAddress, network, address)
+		}
+	}
+}
+
+func TestListenIPv4Only(t *testing.T) {
+	ln, err := Listen(FamilyIPv4, "0.0.0.0:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	defer ln.Close()
+
+	addr := ln.Addr().(*net.TCPAddr)
+	if addr.IP.To4() == nil {
+		t.Errorf("Expected an IPv4 listener, got %s", addr)
+	}
+}
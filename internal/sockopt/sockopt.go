@@ -0,0 +1,120 @@
+// Package sockopt applies socket options to raw TCP connections and
+// listeners: the client's tunnel, SOCKS5 and port-forward sockets and the
+// server's listener and destination sockets.
+package sockopt
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+)
+
+// errNotSupported is returned for options this platform cannot set.
+var errNotSupported = errors.New("not supported on this platform")
+
+// TCPConfig holds TCP socket options.
+type TCPConfig struct {
+	// NoDelay disables Nagle's algorithm (TCP_NODELAY)
+	NoDelay bool
+	// KeepAlive enables TCP keepalive probes
+	KeepAlive bool
+	// KeepAliveIdle is how long a connection is idle before the first probe
+	KeepAliveIdle time.Duration
+	// KeepAliveInterval is the time between probes
+	KeepAliveInterval time.Duration
+	// KeepAliveCount is the number of unanswered probes before the
+	// connection is dropped
+	KeepAliveCount int
+	// UserTimeout is how long sent data may stay unacknowledged before the
+	// connection is dropped (TCP_USER_TIMEOUT; ignored on platforms other
+	// than Linux, 0 keeps the OS default)
+	UserTimeout time.Duration
+}
+
+// DefaultTCPConfig returns default TCP socket options.
+func DefaultTCPConfig() *TCPConfig {
+	return &TCPConfig{
+		NoDelay:           true,
+		KeepAlive:         true,
+		KeepAliveIdle:     30 * time.Second,
+		KeepAliveInterval: 10 * time.Second,
+		KeepAliveCount:    3,
+	}
+}
+
+// Apply sets the options on conn. Connections that are not TCP are left
+// alone. A nil config leaves the OS defaults.
+func (c *TCPConfig) Apply(conn net.Conn) error {
+	tcpConn, ok := conn.(*net.TCPConn)
+	if c == nil || !ok {
+		return nil
+	}
+	if err := tcpConn.SetNoDelay(c.NoDelay); err != nil {
+		return err
+	}
+	if err := tcpConn.SetKeepAliveConfig(net.KeepAliveConfig{
+		Enable:   c.KeepAlive,
+		Idle:     c.KeepAliveIdle,
+		Interval: c.KeepAliveInterval,
+		Count:    c.KeepAliveCount,
+	}); err != nil {
+		return err
+	}
+	if c.UserTimeout > 0 {
+		if err := setUserTimeout(tcpConn, c.UserTimeout); err != nil && !errors.Is(err, errNotSupported) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Dialer returns a dial function that applies the options to each
+// connection it makes.
+func (c *TCPConfig) Dialer(dialer *net.Dialer) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dialer.DialContext(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		if err := c.Apply(conn); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return conn, nil
+	}
+}
+
+// Listener wraps ln so the options are applied to every accepted connection.
+// A nil config returns ln unchanged.
+func Listener(ln net.Listener, c *TCPConfig) net.Listener {
+	if c == nil {
+		return ln
+	}
+	return &listener{Listener: ln, config: c}
+}
+
+type listener struct {
+	net.Listener
+	config *TCPConfig
+}
+
+// Accept applies the options to the next connection. A connection whose
+// options cannot be set is still returned; the socket keeps working with
+// the OS defaults.
+func (l *listener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	_ = l.config.Apply(conn)
+	return conn, nil
+}
+
+// SetDeadline sets the deadline of the wrapped listener, if it has one.
+func (l *listener) SetDeadline(t time.Time) error {
+	if dl, ok := l.Listener.(interface{ SetDeadline(time.Time) error }); ok {
+		return dl.SetDeadline(t)
+	}
+	return nil
+}
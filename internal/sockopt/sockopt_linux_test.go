@@ -0,0 +1,50 @@
+package sockopt
+
+import (
+	"net"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func getsockopt(t *testing.T, conn net.Conn, level, opt int) int {
+	t.Helper()
+	raw, err := conn.(*net.TCPConn).SyscallConn()
+	if err != nil {
+		t.Fatalf("SyscallConn failed: %v", err)
+	}
+	var value int
+	var sockErr error
+	if err := raw.Control(func(fd uintptr) {
+		value, sockErr = syscall.GetsockoptInt(int(fd), level, opt)
+	}); err != nil {
+		t.Fatalf("Control failed: %v", err)
+	}
+	if sockErr != nil {
+		t.Fatalf("getsockopt failed: %v", sockErr)
+	}
+	return value
+}
+
+func TestListenerAppliesOptions(t *testing.T) {
+	config := DefaultTCPConfig()
+	config.KeepAliveCount = 5
+	config.UserTimeout = 20 * time.Second
+	_, server := tcpPair(t, config)
+
+	if v := getsockopt(t, server, syscall.IPPROTO_TCP, syscall.TCP_NODELAY); v == 0 {
+		t.Error("Expected TCP_NODELAY to be set")
+	}
+	if v := getsockopt(t, server, syscall.SOL_SOCKET, syscall.SO_KEEPALIVE); v == 0 {
+		t.Error("Expected SO_KEEPALIVE to be set")
+	}
+	if v := getsockopt(t, server, syscall.IPPROTO_TCP, syscall.TCP_KEEPIDLE); v != 30 {
+		t.Errorf("Expected TCP_KEEPIDLE 30, got %d", v)
+	}
+	if v := getsockopt(t, server, syscall.IPPROTO_TCP, syscall.TCP_KEEPCNT); v != 5 {
+		t.Errorf("Expected TCP_KEEPCNT 5, got %d", v)
+	}
+	if v := getsockopt(t, server, syscall.IPPROTO_TCP, tcpUserTimeout); v != 20000 {
+		t.Errorf("Expected TCP_USER_TIMEOUT 20000, got %d", v)
+	}
+}
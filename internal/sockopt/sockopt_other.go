@@ -0,0 +1,12 @@
+//go:build !linux
+
+package sockopt
+
+import (
+	"net"
+	"time"
+)
+
+func setUserTimeout(_ *net.TCPConn, _ time.Duration) error {
+	return errNotSupported
+}
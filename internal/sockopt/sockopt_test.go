@@ -0,0 +1,70 @@
+package sockopt
+
+import (
+	"net"
+	"testing"
+)
+
+// tcpPair returns both ends of a loopback TCP connection, the server end
+// accepted through a listener wrapped with config.
+func tcpPair(t *testing.T, config *TCPConfig) (client, server net.Conn) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	defer ln.Close()
+	ln = Listener(ln, config)
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			close(accepted)
+			return
+		}
+		accepted <- conn
+	}()
+
+	client, err = net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	server, ok := <-accepted
+	if !ok {
+		t.Fatal("Accept failed")
+	}
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	return client, server
+}
+
+func TestApply(t *testing.T) {
+	config := DefaultTCPConfig()
+	client, _ := tcpPair(t, config)
+	if err := config.Apply(client); err != nil {
+		t.Errorf("Apply failed: %v", err)
+	}
+
+	config.KeepAlive = false
+	config.NoDelay = false
+	if err := config.Apply(client); err != nil {
+		t.Errorf("Apply without keepalive failed: %v", err)
+	}
+}
+
+func TestApplyIgnoresOtherConns(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+	if err := DefaultTCPConfig().Apply(a); err != nil {
+		t.Errorf("Expected no error for a non-TCP conn, got %v", err)
+	}
+
+	var config *TCPConfig
+	if err := config.Apply(a); err != nil {
+		t.Errorf("Expected no error for a nil config, got %v", err)
+	}
+}
@@ -6,9 +6,9 @@ import (
 	"crypto/subtle"
 	"encoding/binary"
 	"errors"
-	"fmt"
 	"io"
 	"net"
+	"strconv"
 	"sync"
 )
 
@@ -17,6 +17,7 @@ var (
 	ErrUnsupportedVersion     = errors.New("unsupported SOCKS version")
 	ErrUnsupportedCommand     = errors.New("unsupported command")
 	ErrUnsupportedAddressType = errors.New("unsupported address type")
+	ErrEmptyDomain            = errors.New("empty domain name")
 	ErrAuthFailed             = errors.New("authentication failed")
 	ErrConnectionRefused      = errors.New("connection refused")
 )
@@ -43,7 +44,11 @@ const (
 	// Reply codes
 	ReplySuccess                 = 0x00
 	ReplyGeneralFailure          = 0x01
+	ReplyNotAllowed              = 0x02
+	ReplyNetworkUnreachable      = 0x03
+	ReplyHostUnreachable         = 0x04
 	ReplyConnectionRefused       = 0x05
+	ReplyTTLExpired              = 0x06
 	ReplyCommandNotSupported     = 0x07
 	ReplyAddressTypeNotSupported = 0x08
 )
@@ -63,6 +68,9 @@ type ConnectRequest struct {
 	DestPort uint16
 	// ClientConn is the client connection that needs to be proxied.
 	ClientConn net.Conn
+	// Username is the name the client authenticated with, empty when
+	// authentication is disabled.
+	Username string
 }
 
 // ConnectHandler is called for each SOCKS5 CONNECT request.
@@ -145,6 +153,22 @@ func (s *Server) Close() error {
 	return nil
 }
 
+// SetCredentials replaces the username and password required from new
+// connections. Empty values disable authentication.
+func (s *Server) SetCredentials(username, password string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.config.Username = username
+	s.config.Password = password
+}
+
+// credentials returns the currently configured username and password.
+func (s *Server) credentials() (string, string) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.config.Username, s.config.Password
+}
+
 // Wait waits for all connections to complete.
 func (s *Server) Wait() {
 	s.wg.Wait()
@@ -155,7 +179,8 @@ func (s *Server) handleConnection(ctx context.Context, conn net.Conn) {
 	defer conn.Close()
 
 	// 1. Handle authentication negotiation
-	if err := s.handleAuth(conn); err != nil {
+	username, err := s.handleAuth(conn)
+	if err != nil {
 		return
 	}
 
@@ -170,6 +195,7 @@ func (s *Server) handleConnection(ctx context.Context, conn net.Conn) {
 		DestHost:   destHost,
 		DestPort:   destPort,
 		ClientConn: conn,
+		Username:   username,
 	}
 
 	if err := s.handler(ctx, req); err != nil {
@@ -178,26 +204,28 @@ func (s *Server) handleConnection(ctx context.Context, conn net.Conn) {
 	}
 }
 
-// handleAuth handles SOCKS5 authentication negotiation.
-func (s *Server) handleAuth(conn net.Conn) error {
+// handleAuth handles SOCKS5 authentication negotiation. It returns the
+// authenticated username, if any.
+func (s *Server) handleAuth(conn net.Conn) (string, error) {
 	// Read version and number of methods
 	header := make([]byte, 2)
 	if _, err := io.ReadFull(conn, header); err != nil {
-		return err
+		return "", err
 	}
 
 	if header[0] != Version5 {
-		return ErrUnsupportedVersion
+		return "", ErrUnsupportedVersion
 	}
 
 	numMethods := int(header[1])
 	methods := make([]byte, numMethods)
 	if _, err := io.ReadFull(conn, methods); err != nil {
-		return err
+		return "", err
 	}
 
 	// Check if auth is required
-	requireAuth := s.config.Username != "" && s.config.Password != ""
+	username, password := s.credentials()
+	requireAuth := username != "" && password != ""
 
 	if requireAuth {
 		// Check if username/password auth is supported
@@ -211,17 +239,17 @@ func (s *Server) handleAuth(conn net.Conn) error {
 
 		if !hasUserPass {
 			_, _ = conn.Write([]byte{Version5, AuthNoAcceptable})
-			return ErrAuthFailed
+			return "", ErrAuthFailed
 		}
 
 		// Request username/password auth
 		if _, err := conn.Write([]byte{Version5, AuthUserPass}); err != nil {
-			return err
+			return "", err
 		}
 
 		// Read username/password
-		if err := s.handleUserPassAuth(conn); err != nil {
-			return err
+		if err := s.handleUserPassAuth(conn, username, password); err != nil {
+			return "", err
 		}
 	} else {
 		// No auth required
@@ -235,19 +263,22 @@ func (s *Server) handleAuth(conn net.Conn) error {
 
 		if !hasNoAuth {
 			_, _ = conn.Write([]byte{Version5, AuthNoAcceptable})
-			return ErrAuthFailed
+			return "", ErrAuthFailed
 		}
 
 		if _, err := conn.Write([]byte{Version5, AuthNone}); err != nil {
-			return err
+			return "", err
 		}
 	}
 
-	return nil
+	if requireAuth {
+		return username, nil
+	}
+	return "", nil
 }
 
 // handleUserPassAuth handles username/password authentication.
-func (s *Server) handleUserPassAuth(conn net.Conn) error {
+func (s *Server) handleUserPassAuth(conn net.Conn, wantUser, wantPass string) error {
 	// Read auth version
 	header := make([]byte, 2)
 	if _, err := io.ReadFull(conn, header); err != nil {
@@ -275,8 +306,8 @@ func (s *Server) handleUserPassAuth(conn net.Conn) error {
 	}
 
 	// Verify credentials using constant-time comparison to prevent timing attacks
-	usernameMatch := subtle.ConstantTimeCompare(username, []byte(s.config.Username)) == 1
-	passwordMatch := subtle.ConstantTimeCompare(password, []byte(s.config.Password)) == 1
+	usernameMatch := subtle.ConstantTimeCompare(username, []byte(wantUser)) == 1
+	passwordMatch := subtle.ConstantTimeCompare(password, []byte(wantPass)) == 1
 	if !usernameMatch || !passwordMatch {
 		_, _ = conn.Write([]byte{0x01, 0x01}) // Auth failed
 		return ErrAuthFailed
@@ -319,6 +350,12 @@ func (s *Server) handleRequest(conn net.Conn) (string, uint16, error) {
 		if _, err := io.ReadFull(conn, lenBuf); err != nil {
 			return "", 0, err
 		}
+		// An empty domain would have the destination dialed on the local
+		// host
+		if lenBuf[0] == 0 {
+			_ = s.sendReply(conn, ReplyGeneralFailure, nil, 0)
+			return "", 0, ErrEmptyDomain
+		}
 		domain := make([]byte, lenBuf[0])
 		if _, err := io.ReadFull(conn, domain); err != nil {
 			return "", 0, err
@@ -401,5 +438,5 @@ func (s *Server) Addr() net.Addr {
 
 // FormatDestination formats the destination as host:port string.
 func FormatDestination(host string, port uint16) string {
-	return fmt.Sprintf("%s:%d", host, port)
+	return net.JoinHostPort(host, strconv.Itoa(int(port)))
 }
@@ -1,6 +1,7 @@
 package socks5
 
 import (
+	"bytes"
 	"context"
 	"io"
 	"net"
@@ -74,7 +75,9 @@ func TestServerListenAndServe(t *testing.T) {
 }
 
 func TestServerWithAuth(t *testing.T) {
+	usernames := make(chan string, 1)
 	handler := func(ctx context.Context, req *ConnectRequest) error {
+		usernames <- req.Username
 		req.ClientConn.Close()
 		return nil
 	}
@@ -145,6 +148,73 @@ func TestServerWithAuth(t *testing.T) {
 		t.Errorf("Expected auth success, got %v", authResp)
 	}
 
+	// CONNECT to 127.0.0.1:80; the handler sees the authenticated user
+	if _, err := conn.Write([]byte{0x05, 0x01, 0x00, 0x01, 127, 0, 0, 1, 0, 80}); err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	select {
+	case username := <-usernames:
+		if username != "testuser" {
+			t.Errorf("Expected username testuser, got %q", username)
+		}
+	case <-time.After(time.Second):
+		t.Error("Handler was not called")
+	}
+
+	cancel()
+	server.Close()
+	wg.Wait()
+}
+
+func TestServerSetCredentials(t *testing.T) {
+	handler := func(ctx context.Context, req *ConnectRequest) error {
+		req.ClientConn.Close()
+		return nil
+	}
+
+	config := &Config{
+		ListenAddr: "127.0.0.1:0",
+	}
+
+	server := NewServer(config, handler)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		_ = server.ListenAndServe(ctx)
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+
+	// Enable auth on the running server
+	server.SetCredentials("newuser", "newpass")
+
+	conn, err := net.Dial("tcp", server.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+
+	// Offer only the no-auth method, which must now be rejected
+	_, err = conn.Write([]byte{0x05, 0x01, 0x00})
+	if err != nil {
+		t.Fatalf("Failed to send greeting: %v", err)
+	}
+
+	resp := make([]byte, 2)
+	_, err = io.ReadFull(conn, resp)
+	if err != nil {
+		t.Fatalf("Failed to read response: %v", err)
+	}
+
+	if resp[0] != 0x05 || resp[1] != AuthNoAcceptable {
+		t.Errorf("Expected no acceptable methods, got %v", resp)
+	}
+
 	cancel()
 	server.Close()
 	wg.Wait()
@@ -224,4 +294,52 @@ func TestFormatDestination(t *testing.T) {
 	if result != expected {
 		t.Errorf("Expected %s, got %s", expected, result)
 	}
+
+	result = FormatDestination("2001:db8::1", 443)
+	expected = "[2001:db8::1]:443"
+	if result != expected {
+		t.Errorf("Expected %s, got %s", expected, result)
+	}
+}
+
+// fuzzConn is a connection that reads a fixed input and discards writes.
+type fuzzConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c *fuzzConn) Read(b []byte) (int, error)  { return c.r.Read(b) }
+func (c *fuzzConn) Write(b []byte) (int, error) { return len(b), nil }
+
+func FuzzHandshake(f *testing.F) {
+	f.Add([]byte{0x05, 0x01, 0x00, 0x05, 0x01, 0x00, 0x01, 127, 0, 0, 1, 0x1F, 0x90}, false)
+	f.Add(append([]byte{0x05, 0x01, 0x00, 0x05, 0x01, 0x00, 0x03, 11}, "example.com\x01\xbb"...), false)
+	f.Add([]byte{0x05, 0x02, 0x00, 0x02, 0x01, 0x04, 'u', 's', 'e', 'r', 0x04, 'p', 'a', 's', 's',
+		0x05, 0x01, 0x00, 0x04, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1, 0x00, 0x50}, true)
+	f.Add([]byte{0x05, 0x01, 0x00, 0x05, 0x01, 0x00, 0x03, 0, 0x00, 0x50}, false)
+	f.Add([]byte{0x05, 0xff}, true)
+
+	f.Fuzz(func(t *testing.T, input []byte, auth bool) {
+		config := &Config{}
+		if auth {
+			config.Username, config.Password = "user", "pass"
+		}
+		server := NewServer(config, nil)
+		conn := &fuzzConn{r: bytes.NewReader(input)}
+
+		username, err := server.handleAuth(conn)
+		if err != nil {
+			return
+		}
+		if auth && username != "user" {
+			t.Fatalf("Authenticated as %q", username)
+		}
+		if !auth && username != "" {
+			t.Fatalf("Expected no username without authentication, got %q", username)
+		}
+		host, _, err := server.handleRequest(conn)
+		if err == nil && host == "" {
+			t.Fatal("Accepted an empty destination")
+		}
+	})
 }
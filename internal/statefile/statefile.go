@@ -0,0 +1,71 @@
+// Package statefile reads and writes the JSON state files that keep usage
+// counters, quotas and bans across restarts.
+package statefile
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Load decodes the state file at path into v. A missing file leaves v as
+// it is. name describes the file in errors, e.g. "usage state".
+func Load(path, name string, v any) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("failed to read %s: %w", name, err)
+	}
+
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", name, err)
+	}
+	return nil
+}
+
+// Save writes data to the state file at path atomically: a crash leaves
+// either the previous file or the new one, never a truncated file. The
+// data is synced to disk before Save returns. name describes the file in
+// errors.
+func Save(path, name string, data []byte) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create %s directory: %w", name, err)
+	}
+
+	tmp := path + ".tmp"
+	if err := writeSynced(tmp, data); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	// Make the rename itself durable
+	if err := syncDir(dir); err != nil {
+		return fmt.Errorf("failed to sync %s directory: %w", name, err)
+	}
+	return nil
+}
+
+// writeSynced writes data to the file at path and syncs it to disk.
+func writeSynced(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
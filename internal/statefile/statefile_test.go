@@ -0,0 +1,71 @@
+package statefile
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type testState struct {
+	Version int            `json:"version"`
+	Counts  map[string]int `json:"counts"`
+}
+
+func TestLoadMissing(t *testing.T) {
+	state := testState{Version: 1}
+	if err := Load(filepath.Join(t.TempDir(), "missing.json"), "test state", &state); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if state.Version != 1 || state.Counts != nil {
+		t.Errorf("Expected the state unchanged, got %+v", state)
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	if err := os.WriteFile(path, []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var state testState
+	if err := Load(path, "test state", &state); err == nil {
+		t.Error("Expected an error for a malformed state file")
+	}
+}
+
+func TestSaveAndLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nested", "state.json")
+	for _, data := range []string{`{"version":1,"counts":{"a":1}}`, `{"version":2,"counts":{"b":2}}`} {
+		if err := Save(path, "test state", []byte(data)); err != nil {
+			t.Fatalf("Save failed: %v", err)
+		}
+	}
+
+	var state testState
+	if err := Load(path, "test state", &state); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if state.Version != 2 || state.Counts["b"] != 2 || len(state.Counts) != 1 {
+		t.Errorf("Expected the last saved state, got %+v", state)
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("Expected no temporary file left, got %v", err)
+	}
+}
+
+func TestSaveFailure(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "state.json")
+	// A directory in the way of the file
+	if err := os.Mkdir(path, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(path, "keep"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := Save(path, "test state", []byte("{}")); err == nil {
+		t.Fatal("Expected Save to fail")
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("Expected the temporary file removed, got %v", err)
+	}
+}
@@ -0,0 +1,15 @@
+//go:build !windows
+
+package statefile
+
+import "os"
+
+// syncDir syncs a directory, persisting the renames in it.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,9 @@
+//go:build windows
+
+package statefile
+
+// syncDir is a no-op: Windows cannot sync directories, and its renames
+// are persisted with the file system's metadata.
+func syncDir(string) error {
+	return nil
+}
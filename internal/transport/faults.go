@@ -0,0 +1,134 @@
+package transport
+
+import (
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// reorderHold is how long a message held back waits for the next one before
+// it is sent anyway.
+const reorderHold = 50 * time.Millisecond
+
+// ErrInjectedDisconnect is returned by the write that dropped a connection
+// because of FaultConfig.DisconnectAfter.
+var ErrInjectedDisconnect = errors.New("injected disconnect")
+
+// FaultConfig injects faults into the messages a connection sends, to test
+// reconnects, reassembly and retransmission. Faults are drawn from a random
+// source seeded with Seed, so the same seed and the same sequence of writes
+// reproduce the same faults.
+type FaultConfig struct {
+	// Seed seeds the random source that decides which messages are faulted
+	Seed int64
+	// Latency delays every message
+	Latency time.Duration
+	// Jitter adds a random delay of up to Jitter to every message
+	Jitter time.Duration
+	// DropRate is the fraction of messages silently dropped
+	DropRate float64
+	// DuplicateRate is the fraction of messages sent twice
+	DuplicateRate float64
+	// ReorderRate is the fraction of messages held back and sent after the
+	// next one (or after a short while if none follows)
+	ReorderRate float64
+	// DisconnectAfter drops the connection, without a close message, on the
+	// write after this many messages (0 never drops it)
+	DisconnectAfter int
+}
+
+// faultInjector applies a FaultConfig to the messages of one connection.
+type faultInjector struct {
+	config *FaultConfig
+	// send writes a held back message nothing followed
+	send func(data []byte) error
+
+	mu   sync.Mutex
+	rng  *rand.Rand
+	held []byte // message held back to be sent after the next one
+	// holds counts the messages held back, telling a release timer whether
+	// its message is still held
+	holds int
+	sent  int
+}
+
+func newFaultInjector(config *FaultConfig, send func(data []byte) error) *faultInjector {
+	return &faultInjector{
+		config: config,
+		send:   send,
+		rng:    rand.New(rand.NewSource(config.Seed)),
+	}
+}
+
+// apply decides the fate of a message: it returns the messages to send in
+// its place, how long to wait before sending them, and whether to drop the
+// connection instead.
+func (f *faultInjector) apply(data []byte) (out [][]byte, delay time.Duration, disconnect bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.config.DisconnectAfter > 0 && f.sent >= f.config.DisconnectAfter {
+		return nil, 0, true
+	}
+	f.sent++
+
+	delay = f.config.Latency
+	if f.config.Jitter > 0 {
+		delay += time.Duration(f.rng.Int63n(int64(f.config.Jitter)))
+	}
+
+	if f.rng.Float64() < f.config.DropRate {
+		return nil, delay, false
+	}
+	if f.held == nil && f.rng.Float64() < f.config.ReorderRate {
+		// Callers may reuse data once Write returns
+		f.held = append([]byte(nil), data...)
+		f.holds++
+		hold := f.holds
+		time.AfterFunc(delay+reorderHold, func() { f.release(hold) })
+		return nil, delay, false
+	}
+	out = append(out, data)
+	if f.rng.Float64() < f.config.DuplicateRate {
+		out = append(out, data)
+	}
+	if f.held != nil {
+		out = append(out, f.held)
+		f.held = nil
+	}
+	return out, delay, false
+}
+
+// release sends the hold-th held back message if it is still held.
+func (f *faultInjector) release(hold int) {
+	f.mu.Lock()
+	held := f.held
+	if held == nil || f.holds != hold {
+		f.mu.Unlock()
+		return
+	}
+	f.held = nil
+	f.mu.Unlock()
+	_ = f.send(held)
+}
+
+// writeFaulty writes data with the faults of the connection's injector.
+func (c *Connection) writeFaulty(data []byte) error {
+	out, delay, disconnect := c.faults.apply(data)
+	if disconnect {
+		// Like a broken network: no close message, the peer's reads fail
+		_ = c.conn.Close()
+		_ = c.Close()
+		return ErrInjectedDisconnect
+	}
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+	for _, msg := range out {
+		if err := c.write(msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
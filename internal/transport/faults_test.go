@@ -0,0 +1,127 @@
+package transport
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sahmadiut/half-tunnel/pkg/logger"
+)
+
+// faultOutput runs n messages through an injector and returns what it sends.
+func faultOutput(config *FaultConfig, n int) []string {
+	f := newFaultInjector(config, func([]byte) error { return nil })
+	var sent []string
+	for i := 0; i < n; i++ {
+		out, _, _ := f.apply([]byte(fmt.Sprint(i)))
+		for _, msg := range out {
+			sent = append(sent, string(msg))
+		}
+	}
+	return sent
+}
+
+func TestFaultInjectorReproducible(t *testing.T) {
+	config := &FaultConfig{Seed: 42, DropRate: 0.1, DuplicateRate: 0.1, ReorderRate: 0.1}
+
+	first := faultOutput(config, 200)
+	if !reflect.DeepEqual(first, faultOutput(config, 200)) {
+		t.Error("Expected the same seed to inject the same faults")
+	}
+	other := *config
+	other.Seed = 7
+	if reflect.DeepEqual(first, faultOutput(&other, 200)) {
+		t.Error("Expected another seed to inject other faults")
+	}
+}
+
+func TestFaultInjectorFaults(t *testing.T) {
+	tests := []struct {
+		name   string
+		config FaultConfig
+		want   []string
+	}{
+		{"none", FaultConfig{}, []string{"0", "1", "2"}},
+		{"drop", FaultConfig{DropRate: 1}, nil},
+		{"duplicate", FaultConfig{DuplicateRate: 1}, []string{"0", "0", "1", "1", "2", "2"}},
+		{"reorder", FaultConfig{ReorderRate: 1}, []string{"1", "0"}},
+		{"disconnect", FaultConfig{DisconnectAfter: 2}, []string{"0", "1"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := faultOutput(&tt.config, 3); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestFaultInjectorLatency(t *testing.T) {
+	f := newFaultInjector(&FaultConfig{Latency: 10 * time.Millisecond, Jitter: 5 * time.Millisecond}, nil)
+	for i := 0; i < 10; i++ {
+		_, delay, _ := f.apply([]byte("x"))
+		if delay < 10*time.Millisecond || delay >= 15*time.Millisecond {
+			t.Fatalf("Expected a delay in [10ms, 15ms), got %v", delay)
+		}
+	}
+}
+
+func TestFaultInjectorReleasesHeldMessage(t *testing.T) {
+	released := make(chan string, 1)
+	f := newFaultInjector(&FaultConfig{ReorderRate: 1}, func(data []byte) error {
+		released <- string(data)
+		return nil
+	})
+
+	if out, _, _ := f.apply([]byte("last")); len(out) != 0 {
+		t.Fatalf("Expected the message held back, got %q", out)
+	}
+	select {
+	case got := <-released:
+		if got != "last" {
+			t.Errorf("Expected the held message released, got %q", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected a held message released when nothing follows")
+	}
+}
+
+func TestFaultInjectorDisconnects(t *testing.T) {
+	handler := NewServerHandler(&ServerConfig{Faults: &FaultConfig{DisconnectAfter: 2}}, logger.NewDefault())
+	defer handler.Close()
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	client, err := Dial(context.Background(), DefaultConfig("ws"+strings.TrimPrefix(server.URL, "http")))
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer client.Close()
+	accepted := <-handler.Accept()
+
+	for i := 0; i < 2; i++ {
+		if err := accepted.Write([]byte("data")); err != nil {
+			t.Fatalf("Write %d failed: %v", i, err)
+		}
+	}
+	if err := accepted.Write([]byte("data")); err != ErrInjectedDisconnect {
+		t.Fatalf("Expected ErrInjectedDisconnect, got %v", err)
+	}
+	if !accepted.IsClosed() {
+		t.Error("Expected the connection closed")
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := client.Read(); err != nil {
+			t.Fatalf("Read %d failed: %v", i, err)
+		}
+	}
+	if _, err := client.Read(); err == nil {
+		t.Error("Expected the peer to see the connection drop")
+	}
+}
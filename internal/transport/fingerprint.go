@@ -0,0 +1,40 @@
+package transport
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Browser TLS fingerprints for Config.Fingerprint. The dial sends the
+// ClientHello of a current release of the browser instead of Go's own,
+// which DPI can tell apart from browser traffic.
+const (
+	FingerprintChrome  = "chrome"
+	FingerprintFirefox = "firefox"
+	FingerprintSafari  = "safari"
+)
+
+// Fingerprints lists the supported browser fingerprints.
+var Fingerprints = []string{FingerprintChrome, FingerprintFirefox, FingerprintSafari}
+
+// ErrFingerprintUnsupported is returned for browser fingerprints by builds
+// without uTLS.
+var ErrFingerprintUnsupported = errors.New("TLS fingerprints need a build with -tags utls")
+
+// ValidateFingerprint checks a fingerprint name; empty keeps Go's TLS
+// handshake.
+func ValidateFingerprint(name string) error {
+	if name == "" {
+		return nil
+	}
+	for _, fp := range Fingerprints {
+		if name == fp {
+			if !fingerprintSupported {
+				return ErrFingerprintUnsupported
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown TLS fingerprint %q (use %s)", name, strings.Join(Fingerprints, ", "))
+}
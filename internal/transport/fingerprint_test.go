@@ -0,0 +1,50 @@
+package transport
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestValidateFingerprint(t *testing.T) {
+	if err := ValidateFingerprint(""); err != nil {
+		t.Errorf("Expected no fingerprint to be valid, got %v", err)
+	}
+	for _, fp := range Fingerprints {
+		err := ValidateFingerprint(fp)
+		if fingerprintSupported && err != nil || !fingerprintSupported && err != ErrFingerprintUnsupported {
+			t.Errorf("ValidateFingerprint(%s) = %v", fp, err)
+		}
+	}
+	if err := ValidateFingerprint("netscape"); err == nil {
+		t.Error("Expected an error for an unknown fingerprint")
+	}
+}
+
+func TestDialWithFingerprint(t *testing.T) {
+	server := httptest.NewTLSServer(NewServerHandler(nil, nil))
+	defer server.Close()
+
+	config := DefaultConfig("wss" + strings.TrimPrefix(server.URL, "https"))
+	config.TLSConfig = &tls.Config{InsecureSkipVerify: true}
+	config.Fingerprint = FingerprintChrome
+
+	conn, err := Dial(context.Background(), config)
+	if fingerprintSupported {
+		if err != nil {
+			t.Fatalf("Failed to dial: %v", err)
+		}
+		conn.Close()
+	} else if !errors.Is(err, ErrFingerprintUnsupported) {
+		t.Fatalf("Expected ErrFingerprintUnsupported, got %v", err)
+	}
+
+	config.ProxyURL, _ = url.Parse("http://127.0.0.1:3128")
+	if _, err := Dial(context.Background(), config); err == nil {
+		t.Error("Expected an error for a fingerprint with a proxy")
+	}
+}
@@ -0,0 +1,203 @@
+package transport
+
+import (
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// closeFlushTimeout bounds how long Close waits for queued messages to be
+// written.
+const closeFlushTimeout = time.Second
+
+// QueueObserver is told about send queue activity, e.g. to export metrics.
+// It is called from several goroutines.
+type QueueObserver interface {
+	// QueueChanged reports messages added to (delta > 0) or taken from
+	// (delta < 0) a send queue.
+	QueueChanged(delta int)
+	// BatchWritten reports the number of messages written in one batch.
+	BatchWritten(messages int)
+}
+
+// sendQueue serializes the writes to a connection through a single writer
+// goroutine, so senders do not contend for the connection. Messages queued
+// while a write is in progress are written back to back in the next batch,
+// under a single write deadline.
+type sendQueue struct {
+	ch       chan []byte
+	observer QueueObserver
+
+	// mu guards stopped, so no message is queued once the writer has
+	// drained the queue for the last time
+	mu      sync.Mutex
+	stopped bool
+	// err is the write error that stopped the writer, set before done is
+	// closed
+	err error
+
+	closeOnce sync.Once
+	closing   chan struct{} // closed by Close: flush and stop
+	done      chan struct{} // closed when the writer stops
+}
+
+// startSendQueue routes Write through a queue of size messages and starts
+// its writer.
+func (c *Connection) startSendQueue(size int, observer QueueObserver) {
+	c.queue = &sendQueue{
+		ch:       make(chan []byte, size),
+		observer: observer,
+		closing:  make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go c.writeLoop()
+}
+
+// enqueue queues data for the writer. While the queue is full it waits for
+// room, at most WriteTimeout. It fails if the connection is closed or an
+// earlier write failed.
+func (c *Connection) enqueue(data []byte) error {
+	q := c.queue
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.stopped {
+		if q.err != nil {
+			return q.err
+		}
+		return ErrConnectionClosed
+	}
+	select {
+	case q.ch <- data:
+		q.changed(1)
+		return nil
+	default:
+	}
+
+	var timeout <-chan time.Time
+	if c.config.WriteTimeout > 0 {
+		timer := time.NewTimer(c.config.WriteTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case q.ch <- data:
+		q.changed(1)
+		return nil
+	case <-q.closing:
+		return ErrConnectionClosed
+	case <-q.done:
+		if q.err != nil {
+			return q.err
+		}
+		return ErrConnectionClosed
+	case <-timeout:
+		return ErrWriteTimeout
+	}
+}
+
+// writeLoop writes queued messages until the connection is closed or a
+// write fails; a failed write closes the connection.
+func (c *Connection) writeLoop() {
+	q := c.queue
+	var batch [][]byte
+	for {
+		select {
+		case data := <-q.ch:
+			batch = append(batch[:0], data)
+		case <-q.closing:
+			c.flushQueue()
+			return
+		}
+		// Take everything queued meanwhile
+	drain:
+		for {
+			select {
+			case data := <-q.ch:
+				batch = append(batch, data)
+			default:
+				break drain
+			}
+		}
+
+		deadline := time.Time{}
+		if c.config.WriteTimeout > 0 {
+			deadline = time.Now().Add(c.config.WriteTimeout)
+		}
+		if err := c.writeBatch(batch, deadline); err != nil {
+			// Senders waiting for room give up on done, so stop can take
+			// the lock
+			q.err = err
+			close(q.done)
+			q.changed(-len(q.stop()))
+			_ = c.Close()
+			return
+		}
+	}
+}
+
+// flushQueue writes the messages left in the queue when the connection is
+// closed, for at most closeFlushTimeout.
+func (c *Connection) flushQueue() {
+	q := c.queue
+	defer close(q.done)
+	// Senders give up on closing, so stop can take the lock
+	pending := q.stop()
+	if len(pending) > 0 {
+		_ = c.writeBatch(pending, time.Now().Add(closeFlushTimeout))
+	}
+}
+
+// writeBatch writes messages back to back.
+func (c *Connection) writeBatch(batch [][]byte, deadline time.Time) error {
+	c.queue.changed(-len(batch))
+	if c.queue.observer != nil {
+		c.queue.observer.BatchWritten(len(batch))
+	}
+	if err := c.conn.SetWriteDeadline(deadline); err != nil {
+		return err
+	}
+	for _, data := range batch {
+		if err := c.conn.WriteMessage(websocket.BinaryMessage, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// stop refuses further messages and returns those still queued.
+func (q *sendQueue) stop() [][]byte {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.stopped = true
+
+	var pending [][]byte
+	for {
+		select {
+		case data := <-q.ch:
+			pending = append(pending, data)
+		default:
+			return pending
+		}
+	}
+}
+
+// close asks the writer to flush the queue and waits until it stops, at
+// most closeFlushTimeout.
+func (q *sendQueue) close() {
+	q.closeOnce.Do(func() { close(q.closing) })
+	timer := time.NewTimer(closeFlushTimeout)
+	defer timer.Stop()
+	select {
+	case <-q.done:
+	case <-timer.C:
+	}
+}
+
+// changed reports a change in the number of queued messages.
+func (q *sendQueue) changed(delta int) {
+	if q.observer != nil && delta != 0 {
+		q.observer.QueueChanged(delta)
+	}
+}
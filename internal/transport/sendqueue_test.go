@@ -0,0 +1,143 @@
+package transport
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sahmadiut/half-tunnel/pkg/logger"
+)
+
+type recordingObserver struct {
+	mu       sync.Mutex
+	depth    int
+	messages int
+	batches  int
+}
+
+func (o *recordingObserver) QueueChanged(delta int) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.depth += delta
+}
+
+func (o *recordingObserver) BatchWritten(messages int) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.messages += messages
+	o.batches++
+}
+
+// dialQueued connects a client with a send queue to a server handler and
+// returns both ends.
+func dialQueued(t *testing.T, observer QueueObserver) (*Connection, *Connection) {
+	t.Helper()
+	handler := NewServerHandler(nil, logger.NewDefault())
+	t.Cleanup(func() { handler.Close() })
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	config := DefaultConfig("ws" + strings.TrimPrefix(server.URL, "http"))
+	config.SendQueueSize = 8
+	config.QueueObserver = observer
+	client, err := Dial(context.Background(), config)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	select {
+	case accepted := <-handler.Accept():
+		t.Cleanup(func() { accepted.Close() })
+		return client, accepted
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for the connection")
+		return nil, nil
+	}
+}
+
+func TestSendQueueKeepsOrder(t *testing.T) {
+	observer := &recordingObserver{}
+	client, accepted := dialQueued(t, observer)
+
+	const n = 100
+	go func() {
+		for i := 0; i < n; i++ {
+			if err := client.Write([]byte(fmt.Sprint(i))); err != nil {
+				t.Errorf("Write %d failed: %v", i, err)
+				return
+			}
+		}
+	}()
+
+	for i := 0; i < n; i++ {
+		data, err := accepted.Read()
+		if err != nil {
+			t.Fatalf("Read %d failed: %v", i, err)
+		}
+		if string(data) != fmt.Sprint(i) {
+			t.Fatalf("Expected message %d, got %q", i, data)
+		}
+	}
+
+	observer.mu.Lock()
+	defer observer.mu.Unlock()
+	if observer.depth != 0 || observer.messages != n {
+		t.Errorf("Expected an empty queue after %d messages, got depth %d after %d", n, observer.depth, observer.messages)
+	}
+	if observer.batches < 1 || observer.batches > n {
+		t.Errorf("Unexpected number of batches: %d", observer.batches)
+	}
+}
+
+func TestSendQueueFlushesOnClose(t *testing.T) {
+	client, accepted := dialQueued(t, nil)
+
+	for i := 0; i < 5; i++ {
+		if err := client.Write([]byte(fmt.Sprint(i))); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+	}
+	client.Close()
+	if err := client.Write([]byte("late")); err != ErrConnectionClosed {
+		t.Errorf("Expected ErrConnectionClosed after Close, got %v", err)
+	}
+
+	for i := 0; i < 5; i++ {
+		data, err := accepted.Read()
+		if err != nil {
+			t.Fatalf("Expected queued message %d before the close, got %v", i, err)
+		}
+		if string(data) != fmt.Sprint(i) {
+			t.Fatalf("Expected message %d, got %q", i, data)
+		}
+	}
+	if _, err := accepted.Read(); err == nil {
+		t.Error("Expected the connection to be closed after the queued messages")
+	}
+}
+
+func TestSendQueueWriteFailureClosesConnection(t *testing.T) {
+	client, accepted := dialQueued(t, nil)
+	accepted.Close()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if err := client.Write(make([]byte, 1024)); err != nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected writes to fail after the peer closed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	select {
+	case <-client.ClosedChan():
+	case <-time.After(time.Second):
+		t.Error("Expected a failed write to close the connection")
+	}
+}
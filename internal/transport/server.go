@@ -2,8 +2,10 @@
 package transport
 
 import (
+	"context"
 	"net/http"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -13,13 +15,37 @@ import (
 
 // ServerConfig holds server transport configuration.
 type ServerConfig struct {
-	ReadBufferSize    int
-	WriteBufferSize   int
-	MaxMessageSize    int64
-	ChannelBufferSize int // Buffer size for connection channel
+	ReadBufferSize  int
+	WriteBufferSize int
+	MaxMessageSize  int64
+	// ChannelBufferSize is the depth of the accept queue: upgraded
+	// connections waiting for Accept. Upgrades beyond it are refused with
+	// 503 Service Unavailable.
+	ChannelBufferSize int
 	HandshakeTimeout  time.Duration
+	// Sources caps the open connections per source IP; upgrades beyond it
+	// are refused with 429 Too Many Requests (nil means no cap)
+	Sources *SourceLimiter
+	// SendQueueSize routes writes to accepted connections through a queue
+	// of this many messages (0 writes directly)
+	SendQueueSize int
+	// QueueObserver is told about send queue activity (optional)
+	QueueObserver QueueObserver
+	// Faults injects faults into the messages sent on accepted
+	// connections, for tests (nil sends them faithfully)
+	Faults *FaultConfig
 }
 
+// Reasons the handler drops a connection, passed to the drop callback.
+const (
+	// DropQueueFull means the accept queue was full.
+	DropQueueFull = "queue_full"
+	// DropClosing means the handler was closing.
+	DropClosing = "closing"
+	// DropSourceLimit means the source IP had too many open connections.
+	DropSourceLimit = "source_limit"
+)
+
 // DefaultServerConfig returns a ServerConfig with sensible defaults.
 func DefaultServerConfig() *ServerConfig {
 	return &ServerConfig{
@@ -40,6 +66,12 @@ type ServerHandler struct {
 	mu       sync.RWMutex
 	closed   bool
 	log      *logger.Logger
+
+	// upgrading counts upgrades in progress, which will need room in the
+	// accept queue; updated atomically
+	upgrading int32
+	dropped   int64 // updated atomically
+	onDrop    func(reason string)
 }
 
 // NewServerHandler creates a new server handler.
@@ -83,12 +115,40 @@ func (h *ServerHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	h.mu.RUnlock()
 
 	if closed {
+		h.drop(DropClosing)
 		http.Error(w, "server closed", http.StatusServiceUnavailable)
 		return
 	}
 
+	// Shed load before upgrading while the consumer cannot keep up, so
+	// the client sees a plain 503 and retries instead of a dead connection
+	upgrading := atomic.AddInt32(&h.upgrading, 1)
+	defer atomic.AddInt32(&h.upgrading, -1)
+	if len(h.connCh)+int(upgrading) > cap(h.connCh) {
+		h.drop(DropQueueFull)
+		h.log.Warn().
+			Str("remote_addr", r.RemoteAddr).
+			Int("queue_size", cap(h.connCh)).
+			Msg("Rejected connection: accept queue full")
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, "server busy", http.StatusServiceUnavailable)
+		return
+	}
+
+	ip := remoteIP(r.RemoteAddr)
+	if !h.config.Sources.acquire(ip) {
+		h.drop(DropSourceLimit)
+		h.log.Warn().
+			Str("remote_addr", r.RemoteAddr).
+			Int("max_connections", h.config.Sources.max).
+			Msg("Rejected connection: too many connections from source")
+		http.Error(w, "too many connections", http.StatusTooManyRequests)
+		return
+	}
+
 	conn, err := h.upgrader.Upgrade(w, r, nil)
 	if err != nil {
+		h.config.Sources.release(ip)
 		h.log.Error().Err(err).
 			Str("remote_addr", r.RemoteAddr).
 			Str("path", r.URL.Path).
@@ -99,36 +159,99 @@ func (h *ServerHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	conn.SetReadLimit(h.config.MaxMessageSize)
 
+	relay, relayed := r.Context().Value(relayedClientKey{}).(relayedClient)
 	c := &Connection{
 		conn: conn,
 		config: &Config{
 			MaxMessageSize: h.config.MaxMessageSize,
 		},
-		closedCh: make(chan struct{}),
+		peerCN:     peerCommonName(r),
+		remoteAddr: r.RemoteAddr,
+		relayed:    relayed,
+		closedCh:   make(chan struct{}),
+	}
+	if relayed {
+		c.peerCN = relay.peerCN
+	}
+	if h.config.SendQueueSize > 0 {
+		c.startSendQueue(h.config.SendQueueSize, h.config.QueueObserver)
+	}
+	if h.config.Faults != nil {
+		c.faults = newFaultInjector(h.config.Faults, c.write)
+	}
+	if h.config.Sources != nil {
+		c.onClose = func() { h.config.Sources.release(ip) }
 	}
 
 	// Non-blocking send to connection channel, or drop if closed
 	select {
 	case h.connCh <- c:
 		h.log.Info().
-			Str("remote_addr", conn.RemoteAddr().String()).
+			Str("remote_addr", c.RemoteAddr()).
 			Msg("Accepted WebSocket connection")
 	case <-h.closeCh:
 		// Handler is closing, close the connection
 		c.Close()
+		h.drop(DropClosing)
 		h.log.Debug().
-			Str("remote_addr", conn.RemoteAddr().String()).
+			Str("remote_addr", c.RemoteAddr()).
 			Msg("Rejected connection: handler closing")
 	default:
 		// Channel full, close connection
 		c.Close()
+		h.drop(DropQueueFull)
 		h.log.Warn().
-			Str("remote_addr", conn.RemoteAddr().String()).
+			Str("remote_addr", c.RemoteAddr()).
 			Int("buffer_size", cap(h.connCh)).
 			Msg("Rejected connection: channel full")
 	}
 }
 
+// SetOnDrop sets a callback for connections the handler drops, with the
+// reason. It must be set before the handler serves requests.
+func (h *ServerHandler) SetOnDrop(fn func(reason string)) {
+	h.onDrop = fn
+}
+
+// Dropped returns the number of connections the handler dropped.
+func (h *ServerHandler) Dropped() int64 {
+	return atomic.LoadInt64(&h.dropped)
+}
+
+// drop counts a dropped connection.
+func (h *ServerHandler) drop(reason string) {
+	atomic.AddInt64(&h.dropped, 1)
+	if h.onDrop != nil {
+		h.onDrop(reason)
+	}
+}
+
+// relayedClientKey is the context key of relayedClient.
+type relayedClientKey struct{}
+
+// relayedClient describes the client of a relayed request.
+type relayedClient struct {
+	peerCN string
+}
+
+// WithRelayedClient marks a request as relayed by another server for a
+// client whose verified certificate has common name peerCN ("" for none).
+// The accepted connection reports peerCN instead of the relay's certificate
+// and r.RemoteAddr, which the caller sets to the client's address, instead of
+// the relay's.
+func WithRelayedClient(ctx context.Context, peerCN string) context.Context {
+	return context.WithValue(ctx, relayedClientKey{}, relayedClient{peerCN: peerCN})
+}
+
+// peerCommonName returns the common name of the client certificate verified
+// during the TLS handshake of r, or "" if the client presented none.
+func peerCommonName(r *http.Request) string {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return ""
+	}
+	return r.TLS.VerifiedChains[0][0].Subject.CommonName
+}
+
 // Accept returns a channel that receives new connections.
 func (h *ServerHandler) Accept() <-chan *Connection {
 	return h.connCh
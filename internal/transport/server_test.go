@@ -1,6 +1,14 @@
 package transport
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"strings"
@@ -90,3 +98,208 @@ func TestDefaultServerConfig(t *testing.T) {
 		t.Errorf("Expected MaxMessageSize 1MB, got %d", config.MaxMessageSize)
 	}
 }
+
+// newTestClientCert returns a self-signed client certificate for commonName.
+func newTestClientCert(t *testing.T, commonName string) (tls.Certificate, *x509.Certificate) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("Failed to parse certificate: %v", err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, leaf
+}
+
+func TestServerHandlerPeerCommonName(t *testing.T) {
+	clientCert, leaf := newTestClientCert(t, "client-01")
+	pool := x509.NewCertPool()
+	pool.AddCert(leaf)
+
+	handler := NewServerHandler(nil, logger.NewDefault())
+	server := httptest.NewUnstartedServer(handler)
+	server.TLS = &tls.Config{
+		ClientAuth: tls.RequireAndVerifyClientCert,
+		ClientCAs:  pool,
+	}
+	server.StartTLS()
+	defer server.Close()
+
+	wsURL := "wss" + strings.TrimPrefix(server.URL, "https")
+	dialer := websocket.Dialer{
+		TLSClientConfig: &tls.Config{
+			InsecureSkipVerify: true,
+			Certificates:       []tls.Certificate{clientCert},
+		},
+	}
+	conn, _, err := dialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+
+	select {
+	case c := <-handler.Accept():
+		if cn := c.PeerCommonName(); cn != "client-01" {
+			t.Errorf("Expected peer common name 'client-01', got '%s'", cn)
+		}
+		c.Close()
+	case <-time.After(time.Second):
+		t.Fatal("Timeout waiting for connection")
+	}
+
+	handler.Close()
+}
+
+func TestServerHandlerShedsLoadWhenQueueFull(t *testing.T) {
+	config := DefaultServerConfig()
+	config.ChannelBufferSize = 1
+	handler := NewServerHandler(config, logger.NewDefault())
+	defer handler.Close()
+	var reasons []string
+	handler.SetOnDrop(func(reason string) { reasons = append(reasons, reason) })
+
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	// The first connection fills the queue; nothing accepts it
+	first, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer first.Close()
+
+	_, resp, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err == nil {
+		t.Fatal("Expected the upgrade to be refused while the queue is full")
+	}
+	if resp == nil || resp.StatusCode != http.StatusServiceUnavailable || resp.Header.Get("Retry-After") == "" {
+		t.Fatalf("Expected 503 with Retry-After, got %+v", resp)
+	}
+	if handler.Dropped() != 1 || len(reasons) != 1 || reasons[0] != DropQueueFull {
+		t.Errorf("Expected one queue_full drop, got %d (%v)", handler.Dropped(), reasons)
+	}
+
+	// Accepting the queued connection makes room again
+	<-handler.Accept()
+	second, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatalf("Expected the upgrade to succeed once the queue has room: %v", err)
+	}
+	second.Close()
+}
+
+func TestServerHandlerLimitsConnectionsPerSource(t *testing.T) {
+	config := DefaultServerConfig()
+	config.Sources = NewSourceLimiter(2, nil)
+	upstream := NewServerHandler(config, logger.NewDefault())
+	defer upstream.Close()
+	downstream := NewServerHandler(config, logger.NewDefault())
+	defer downstream.Close()
+	var reasons []string
+	downstream.SetOnDrop(func(reason string) { reasons = append(reasons, reason) })
+
+	upServer := httptest.NewServer(upstream)
+	defer upServer.Close()
+	downServer := httptest.NewServer(downstream)
+	defer downServer.Close()
+	upURL := "ws" + strings.TrimPrefix(upServer.URL, "http")
+	downURL := "ws" + strings.TrimPrefix(downServer.URL, "http")
+
+	// Both handlers share the cap
+	for _, url := range []string{upURL, downURL} {
+		conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+		if err != nil {
+			t.Fatalf("Failed to connect: %v", err)
+		}
+		defer conn.Close()
+	}
+
+	_, resp, err := websocket.DefaultDialer.Dial(downURL, nil)
+	if err == nil {
+		t.Fatal("Expected the upgrade over the per-source cap to be refused")
+	}
+	if resp == nil || resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("Expected 429, got %+v", resp)
+	}
+	if len(reasons) != 1 || reasons[0] != DropSourceLimit {
+		t.Errorf("Expected one source_limit drop, got %v", reasons)
+	}
+
+	// Closing a connection frees its slot
+	(<-upstream.Accept()).Close()
+	if got := config.Sources.Open("127.0.0.1"); got != 1 {
+		t.Errorf("Expected 1 open connection after close, got %d", got)
+	}
+	conn, _, err := websocket.DefaultDialer.Dial(downURL, nil)
+	if err != nil {
+		t.Fatalf("Expected the upgrade to succeed after a connection closed: %v", err)
+	}
+	conn.Close()
+}
+
+func TestSourceLimiterExempt(t *testing.T) {
+	_, loopback, _ := net.ParseCIDR("127.0.0.0/8")
+	limiter := NewSourceLimiter(1, []*net.IPNet{loopback})
+	for i := 0; i < 3; i++ {
+		if !limiter.acquire("127.0.0.1") {
+			t.Fatal("Expected exempt source to be allowed")
+		}
+	}
+	limiter.release("127.0.0.1")
+	if got := limiter.Open("127.0.0.1"); got != 0 {
+		t.Errorf("Expected exempt source to take no slots, got %d", got)
+	}
+
+	if !limiter.acquire("192.0.2.1") || limiter.acquire("192.0.2.1") {
+		t.Error("Expected one connection from a limited source")
+	}
+}
+
+func TestServerHandlerRelayedClient(t *testing.T) {
+	handler := NewServerHandler(nil, logger.NewDefault())
+	defer handler.Close()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		relayed := r.WithContext(WithRelayedClient(r.Context(), "alice"))
+		relayed.RemoteAddr = "198.51.100.7:40000"
+		handler.ServeHTTP(w, relayed)
+	}))
+	defer server.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	defer conn.Close()
+
+	select {
+	case c := <-handler.Accept():
+		defer c.Close()
+		if !c.Relayed() {
+			t.Error("Expected a relayed connection")
+		}
+		if c.RemoteAddr() != "198.51.100.7:40000" {
+			t.Errorf("Expected the client's address, got %s", c.RemoteAddr())
+		}
+		if c.PeerCommonName() != "alice" {
+			t.Errorf("Expected the client's certificate name, got %q", c.PeerCommonName())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timeout waiting for connection")
+	}
+}
@@ -0,0 +1,85 @@
+package transport
+
+import (
+	"net"
+	"sync"
+)
+
+// SourceLimiter caps the open connections per source IP. One limiter may be
+// shared by several handlers, so the cap covers all of them.
+type SourceLimiter struct {
+	max    int
+	exempt []*net.IPNet
+	mu     sync.Mutex
+	open   map[string]int
+}
+
+// NewSourceLimiter creates a limiter allowing max open connections per
+// source IP. Sources in exempt are not limited.
+func NewSourceLimiter(max int, exempt []*net.IPNet) *SourceLimiter {
+	return &SourceLimiter{
+		max:    max,
+		exempt: exempt,
+		open:   make(map[string]int),
+	}
+}
+
+// acquire takes a connection slot for ip. It reports false if ip has max
+// connections open already. A nil limiter allows every connection.
+func (l *SourceLimiter) acquire(ip string) bool {
+	if l == nil || l.exempted(ip) {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.open[ip] >= l.max {
+		return false
+	}
+	l.open[ip]++
+	return true
+}
+
+// release returns a connection slot taken by acquire.
+func (l *SourceLimiter) release(ip string) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	// Exempt sources took no slot and have no entry
+	if l.open[ip] <= 1 {
+		delete(l.open, ip)
+		return
+	}
+	l.open[ip]--
+}
+
+// exempted reports whether ip is in an exempt network.
+func (l *SourceLimiter) exempted(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, network := range l.exempt {
+		if network.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// Open returns the number of open connections from ip.
+func (l *SourceLimiter) Open(ip string) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.open[ip]
+}
+
+// remoteIP returns the IP of a remote address.
+func remoteIP(remoteAddr string) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return remoteAddr
+	}
+	return host
+}
@@ -5,13 +5,18 @@ import (
 	"context"
 	"crypto/tls"
 	"errors"
+	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"net/url"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/sahmadiut/half-tunnel/internal/constants"
+	"github.com/sahmadiut/half-tunnel/internal/sockopt"
 )
 
 // Errors
@@ -33,6 +38,26 @@ type Config struct {
 	HandshakeTimeout time.Duration
 	ReadBufferSize   int
 	WriteBufferSize  int
+	// SendQueueSize routes writes through a queue of this many messages
+	// drained by a single writer goroutine (0 writes directly)
+	SendQueueSize int
+	// QueueObserver is told about send queue activity (optional)
+	QueueObserver QueueObserver
+	// Header is sent with the WebSocket handshake. A Host entry overrides the
+	// Host header, e.g. for domain fronting.
+	Header http.Header
+	// ProxyURL routes the dial through an http or socks5 proxy
+	ProxyURL *url.URL
+	// TCP sets socket options on the dialed connection (nil keeps the OS
+	// defaults)
+	TCP *sockopt.TCPConfig
+	// Fingerprint sends a browser's TLS ClientHello on wss:// dials instead
+	// of Go's (see Fingerprints; empty keeps Go's). It cannot be combined
+	// with ProxyURL.
+	Fingerprint string
+	// Faults injects faults into the messages sent, for tests (nil sends
+	// them faithfully)
+	Faults *FaultConfig
 }
 
 // DefaultConfig returns a Config with sensible defaults.
@@ -54,9 +79,22 @@ func DefaultConfig(url string) *Config {
 type Connection struct {
 	conn     *websocket.Conn
 	config   *Config
+	peerCN   string
 	mu       sync.Mutex
 	closed   bool
 	closedCh chan struct{}
+	// remoteAddr overrides the socket's address on accepted connections
+	remoteAddr string
+	// relayed marks connections another server relayed for a client
+	relayed bool
+	// queue serializes writes when a send queue is configured (nil
+	// writes directly)
+	queue *sendQueue
+	// onClose is called once when the connection is closed
+	onClose func()
+	// faults injects faults into written messages (nil writes them
+	// faithfully)
+	faults *faultInjector
 }
 
 // Dial creates a new WebSocket connection.
@@ -71,9 +109,28 @@ func Dial(ctx context.Context, config *Config) (*Connection, error) {
 	if config.WriteBufferSize > 0 {
 		dialer.WriteBufferSize = config.WriteBufferSize
 	}
+	if config.ProxyURL != nil {
+		dialer.Proxy = http.ProxyURL(config.ProxyURL)
+	}
+	if config.TCP != nil {
+		dialer.NetDialContext = config.TCP.Dialer(&net.Dialer{})
+	}
+	if config.Fingerprint != "" && strings.HasPrefix(config.URL, "wss://") {
+		// The dialer would run the handshake with the proxy instead of
+		// the server
+		if config.ProxyURL != nil {
+			return nil, fmt.Errorf("TLS fingerprints cannot be used with a proxy")
+		}
+		dialer.NetDialTLSContext = fingerprintDialer(config)
+	}
 
-	conn, _, err := dialer.DialContext(ctx, config.URL, http.Header{})
+	conn, resp, err := dialer.DialContext(ctx, config.URL, config.Header)
 	if err != nil {
+		// A failed upgrade is only reported as a bad handshake; the status
+		// tells a wrong path (404) from a proxy or CDN refusing it
+		if errors.Is(err, websocket.ErrBadHandshake) && resp != nil {
+			return nil, fmt.Errorf("%w: HTTP %s", err, resp.Status)
+		}
 		return nil, err
 	}
 
@@ -84,12 +141,30 @@ func Dial(ctx context.Context, config *Config) (*Connection, error) {
 		config:   config,
 		closedCh: make(chan struct{}),
 	}
+	if config.SendQueueSize > 0 {
+		c.startSendQueue(config.SendQueueSize, config.QueueObserver)
+	}
+	if config.Faults != nil {
+		c.faults = newFaultInjector(config.Faults, c.write)
+	}
 
 	return c, nil
 }
 
 // Write sends data over the connection.
 func (c *Connection) Write(data []byte) error {
+	if c.faults != nil {
+		return c.writeFaulty(data)
+	}
+	return c.write(data)
+}
+
+// write sends data through the send queue, or directly without one.
+func (c *Connection) write(data []byte) error {
+	if c.queue != nil {
+		return c.enqueue(data)
+	}
+
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
@@ -129,19 +204,27 @@ func (c *Connection) Read() ([]byte, error) {
 // Close closes the connection gracefully.
 func (c *Connection) Close() error {
 	c.mu.Lock()
-	defer c.mu.Unlock()
-
 	if c.closed {
+		c.mu.Unlock()
 		return nil
 	}
-
 	c.closed = true
 	close(c.closedCh)
+	c.mu.Unlock()
+
+	if c.onClose != nil {
+		c.onClose()
+	}
+	if c.queue != nil {
+		c.queue.close()
+	}
 
-	// Send close message (best effort, ignore errors)
-	_ = c.conn.WriteMessage(
+	// Send close message (best effort, ignore errors). WriteControl may
+	// run alongside a write in progress.
+	_ = c.conn.WriteControl(
 		websocket.CloseMessage,
 		websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
+		time.Now().Add(closeFlushTimeout),
 	)
 
 	return c.conn.Close()
@@ -159,14 +242,33 @@ func (c *Connection) ClosedChan() <-chan struct{} {
 	return c.closedCh
 }
 
-// RemoteAddr returns the remote address for the connection.
+// RemoteAddr returns the remote address for the connection. For relayed
+// connections it is the address of the client the relay serves.
 func (c *Connection) RemoteAddr() string {
 	if c == nil || c.conn == nil {
 		return ""
 	}
+	if c.remoteAddr != "" {
+		return c.remoteAddr
+	}
 	return c.conn.RemoteAddr().String()
 }
 
+// PeerCommonName returns the common name of the verified client certificate
+// presented on an accepted connection, or "" if none was presented.
+func (c *Connection) PeerCommonName() string {
+	if c == nil {
+		return ""
+	}
+	return c.peerCN
+}
+
+// Relayed reports whether another server relayed the connection on behalf
+// of a client (see WithRelayedClient).
+func (c *Connection) Relayed() bool {
+	return c != nil && c.relayed
+}
+
 // Transport defines the interface for split-path transports.
 type Transport interface {
 	// Write sends data through the transport.
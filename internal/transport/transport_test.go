@@ -0,0 +1,107 @@
+package transport
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestDialSendsHeaders(t *testing.T) {
+	received := make(chan *http.Request, 1)
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		conn.Close()
+	}))
+	defer server.Close()
+
+	config := DefaultConfig("ws" + strings.TrimPrefix(server.URL, "http"))
+	config.Header = http.Header{}
+	config.Header.Set("Host", "front.example.com")
+	config.Header.Set("User-Agent", "Mozilla/5.0")
+	config.Header.Set("X-Custom", "value")
+
+	conn, err := Dial(context.Background(), config)
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer conn.Close()
+
+	r := <-received
+	if r.Host != "front.example.com" {
+		t.Errorf("Expected Host front.example.com, got %s", r.Host)
+	}
+	if got := r.Header.Get("User-Agent"); got != "Mozilla/5.0" {
+		t.Errorf("Expected User-Agent Mozilla/5.0, got %s", got)
+	}
+	if got := r.Header.Get("X-Custom"); got != "value" {
+		t.Errorf("Expected X-Custom value, got %s", got)
+	}
+}
+
+func TestDialThroughHTTPProxy(t *testing.T) {
+	server := httptest.NewServer(NewServerHandler(nil, nil))
+	defer server.Close()
+
+	// Minimal CONNECT proxy that counts tunnelled connections
+	var connects int32
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect {
+			http.Error(w, "CONNECT only", http.StatusMethodNotAllowed)
+			return
+		}
+		atomic.AddInt32(&connects, 1)
+		target, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		hijacker, ok := w.(http.Hijacker)
+		if !ok {
+			target.Close()
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		client, _, err := hijacker.Hijack()
+		if err != nil {
+			target.Close()
+			return
+		}
+		go func() {
+			_, _ = io.Copy(target, client)
+			target.Close()
+		}()
+		_, _ = io.Copy(client, target)
+		client.Close()
+	}))
+	defer proxy.Close()
+
+	proxyURL, err := url.Parse(proxy.URL)
+	if err != nil {
+		t.Fatalf("Failed to parse proxy URL: %v", err)
+	}
+	config := DefaultConfig("ws" + strings.TrimPrefix(server.URL, "http"))
+	config.ProxyURL = proxyURL
+
+	conn, err := Dial(context.Background(), config)
+	if err != nil {
+		t.Fatalf("Failed to dial through proxy: %v", err)
+	}
+	conn.Close()
+
+	if atomic.LoadInt32(&connects) != 1 {
+		t.Errorf("Expected 1 CONNECT through the proxy, got %d", connects)
+	}
+}
@@ -0,0 +1,102 @@
+//go:build utls
+
+package transport
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+
+	utls "github.com/refraction-networking/utls"
+)
+
+// fingerprintSupported reports whether this build can mimic browser
+// fingerprints.
+const fingerprintSupported = true
+
+// helloIDs maps each fingerprint to the ClientHello of the browser's
+// current release.
+var helloIDs = map[string]utls.ClientHelloID{
+	FingerprintChrome:  utls.HelloChrome_Auto,
+	FingerprintFirefox: utls.HelloFirefox_Auto,
+	FingerprintSafari:  utls.HelloSafari_Auto,
+}
+
+// fingerprintDialer returns a dial function for the WebSocket dialer that
+// connects with the config's socket options and completes the TLS
+// handshake with the browser's ClientHello.
+func fingerprintDialer(config *Config) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		id, ok := helloIDs[config.Fingerprint]
+		if !ok {
+			return nil, fmt.Errorf("unknown TLS fingerprint %q", config.Fingerprint)
+		}
+
+		dial := (&net.Dialer{}).DialContext
+		if config.TCP != nil {
+			dial = config.TCP.Dialer(&net.Dialer{})
+		}
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+
+		tlsConn, err := handshakeUTLS(ctx, conn, config.TLSConfig, addr, id)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return tlsConn, nil
+	}
+}
+
+// handshakeUTLS runs the TLS handshake on conn with the ClientHello of id
+// and the verification settings of cfg.
+func handshakeUTLS(ctx context.Context, conn net.Conn, cfg *tls.Config, addr string, id utls.ClientHelloID) (net.Conn, error) {
+	if cfg == nil {
+		cfg = &tls.Config{}
+	}
+	serverName := cfg.ServerName
+	if serverName == "" {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		serverName = host
+	}
+
+	ucfg := &utls.Config{
+		ServerName:            serverName,
+		InsecureSkipVerify:    cfg.InsecureSkipVerify,
+		RootCAs:               cfg.RootCAs,
+		VerifyPeerCertificate: cfg.VerifyPeerCertificate,
+	}
+	for _, cert := range cfg.Certificates {
+		ucfg.Certificates = append(ucfg.Certificates, utls.Certificate{
+			Certificate: cert.Certificate,
+			PrivateKey:  cert.PrivateKey,
+			Leaf:        cert.Leaf,
+		})
+	}
+
+	spec, err := utls.UTLSIdToSpec(id)
+	if err != nil {
+		return nil, err
+	}
+	// Browsers offer HTTP/2, but the WebSocket upgrade needs HTTP/1.1
+	for _, ext := range spec.Extensions {
+		if alpn, ok := ext.(*utls.ALPNExtension); ok {
+			alpn.AlpnProtocols = []string{"http/1.1"}
+		}
+	}
+
+	uconn := utls.UClient(conn, ucfg, utls.HelloCustom)
+	if err := uconn.ApplyPreset(&spec); err != nil {
+		return nil, err
+	}
+	if err := uconn.HandshakeContext(ctx); err != nil {
+		return nil, fmt.Errorf("TLS handshake failed: %w", err)
+	}
+	return uconn, nil
+}
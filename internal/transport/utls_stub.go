@@ -0,0 +1,20 @@
+//go:build !utls
+
+package transport
+
+import (
+	"context"
+	"net"
+)
+
+// fingerprintSupported reports whether this build can mimic browser
+// fingerprints.
+const fingerprintSupported = false
+
+// fingerprintDialer returns a dial function that fails, since this build
+// has no uTLS.
+func fingerprintDialer(*Config) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(context.Context, string, string) (net.Conn, error) {
+		return nil, ErrFingerprintUnsupported
+	}
+}
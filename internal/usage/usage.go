@@ -0,0 +1,255 @@
+// Package usage persists cumulative traffic counters for the Half-Tunnel client.
+//
+// Counters are bucketed per local calendar day and per forward (a port forward
+// name or "socks5") and stored in a JSON state file, so totals survive restarts
+// and can be reported with "ht client usage".
+package usage
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sahmadiut/half-tunnel/internal/statefile"
+)
+
+// DateFormat is the layout used for day keys in the state file.
+const DateFormat = "2006-01-02"
+
+// DefaultRetention is how long daily buckets are kept.
+const DefaultRetention = 400 * 24 * time.Hour
+
+// Counters holds byte totals for one direction pair.
+type Counters struct {
+	Upload   int64 `json:"upload"`
+	Download int64 `json:"download"`
+}
+
+// Total returns upload plus download.
+func (c Counters) Total() int64 {
+	return c.Upload + c.Download
+}
+
+// Day holds the totals for one calendar day.
+type Day struct {
+	Counters
+	Forwards map[string]*Counters `json:"forwards,omitempty"`
+}
+
+// State is the persisted usage state.
+type State struct {
+	Version int             `json:"version"`
+	Days    map[string]*Day `json:"days"`
+}
+
+// ForwardTotal is the traffic attributed to one forward over a period.
+type ForwardTotal struct {
+	Name string
+	Counters
+}
+
+// DayTotal is the traffic for one day of a report.
+type DayTotal struct {
+	Date string
+	Counters
+}
+
+// Load reads a state file. A missing file yields an empty state.
+func Load(path string) (*State, error) {
+	state := &State{Version: 1, Days: make(map[string]*Day)}
+	if err := statefile.Load(path, "usage state", state); err != nil {
+		return nil, err
+	}
+	if state.Days == nil {
+		state.Days = make(map[string]*Day)
+	}
+	return state, nil
+}
+
+// DaysSince returns per-day totals from since (inclusive) onwards, oldest first.
+func (s *State) DaysSince(since time.Time) []DayTotal {
+	from := since.Format(DateFormat)
+
+	days := make([]DayTotal, 0, len(s.Days))
+	for date, day := range s.Days {
+		if date < from {
+			continue
+		}
+		days = append(days, DayTotal{Date: date, Counters: day.Counters})
+	}
+	sort.Slice(days, func(i, j int) bool { return days[i].Date < days[j].Date })
+	return days
+}
+
+// TopForwards returns the n forwards with the most traffic since the given
+// time, largest first. n <= 0 returns all forwards.
+func (s *State) TopForwards(since time.Time, n int) []ForwardTotal {
+	from := since.Format(DateFormat)
+
+	totals := make(map[string]*Counters)
+	for date, day := range s.Days {
+		if date < from {
+			continue
+		}
+		for name, c := range day.Forwards {
+			t, ok := totals[name]
+			if !ok {
+				t = &Counters{}
+				totals[name] = t
+			}
+			t.Upload += c.Upload
+			t.Download += c.Download
+		}
+	}
+
+	forwards := make([]ForwardTotal, 0, len(totals))
+	for name, c := range totals {
+		forwards = append(forwards, ForwardTotal{Name: name, Counters: *c})
+	}
+	sort.Slice(forwards, func(i, j int) bool {
+		if forwards[i].Total() != forwards[j].Total() {
+			return forwards[i].Total() > forwards[j].Total()
+		}
+		return forwards[i].Name < forwards[j].Name
+	})
+	if n > 0 && len(forwards) > n {
+		forwards = forwards[:n]
+	}
+	return forwards
+}
+
+// Recorder accumulates traffic and periodically writes it to a state file.
+type Recorder struct {
+	path      string
+	retention time.Duration
+	now       func() time.Time
+
+	state *State
+	dirty bool
+	mu    sync.Mutex
+
+	shutdown chan struct{}
+	wg       sync.WaitGroup
+}
+
+// NewRecorder creates a recorder backed by the state file at path, loading
+// any existing totals.
+func NewRecorder(path string) (*Recorder, error) {
+	state, err := Load(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Recorder{
+		path:      path,
+		retention: DefaultRetention,
+		now:       time.Now,
+		state:     state,
+		shutdown:  make(chan struct{}),
+	}, nil
+}
+
+// Add records traffic for a forward in today's bucket.
+func (r *Recorder) Add(forward string, upload, download int64) {
+	if upload == 0 && download == 0 {
+		return
+	}
+
+	date := r.now().Format(DateFormat)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	day, ok := r.state.Days[date]
+	if !ok {
+		day = &Day{Forwards: make(map[string]*Counters)}
+		r.state.Days[date] = day
+	}
+	if day.Forwards == nil {
+		day.Forwards = make(map[string]*Counters)
+	}
+	fwd, ok := day.Forwards[forward]
+	if !ok {
+		fwd = &Counters{}
+		day.Forwards[forward] = fwd
+	}
+
+	day.Upload += upload
+	day.Download += download
+	fwd.Upload += upload
+	fwd.Download += download
+	r.dirty = true
+}
+
+// Flush writes the state file if anything changed since the last flush.
+// A failed write leaves the totals pending for the next flush.
+func (r *Recorder) Flush() error {
+	r.mu.Lock()
+	if !r.dirty {
+		r.mu.Unlock()
+		return nil
+	}
+	r.prune()
+	data, err := json.MarshalIndent(r.state, "", "  ")
+	if err != nil {
+		r.mu.Unlock()
+		return fmt.Errorf("failed to encode usage state: %w", err)
+	}
+	r.dirty = false
+	r.mu.Unlock()
+
+	if err := statefile.Save(r.path, "usage state", data); err != nil {
+		r.mu.Lock()
+		r.dirty = true
+		r.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// Start flushes the state file every interval until Stop is called.
+// Flush errors are passed to onError if it is non-nil.
+func (r *Recorder) Start(interval time.Duration, onError func(error)) {
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-r.shutdown:
+				return
+			case <-ticker.C:
+				if err := r.Flush(); err != nil && onError != nil {
+					onError(err)
+				}
+			}
+		}
+	}()
+}
+
+// Stop stops periodic flushing and writes any pending totals.
+func (r *Recorder) Stop() error {
+	select {
+	case <-r.shutdown:
+	default:
+		close(r.shutdown)
+	}
+	r.wg.Wait()
+	return r.Flush()
+}
+
+// prune drops buckets older than the retention period.
+// Must be called with the lock held.
+func (r *Recorder) prune() {
+	cutoff := r.now().Add(-r.retention).Format(DateFormat)
+	for date := range r.state.Days {
+		if date < cutoff {
+			delete(r.state.Days, date)
+		}
+	}
+}
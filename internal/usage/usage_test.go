@@ -0,0 +1,142 @@
+package usage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRecorderPersistsAcrossRestarts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "usage.json")
+
+	r, err := NewRecorder(path)
+	if err != nil {
+		t.Fatalf("Failed to create recorder: %v", err)
+	}
+	r.Add("socks5", 100, 200)
+	r.Add("web", 10, 20)
+	if err := r.Stop(); err != nil {
+		t.Fatalf("Failed to stop recorder: %v", err)
+	}
+
+	// A new recorder picks up where the previous one left off
+	r, err = NewRecorder(path)
+	if err != nil {
+		t.Fatalf("Failed to reopen recorder: %v", err)
+	}
+	r.Add("socks5", 1, 2)
+	if err := r.Stop(); err != nil {
+		t.Fatalf("Failed to stop recorder: %v", err)
+	}
+
+	state, err := Load(path)
+	if err != nil {
+		t.Fatalf("Failed to load state: %v", err)
+	}
+	days := state.DaysSince(time.Now().AddDate(0, 0, -1))
+	if len(days) != 1 {
+		t.Fatalf("Expected 1 day, got %d", len(days))
+	}
+	if days[0].Upload != 111 || days[0].Download != 222 {
+		t.Errorf("Expected 111/222, got %d/%d", days[0].Upload, days[0].Download)
+	}
+}
+
+func TestLoadMissingFile(t *testing.T) {
+	state, err := Load(filepath.Join(t.TempDir(), "missing.json"))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(state.Days) != 0 {
+		t.Errorf("Expected empty state, got %d days", len(state.Days))
+	}
+}
+
+func TestReportSinceAndTopForwards(t *testing.T) {
+	r, err := NewRecorder(filepath.Join(t.TempDir(), "usage.json"))
+	if err != nil {
+		t.Fatalf("Failed to create recorder: %v", err)
+	}
+
+	base := time.Date(2024, 3, 10, 12, 0, 0, 0, time.Local)
+	for i, fwd := range []string{"web", "ssh", "web", "socks5"} {
+		day := base.AddDate(0, 0, i)
+		r.now = func() time.Time { return day }
+		r.Add(fwd, int64(100*(i+1)), int64(1000*(i+1)))
+	}
+
+	since := base.AddDate(0, 0, 1)
+	days := r.state.DaysSince(since)
+	if len(days) != 3 {
+		t.Fatalf("Expected 3 days, got %d", len(days))
+	}
+	if days[0].Date != "2024-03-11" || days[2].Date != "2024-03-13" {
+		t.Errorf("Expected days sorted oldest first, got %s..%s", days[0].Date, days[2].Date)
+	}
+
+	top := r.state.TopForwards(since, 2)
+	if len(top) != 2 {
+		t.Fatalf("Expected 2 forwards, got %d", len(top))
+	}
+	if top[0].Name != "socks5" || top[1].Name != "web" {
+		t.Errorf("Expected socks5 then web, got %s then %s", top[0].Name, top[1].Name)
+	}
+	if top[1].Download != 3000 {
+		t.Errorf("Expected web download 3000 since cutoff, got %d", top[1].Download)
+	}
+}
+
+func TestPruneOldDays(t *testing.T) {
+	r, err := NewRecorder(filepath.Join(t.TempDir(), "usage.json"))
+	if err != nil {
+		t.Fatalf("Failed to create recorder: %v", err)
+	}
+
+	now := time.Now()
+	r.now = func() time.Time { return now.Add(-2 * DefaultRetention) }
+	r.Add("web", 1, 1)
+	r.now = func() time.Time { return now }
+	r.Add("web", 1, 1)
+
+	if err := r.Flush(); err != nil {
+		t.Fatalf("Failed to flush: %v", err)
+	}
+	if len(r.state.Days) != 1 {
+		t.Errorf("Expected old day to be pruned, got %d days", len(r.state.Days))
+	}
+}
+
+func TestRecorderFlushRetriesFailedWrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "usage.json")
+	r, err := NewRecorder(path)
+	if err != nil {
+		t.Fatalf("Failed to create recorder: %v", err)
+	}
+	r.Add("web", 100, 200)
+
+	// A directory in the way makes the write fail
+	if err := os.Mkdir(path, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(path, "keep"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Flush(); err == nil {
+		t.Fatal("Expected flush to fail")
+	}
+
+	if err := os.RemoveAll(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Flush(); err != nil {
+		t.Fatalf("Failed to flush: %v", err)
+	}
+	state, err := Load(path)
+	if err != nil {
+		t.Fatalf("Failed to load: %v", err)
+	}
+	if got := state.TopForwards(time.Now().AddDate(0, 0, -1), 0); len(got) != 1 || got[0].Total() != 300 {
+		t.Errorf("Expected the totals of the failed flush written, got %+v", got)
+	}
+}
@@ -0,0 +1,205 @@
+package halftunnel
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/google/uuid"
+	"github.com/sahmadiut/half-tunnel/internal/client"
+	"github.com/sahmadiut/half-tunnel/pkg/logger"
+)
+
+// Client is an embedded Half-Tunnel client. It sends traffic from its local
+// SOCKS5 proxy and port forwards through the tunnel.
+type Client struct {
+	client *client.Client
+}
+
+// PortForward forwards connections to a local address through the tunnel
+// to a remote host.
+type PortForward struct {
+	// Name labels the forward in logs and metrics (optional)
+	Name string
+	// ListenAddr is the local host:port to accept connections on
+	ListenAddr string
+	// RemoteHost and RemotePort are dialed by the server
+	RemoteHost string
+	RemotePort int
+}
+
+// ClientHooks are called on client events. They run on the goroutine of the
+// event and must not block. Any of them may be nil.
+type ClientHooks struct {
+	// Connected is called when the tunnel is connected or reconnected
+	Connected func(sessionID string)
+	// Disconnected is called when the tunnel connection is lost; source
+	// tells what noticed it
+	Disconnected func(source string)
+	// StreamOpened is called when a stream to destAddr is opened
+	StreamOpened func(streamID uint32, destAddr string)
+	// StreamClosed is called when a stream is closed; closedBy is "local"
+	// or "peer"
+	StreamClosed func(streamID uint32, closedBy, reason string)
+}
+
+// ClientOption configures a Client.
+type ClientOption func(*clientOptions) error
+
+type clientOptions struct {
+	config *client.Config
+	log    *logger.Logger
+}
+
+// NewClient creates a client for a server's upstream and downstream
+// WebSocket URLs, e.g. "wss://example.com:8443/upstream". By default it runs
+// a SOCKS5 proxy on 127.0.0.1:1080 and reconnects when the tunnel drops.
+func NewClient(upstreamURL, downstreamURL string, opts ...ClientOption) (*Client, error) {
+	o := &clientOptions{config: client.DefaultConfig()}
+	o.config.UpstreamURL = upstreamURL
+	o.config.DownstreamURL = downstreamURL
+	for _, opt := range opts {
+		if err := opt(o); err != nil {
+			return nil, err
+		}
+	}
+	return &Client{client: client.New(o.config, o.log)}, nil
+}
+
+// Start connects to the server and starts the local listeners. If the first
+// connection fails and reconnects are enabled, the client keeps trying in
+// the background. Cancelling ctx stops the client.
+func (c *Client) Start(ctx context.Context) error {
+	return c.client.Start(ctx)
+}
+
+// Stop closes the tunnel, the local listeners and their connections.
+func (c *Client) Stop() error {
+	return c.client.Stop()
+}
+
+// Connected reports whether both tunnel connections are up.
+func (c *Client) Connected() bool {
+	return c.client.IsConnected()
+}
+
+// SessionID returns the ID of the client's current session.
+func (c *Client) SessionID() string {
+	return c.client.GetSessionID().String()
+}
+
+// DialContext opens a connection to address through the tunnel. It has the
+// signature of net.Dialer.DialContext, so it can be used as the dialer of an
+// http.Transport, for example. Only TCP is supported, and ctx bounds the
+// dial only. It returns once the server connected the destination, so an
+// unreachable destination is a dial error; with older servers it shows up
+// as a closed connection instead.
+func (c *Client) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	return c.client.DialContext(ctx, network, address)
+}
+
+// Dial is DialContext with a background context.
+func (c *Client) Dial(network, address string) (net.Conn, error) {
+	return c.DialContext(context.Background(), network, address)
+}
+
+// WithSOCKS5 runs the SOCKS5 proxy on addr (host:port).
+func WithSOCKS5(addr string) ClientOption {
+	return func(o *clientOptions) error {
+		o.config.SOCKS5Enabled = true
+		o.config.SOCKS5Addr = addr
+		return nil
+	}
+}
+
+// WithoutSOCKS5 disables the SOCKS5 proxy, e.g. for a client that only
+// forwards ports.
+func WithoutSOCKS5() ClientOption {
+	return func(o *clientOptions) error {
+		o.config.SOCKS5Enabled = false
+		return nil
+	}
+}
+
+// WithSOCKS5Auth requires SOCKS5 clients to authenticate with username and
+// password.
+func WithSOCKS5Auth(username, password string) ClientOption {
+	return func(o *clientOptions) error {
+		o.config.SOCKS5Username = username
+		o.config.SOCKS5Password = password
+		return nil
+	}
+}
+
+// WithPortForward adds a port forward.
+func WithPortForward(pf PortForward) ClientOption {
+	return func(o *clientOptions) error {
+		host, portStr, err := net.SplitHostPort(pf.ListenAddr)
+		if err != nil {
+			return fmt.Errorf("invalid port forward listen address %q: %w", pf.ListenAddr, err)
+		}
+		port, err := strconv.Atoi(portStr)
+		if err != nil {
+			return fmt.Errorf("invalid port forward listen port %q: %w", portStr, err)
+		}
+		o.config.PortForwards = append(o.config.PortForwards, client.PortForward{
+			Name:       pf.Name,
+			ListenHost: host,
+			ListenPort: port,
+			RemoteHost: pf.RemoteHost,
+			RemotePort: pf.RemotePort,
+		})
+		return nil
+	}
+}
+
+// WithClientTLS sets the TLS configuration of both wss:// connections.
+func WithClientTLS(config *tls.Config) ClientOption {
+	return func(o *clientOptions) error {
+		o.config.UpstreamTLS = config
+		o.config.DownstreamTLS = config
+		return nil
+	}
+}
+
+// WithAuthToken identifies the client to the server with token.
+func WithAuthToken(token string) ClientOption {
+	return func(o *clientOptions) error {
+		o.config.AuthToken = token
+		return nil
+	}
+}
+
+// WithReconnect enables or disables reconnecting when the tunnel drops.
+func WithReconnect(enabled bool) ClientOption {
+	return func(o *clientOptions) error {
+		o.config.ReconnectEnabled = enabled
+		return nil
+	}
+}
+
+// WithClientLogger sets the client's logger (the default logs to stdout).
+func WithClientLogger(log *logger.Logger) ClientOption {
+	return func(o *clientOptions) error {
+		o.log = log
+		return nil
+	}
+}
+
+// WithClientHooks sets the hooks called on client events.
+func WithClientHooks(hooks ClientHooks) ClientOption {
+	return func(o *clientOptions) error {
+		h := &client.Hooks{
+			Disconnected: hooks.Disconnected,
+			StreamOpened: hooks.StreamOpened,
+			StreamClosed: hooks.StreamClosed,
+		}
+		if hooks.Connected != nil {
+			h.Connected = func(id uuid.UUID) { hooks.Connected(id.String()) }
+		}
+		o.config.Hooks = h
+		return nil
+	}
+}
@@ -0,0 +1,33 @@
+// Package halftunnel embeds a Half-Tunnel client or server in another Go
+// program, such as a desktop GUI or another proxy.
+//
+// A server and a client on the same host:
+//
+//	srv, err := halftunnel.NewServer(":8080", ":8081")
+//	if err != nil {
+//		return err
+//	}
+//	if err := srv.Start(ctx); err != nil {
+//		return err
+//	}
+//	defer srv.Stop(context.Background())
+//
+//	cli, err := halftunnel.NewClient(
+//		"ws://127.0.0.1:8080/upstream",
+//		"ws://127.0.0.1:8081/downstream",
+//		halftunnel.WithSOCKS5("127.0.0.1:1080"),
+//		halftunnel.WithClientHooks(halftunnel.ClientHooks{
+//			Connected: func(sessionID string) { log.Println("connected", sessionID) },
+//		}),
+//	)
+//	if err != nil {
+//		return err
+//	}
+//	if err := cli.Start(ctx); err != nil {
+//		return err
+//	}
+//	defer cli.Stop()
+//
+// The options cover what embedding programs commonly need; the command-line
+// client and server expose every setting through their configuration files.
+package halftunnel
@@ -0,0 +1,201 @@
+package halftunnel
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+// events records the hooks called, in order.
+type events struct {
+	mu   sync.Mutex
+	seen []string
+}
+
+func (e *events) add(name string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.seen = append(e.seen, name)
+}
+
+func (e *events) has(name string) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, s := range e.seen {
+		if s == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *events) wait(t *testing.T, names ...string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for _, name := range names {
+		for !e.has(name) {
+			if time.Now().After(deadline) {
+				t.Fatalf("Expected the %s hook called", name)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
+
+func startEcho(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to create echo listener: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func(c net.Conn) {
+				defer c.Close()
+				_, _ = io.Copy(c, c)
+			}(conn)
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to find a free port: %v", err)
+	}
+	defer ln.Close()
+	return ln.Addr().String()
+}
+
+func TestEmbeddedClientAndServer(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping embedding test in short mode")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	echoAddr := startEcho(t)
+	upstreamAddr, downstreamAddr := freeAddr(t), freeAddr(t)
+	socksAddr := freeAddr(t)
+
+	var serverEvents, clientEvents events
+	srv, err := NewServer(upstreamAddr, downstreamAddr, WithServerHooks(ServerHooks{
+		SessionOpened: func(string) { serverEvents.add("SessionOpened") },
+		StreamOpened:  func(string, uint32, string) { serverEvents.add("StreamOpened") },
+		StreamClosed:  func(string, uint32, string, string) { serverEvents.add("StreamClosed") },
+	}))
+	if err != nil {
+		t.Fatalf("NewServer failed: %v", err)
+	}
+	if err := srv.Start(ctx); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer srv.Stop(context.Background())
+
+	cli, err := NewClient(
+		"ws://"+upstreamAddr+"/upstream",
+		"ws://"+downstreamAddr+"/downstream",
+		WithSOCKS5(socksAddr),
+		WithReconnect(false),
+		WithClientHooks(ClientHooks{
+			Connected:    func(string) { clientEvents.add("Connected") },
+			StreamOpened: func(_ uint32, destAddr string) { clientEvents.add("StreamOpened " + destAddr) },
+			StreamClosed: func(uint32, string, string) { clientEvents.add("StreamClosed") },
+		}),
+	)
+	if err != nil {
+		t.Fatalf("NewClient failed: %v", err)
+	}
+	if err := cli.Start(ctx); err != nil {
+		t.Fatalf("Failed to start client: %v", err)
+	}
+	defer cli.Stop()
+
+	clientEvents.wait(t, "Connected")
+	serverEvents.wait(t, "SessionOpened")
+	if !cli.Connected() {
+		t.Error("Expected the client connected")
+	}
+	if srv.Sessions() != 1 {
+		t.Errorf("Expected 1 session, got %d", srv.Sessions())
+	}
+
+	dialer, err := proxy.SOCKS5("tcp", socksAddr, nil, proxy.Direct)
+	if err != nil {
+		t.Fatalf("Failed to create SOCKS5 dialer: %v", err)
+	}
+	conn, err := dialer.Dial("tcp", echoAddr)
+	if err != nil {
+		t.Fatalf("Failed to dial through the tunnel: %v", err)
+	}
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Write([]byte("hello")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if string(buf) != "hello" {
+		t.Errorf("Expected %q echoed, got %q", "hello", buf)
+	}
+	conn.Close()
+
+	conn, err = cli.DialContext(ctx, "tcp", echoAddr)
+	if err != nil {
+		t.Fatalf("DialContext failed: %v", err)
+	}
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Write([]byte("again")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if string(buf) != "again" {
+		t.Errorf("Expected %q echoed, got %q", "again", buf)
+	}
+	conn.Close()
+
+	clientEvents.wait(t, "StreamOpened "+echoAddr, "StreamClosed")
+	serverEvents.wait(t, "StreamOpened", "StreamClosed")
+
+	err = srv.HandleHTTP("internal.service:80", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		stream, _ := StreamFromContext(r.Context())
+		_, _ = io.WriteString(w, stream.SessionID)
+	}))
+	if err != nil {
+		t.Fatalf("HandleHTTP failed: %v", err)
+	}
+	httpClient := &http.Client{Transport: &http.Transport{DialContext: cli.DialContext}, Timeout: 5 * time.Second}
+	resp, err := httpClient.Get("http://internal.service/")
+	if err != nil {
+		t.Fatalf("Request to the handled destination failed: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != cli.SessionID() {
+		t.Errorf("Expected the client's session ID %q, got %q", cli.SessionID(), body)
+	}
+}
+
+func TestWithPortForwardInvalidAddress(t *testing.T) {
+	_, err := NewClient("ws://127.0.0.1:1/upstream", "ws://127.0.0.1:1/downstream",
+		WithPortForward(PortForward{ListenAddr: "no-port", RemoteHost: "example.com", RemotePort: 80}))
+	if err == nil {
+		t.Error("Expected an error for a listen address without a port")
+	}
+}
@@ -0,0 +1,190 @@
+package halftunnel
+
+import (
+	"context"
+	"net"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/sahmadiut/half-tunnel/internal/server"
+	"github.com/sahmadiut/half-tunnel/pkg/logger"
+)
+
+// Server is an embedded Half-Tunnel server. It accepts clients' upstream and
+// downstream connections and dials their destinations.
+type Server struct {
+	server *server.Server
+}
+
+// ServerHooks are called on server events. They run on the goroutine of the
+// event and must not block. Any of them may be nil.
+type ServerHooks struct {
+	// SessionOpened is called when a client session is created
+	SessionOpened func(sessionID string)
+	// SessionClosed is called when a client session is removed; sessions
+	// outlive their connections until they expire or are closed
+	SessionClosed func(sessionID string)
+	// StreamOpened is called when a client opens a stream to destAddr
+	StreamOpened func(sessionID string, streamID uint32, destAddr string)
+	// StreamClosed is called when a stream is closed; closedBy is "local"
+	// or "peer"
+	StreamClosed func(sessionID string, streamID uint32, closedBy, reason string)
+}
+
+// ServerOption configures a Server.
+type ServerOption func(*serverOptions) error
+
+type serverOptions struct {
+	config *server.Config
+	log    *logger.Logger
+}
+
+// NewServer creates a server listening for upstream connections on
+// upstreamAddr and downstream connections on downstreamAddr (host:port; the
+// two may be the same). The WebSocket paths default to /upstream and
+// /downstream.
+func NewServer(upstreamAddr, downstreamAddr string, opts ...ServerOption) (*Server, error) {
+	o := &serverOptions{config: server.DefaultConfig()}
+	o.config.UpstreamAddr = upstreamAddr
+	o.config.DownstreamAddr = downstreamAddr
+	for _, opt := range opts {
+		if err := opt(o); err != nil {
+			return nil, err
+		}
+	}
+	return &Server{server: server.New(o.config, o.log)}, nil
+}
+
+// Start starts the listeners. It returns once they are listening.
+func (s *Server) Start(ctx context.Context) error {
+	return s.server.Start(ctx)
+}
+
+// Stop closes the listeners and the sessions, waiting at most until ctx is
+// done.
+func (s *Server) Stop(ctx context.Context) error {
+	return s.server.Stop(ctx)
+}
+
+// Sessions returns the number of client sessions.
+func (s *Server) Sessions() int {
+	return s.server.GetSessionCount()
+}
+
+// Streams returns the number of open streams.
+func (s *Server) Streams() int {
+	return s.server.GetNatEntryCount()
+}
+
+// StreamInfo describes a stream served by a handler registered with Handle
+// or HandleHTTP.
+type StreamInfo struct {
+	SessionID string
+	StreamID  uint32
+	// DestAddr is the host:port the client asked for
+	DestAddr string
+	// Identity is the client identity of the session, if known
+	Identity string
+}
+
+func streamInfo(stream server.StreamInfo) StreamInfo {
+	return StreamInfo{
+		SessionID: stream.SessionID.String(),
+		StreamID:  stream.StreamID,
+		DestAddr:  stream.DestAddr,
+		Identity:  stream.Identity,
+	}
+}
+
+// Handle serves streams to addr (host:port) in this process with handler
+// instead of dialing addr, turning the server into an application gateway;
+// a nil handler removes the handler of addr. conn carries the stream's data
+// and is closed when handler returns. The destination policy still decides
+// who may open streams to addr.
+func (s *Server) Handle(addr string, handler func(conn net.Conn, stream StreamInfo)) error {
+	if handler == nil {
+		return s.server.Handle(addr, nil)
+	}
+	return s.server.Handle(addr, func(conn net.Conn, stream server.StreamInfo) {
+		handler(conn, streamInfo(stream))
+	})
+}
+
+// HandleHTTP serves the HTTP requests of streams to addr (host:port) with h,
+// e.g. HandleHTTP("internal.service:80", mux). h can get the stream of a
+// request with StreamFromContext.
+func (s *Server) HandleHTTP(addr string, h http.Handler) error {
+	return s.server.Handle(addr, server.HTTPStreamHandler(h))
+}
+
+// StreamFromContext returns the stream of a request served by a handler
+// registered with HandleHTTP.
+func StreamFromContext(ctx context.Context) (StreamInfo, bool) {
+	stream, ok := server.StreamInfoFromContext(ctx)
+	if !ok {
+		return StreamInfo{}, false
+	}
+	return streamInfo(stream), true
+}
+
+// WithPaths sets the WebSocket paths of the upstream and downstream
+// connections.
+func WithPaths(upstream, downstream string) ServerOption {
+	return func(o *serverOptions) error {
+		o.config.UpstreamPath = upstream
+		o.config.DownstreamPath = downstream
+		return nil
+	}
+}
+
+// WithServerTLS serves both connections over TLS with the certificate and
+// key in certFile and keyFile.
+func WithServerTLS(certFile, keyFile string) ServerOption {
+	return func(o *serverOptions) error {
+		tls := server.TLSConfig{Enabled: true, CertFile: certFile, KeyFile: keyFile}
+		o.config.UpstreamTLS = tls
+		o.config.DownstreamTLS = tls
+		return nil
+	}
+}
+
+// WithMaxSessions caps the number of client sessions.
+func WithMaxSessions(n int) ServerOption {
+	return func(o *serverOptions) error {
+		o.config.MaxSessions = n
+		return nil
+	}
+}
+
+// WithServerLogger sets the server's logger (the default logs to stdout).
+func WithServerLogger(log *logger.Logger) ServerOption {
+	return func(o *serverOptions) error {
+		o.log = log
+		return nil
+	}
+}
+
+// WithServerHooks sets the hooks called on server events.
+func WithServerHooks(hooks ServerHooks) ServerOption {
+	return func(o *serverOptions) error {
+		h := &server.Hooks{}
+		if hooks.SessionOpened != nil {
+			h.SessionOpened = func(id uuid.UUID) { hooks.SessionOpened(id.String()) }
+		}
+		if hooks.SessionClosed != nil {
+			h.SessionClosed = func(id uuid.UUID) { hooks.SessionClosed(id.String()) }
+		}
+		if hooks.StreamOpened != nil {
+			h.StreamOpened = func(id uuid.UUID, streamID uint32, destAddr string) {
+				hooks.StreamOpened(id.String(), streamID, destAddr)
+			}
+		}
+		if hooks.StreamClosed != nil {
+			h.StreamClosed = func(id uuid.UUID, streamID uint32, closedBy, reason string) {
+				hooks.StreamClosed(id.String(), streamID, closedBy, reason)
+			}
+		}
+		o.config.Hooks = h
+		return nil
+	}
+}
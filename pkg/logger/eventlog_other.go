@@ -0,0 +1,14 @@
+//go:build !windows
+
+package logger
+
+import (
+	"errors"
+
+	"github.com/rs/zerolog"
+)
+
+// newEventLogWriter fails: the event log exists only on Windows.
+func newEventLogWriter(source string) (zerolog.LevelWriter, error) {
+	return nil, errors.New("the event log is only available on Windows")
+}
@@ -0,0 +1,47 @@
+//go:build windows
+
+package logger
+
+import (
+	"github.com/rs/zerolog"
+	"golang.org/x/sys/windows/svc/eventlog"
+)
+
+// eventLogID is the event ID of log entries. IDs from 1 to 1000 display
+// without a message file when the source is registered with EventCreate.
+const eventLogID = 1
+
+// eventLogWriter sends log entries to the Windows Application event log with
+// a type matching their level.
+type eventLogWriter struct {
+	l *eventlog.Log
+}
+
+// newEventLogWriter opens the event log for source.
+func newEventLogWriter(source string) (zerolog.LevelWriter, error) {
+	l, err := eventlog.Open(source)
+	if err != nil {
+		return nil, err
+	}
+	return &eventLogWriter{l: l}, nil
+}
+
+func (e *eventLogWriter) Write(p []byte) (int, error) {
+	return e.WriteLevel(zerolog.InfoLevel, p)
+}
+
+func (e *eventLogWriter) WriteLevel(level zerolog.Level, p []byte) (int, error) {
+	var err error
+	switch level {
+	case zerolog.WarnLevel:
+		err = e.l.Warning(eventLogID, string(p))
+	case zerolog.ErrorLevel, zerolog.FatalLevel, zerolog.PanicLevel:
+		err = e.l.Error(eventLogID, string(p))
+	default:
+		err = e.l.Info(eventLogID, string(p))
+	}
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,76 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"strconv"
+
+	"github.com/rs/zerolog"
+)
+
+// journaldSocket is where systemd-journald accepts native protocol messages.
+const journaldSocket = "/run/systemd/journal/socket"
+
+// journaldWriter sends log entries to systemd-journald using its native
+// protocol, with a priority matching their level.
+type journaldWriter struct {
+	conn net.Conn
+	tag  string
+}
+
+// newJournaldWriter connects to the journald socket.
+func newJournaldWriter(tag string) (zerolog.LevelWriter, error) {
+	conn, err := net.Dial("unixgram", journaldSocket)
+	if err != nil {
+		return nil, err
+	}
+	return &journaldWriter{conn: conn, tag: tag}, nil
+}
+
+func (j *journaldWriter) Write(p []byte) (int, error) {
+	return j.WriteLevel(zerolog.NoLevel, p)
+}
+
+func (j *journaldWriter) WriteLevel(level zerolog.Level, p []byte) (int, error) {
+	var msg bytes.Buffer
+	writeJournalField(&msg, "PRIORITY", []byte(strconv.Itoa(journalPriority(level))))
+	writeJournalField(&msg, "SYSLOG_IDENTIFIER", []byte(j.tag))
+	writeJournalField(&msg, "MESSAGE", bytes.TrimRight(p, "\n"))
+	if _, err := j.conn.Write(msg.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// writeJournalField appends a field in the journal export format. Values
+// containing a newline use the length-prefixed binary form.
+func writeJournalField(buf *bytes.Buffer, name string, value []byte) {
+	buf.WriteString(name)
+	if bytes.IndexByte(value, '\n') < 0 {
+		buf.WriteByte('=')
+		buf.Write(value)
+		buf.WriteByte('\n')
+		return
+	}
+	buf.WriteByte('\n')
+	_ = binary.Write(buf, binary.LittleEndian, uint64(len(value)))
+	buf.Write(value)
+	buf.WriteByte('\n')
+}
+
+// journalPriority maps a level to a syslog priority.
+func journalPriority(level zerolog.Level) int {
+	switch level {
+	case zerolog.TraceLevel, zerolog.DebugLevel:
+		return 7
+	case zerolog.WarnLevel:
+		return 4
+	case zerolog.ErrorLevel:
+		return 3
+	case zerolog.FatalLevel, zerolog.PanicLevel:
+		return 2
+	default:
+		return 6
+	}
+}
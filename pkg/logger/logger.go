@@ -2,41 +2,133 @@
 package logger
 
 import (
+	"fmt"
 	"io"
 	"os"
+	"sort"
+	"sync/atomic"
 	"time"
 
 	"github.com/rs/zerolog"
 )
 
+// Special values of Config.Output.
+const (
+	OutputStdout   = "stdout"
+	OutputStderr   = "stderr"
+	OutputSyslog   = "syslog"
+	OutputJournald = "journald"
+	OutputEventLog = "eventlog"
+)
+
+// DefaultTag identifies log entries sent to syslog, journald or the event
+// log.
+const DefaultTag = "half-tunnel"
+
 // Logger wraps zerolog.Logger for structured logging.
 type Logger struct {
 	zl zerolog.Logger
+	// component selects the level set for it with Config.Components or
+	// SetComponentLevel (empty uses the default level)
+	component string
 }
 
 // Config holds logger configuration.
 type Config struct {
 	// Level sets the minimum log level: debug, info, warn, error
 	Level string
+	// Components override Level for loggers created with Component, e.g.
+	// {"transport": "debug"}
+	Components map[string]string
 	// Format sets the output format: json, console
 	Format string
-	// Output sets the output destination (file path or empty for stdout)
+	// Output sets the output destination: a file path, "stdout" (or empty),
+	// "stderr", "syslog", "journald" or "eventlog" (Windows)
 	Output string
+	// Tag identifies entries sent to syslog or journald, and is the event
+	// log source (default "half-tunnel")
+	Tag string
+	// Rotation rotates the output file (ignored for other outputs)
+	Rotation RotateConfig
 	// Fields are additional fields to add to all log entries
 	Fields map[string]interface{}
 }
 
-// New creates a new logger with the given configuration.
-func New(cfg Config) (*Logger, error) {
-	var output io.Writer = os.Stdout
+// levels holds the default minimum level and per-component overrides.
+// Loggers check it for every entry, so levels can change at runtime.
+type levels struct {
+	defaultLevel zerolog.Level
+	components   map[string]zerolog.Level
+}
 
-	// Set up output
-	if cfg.Output != "" {
-		file, err := os.OpenFile(cfg.Output, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+var currentLevels atomic.Pointer[levels]
+
+func init() {
+	currentLevels.Store(&levels{defaultLevel: zerolog.GlobalLevel()})
+}
+
+// level returns the minimum level for component.
+func (lv *levels) level(component string) zerolog.Level {
+	if level, ok := lv.components[component]; ok {
+		return level
+	}
+	return lv.defaultLevel
+}
+
+// storeLevels makes lv current. zerolog's global level is set to the lowest
+// level in use so it does not filter entries a component wants.
+func storeLevels(lv *levels) {
+	lowest := lv.defaultLevel
+	for _, level := range lv.components {
+		if level < lowest {
+			lowest = level
+		}
+	}
+	currentLevels.Store(lv)
+	zerolog.SetGlobalLevel(lowest)
+}
+
+// openOutput returns the writer for output.
+func openOutput(cfg Config) (io.Writer, error) {
+	tag := cfg.Tag
+	if tag == "" {
+		tag = DefaultTag
+	}
+	switch cfg.Output {
+	case "", OutputStdout:
+		return os.Stdout, nil
+	case OutputStderr:
+		return os.Stderr, nil
+	case OutputSyslog:
+		w, err := newSyslogWriter(tag)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to syslog: %w", err)
+		}
+		return w, nil
+	case OutputJournald:
+		w, err := newJournaldWriter(tag)
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("failed to connect to journald: %w", err)
 		}
-		output = file
+		return w, nil
+	case OutputEventLog:
+		w, err := newEventLogWriter(tag)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open the event log: %w", err)
+		}
+		return w, nil
+	}
+	if cfg.Rotation.Enabled() {
+		return OpenRotatingFile(cfg.Output, cfg.Rotation)
+	}
+	return os.OpenFile(cfg.Output, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+}
+
+// New creates a new logger with the given configuration.
+func New(cfg Config) (*Logger, error) {
+	output, err := openOutput(cfg)
+	if err != nil {
+		return nil, err
 	}
 
 	// Set up format
@@ -47,9 +139,15 @@ func New(cfg Config) (*Logger, error) {
 		}
 	}
 
-	// Set up level
-	level := parseLevel(cfg.Level)
-	zerolog.SetGlobalLevel(level)
+	// Set up levels
+	lv := &levels{
+		defaultLevel: parseLevel(cfg.Level),
+		components:   make(map[string]zerolog.Level, len(cfg.Components)),
+	}
+	for component, level := range cfg.Components {
+		lv.components[component] = parseLevel(level)
+	}
+	storeLevels(lv)
 
 	// Create logger
 	zl := zerolog.New(output).With().Timestamp().Logger()
@@ -92,23 +190,109 @@ func parseLevel(level string) zerolog.Level {
 	}
 }
 
+// ValidateLevel checks that level is debug, info, warn or error.
+func ValidateLevel(level string) error {
+	switch level {
+	case "debug", "info", "warn", "error":
+		return nil
+	default:
+		return fmt.Errorf("invalid log level: %s (use debug, info, warn or error)", level)
+	}
+}
+
+// SetLevel changes the default minimum level at runtime. Components with
+// their own level keep it.
+func SetLevel(level string) error {
+	if err := ValidateLevel(level); err != nil {
+		return err
+	}
+	current := currentLevels.Load()
+	storeLevels(&levels{defaultLevel: parseLevel(level), components: current.components})
+	return nil
+}
+
+// SetComponentLevel changes the minimum level of a component at runtime.
+// The level "default" makes the component use the default level again.
+func SetComponentLevel(component, level string) error {
+	if component == "" {
+		return fmt.Errorf("component name required")
+	}
+	if level != "default" {
+		if err := ValidateLevel(level); err != nil {
+			return err
+		}
+	}
+	current := currentLevels.Load()
+	components := make(map[string]zerolog.Level, len(current.components)+1)
+	for name, l := range current.components {
+		components[name] = l
+	}
+	if level == "default" {
+		delete(components, component)
+	} else {
+		components[component] = parseLevel(level)
+	}
+	storeLevels(&levels{defaultLevel: current.defaultLevel, components: components})
+	return nil
+}
+
+// Level returns the current default minimum log level.
+func Level() string {
+	return currentLevels.Load().defaultLevel.String()
+}
+
+// ComponentLevels returns the components with their own level, as
+// "component=level", sorted by component.
+func ComponentLevels() []string {
+	current := currentLevels.Load()
+	var result []string
+	for component, level := range current.components {
+		result = append(result, component+"="+level.String())
+	}
+	sort.Strings(result)
+	return result
+}
+
+// enabled reports whether entries at level are logged by l.
+func (l *Logger) enabled(level zerolog.Level) bool {
+	return level >= currentLevels.Load().level(l.component)
+}
+
+// Component returns a logger for a named part of the system. Its entries
+// carry a "component" field and use the component's level if one is set.
+func (l *Logger) Component(name string) *Logger {
+	return &Logger{zl: l.zl.With().Str("component", name).Logger(), component: name}
+}
+
 // Debug logs a debug message.
 func (l *Logger) Debug() *zerolog.Event {
+	if !l.enabled(zerolog.DebugLevel) {
+		return nil
+	}
 	return l.zl.Debug()
 }
 
 // Info logs an info message.
 func (l *Logger) Info() *zerolog.Event {
+	if !l.enabled(zerolog.InfoLevel) {
+		return nil
+	}
 	return l.zl.Info()
 }
 
 // Warn logs a warning message.
 func (l *Logger) Warn() *zerolog.Event {
+	if !l.enabled(zerolog.WarnLevel) {
+		return nil
+	}
 	return l.zl.Warn()
 }
 
 // Error logs an error message.
 func (l *Logger) Error() *zerolog.Event {
+	if !l.enabled(zerolog.ErrorLevel) {
+		return nil
+	}
 	return l.zl.Error()
 }
 
@@ -119,17 +303,17 @@ func (l *Logger) Fatal() *zerolog.Event {
 
 // With returns a new logger with the given key-value pair added.
 func (l *Logger) With(key string, value interface{}) *Logger {
-	return &Logger{zl: l.zl.With().Interface(key, value).Logger()}
+	return &Logger{zl: l.zl.With().Interface(key, value).Logger(), component: l.component}
 }
 
 // WithStr returns a new logger with the given string key-value pair added.
 func (l *Logger) WithStr(key, value string) *Logger {
-	return &Logger{zl: l.zl.With().Str(key, value).Logger()}
+	return &Logger{zl: l.zl.With().Str(key, value).Logger(), component: l.component}
 }
 
 // WithError returns a new logger with the given error added.
 func (l *Logger) WithError(err error) *Logger {
-	return &Logger{zl: l.zl.With().Err(err).Logger()}
+	return &Logger{zl: l.zl.With().Err(err).Logger(), component: l.component}
 }
 
 // WithFields returns a new logger with the given fields added.
@@ -138,15 +322,15 @@ func (l *Logger) WithFields(fields map[string]interface{}) *Logger {
 	for k, v := range fields {
 		ctx = ctx.Interface(k, v)
 	}
-	return &Logger{zl: ctx.Logger()}
+	return &Logger{zl: ctx.Logger(), component: l.component}
 }
 
 // WithDuration returns a new logger with the given duration added.
 func (l *Logger) WithDuration(key string, d time.Duration) *Logger {
-	return &Logger{zl: l.zl.With().Dur(key, d).Logger()}
+	return &Logger{zl: l.zl.With().Dur(key, d).Logger(), component: l.component}
 }
 
 // WithBytes returns a new logger with the given byte count added.
 func (l *Logger) WithBytes(key string, b int64) *Logger {
-	return &Logger{zl: l.zl.With().Int64(key, b).Logger()}
+	return &Logger{zl: l.zl.With().Int64(key, b).Logger(), component: l.component}
 }
@@ -96,3 +96,69 @@ func TestWithFields(t *testing.T) {
 		t.Errorf("Expected field2 to be 42, got %v", result["field2"])
 	}
 }
+
+func TestSetLevel(t *testing.T) {
+	defer func() { _ = SetLevel("info") }()
+
+	if err := SetLevel("warn"); err != nil {
+		t.Fatalf("Failed to set level: %v", err)
+	}
+	if Level() != "warn" {
+		t.Errorf("Expected level warn, got %s", Level())
+	}
+	if err := SetLevel("verbose"); err == nil {
+		t.Error("Expected error for invalid level")
+	}
+	if Level() != "warn" {
+		t.Errorf("Expected level to stay warn, got %s", Level())
+	}
+}
+
+func TestComponentLevels(t *testing.T) {
+	var buf bytes.Buffer
+	log, err := New(Config{
+		Level:      "info",
+		Format:     "json",
+		Components: map[string]string{"transport": "debug", "mux": "error"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create logger: %v", err)
+	}
+	defer func() {
+		_ = SetLevel("info")
+		_ = SetComponentLevel("transport", "default")
+		_ = SetComponentLevel("mux", "default")
+	}()
+	log.zl = log.zl.Output(&buf)
+
+	log.Debug().Msg("root debug")
+	log.Component("transport").WithStr("direction", "upstream").Debug().Msg("transport debug")
+	log.Component("mux").Warn().Msg("mux warn")
+	if lines := bytes.Count(buf.Bytes(), []byte("\n")); lines != 1 {
+		t.Fatalf("Expected only the transport entry, got %q", buf.String())
+	}
+	var result map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &result); err != nil {
+		t.Fatalf("Failed to parse JSON: %v", err)
+	}
+	if result["component"] != "transport" || result["message"] != "transport debug" {
+		t.Errorf("Expected the transport entry with its component, got %v", result)
+	}
+
+	// Runtime changes apply to existing loggers
+	buf.Reset()
+	mux := log.Component("mux")
+	if err := SetComponentLevel("mux", "default"); err != nil {
+		t.Fatalf("SetComponentLevel failed: %v", err)
+	}
+	mux.Warn().Msg("mux warn")
+	if buf.Len() == 0 {
+		t.Error("Expected mux to use the default level after reset")
+	}
+	if got := ComponentLevels(); len(got) != 1 || got[0] != "transport=debug" {
+		t.Errorf("Expected only transport to have its own level, got %v", got)
+	}
+	if err := SetComponentLevel("mux", "loud"); err == nil {
+		t.Error("Expected an invalid level to be rejected")
+	}
+}
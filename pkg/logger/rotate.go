@@ -0,0 +1,268 @@
+package logger
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// backupTimeFormat is the timestamp in rotated file names.
+const backupTimeFormat = "2006-01-02T15-04-05.000"
+
+// RotateConfig controls rotation of a log file. Zero values disable the
+// corresponding limit.
+type RotateConfig struct {
+	// MaxSize rotates the file before a write would take it past this many
+	// bytes
+	MaxSize int64
+	// Interval rotates the file when a write falls in a later interval than
+	// the file was started in; intervals are aligned to UTC, so 24h rotates
+	// at midnight UTC
+	Interval time.Duration
+	// MaxBackups is the number of rotated files to keep
+	MaxBackups int
+	// MaxAge removes rotated files older than this
+	MaxAge time.Duration
+	// Compress gzips rotated files
+	Compress bool
+}
+
+// Enabled reports whether the file is rotated at all.
+func (c RotateConfig) Enabled() bool {
+	return c.MaxSize > 0 || c.Interval > 0
+}
+
+// RotatingFile is an io.Writer appending to a log file that is rotated by
+// size or time. Rotated files are renamed with a timestamp, e.g.
+// server-2024-03-10T12-00-00.000.log, optionally compressed and pruned in the
+// background.
+type RotatingFile struct {
+	path   string
+	config RotateConfig
+	now    func() time.Time
+
+	mu      sync.Mutex
+	file    *os.File
+	size    int64
+	started time.Time
+
+	millMu sync.Mutex
+	wg     sync.WaitGroup
+}
+
+// OpenRotatingFile opens the log file at path for appending, creating it and
+// its directory if needed.
+func OpenRotatingFile(path string, config RotateConfig) (*RotatingFile, error) {
+	r := &RotatingFile{path: path, config: config, now: time.Now}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// open opens the current file. An existing file keeps its modification time
+// as its start, so a restart does not postpone time-based rotation.
+// Must be called with the lock held.
+func (r *RotatingFile) open() error {
+	if err := os.MkdirAll(filepath.Dir(r.path), 0755); err != nil {
+		return fmt.Errorf("failed to create log directory: %w", err)
+	}
+	file, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	r.file = file
+	r.size = info.Size()
+	r.started = r.now()
+	if r.size > 0 {
+		r.started = info.ModTime()
+	}
+	return nil
+}
+
+// Write appends p to the file, rotating it first if needed.
+func (r *RotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.file == nil {
+		if err := r.open(); err != nil {
+			return 0, err
+		}
+	}
+	if r.shouldRotate(int64(len(p))) {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// shouldRotate reports whether writing n more bytes needs a new file.
+// Must be called with the lock held.
+func (r *RotatingFile) shouldRotate(n int64) bool {
+	if r.size == 0 {
+		return false
+	}
+	if r.config.MaxSize > 0 && r.size+n > r.config.MaxSize {
+		return true
+	}
+	if r.config.Interval > 0 {
+		return r.now().Truncate(r.config.Interval).After(r.started.Truncate(r.config.Interval))
+	}
+	return false
+}
+
+// Rotate starts a new file now.
+func (r *RotatingFile) Rotate() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.rotate()
+}
+
+// rotate renames the current file to a backup and opens a new one.
+// Must be called with the lock held.
+func (r *RotatingFile) rotate() error {
+	if r.file != nil {
+		if err := r.file.Close(); err != nil {
+			return err
+		}
+		r.file = nil
+	}
+
+	now := r.now()
+	backup := r.backupName(now)
+	if err := os.Rename(r.path, backup); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to rotate log file: %w", err)
+	}
+	if err := r.open(); err != nil {
+		return err
+	}
+
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		r.mill(backup, now)
+	}()
+	return nil
+}
+
+// backupName returns the name of a file rotated at t.
+func (r *RotatingFile) backupName(t time.Time) string {
+	dir, name := filepath.Split(r.path)
+	ext := filepath.Ext(name)
+	return filepath.Join(dir, strings.TrimSuffix(name, ext)+"-"+t.UTC().Format(backupTimeFormat)+ext)
+}
+
+// mill compresses a file rotated at now and removes backups beyond
+// MaxBackups or older than MaxAge. Errors are ignored: logging must not fail
+// because housekeeping did.
+func (r *RotatingFile) mill(backup string, now time.Time) {
+	r.millMu.Lock()
+	defer r.millMu.Unlock()
+
+	if r.config.Compress {
+		if err := compressFile(backup); err == nil {
+			_ = os.Remove(backup)
+		}
+	}
+
+	backups := r.backups()
+	cutoff := now.Add(-r.config.MaxAge)
+	for i, b := range backups {
+		if (r.config.MaxBackups > 0 && i >= r.config.MaxBackups) ||
+			(r.config.MaxAge > 0 && b.rotated.Before(cutoff)) {
+			_ = os.Remove(b.path)
+		}
+	}
+}
+
+type backupFile struct {
+	path    string
+	rotated time.Time
+}
+
+// backups returns the rotated files, newest first.
+func (r *RotatingFile) backups() []backupFile {
+	dir, name := filepath.Split(r.path)
+	if dir == "" {
+		dir = "."
+	}
+	ext := filepath.Ext(name)
+	prefix := strings.TrimSuffix(name, ext) + "-"
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	var backups []backupFile
+	for _, e := range entries {
+		stamp := strings.TrimSuffix(e.Name(), ".gz")
+		if e.IsDir() || !strings.HasPrefix(stamp, prefix) || !strings.HasSuffix(stamp, ext) {
+			continue
+		}
+		stamp = strings.TrimSuffix(strings.TrimPrefix(stamp, prefix), ext)
+		t, err := time.Parse(backupTimeFormat, stamp)
+		if err != nil {
+			continue
+		}
+		backups = append(backups, backupFile{path: filepath.Join(dir, e.Name()), rotated: t})
+	}
+	sort.Slice(backups, func(i, j int) bool { return backups[i].rotated.After(backups[j].rotated) })
+	return backups
+}
+
+// compressFile writes a gzipped copy of path to path.gz.
+func compressFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	tmp := path + ".gz.tmp"
+	dst, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(dst)
+	_, err = io.Copy(zw, src)
+	if closeErr := zw.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path+".gz")
+}
+
+// Close closes the file and waits for background compression and pruning.
+func (r *RotatingFile) Close() error {
+	r.mu.Lock()
+	var err error
+	if r.file != nil {
+		err = r.file.Close()
+		r.file = nil
+	}
+	r.mu.Unlock()
+	r.wg.Wait()
+	return err
+}
@@ -0,0 +1,72 @@
+package logger
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRotatingFileBySize(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "server.log")
+	r, err := OpenRotatingFile(path, RotateConfig{MaxSize: 10, MaxBackups: 2})
+	if err != nil {
+		t.Fatalf("OpenRotatingFile failed: %v", err)
+	}
+
+	now := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+	r.now = func() time.Time { return now }
+	for i := 0; i < 4; i++ {
+		if _, err := r.Write([]byte("12345678\n")); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+		now = now.Add(time.Second)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	data, _ := os.ReadFile(path)
+	if string(data) != "12345678\n" {
+		t.Errorf("Expected one entry in the current file, got %q", data)
+	}
+	backups := r.backups()
+	if len(backups) != 2 {
+		t.Fatalf("Expected 2 backups kept, got %d", len(backups))
+	}
+	if want := filepath.Join(dir, "server-2024-03-10T12-00-03.000.log"); backups[0].path != want {
+		t.Errorf("Expected newest backup %s, got %s", want, backups[0].path)
+	}
+}
+
+func TestRotatingFileByIntervalCompressed(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "client.log")
+	r, err := OpenRotatingFile(path, RotateConfig{Interval: 24 * time.Hour, Compress: true})
+	if err != nil {
+		t.Fatalf("OpenRotatingFile failed: %v", err)
+	}
+
+	now := time.Date(2024, 3, 10, 23, 0, 0, 0, time.UTC)
+	r.now = func() time.Time { return now }
+	r.started = now
+	_, _ = r.Write([]byte("before midnight\n"))
+	now = now.Add(30 * time.Minute)
+	_, _ = r.Write([]byte("still the same day\n"))
+	now = now.Add(time.Hour)
+	_, _ = r.Write([]byte("next day\n"))
+	if err := r.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	backups := r.backups()
+	if len(backups) != 1 || !strings.HasSuffix(backups[0].path, ".log.gz") {
+		t.Fatalf("Expected one compressed backup, got %+v", backups)
+	}
+	data, _ := os.ReadFile(path)
+	if string(data) != "next day\n" {
+		t.Errorf("Expected only the new day's entry, got %q", data)
+	}
+}
@@ -0,0 +1,50 @@
+//go:build !windows && !plan9
+
+package logger
+
+import (
+	"log/syslog"
+
+	"github.com/rs/zerolog"
+)
+
+// syslogWriter sends log entries to the local syslog daemon with a priority
+// matching their level.
+type syslogWriter struct {
+	w *syslog.Writer
+}
+
+// newSyslogWriter connects to the local syslog daemon.
+func newSyslogWriter(tag string) (zerolog.LevelWriter, error) {
+	w, err := syslog.New(syslog.LOG_INFO|syslog.LOG_DAEMON, tag)
+	if err != nil {
+		return nil, err
+	}
+	return &syslogWriter{w: w}, nil
+}
+
+func (s *syslogWriter) Write(p []byte) (int, error) {
+	return s.w.Write(p)
+}
+
+func (s *syslogWriter) WriteLevel(level zerolog.Level, p []byte) (int, error) {
+	var err error
+	switch level {
+	case zerolog.TraceLevel, zerolog.DebugLevel:
+		err = s.w.Debug(string(p))
+	case zerolog.InfoLevel:
+		err = s.w.Info(string(p))
+	case zerolog.WarnLevel:
+		err = s.w.Warning(string(p))
+	case zerolog.ErrorLevel:
+		err = s.w.Err(string(p))
+	case zerolog.FatalLevel, zerolog.PanicLevel:
+		err = s.w.Crit(string(p))
+	default:
+		return s.w.Write(p)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
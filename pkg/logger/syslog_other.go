@@ -0,0 +1,14 @@
+//go:build windows || plan9
+
+package logger
+
+import (
+	"errors"
+
+	"github.com/rs/zerolog"
+)
+
+// newSyslogWriter fails: syslog is not available on this platform.
+func newSyslogWriter(tag string) (zerolog.LevelWriter, error) {
+	return nil, errors.New("syslog is not supported on this platform")
+}
@@ -12,6 +12,7 @@ import (
 	"time"
 
 	"github.com/sahmadiut/half-tunnel/internal/client"
+	"github.com/sahmadiut/half-tunnel/internal/diag"
 	"github.com/sahmadiut/half-tunnel/internal/server"
 	"golang.org/x/net/proxy"
 )
@@ -258,3 +259,330 @@ func TestEndToEndMultipleStreams(t *testing.T) {
 
 	t.Logf("Multiple streams test completed: %d connections tested", numConnections)
 }
+
+// TestEndToEndSinglePort tests upstream and downstream sharing one listener.
+func TestEndToEndSinglePort(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping e2e test in short mode")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	expectedResponse := "Hello over a single port!"
+	httpHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(expectedResponse))
+	})
+
+	httpListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to create HTTP listener: %v", err)
+	}
+	httpServer := &http.Server{Handler: httpHandler}
+	go func() {
+		_ = httpServer.Serve(httpListener)
+	}()
+	defer httpServer.Close()
+
+	httpAddr := httpListener.Addr().String()
+
+	// Start the Half-Tunnel server with both directions on one address
+	serverConfig := &server.Config{
+		UpstreamAddr:    "127.0.0.1:58080",
+		UpstreamPath:    "/upstream",
+		DownstreamAddr:  "127.0.0.1:58080",
+		DownstreamPath:  "/downstream",
+		SessionTimeout:  5 * time.Minute,
+		MaxSessions:     100,
+		ReadBufferSize:  32768,
+		WriteBufferSize: 32768,
+		MaxMessageSize:  65536,
+		DialTimeout:     10 * time.Second,
+	}
+
+	srv := server.New(serverConfig, nil)
+	if err := srv.Start(ctx); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer func() {
+		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer shutdownCancel()
+		_ = srv.Stop(shutdownCtx)
+	}()
+
+	time.Sleep(200 * time.Millisecond)
+
+	// Start the Half-Tunnel client with both legs on the same host:port
+	clientConfig := &client.Config{
+		UpstreamURL:      "ws://127.0.0.1:58080/upstream",
+		DownstreamURL:    "ws://127.0.0.1:58080/downstream",
+		SOCKS5Addr:       "127.0.0.1:51080",
+		SOCKS5Enabled:    true,
+		PingInterval:     30 * time.Second,
+		WriteTimeout:     10 * time.Second,
+		ReadTimeout:      60 * time.Second,
+		DialTimeout:      10 * time.Second,
+		HandshakeTimeout: 10 * time.Second,
+	}
+
+	cli := client.New(clientConfig, nil)
+	if err := cli.Start(ctx); err != nil {
+		t.Fatalf("Failed to start client: %v", err)
+	}
+	defer func() {
+		_ = cli.Stop()
+	}()
+
+	time.Sleep(500 * time.Millisecond)
+
+	dialer, err := proxy.SOCKS5("tcp", "127.0.0.1:51080", nil, proxy.Direct)
+	if err != nil {
+		t.Fatalf("Failed to create SOCKS5 dialer: %v", err)
+	}
+
+	httpClient := &http.Client{
+		Transport: &http.Transport{
+			Dial: dialer.Dial,
+		},
+		Timeout: 10 * time.Second,
+	}
+
+	resp, err := httpClient.Get("http://" + httpAddr + "/")
+	if err != nil {
+		t.Fatalf("HTTP request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("Failed to read response body: %v", err)
+	}
+
+	if string(body) != expectedResponse {
+		t.Errorf("Response mismatch: expected %q, got %q", expectedResponse, string(body))
+	}
+}
+
+// TestEndToEndBench runs the benchmark against the server's diagnostic
+// targets through the tunnel.
+func TestEndToEndBench(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping e2e test in short mode")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	serverConfig := &server.Config{
+		UpstreamAddr:    "127.0.0.1:28080",
+		UpstreamPath:    "/upstream",
+		DownstreamAddr:  "127.0.0.1:28081",
+		DownstreamPath:  "/downstream",
+		SessionTimeout:  5 * time.Minute,
+		MaxSessions:     100,
+		ReadBufferSize:  32768,
+		WriteBufferSize: 32768,
+		MaxMessageSize:  65536,
+		DialTimeout:     10 * time.Second,
+		Diagnostics:     true,
+	}
+
+	srv := server.New(serverConfig, nil)
+	if err := srv.Start(ctx); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer func() {
+		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer shutdownCancel()
+		_ = srv.Stop(shutdownCtx)
+	}()
+
+	time.Sleep(200 * time.Millisecond)
+
+	clientConfig := &client.Config{
+		UpstreamURL:      "ws://127.0.0.1:28080/upstream",
+		DownstreamURL:    "ws://127.0.0.1:28081/downstream",
+		SOCKS5Addr:       "127.0.0.1:21080",
+		SOCKS5Enabled:    true,
+		PingInterval:     30 * time.Second,
+		WriteTimeout:     10 * time.Second,
+		ReadTimeout:      60 * time.Second,
+		DialTimeout:      10 * time.Second,
+		HandshakeTimeout: 10 * time.Second,
+	}
+
+	cli := client.New(clientConfig, nil)
+	if err := cli.Start(ctx); err != nil {
+		t.Fatalf("Failed to start client: %v", err)
+	}
+	defer func() {
+		_ = cli.Stop()
+	}()
+
+	time.Sleep(500 * time.Millisecond)
+
+	dialer, err := proxy.SOCKS5("tcp", "127.0.0.1:21080", nil, proxy.Direct)
+	if err != nil {
+		t.Fatalf("Failed to create SOCKS5 dialer: %v", err)
+	}
+
+	opts := diag.DefaultBenchOptions()
+	opts.Duration = 500 * time.Millisecond
+	report, err := diag.Bench(ctx, dialer.(proxy.ContextDialer).DialContext, opts)
+	if err != nil {
+		t.Fatalf("Bench failed: %v", err)
+	}
+
+	if report.Latency.Samples != opts.Pings || report.Latency.Min <= 0 {
+		t.Errorf("Expected %d timed round trips, got %+v", opts.Pings, report.Latency)
+	}
+	if report.Upload.Bytes == 0 {
+		t.Error("Expected data uploaded to the discard target")
+	}
+	if report.Download.Bytes == 0 {
+		t.Error("Expected data downloaded from the chargen target")
+	}
+}
+
+// TestEndToEndEchoProbe tests the client's echo readiness check against a
+// server with diagnostic targets enabled.
+func TestEndToEndEchoProbe(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping e2e test in short mode")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	serverConfig := &server.Config{
+		UpstreamAddr:    "127.0.0.1:28090",
+		UpstreamPath:    "/upstream",
+		DownstreamAddr:  "127.0.0.1:28091",
+		DownstreamPath:  "/downstream",
+		SessionTimeout:  5 * time.Minute,
+		MaxSessions:     100,
+		ReadBufferSize:  32768,
+		WriteBufferSize: 32768,
+		MaxMessageSize:  65536,
+		DialTimeout:     10 * time.Second,
+		Diagnostics:     true,
+	}
+
+	srv := server.New(serverConfig, nil)
+	if err := srv.Start(ctx); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer func() {
+		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer shutdownCancel()
+		_ = srv.Stop(shutdownCtx)
+	}()
+
+	time.Sleep(200 * time.Millisecond)
+
+	clientConfig := &client.Config{
+		UpstreamURL:      "ws://127.0.0.1:28090/upstream",
+		DownstreamURL:    "ws://127.0.0.1:28091/downstream",
+		PingInterval:     30 * time.Second,
+		WriteTimeout:     10 * time.Second,
+		ReadTimeout:      60 * time.Second,
+		DialTimeout:      10 * time.Second,
+		HandshakeTimeout: 10 * time.Second,
+		EchoProbe:        true,
+	}
+
+	cli := client.New(clientConfig, nil)
+	if err := cli.Start(ctx); err != nil {
+		t.Fatalf("Failed to start client: %v", err)
+	}
+	defer func() {
+		_ = cli.Stop()
+	}()
+
+	time.Sleep(500 * time.Millisecond)
+
+	check, ok := cli.HealthChecks()["echo"]
+	if !ok {
+		t.Fatal("Expected an echo readiness check")
+	}
+	for i := 0; i < 3; i++ {
+		if err := check(ctx); err != nil {
+			t.Fatalf("Echo probe %d failed: %v", i, err)
+		}
+	}
+}
+
+// TestEndToEndSelfTest tests the client self-test against a working server
+// and reports of the stage that fails.
+func TestEndToEndSelfTest(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping e2e test in short mode")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	serverConfig := &server.Config{
+		UpstreamAddr:    "127.0.0.1:28100",
+		UpstreamPath:    "/upstream",
+		DownstreamAddr:  "127.0.0.1:28101",
+		DownstreamPath:  "/downstream",
+		SessionTimeout:  5 * time.Minute,
+		MaxSessions:     100,
+		ReadBufferSize:  32768,
+		WriteBufferSize: 32768,
+		MaxMessageSize:  65536,
+		DialTimeout:     10 * time.Second,
+		Diagnostics:     true,
+	}
+
+	srv := server.New(serverConfig, nil)
+	if err := srv.Start(ctx); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer func() {
+		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer shutdownCancel()
+		_ = srv.Stop(shutdownCtx)
+	}()
+
+	time.Sleep(200 * time.Millisecond)
+
+	tests := []struct {
+		name          string
+		upstreamURL   string
+		downstreamURL string
+		target        string
+		failedStage   string
+	}{
+		{"working", "ws://127.0.0.1:28100/upstream", "ws://localhost:28101/downstream", diag.EchoAddr, ""},
+		{"closed port", "ws://127.0.0.1:28109/upstream", "ws://127.0.0.1:28101/downstream", diag.EchoAddr, client.StageTCP},
+		{"wrong path", "ws://127.0.0.1:28100/upstream", "ws://127.0.0.1:28101/wrong", diag.EchoAddr, client.StageWebSocket},
+		{"unreachable target", "ws://127.0.0.1:28100/upstream", "ws://127.0.0.1:28101/downstream", "127.0.0.1:1", client.StageStream},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report := client.SelfTest(ctx, &client.Config{
+				UpstreamURL:      tt.upstreamURL,
+				DownstreamURL:    tt.downstreamURL,
+				DialTimeout:      5 * time.Second,
+				HandshakeTimeout: 5 * time.Second,
+			}, tt.target)
+
+			last := report.Results[len(report.Results)-1]
+			if tt.failedStage == "" {
+				if err := report.Err(); err != nil {
+					t.Fatalf("Expected the self-test to pass, got %v", err)
+				}
+				if last.Stage != client.StageStream {
+					t.Errorf("Expected the stream stage last, got %s", last.Stage)
+				}
+				return
+			}
+			if last.Err == nil || last.Stage != tt.failedStage {
+				t.Errorf("Expected %s to fail, got %s: %v", tt.failedStage, last.Stage, last.Err)
+			}
+		})
+	}
+}
@@ -0,0 +1,472 @@
+package e2e
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sahmadiut/half-tunnel/internal/client"
+	"github.com/sahmadiut/half-tunnel/internal/retry"
+	"github.com/sahmadiut/half-tunnel/internal/server"
+	"github.com/sahmadiut/half-tunnel/internal/transport"
+	"golang.org/x/net/proxy"
+)
+
+// harness runs a server, a client and an echo destination in-process. The
+// client has a SOCKS5 proxy and a port forward to the echo destination.
+type harness struct {
+	t   *testing.T
+	ctx context.Context
+
+	echoAddr    string
+	socksAddr   string
+	forwardAddr string
+
+	serverConfig *server.Config
+	server       *server.Server
+	client       *client.Client
+}
+
+// harnessOption adjusts the server and client configurations of a harness.
+type harnessOption func(*server.Config, *client.Config)
+
+// withFaults injects faults into the messages the server and the client
+// send (nil sends them faithfully).
+func withFaults(serverFaults, clientFaults *transport.FaultConfig) harnessOption {
+	return func(s *server.Config, c *client.Config) {
+		s.Faults = serverFaults
+		c.Faults = clientFaults
+	}
+}
+
+// newHarness starts the echo destination, the server and the client, and
+// waits until the client is connected. Everything is stopped when the test
+// ends.
+func newHarness(t *testing.T, opts ...harnessOption) *harness {
+	t.Helper()
+	if testing.Short() {
+		t.Skip("Skipping e2e test in short mode")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	t.Cleanup(cancel)
+	h := &harness{t: t, ctx: ctx}
+
+	h.echoAddr = startEcho(t)
+
+	h.serverConfig = server.DefaultConfig()
+	h.serverConfig.UpstreamAddr = freeAddr(t)
+	h.serverConfig.DownstreamAddr = freeAddr(t)
+
+	h.socksAddr = freeAddr(t)
+	h.forwardAddr = freeAddr(t)
+	echoHost, echoPort := splitAddr(t, h.echoAddr)
+	forwardHost, forwardPort := splitAddr(t, h.forwardAddr)
+
+	clientConfig := client.DefaultConfig()
+	clientConfig.UpstreamURL = "ws://" + h.serverConfig.UpstreamAddr + "/upstream"
+	clientConfig.DownstreamURL = "ws://" + h.serverConfig.DownstreamAddr + "/downstream"
+	clientConfig.SOCKS5Addr = h.socksAddr
+	clientConfig.PortForwards = []client.PortForward{{
+		Name:       "echo",
+		ListenHost: forwardHost,
+		ListenPort: forwardPort,
+		RemoteHost: echoHost,
+		RemotePort: echoPort,
+	}}
+	clientConfig.ReconnectConfig = &retry.Config{
+		InitialDelay: 100 * time.Millisecond,
+		MaxDelay:     500 * time.Millisecond,
+		Multiplier:   2.0,
+	}
+	for _, opt := range opts {
+		opt(h.serverConfig, clientConfig)
+	}
+
+	h.startServer()
+	h.client = client.New(clientConfig, nil)
+	if err := h.client.Start(ctx); err != nil {
+		t.Fatalf("Failed to start client: %v", err)
+	}
+	t.Cleanup(func() { _ = h.client.Stop() })
+
+	h.waitEcho(h.dialForward)
+	return h
+}
+
+// startServer starts a server on the harness addresses.
+func (h *harness) startServer() {
+	h.t.Helper()
+	h.server = server.New(h.serverConfig, nil)
+	if err := h.server.Start(h.ctx); err != nil {
+		h.t.Fatalf("Failed to start server: %v", err)
+	}
+	srv := h.server
+	h.t.Cleanup(func() { stopServer(srv) })
+}
+
+// restartServer stops the server and starts a new one on the same
+// addresses, dropping the client's connections.
+func (h *harness) restartServer() {
+	h.t.Helper()
+	stopServer(h.server)
+	h.startServer()
+}
+
+func stopServer(srv *server.Server) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_ = srv.Stop(ctx)
+}
+
+// dialSOCKS connects to target through the client's SOCKS5 proxy. It
+// returns the TCP connection to the proxy, which can be half-closed.
+func (h *harness) dialSOCKS(target string) (net.Conn, error) {
+	forward := &tcpDialer{}
+	dialer, err := proxy.SOCKS5("tcp", h.socksAddr, nil, forward)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := dialer.(proxy.ContextDialer).DialContext(h.ctx, "tcp", target); err != nil {
+		return nil, err
+	}
+	return forward.conn, nil
+}
+
+// tcpDialer dials directly and keeps the connection, so the SOCKS5 dialer's
+// wrapper can be bypassed once the handshake is done.
+type tcpDialer struct {
+	conn net.Conn
+}
+
+func (d *tcpDialer) Dial(network, addr string) (net.Conn, error) {
+	conn, err := net.DialTimeout(network, addr, 5*time.Second)
+	d.conn = conn
+	return conn, err
+}
+
+// dialForward connects to the client's port forward to the echo
+// destination.
+func (h *harness) dialForward() (net.Conn, error) {
+	return net.DialTimeout("tcp", h.forwardAddr, 5*time.Second)
+}
+
+// waitEcho waits until a connection from dial echoes data, e.g. while the
+// client connects or reconnects.
+func (h *harness) waitEcho(dial func() (net.Conn, error)) {
+	h.t.Helper()
+	deadline := time.Now().Add(15 * time.Second)
+	for {
+		err := echoOnce(dial, []byte("ping"))
+		if err == nil {
+			return
+		}
+		if time.Now().After(deadline) {
+			h.t.Fatalf("Tunnel not ready: %v", err)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// transfer sends size random bytes through the port forward, half-closes,
+// and checks the echo arrives intact before the echo destination's FIN.
+func (h *harness) transfer(size int) {
+	h.t.Helper()
+	data := make([]byte, size)
+	if _, err := rand.Read(data); err != nil {
+		h.t.Fatalf("Failed to generate data: %v", err)
+	}
+
+	conn, err := h.dialForward()
+	if err != nil {
+		h.t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(30 * time.Second))
+
+	// The server only queues a little data while it dials the destination,
+	// so wait for the stream to be established first
+	hello := make([]byte, 5)
+	if _, err := conn.Write([]byte("hello")); err != nil {
+		h.t.Fatalf("Write failed: %v", err)
+	}
+	if _, err := io.ReadFull(conn, hello); err != nil {
+		h.t.Fatalf("Read failed: %v", err)
+	}
+
+	writeErr := make(chan error, 1)
+	go func() {
+		_, err := conn.Write(data)
+		if err == nil {
+			err = conn.(*net.TCPConn).CloseWrite()
+		}
+		writeErr <- err
+	}()
+
+	got, err := io.ReadAll(conn)
+	if err != nil {
+		h.t.Fatalf("Read failed after %d bytes: %v", len(got), err)
+	}
+	if err := <-writeErr; err != nil {
+		h.t.Fatalf("Write failed: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		h.t.Errorf("Data corrupted: sent %d bytes, got %d", len(data), len(got))
+	}
+}
+
+// echoOnce sends data over a new connection and checks it comes back.
+func echoOnce(dial func() (net.Conn, error), data []byte) error {
+	conn, err := dial()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(2 * time.Second))
+
+	if _, err := conn.Write(data); err != nil {
+		return err
+	}
+	buf := make([]byte, len(data))
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return err
+	}
+	if !bytes.Equal(buf, data) {
+		return fmt.Errorf("expected %q, got %q", data, buf)
+	}
+	return nil
+}
+
+// startEcho starts a destination that echoes data until the peer
+// half-closes, then half-closes in turn.
+func startEcho(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to create echo listener: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func(c net.Conn) {
+				defer c.Close()
+				_, _ = io.Copy(c, c)
+				_ = c.(*net.TCPConn).CloseWrite()
+			}(conn)
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// freeAddr returns a loopback address with a port that was free.
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to find a free port: %v", err)
+	}
+	defer ln.Close()
+	return ln.Addr().String()
+}
+
+func splitAddr(t *testing.T, addr string) (string, int) {
+	t.Helper()
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		t.Fatalf("Invalid address %q: %v", addr, err)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		t.Fatalf("Invalid port in %q: %v", addr, err)
+	}
+	return host, port
+}
+
+// TestEndToEndSOCKS5AndPortForward tests data through the SOCKS5 proxy and
+// a port forward.
+func TestEndToEndSOCKS5AndPortForward(t *testing.T) {
+	h := newHarness(t)
+
+	socks := func() (net.Conn, error) { return h.dialSOCKS(h.echoAddr) }
+	if err := echoOnce(socks, []byte("through socks5")); err != nil {
+		t.Errorf("SOCKS5 echo failed: %v", err)
+	}
+	if err := echoOnce(h.dialForward, []byte("through the port forward")); err != nil {
+		t.Errorf("Port forward echo failed: %v", err)
+	}
+}
+
+// TestEndToEndDialContext tests connections opened by the client's
+// DialContext, directly and as the dialer of an HTTP client.
+func TestEndToEndDialContext(t *testing.T) {
+	h := newHarness(t)
+
+	dial := func() (net.Conn, error) { return h.client.DialContext(h.ctx, "tcp", h.echoAddr) }
+	if err := echoOnce(dial, []byte("through DialContext")); err != nil {
+		t.Errorf("DialContext echo failed: %v", err)
+	}
+
+	web := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "hello over the tunnel")
+	}))
+	defer web.Close()
+	httpClient := &http.Client{
+		Transport: &http.Transport{DialContext: h.client.DialContext},
+		Timeout:   5 * time.Second,
+	}
+	resp, err := httpClient.Get(web.URL)
+	if err != nil {
+		t.Fatalf("HTTP request through DialContext failed: %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("Failed to read response: %v", err)
+	}
+	if string(body) != "hello over the tunnel" {
+		t.Errorf("Expected %q, got %q", "hello over the tunnel", body)
+	}
+}
+
+// TestEndToEndConfirmedOpen tests that a stream to a destination the server
+// cannot connect fails to open, instead of opening and closing at once.
+func TestEndToEndConfirmedOpen(t *testing.T) {
+	h := newHarness(t)
+	closed := freeAddr(t)
+
+	_, err := h.dialSOCKS(closed)
+	if err == nil || !strings.Contains(err.Error(), "connection refused") {
+		t.Errorf("Expected the SOCKS5 reply to be connection refused, got %v", err)
+	}
+
+	if conn, err := h.client.DialContext(h.ctx, "tcp", closed); err == nil {
+		conn.Close()
+		t.Error("Expected DialContext to a closed port to fail")
+	}
+
+	// Streams to reachable destinations still open
+	socks := func() (net.Conn, error) { return h.dialSOCKS(h.echoAddr) }
+	if err := echoOnce(socks, []byte("after a refused stream")); err != nil {
+		t.Errorf("SOCKS5 echo failed: %v", err)
+	}
+}
+
+// TestEndToEndStreamHandler tests that streams to a destination with a
+// handler registered on the server are served in-process.
+func TestEndToEndStreamHandler(t *testing.T) {
+	h := newHarness(t)
+
+	err := h.server.Handle("internal.service:80", server.HTTPStreamHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		stream, _ := server.StreamInfoFromContext(r.Context())
+		_, _ = io.WriteString(w, "served for "+stream.DestAddr)
+	})))
+	if err != nil {
+		t.Fatalf("Handle failed: %v", err)
+	}
+
+	httpClient := &http.Client{
+		Transport: &http.Transport{DialContext: h.client.DialContext},
+		Timeout:   5 * time.Second,
+	}
+	resp, err := httpClient.Get("http://internal.service/")
+	if err != nil {
+		t.Fatalf("Request to the handled destination failed: %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("Failed to read response: %v", err)
+	}
+	if string(body) != "served for internal.service:80" {
+		t.Errorf("Expected %q, got %q", "served for internal.service:80", body)
+	}
+}
+
+// TestEndToEndHalfClose tests that a FIN from the application reaches the
+// destination while the response still comes back, followed by the
+// destination's FIN.
+func TestEndToEndHalfClose(t *testing.T) {
+	h := newHarness(t)
+
+	conn, err := h.dialSOCKS(h.echoAddr)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	if _, err := conn.Write([]byte("last words")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err := conn.(*net.TCPConn).CloseWrite(); err != nil {
+		t.Fatalf("CloseWrite failed: %v", err)
+	}
+
+	// The echo destination only replies with EOF once the FIN reached it
+	got, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatalf("Expected the echo then EOF, got %v", err)
+	}
+	if string(got) != "last words" {
+		t.Errorf("Expected %q, got %q", "last words", got)
+	}
+}
+
+// TestEndToEndLargeTransfer tests that a transfer much larger than a packet
+// arrives intact through the port forward.
+func TestEndToEndLargeTransfer(t *testing.T) {
+	h := newHarness(t)
+	h.transfer(16 << 20)
+}
+
+// TestEndToEndReconnect tests that the client reconnects to a restarted
+// server and carries new streams again.
+func TestEndToEndReconnect(t *testing.T) {
+	h := newHarness(t)
+
+	h.restartServer()
+
+	socks := func() (net.Conn, error) { return h.dialSOCKS(h.echoAddr) }
+	h.waitEcho(socks)
+	if err := echoOnce(h.dialForward, []byte("after reconnect")); err != nil {
+		t.Errorf("Port forward echo after reconnect failed: %v", err)
+	}
+}
+
+// TestEndToEndReorderedDownstream tests that data arrives intact when the
+// server's messages are delayed, reordered and duplicated.
+func TestEndToEndReorderedDownstream(t *testing.T) {
+	h := newHarness(t, withFaults(&transport.FaultConfig{
+		Seed:          1,
+		Jitter:        time.Millisecond,
+		DuplicateRate: 0.1,
+		ReorderRate:   0.1,
+	}, nil))
+	h.transfer(1 << 20)
+}
+
+// TestEndToEndInjectedDisconnects tests that the client keeps reconnecting
+// when its connections drop mid-stream.
+func TestEndToEndInjectedDisconnects(t *testing.T) {
+	h := newHarness(t, withFaults(nil, &transport.FaultConfig{DisconnectAfter: 20}))
+
+	// Every echo sends at least two messages upstream, so the connection
+	// drops several times
+	for i := 0; i < 40; i++ {
+		h.waitEcho(h.dialForward)
+	}
+}